@@ -0,0 +1,76 @@
+// Package alert sends notifications about problems such as fsck failures
+// or stalled aggregation, for installations without a monitoring stack.
+package alert
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Alert is a single notification.
+type Alert struct {
+	Subject string    `json:"subject"`
+	Message string    `json:"message"`
+	Root    string    `json:"root,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier delivers alerts to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Dispatcher sends alerts to all configured notifiers, suppressing repeats
+// of the same condition until the repeat interval has passed.
+type Dispatcher struct {
+	notifiers []Notifier
+	repeat    time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// NewDispatcher creates a Dispatcher. An alert with a given key is sent at
+// most once per repeat interval.
+func NewDispatcher(repeat time.Duration, notifiers ...Notifier) *Dispatcher {
+	return &Dispatcher{
+		notifiers: notifiers,
+		repeat:    repeat,
+		last:      make(map[string]time.Time),
+	}
+}
+
+// Fire sends a to every notifier unless an alert with the same key was sent
+// within the repeat interval. key identifies the condition, e.g.
+// "fsck:/srv/mirror". Errors from all notifiers are joined.
+func (d *Dispatcher) Fire(ctx context.Context, key string, a Alert) error {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	d.mu.Lock()
+	if last, ok := d.last[key]; ok && a.Time.Sub(last) < d.repeat {
+		d.mu.Unlock()
+		return nil
+	}
+	d.last[key] = a.Time
+	d.mu.Unlock()
+
+	var errs []error
+	for _, n := range d.notifiers {
+		if err := n.Notify(ctx, a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Resolve forgets that the condition identified by key has fired, so the
+// next occurrence is reported immediately.
+func (d *Dispatcher) Resolve(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.last, key)
+}
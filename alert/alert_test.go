@@ -0,0 +1,139 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memNotifier struct {
+	alerts []Alert
+	err    error
+}
+
+func (m *memNotifier) Notify(ctx context.Context, a Alert) error {
+	m.alerts = append(m.alerts, a)
+	return m.err
+}
+
+func TestDispatcherRepeat(t *testing.T) {
+	n := &memNotifier{}
+	d := NewDispatcher(time.Hour, n)
+	ctx := context.Background()
+	now := time.Now()
+
+	d.Fire(ctx, "fsck", Alert{Subject: "one", Time: now})
+	d.Fire(ctx, "fsck", Alert{Subject: "two", Time: now.Add(time.Minute)})
+	d.Fire(ctx, "lag", Alert{Subject: "three", Time: now.Add(time.Minute)})
+	d.Fire(ctx, "fsck", Alert{Subject: "four", Time: now.Add(2 * time.Hour)})
+
+	d.Resolve("lag")
+	d.Fire(ctx, "lag", Alert{Subject: "five", Time: now.Add(2 * time.Minute)})
+
+	var got []string
+	for _, a := range n.alerts {
+		got = append(got, a.Subject)
+	}
+	if strings.Join(got, ",") != "one,three,four,five" {
+		t.Errorf("sent %v", got)
+	}
+}
+
+func TestDispatcherErrors(t *testing.T) {
+	failing := &memNotifier{err: errors.New("boom")}
+	ok := &memNotifier{}
+	d := NewDispatcher(time.Hour, failing, ok)
+
+	if err := d.Fire(context.Background(), "k", Alert{Subject: "s"}); err == nil {
+		t.Error("Expected error from failing notifier")
+	}
+	if len(ok.alerts) != 1 {
+		t.Error("Other notifiers should still be called")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+
+	a := Alert{Subject: "fsck failed", Message: "3 issues", Root: "/srv"}
+
+	wh, _ := NewWebhook(srv.URL)
+	if err := wh.Notify(context.Background(), a); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	slack, _ := NewSlack(srv.URL)
+	if err := slack.Notify(context.Background(), a); err != nil {
+		t.Fatalf("Notify (slack) failed: %v", err)
+	}
+
+	if bodies[0]["subject"] != "fsck failed" || bodies[0]["root"] != "/srv" {
+		t.Errorf("generic body = %v", bodies[0])
+	}
+	if bodies[1]["text"] != "*fsck failed*\n3 issues" {
+		t.Errorf("slack body = %v", bodies[1])
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	wh, _ := NewWebhook(srv.URL)
+	if err := wh.Notify(context.Background(), Alert{}); err == nil {
+		t.Error("Expected error for 403 response")
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	var gotAddr string
+	var gotTo []string
+	var gotMsg string
+	orig := sendMail
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotTo, gotMsg = addr, to, string(msg)
+		return nil
+	}
+	defer func() { sendMail = orig }()
+
+	s, err := NewSMTP("mail.example.com:25", "rrr@example.com", []string{"ops@example.com"}, "", "")
+	if err != nil {
+		t.Fatalf("NewSMTP failed: %v", err)
+	}
+
+	err = s.Notify(context.Background(), Alert{
+		Subject: "aggregation\nlag",
+		Message: "no aggregation\nfor 2h",
+		Time:    time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if gotAddr != "mail.example.com:25" || len(gotTo) != 1 {
+		t.Errorf("sent to %s %v", gotAddr, gotTo)
+	}
+	if !strings.Contains(gotMsg, "Subject: [rrr] aggregation lag\r\n") {
+		t.Errorf("subject not on one line:\n%s", gotMsg)
+	}
+	if !strings.Contains(gotMsg, "\r\n\r\nno aggregation\r\nfor 2h") {
+		t.Errorf("unexpected body:\n%s", gotMsg)
+	}
+
+	if _, err := NewSMTP("mail.example.com", "a@b", []string{"c@d"}, "", ""); err == nil {
+		t.Error("Expected error for address without port")
+	}
+}
@@ -0,0 +1,130 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Webhook posts alerts as JSON to an HTTP endpoint. In Slack mode the body
+// is a Slack incoming-webhook message; otherwise it is the Alert itself.
+type Webhook struct {
+	url    string
+	slack  bool
+	client *http.Client
+}
+
+// NewWebhook posts alerts as JSON to url.
+func NewWebhook(url string) (*Webhook, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook url cannot be empty")
+	}
+	return &Webhook{url: url, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// NewSlack posts alerts to a Slack incoming webhook url.
+func NewSlack(url string) (*Webhook, error) {
+	wh, err := NewWebhook(url)
+	if err != nil {
+		return nil, err
+	}
+	wh.slack = true
+	return wh, nil
+}
+
+// Notify posts the alert.
+func (wh *Webhook) Notify(ctx context.Context, a Alert) error {
+	var payload any = a
+	if wh.slack {
+		payload = map[string]string{"text": "*" + a.Subject + "*\n" + a.Message}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post alert: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post alert: %s", resp.Status)
+	}
+	return nil
+}
+
+// sendMail delivers mail; replaced in tests.
+var sendMail = smtp.SendMail
+
+// SMTP mails alerts.
+type SMTP struct {
+	addr string
+	from string
+	to   []string
+	auth smtp.Auth
+}
+
+// NewSMTP mails alerts through the server at addr (host:port). If username
+// is set, PLAIN authentication is used.
+func NewSMTP(addr, from string, to []string, username, password string) (*SMTP, error) {
+	if addr == "" || from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("smtp needs a server address, sender and recipients")
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp address: %w", err)
+	}
+
+	s := &SMTP{addr: addr, from: from, to: to}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s, nil
+}
+
+// Notify sends the alert as a plain text mail.
+func (s *SMTP) Notify(ctx context.Context, a Alert) error {
+	if err := sendMail(s.addr, s.auth, s.from, s.to, s.message(a)); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+// message formats the alert as an RFC 5322 message.
+func (s *SMTP) message(a Alert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&b, "Subject: [rrr] %s\r\n", oneLine(a.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(a.Message, "\n", "\r\n"))
+	if a.Root != "" {
+		fmt.Fprintf(&b, "\r\n\r\nRoot: %s", a.Root)
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// oneLine keeps header values from spanning lines.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,214 @@
+// Package api serves a read-only HTTP API over RECENT hierarchies, so
+// monitoring and downstream tooling can query them without parsing the
+// recentfiles themselves.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/abh/rrrgo/index"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+// DefaultLimit is the number of events returned by /events when no limit
+// is given.
+const DefaultLimit = 10000
+
+// Server routes API requests to the hierarchies added to it.
+type Server struct {
+	mux *http.ServeMux
+	log *slog.Logger
+}
+
+// New creates an empty Server.
+func New(log *slog.Logger) *Server {
+	return &Server{mux: http.NewServeMux(), log: log}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Add serves rec below prefix ("" for the root, e.g. "authors" for
+// /authors/recent/1h). idx, if not nil, answers /state lookups.
+func (s *Server) Add(prefix string, rec *recent.Recent, idx *index.DB) {
+	h := &hierarchy{rec: rec, index: idx, log: s.log}
+
+	base := "/"
+	if prefix != "" && prefix != "." {
+		base = "/" + path.Clean(prefix) + "/"
+	}
+
+	s.mux.HandleFunc("GET "+base+"recent/{interval}", h.recentfile)
+	s.mux.HandleFunc("GET "+base+"events", h.events)
+	s.mux.HandleFunc("GET "+base+"state/{path...}", h.state)
+	s.mux.HandleFunc("GET "+base+"stream", h.stream)
+}
+
+type hierarchy struct {
+	rec   *recent.Recent
+	index *index.DB
+	log   *slog.Logger
+}
+
+// EventsResponse is returned by /events.
+type EventsResponse struct {
+	Events []recentfile.Event `json:"events"`
+	// Next is set when the limit was reached; request again with since=Next.
+	Next *recentfile.Epoch `json:"next,omitempty"`
+}
+
+// StateResponse is returned by /state.
+type StateResponse struct {
+	recentfile.Event
+	Exists bool `json:"exists"`
+}
+
+// recentfile returns one recentfile as JSON.
+func (h *hierarchy) recentfile(w http.ResponseWriter, r *http.Request) {
+	rf := h.rec.RecentfileByInterval(r.PathValue("interval"))
+	if rf == nil {
+		httpError(w, http.StatusNotFound, "no such interval")
+		return
+	}
+
+	stored, err := recentfile.NewFromFile(rf.Rfile())
+	if errors.Is(err, os.ErrNotExist) {
+		httpError(w, http.StatusNotFound, "recentfile not written yet")
+		return
+	}
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+
+	writeJSON(w, recentfile.SerializedData{
+		Meta:   stored.Meta(),
+		Recent: stored.RecentEvents(),
+	})
+}
+
+// events returns the latest event for every path changed after since,
+// oldest first.
+func (h *hierarchy) events(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseFloat(r.URL.Query().Get("since"), 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "since must be an epoch")
+		return
+	}
+
+	limit := DefaultLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			httpError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+
+	events, err := rsynclist.Changes(h.rec, recentfile.EpochFromFloat(since))
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return recentfile.EpochLt(events[i].Epoch, events[j].Epoch)
+	})
+
+	resp := EventsResponse{Events: events}
+	if len(events) > limit {
+		resp.Events = events[:limit]
+		next := events[limit-1].Epoch
+		resp.Next = &next
+	}
+	if resp.Events == nil {
+		resp.Events = []recentfile.Event{}
+	}
+
+	writeJSON(w, resp)
+}
+
+// state returns the latest event recorded for a path.
+func (h *hierarchy) state(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("path")
+	if p == "" || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		httpError(w, http.StatusBadRequest, "invalid path")
+		return
+	}
+
+	event, found, err := h.lookup(p)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	if !found {
+		httpError(w, http.StatusNotFound, "path not in index")
+		return
+	}
+
+	_, statErr := os.Lstat(filepath.Join(h.rec.LocalRoot(), filepath.FromSlash(p)))
+	writeJSON(w, StateResponse{Event: event, Exists: statErr == nil})
+}
+
+// lookup finds the latest event for p in the index database, or else by
+// reading the recentfiles from the smallest interval up.
+func (h *hierarchy) lookup(p string) (recentfile.Event, bool, error) {
+	if h.index != nil {
+		return h.index.Get(p)
+	}
+
+	for _, rf := range h.rec.Recentfiles() {
+		var event recentfile.Event
+		found := false
+
+		_, err := recentfile.StreamEvents(rf.Rfile(), 1000, func(events []recentfile.Event) bool {
+			for _, e := range events {
+				if e.Path == p {
+					event, found = e, true
+					return false
+				}
+			}
+			return true
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return recentfile.Event{}, false, fmt.Errorf("read %s: %w", rf.Interval(), err)
+		}
+		if found {
+			return event, true, nil
+		}
+	}
+
+	return recentfile.Event{}, false, nil
+}
+
+func (h *hierarchy) fail(w http.ResponseWriter, err error) {
+	h.log.Error("api request failed", "error", err)
+	httpError(w, http.StatusInternalServerError, "internal error")
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
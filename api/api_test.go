@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/index"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func newTestRecent(t *testing.T) *recent.Recent {
+	t.Helper()
+	root := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(root),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d", "Z"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rec.Update(path, "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Remove(filepath.Join(root, "c.txt"))
+	if err := rec.Update(filepath.Join(root, "c.txt"), "delete"); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func newTestServer(t *testing.T, prefix string, rec *recent.Recent, idx *index.DB) *httptest.Server {
+	t.Helper()
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.Add(prefix, rec, idx)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string, wantCode int, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantCode {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s = %d (%s), want %d", url, resp.StatusCode, body, wantCode)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decode %s: %v", url, err)
+		}
+	}
+}
+
+func TestRecentfile(t *testing.T) {
+	rec := newTestRecent(t)
+	srv := newTestServer(t, "", rec, nil)
+
+	var data recentfile.SerializedData
+	get(t, srv.URL+"/recent/1h", http.StatusOK, &data)
+	if data.Meta.Interval != "1h" {
+		t.Errorf("interval = %q", data.Meta.Interval)
+	}
+	if len(data.Recent) != 3 {
+		t.Errorf("got %d events, want 3", len(data.Recent))
+	}
+
+	get(t, srv.URL+"/recent/1W", http.StatusNotFound, nil)
+}
+
+func TestEvents(t *testing.T) {
+	rec := newTestRecent(t)
+	srv := newTestServer(t, "authors", rec, nil)
+
+	var resp EventsResponse
+	get(t, srv.URL+"/authors/events?since=0", http.StatusOK, &resp)
+	if len(resp.Events) != 3 || resp.Next != nil {
+		t.Fatalf("events = %+v", resp)
+	}
+	for i := 1; i < len(resp.Events); i++ {
+		if !recentfile.EpochLt(resp.Events[i-1].Epoch, resp.Events[i].Epoch) {
+			t.Errorf("events not sorted oldest first: %+v", resp.Events)
+		}
+	}
+	if last := resp.Events[2]; last.Path != "c.txt" || last.Type != "delete" {
+		t.Errorf("last event = %+v", last)
+	}
+
+	// Paging with limit and next
+	var page EventsResponse
+	get(t, srv.URL+"/authors/events?since=0&limit=2", http.StatusOK, &page)
+	if len(page.Events) != 2 || page.Next == nil || *page.Next != page.Events[1].Epoch {
+		t.Fatalf("first page = %+v", page)
+	}
+	var rest EventsResponse
+	get(t, srv.URL+"/authors/events?since="+page.Next.String()+"&limit=2", http.StatusOK, &rest)
+	if len(rest.Events) != 1 || rest.Events[0].Path != "c.txt" || rest.Next != nil {
+		t.Errorf("second page = %+v", rest)
+	}
+
+	get(t, srv.URL+"/authors/events", http.StatusBadRequest, nil)
+	get(t, srv.URL+"/authors/events?since=0&limit=0", http.StatusBadRequest, nil)
+	get(t, srv.URL+"/events?since=0", http.StatusNotFound, nil)
+}
+
+func TestState(t *testing.T) {
+	rec := newTestRecent(t)
+
+	db, err := index.Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Rebuild(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, idx := range map[string]*index.DB{"recentfiles": nil, "index": db} {
+		t.Run(name, func(t *testing.T) {
+			srv := newTestServer(t, "", rec, idx)
+
+			var state StateResponse
+			get(t, srv.URL+"/state/a.txt", http.StatusOK, &state)
+			if state.Path != "a.txt" || state.Type != "new" || !state.Exists {
+				t.Errorf("a.txt = %+v", state)
+			}
+
+			state = StateResponse{}
+			get(t, srv.URL+"/state/c.txt", http.StatusOK, &state)
+			if state.Type != "delete" || state.Exists {
+				t.Errorf("c.txt = %+v", state)
+			}
+
+			get(t, srv.URL+"/state/missing.txt", http.StatusNotFound, nil)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+// KeepaliveInterval is how often an idle /stream connection gets a
+// comment line, so proxies don't time it out.
+var KeepaliveInterval = 30 * time.Second
+
+// Batch is the data of one "batch" event on /stream.
+type Batch struct {
+	Events []recentfile.Event `json:"events"`
+}
+
+// stream sends every batch committed to the principal recentfile as a
+// Server-Sent Event. The event id is the epoch of the newest event in the
+// batch; a client reconnecting with Last-Event-ID (or ?since=EPOCH) first
+// gets the changes it missed as one batch.
+//
+// Batches are dropped for clients that can't keep up, as with any
+// Subscribe consumer; such clients should reconnect and catch up.
+func (h *hierarchy) stream(w http.ResponseWriter, r *http.Request) {
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	var sinceEpoch recentfile.Epoch
+	if since != "" {
+		f, err := strconv.ParseFloat(since, 64)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "since must be an epoch")
+			return
+		}
+		sinceEpoch = recentfile.EpochFromFloat(f)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	// Subscribe before catching up so nothing committed in between is lost
+	batches, cancel := h.rec.Subscribe(r.Context())
+	defer cancel()
+
+	var missed []recentfile.Event
+	if since != "" {
+		var err error
+		missed, err = rsynclist.Changes(h.rec, sinceEpoch)
+		if err != nil {
+			h.fail(w, err)
+			return
+		}
+		sort.Slice(missed, func(i, j int) bool {
+			return recentfile.EpochLt(missed[i].Epoch, missed[j].Epoch)
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+
+	var last recentfile.Epoch
+	if len(missed) > 0 {
+		if err := writeBatch(w, missed); err != nil {
+			return
+		}
+		last = missed[len(missed)-1].Epoch
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(KeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				return
+			}
+			// Skip events already sent while catching up
+			n := 0
+			for _, e := range batch {
+				if recentfile.EpochGt(e.Epoch, last) {
+					batch[n] = e
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			if err := writeBatch(w, batch[:n]); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeBatch writes events as one "batch" event.
+func writeBatch(w http.ResponseWriter, events []recentfile.Event) error {
+	data, err := json.Marshal(Batch{Events: events})
+	if err != nil {
+		return err
+	}
+
+	newest := events[0].Epoch
+	for _, e := range events[1:] {
+		if recentfile.EpochGt(e.Epoch, newest) {
+			newest = e.Epoch
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: batch\ndata: %s\n\n", newest, data)
+	return err
+}
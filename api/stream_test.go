@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvents parses Server-Sent Events from resp onto a channel.
+func readEvents(resp *http.Response) <-chan sseEvent {
+	ch := make(chan sseEvent)
+	go func() {
+		defer close(ch)
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if ev.event != "" {
+					ch <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return ch
+}
+
+func nextBatch(t *testing.T, events <-chan sseEvent) (string, []recentfile.Event) {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		if ev.event != "batch" {
+			t.Fatalf("event = %q, want batch", ev.event)
+		}
+		var b Batch
+		if err := json.Unmarshal([]byte(ev.data), &b); err != nil {
+			t.Fatalf("decode batch: %v", err)
+		}
+		return ev.id, b.Events
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for batch")
+	}
+	return "", nil
+}
+
+func openStream(t *testing.T, url, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %d", url, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	return readEvents(resp)
+}
+
+func TestStream(t *testing.T) {
+	rec := newTestRecent(t)
+	srv := newTestServer(t, "", rec, nil)
+
+	// Catch up on everything, then follow new batches
+	events := openStream(t, srv.URL+"/stream?since=0", "")
+	id, batch := nextBatch(t, events)
+	if len(batch) != 3 {
+		t.Fatalf("catch-up batch has %d events, want 3", len(batch))
+	}
+
+	path := filepath.Join(rec.LocalRoot(), "d.txt")
+	os.WriteFile(path, []byte("d"), 0o644)
+	if err := rec.Update(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+	newID, batch := nextBatch(t, events)
+	if len(batch) != 1 || batch[0].Path != "d.txt" {
+		t.Errorf("batch = %+v", batch)
+	}
+	if newID == id {
+		t.Errorf("id did not advance: %s", newID)
+	}
+
+	// Reconnecting with the last id only replays what came after it
+	resumed := openStream(t, srv.URL+"/stream", id)
+	_, batch = nextBatch(t, resumed)
+	if len(batch) != 1 || batch[0].Path != "d.txt" {
+		t.Errorf("resumed batch = %+v", batch)
+	}
+}
+
+func TestStreamBadSince(t *testing.T) {
+	srv := newTestServer(t, "", newTestRecent(t), nil)
+	get(t, srv.URL+"/stream?since=yesterday", http.StatusBadRequest, nil)
+}
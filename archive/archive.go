@@ -0,0 +1,239 @@
+// Package archive rotates old events out of the Z recentfile into
+// compressed, dated segments. Z stays small while the complete history
+// remains available for audits and rebuilds.
+//
+// A segment is a gzip-compressed file with one JSON event per line, newest
+// first, like the recentfiles themselves. index.json in the archive
+// directory lists the segments in the order they were written.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// IndexFile is the name of the segment index in an archive directory.
+const IndexFile = "index.json"
+
+// Segment describes one archive file.
+type Segment struct {
+	File   string           `json:"file"`   // Name relative to the archive directory
+	From   recentfile.Epoch `json:"from"`   // Oldest event
+	To     recentfile.Epoch `json:"to"`     // Newest event
+	Events int              `json:"events"` // Number of events
+	SHA256 string           `json:"sha256"` // Checksum of the compressed file
+}
+
+// Index lists the segments in an archive directory.
+type Index struct {
+	Segments []Segment `json:"segments"`
+}
+
+// ReadIndex reads the segment index in dir. A missing index is an empty archive.
+func ReadIndex(dir string) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Index{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", IndexFile, err)
+	}
+	return &idx, nil
+}
+
+// Rotate moves events older than maxAge from the Z recentfile of rec into a
+// new segment in dir. It returns the new segment, or nil if nothing was old
+// enough to rotate.
+func Rotate(rec *recent.Recent, dir string, maxAge time.Duration) (*Segment, error) {
+	z := rec.RecentfileByInterval("Z")
+	if z == nil {
+		return nil, fmt.Errorf("hierarchy has no Z recentfile")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+
+	cutoff := recentfile.EpochFromTime(time.Now().Add(-maxAge))
+
+	var segment *Segment
+	_, err := z.Expire(cutoff, func(events []recentfile.Event) error {
+		var err error
+		segment, err = writeSegment(dir, events)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// writeSegment writes events (newest first) to a new segment file and adds
+// it to the index. The index is only updated once the segment is on disk.
+func writeSegment(dir string, events []recentfile.Event) (*Segment, error) {
+	idx, err := ReadIndex(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+
+	segment := Segment{
+		From:   events[len(events)-1].Epoch,
+		To:     events[0].Epoch,
+		Events: len(events),
+	}
+	segment.File = fmt.Sprintf("Z-%s-%s.ndjson.gz", epochStamp(segment.From), epochStamp(segment.To))
+
+	path := filepath.Join(dir, segment.File)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("segment %s already exists", segment.File)
+	}
+
+	sum, err := writeAtomic(path, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		enc := json.NewEncoder(gz)
+		for _, event := range events {
+			if err := enc.Encode(event); err != nil {
+				return err
+			}
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write %s: %w", segment.File, err)
+	}
+	segment.SHA256 = sum
+
+	idx.Segments = append(idx.Segments, segment)
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	_, err = writeAtomic(filepath.Join(dir, IndexFile), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write index: %w", err)
+	}
+
+	return &segment, nil
+}
+
+// StreamEvents calls fn with the events of every segment in dir, oldest
+// segment first. Return false from fn to stop.
+func StreamEvents(dir string, batchSize int, fn recentfile.StreamEventCallback) error {
+	idx, err := ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range idx.Segments {
+		more, err := streamSegment(filepath.Join(dir, segment.File), batchSize, fn)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", segment.File, err)
+		}
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// streamSegment reads one segment file in batches. It reports whether fn
+// wants more events.
+func streamSegment(path string, batchSize int, fn recentfile.StreamEventCallback) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	defer gz.Close()
+
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	batch := make([]recentfile.Event, 0, batchSize)
+	for {
+		var event recentfile.Event
+		err := dec.Decode(&event)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+
+		batch = append(batch, event)
+		if len(batch) == batchSize {
+			if !fn(batch) {
+				return false, nil
+			}
+			batch = make([]recentfile.Event, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		return fn(batch), nil
+	}
+	return true, nil
+}
+
+// writeAtomic writes a file through a temporary name and returns the
+// SHA-256 of its content.
+func writeAtomic(path string, write func(w io.Writer) error) (string, error) {
+	tmp := path + ".new"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if err := write(io.MultiWriter(f, h)); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// epochStamp formats an epoch for segment file names.
+func epochStamp(e recentfile.Epoch) string {
+	sec := int64(recentfile.EpochToFloat(e))
+	return time.Unix(sec, 0).UTC().Format("20060102T150405Z")
+}
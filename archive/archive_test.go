@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func setupTestRecent(t *testing.T) (*recent.Recent, *recentfile.Recentfile) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"Z"}),
+	)
+
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+	z := rec.RecentfileByInterval("Z")
+	z.SetRecentEvents([]recentfile.Event{
+		{Epoch: recentfile.EpochFromTime(now.Add(-1 * day)), Path: "fresh.txt", Type: "new"},
+		{Epoch: recentfile.EpochFromTime(now.Add(-40 * day)), Path: "old.txt", Type: "new"},
+		{Epoch: recentfile.EpochFromTime(now.Add(-50 * day)), Path: "older.txt", Type: "delete"},
+	})
+	if err := z.Write(); err != nil {
+		t.Fatalf("write Z: %v", err)
+	}
+
+	return rec, z
+}
+
+func TestRotate(t *testing.T) {
+	rec, z := setupTestRecent(t)
+	dir := filepath.Join(t.TempDir(), "archive")
+
+	segment, err := Rotate(rec, dir, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if segment == nil || segment.Events != 2 {
+		t.Fatalf("Expected segment with 2 events, got %+v", segment)
+	}
+	if recentfile.EpochGe(segment.From, segment.To) {
+		t.Errorf("segment range %v..%v", segment.From, segment.To)
+	}
+
+	// Z only keeps the fresh event, on disk as well
+	if err := z.Read(); err != nil {
+		t.Fatalf("read Z: %v", err)
+	}
+	events := z.RecentEvents()
+	if len(events) != 1 || events[0].Path != "fresh.txt" {
+		t.Errorf("Z events = %+v", events)
+	}
+	if mm := z.Meta().Minmax; mm == nil || mm.Min != events[0].Epoch {
+		t.Errorf("Z minmax not updated: %+v", mm)
+	}
+
+	idx, err := ReadIndex(dir)
+	if err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+	if len(idx.Segments) != 1 || idx.Segments[0] != *segment {
+		t.Errorf("index = %+v", idx)
+	}
+
+	var archived []recentfile.Event
+	err = StreamEvents(dir, 1, func(events []recentfile.Event) bool {
+		archived = append(archived, events...)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("StreamEvents failed: %v", err)
+	}
+	if len(archived) != 2 || archived[0].Path != "old.txt" || archived[1].Path != "older.txt" {
+		t.Errorf("archived = %+v", archived)
+	}
+
+	// Nothing left to rotate
+	segment, err = Rotate(rec, dir, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("second Rotate failed: %v", err)
+	}
+	if segment != nil {
+		t.Errorf("Expected no segment, got %+v", segment)
+	}
+}
+
+func TestRotateWithoutZ(t *testing.T) {
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(t.TempDir()),
+		recentfile.WithInterval("1h"),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	if _, err := Rotate(rec, t.TempDir(), time.Hour); err == nil {
+		t.Error("Expected error for hierarchy without Z")
+	}
+}
@@ -0,0 +1,60 @@
+package flags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLConfig reads a config file, for kong.Configuration: a YAML mapping
+// of flag names (with dashes or underscores) to values, e.g.
+//
+//	local_root: /srv/cpan
+//	hierarchy: [authors:1h, modules:1h]
+//	batch_delay: 2s
+//	ignore: [.git, "*.tmp"]
+//
+// Every command reads the settings it has flags for and ignores the
+// others, so one file can serve them all.
+func YAMLConfig(r io.Reader) (kong.Resolver, error) {
+	values := map[string]any{}
+	if err := yaml.NewDecoder(r).Decode(&values); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	// kong looks flags up with underscores
+	normalized := make(map[string]any, len(values))
+	for key, value := range values {
+		normalized[strings.ReplaceAll(key, "-", "_")] = value
+	}
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	return kong.JSON(bytes.NewReader(data))
+}
+
+// ConfigLocalRoot returns the local_root setting of the config file at
+// path, for when the local root is not given on the command line.
+func ConfigLocalRoot(path string) (string, error) {
+	data, err := os.ReadFile(kong.ExpandPath(path))
+	if err != nil {
+		return "", fmt.Errorf("read config: %w", err)
+	}
+	values := map[string]any{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return "", fmt.Errorf("parse config: %w", err)
+	}
+	for _, key := range []string{"local_root", "local-root"} {
+		if root, ok := values[key].(string); ok {
+			return root, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,282 @@
+// Package flags holds the command line flags and the config file loading
+// shared by the rrr commands, so every command spells a setting the same
+// way and reads it from the same config file.
+package flags
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/abh/rrrgo/objstore"
+	"github.com/abh/rrrgo/pathfilter"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// compressionSuffixes maps --compress values to RECENT file suffixes.
+var compressionSuffixes = map[string]string{
+	"gzip": recentfile.GzipSuffix,
+	"zstd": recentfile.ZstdSuffix,
+}
+
+// Layout are the flags naming and laying out the RECENT files of the
+// hierarchies below a local root.
+type Layout struct {
+	Filenameroot string `default:"RECENT" help:"Name root of the RECENT files, e.g. MYRECENT for MYRECENT-1h.yaml; lets several hierarchies share a directory."`
+	Comment      string `help:"Comment to record in the metadata of the RECENT files."`
+	IndexDir     string `help:"Write the RECENT files to this directory outside the local root instead of into it, e.g. for a read-only tree; event paths stay relative to the local root." type:"path"`
+
+	Interval   string   `short:"i" default:"1h" help:"Principal recentfile interval (e.g., 1h, 30m)."`
+	Aggregator []string `short:"a" help:"Aggregator intervals (e.g., 6h,1d,1W). Can be specified multiple times."`
+	Format     string   `short:"f" default:"yaml" enum:"yaml,yml,json,sereal" help:"Serialization format (yaml, json or sereal)."`
+
+	PerlYAML       bool   `name:"perl-yaml" help:"Write YAML RECENT files exactly like the Perl implementation (sorted keys, epochs as quoted strings), for hierarchies shared with Perl tools."`
+	Compress       string `default:"none" enum:"none,gzip,zstd" help:"Compress RECENT files (none, gzip or zstd); files get an extra .gz or .zst suffix."`
+	EncryptKeyfile string `type:"path" env:"RRR_KEYFILE" help:"Encrypt RECENT files with the AES-256 key in this file (32 raw bytes or 64 hex characters); files get an extra .enc suffix."`
+
+	Cpan      bool     `help:"Maintain the standard CPAN authors/ and modules/ hierarchies below the local root (ignores --interval, --aggregator and --format)."`
+	Hierarchy []string `sep:"none" placeholder:"DIR[:INTERVAL[:AGGREGATOR]]" help:"Maintain a hierarchy in this directory below the local root, e.g. authors:1h:6h,1d,Z, instead of one at the root; repeatable. The interval and aggregator default to --interval and --aggregator."`
+}
+
+// Layouts returns the hierarchies the flags describe, with the suffixes
+// of compressed and encrypted files in their formats, after checking
+// their intervals. With an encryption key file it loads the key, for
+// reading and writing the files.
+func (l *Layout) Layouts() ([]recent.Layout, error) {
+	layouts := []recent.Layout{{
+		Dir:        ".",
+		Interval:   l.Interval,
+		Aggregator: l.Aggregator,
+		Format:     l.Format,
+	}}
+	switch {
+	case l.Cpan && len(l.Hierarchy) > 0:
+		return nil, fmt.Errorf("--hierarchy cannot be used with --cpan")
+	case l.Cpan:
+		layouts = recent.CPANLayout()
+	case len(l.Hierarchy) > 0:
+		layouts = nil
+		for _, spec := range l.Hierarchy {
+			layout, err := recent.ParseLayout(spec)
+			if err != nil {
+				return nil, err
+			}
+			if layout.Interval == "" {
+				layout.Interval = l.Interval
+			}
+			if layout.Aggregator == nil {
+				layout.Aggregator = l.Aggregator
+			}
+			layout.Format = l.Format
+			layouts = append(layouts, layout)
+		}
+		if err := recent.CheckLayouts(layouts); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, layout := range layouts {
+		if err := recentfile.CheckAggregator(layout.Interval, layout.Aggregator); err != nil {
+			return nil, fmt.Errorf("hierarchy %s: %w", layout.Dir, err)
+		}
+	}
+
+	if compression := compressionSuffixes[l.Compress]; compression != "" {
+		for i := range layouts {
+			layouts[i].Format += compression
+		}
+	}
+	if l.EncryptKeyfile != "" {
+		if err := recentfile.LoadKeyFile(l.EncryptKeyfile); err != nil {
+			return nil, err
+		}
+		for i := range layouts {
+			layouts[i].Format += recentfile.EncryptedSuffix
+		}
+	}
+	return layouts, nil
+}
+
+// Lock are the flags for locking RECENT files.
+type Lock struct {
+	LockBackend string `default:"mkdir" enum:"mkdir,flock" help:"How to lock RECENT files: mkdir (a lock directory, compatible with the Perl tools) or flock, which the kernel releases if the process dies. All writers of a hierarchy must use the same."`
+	BreakLocks  bool   `help:"Break RECENT file locks held by processes on other hosts (on shared filesystems) instead of waiting for them; only use when those hosts are known to be down."`
+}
+
+// Apply makes rec lock its files as the flags say.
+func (l *Lock) Apply(rec *recent.Recent) {
+	rec.SetBreakLocks(l.BreakLocks)
+	rec.SetLockBackend(l.LockBackend)
+}
+
+// Perms are the flags for the permissions of the RECENT files written.
+type Perms struct {
+	FileMode string `placeholder:"MODE" help:"Give the RECENT files and signatures written this mode, in octal (e.g. 0644), regardless of the umask, so rsyncd can export them; by default they are 0644 less the umask."`
+	DirMode  string `placeholder:"MODE" help:"Give lock directories this mode, in octal (e.g. 0755)."`
+	Owner    string `placeholder:"USER" help:"Give the RECENT files, lock directories and RECENT.recent symlink written this owner, a user name or ID; needs root."`
+	Group    string `placeholder:"GROUP" help:"Give them this group, a group name or ID."`
+}
+
+// Check checks the modes and looks up the owner and group of the flags.
+func (p *Perms) Check() error {
+	_, err := p.Permissions()
+	return err
+}
+
+// Permissions returns the permissions the flags describe.
+func (p *Perms) Permissions() (recentfile.Permissions, error) {
+	perm := recentfile.Permissions{UID: -1, GID: -1}
+	var err error
+	if perm.FileMode, err = parseMode(p.FileMode); err != nil {
+		return perm, fmt.Errorf("--file-mode: %w", err)
+	}
+	if perm.DirMode, err = parseMode(p.DirMode); err != nil {
+		return perm, fmt.Errorf("--dir-mode: %w", err)
+	}
+	if p.Owner != "" {
+		u, err := user.Lookup(p.Owner)
+		if err != nil {
+			if u, err = user.LookupId(p.Owner); err != nil {
+				return perm, fmt.Errorf("--owner: %w", err)
+			}
+		}
+		if perm.UID, err = strconv.Atoi(u.Uid); err != nil {
+			return perm, fmt.Errorf("--owner: %s has no numeric ID", p.Owner)
+		}
+		perm.Chown = true
+	}
+	if p.Group != "" {
+		g, err := user.LookupGroup(p.Group)
+		if err != nil {
+			if g, err = user.LookupGroupId(p.Group); err != nil {
+				return perm, fmt.Errorf("--group: %w", err)
+			}
+		}
+		if perm.GID, err = strconv.Atoi(g.Gid); err != nil {
+			return perm, fmt.Errorf("--group: %s has no numeric ID", p.Group)
+		}
+		perm.Chown = true
+	}
+	return perm, nil
+}
+
+// parseMode parses an octal permission mode; an empty one is 0.
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode == 0 || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal mode from 1 to 0777", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Apply makes rec write its files with the permissions of the flags,
+// which must have been checked with Check.
+func (p *Perms) Apply(rec *recent.Recent) {
+	perm, _ := p.Permissions()
+	rec.SetPermissions(perm)
+}
+
+// Options returns the recentfile options for creating RECENT files with
+// the permissions of the flags, which must have been checked with Check.
+func (p *Perms) Options() []recentfile.Option {
+	perm, _ := p.Permissions()
+	return []recentfile.Option{recentfile.WithPermissions(perm)}
+}
+
+// Write are the flags for what goes into the RECENT files written.
+type Write struct {
+	Retention      bool `default:"true" negatable:"" help:"Keep events in each recentfile for its full interval after they are merged (--no-retention drops them at the merge)."`
+	EventMtime     bool `help:"Set the mtime of each RECENT file to its newest event, which Perl clients use as a freshness hint."`
+	PreserveEpochs bool `help:"Write epochs read from existing RECENT files back in their original decimal form, keeping the full precision of files from Perl mirrors."`
+	ProtocolExt    bool `help:"Record the size, SHA-256, mode and owner of new files in their events (a protocol extension; files over 64 MiB get no SHA-256), so clients can verify downloads and keep permissions."`
+
+	DropDeletes   []string       `placeholder:"INTERVAL" help:"Keep delete events out of the recentfiles of these aggregator intervals, e.g. Z; the deleted paths are dropped from them instead. Repeatable or comma-separated."`
+	MaxEvents     map[string]int `mapsep:"," placeholder:"INTERVAL=N" help:"Cap the events of the recentfile of an interval, e.g. 1h=10000: a principal over its cap is aggregated at once, and merged events beyond it are dropped."`
+	Generations   int            `placeholder:"N" help:"Keep the previous N versions of each RECENT file on every rewrite, as RECENT-1h.yaml.1 (newest) to .N, for rolling back a botched aggregation or repair."`
+	DurableWrites bool           `help:"Sync every RECENT file written to disk, with its directory, so a power loss leaves the old or the new version rather than an empty file."`
+}
+
+// Check checks the intervals of the flags.
+func (w *Write) Check() error {
+	for _, interval := range w.DropDeletes {
+		if _, err := recentfile.ParseInterval(interval); err != nil {
+			return fmt.Errorf("--drop-deletes: %w", err)
+		}
+	}
+	if w.Generations < 0 {
+		return fmt.Errorf("--generations: want 0 or more, not %d", w.Generations)
+	}
+	for interval, limit := range w.MaxEvents {
+		if _, err := recentfile.ParseInterval(interval); err != nil {
+			return fmt.Errorf("--max-events: %w", err)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("--max-events: %s=%d: want a positive number of events", interval, limit)
+		}
+	}
+	return nil
+}
+
+// Apply makes rec write its files as the flags say.
+func (w *Write) Apply(rec *recent.Recent) {
+	rec.SetRetention(w.Retention)
+	rec.SetEventMtime(w.EventMtime)
+	rec.SetPreserveEpochs(w.PreserveEpochs)
+	rec.SetProtocolExt(w.ProtocolExt)
+	rec.SetDropDeletes(w.DropDeletes)
+	rec.SetMaxEvents(w.MaxEvents)
+	rec.SetGenerations(w.Generations)
+	rec.SetDurableWrites(w.DurableWrites)
+}
+
+// Sign are the flags for signing RECENT files.
+type Sign struct {
+	SignKeyfile  string `type:"path" env:"RRR_SIGN_KEYFILE" help:"Sign every RECENT file written with the minisign secret key in this file; signatures are written next to them, e.g. RECENT-1h.yaml.minisig. Files rewritten without it lose their signatures."`
+	SignPassword string `env:"RRR_SIGN_PASSWORD" help:"Password of an encrypted --sign-keyfile."`
+}
+
+// Load loads the signing key, if one is given.
+func (s *Sign) Load() error {
+	if s.SignKeyfile == "" {
+		return nil
+	}
+	return recentfile.LoadSigningKeyFile(s.SignKeyfile, s.SignPassword)
+}
+
+// Filter are the flags selecting the paths a command looks at.
+type Filter struct {
+	Ignore  []string `sep:"none" aliases:"exclude" placeholder:"PATTERN" help:"Leave out paths matching this glob (or \"re:\" regexp); repeatable."`
+	Include []string `sep:"none" placeholder:"PATTERN" help:"Only look at files matching this glob (or \"re:\" regexp); repeatable."`
+}
+
+// New returns the filter the flags describe.
+func (f *Filter) New() (*pathfilter.Filter, error) {
+	return pathfilter.New(f.Ignore, f.Include)
+}
+
+// S3 are the flags for reaching an S3 bucket.
+type S3 struct {
+	S3Endpoint     string `name:"s3-endpoint" help:"S3-compatible endpoint, e.g. http://localhost:9000; AWS S3 when empty."`
+	S3Region       string `name:"s3-region" default:"us-east-1" env:"AWS_REGION" help:"Region of the S3 bucket."`
+	S3AccessKey    string `name:"s3-access-key" env:"AWS_ACCESS_KEY_ID" help:"Access key for the S3 bucket; requests are unsigned without one."`
+	S3SecretKey    string `name:"s3-secret-key" env:"AWS_SECRET_ACCESS_KEY" help:"Secret key for the S3 bucket."`
+	S3SessionToken string `name:"s3-session-token" env:"AWS_SESSION_TOKEN" help:"Session token for the S3 bucket with temporary credentials."`
+}
+
+// Options returns the objstore options for the bucket.
+func (s *S3) Options() []objstore.Option {
+	return []objstore.Option{
+		objstore.WithEndpoint(s.S3Endpoint),
+		objstore.WithRegion(s.S3Region),
+		objstore.WithCredentials(objstore.Credentials{
+			AccessKey:    s.S3AccessKey,
+			SecretKey:    s.S3SecretKey,
+			SessionToken: s.S3SessionToken,
+		}),
+	}
+}
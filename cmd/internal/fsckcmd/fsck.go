@@ -0,0 +1,266 @@
+// Package fsckcmd is the rrr-fsck command, also run as rrr fsck.
+package fsckcmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Exit codes, as those of e2fsck, so scripts can tell the outcomes apart.
+// Command line errors exit with 80, as kong's parse errors do.
+const (
+	ExitClean    = 0  // No issues found
+	ExitRepaired = 1  // Issues found and repaired
+	ExitIssues   = 4  // Issues found and left, without --repair or beyond it
+	ExitError    = 8  // The check could not be run
+	ExitUsage    = 80 // Bad flag values
+)
+
+// CLI defines the command-line interface for rrr-fsck and rrr fsck.
+type CLI struct {
+	PrincipalFile string          `arg:"" help:"Path to principal RECENT file (e.g., RECENT-1h.yaml)." type:"path"`
+	LocalRoot     string          `help:"Tree the RECENT files index, if they are kept outside it (rrr-server --index-dir); defaults to the principal's directory." type:"path"`
+	Config        kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, e.g. that of rrr-server, for the same --lock-backend, --sign-keyfile and patterns." type:"path"`
+
+	Repair          bool          `short:"r" help:"Repair issues found (otherwise just report)."`
+	RepairOnly      []string      `placeholder:"REPAIR" help:"Make only these repairs (implies --repair): files, corrupt, symlink, index-orphans, missing-events, future-epochs, order, epochs."`
+	NoRepair        []string      `placeholder:"REPAIR" help:"Make every repair but these (implies --repair)."`
+	SkipEvents      bool          `help:"Skip parsing events (faster, less thorough)."`
+	Sample          int           `default:"1000" help:"Indexed files to check for on disk; which ones differs from run to run."`
+	Full            bool          `help:"Check every indexed file for on disk, not a sample."`
+	Concurrency     int           `default:"8" help:"Directories to read at once when scanning the tree."`
+	Incremental     bool          `help:"Keep the result of the tree scan between runs, and only read directories changed since the last one."`
+	StateFile       string        `help:"Where --incremental keeps the scan; defaults to a file per local root in the user cache directory." type:"path"`
+	VerifyChecksums string        `placeholder:"MANIFEST" help:"Verify file contents against the digests in this manifest (sha256sum, md5sum, sha1sum or sha512sum output, paths relative to the local root)." type:"path"`
+	ChecksumSample  float64       `default:"1" help:"With --verify-checksums, verify this share of the listed files, picked at random (e.g. 0.05)."`
+	ChecksumLimit   int           `help:"With --verify-checksums, verify at most this many files, picked at random."`
+	MaxClockSkew    time.Duration `default:"5m" help:"Report events dated further than this ahead of the local clock."`
+	ArchiveDir      string        `help:"Archive of events rotated out of Z; its paths count as indexed." type:"path"`
+	flags.Filter
+	Verbose bool `short:"v" help:"Enable verbose logging."`
+
+	flags.Lock
+	flags.Perms
+	flags.Sign
+	BumpDirtymark bool `help:"Instead of checking, set the dirtymark of every RECENT file to now, forcing downstream mirrors into a full re-sync."`
+	ProtocolExt   bool `help:"Keep the sizes, checksums, modes and owners in events of RECENT files rewritten by repairs, and record them for files added, as rrr-server --protocol-ext does; without it they are dropped."`
+	Generations   int  `placeholder:"N" help:"Keep the previous N versions of each RECENT file rewritten by repairs, as RECENT-1h.yaml.1 (newest) to .N, as rrr-server --generations does."`
+	DurableWrites bool `help:"Sync the RECENT files rewritten by repairs to disk, as rrr-server --durable-writes does."`
+}
+
+// Run checks (and repairs) the hierarchy as cli says and returns the exit
+// code for the outcome, with an error to report unless it is clean or
+// repaired.
+func Run(cli *CLI) (int, error) {
+	// Resolve absolute path
+	principalPath, err := filepath.Abs(cli.PrincipalFile)
+	if err != nil {
+		return ExitError, fmt.Errorf("resolve principal path: %w", err)
+	}
+
+	// Check file exists
+	if _, err := os.Stat(principalPath); err != nil {
+		return ExitError, fmt.Errorf("principal file not found: %w", err)
+	}
+
+	// Create logger for CLI output
+	logLevel := slog.LevelInfo
+	if cli.Verbose {
+		logLevel = slog.LevelDebug
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
+
+	if cli.Verbose {
+		fmt.Printf("Checking RECENT collection: %s\n", principalPath)
+	}
+
+	if err := cli.Sign.Load(); err != nil {
+		return ExitError, err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return ExitError, err
+	}
+
+	localRoot := filepath.Dir(principalPath)
+	if cli.LocalRoot != "" {
+		if localRoot, err = filepath.Abs(cli.LocalRoot); err != nil {
+			return ExitError, fmt.Errorf("resolve local root: %w", err)
+		}
+	}
+
+	var repairs []string
+	if len(cli.RepairOnly) > 0 || len(cli.NoRepair) > 0 {
+		if repairs, err = fsck.SelectRepairs(cli.RepairOnly, cli.NoRepair); err != nil {
+			return ExitUsage, err
+		}
+		cli.Repair = true
+	}
+
+	// Load Recent collection (metadata only, not all events)
+	rec, err := recent.NewWithLocalRoot(principalPath, localRoot)
+	if errors.Is(err, recentfile.ErrCorrupt) && cli.Repair && (repairs == nil || slices.Contains(repairs, fsck.RepairCorrupt)) {
+		// The corrupt repair rebuilds the principal from what can be read
+		var dropped int
+		if rec, dropped, err = recent.NewTolerant(principalPath, localRoot); err == nil {
+			logger.Warn("principal damaged, loaded what could be read", "path", principalPath, "dropped", dropped)
+		}
+	}
+	if err != nil {
+		return ExitError, fmt.Errorf("load recent: %w", err)
+	}
+
+	cli.Lock.Apply(rec)
+	cli.Perms.Apply(rec)
+	rec.SetProtocolExt(cli.ProtocolExt)
+	rec.SetGenerations(cli.Generations)
+	rec.SetDurableWrites(cli.DurableWrites)
+
+	if cli.Verbose {
+		fmt.Printf("Loaded: %s\n", rec.String())
+	}
+
+	if cli.BumpDirtymark {
+		dirtymark := recentfile.EpochNow()
+		if err := rec.SetDirtymark(dirtymark); err != nil {
+			return ExitError, fmt.Errorf("bump dirtymark: %w", err)
+		}
+		fmt.Printf("Dirtymark set to %s in %d RECENT files\n", dirtymark, len(rec.Recentfiles()))
+		return ExitClean, nil
+	}
+
+	filter, err := cli.Filter.New()
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	var stateFile string
+	if cli.Incremental {
+		stateFile = cli.StateFile
+		if stateFile == "" {
+			if stateFile, err = defaultStateFile(localRoot); err != nil {
+				return ExitError, err
+			}
+		}
+	}
+
+	// Run fsck
+	opts := fsck.Options{
+		Repair:           cli.Repair,
+		Repairs:          repairs,
+		SkipEvents:       cli.SkipEvents,
+		Sample:           cli.Sample,
+		Full:             cli.Full,
+		Concurrency:      cli.Concurrency,
+		StateFile:        stateFile,
+		ChecksumManifest: cli.VerifyChecksums,
+		ChecksumSample:   cli.ChecksumSample,
+		ChecksumLimit:    cli.ChecksumLimit,
+		MaxClockSkew:     cli.MaxClockSkew,
+		Verbose:          cli.Verbose,
+		ArchiveDir:       cli.ArchiveDir,
+		Filter:           filter,
+		Logger:           logger,
+	}
+	result, err := fsck.Run(rec, opts)
+	if err != nil {
+		return ExitError, fmt.Errorf("fsck failed: %w", err)
+	}
+
+	// Print summary
+	fmt.Println("\n=== Summary ===")
+	stats := rec.Stats()
+	fmt.Printf("Intervals: %d\n", stats.Intervals)
+	fmt.Printf("Total events: %d\n", stats.TotalEvents)
+
+	fmt.Println("\nPer-interval statistics:")
+	for interval, fs := range stats.Files {
+		fmt.Printf("  %s: %d events, %d bytes", interval, fs.Events, fs.Size)
+		if fs.Mtime > 0 {
+			fmt.Printf(", modified: %d", fs.Mtime)
+		}
+		fmt.Println()
+	}
+
+	// Report issues
+	fmt.Printf("\nIssues found: %d\n", result.Issues)
+
+	if result.Issues > 0 {
+		if cli.Repair {
+			if result.Repaired {
+				fmt.Println("✓ Repair complete")
+				if repairs != nil {
+					fmt.Printf("Repairs made: %s\n", strings.Join(repairs, ", "))
+				}
+				if result.EpochsQuantized > 0 || result.EpochsDeduplicated > 0 {
+					fmt.Println("\nEpoch repairs:")
+					if result.EpochsQuantized > 0 {
+						fmt.Printf("  • Quantized %d epochs to 10µs precision\n", result.EpochsQuantized)
+					}
+					if result.EpochsDeduplicated > 0 {
+						fmt.Printf("  • Fixed %d epoch collisions\n", result.EpochsDeduplicated)
+					}
+				}
+			} else {
+				return ExitError, fmt.Errorf("repair was requested but not completed")
+			}
+
+			// Not every issue has a repair, or was selected for one
+			fmt.Println("\nChecking again after repair")
+			opts.Repair = false
+			after, err := fsck.Run(rec, opts)
+			if err != nil {
+				return ExitError, fmt.Errorf("fsck failed: %w", err)
+			}
+			if n := after.IssuesFound["checksums"]; n > 0 {
+				return ExitIssues, fmt.Errorf("%d files do not match their checksums; fetch them again", n)
+			}
+			if after.Issues > 0 {
+				return ExitIssues, fmt.Errorf("%d issues left after repair", after.Issues)
+			}
+			fmt.Println("✓ No issues left")
+		} else {
+			fmt.Println("\nTo fix issues:")
+			fmt.Println("  • Files on disk but not in index: --repair will add them to the index")
+			fmt.Println("  • Files in index but not on disk:")
+			fmt.Println("      - If syncing from remote: run 'rsync -av REMOTE/ LOCAL/' first")
+			fmt.Println("      - If disk is authoritative: --repair will mark them as deleted")
+			if result.IssuesFound["checksums"] > 0 {
+				fmt.Println("  • Files not matching their checksums: fetch them again, e.g. 'rsync -av --checksum REMOTE/ LOCAL/'")
+			}
+			return ExitIssues, fmt.Errorf("found %d issues", result.Issues)
+		}
+		return ExitRepaired, nil
+	}
+
+	fmt.Println("✓ No issues found")
+	return ExitClean, nil
+}
+
+// defaultStateFile returns where --incremental keeps the scan of the tree
+// at localRoot, named after a hash of its path.
+func defaultStateFile(localRoot string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("state file: %w", err)
+	}
+	sum := sha256.Sum256([]byte(localRoot))
+	return filepath.Join(dir, "rrr-fsck", hex.EncodeToString(sum[:8])+".json.gz"), nil
+}
@@ -0,0 +1,295 @@
+package fsckcmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func setupTestRecent(t *testing.T) (*recent.Recent, string) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1d"}),
+	)
+
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	// Create files
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatalf("EnsureFilesExist failed: %v", err)
+	}
+
+	return rec, tmpDir
+}
+
+func TestRunHealthy(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+
+	// Add some events and create the files
+	for _, fname := range []string{"file1.txt", "file2.txt"} {
+		testFile := filepath.Join(tmpDir, fname)
+		if err := os.WriteFile(testFile, []byte("test"), 0o644); err != nil {
+			t.Fatalf("create file: %v", err)
+		}
+		if err := rec.Update(fname, "new"); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+
+	// Run fsck
+	cli := &CLI{
+		PrincipalFile: principalPath,
+		Verbose:       true,
+	}
+
+	if code, err := Run(cli); err != nil || code != ExitClean {
+		t.Errorf("run = %d, %v, want %d", code, err, ExitClean)
+	}
+}
+
+func TestRunMissingFileNoRepair(t *testing.T) {
+	_, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+
+	// Delete a file
+	aggregatedPath := filepath.Join(tmpDir, "RECENT-6h.yaml")
+	if err := os.Remove(aggregatedPath); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+
+	// Run fsck without repair (should return error)
+	cli := &CLI{
+		PrincipalFile: principalPath,
+		Repair:        false,
+		Verbose:       false,
+	}
+
+	code, err := Run(cli)
+	// Should return an error about issues found
+	if err == nil {
+		t.Error("expected error when issues found without repair")
+	}
+	if code != ExitIssues {
+		t.Errorf("exit code = %d, want %d", code, ExitIssues)
+	}
+}
+
+func TestRunWithRepair(t *testing.T) {
+	_, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+
+	// Delete a file
+	aggregatedPath := filepath.Join(tmpDir, "RECENT-6h.yaml")
+	if err := os.Remove(aggregatedPath); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+
+	// Run fsck with repair
+	cli := &CLI{
+		PrincipalFile: principalPath,
+		Repair:        true,
+		Verbose:       true,
+	}
+
+	if code, err := Run(cli); err != nil || code != ExitRepaired {
+		t.Errorf("run = %d, %v, want %d", code, err, ExitRepaired)
+	}
+
+	// Check file was recreated
+	if _, err := os.Stat(aggregatedPath); err != nil {
+		t.Errorf("file not recreated: %v", err)
+	}
+}
+
+func TestRunBrokenSymlink(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+
+	// Create a broken symlink (pointing to non-existent target)
+	symlinkPath := filepath.Join(tmpDir, "broken-link.txt")
+	targetPath := filepath.Join(tmpDir, "nonexistent-target.txt")
+	if err := os.Symlink(targetPath, symlinkPath); err != nil {
+		t.Fatalf("create symlink: %v", err)
+	}
+
+	// Add event for the symlink
+	if err := rec.Update("broken-link.txt", "new"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	// Run fsck - should not count broken symlink as an error
+	cli := &CLI{
+		PrincipalFile: principalPath,
+		Verbose:       true,
+	}
+
+	if code, err := Run(cli); err != nil || code != ExitClean {
+		t.Errorf("run = %d, %v (broken symlinks should not cause failures)", code, err)
+	}
+}
+
+func TestRunBumpDirtymark(t *testing.T) {
+	_, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+
+	// A missing file would fail the check; bumping doesn't check
+	if err := os.Remove(filepath.Join(tmpDir, "RECENT-6h.yaml")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+
+	before := recentfile.EpochNow()
+	cli := &CLI{
+		PrincipalFile: principalPath,
+		BumpDirtymark: true,
+	}
+	if _, err := Run(cli); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	var dirtymark recentfile.Epoch
+	for _, interval := range []string{"1h", "6h", "1d"} {
+		rf, err := recentfile.NewFromFile(filepath.Join(tmpDir, "RECENT-"+interval+".yaml"))
+		if err != nil {
+			t.Fatalf("NewFromFile(%s): %v", interval, err)
+		}
+		got := rf.Meta().Dirtymark
+		if recentfile.EpochLt(got, before) || (!dirtymark.IsZero() && got != dirtymark) {
+			t.Errorf("%s dirtymark = %v (bumped at %v)", interval, got, before)
+		}
+		dirtymark = got
+	}
+}
+
+func TestRunLocalRoot(t *testing.T) {
+	root := t.TempDir()
+	indexDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(root),
+		recentfile.WithIndexDir(indexDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatalf("EnsureFilesExist failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file1.txt"), []byte("test"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Update(filepath.Join(root, "file1.txt"), "new"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	principalPath := filepath.Join(indexDir, "RECENT-1h.yaml")
+	if _, err := Run(&CLI{PrincipalFile: principalPath, LocalRoot: root}); err != nil {
+		t.Errorf("run with --local-root failed: %v", err)
+	}
+
+	// Without it, the indexed file is looked for next to the RECENT files
+	if _, err := Run(&CLI{PrincipalFile: principalPath}); err == nil {
+		t.Error("run without --local-root found no issues")
+	}
+}
+
+func TestRunRepairOnly(t *testing.T) {
+	_, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+	aggregatedPath := filepath.Join(tmpDir, "RECENT-6h.yaml")
+	if err := os.Remove(aggregatedPath); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+
+	if code, err := Run(&CLI{PrincipalFile: principalPath, RepairOnly: []string{"bogus"}}); err == nil || code != ExitUsage {
+		t.Errorf("run with unknown repair = %d, %v", code, err)
+	}
+
+	// Only the epochs repair: the missing file stays missing
+	if code, err := Run(&CLI{PrincipalFile: principalPath, RepairOnly: []string{"epochs"}}); code != ExitIssues {
+		t.Errorf("run = %d, %v, want %d", code, err, ExitIssues)
+	}
+	if _, err := os.Stat(aggregatedPath); !os.IsNotExist(err) {
+		t.Errorf("file recreated by --repair-only=epochs: %v", err)
+	}
+
+	if code, err := Run(&CLI{PrincipalFile: principalPath, NoRepair: []string{"epochs"}}); err != nil || code != ExitRepaired {
+		t.Errorf("run = %d, %v, want %d", code, err, ExitRepaired)
+	}
+	if _, err := os.Stat(aggregatedPath); err != nil {
+		t.Errorf("file not recreated: %v", err)
+	}
+}
+
+func TestRunCorruptPrincipal(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+	for _, fname := range []string{"file1.txt", "file2.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, fname), []byte("test"), 0o644); err != nil {
+			t.Fatalf("create file: %v", err)
+		}
+		if err := rec.Update(fname, "new"); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	data, err := os.ReadFile(principalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbled := strings.Replace(string(data), "path: file1.txt", "path: [file1.txt", 1)
+	if err := os.WriteFile(principalPath, []byte(garbled), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the corrupt repair the principal can't be loaded
+	if code, err := Run(&CLI{PrincipalFile: principalPath, NoRepair: []string{"corrupt"}}); err == nil || code != ExitError {
+		t.Errorf("run without the corrupt repair = %d, %v, want %d", code, err, ExitError)
+	}
+
+	// The lost event is added back by the index-orphans repair
+	if code, err := Run(&CLI{PrincipalFile: principalPath, Repair: true}); err != nil || code != ExitRepaired {
+		t.Errorf("run = %d, %v, want %d", code, err, ExitRepaired)
+	}
+	if code, err := Run(&CLI{PrincipalFile: principalPath}); err != nil || code != ExitClean {
+		t.Errorf("run after repair = %d, %v, want %d", code, err, ExitClean)
+	}
+}
+
+func TestRunIncremental(t *testing.T) {
+	_, tmpDir := setupTestRecent(t)
+	stateFile := filepath.Join(t.TempDir(), "state.json.gz")
+
+	cli := &CLI{
+		PrincipalFile: filepath.Join(tmpDir, "RECENT-1h.yaml"),
+		Incremental:   true,
+		StateFile:     stateFile,
+	}
+	for range 2 {
+		if _, err := Run(cli); err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Errorf("state file not written: %v", err)
+	}
+}
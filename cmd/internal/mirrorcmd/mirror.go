@@ -0,0 +1,138 @@
+// Package mirrorcmd is the rrr-mirror command, also run as rrr mirror.
+package mirrorcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/mirror"
+	"github.com/abh/rrrgo/objstore"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// CLI defines the command-line interface for rrr-mirror and rrr mirror.
+type CLI struct {
+	Remote    string `arg:"" help:"Remote tree: rsync module (host::module/dir, rsync://host/module), http(s) URL or S3 bucket (s3://bucket/prefix)."`
+	LocalRoot string `arg:"" help:"Local directory to keep in sync." type:"existingdir"`
+
+	Loop         time.Duration `help:"Repeat every this long; run once when 0."`
+	RsyncOption  []string      `sep:"none" help:"Extra rsync option (e.g., --port=8730). Can be specified multiple times."`
+	BatchSize    int           `default:"1000" help:"Maximum files per fetch."`
+	Filenameroot string        `default:"RECENT" help:"Name root of the remote RECENT files."`
+	VerifyKey    []string      `sep:"none" placeholder:"KEY" help:"Only apply changes from RECENT files signed with this minisign public key, given as a key file or the base64 key; repeatable, e.g. while the key is rotated."`
+	Permissions  bool          `help:"Give fetched files the mode, and when run as root the owner and group, recorded in their events by rrr-server --protocol-ext."`
+	Verbose      bool          `short:"v" help:"Enable verbose logging."`
+
+	// For an s3:// remote
+	flags.S3
+}
+
+// Run mirrors the remote tree as cli says, once or until the process is
+// told to stop.
+func Run(cli *CLI) error {
+	logLevel := slog.LevelInfo
+	if cli.Verbose {
+		logLevel = slog.LevelDebug
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+	keys, err := loadVerifyKeys(cli.VerifyKey)
+	if err != nil {
+		return err
+	}
+	recentfile.SetVerifyKeys(keys...)
+
+	fetcher, err := newFetcher(cli.Remote, cli.RsyncOption, cli.S3.Options()...)
+	if err != nil {
+		return err
+	}
+
+	m := mirror.New(fetcher, cli.LocalRoot,
+		mirror.WithFilenameRoot(cli.Filenameroot),
+		mirror.WithBatchSize(cli.BatchSize),
+		mirror.WithPermissions(cli.Permissions),
+		mirror.WithLogger(log),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		start := time.Now()
+		stats, err := m.Run(ctx)
+		if err != nil {
+			if cli.Loop == 0 || ctx.Err() != nil {
+				return err
+			}
+			log.Error("mirror run failed", "error", err)
+		} else {
+			log.Info("mirror run complete",
+				"full", stats.Full,
+				"fetched", stats.Fetched,
+				"dirs", stats.Dirs,
+				"deleted", stats.Deleted,
+				"duration", time.Since(start).Round(time.Millisecond),
+			)
+		}
+
+		if cli.Loop == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cli.Loop):
+		}
+	}
+}
+
+// newFetcher picks the transport for remote. s3Options configure an
+// s3:// remote.
+func newFetcher(remote string, rsyncOptions []string, s3Options ...objstore.Option) (mirror.Fetcher, error) {
+	if strings.HasPrefix(remote, "s3://") {
+		if len(rsyncOptions) > 0 {
+			return nil, fmt.Errorf("--rsync-option cannot be used with an s3 remote")
+		}
+		s, err := objstore.NewS3(remote, s3Options...)
+		if err != nil {
+			return nil, err
+		}
+		return s.Files(), nil
+	}
+	if strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://") {
+		if len(rsyncOptions) > 0 {
+			return nil, fmt.Errorf("--rsync-option cannot be used with an http remote")
+		}
+		return mirror.NewHTTP(remote)
+	}
+	return mirror.NewRsync(remote, rsyncOptions...)
+}
+
+// loadVerifyKeys parses the --verify-key arguments, each a public key file
+// or a base64 key.
+func loadVerifyKeys(args []string) ([]*recentfile.PublicKey, error) {
+	var keys []*recentfile.PublicKey
+	for _, arg := range args {
+		data, err := os.ReadFile(arg)
+		if errors.Is(err, fs.ErrNotExist) {
+			data = []byte(arg)
+		} else if err != nil {
+			return nil, fmt.Errorf("read verify key: %w", err)
+		}
+		key, err := recentfile.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("verify key %s: %w", arg, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
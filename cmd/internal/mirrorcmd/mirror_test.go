@@ -0,0 +1,71 @@
+package mirrorcmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/mirror"
+	"github.com/abh/rrrgo/objstore"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestNewFetcher(t *testing.T) {
+	f, err := newFetcher("https://www.cpan.org/authors/", nil)
+	if err != nil {
+		t.Fatalf("http remote: %v", err)
+	}
+	if _, ok := f.(*mirror.HTTP); !ok {
+		t.Errorf("https remote gave %T", f)
+	}
+
+	f, err = newFetcher("pause.perl.org::authors", []string{"--port=8730"})
+	if err != nil {
+		t.Fatalf("rsync remote: %v", err)
+	}
+	if _, ok := f.(*mirror.Rsync); !ok {
+		t.Errorf("rsync remote gave %T", f)
+	}
+
+	if _, err := newFetcher("http://example.com/", []string{"-z"}); err == nil {
+		t.Error("Expected error for rsync options with http remote")
+	}
+
+	f, err = newFetcher("s3://bucket/cpan", nil, objstore.WithEndpoint("http://localhost:9000"))
+	if err != nil {
+		t.Fatalf("s3 remote: %v", err)
+	}
+	if _, ok := f.(*objstore.Files); !ok {
+		t.Errorf("s3 remote gave %T", f)
+	}
+	if _, err := newFetcher("s3://bucket/cpan", nil, objstore.WithEndpoint("ftp://localhost")); err == nil {
+		t.Error("Expected error for ftp endpoint")
+	}
+}
+
+func TestLoadVerifyKeys(t *testing.T) {
+	k1, err := recentfile.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := recentfile.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "rrr.pub")
+	if err := os.WriteFile(keyFile, k1.Public().Marshal(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadVerifyKeys([]string{keyFile, k2.Public().String()})
+	if err != nil {
+		t.Fatalf("loadVerifyKeys failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID() != k1.Public().ID() || keys[1].ID() != k2.Public().ID() {
+		t.Errorf("keys = %v", keys)
+	}
+
+	if _, err := loadVerifyKeys([]string{"not-a-key"}); err == nil {
+		t.Error("Expected error for invalid key")
+	}
+}
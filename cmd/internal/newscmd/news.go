@@ -0,0 +1,156 @@
+// Package newscmd is the rrr-news command, also run as rrr news.
+package newscmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+// CLI defines the command-line interface for rrr-news and rrr news.
+type CLI struct {
+	PrincipalFile string `arg:"" help:"Path to principal RECENT file (e.g., RECENT-1h.yaml)." type:"path"`
+
+	Since       string        `short:"s" default:"1h" help:"Show changes after this epoch, or within this age (e.g., 1712345678.5, 90m, 1d, 1W)."`
+	JSON        bool          `xor:"format" help:"Print events as JSON, one per line."`
+	PathsOnly   bool          `xor:"format" help:"Print only the paths."`
+	RsyncFilter string        `xor:"format" placeholder:"FORMAT" help:"Print an rsync list of the changes instead: files-from (existing files only) or include-from (filter rules, including deletions)."`
+	Follow      bool          `short:"f" help:"Keep running and print new changes as they are recorded."`
+	Poll        time.Duration `default:"1s" help:"How often to check for new changes with --follow."`
+}
+
+// Run prints the changes cli asks for to out.
+func Run(cli *CLI, out io.Writer) error {
+	since, err := recentfile.ParseSince(cli.Since, time.Now())
+	if err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+
+	principalPath, err := filepath.Abs(cli.PrincipalFile)
+	if err != nil {
+		return fmt.Errorf("resolve principal path: %w", err)
+	}
+
+	rec, err := recent.New(principalPath)
+	if err != nil {
+		return fmt.Errorf("load recent: %w", err)
+	}
+
+	if cli.RsyncFilter != "" {
+		if cli.RsyncFilter != rsynclist.FilesFrom && cli.RsyncFilter != rsynclist.IncludeFrom {
+			return fmt.Errorf("--rsync-filter must be %s or %s", rsynclist.FilesFrom, rsynclist.IncludeFrom)
+		}
+		if cli.Follow {
+			return fmt.Errorf("--rsync-filter cannot be used with --follow")
+		}
+		events, err := rsynclist.Changes(rec, since)
+		if err != nil {
+			return fmt.Errorf("collect changes: %w", err)
+		}
+		if err := rsynclist.Write(out, cli.RsyncFilter, events); err != nil {
+			return fmt.Errorf("write list: %w", err)
+		}
+		return nil
+	}
+
+	p := &printer{w: bufio.NewWriter(out), json: cli.JSON, pathsOnly: cli.PathsOnly}
+
+	if !cli.Follow {
+		events, err := news(rec, since)
+		if err != nil {
+			return err
+		}
+		// Newest first, like the Perl rrr-news
+		for i := len(events) - 1; i >= 0; i-- {
+			p.print(events[i])
+		}
+		return p.flush()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return follow(ctx, rec, since, cli.Poll, p)
+}
+
+// news returns the latest event for every path changed after since,
+// oldest first.
+func news(rec *recent.Recent, since recentfile.Epoch) ([]recentfile.Event, error) {
+	var events []recentfile.Event
+	for event, err := range rec.News(since) {
+		if err != nil {
+			return nil, fmt.Errorf("collect changes: %w", err)
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return recentfile.EpochLt(events[i].Epoch, events[j].Epoch)
+	})
+	return events, nil
+}
+
+// follow prints changes after since oldest first, then polls for new ones
+// until ctx is done.
+func follow(ctx context.Context, rec *recent.Recent, since recentfile.Epoch, poll time.Duration, p *printer) error {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		events, err := news(rec, since)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			p.print(event)
+			since = event.Epoch
+		}
+		if err := p.flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printer writes events in the selected format.
+type printer struct {
+	w         *bufio.Writer
+	json      bool
+	pathsOnly bool
+}
+
+func (p *printer) print(event recentfile.Event) {
+	switch {
+	case p.json:
+		data, _ := json.Marshal(event)
+		p.w.Write(data)
+		p.w.WriteByte('\n')
+	case p.pathsOnly:
+		fmt.Fprintln(p.w, event.Path)
+	default:
+		ts := time.Unix(0, int64(recentfile.EpochToFloat(event.Epoch)*1e9)).UTC()
+		fmt.Fprintf(p.w, "%s  %s  %-6s  %s\n", event.Epoch, ts.Format(time.RFC3339), event.Type, event.Path)
+	}
+}
+
+func (p *printer) flush() error {
+	if err := p.w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package newscmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func setupRecent(t *testing.T) (*recent.Recent, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	for _, name := range []string{"a.txt", "dir/b.txt"} {
+		if err := rec.Update(filepath.Join(tmpDir, name), "new"); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	// Moves the events into the 1d file; news must look there too
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	if err := rec.Update(filepath.Join(tmpDir, "a.txt"), "delete"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	return rec, filepath.Join(tmpDir, "RECENT-1h.yaml")
+}
+
+func TestRun(t *testing.T) {
+	_, principal := setupRecent(t)
+
+	var out bytes.Buffer
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h", PathsOnly: true}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	// Newest first, one line per path
+	if got, want := out.String(), "a.txt\ndir/b.txt\n"; got != want {
+		t.Errorf("paths = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h", JSON: true}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var first recentfile.Event
+	if err := json.Unmarshal([]byte(strings.SplitN(out.String(), "\n", 2)[0]), &first); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if first.Path != "a.txt" || first.Type != "delete" {
+		t.Errorf("first event = %+v", first)
+	}
+
+	out.Reset()
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h"}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if fields := strings.Fields(strings.SplitN(out.String(), "\n", 2)[0]); len(fields) != 4 || fields[2] != "delete" {
+		t.Errorf("text line = %q", fields)
+	}
+
+	if err := Run(&CLI{PrincipalFile: principal, Since: "soon"}, &out); err == nil {
+		t.Error("expected error for invalid --since")
+	}
+}
+
+func TestRunRsyncFilter(t *testing.T) {
+	_, principal := setupRecent(t)
+
+	var out bytes.Buffer
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h", RsyncFilter: "files-from"}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	// a.txt was deleted, which a files-from list cannot express
+	if got, want := out.String(), "dir/b.txt\n"; got != want {
+		t.Errorf("files-from = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h", RsyncFilter: "include-from"}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got, want := out.String(), "+ /a.txt\n+ /dir/\n+ /dir/b.txt\n- *\n"; got != want {
+		t.Errorf("include-from = %q, want %q", got, want)
+	}
+
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h", RsyncFilter: "files-from", Follow: true}, &out); err == nil {
+		t.Error("expected error for --rsync-filter with --follow")
+	}
+	if err := Run(&CLI{PrincipalFile: principal, Since: "1h", RsyncFilter: "exclude-from"}, &out); err == nil {
+		t.Error("expected error for unknown --rsync-filter format")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFollow(t *testing.T) {
+	rec, _ := setupRecent(t)
+
+	var out syncBuffer
+	p := &printer{w: bufio.NewWriter(&out), pathsOnly: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- follow(ctx, rec, 0, 10*time.Millisecond, p) }()
+
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for out.String() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("output = %q, want %q", out.String(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Oldest first when following
+	waitFor("dir/b.txt\na.txt\n")
+
+	path := filepath.Join(rec.LocalRoot(), "c.txt")
+	os.WriteFile(path, []byte("c"), 0o644)
+	if err := rec.Update(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("dir/b.txt\na.txt\nc.txt\n")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("follow: %v", err)
+	}
+}
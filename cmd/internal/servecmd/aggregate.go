@@ -0,0 +1,123 @@
+package servecmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+)
+
+// AggregateCLI defines the command-line interface for rrr-aggregate and
+// rrr aggregate.
+type AggregateCLI struct {
+	LocalRoot string          `arg:"" optional:"" help:"Local root directory of the hierarchy (or local_root in the config file)." type:"path"`
+	Config    kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, e.g. that of rrr serve." type:"path"`
+
+	flags.Layout
+	flags.Write
+	flags.Sign
+	flags.Lock
+	flags.Perms
+
+	Force   bool `help:"Merge into every aggregator interval, not only those that are due."`
+	DryRun  bool `short:"n" help:"Only print the intervals each hierarchy would be merged into."`
+	Verbose bool `short:"v" help:"Enable verbose logging."`
+}
+
+// Aggregate runs one aggregation of the existing hierarchies cli
+// describes, as rrr serve does every --aggregate-interval. It takes the
+// same locks as the server, so it can run from cron next to one, Go or
+// Perl. A hierarchy that fails is reported and the others are still
+// aggregated.
+func Aggregate(cli *AggregateCLI) error {
+	localRoot, err := localRootArg(cli.LocalRoot, cli.Config)
+	if err != nil {
+		return err
+	}
+	log := newLogger(cli.Verbose)
+
+	layouts, err := cli.Layouts()
+	if err != nil {
+		return err
+	}
+	if err := cli.Write.Check(); err != nil {
+		return err
+	}
+	if err := cli.Sign.Load(); err != nil {
+		return err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return err
+	}
+
+	// Give up waiting for a lock on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var errs []error
+	for _, layout := range layouts {
+		root := filepath.Join(localRoot, layout.Dir)
+		rec, err := openExisting(&cli.Layout, root, layout)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rec.SetPerlYAML(cli.PerlYAML)
+		rec.SetComment(cli.Comment)
+		cli.Write.Apply(rec)
+		cli.Lock.Apply(rec)
+		cli.Perms.Apply(rec)
+
+		principal := rec.PrincipalRecentfile().Rfile()
+		plan := rec.AggregatePlan(cli.Force)
+		if cli.DryRun {
+			into := strings.Join(plan, " ")
+			if into == "" {
+				into = "(nothing due)"
+			}
+			fmt.Printf("%s: %s\n", principal, into)
+			continue
+		}
+		if len(plan) == 0 {
+			log.Debug("nothing to aggregate", "principal", principal)
+			continue
+		}
+		if err := rec.AggregateContext(ctx, cli.Force); err != nil {
+			errs = append(errs, fmt.Errorf("aggregate %s: %w", principal, err))
+			continue
+		}
+		log.Debug("aggregated", "principal", principal, "into", plan)
+	}
+	return errors.Join(errs...)
+}
+
+// openExisting loads the hierarchy of layout in root, which must have its
+// RECENT files already.
+func openExisting(l *flags.Layout, root string, layout recent.Layout) (*recent.Recent, error) {
+	indexDir := root
+	if l.IndexDir != "" {
+		indexDir = filepath.Join(l.IndexDir, layout.Dir)
+	}
+	filenameRoot := l.Filenameroot
+	if filenameRoot == "" {
+		filenameRoot = "RECENT"
+	}
+	principal := filepath.Join(indexDir, fmt.Sprintf("%s-%s%s", filenameRoot, layout.Interval, formatSuffix(layout.Format)))
+	if _, err := os.Stat(principal); err != nil {
+		return nil, fmt.Errorf("no hierarchy in %s: %w", root, err)
+	}
+	rec, err := recent.NewWithLocalRoot(principal, root)
+	if err != nil {
+		return nil, fmt.Errorf("load recent: %w", err)
+	}
+	return rec, nil
+}
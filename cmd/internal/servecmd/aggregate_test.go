@@ -0,0 +1,63 @@
+package servecmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestAggregate(t *testing.T) {
+	tmpDir := t.TempDir()
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1d"}),
+	)
+	if err := principal.BatchUpdate([]recentfile.BatchItem{{Path: "a.txt", Type: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &AggregateCLI{
+		LocalRoot: tmpDir,
+		Layout:    flags.Layout{Filenameroot: "RECENT", Interval: "1h", Format: "yaml"},
+		Write:     flags.Write{Retention: true},
+		Lock:      flags.Lock{LockBackend: "mkdir"},
+		DryRun:    true,
+	}
+	if err := Aggregate(cli); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-6h.yaml")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote RECENT-6h.yaml: %v", err)
+	}
+
+	cli.DryRun = false
+	if err := Aggregate(cli); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	rec, err := recent.New(principal.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rf := range rec.Recentfiles() {
+		if events := rf.RecentEvents(); len(events) != 1 || events[0].Path != "a.txt" {
+			t.Errorf("%s events = %+v", rf.Interval(), events)
+		}
+	}
+
+	// Hierarchies are never created
+	cli.Interval = "30m"
+	if err := Aggregate(cli); err == nil {
+		t.Error("Aggregate of a missing hierarchy succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-30m.yaml")); !os.IsNotExist(err) {
+		t.Errorf("RECENT-30m.yaml created: %v", err)
+	}
+}
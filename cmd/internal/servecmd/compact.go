@@ -0,0 +1,70 @@
+package servecmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+)
+
+// CompactCLI defines the command-line interface for rrr compact.
+type CompactCLI struct {
+	LocalRoot string          `arg:"" optional:"" help:"Local root directory of the hierarchy (or local_root in the config file)." type:"path"`
+	Config    kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, e.g. that of rrr serve." type:"path"`
+
+	flags.Layout
+	flags.Sign
+	flags.Lock
+	flags.Perms
+
+	ZPruneDeletes time.Duration `placeholder:"AGE" help:"Also drop delete events older than this; 0 keeps them all."`
+
+	Verbose bool `short:"v" help:"Enable verbose logging."`
+}
+
+// Compact compacts the Z recentfile of the existing hierarchies cli
+// describes once (see recent.Recent.CompactZ), as rrr serve does every
+// --z-compact-interval. It takes the file lock, so the server may be
+// running.
+func Compact(cli *CompactCLI) error {
+	localRoot, err := localRootArg(cli.LocalRoot, cli.Config)
+	if err != nil {
+		return err
+	}
+	log := newLogger(cli.Verbose)
+
+	layouts, err := cli.Layouts()
+	if err != nil {
+		return err
+	}
+	if err := cli.Sign.Load(); err != nil {
+		return err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return err
+	}
+
+	for _, layout := range layouts {
+		root := filepath.Join(localRoot, layout.Dir)
+		rec, err := openExisting(&cli.Layout, root, layout)
+		if err != nil {
+			return err
+		}
+		rec.SetPreserveEpochs(true)
+		rec.SetProtocolExt(true)
+		rec.SetPerlYAML(cli.PerlYAML)
+		rec.SetComment(cli.Comment)
+		cli.Lock.Apply(rec)
+		cli.Perms.Apply(rec)
+
+		removed, err := rec.CompactZ(cli.ZPruneDeletes)
+		if err != nil {
+			return fmt.Errorf("compact %s: %w", root, err)
+		}
+		log.Info("compacted Z recentfile", "root", root, "removed", removed)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package servecmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestCompact(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := flags.Layout{Filenameroot: "RECENT", Interval: "1h", Aggregator: []string{"6h", "Z"}, Format: "yaml"}
+	if err := Init(&InitCLI{LocalRoot: tmpDir, Layout: layout, Lock: flags.Lock{LockBackend: "mkdir"}}); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	z, err := recentfile.NewFromFile(filepath.Join(tmpDir, "RECENT-Z.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := recentfile.EpochFromTime(time.Now().Add(-48 * time.Hour))
+	z.SetRecentEvents([]recentfile.Event{
+		{Epoch: recentfile.EpochNow(), Path: "a.txt", Type: "new"},
+		{Epoch: old, Path: "gone.txt", Type: "delete"},
+	})
+	if err := z.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &CompactCLI{LocalRoot: tmpDir, Layout: layout, Lock: flags.Lock{LockBackend: "mkdir"}, ZPruneDeletes: 24 * time.Hour}
+	if err := Compact(cli); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	z, err = recentfile.NewFromFile(z.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := z.RecentEvents(); len(events) != 1 || events[0].Path != "a.txt" {
+		t.Errorf("Z events after compaction = %+v", events)
+	}
+
+	// A hierarchy without a Z file can't be compacted
+	cli.LocalRoot = t.TempDir()
+	cli.Layout.Aggregator = []string{"6h"}
+	if err := Init(&InitCLI{LocalRoot: cli.LocalRoot, Layout: cli.Layout, Lock: cli.Lock}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Compact(cli); err == nil {
+		t.Error("Compact without a Z interval succeeded")
+	}
+}
@@ -0,0 +1,161 @@
+package servecmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/watcher"
+)
+
+// NewParser returns the command line parser of rrr-server. Flags not given
+// on the command line are taken from the YAML file named with --config.
+func NewParser(root *Root) *kong.Kong {
+	return kong.Must(root,
+		kong.Name("rrr-server"),
+		kong.Description("File synchronization server using RECENT protocol"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+		kong.Configuration(flags.YAMLConfig),
+	)
+}
+
+// parseServe parses args, the server's command line, again with the
+// current contents of its config file. The command line of rrr serve
+// parses as that of rrr-server serve.
+func parseServe(args []string) (*CLI, error) {
+	var root Root
+	parser := NewParser(&root)
+	kctx, err := parser.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(kctx.Command(), "dashboard") {
+		return nil, fmt.Errorf("not a serve command line")
+	}
+	cli := &root.Serve
+	cli.args = args
+	if cli.LocalRoot == "" && cli.Config != "" {
+		if cli.LocalRoot, err = flags.ConfigLocalRoot(string(cli.Config)); err != nil {
+			return nil, err
+		}
+	}
+	return cli, nil
+}
+
+// reloadable are the settings a reload applies to the running server;
+// changing any other one needs a restart.
+var reloadable = map[string]bool{
+	"Ignore":            true,
+	"Include":           true,
+	"BatchSize":         true,
+	"BatchDelay":        true,
+	"QuietPeriod":       true,
+	"IgnoreChmod":       true,
+	"AggregateInterval": true,
+	"RescanInterval":    true,
+	"Retention":         true,
+	"EventMtime":        true,
+	"PreserveEpochs":    true,
+	"ProtocolExt":       true,
+	"DropDeletes":       true,
+	"MaxEvents":         true,
+	"Generations":       true,
+	"DurableWrites":     true,
+	"FileMode":          true,
+	"DirMode":           true,
+	"Owner":             true,
+	"Group":             true,
+	"PerlYAML":          true,
+	"LockBackend":       true,
+	"BreakLocks":        true,
+	"WriteInterval":     true,
+	"WriteMaxEvents":    true,
+	"Comment":           true,
+}
+
+// reload reads the config file again and applies the reloadable settings
+// to every hierarchy, keeping the watchers and their queued events. It
+// returns the new settings, or cli if they could not be read.
+func (s *server) reload(cli *CLI) *CLI {
+	if cli.Config == "" {
+		s.log.Warn("received SIGHUP but no --config file to reload")
+		return cli
+	}
+	next, err := parseServe(cli.args)
+	if err != nil {
+		s.log.Error("reload failed, keeping the current settings", "config", cli.Config, "error", err)
+		return cli
+	}
+
+	// Check the patterns and intervals first, so a bad one changes nothing
+	if _, err := next.Filter.New(); err != nil {
+		s.log.Error("reload failed, keeping the current settings", "config", cli.Config, "error", err)
+		return cli
+	}
+	if err := next.Write.Check(); err != nil {
+		s.log.Error("reload failed, keeping the current settings", "config", cli.Config, "error", err)
+		return cli
+	}
+	if err := next.Perms.Check(); err != nil {
+		s.log.Error("reload failed, keeping the current settings", "config", cli.Config, "error", err)
+		return cli
+	}
+	// The principal in memory would overwrite what the background fsck
+	// repairs, as run checks at startup
+	if next.WriteInterval > 0 && len(s.fsckRepairs) > 0 {
+		s.log.Error("reload failed, keeping the current settings", "config", cli.Config,
+			"error", "--fsck-auto-repair cannot be used with --write-interval")
+		return cli
+	}
+
+	opts := []watcher.Option{
+		watcher.WithIgnorePatterns(next.Ignore...),
+		watcher.WithIncludePatterns(next.Include...),
+		watcher.WithBatchSize(next.BatchSize),
+		watcher.WithBatchDelay(next.BatchDelay),
+		watcher.WithQuietPeriod(next.QuietPeriod),
+		watcher.WithIgnoreChmod(next.IgnoreChmod),
+		watcher.WithAggregateInterval(next.AggregateInterval),
+		watcher.WithRescanInterval(next.RescanInterval),
+	}
+	for _, h := range s.hierarchies {
+		if err := h.watcher.Reconfigure(opts...); err != nil {
+			s.log.Error("reconfigure watcher", "root", h.rec.LocalRoot(), "error", err)
+		}
+		if next.WriteInterval != cli.WriteInterval {
+			if err := h.rec.Flush(); err != nil {
+				s.log.Error("write deferred events", "root", h.rec.LocalRoot(), "error", err)
+			}
+		}
+		applySettings(next, h.rec)
+	}
+
+	old, now := reflect.ValueOf(cli).Elem(), reflect.ValueOf(next).Elem()
+	for _, field := range reflect.VisibleFields(old.Type()) {
+		if !field.IsExported() || field.Anonymous || reloadable[field.Name] {
+			continue
+		}
+		if !reflect.DeepEqual(old.FieldByIndex(field.Index).Interface(), now.FieldByIndex(field.Index).Interface()) {
+			s.log.Warn("setting changed, restart to apply", "setting", field.Name)
+		}
+	}
+
+	s.log.Info("configuration reloaded", "config", cli.Config)
+	return next
+}
+
+// applySettings applies the settings for writing RECENT files to rec.
+func applySettings(cli *CLI, rec *recent.Recent) {
+	cli.Write.Apply(rec)
+	rec.SetPerlYAML(cli.PerlYAML)
+	cli.Lock.Apply(rec)
+	cli.Perms.Apply(rec)
+	rec.SetDeferredWrites(cli.WriteInterval, cli.WriteMaxEvents)
+	rec.SetComment(cli.Comment)
+}
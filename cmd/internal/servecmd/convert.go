@@ -0,0 +1,71 @@
+package servecmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+)
+
+// ConvertCLI defines the command-line interface for rrr-convert and rrr
+// convert.
+type ConvertCLI struct {
+	LocalRoot string          `arg:"" optional:"" help:"Local root directory of the hierarchy (or local_root in the config file)." type:"path"`
+	Config    kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, e.g. that of rrr serve." type:"path"`
+
+	To string `required:"" placeholder:"FORMAT" help:"Format to convert to: yaml, json or sereal, with .gz, .zst or .enc as in the file names, e.g. json.gz."`
+
+	flags.Layout
+	flags.Sign
+	flags.Lock
+	flags.Perms
+
+	Verbose bool `short:"v" help:"Enable verbose logging."`
+}
+
+// Convert rewrites the RECENT files of the existing hierarchies cli
+// describes, in the format they are in now, in the format of --to (see
+// recent.Recent.Convert). Events are converted as they are, with the
+// original text of their epochs and any protocol extension fields.
+func Convert(cli *ConvertCLI) error {
+	localRoot, err := localRootArg(cli.LocalRoot, cli.Config)
+	if err != nil {
+		return err
+	}
+	log := newLogger(cli.Verbose)
+
+	layouts, err := cli.Layouts()
+	if err != nil {
+		return err
+	}
+	if err := cli.Sign.Load(); err != nil {
+		return err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return err
+	}
+	suffix := formatSuffix(cli.To)
+
+	for _, layout := range layouts {
+		root := filepath.Join(localRoot, layout.Dir)
+		rec, err := openExisting(&cli.Layout, root, layout)
+		if err != nil {
+			return err
+		}
+		rec.SetPreserveEpochs(true)
+		rec.SetProtocolExt(true)
+		rec.SetPerlYAML(cli.PerlYAML)
+		rec.SetComment(cli.Comment)
+		cli.Lock.Apply(rec)
+		cli.Perms.Apply(rec)
+
+		from := rec.PrincipalRecentfile().Rfile()
+		if err := rec.Convert(suffix); err != nil {
+			return fmt.Errorf("convert %s: %w", from, err)
+		}
+		log.Info("hierarchy converted", "root", root, "from", from, "to", rec.PrincipalRecentfile().Rfile())
+	}
+	return nil
+}
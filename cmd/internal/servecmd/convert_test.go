@@ -0,0 +1,37 @@
+package servecmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+)
+
+func TestConvert(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := flags.Layout{Filenameroot: "RECENT", Interval: "1h", Format: "yaml", Cpan: true}
+	if err := Init(&InitCLI{LocalRoot: tmpDir, Layout: layout, Lock: flags.Lock{LockBackend: "mkdir"}}); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	cli := &ConvertCLI{LocalRoot: tmpDir, To: "json", Layout: layout, Lock: flags.Lock{LockBackend: "mkdir"}}
+	if err := Convert(cli); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	for _, l := range recent.CPANLayout() {
+		dir := filepath.Join(tmpDir, l.Dir)
+		if target, err := os.Readlink(filepath.Join(dir, "RECENT.recent")); err != nil || target != "RECENT-1h.json" {
+			t.Errorf("%s/RECENT.recent -> %q (%v)", l.Dir, target, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "RECENT-1h.yaml"+recent.BackupSuffix)); err != nil {
+			t.Errorf("no backup in %s: %v", l.Dir, err)
+		}
+	}
+
+	// The YAML hierarchies are gone
+	if err := Convert(cli); err == nil {
+		t.Error("second Convert succeeded")
+	}
+}
@@ -0,0 +1,36 @@
+package servecmd
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+)
+
+// dashboardJSON is a Grafana dashboard for the metrics registered in
+// newMetrics. It uses an import input for the Prometheus data source.
+//
+//go:embed dashboard.json
+var dashboardJSON []byte
+
+// DashboardCmd groups the dashboard subcommands.
+type DashboardCmd struct {
+	Export DashboardExportCmd `cmd:"" help:"Write a Grafana dashboard (JSON) for the rrr-server metrics."`
+}
+
+// DashboardExportCmd writes the bundled Grafana dashboard.
+type DashboardExportCmd struct {
+	Output string `short:"o" help:"Write to this file instead of stdout." type:"path"`
+}
+
+// Run writes the dashboard to the output file or stdout.
+func (c *DashboardExportCmd) Run() error {
+	if c.Output == "" {
+		_, err := os.Stdout.Write(dashboardJSON)
+		return err
+	}
+
+	if err := os.WriteFile(c.Output, dashboardJSON, 0o644); err != nil {
+		return fmt.Errorf("write dashboard: %w", err)
+	}
+	return nil
+}
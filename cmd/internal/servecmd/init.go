@@ -0,0 +1,110 @@
+package servecmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+)
+
+// InitCLI defines the command-line interface for rrr init.
+type InitCLI struct {
+	LocalRoot string          `arg:"" optional:"" help:"Local root directory of the new hierarchy (or local_root in the config file)." type:"path"`
+	Config    kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, e.g. that of rrr serve." type:"path"`
+
+	flags.Layout
+	flags.Sign
+	flags.Lock
+	flags.Perms
+
+	InitialScan bool `aliases:"seed" help:"Record the files already in the local root, using their modification times as epochs."`
+	flags.Filter
+	Verbose bool `short:"v" help:"Enable verbose logging."`
+}
+
+// Init creates the RECENT files of the hierarchies cli describes, as rrr
+// serve would on its first start, and with --initial-scan records the
+// files already there. Hierarchies that have their RECENT files are left
+// as they are, apart from the scan, which only seeds an empty one.
+func Init(cli *InitCLI) error {
+	localRoot, err := localRootArg(cli.LocalRoot, cli.Config)
+	if err != nil {
+		return err
+	}
+	log := newLogger(cli.Verbose)
+
+	layouts, err := cli.Layouts()
+	if err != nil {
+		return err
+	}
+	if err := cli.Sign.Load(); err != nil {
+		return err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return err
+	}
+	if cli.IndexDir != "" && isInside(localRoot, cli.IndexDir) {
+		return fmt.Errorf("index dir %s is inside the local root", cli.IndexDir)
+	}
+	filter, err := cli.Filter.New()
+	if err != nil {
+		return err
+	}
+
+	for _, layout := range layouts {
+		root := filepath.Join(localRoot, layout.Dir)
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			return fmt.Errorf("create hierarchy root: %w", err)
+		}
+		rec, err := loadRecent(&cli.Layout, root, layout, log, cli.Perms.Options()...)
+		if err != nil {
+			return err
+		}
+		rec.SetPerlYAML(cli.PerlYAML)
+		rec.SetComment(cli.Comment)
+		cli.Lock.Apply(rec)
+		cli.Perms.Apply(rec)
+
+		if cli.InitialScan {
+			if err := initialScan(rec, filter, log); err != nil {
+				return fmt.Errorf("initial scan: %w", err)
+			}
+		}
+		log.Info("hierarchy ready", "root", root, "principal", rec.PrincipalRecentfile().Rfile())
+	}
+	return nil
+}
+
+// localRootArg returns the absolute path of the local root given on the
+// command line, or else in the config file.
+func localRootArg(localRoot string, config kong.ConfigFlag) (string, error) {
+	if localRoot == "" && config != "" {
+		root, err := flags.ConfigLocalRoot(string(config))
+		if err != nil {
+			return "", err
+		}
+		localRoot = root
+	}
+	if localRoot == "" {
+		return "", fmt.Errorf("no local root given")
+	}
+	root, err := filepath.Abs(localRoot)
+	if err != nil {
+		return "", fmt.Errorf("resolve local root: %w", err)
+	}
+	return root, nil
+}
+
+// newLogger returns the logger of the one-shot commands, logging to
+// stderr, with debug messages if verbose.
+func newLogger(verbose bool) *slog.Logger {
+	logLevel := slog.LevelInfo
+	if verbose {
+		logLevel = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+}
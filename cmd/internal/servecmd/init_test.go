@@ -0,0 +1,72 @@
+package servecmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+)
+
+func TestInit(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "authors", "id"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "authors", "id", "a.tar.gz"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &InitCLI{
+		LocalRoot: tmpDir,
+		Layout: flags.Layout{
+			Filenameroot: "RECENT",
+			Interval:     "1h",
+			Format:       "yaml",
+			Cpan:         true,
+		},
+		Lock:        flags.Lock{LockBackend: "mkdir"},
+		Perms:       flags.Perms{FileMode: "0640"},
+		InitialScan: true,
+	}
+	if err := Init(cli); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	// Both hierarchies are created, modules/ along with its directory
+	for _, layout := range recent.CPANLayout() {
+		principal := filepath.Join(tmpDir, layout.Dir, "RECENT-"+layout.Interval+".yaml")
+		rec, err := recent.New(principal)
+		if err != nil {
+			t.Fatalf("recent.New(%s) failed: %v", principal, err)
+		}
+		events := rec.PrincipalRecentfile().RecentEvents()
+		if layout.Dir == "authors" && (len(events) != 1 || events[0].Path != "id/a.tar.gz") {
+			t.Errorf("authors events = %+v", events)
+		}
+		if layout.Dir == "modules" && len(events) != 0 {
+			t.Errorf("modules events = %+v", events)
+		}
+		// Files never written since they were created have the mode too
+		if fi, err := os.Stat(filepath.Join(tmpDir, layout.Dir, "RECENT-1M.yaml")); runtime.GOOS != "windows" && (err != nil || fi.Mode().Perm() != 0o640) {
+			t.Errorf("%s/RECENT-1M.yaml: %v, %v", layout.Dir, fi, err)
+		}
+	}
+
+	// Running it again changes nothing
+	if err := Init(cli); err != nil {
+		t.Fatalf("second Init failed: %v", err)
+	}
+
+	cli.Perms.FileMode = "0888"
+	if err := Init(cli); err == nil {
+		t.Error("Init with an invalid mode succeeded")
+	}
+
+	cli.LocalRoot = ""
+	if err := Init(cli); err == nil {
+		t.Error("Init without a local root succeeded")
+	}
+}
@@ -0,0 +1,312 @@
+package servecmd
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// expvars mirrors the key metrics for /debug/vars.
+var expvars = expvar.NewMap("rrr")
+
+// metrics holds Prometheus metrics collectors.
+type metrics struct {
+	eventsProcessed     *prometheus.CounterVec
+	aggregationRuns     prometheus.Counter
+	aggregationDuration prometheus.Histogram
+	aggregationSkipped  *prometheus.CounterVec
+	eventsInQueue       prometheus.Gauge
+	flushDuration       prometheus.Histogram
+	newestEvent         *prometheus.GaugeVec
+	recentfileAge       *prometheus.GaugeVec
+	recentfileEvents    *prometheus.GaugeVec
+	lockWait            *prometheus.HistogramVec
+	lockRetries         *prometheus.CounterVec
+	lockFailures        *prometheus.CounterVec
+	fsckIssues          *prometheus.GaugeVec
+	fsckLastRun         *prometheus.GaugeVec
+}
+
+// newMetrics creates the server's metrics and registers them with reg.
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		eventsProcessed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rrr_events_processed_total",
+				Help: "Total number of file system events processed",
+			},
+			[]string{"type"}, // "new" or "delete"
+		),
+		aggregationRuns: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rrr_aggregation_runs_total",
+				Help: "Total number of aggregation runs",
+			},
+		),
+		aggregationDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "rrr_aggregation_duration_seconds",
+				Help:    "Time taken to run aggregation",
+				Buckets: prometheus.DefBuckets,
+			},
+		),
+		aggregationSkipped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rrr_aggregation_skipped_total",
+				Help: "Aggregation runs skipped because another process held recentfile locks",
+			},
+			[]string{"hierarchy"},
+		),
+		eventsInQueue: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "rrr_events_in_queue",
+				Help: "Current number of events queued for processing",
+			},
+		),
+		flushDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "rrr_flush_duration_seconds",
+				Help:    "Time taken to write a batch of events",
+				Buckets: prometheus.DefBuckets,
+			},
+		),
+		newestEvent: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rrr_newest_event_timestamp_seconds",
+				Help: "Epoch of the newest event in each recentfile (minmax.max)",
+			},
+			[]string{"hierarchy", "interval"},
+		),
+		recentfileAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rrr_recentfile_age_seconds",
+				Help: "Time since each recentfile was last updated (minmax.mtime)",
+			},
+			[]string{"hierarchy", "interval"},
+		),
+		recentfileEvents: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rrr_recentfile_events",
+				Help: "Number of events in each recentfile",
+			},
+			[]string{"hierarchy", "interval"},
+		),
+		lockWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "rrr_lock_wait_seconds",
+				Help:    "Time taken to lock a recentfile",
+				Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to 4m
+			},
+			[]string{"hierarchy", "interval"},
+		),
+		lockRetries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rrr_lock_retries_total",
+				Help: "Attempts to lock a recentfile that found it held by another process",
+			},
+			[]string{"hierarchy", "interval"},
+		),
+		lockFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rrr_lock_failures_total",
+				Help: "Recentfile locks that could not be taken",
+			},
+			[]string{"hierarchy", "interval"},
+		),
+		fsckIssues: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rrr_fsck_issues",
+				Help: "Issues found by the last fsck run, by check",
+			},
+			[]string{"hierarchy", "check"},
+		),
+		fsckLastRun: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rrr_fsck_last_run_timestamp_seconds",
+				Help: "Time of the last fsck run",
+			},
+			[]string{"hierarchy"},
+		),
+	}
+
+	reg.MustRegister(
+		m.eventsProcessed,
+		m.aggregationRuns,
+		m.aggregationDuration,
+		m.aggregationSkipped,
+		m.eventsInQueue,
+		m.flushDuration,
+		m.newestEvent,
+		m.recentfileAge,
+		m.recentfileEvents,
+		m.lockWait,
+		m.lockRetries,
+		m.lockFailures,
+		m.fsckIssues,
+		m.fsckLastRun,
+	)
+
+	// Initialize eventsProcessed metric with zero values for all label types
+	// This ensures the metric appears in /metrics even before any events are processed
+	m.addEvents("new", 0)
+	m.addEvents("delete", 0)
+
+	return m
+}
+
+// addEvents counts processed events of one type.
+func (m *metrics) addEvents(eventType string, count int) {
+	m.eventsProcessed.WithLabelValues(eventType).Add(float64(count))
+	expvars.Add("events_processed_"+eventType, int64(count))
+}
+
+// observeAggregation records a successful aggregation run.
+func (m *metrics) observeAggregation(duration time.Duration) {
+	m.aggregationRuns.Inc()
+	m.aggregationDuration.Observe(duration.Seconds())
+
+	expvars.Add("aggregation_runs", 1)
+	last := new(expvar.Float)
+	last.Set(duration.Seconds())
+	expvars.Set("aggregation_last_duration_seconds", last)
+	lastRun := new(expvar.Int)
+	lastRun.Set(time.Now().Unix())
+	expvars.Set("aggregation_last_run", lastRun)
+}
+
+// skipAggregation records an aggregation of the hierarchy in dir skipped
+// for locks held elsewhere.
+func (m *metrics) skipAggregation(dir string) {
+	m.aggregationSkipped.WithLabelValues(dir).Inc()
+	expvars.Add("aggregation_skipped", 1)
+}
+
+// observeFlush records a successful batch flush.
+func (m *metrics) observeFlush(duration time.Duration) {
+	m.flushDuration.Observe(duration.Seconds())
+}
+
+// observeLock records an attempt to lock a recentfile of the hierarchy in
+// dir.
+func (m *metrics) observeLock(dir string, lw recentfile.LockWait) {
+	m.lockWait.WithLabelValues(dir, lw.Interval).Observe(lw.Wait.Seconds())
+	m.lockRetries.WithLabelValues(dir, lw.Interval).Add(float64(lw.Retries))
+	if lw.Err != nil {
+		m.lockFailures.WithLabelValues(dir, lw.Interval).Inc()
+	}
+}
+
+// observeFsck records the result of an fsck run of the hierarchy in dir.
+func (m *metrics) observeFsck(dir string, st *fsckStatus) {
+	for check, issues := range st.IssuesFound {
+		m.fsckIssues.WithLabelValues(dir, check).Set(float64(issues))
+	}
+	m.fsckLastRun.WithLabelValues(dir).Set(float64(st.Time.Unix()))
+}
+
+// setQueued records the number of events waiting to be written.
+func (m *metrics) setQueued(n int) {
+	m.eventsInQueue.Set(float64(n))
+
+	queued := new(expvar.Int)
+	queued.Set(int64(n))
+	expvars.Set("events_in_queue", queued)
+}
+
+// setFreshness records the state of the recentfiles of the hierarchy in
+// dir. Recentfiles without events have no newest event or age.
+func (m *metrics) setFreshness(dir string, states []recentfileState, now time.Time) {
+	for _, fs := range states {
+		m.recentfileEvents.WithLabelValues(dir, fs.interval).Set(float64(fs.events))
+		if fs.minmax == nil {
+			m.newestEvent.DeleteLabelValues(dir, fs.interval)
+			m.recentfileAge.DeleteLabelValues(dir, fs.interval)
+			continue
+		}
+		m.newestEvent.WithLabelValues(dir, fs.interval).Set(recentfile.EpochToFloat(fs.minmax.Max))
+		if fs.minmax.Mtime != 0 {
+			m.recentfileAge.WithLabelValues(dir, fs.interval).Set(now.Sub(time.Unix(fs.minmax.Mtime, 0)).Seconds())
+		}
+	}
+}
+
+var (
+	droppedEventsDesc = prometheus.NewDesc("rrr_watcher_dropped_events_total",
+		"Events dropped because the watcher queue was full", []string{"hierarchy"}, nil)
+	watchedDirsDesc = prometheus.NewDesc("rrr_watcher_watched_dirs",
+		"Directories being watched", []string{"hierarchy"}, nil)
+	batchedEventsDesc = prometheus.NewDesc("rrr_watcher_batched_events_total",
+		"Events flushed by the watcher, before deduplication", []string{"hierarchy"}, nil)
+	writtenEventsDesc = prometheus.NewDesc("rrr_watcher_written_events_total",
+		"Events written by the watcher, after deduplication", []string{"hierarchy"}, nil)
+)
+
+// watcherCollector reports the watcher stats of each hierarchy of a server
+// once it is set up.
+type watcherCollector struct {
+	s *server
+}
+
+// Describe implements prometheus.Collector.
+func (c watcherCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- droppedEventsDesc
+	ch <- watchedDirsDesc
+	ch <- batchedEventsDesc
+	ch <- writtenEventsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c watcherCollector) Collect(ch chan<- prometheus.Metric) {
+	if !c.s.ready.Load() {
+		return
+	}
+	for _, h := range c.s.hierarchies {
+		stats := h.watcher.Stats()
+		ch <- prometheus.MustNewConstMetric(droppedEventsDesc, prometheus.CounterValue, float64(stats.DroppedEvents), h.dir)
+		ch <- prometheus.MustNewConstMetric(watchedDirsDesc, prometheus.GaugeValue, float64(stats.WatchedDirs), h.dir)
+		ch <- prometheus.MustNewConstMetric(batchedEventsDesc, prometheus.CounterValue, float64(stats.BatchedEvents), h.dir)
+		ch <- prometheus.MustNewConstMetric(writtenEventsDesc, prometheus.CounterValue, float64(stats.WrittenEvents), h.dir)
+	}
+}
+
+// serveExpvar serves /debug/vars on port until ctx is done.
+func serveExpvar(ctx context.Context, port int) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	if err := serveHTTP(ctx, port, mux); err != nil {
+		return fmt.Errorf("expvar server: %w", err)
+	}
+	return nil
+}
+
+// serveHTTP serves handler on port until ctx is done.
+func serveHTTP(ctx context.Context, port int, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              net.JoinHostPort("", strconv.Itoa(port)),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		// End long-lived requests such as API streams on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
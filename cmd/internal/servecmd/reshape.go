@@ -0,0 +1,113 @@
+package servecmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+)
+
+// ReshapeCLI defines the command-line interface for rrr reshape.
+type ReshapeCLI struct {
+	LocalRoot string          `arg:"" optional:"" help:"Local root directory of the hierarchy (or local_root in the config file)." type:"path"`
+	Config    kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, e.g. that of rrr serve." type:"path"`
+
+	flags.Layout
+	flags.Sign
+	flags.Lock
+	flags.Perms
+
+	DryRun  bool `short:"n" help:"Only print the intervals each hierarchy would gain and lose."`
+	Verbose bool `short:"v" help:"Enable verbose logging."`
+}
+
+// Reshape gives the existing hierarchies cli describes the aggregator
+// intervals it gives them (see recent.Recent.AddInterval and
+// recent.Recent.RemoveInterval). The intervals added are created before
+// those removed are retired.
+func Reshape(cli *ReshapeCLI) error {
+	localRoot, err := localRootArg(cli.LocalRoot, cli.Config)
+	if err != nil {
+		return err
+	}
+	log := newLogger(cli.Verbose)
+
+	layouts, err := cli.Layouts()
+	if err != nil {
+		return err
+	}
+	if err := cli.Sign.Load(); err != nil {
+		return err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return err
+	}
+
+	for _, layout := range layouts {
+		if len(layout.Aggregator) == 0 {
+			return fmt.Errorf("no aggregator intervals given for %s", filepath.Join(localRoot, layout.Dir))
+		}
+		root := filepath.Join(localRoot, layout.Dir)
+		rec, err := openExisting(&cli.Layout, root, layout)
+		if err != nil {
+			return err
+		}
+		rec.SetPreserveEpochs(true)
+		rec.SetProtocolExt(true)
+		rec.SetPerlYAML(cli.PerlYAML)
+		rec.SetComment(cli.Comment)
+		cli.Lock.Apply(rec)
+		cli.Perms.Apply(rec)
+
+		add, remove := reshapeDiff(rec, layout)
+		principal := rec.PrincipalRecentfile().Rfile()
+		if cli.DryRun {
+			fmt.Printf("%s: add %s, remove %s\n", principal, intervalList(add), intervalList(remove))
+			continue
+		}
+		for _, interval := range add {
+			if err := rec.AddInterval(interval); err != nil {
+				return err
+			}
+			log.Info("interval added", "principal", principal, "interval", interval)
+		}
+		for _, interval := range remove {
+			if err := rec.RemoveInterval(interval); err != nil {
+				return err
+			}
+			log.Info("interval removed", "principal", principal, "interval", interval)
+		}
+	}
+	return nil
+}
+
+// reshapeDiff returns the intervals rec lacks and those it has beyond the
+// aggregator of layout. The principal's interval may be listed or not.
+func reshapeDiff(rec *recent.Recent, layout recent.Layout) (add, remove []string) {
+	current := rec.Intervals()
+	principal := current[0]
+	for _, interval := range layout.Aggregator {
+		if interval != principal && !slices.Contains(current, interval) && !slices.Contains(add, interval) {
+			add = append(add, interval)
+		}
+	}
+	for _, interval := range current[1:] {
+		if !slices.Contains(layout.Aggregator, interval) {
+			remove = append(remove, interval)
+		}
+	}
+	return add, remove
+}
+
+// intervalList formats intervals for the dry run.
+func intervalList(intervals []string) string {
+	if len(intervals) == 0 {
+		return "none"
+	}
+	return strings.Join(intervals, ",")
+}
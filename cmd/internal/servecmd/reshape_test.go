@@ -0,0 +1,48 @@
+package servecmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+)
+
+func TestReshape(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := flags.Layout{Filenameroot: "RECENT", Interval: "1h", Aggregator: []string{"6h", "1d"}, Format: "yaml"}
+	if err := Init(&InitCLI{LocalRoot: tmpDir, Layout: layout, Lock: flags.Lock{LockBackend: "mkdir"}}); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	layout.Aggregator = []string{"1h", "6h", "1W", "Z"}
+	cli := &ReshapeCLI{LocalRoot: tmpDir, Layout: layout, Lock: flags.Lock{LockBackend: "mkdir"}, DryRun: true}
+	if err := Reshape(cli); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-Z.yaml")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote RECENT-Z.yaml: %v", err)
+	}
+
+	cli.DryRun = false
+	if err := Reshape(cli); err != nil {
+		t.Fatalf("Reshape failed: %v", err)
+	}
+	rec, err := recent.New(filepath.Join(tmpDir, "RECENT-1h.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Intervals(); !slices.Equal(got, []string{"1h", "6h", "1W", "Z"}) {
+		t.Errorf("Intervals() = %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-1d.yaml")); !os.IsNotExist(err) {
+		t.Errorf("RECENT-1d.yaml still there: %v", err)
+	}
+
+	cli.Aggregator = nil
+	if err := Reshape(cli); err == nil {
+		t.Error("Reshape without aggregator intervals succeeded")
+	}
+}
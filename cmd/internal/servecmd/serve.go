@@ -0,0 +1,1418 @@
+package servecmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.ntppool.org/common/logger"
+	"go.ntppool.org/common/metricsserver"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/alert"
+	"github.com/abh/rrrgo/api"
+	"github.com/abh/rrrgo/archive"
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/index"
+	"github.com/abh/rrrgo/inject"
+	"github.com/abh/rrrgo/objstore"
+	"github.com/abh/rrrgo/pathfilter"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/sink"
+	"github.com/abh/rrrgo/snapshot"
+	"github.com/abh/rrrgo/watcher"
+)
+
+// CLI defines the command-line interface for rrr-server and rrr serve.
+type CLI struct {
+	LocalRoot string          `arg:"" optional:"" help:"Local root directory to watch (or local_root in the config file)." type:"path"`
+	Config    kong.ConfigFlag `help:"Read settings not given on the command line from this YAML file, and again on SIGHUP." type:"path"`
+
+	flags.Layout
+	flags.Sign
+
+	BatchSize   int           `default:"1000" help:"Maximum batch size before flushing events."`
+	BatchDelay  time.Duration `default:"1s" help:"Maximum delay before flushing events."`
+	QuietPeriod time.Duration `help:"Record a changed file only once it has had no further change for this long, so files written continuously, such as logs, don't get an event with every batch; deletes are recorded at once. Disabled when 0."`
+
+	WriteInterval  time.Duration `help:"Keep the principal RECENT file in memory and write it this often instead of on every batch, for high event rates; disabled when 0. No other process may update the files meanwhile."`
+	WriteMaxEvents int           `default:"10000" help:"With --write-interval, write the principal RECENT file early once this many events are pending."`
+
+	AggregateInterval time.Duration `default:"5m" help:"How often to run aggregation."`
+	RescanInterval    time.Duration `help:"Rescan the tree this often and record changes the watcher missed; disabled when 0."`
+	flags.Write
+
+	flags.Filter
+
+	WatcherBackend string        `default:"fsnotify" enum:"fsnotify,fanotify,fsevents,readdirchanges,poll" help:"How to detect changes: fsnotify (inotify), fanotify (Linux, one mark for the whole filesystem), fsevents (macOS), readdirchanges (Windows, one handle for the whole tree) or poll, which scans the tree every --poll-interval (for NFS)."`
+	PollInterval   time.Duration `default:"10s" help:"How often the poll backend scans the tree."`
+	DirEvents      bool          `help:"Record directories created and removed as events marked dir: true (a protocol extension), so clients such as rrr mirror recreate empty directories."`
+	IgnoreChmod    bool          `help:"Don't record files whose permissions alone changed, so mirrors don't fetch unchanged content again; still recorded with --protocol-ext, whose events carry modes and owners."`
+
+	EventFeed    string `help:"Read change events as NDJSON from this named pipe or file (\"-\" for stdin) instead of using inotify."`
+	InjectSocket string `help:"Accept new/delete events from producers as NDJSON on this UNIX socket." type:"path"`
+	JournalDir   string `help:"Journal accepted events in this directory, outside the local root, and replay them after a crash." type:"path"`
+
+	MetricsPort    int           `default:"9090" help:"Port for metrics server; /status there reports the state of each hierarchy as JSON."`
+	StatusFile     string        `help:"Write the state of each hierarchy as JSON to this file outside the local root." type:"path"`
+	StatusInterval time.Duration `default:"30s" help:"How often to write --status-file."`
+	ExpvarPort     int           `help:"Port for /debug/vars (expvar); disabled when 0."`
+	APIPort        int           `name:"api-port" help:"Port for the HTTP query API; disabled when 0."`
+	LogLevel       string        `default:"info" help:"Log level (debug, info, warn, error)."`
+
+	InitialScan bool `aliases:"seed" help:"Populate an empty hierarchy from the files already in the local root, using their modification times as epochs."`
+
+	flags.Lock
+	flags.Perms
+	BumpDirtymark bool `help:"Set the dirtymark of every RECENT file to now, forcing downstream mirrors into a full re-sync, and exit."`
+
+	SkipFsck       bool          `help:"Skip startup integrity check."`
+	FsckRepair     bool          `help:"Auto-repair issues found during startup fsck."`
+	FsckInterval   time.Duration `help:"Run fsck in the background this often, reporting issues without repairing them; disabled when 0."`
+	FsckAutoRepair []string      `placeholder:"REPAIR" help:"Make these repairs when the background fsck finds issues; only those safe while serving: symlink, order, epochs."`
+
+	NatsURL      string `help:"NATS server URL; publish each committed batch as JSON when set."`
+	NatsSubject  string `default:"rrr.events" help:"NATS subject for published batches."`
+	KafkaRestURL string `help:"Kafka REST Proxy URL; publish each committed batch as JSON when set."`
+	KafkaTopic   string `default:"rrr-events" help:"Kafka topic for published batches."`
+
+	WebhookURL     string `help:"URL to POST a JSON summary of each committed batch to."`
+	WebhookSecret  string `env:"RRR_WEBHOOK_SECRET" help:"Shared secret for HMAC-SHA256 signing of webhook requests."`
+	WebhookRetries int    `default:"3" help:"Retries for failed webhook deliveries."`
+
+	ProcessorCmd     []string      `sep:"none" help:"Run this shell command for each committed batch, with the events as NDJSON on stdin. Can be specified multiple times."`
+	OnNew            []string      `sep:"none" placeholder:"CMD" help:"Run this shell command for each new or changed file once its batch is committed, with the path in RRR_PATH and RRR_FILE. Can be specified multiple times."`
+	OnDelete         []string      `sep:"none" placeholder:"CMD" help:"Run this shell command for each deleted file once its batch is committed, with the path in RRR_PATH and RRR_FILE. Can be specified multiple times."`
+	ProcessorTimeout time.Duration `default:"5m" help:"Kill processor commands and --on-new and --on-delete hooks running longer than this."`
+
+	IndexDB string `help:"Maintain a path lookup database (bbolt) at this location, outside the local root." type:"path"`
+
+	ArchiveDir      string        `help:"Rotate old events out of the Z recentfile into compressed segments in this directory, outside the local root." type:"path"`
+	ArchiveAfter    time.Duration `default:"8760h" help:"Age after which Z events are moved to the archive."`
+	ArchiveInterval time.Duration `default:"24h" help:"How often to rotate old Z events into the archive."`
+
+	ZPruneDeletes    time.Duration `placeholder:"AGE" help:"Compact the Z recentfile every --z-compact-interval, dropping superseded events and delete events older than this; disabled when 0."`
+	ZCompactInterval time.Duration `default:"24h" help:"How often to compact the Z recentfile with --z-prune-deletes."`
+
+	PublishS3 string `name:"publish-s3" placeholder:"s3://BUCKET/PREFIX" help:"Copy the tree and its RECENT files to this S3 bucket after every write and aggregation, so clients can mirror it without an rsync daemon."`
+	flags.S3
+
+	SnapshotCmd      string `help:"Shell command to run after each successful aggregation; RRR_LOCAL_ROOT and RRR_SNAPSHOT_NAME are set."`
+	SnapshotZfs      string `help:"ZFS dataset to snapshot after each successful aggregation."`
+	SnapshotBtrfs    string `help:"Btrfs subvolume to snapshot (read-only) after each successful aggregation." type:"path"`
+	SnapshotBtrfsDir string `help:"Directory to store btrfs snapshots in, outside the subvolume." type:"path"`
+
+	AlertWebhookURL     string        `help:"URL to POST JSON alerts to."`
+	AlertSlackURL       string        `help:"Slack incoming webhook URL for alerts."`
+	AlertSMTPAddr       string        `name:"alert-smtp-addr" help:"SMTP server (host:port) to mail alerts through."`
+	AlertSMTPFrom       string        `name:"alert-smtp-from" help:"Sender address for alert mails."`
+	AlertSMTPTo         []string      `name:"alert-smtp-to" help:"Recipients for alert mails. Can be specified multiple times."`
+	AlertSMTPUser       string        `name:"alert-smtp-user" help:"SMTP username (PLAIN auth)."`
+	AlertSMTPPassword   string        `name:"alert-smtp-password" env:"RRR_ALERT_SMTP_PASSWORD" help:"SMTP password."`
+	AlertFsckIssues     int           `default:"1" help:"Alert when fsck finds at least this many issues (0 disables)."`
+	AlertAggregationLag time.Duration `default:"0" help:"Alert when no aggregation has succeeded for this long (0 disables)."`
+	AlertRepeat         time.Duration `default:"1h" help:"Minimum time between repeated alerts for the same condition."`
+
+	TraceSpans bool `help:"Log an OpenTelemetry span for every batch write, aggregation and merge, with its duration and event counts."`
+	Verbose    bool `short:"v" help:"Enable verbose logging."`
+
+	// The command line, for parsing it again with the config file on
+	// reload
+	args []string
+}
+
+// Root is the top-level command line of rrr-server; serving is the
+// default command.
+type Root struct {
+	Serve     CLI          `cmd:"" default:"withargs" help:"Watch a directory tree and maintain its RECENT files (default)."`
+	Dashboard DashboardCmd `cmd:"" help:"Monitoring dashboard helpers."`
+
+	Version kong.VersionFlag `short:"V" help:"Show version."`
+}
+
+// snapshotTimeout bounds how long a snapshot may hold up event processing.
+const snapshotTimeout = 5 * time.Minute
+
+// publishRetry is how long to wait before retrying a failed --publish-s3.
+const publishRetry = time.Minute
+
+// server holds the application state for rrr-server.
+type server struct {
+	hierarchies  []*hierarchy
+	snapshotters []snapshot.Snapshotter
+	snapshotMu   sync.Mutex
+	alerts       *alert.Dispatcher // nil when no alert destination is configured
+	metrics      *metrics
+	log          *slog.Logger
+
+	// The repairs the background fsck makes, which a reload doesn't change
+	fsckRepairs []string
+
+	// Set once every hierarchy is set up
+	ready atomic.Bool
+}
+
+// hierarchy is one RECENT hierarchy maintained by the server.
+type hierarchy struct {
+	dir        string // relative to the local root
+	rec        *recent.Recent
+	watcher    *watcher.Watcher
+	archiveDir string    // empty unless --archive-dir is set
+	index      *index.DB // nil unless --index-db is set
+
+	// Unix nanoseconds of the last successful aggregation (or startup)
+	lastAggregation atomic.Int64
+
+	// The last fsck run; nil with --skip-fsck until the background fsck runs
+	fsck atomic.Pointer[fsckStatus]
+
+	// Copies the hierarchy to --publish-s3; nil when not set. A value on
+	// publish asks for a copy.
+	publisher *objstore.Publisher
+	publish   chan struct{}
+
+	filesMu sync.Mutex
+	files   map[string]recentfileState // aggregated recentfiles by interval
+}
+
+// Run serves as cli says until the process is told to stop. args is the
+// command line cli was parsed from, by NewParser or as rrr serve, for
+// parsing it again on reload. Errors are logged as well as returned.
+func Run(cli *CLI, args []string) error {
+	cli.args = args
+	var configErr error
+	if cli.LocalRoot == "" && cli.Config != "" {
+		cli.LocalRoot, configErr = flags.ConfigLocalRoot(string(cli.Config))
+	}
+
+	// Initialize logger
+	// Set log level via environment variable for logger package
+	if cli.Verbose {
+		os.Setenv("LOG_LEVEL", "DEBUG")
+	} else if cli.LogLevel != "" {
+		os.Setenv("LOG_LEVEL", cli.LogLevel)
+	}
+
+	log := logger.Setup()
+
+	err := configErr
+	if err == nil {
+		err = run(context.Background(), cli, log)
+	}
+	if err != nil {
+		log.Error("fatal error", "error", err)
+	}
+	return err
+}
+
+func run(ctx context.Context, cli *CLI, log *slog.Logger) error {
+	// Validate local root
+	if cli.LocalRoot == "" {
+		return fmt.Errorf("no local root given")
+	}
+	localRoot, err := filepath.Abs(cli.LocalRoot)
+	if err != nil {
+		return fmt.Errorf("resolve local root: %w", err)
+	}
+
+	fi, err := os.Stat(localRoot)
+	if err != nil {
+		return fmt.Errorf("stat local root: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("local root is not a directory: %s", localRoot)
+	}
+
+	layouts, err := cli.Layouts()
+	if err != nil {
+		return err
+	}
+	if err := cli.Write.Check(); err != nil {
+		return err
+	}
+	if err := cli.Perms.Check(); err != nil {
+		return err
+	}
+	if len(layouts) > 1 {
+		// Both need a single hierarchy to attach to
+		if cli.IndexDB != "" {
+			return fmt.Errorf("--index-db needs a single hierarchy")
+		}
+		if cli.EventFeed != "" {
+			return fmt.Errorf("--event-feed needs a single hierarchy")
+		}
+	}
+	if cli.EventFeed != "" && cli.WatcherBackend != "fsnotify" {
+		return fmt.Errorf("--event-feed cannot be used with --watcher-backend=%s", cli.WatcherBackend)
+	}
+	if err := cli.Sign.Load(); err != nil {
+		return err
+	}
+
+	if cli.IndexDir != "" && isInside(localRoot, cli.IndexDir) {
+		return fmt.Errorf("index dir %s is inside the local root", cli.IndexDir)
+	}
+	// Rewriting the status file inside the tree would show up as changes
+	if cli.StatusFile != "" && isInside(localRoot, cli.StatusFile) {
+		return fmt.Errorf("status file %s is inside the local root", cli.StatusFile)
+	}
+
+	if len(cli.FsckAutoRepair) > 0 {
+		if cli.FsckInterval <= 0 {
+			return fmt.Errorf("--fsck-auto-repair needs --fsck-interval")
+		}
+		// The other repairs add events behind the watcher's back
+		for _, name := range cli.FsckAutoRepair {
+			if !slices.Contains(backgroundRepairs, name) {
+				return fmt.Errorf("repair %q is not safe while serving (want one of %s)", name, strings.Join(backgroundRepairs, ", "))
+			}
+		}
+		// The principal in memory would overwrite a repaired one
+		if cli.WriteInterval > 0 {
+			return fmt.Errorf("--fsck-auto-repair cannot be used with --write-interval")
+		}
+	}
+
+	// An archived event of a file whose delete is pruned would bring the
+	// file back for readers of the archive
+	if cli.ZPruneDeletes > 0 && cli.ArchiveDir != "" {
+		return fmt.Errorf("--z-prune-deletes cannot be used with --archive-dir")
+	}
+
+	if cli.BumpDirtymark {
+		return bumpDirtymark(cli, localRoot, layouts, log)
+	}
+
+	if cli.TraceSpans {
+		stopTracing := startTracing(log)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := stopTracing(shutdownCtx); err != nil {
+				log.Error("stop tracing", "error", err)
+			}
+		}()
+	}
+
+	log.Info("starting rrr-server",
+		"version", version.Version(),
+		"local_root", localRoot,
+		"cpan", cli.Cpan,
+		"hierarchies", len(layouts),
+		"interval", cli.Interval,
+		"format", cli.Format,
+		"compress", cli.Compress,
+		"encrypted", cli.EncryptKeyfile != "",
+		"aggregator", cli.Aggregator,
+		"batch_size", cli.BatchSize,
+		"batch_delay", cli.BatchDelay,
+		"aggregate_interval", cli.AggregateInterval,
+		"metrics_port", cli.MetricsPort,
+	)
+
+	// Start metrics server
+	metricsSrv := metricsserver.New()
+
+	m := newMetrics(metricsSrv.Registry())
+
+	// Register build_info metric
+	version.RegisterMetric("rrr", metricsSrv.Registry())
+
+	if cli.ExpvarPort > 0 {
+		go func() {
+			log.Info("expvar server starting", "port", cli.ExpvarPort)
+			if err := serveExpvar(ctx, cli.ExpvarPort); err != nil {
+				log.Error("expvar server error", "error", err)
+			}
+		}()
+	}
+
+	snapshotters, err := newSnapshotters(cli)
+	if err != nil {
+		return fmt.Errorf("snapshots: %w", err)
+	}
+
+	alerts, err := newAlertDispatcher(cli)
+	if err != nil {
+		return fmt.Errorf("alerts: %w", err)
+	}
+
+	srv := &server{
+		snapshotters: snapshotters,
+		alerts:       alerts,
+		metrics:      m,
+		log:          log,
+	}
+
+	metricsSrv.Registry().MustRegister(watcherCollector{srv})
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.HandlerFor(metricsSrv.Registry(), promhttp.HandlerOpts{}))
+	metricsMux.HandleFunc("/status", srv.serveStatus)
+	go func() {
+		log.Info("metrics server starting", "port", cli.MetricsPort)
+		if err := serveHTTP(ctx, cli.MetricsPort, metricsMux); err != nil {
+			log.Error("metrics server error", "error", err)
+		}
+	}()
+
+	for _, layout := range layouts {
+		h, stopSinks, err := srv.setupHierarchy(ctx, cli, localRoot, layout)
+		if stopSinks != nil {
+			defer stopSinks()
+		}
+		if err != nil {
+			return err
+		}
+		srv.hierarchies = append(srv.hierarchies, h)
+	}
+	for _, h := range srv.hierarchies {
+		if st := h.fsck.Load(); st != nil {
+			srv.metrics.observeFsck(h.dir, st)
+		}
+	}
+	srv.ready.Store(true)
+
+	if cli.APIPort > 0 {
+		apiSrv := api.New(log)
+		for _, h := range srv.hierarchies {
+			apiSrv.Add(h.dir, h.rec, h.index)
+		}
+		go func() {
+			log.Info("api server starting", "port", cli.APIPort)
+			if err := serveHTTP(ctx, cli.APIPort, apiSrv); err != nil {
+				log.Error("api server error", "error", err)
+			}
+		}()
+	}
+
+	// Start watchers
+	for i, h := range srv.hierarchies {
+		if err := h.watcher.Start(); err != nil {
+			for _, started := range srv.hierarchies[:i] {
+				started.watcher.Stop()
+			}
+			return fmt.Errorf("start watcher for %s: %w", h.rec.LocalRoot(), err)
+		}
+		log.Info("watcher started", "root", h.rec.LocalRoot())
+	}
+
+	// Start background jobs: archivers, Z compaction, fsck and the aggregation lag watch
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	var background sync.WaitGroup
+	for _, h := range srv.hierarchies {
+		if h.archiveDir == "" {
+			continue
+		}
+		background.Add(1)
+		go func(h *hierarchy) {
+			defer background.Done()
+			srv.runArchiver(backgroundCtx, h, cli.ArchiveAfter, cli.ArchiveInterval)
+		}(h)
+	}
+
+	if cli.ZPruneDeletes > 0 {
+		for _, h := range srv.hierarchies {
+			background.Add(1)
+			go func(h *hierarchy) {
+				defer background.Done()
+				srv.runCompactor(backgroundCtx, cli, h)
+			}(h)
+		}
+	}
+
+	if cli.FsckInterval > 0 {
+		srv.fsckRepairs = cli.FsckAutoRepair
+		for _, h := range srv.hierarchies {
+			background.Add(1)
+			go func(h *hierarchy) {
+				defer background.Done()
+				srv.runFsck(backgroundCtx, cli, h)
+			}(h)
+		}
+	}
+
+	for _, h := range srv.hierarchies {
+		if h.publisher == nil {
+			continue
+		}
+		background.Add(1)
+		go func(h *hierarchy) {
+			defer background.Done()
+			srv.runPublisher(backgroundCtx, h)
+		}(h)
+	}
+
+	if cli.StatusFile != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			srv.runStatusWriter(backgroundCtx, cli.StatusFile, cli.StatusInterval)
+		}()
+	}
+
+	// Watch for stalled aggregation
+	if srv.alerts != nil && cli.AlertAggregationLag > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			srv.watchAggregationLag(backgroundCtx, cli.AlertAggregationLag)
+		}()
+	}
+
+	// Accept injected events
+	if cli.InjectSocket != "" {
+		injector := inject.New(localRoot, log)
+		for _, h := range srv.hierarchies {
+			injector.Add(h.dir, h.rec)
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			log.Info("injection socket listening", "socket", cli.InjectSocket)
+			if err := injector.Serve(backgroundCtx, cli.InjectSocket); err != nil {
+				log.Error("injection socket error", "error", err)
+			}
+		}()
+	}
+
+	// Start metrics reporter
+	stopMetrics := make(chan struct{})
+	metricsDone := make(chan struct{})
+	go srv.metricsReporter(stopMetrics, metricsDone)
+
+	// Wait for shutdown signal, reloading the config file on SIGHUP
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	current := cli
+	sig := <-sigChan
+	for sig == syscall.SIGHUP {
+		current = srv.reload(current)
+		sig = <-sigChan
+	}
+	log.Info("received shutdown signal", "signal", sig.String())
+
+	// Stop metrics reporter
+	close(stopMetrics)
+	<-metricsDone
+
+	stopBackground()
+	background.Wait()
+
+	// A second signal cuts the remaining writes short instead of waiting
+	// for locks
+	shutdownCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	go func() {
+		for {
+			select {
+			case sig := <-sigChan:
+				if sig == syscall.SIGHUP {
+					continue
+				}
+				log.Warn("received second signal, aborting shutdown", "signal", sig.String())
+				abort()
+			case <-shutdownCtx.Done():
+			}
+			return
+		}
+	}()
+
+	for _, h := range srv.hierarchies {
+		// Stop watcher
+		if err := h.watcher.StopContext(shutdownCtx); err != nil {
+			return fmt.Errorf("stop watcher: %w", err)
+		}
+
+		log.Info("watcher stopped", "root", h.rec.LocalRoot())
+
+		// Final aggregation
+		log.Info("running final aggregation", "root", h.rec.LocalRoot())
+		if err := h.rec.AggregateContext(shutdownCtx, false); err != nil {
+			return fmt.Errorf("final aggregation: %w", err)
+		}
+		srv.snapshot(h.rec.LocalRoot())
+		if h.publisher != nil {
+			if err := srv.publish(shutdownCtx, h); err != nil {
+				log.Error("final publish failed", "root", h.rec.LocalRoot(), "error", err)
+			}
+		}
+
+		stats := h.rec.Stats()
+		log.Info("shutdown complete",
+			"root", h.rec.LocalRoot(),
+			"total_events", stats.TotalEvents,
+			"intervals", stats.Intervals,
+		)
+	}
+
+	return nil
+}
+
+// setupHierarchy loads (or creates) the hierarchy described by layout, checks
+// it and prepares its watcher. The returned func, when non-nil, stops the
+// hierarchy's event publishers and must be called even if err is set.
+func (s *server) setupHierarchy(ctx context.Context, cli *CLI, localRoot string, layout recent.Layout) (*hierarchy, func(), error) {
+	log := s.log
+
+	root := filepath.Join(localRoot, layout.Dir)
+	if fi, err := os.Stat(root); err != nil {
+		return nil, nil, fmt.Errorf("stat hierarchy root: %w", err)
+	} else if !fi.IsDir() {
+		return nil, nil, fmt.Errorf("hierarchy root is not a directory: %s", root)
+	}
+
+	var archiveDir string
+	if cli.ArchiveDir != "" {
+		// Segments written inside the watched tree would show up as changes
+		if isInside(localRoot, cli.ArchiveDir) {
+			return nil, nil, fmt.Errorf("archive dir %s is inside the local root", cli.ArchiveDir)
+		}
+		archiveDir = filepath.Join(cli.ArchiveDir, layout.Dir)
+	}
+
+	filter, err := cli.Filter.New()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var journal string
+	if cli.JournalDir != "" {
+		if isInside(localRoot, cli.JournalDir) {
+			return nil, nil, fmt.Errorf("journal dir %s is inside the local root", cli.JournalDir)
+		}
+		journal = filepath.Join(cli.JournalDir, layout.Dir, "journal.ndjson")
+	}
+
+	rec, err := openRecent(cli, root, layout, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.metrics != nil {
+		rec.SetLockObserver(func(lw recentfile.LockWait) {
+			s.metrics.observeLock(layout.Dir, lw)
+		})
+	}
+
+	log.Info("recent collection loaded", "collection", rec.String())
+
+	// Seed before fsck, which would otherwise report every file as missing
+	if cli.InitialScan {
+		if err := initialScan(rec, filter, log); err != nil {
+			return nil, nil, fmt.Errorf("initial scan: %w", err)
+		}
+	}
+
+	if archiveDir != "" && rec.RecentfileByInterval("Z") == nil {
+		return nil, nil, fmt.Errorf("--archive-dir needs a Z interval in the aggregator")
+	}
+	if cli.ZPruneDeletes > 0 && rec.RecentfileByInterval("Z") == nil {
+		return nil, nil, fmt.Errorf("--z-prune-deletes needs a Z interval in the aggregator")
+	}
+
+	// Run startup fsck (unless --skip-fsck)
+	var fsckResult *fsck.Result
+	if !cli.SkipFsck {
+		log.Info("running startup fsck", "root", root, "auto_repair", cli.FsckRepair)
+
+		fsckOpts := fsck.Options{
+			Repair:     cli.FsckRepair,
+			SkipEvents: false, // Full check by default
+			Verbose:    cli.Verbose,
+			ArchiveDir: archiveDir,
+			Filter:     filter,
+			Logger:     log,
+		}
+
+		result, err := fsck.Run(rec, fsckOpts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("startup fsck failed: %w", err)
+		}
+		fsckResult = result
+
+		s.fsckAlert(root, result.Issues, cli.AlertFsckIssues)
+
+		if result.Issues > 0 {
+			if cli.FsckRepair {
+				log.Info("startup fsck repaired issues", "issues", result.Issues)
+			} else {
+				// Issues found but not repaired - fail startup
+				return nil, nil, fmt.Errorf("startup fsck of %s found %d issues (use --fsck-repair to auto-fix)", root, result.Issues)
+			}
+		} else {
+			log.Debug("startup fsck completed with no issues")
+		}
+	} else {
+		log.Info("skipping startup fsck")
+	}
+
+	h := &hierarchy{dir: layout.Dir, rec: rec, archiveDir: archiveDir}
+	if fsckResult != nil {
+		h.recordFsck(fsckResult)
+	}
+
+	if cli.PublishS3 != "" {
+		store, err := newS3Store(cli)
+		if err != nil {
+			return nil, nil, fmt.Errorf("publish: %w", err)
+		}
+		h.publisher = objstore.NewPublisher(store.Sub(layout.Dir), rec, log)
+		h.publish = make(chan struct{}, 1)
+	}
+
+	// Start event publishers before the watcher so no batch is missed
+	stopSinks, err := startSinks(ctx, cli, h, log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("start sinks: %w", err)
+	}
+
+	h.lastAggregation.Store(time.Now().UnixNano())
+
+	// Create watcher
+	watcherOpts := []watcher.Option{
+		watcher.WithBatchSize(cli.BatchSize),
+		watcher.WithBatchDelay(cli.BatchDelay),
+		watcher.WithQuietPeriod(cli.QuietPeriod),
+		watcher.WithAggregateInterval(cli.AggregateInterval),
+		watcher.WithVerbose(cli.Verbose),
+		watcher.WithBackend(cli.WatcherBackend),
+		watcher.WithPollInterval(cli.PollInterval),
+		watcher.WithDirEvents(cli.DirEvents),
+		watcher.WithIgnoreChmod(cli.IgnoreChmod),
+		watcher.WithArchiveDir(archiveDir),
+		watcher.WithIgnorePatterns(cli.Ignore...),
+		watcher.WithIncludePatterns(cli.Include...),
+		watcher.WithErrorHandler(func(err error) {
+			log.Error("watcher error", "root", root, "error", err)
+		}),
+		watcher.WithEventCallback(func(eventType string, count int) {
+			s.metrics.addEvents(eventType, count)
+		}),
+		watcher.WithFlushCallback(func(events int, duration time.Duration) {
+			s.metrics.observeFlush(duration)
+			h.triggerPublish()
+		}),
+		watcher.WithAggregationCallback(func(duration time.Duration) {
+			h.lastAggregation.Store(time.Now().UnixNano())
+			s.metrics.observeAggregation(duration)
+			stats := rec.Stats()
+			log.Info("aggregation complete",
+				"root", root,
+				"duration", duration,
+				"total_events", stats.TotalEvents,
+			)
+			// Runs on the watcher goroutine, so no batch is written mid-snapshot
+			s.snapshot(root)
+			h.triggerPublish()
+		}),
+		watcher.WithAggregationSkipCallback(func(held []string) {
+			s.metrics.skipAggregation(layout.Dir)
+			log.Warn("aggregation skipped, RECENT files locked by another process",
+				"root", root,
+				"intervals", held,
+			)
+		}),
+	}
+
+	if cli.EventFeed != "" {
+		feed, err := watcher.OpenFeedSource(root, cli.EventFeed)
+		if err != nil {
+			return nil, stopSinks, fmt.Errorf("open event feed: %w", err)
+		}
+		log.Info("reading events from feed", "feed", cli.EventFeed)
+		watcherOpts = append(watcherOpts, watcher.WithEventSource(feed))
+	}
+
+	if journal != "" {
+		watcherOpts = append(watcherOpts, watcher.WithJournal(journal))
+	}
+
+	// The callback is set even when rescans are disabled, in case a reload
+	// enables them
+	watcherOpts = append(watcherOpts,
+		watcher.WithRescanInterval(cli.RescanInterval),
+		watcher.WithRescanCallback(func(corrected int, duration time.Duration) {
+			if corrected > 0 {
+				log.Info("rescan corrected missed changes", "root", root, "events", corrected, "duration", duration)
+				return
+			}
+			log.Debug("rescan complete", "root", root, "duration", duration)
+		}),
+	)
+
+	w, err := watcher.New(rec, watcherOpts...)
+	if err != nil {
+		return nil, stopSinks, fmt.Errorf("create watcher: %w", err)
+	}
+
+	h.watcher = w
+
+	return h, stopSinks, nil
+}
+
+// openRecent creates or loads the Recent collection for layout at root and
+// applies the command line settings for writing it.
+func openRecent(cli *CLI, root string, layout recent.Layout, log *slog.Logger) (*recent.Recent, error) {
+	rec, err := loadRecent(&cli.Layout, root, layout, log, cli.Perms.Options()...)
+	if err != nil {
+		return nil, err
+	}
+	applySettings(cli, rec)
+	return rec, nil
+}
+
+// loadRecent creates or loads the Recent collection for layout at root,
+// with its RECENT files named and placed as l says. A new one is created
+// with opts.
+func loadRecent(l *flags.Layout, root string, layout recent.Layout, log *slog.Logger, opts ...recentfile.Option) (*recent.Recent, error) {
+	indexDir := root
+	if l.IndexDir != "" {
+		indexDir = filepath.Join(l.IndexDir, layout.Dir)
+		if err := os.MkdirAll(indexDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create index dir: %w", err)
+		}
+	}
+	rec, err := createOrLoadRecent(root, indexDir, l.Filenameroot, layout.Interval, layout.Format, layout.Aggregator, log, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create/load recent: %w", err)
+	}
+	return rec, nil
+}
+
+// bumpDirtymark sets the dirtymark of every hierarchy to now, so mirrors
+// do a full re-sync.
+func bumpDirtymark(cli *CLI, localRoot string, layouts []recent.Layout, log *slog.Logger) error {
+	dirtymark := recentfile.EpochNow()
+	for _, layout := range layouts {
+		root := filepath.Join(localRoot, layout.Dir)
+		rec, err := openRecent(cli, root, layout, log)
+		if err != nil {
+			return err
+		}
+		if err := rec.SetDirtymark(dirtymark); err != nil {
+			return fmt.Errorf("bump dirtymark of %s: %w", root, err)
+		}
+		log.Info("dirtymark bumped", "root", root, "dirtymark", dirtymark)
+	}
+	return nil
+}
+
+// initialScan seeds rec from the files on disk unless it already has events.
+func initialScan(rec *recent.Recent, filter *pathfilter.Filter, log *slog.Logger) error {
+	for _, err := range rec.News(0, recent.NewsMax(1)) {
+		if err != nil {
+			return err
+		}
+		log.Debug("hierarchy has events, skipping initial scan", "root", rec.LocalRoot())
+		return nil
+	}
+
+	log.Info("scanning local root to seed the hierarchy", "root", rec.LocalRoot())
+	start := time.Now()
+	n, err := rec.Seed(filter)
+	if err != nil {
+		return err
+	}
+	log.Info("initial scan complete", "root", rec.LocalRoot(), "files", n, "duration", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// formatSuffix returns the file name suffix of RECENT files in format,
+// e.g. ".yaml.gz" for "yml.gz".
+func formatSuffix(format string) string {
+	suffix := "." + format
+	if rest, ok := strings.CutPrefix(suffix, ".yml"); ok {
+		suffix = ".yaml" + rest
+	}
+	return suffix
+}
+
+// createOrLoadRecent creates a new Recent collection for localRoot with its
+// recentfiles named filenameRoot in indexDir, or loads an existing one. A new
+// principal gets opts too.
+func createOrLoadRecent(localRoot, indexDir, filenameRoot, interval, format string, aggregator []string, log *slog.Logger, opts ...recentfile.Option) (*recent.Recent, error) {
+	suffix := formatSuffix(format)
+	if filenameRoot == "" {
+		filenameRoot = "RECENT"
+	}
+
+	// Check if principal recentfile exists
+	principalPath := filepath.Join(indexDir, fmt.Sprintf("%s-%s%s", filenameRoot, interval, suffix))
+
+	if _, err := os.Stat(principalPath); os.IsNotExist(err) {
+		// Create new Recent collection
+		log.Info("creating new recent collection", "principal", principalPath)
+
+		principal := recentfile.New(append([]recentfile.Option{
+			recentfile.WithLocalRoot(localRoot),
+			recentfile.WithIndexDir(indexDir),
+			recentfile.WithFilenameRoot(filenameRoot),
+			recentfile.WithInterval(interval),
+			recentfile.WithSerializerSuffix(suffix),
+			recentfile.WithAggregator(aggregator),
+		}, opts...)...)
+
+		rec, err := recent.NewWithPrincipal(principal)
+		if err != nil {
+			return nil, fmt.Errorf("new with principal: %w", err)
+		}
+
+		// Ensure all files exist
+		if err := rec.EnsureFilesExist(); err != nil {
+			return nil, fmt.Errorf("ensure files exist: %w", err)
+		}
+
+		return rec, nil
+	}
+
+	// Load existing Recent collection
+	log.Info("loading existing recent collection", "principal", principalPath)
+
+	rec, err := recent.NewWithLocalRoot(principalPath, localRoot)
+	if err != nil {
+		return nil, fmt.Errorf("load recent: %w", err)
+	}
+
+	// Load all recentfiles from disk
+	if err := rec.LoadAll(); err != nil {
+		return nil, fmt.Errorf("load all: %w", err)
+	}
+
+	return rec, nil
+}
+
+// startSinks creates the configured event publishers and runs each one in
+// the background. The returned func stops them and waits for them to finish.
+func startSinks(ctx context.Context, cli *CLI, h *hierarchy, log *slog.Logger) (func(), error) {
+	rec := h.rec
+	var sinks []sink.Sink
+
+	if cli.NatsURL != "" {
+		s, err := sink.NewNATS(cli.NatsURL, cli.NatsSubject, rec.LocalRoot())
+		if err != nil {
+			return nil, fmt.Errorf("nats: %w", err)
+		}
+		log.Info("publishing batches to nats", "url", cli.NatsURL, "subject", cli.NatsSubject)
+		sinks = append(sinks, s)
+	}
+
+	if cli.KafkaRestURL != "" {
+		s, err := sink.NewKafkaREST(cli.KafkaRestURL, cli.KafkaTopic, rec.LocalRoot())
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, fmt.Errorf("kafka: %w", err)
+		}
+		log.Info("publishing batches to kafka", "url", cli.KafkaRestURL, "topic", cli.KafkaTopic)
+		sinks = append(sinks, s)
+	}
+
+	if cli.WebhookURL != "" {
+		s, err := sink.NewWebhook(cli.WebhookURL, cli.WebhookSecret, rec.LocalRoot(), cli.WebhookRetries)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, fmt.Errorf("webhook: %w", err)
+		}
+		log.Info("posting batches to webhook", "url", cli.WebhookURL, "signed", cli.WebhookSecret != "")
+		sinks = append(sinks, s)
+	}
+
+	if cli.IndexDB != "" {
+		s, err := openIndexDB(cli.IndexDB, rec, h.archiveDir, log)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, fmt.Errorf("index db: %w", err)
+		}
+		h.index = s
+		sinks = append(sinks, s)
+	}
+
+	for _, command := range cli.ProcessorCmd {
+		s, err := sink.NewExec(command, rec.LocalRoot(), cli.ProcessorTimeout)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, fmt.Errorf("processor: %w", err)
+		}
+		log.Info("running processor for each batch", "command", command)
+		sinks = append(sinks, s)
+	}
+
+	hooks := map[string][]string{"new": cli.OnNew, "delete": cli.OnDelete}
+	for _, eventType := range []string{"new", "delete"} {
+		for _, command := range hooks[eventType] {
+			s, err := sink.NewHook(command, eventType, rec.LocalRoot(), cli.ProcessorTimeout)
+			if err != nil {
+				for _, s := range sinks {
+					s.Close()
+				}
+				return nil, fmt.Errorf("on-%s hook: %w", eventType, err)
+			}
+			log.Info("running hook for each event", "type", eventType, "command", command)
+			sinks = append(sinks, s)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	for _, s := range sinks {
+		wg.Add(1)
+		go func(s sink.Sink) {
+			defer wg.Done()
+			sink.Run(ctx, rec, s, func(err error) {
+				log.Error("sink error", "error", err)
+			})
+		}(s)
+	}
+
+	stop := func() {
+		cancel()
+		wg.Wait()
+		for _, s := range sinks {
+			if err := s.Close(); err != nil {
+				log.Error("close sink", "error", err)
+			}
+		}
+	}
+
+	return stop, nil
+}
+
+// openIndexDB opens the path lookup database and rebuilds it from the
+// recentfiles (and archive, if any) so changes made while the server was
+// down are included.
+func openIndexDB(path string, rec *recent.Recent, archiveDir string, log *slog.Logger) (*index.DB, error) {
+	// Writes to a database inside the watched tree would generate events forever
+	if isInside(rec.LocalRoot(), path) {
+		return nil, fmt.Errorf("%s is inside the local root", path)
+	}
+
+	db, err := index.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := db.Rebuild(rec); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("rebuild: %w", err)
+	}
+
+	if archiveDir != "" {
+		var applyErr error
+		err := archive.StreamEvents(archiveDir, 10000, func(events []recentfile.Event) bool {
+			applyErr = db.Apply(events)
+			return applyErr == nil
+		})
+		if err == nil {
+			err = applyErr
+		}
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("apply archive: %w", err)
+		}
+	}
+
+	paths, _ := db.Len()
+	log.Info("index database ready", "path", path, "paths", paths, "duration", time.Since(start))
+
+	return db, nil
+}
+
+// newSnapshotters creates the configured post-aggregation snapshot hooks.
+func newSnapshotters(cli *CLI) ([]snapshot.Snapshotter, error) {
+	var snapshotters []snapshot.Snapshotter
+
+	if cli.SnapshotCmd != "" {
+		s, err := snapshot.NewExec(cli.SnapshotCmd)
+		if err != nil {
+			return nil, fmt.Errorf("exec: %w", err)
+		}
+		snapshotters = append(snapshotters, s)
+	}
+
+	if cli.SnapshotZfs != "" {
+		s, err := snapshot.NewZFS(cli.SnapshotZfs)
+		if err != nil {
+			return nil, fmt.Errorf("zfs: %w", err)
+		}
+		snapshotters = append(snapshotters, s)
+	}
+
+	if cli.SnapshotBtrfs != "" {
+		s, err := snapshot.NewBtrfs(cli.SnapshotBtrfs, cli.SnapshotBtrfsDir)
+		if err != nil {
+			return nil, fmt.Errorf("btrfs: %w", err)
+		}
+		snapshotters = append(snapshotters, s)
+	}
+
+	return snapshotters, nil
+}
+
+// snapshot runs the snapshot hooks after a successful aggregation of the
+// hierarchy at root. Failures are logged; they don't stop the server.
+func (s *server) snapshot(root string) {
+	if len(s.snapshotters) == 0 {
+		return
+	}
+
+	// Hierarchies aggregate independently; take one snapshot at a time
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	name := snapshot.Name(time.Now())
+	for _, snap := range s.snapshotters {
+		start := time.Now()
+		if err := snap.Snapshot(ctx, root, name); err != nil {
+			s.log.Error("snapshot failed", "root", root, "name", name, "error", err)
+			continue
+		}
+		s.log.Info("snapshot taken", "root", root, "name", name, "duration", time.Since(start))
+	}
+}
+
+// newS3Store creates the store for --publish-s3.
+func newS3Store(cli *CLI) (*objstore.S3, error) {
+	return objstore.NewS3(cli.PublishS3, cli.S3.Options()...)
+}
+
+// triggerPublish asks for h to be published, if it is published at all.
+// It does not wait; requests made while a publish runs are merged into
+// one.
+func (h *hierarchy) triggerPublish() {
+	if h.publish == nil {
+		return
+	}
+	select {
+	case h.publish <- struct{}{}:
+	default:
+	}
+}
+
+// runPublisher publishes h whenever it is asked to, and once at the
+// start, until ctx is done. A failed publish is retried after
+// publishRetry.
+func (s *server) runPublisher(ctx context.Context, h *hierarchy) {
+	h.triggerPublish()
+	for {
+		select {
+		case <-h.publish:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := s.publish(ctx, h); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.log.Error("publish failed", "root", h.rec.LocalRoot(), "error", err)
+			time.AfterFunc(publishRetry, h.triggerPublish)
+		}
+	}
+}
+
+// publish copies the changes to h since the last publish to the store.
+func (s *server) publish(ctx context.Context, h *hierarchy) error {
+	start := time.Now()
+	stats, err := h.publisher.Publish(ctx)
+	if err != nil {
+		return err
+	}
+	if stats.Uploaded > 0 || stats.Deleted > 0 || stats.Recentfiles > 0 {
+		s.log.Info("published",
+			"root", h.rec.LocalRoot(),
+			"full", stats.Full,
+			"uploaded", stats.Uploaded,
+			"deleted", stats.Deleted,
+			"recentfiles", stats.Recentfiles,
+			"duration", time.Since(start).Round(time.Millisecond),
+		)
+	}
+	return nil
+}
+
+// newAlertDispatcher creates the alert dispatcher for the configured
+// destinations, or returns nil if there are none.
+func newAlertDispatcher(cli *CLI) (*alert.Dispatcher, error) {
+	var notifiers []alert.Notifier
+
+	if cli.AlertWebhookURL != "" {
+		n, err := alert.NewWebhook(cli.AlertWebhookURL)
+		if err != nil {
+			return nil, fmt.Errorf("webhook: %w", err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	if cli.AlertSlackURL != "" {
+		n, err := alert.NewSlack(cli.AlertSlackURL)
+		if err != nil {
+			return nil, fmt.Errorf("slack: %w", err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	if cli.AlertSMTPAddr != "" {
+		n, err := alert.NewSMTP(cli.AlertSMTPAddr, cli.AlertSMTPFrom, cli.AlertSMTPTo, cli.AlertSMTPUser, cli.AlertSMTPPassword)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: %w", err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+	return alert.NewDispatcher(cli.AlertRepeat, notifiers...), nil
+}
+
+// alert sends an alert if any destination is configured. Delivery failures
+// are logged.
+func (s *server) alert(key string, a alert.Alert) {
+	if s.alerts == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := s.alerts.Fire(ctx, key, a); err != nil {
+		s.log.Error("send alert", "subject", a.Subject, "error", err)
+	}
+}
+
+// fsckAlert reports an fsck run that found at least threshold issues.
+func (s *server) fsckAlert(root string, issues, threshold int) {
+	key := "fsck:" + root
+	if threshold <= 0 || issues < threshold {
+		if s.alerts != nil {
+			s.alerts.Resolve(key)
+		}
+		return
+	}
+
+	s.alert(key, alert.Alert{
+		Subject: fmt.Sprintf("fsck found %d issues", issues),
+		Message: fmt.Sprintf("fsck of %s found %d issues (alert threshold %d).", root, issues, threshold),
+		Root:    root,
+	})
+}
+
+// backgroundRepairs are the fsck repairs that can be made while the server
+// is writing the hierarchy: they only rewrite files under their lock.
+var backgroundRepairs = []string{fsck.RepairSymlink, fsck.RepairOrder, fsck.RepairEpochs}
+
+// runFsck checks h with fsck every cli.FsckInterval until ctx is done,
+// making only the repairs in cli.FsckAutoRepair. A run in progress is
+// finished before it returns.
+func (s *server) runFsck(ctx context.Context, cli *CLI, h *hierarchy) {
+	ticker := time.NewTicker(cli.FsckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := s.backgroundFsck(cli, h); err != nil {
+			s.log.Error("background fsck failed", "root", h.rec.LocalRoot(), "error", err)
+		}
+	}
+}
+
+// backgroundFsck runs fsck on h and records the result. It reads the
+// RECENT files through a collection of its own, so they are locked as by
+// any other process and the server's copies are left alone.
+func (s *server) backgroundFsck(cli *CLI, h *hierarchy) error {
+	root := h.rec.LocalRoot()
+	rec, err := recent.NewWithLocalRoot(h.rec.PrincipalRecentfile().Rfile(), root)
+	if err != nil {
+		return fmt.Errorf("load recent: %w", err)
+	}
+	applySettings(cli, rec)
+	rec.SetDeferredWrites(0, 0)
+
+	filter, err := cli.Filter.New()
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("running background fsck", "root", root, "auto_repair", cli.FsckAutoRepair)
+	result, err := fsck.Run(rec, fsck.Options{
+		Repair:     len(cli.FsckAutoRepair) > 0,
+		Repairs:    cli.FsckAutoRepair,
+		ArchiveDir: h.archiveDir,
+		Filter:     filter,
+		Logger:     s.log,
+	})
+	if err != nil {
+		return err
+	}
+
+	h.recordFsck(result)
+	s.metrics.observeFsck(h.dir, h.fsck.Load())
+	s.fsckAlert(root, result.Issues, cli.AlertFsckIssues)
+	return nil
+}
+
+// watchAggregationLag alerts when a hierarchy has gone longer than limit
+// without a successful aggregation.
+func (s *server) watchAggregationLag(ctx context.Context, limit time.Duration) {
+	ticker := time.NewTicker(max(min(limit/2, time.Minute), time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, h := range s.hierarchies {
+			root := h.rec.LocalRoot()
+			key := "aggregation-lag:" + root
+			lag := time.Since(time.Unix(0, h.lastAggregation.Load()))
+			if lag < limit {
+				s.alerts.Resolve(key)
+				continue
+			}
+
+			s.alert(key, alert.Alert{
+				Subject: "aggregation lagging",
+				Message: fmt.Sprintf("No successful aggregation of %s for %s (limit %s).",
+					root, lag.Round(time.Second), limit),
+				Root: root,
+			})
+		}
+	}
+}
+
+// isInside reports whether path is root or below it.
+func isInside(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// runArchiver rotates Z events older than maxAge into the hierarchy's
+// archive, once at startup and then every interval until ctx is done.
+func (s *server) runArchiver(ctx context.Context, h *hierarchy, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		segment, err := archive.Rotate(h.rec, h.archiveDir, maxAge)
+		switch {
+		case err != nil:
+			s.log.Error("archive rotation failed", "root", h.rec.LocalRoot(), "error", err)
+		case segment != nil:
+			s.log.Info("archived Z events",
+				"root", h.rec.LocalRoot(),
+				"segment", segment.File,
+				"events", segment.Events,
+				"duration", time.Since(start),
+			)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runCompactor compacts the Z recentfile of the hierarchy, pruning delete
+// events older than --z-prune-deletes, once at startup and then every
+// --z-compact-interval until ctx is done.
+func (s *server) runCompactor(ctx context.Context, cli *CLI, h *hierarchy) {
+	ticker := time.NewTicker(cli.ZCompactInterval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		removed, err := compactZ(cli, h)
+		switch {
+		case err != nil:
+			s.log.Error("Z compaction failed", "root", h.rec.LocalRoot(), "error", err)
+		case removed > 0:
+			s.log.Info("compacted Z recentfile",
+				"root", h.rec.LocalRoot(),
+				"removed", removed,
+				"duration", time.Since(start),
+			)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// compactZ compacts the Z recentfile of h through a collection of its own,
+// as backgroundFsck reads it, so it waits for the lock the archiver or an
+// aggregation holds on the server's copy instead of failing.
+func compactZ(cli *CLI, h *hierarchy) (int, error) {
+	rec, err := recent.NewWithLocalRoot(h.rec.PrincipalRecentfile().Rfile(), h.rec.LocalRoot())
+	if err != nil {
+		return 0, fmt.Errorf("load recent: %w", err)
+	}
+	applySettings(cli, rec)
+	rec.SetDeferredWrites(0, 0)
+	return rec.CompactZ(cli.ZPruneDeletes)
+}
+
+// metricsReporter periodically reports watcher stats to Prometheus.
+func (s *server) metricsReporter(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			var queued int
+			for _, h := range s.hierarchies {
+				stats := h.watcher.Stats()
+				queued += stats.QueuedEvents + stats.BatchSize + stats.HeldEvents
+				s.metrics.setFreshness(h.dir, h.recentfileStates(s.log), time.Now())
+			}
+			s.metrics.setQueued(queued)
+
+		case <-stop:
+			return
+		}
+	}
+}
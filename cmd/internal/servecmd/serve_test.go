@@ -0,0 +1,946 @@
+package servecmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.ntppool.org/common/metricsserver"
+	"go.ntppool.org/common/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/abh/rrrgo/alert"
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestCreateOrLoadRecent(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create a test logger
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	// Test creating new collection (default YAML)
+	rec, err := createOrLoadRecent(tmpDir, tmpDir, "RECENT", "1h", "yaml", []string{"6h", "1d"}, log)
+	if err != nil {
+		t.Fatalf("createOrLoadRecent (new): %v", err)
+	}
+
+	if rec == nil {
+		t.Fatal("createOrLoadRecent returned nil")
+	}
+
+	intervals := rec.Intervals()
+	if len(intervals) != 3 {
+		t.Errorf("expected 3 intervals, got %d", len(intervals))
+	}
+
+	// Verify principal file was created
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+	if _, err := os.Stat(principalPath); err != nil {
+		t.Errorf("principal file not created: %v", err)
+	}
+
+	// Test loading existing collection
+	rec2, err := createOrLoadRecent(tmpDir, tmpDir, "RECENT", "1h", "yaml", []string{"6h", "1d"}, log)
+	if err != nil {
+		t.Fatalf("createOrLoadRecent (load): %v", err)
+	}
+
+	if rec2 == nil {
+		t.Fatal("createOrLoadRecent (load) returned nil")
+	}
+
+	intervals2 := rec2.Intervals()
+	if len(intervals2) != len(intervals) {
+		t.Errorf("loaded collection has %d intervals, expected %d", len(intervals2), len(intervals))
+	}
+}
+
+func TestInitialScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	os.WriteFile(filepath.Join(tmpDir, "existing.txt"), []byte("x"), 0o644)
+
+	rec, err := createOrLoadRecent(tmpDir, tmpDir, "RECENT", "1h", "yaml", []string{"1d", "Z"}, log)
+	if err != nil {
+		t.Fatalf("createOrLoadRecent: %v", err)
+	}
+	if err := initialScan(rec, nil, log); err != nil {
+		t.Fatalf("initialScan: %v", err)
+	}
+	events := rec.PrincipalRecentfile().RecentEvents()
+	if len(events) != 1 || events[0].Path != "existing.txt" {
+		t.Fatalf("events after scan = %+v", events)
+	}
+
+	// Only an empty hierarchy is seeded
+	os.WriteFile(filepath.Join(tmpDir, "later.txt"), []byte("y"), 0o644)
+	if err := initialScan(rec, nil, log); err != nil {
+		t.Fatalf("second initialScan: %v", err)
+	}
+	if events := rec.PrincipalRecentfile().RecentEvents(); len(events) != 1 {
+		t.Errorf("second scan changed events: %+v", events)
+	}
+}
+
+func TestBumpDirtymark(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, dir := range []string{"authors", "modules"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cli := &CLI{Write: flags.Write{Retention: true}, BumpDirtymark: true}
+	before := recentfile.EpochNow()
+	if err := bumpDirtymark(cli, tmpDir, recent.CPANLayout(), log); err != nil {
+		t.Fatalf("bumpDirtymark: %v", err)
+	}
+
+	// Every file of both hierarchies gets the same dirtymark
+	var dirtymark recentfile.Epoch
+	for _, layout := range recent.CPANLayout() {
+		root := filepath.Join(tmpDir, layout.Dir)
+		rec, err := createOrLoadRecent(root, root, "RECENT", layout.Interval, layout.Format, layout.Aggregator, log)
+		if err != nil {
+			t.Fatalf("createOrLoadRecent: %v", err)
+		}
+		for _, rf := range rec.Recentfiles() {
+			got := rf.Meta().Dirtymark
+			if recentfile.EpochLt(got, before) || (!dirtymark.IsZero() && got != dirtymark) {
+				t.Errorf("%s dirtymark = %v (bumped at %v)", rf.Rfile(), got, before)
+			}
+			dirtymark = got
+		}
+	}
+}
+
+func TestIndexDir(t *testing.T) {
+	root := t.TempDir()
+	indexDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{IndexDir: indexDir}}
+	layout := recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"}
+	rec, err := openRecent(cli, root, layout, log)
+	if err != nil {
+		t.Fatalf("openRecent: %v", err)
+	}
+	if err := rec.Update(filepath.Join(root, "a/b.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(indexDir, "RECENT-1h.yaml")); err != nil {
+		t.Errorf("principal not in the index dir: %v", err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("local root has %d entries, want none", len(entries))
+	}
+
+	// Loading it again keeps the local root
+	rec2, err := openRecent(cli, root, layout, log)
+	if err != nil {
+		t.Fatalf("openRecent (load): %v", err)
+	}
+	if rec2.LocalRoot() != root || rec2.IndexDir() != indexDir {
+		t.Errorf("LocalRoot() = %s, IndexDir() = %s", rec2.LocalRoot(), rec2.IndexDir())
+	}
+	events := rec2.PrincipalRecentfile().RecentEvents()
+	if len(events) != 1 || events[0].Path != "a/b.txt" {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestFilenameroot(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "MYRECENT", Comment: "test tree"}}
+	layout := recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"}
+	rec, err := openRecent(cli, tmpDir, layout, log)
+	if err != nil {
+		t.Fatalf("openRecent: %v", err)
+	}
+	if err := rec.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	rf, err := recentfile.NewFromFile(filepath.Join(tmpDir, "MYRECENT-1h.yaml"))
+	if err != nil {
+		t.Fatalf("NewFromFile: %v", err)
+	}
+	if meta := rf.Meta(); meta.Filenameroot != "MYRECENT" || meta.Comment != "test tree" {
+		t.Errorf("filenameroot = %q, comment = %q", meta.Filenameroot, meta.Comment)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-1h.yaml")); !os.IsNotExist(err) {
+		t.Errorf("RECENT-1h.yaml exists: %v", err)
+	}
+
+	// A second hierarchy with the default name shares the directory
+	cli.Filenameroot = "RECENT"
+	if _, err := openRecent(cli, tmpDir, layout, log); err != nil {
+		t.Fatalf("openRecent (RECENT): %v", err)
+	}
+	for _, name := range []string{"RECENT-1h.yaml", "MYRECENT-6h.yaml"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestHierarchyFlag(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bumping the dirtymark creates the hierarchies and returns
+	cli := &CLI{
+		LocalRoot: tmpDir,
+		Layout: flags.Layout{
+			Hierarchy:  []string{"a:1h:6h", "b:30m"},
+			Interval:   "1h",
+			Aggregator: []string{"1d"},
+			Format:     "yaml",
+		},
+		Write:          flags.Write{Retention: true},
+		WatcherBackend: "fsnotify",
+		BumpDirtymark:  true,
+	}
+	if err := run(context.Background(), cli, log); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, name := range []string{"a/RECENT-1h.yaml", "a/RECENT-6h.yaml", "b/RECENT-30m.yaml", "b/RECENT-1d.yaml"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cli.Hierarchy = []string{".", "a"}
+	if err := run(context.Background(), cli, log); err == nil {
+		t.Error("run accepted nested hierarchies")
+	}
+}
+
+func TestCreateOrLoadRecentJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create a test logger
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	// Test creating new collection with JSON format
+	rec, err := createOrLoadRecent(tmpDir, tmpDir, "RECENT", "1h", "json", []string{"6h", "1d"}, log)
+	if err != nil {
+		t.Fatalf("createOrLoadRecent (new, JSON): %v", err)
+	}
+
+	if rec == nil {
+		t.Fatal("createOrLoadRecent returned nil")
+	}
+
+	intervals := rec.Intervals()
+	if len(intervals) != 3 {
+		t.Errorf("expected 3 intervals, got %d", len(intervals))
+	}
+
+	// Verify principal file was created with .json extension
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.json")
+	if _, err := os.Stat(principalPath); err != nil {
+		t.Errorf("principal JSON file not created: %v", err)
+	}
+
+	// Verify aggregator files were also created with .json extension
+	aggPath := filepath.Join(tmpDir, "RECENT-6h.json")
+	if _, err := os.Stat(aggPath); err != nil {
+		t.Errorf("aggregator JSON file not created: %v", err)
+	}
+
+	// Test loading existing JSON collection
+	rec2, err := createOrLoadRecent(tmpDir, tmpDir, "RECENT", "1h", "json", []string{"6h", "1d"}, log)
+	if err != nil {
+		t.Fatalf("createOrLoadRecent (load, JSON): %v", err)
+	}
+
+	if rec2 == nil {
+		t.Fatal("createOrLoadRecent (load) returned nil")
+	}
+
+	intervals2 := rec2.Intervals()
+	if len(intervals2) != len(intervals) {
+		t.Errorf("loaded collection has %d intervals, expected %d", len(intervals2), len(intervals))
+	}
+}
+
+func TestCreateOrLoadRecentYAMLDefault(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create a test logger
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	// Test creating new collection with YAML format (default)
+	rec, err := createOrLoadRecent(tmpDir, tmpDir, "RECENT", "1h", "yaml", []string{"6h"}, log)
+	if err != nil {
+		t.Fatalf("createOrLoadRecent (new, YAML): %v", err)
+	}
+
+	if rec == nil {
+		t.Fatal("createOrLoadRecent returned nil")
+	}
+
+	// Verify principal file was created with .yaml extension
+	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
+	if _, err := os.Stat(principalPath); err != nil {
+		t.Errorf("principal YAML file not created: %v", err)
+	}
+}
+
+func TestCPANLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	for _, layout := range recent.CPANLayout() {
+		root := filepath.Join(tmpDir, layout.Dir)
+		if err := os.Mkdir(root, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+
+		rec, err := createOrLoadRecent(root, root, "RECENT", layout.Interval, layout.Format, layout.Aggregator, log)
+		if err != nil {
+			t.Fatalf("createOrLoadRecent (%s): %v", layout.Dir, err)
+		}
+
+		want := []string{"1h", "6h", "1d", "1W", "1M", "1Q", "1Y", "Z"}
+		if got := rec.Intervals(); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s intervals = %v, want %v", layout.Dir, got, want)
+		}
+
+		for _, interval := range want {
+			path := filepath.Join(root, "RECENT-"+interval+".yaml")
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%s not created: %v", path, err)
+			}
+		}
+	}
+
+	// Nothing is maintained at the top level
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-1h.yaml")); err == nil {
+		t.Error("unexpected top-level RECENT-1h.yaml")
+	}
+}
+
+func TestFsckAlert(t *testing.T) {
+	var subjects []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert.Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		subjects = append(subjects, a.Subject)
+	}))
+	defer hook.Close()
+
+	alerts, err := newAlertDispatcher(&CLI{AlertWebhookURL: hook.URL, AlertRepeat: time.Hour})
+	if err != nil {
+		t.Fatalf("newAlertDispatcher: %v", err)
+	}
+
+	srv := &server{
+		alerts: alerts,
+		log:    slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+	}
+
+	srv.fsckAlert("/srv/mirror", 2, 5) // below threshold
+	srv.fsckAlert("/srv/mirror", 7, 5)
+	srv.fsckAlert("/srv/mirror", 8, 5) // repeat suppressed
+	srv.fsckAlert("/srv/mirror", 0, 5) // resolved
+	srv.fsckAlert("/srv/mirror", 9, 5)
+
+	if strings.Join(subjects, "|") != "fsck found 7 issues|fsck found 9 issues" {
+		t.Errorf("alerts sent: %v", subjects)
+	}
+
+	// No destinations, no dispatcher
+	if d, err := newAlertDispatcher(&CLI{}); d != nil || err != nil {
+		t.Errorf("newAlertDispatcher(empty) = %v, %v", d, err)
+	}
+}
+
+func TestBuildInfoMetric(t *testing.T) {
+	// Create a metrics server with custom registry
+	metricsSrv := metricsserver.New()
+
+	// Register build_info metric
+	version.RegisterMetric("rrr", metricsSrv.Registry())
+
+	// Gather metrics from registry
+	metricFamilies, err := metricsSrv.Registry().Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	// Check if rrr_build_info metric is present
+	found := false
+	var buildInfoMetric string
+	for _, mf := range metricFamilies {
+		if mf.GetName() == "rrr_build_info" {
+			found = true
+			buildInfoMetric = mf.String()
+			break
+		}
+	}
+
+	if !found {
+		t.Error("rrr_build_info metric not found in registry")
+	}
+
+	// Verify the metric has expected labels
+	expectedLabels := []string{"version", "buildtime", "gittime", "git"}
+	for _, label := range expectedLabels {
+		if !strings.Contains(buildInfoMetric, label) {
+			t.Errorf("rrr_build_info metric missing expected label: %s", label)
+		}
+	}
+}
+
+func TestDashboardMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	newMetrics(reg)
+	version.RegisterMetric("rrr", reg)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	registered := make(map[string]bool)
+	for _, mf := range families {
+		registered[mf.GetName()] = true
+	}
+
+	var dashboard struct {
+		Panels []struct {
+			Title   string
+			Targets []struct{ Expr string }
+		}
+	}
+	if err := json.Unmarshal(dashboardJSON, &dashboard); err != nil {
+		t.Fatalf("parse dashboard: %v", err)
+	}
+
+	metricRx := regexp.MustCompile(`rrr_[a-z_]+`)
+	used := 0
+	for _, panel := range dashboard.Panels {
+		for _, target := range panel.Targets {
+			for _, name := range metricRx.FindAllString(target.Expr, -1) {
+				used++
+				name = strings.TrimSuffix(name, "_bucket")
+				if !registered[name] {
+					t.Errorf("panel %q uses unknown metric %s", panel.Title, name)
+				}
+			}
+		}
+	}
+	if used == 0 {
+		t.Error("dashboard doesn't reference any metrics")
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	m := newMetrics(prometheus.NewRegistry())
+
+	before := expvars.Get("events_processed_new").(*expvar.Int).Value()
+	m.addEvents("new", 3)
+	m.setQueued(7)
+
+	if got := expvars.Get("events_processed_new").(*expvar.Int).Value(); got != before+3 {
+		t.Errorf("events_processed_new = %d, want %d", got, before+3)
+	}
+	if got := expvars.Get("events_in_queue").String(); got != "7" {
+		t.Errorf("events_in_queue = %s", got)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := filepath.Join(tmpDir, "rrr-server.yaml")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(config, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig(`local_root: /srv/mirror
+batch-size: 500
+batch_delay: 2s
+ignore: [.git, "*.o"]
+comment: from the config
+retention: false
+`)
+	cli, err := parseServe([]string{"--config", config, "--batch-size", "50"})
+	if err != nil {
+		t.Fatalf("parseServe: %v", err)
+	}
+	if cli.LocalRoot != "/srv/mirror" {
+		t.Errorf("LocalRoot = %q", cli.LocalRoot)
+	}
+	if cli.BatchSize != 50 {
+		t.Errorf("BatchSize = %d, want the command line's 50", cli.BatchSize)
+	}
+	if cli.BatchDelay != 2*time.Second {
+		t.Errorf("BatchDelay = %v", cli.BatchDelay)
+	}
+	if strings.Join(cli.Ignore, ",") != ".git,*.o" {
+		t.Errorf("Ignore = %v", cli.Ignore)
+	}
+	if cli.Interval != "1h" || cli.Retention {
+		t.Errorf("Interval = %q, Retention = %v", cli.Interval, cli.Retention)
+	}
+
+	// An argument wins over local_root
+	if cli, err := parseServe([]string{"--config", config, "/srv/other"}); err != nil || cli.LocalRoot != "/srv/other" {
+		t.Errorf("parseServe with argument = %v, %v", cli, err)
+	}
+
+	// The command line of rrr serve parses the same
+	if cli, err := parseServe([]string{"serve", "--config", config}); err != nil || cli.BatchSize != 500 {
+		t.Errorf("parseServe of rrr serve = %v, %v", cli, err)
+	}
+
+	writeConfig("batch_size: many\n")
+	if _, err := parseServe([]string{"--config", config}); err == nil {
+		t.Error("parseServe accepted a bad batch_size")
+	}
+}
+
+func TestReload(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := filepath.Join(t.TempDir(), "rrr-server.yaml")
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(config, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("comment: before\n")
+	cli, err := parseServe([]string{"--config", config, tmpDir})
+	if err != nil {
+		t.Fatalf("parseServe: %v", err)
+	}
+	srv := &server{log: log}
+	h, stopSinks, err := srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err != nil {
+		t.Fatalf("setupHierarchy: %v", err)
+	}
+	srv.hierarchies = []*hierarchy{h}
+
+	comment := func() string {
+		t.Helper()
+		rf, err := recentfile.NewFromFile(h.rec.PrincipalRecentfile().Rfile())
+		if err != nil {
+			t.Fatalf("NewFromFile: %v", err)
+		}
+		return rf.Meta().Comment
+	}
+
+	writeConfig("comment: after\nbatch_size: 10\n")
+	next := srv.reload(cli)
+	if next == cli || next.Comment != "after" || next.BatchSize != 10 {
+		t.Fatalf("reload returned %+v", next)
+	}
+	if err := h.rec.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := comment(); got != "after" {
+		t.Errorf("comment after reload = %q", got)
+	}
+
+	// A bad pattern keeps the current settings
+	writeConfig("comment: broken\nignore: [\"re:(\"]\n")
+	if got := srv.reload(next); got != next {
+		t.Errorf("reload with a bad pattern returned %+v", got)
+	}
+	if err := h.rec.Update(filepath.Join(tmpDir, "b.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := comment(); got != "after" {
+		t.Errorf("comment after failed reload = %q", got)
+	}
+
+	// Deferred writes would undo the repairs of the background fsck
+	srv.fsckRepairs = []string{"epochs"}
+	writeConfig("comment: after\nwrite_interval: 1m\n")
+	if got := srv.reload(next); got != next {
+		t.Errorf("reload with --write-interval and --fsck-auto-repair returned %+v", got)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "RECENT"}, BatchSize: 100, BatchDelay: time.Second, AggregateInterval: time.Minute}
+	srv := &server{log: log}
+	h, stopSinks, err := srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err != nil {
+		t.Fatalf("setupHierarchy: %v", err)
+	}
+	srv.hierarchies = []*hierarchy{h}
+
+	rec := httptest.NewRecorder()
+	srv.serveStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before setup = %d", rec.Code)
+	}
+	srv.ready.Store(true)
+
+	if err := h.rec.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.serveStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	var st status
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatalf("decode /status: %v", err)
+	}
+	if len(st.Hierarchies) != 1 {
+		t.Fatalf("hierarchies = %+v", st.Hierarchies)
+	}
+	hs := st.Hierarchies[0]
+	if hs.Root != tmpDir || len(hs.Intervals) != 2 || hs.LastAggregation.IsZero() {
+		t.Errorf("hierarchy status = %+v", hs)
+	}
+	if is := hs.Intervals[0]; is.Interval != "1h" || is.Events != 1 || is.NewestEpoch == 0 {
+		t.Errorf("1h status = %+v", is)
+	}
+	if hs.Fsck == nil || hs.Fsck.Issues != 0 {
+		t.Errorf("fsck status = %+v", hs.Fsck)
+	}
+
+	// The status file is replaced as a whole
+	path := filepath.Join(t.TempDir(), "status.json")
+	for range 2 {
+		if err := srv.writeStatus(path); err != nil {
+			t.Fatalf("writeStatus: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &st); err != nil || len(st.Hierarchies) != 1 {
+		t.Errorf("status file = %s (%v)", data, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("status dir holds %d files", len(entries))
+	}
+}
+
+func TestFreshnessMetrics(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	rec, err := openRecent(&CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "RECENT"}}, tmpDir,
+		recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"}, log)
+	if err != nil {
+		t.Fatalf("openRecent: %v", err)
+	}
+	h := &hierarchy{dir: ".", rec: rec}
+	m := newMetrics(prometheus.NewRegistry())
+
+	if err := rec.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	newest := rec.PrincipalRecentfile().RecentEvents()[0].Epoch
+
+	now := time.Now()
+	m.setFreshness(h.dir, h.recentfileStates(log), now.Add(time.Minute))
+	if got := testutil.ToFloat64(m.newestEvent.WithLabelValues(".", "1h")); got != recentfile.EpochToFloat(newest) {
+		t.Errorf("1h newest event = %v, want %v", got, newest)
+	}
+	if got := testutil.ToFloat64(m.recentfileAge.WithLabelValues(".", "1h")); got < 55 || got > 65 {
+		t.Errorf("1h age = %v, want about 60", got)
+	}
+	if n := testutil.CollectAndCount(m.newestEvent); n != 1 {
+		t.Errorf("%d newest event series before aggregation, want only 1h", n)
+	}
+
+	// Aggregated recentfiles are read again once they changed on disk
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	m.setFreshness(h.dir, h.recentfileStates(log), time.Now())
+	if got := testutil.ToFloat64(m.newestEvent.WithLabelValues(".", "6h")); got != recentfile.EpochToFloat(newest) {
+		t.Errorf("6h newest event = %v, want %v", got, newest)
+	}
+	if got := testutil.ToFloat64(m.recentfileEvents.WithLabelValues(".", "6h")); got != 1 {
+		t.Errorf("6h events = %v", got)
+	}
+}
+
+func TestWatcherMetrics(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Mkdir(filepath.Join(tmpDir, "sub"), 0o755)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "RECENT"}, BatchSize: 100, BatchDelay: time.Second, SkipFsck: true}
+	srv := &server{log: log, metrics: newMetrics(prometheus.NewRegistry())}
+	h, stopSinks, err := srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err != nil {
+		t.Fatalf("setupHierarchy: %v", err)
+	}
+	srv.hierarchies = []*hierarchy{h}
+	collector := watcherCollector{srv}
+
+	// Locks taken for writing are observed
+	if err := h.rec.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if n := testutil.CollectAndCount(srv.metrics.lockWait); n != 1 {
+		t.Errorf("%d lock wait series, want 1", n)
+	}
+	if got := testutil.ToFloat64(srv.metrics.lockRetries.WithLabelValues(".", "1h")); got != 0 {
+		t.Errorf("lock retries = %v", got)
+	}
+
+	if n := testutil.CollectAndCount(collector); n != 0 {
+		t.Errorf("%d metrics before setup, want 0", n)
+	}
+	srv.ready.Store(true)
+
+	if err := h.watcher.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer h.watcher.Stop()
+
+	expected := `
+# HELP rrr_watcher_watched_dirs Directories being watched
+# TYPE rrr_watcher_watched_dirs gauge
+rrr_watcher_watched_dirs{hierarchy="."} 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "rrr_watcher_watched_dirs"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(collector); n != 4 {
+		t.Errorf("%d metrics, want 4", n)
+	}
+}
+
+func TestSpanLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanLogger{log: log}))
+	defer provider.Shutdown(context.Background())
+
+	tracer := provider.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "flushBatch",
+		trace.WithAttributes(attribute.Int("events", 3)))
+	_, child := tracer.Start(ctx, "BatchUpdate")
+	child.SetStatus(codes.Error, "locked")
+	child.End()
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	for _, want := range []string{"msg=span", "name=BatchUpdate", "parent_id=", "error=locked"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("child span line lacks %q: %s", want, lines[0])
+		}
+	}
+	for _, want := range []string{"name=flushBatch", "duration=", "trace_id=", "events=3"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("parent span line lacks %q: %s", want, lines[1])
+		}
+	}
+	if strings.Contains(lines[1], "parent_id=") {
+		t.Errorf("root span logged with a parent: %s", lines[1])
+	}
+}
+
+func TestCompactZWaitsForArchiver(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "RECENT"}, BatchSize: 100, BatchDelay: time.Second, SkipFsck: true, ZPruneDeletes: time.Hour}
+	srv := &server{log: log, metrics: newMetrics(prometheus.NewRegistry())}
+	h, stopSinks, err := srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h", "Z"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err != nil {
+		t.Fatalf("setupHierarchy: %v", err)
+	}
+
+	// The archiver holds the server's Z recentfile for a while
+	z := h.rec.RecentfileByInterval("Z")
+	if err := z.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { z.Unlock() })
+
+	if _, err := compactZ(cli, h); err != nil {
+		t.Errorf("compactZ: %v", err)
+	}
+	if z.Locked() {
+		t.Error("compactZ did not wait for the lock")
+	}
+}
+
+func TestBackgroundFsck(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "RECENT"}, BatchSize: 100, BatchDelay: time.Second, SkipFsck: true}
+	srv := &server{log: log, metrics: newMetrics(prometheus.NewRegistry())}
+	h, stopSinks, err := srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err != nil {
+		t.Fatalf("setupHierarchy: %v", err)
+	}
+
+	link := filepath.Join(tmpDir, "RECENT.recent")
+	os.Remove(link)
+
+	// Read-only
+	if err := srv.backgroundFsck(cli, h); err != nil {
+		t.Fatalf("backgroundFsck: %v", err)
+	}
+	if st := h.fsck.Load(); st == nil || st.IssuesFound["symlink"] != 1 || st.Repaired {
+		t.Errorf("fsck status = %+v", st)
+	}
+	if got := testutil.ToFloat64(srv.metrics.fsckIssues.WithLabelValues(".", "symlink")); got != 1 {
+		t.Errorf("rrr_fsck_issues{check=symlink} = %v, want 1", got)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("symlink repaired without --fsck-auto-repair: %v", err)
+	}
+
+	cli.FsckAutoRepair = []string{"symlink"}
+	if err := srv.backgroundFsck(cli, h); err != nil {
+		t.Fatalf("backgroundFsck: %v", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != "RECENT-1h.yaml" {
+		t.Errorf("symlink after repair = %q (%v)", target, err)
+	}
+	if err := srv.backgroundFsck(cli, h); err != nil {
+		t.Fatalf("backgroundFsck: %v", err)
+	}
+	if got := testutil.ToFloat64(srv.metrics.fsckIssues.WithLabelValues(".", "symlink")); got != 0 {
+		t.Errorf("rrr_fsck_issues{check=symlink} after repair = %v, want 0", got)
+	}
+
+	// Repairs adding events are refused
+	err = run(context.Background(), &CLI{LocalRoot: tmpDir, Layout: flags.Layout{Interval: "1h"}, FsckInterval: time.Hour, FsckAutoRepair: []string{"missing-events"}}, log)
+	if err == nil || !strings.Contains(err.Error(), "not safe") {
+		t.Errorf("run with --fsck-auto-repair=missing-events: %v", err)
+	}
+}
+
+func TestPublishS3(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(data)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, data)
+		}
+	}))
+	defer bucket.Close()
+
+	cli := &CLI{Write: flags.Write{Retention: true}, Layout: flags.Layout{Filenameroot: "RECENT"}, BatchSize: 100, BatchDelay: time.Second, SkipFsck: true,
+		PublishS3: "s3://bucket/mirror", S3: flags.S3{S3Endpoint: bucket.URL, S3Region: "us-east-1"}}
+	srv := &server{log: log, metrics: newMetrics(prometheus.NewRegistry())}
+	h, stopSinks, err := srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err != nil {
+		t.Fatalf("setupHierarchy: %v", err)
+	}
+
+	path := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(path, []byte("a"), 0o644)
+	if err := h.rec.Update(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests while one is pending are merged
+	h.triggerPublish()
+	h.triggerPublish()
+	if len(h.publish) != 1 {
+		t.Errorf("pending publishes = %d, want 1", len(h.publish))
+	}
+
+	if err := srv.publish(context.Background(), h); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	mu.Lock()
+	for _, key := range []string{"/bucket/mirror/a.txt", "/bucket/mirror/RECENT-1h.yaml", "/bucket/mirror/RECENT.recent"} {
+		if _, ok := objects[key]; !ok {
+			t.Errorf("%s not published; objects: %v", key, objects)
+		}
+	}
+	mu.Unlock()
+
+	cli.PublishS3 = "https://bucket/mirror"
+	_, stopSinks, err = srv.setupHierarchy(context.Background(), cli, tmpDir, recent.Layout{Dir: ".", Interval: "1h", Aggregator: []string{"6h"}, Format: "yaml"})
+	if stopSinks != nil {
+		defer stopSinks()
+	}
+	if err == nil {
+		t.Error("setupHierarchy with an https --publish-s3: expected error")
+	}
+}
@@ -0,0 +1,214 @@
+package servecmd
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// status is the server state served at /status and written to
+// --status-file.
+type status struct {
+	Version     string            `json:"version"`
+	Time        time.Time         `json:"time"`
+	Hierarchies []hierarchyStatus `json:"hierarchies"`
+}
+
+// hierarchyStatus is the state of one hierarchy.
+type hierarchyStatus struct {
+	Dir             string           `json:"dir"`
+	Root            string           `json:"root"`
+	QueuedEvents    int              `json:"queued_events"`  // received, not yet written
+	PendingEvents   int              `json:"pending_events"` // written in memory only (--write-interval)
+	LastAggregation time.Time        `json:"last_aggregation"`
+	Intervals       []intervalStatus `json:"intervals"`
+	Fsck            *fsckStatus      `json:"fsck,omitempty"` // nil until fsck has run
+}
+
+// intervalStatus is the state of one recentfile.
+type intervalStatus struct {
+	Interval    string           `json:"interval"`
+	Events      int              `json:"events"`
+	NewestEpoch recentfile.Epoch `json:"newest_epoch,omitempty"`
+}
+
+// fsckStatus is the outcome of the last fsck run of a hierarchy.
+type fsckStatus struct {
+	Time        time.Time      `json:"time"`
+	Issues      int            `json:"issues"`
+	IssuesFound map[string]int `json:"issues_found,omitempty"`
+	Repaired    bool           `json:"repaired"`
+}
+
+// recordFsck keeps the result of an fsck run of h for the status.
+func (h *hierarchy) recordFsck(result *fsck.Result) {
+	h.fsck.Store(&fsckStatus{
+		Time:        time.Now(),
+		Issues:      result.Issues,
+		IssuesFound: result.IssuesFound,
+		Repaired:    result.Repaired,
+	})
+}
+
+// recentfileState is what is known about one recentfile of a hierarchy.
+type recentfileState struct {
+	interval string
+	events   int
+	minmax   *recentfile.MinmaxInfo // nil when there are no events
+
+	// The file these were read from, for aggregated recentfiles
+	modTime time.Time
+	size    int64
+}
+
+// recentfileStates returns the state of each recentfile of h. The
+// principal is taken from memory, which holds events not written yet with
+// --write-interval. Aggregation writes the other recentfiles through
+// copies, so they are read from disk, again only after they changed.
+func (h *hierarchy) recentfileStates(log *slog.Logger) []recentfileState {
+	h.filesMu.Lock()
+	defer h.filesMu.Unlock()
+
+	if h.files == nil {
+		h.files = make(map[string]recentfileState)
+	}
+
+	principal := h.rec.PrincipalRecentfile()
+	var states []recentfileState
+	for _, rf := range h.rec.Recentfiles() {
+		interval := rf.Interval()
+		if rf == principal {
+			states = append(states, recentfileState{
+				interval: interval,
+				events:   len(rf.RecentEvents()),
+				minmax:   rf.Meta().Minmax,
+			})
+			continue
+		}
+
+		cached := h.files[interval]
+		cached.interval = interval
+		fi, err := os.Stat(rf.Rfile())
+		if err != nil || (fi.ModTime().Equal(cached.modTime) && fi.Size() == cached.size) {
+			states = append(states, cached)
+			continue
+		}
+		stats, err := recentfile.StreamEvents(rf.Rfile(), 0, nil)
+		if err != nil {
+			log.Warn("read recentfile state", "file", rf.Rfile(), "error", err)
+			states = append(states, cached)
+			continue
+		}
+		state := recentfileState{
+			interval: interval,
+			events:   stats.EventCount,
+			minmax:   stats.Meta.Minmax,
+			modTime:  fi.ModTime(),
+			size:     fi.Size(),
+		}
+		h.files[interval] = state
+		states = append(states, state)
+	}
+	return states
+}
+
+// status collects the current state of every hierarchy.
+func (s *server) status() status {
+	st := status{
+		Version:     version.Version(),
+		Time:        time.Now(),
+		Hierarchies: make([]hierarchyStatus, 0, len(s.hierarchies)),
+	}
+
+	for _, h := range s.hierarchies {
+		hs := hierarchyStatus{
+			Dir:             h.dir,
+			Root:            h.rec.LocalRoot(),
+			PendingEvents:   h.rec.PrincipalRecentfile().Pending(),
+			LastAggregation: time.Unix(0, h.lastAggregation.Load()),
+			Fsck:            h.fsck.Load(),
+		}
+		if h.watcher != nil {
+			stats := h.watcher.Stats()
+			hs.QueuedEvents = stats.QueuedEvents + stats.BatchSize + stats.HeldEvents
+		}
+		for _, fs := range h.recentfileStates(s.log) {
+			is := intervalStatus{Interval: fs.interval, Events: fs.events}
+			if fs.minmax != nil {
+				is.NewestEpoch = fs.minmax.Max
+			}
+			hs.Intervals = append(hs.Intervals, is)
+		}
+		st.Hierarchies = append(st.Hierarchies, hs)
+	}
+
+	return st
+}
+
+// serveStatus answers /status with the server state as JSON, once every
+// hierarchy is set up.
+func (s *server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.status())
+}
+
+// writeStatus replaces the file at path with the server state as JSON.
+func (s *server) writeStatus(path string) error {
+	data, err := json.MarshalIndent(s.status(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// runStatusWriter writes the status file at path now and then every
+// interval until ctx is done.
+func (s *server) runStatusWriter(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.writeStatus(path); err != nil {
+			s.log.Error("write status file", "path", path, "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package servecmd
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// startTracing installs a tracer provider logging every finished span to
+// log. The returned func logs the remaining spans and stops the provider.
+func startTracing(log *slog.Logger) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanLogger{log: log}),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// spanLogger is a span exporter writing spans to a logger, with their
+// duration and attributes.
+type spanLogger struct {
+	log *slog.Logger
+}
+
+// ExportSpans implements sdktrace.SpanExporter.
+func (e spanLogger) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, span := range spans {
+		args := []any{
+			"name", span.Name(),
+			"duration", span.EndTime().Sub(span.StartTime()),
+			"trace_id", span.SpanContext().TraceID().String(),
+			"span_id", span.SpanContext().SpanID().String(),
+		}
+		if parent := span.Parent(); parent.IsValid() {
+			args = append(args, "parent_id", parent.SpanID().String())
+		}
+		for _, kv := range span.Attributes() {
+			args = append(args, string(kv.Key), kv.Value.AsInterface())
+		}
+		if status := span.Status(); status.Code == codes.Error {
+			args = append(args, "error", status.Description)
+		}
+		e.log.Info("span", args...)
+	}
+	return nil
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (e spanLogger) Shutdown(ctx context.Context) error {
+	return nil
+}
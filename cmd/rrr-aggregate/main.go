@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/cmd/internal/servecmd"
+)
+
+func main() {
+	var cli struct {
+		servecmd.AggregateCLI
+
+		Version kong.VersionFlag `short:"V" help:"Show version."`
+	}
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-aggregate"),
+		kong.Description("Aggregate the RECENT files of a hierarchy once, e.g. from cron"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+		kong.Configuration(flags.YAMLConfig),
+	)
+
+	if err := servecmd.Aggregate(&cli.AggregateCLI); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/cmd/internal/servecmd"
+)
+
+func main() {
+	var cli struct {
+		servecmd.ConvertCLI
+
+		Version kong.VersionFlag `short:"V" help:"Show version."`
+	}
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-convert"),
+		kong.Description("Convert the RECENT files of a hierarchy to another format"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+		kong.Configuration(flags.YAMLConfig),
+	)
+
+	if err := servecmd.Convert(&cli.ConvertCLI); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/abh/rrrgo/cmd/internal/fsckcmd"
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
 )
@@ -93,6 +94,9 @@ func TestFsckMissingFile(t *testing.T) {
 	if err == nil {
 		t.Error("expected fsck to fail with missing file")
 	}
+	if code := cmd.ProcessState.ExitCode(); code != fsckcmd.ExitIssues {
+		t.Errorf("exit code = %d, want %d", code, fsckcmd.ExitIssues)
+	}
 
 	// Check output mentions the missing file
 	outputStr := string(output)
@@ -121,9 +125,9 @@ func TestFsckRepair(t *testing.T) {
 
 	// Run fsck with repair
 	cmd := exec.Command(binPath, principalPath, "--repair", "--verbose")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		t.Errorf("fsck --repair failed: %v\noutput: %s", err, output)
+	output, _ := cmd.CombinedOutput()
+	if code := cmd.ProcessState.ExitCode(); code != fsckcmd.ExitRepaired {
+		t.Errorf("fsck --repair exited with %d, want %d\noutput: %s", code, fsckcmd.ExitRepaired, output)
 	}
 
 	// Check file was recreated
@@ -152,111 +156,3 @@ func TestFsckNonExistent(t *testing.T) {
 		t.Error("expected error message in output")
 	}
 }
-
-func TestRunHealthy(t *testing.T) {
-	rec, tmpDir := setupTestRecent(t)
-
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
-
-	// Add some events and create the files
-	for _, fname := range []string{"file1.txt", "file2.txt"} {
-		testFile := filepath.Join(tmpDir, fname)
-		if err := os.WriteFile(testFile, []byte("test"), 0o644); err != nil {
-			t.Fatalf("create file: %v", err)
-		}
-		if err := rec.Update(fname, "new"); err != nil {
-			t.Fatalf("update: %v", err)
-		}
-	}
-
-	// Run fsck
-	cli := &CLI{
-		PrincipalFile: principalPath,
-		Verbose:       true,
-	}
-
-	if err := run(cli); err != nil {
-		t.Errorf("run failed: %v", err)
-	}
-}
-
-func TestRunMissingFileNoRepair(t *testing.T) {
-	_, tmpDir := setupTestRecent(t)
-
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
-
-	// Delete a file
-	aggregatedPath := filepath.Join(tmpDir, "RECENT-6h.yaml")
-	if err := os.Remove(aggregatedPath); err != nil {
-		t.Fatalf("remove file: %v", err)
-	}
-
-	// Run fsck without repair (should return error)
-	cli := &CLI{
-		PrincipalFile: principalPath,
-		Repair:        false,
-		Verbose:       false,
-	}
-
-	err := run(cli)
-	// Should return an error about issues found
-	if err == nil {
-		t.Error("expected error when issues found without repair")
-	}
-}
-
-func TestRunWithRepair(t *testing.T) {
-	_, tmpDir := setupTestRecent(t)
-
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
-
-	// Delete a file
-	aggregatedPath := filepath.Join(tmpDir, "RECENT-6h.yaml")
-	if err := os.Remove(aggregatedPath); err != nil {
-		t.Fatalf("remove file: %v", err)
-	}
-
-	// Run fsck with repair
-	cli := &CLI{
-		PrincipalFile: principalPath,
-		Repair:        true,
-		Verbose:       true,
-	}
-
-	if err := run(cli); err != nil {
-		t.Errorf("run failed: %v", err)
-	}
-
-	// Check file was recreated
-	if _, err := os.Stat(aggregatedPath); err != nil {
-		t.Errorf("file not recreated: %v", err)
-	}
-}
-
-func TestRunBrokenSymlink(t *testing.T) {
-	rec, tmpDir := setupTestRecent(t)
-
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
-
-	// Create a broken symlink (pointing to non-existent target)
-	symlinkPath := filepath.Join(tmpDir, "broken-link.txt")
-	targetPath := filepath.Join(tmpDir, "nonexistent-target.txt")
-	if err := os.Symlink(targetPath, symlinkPath); err != nil {
-		t.Fatalf("create symlink: %v", err)
-	}
-
-	// Add event for the symlink
-	if err := rec.Update("broken-link.txt", "new"); err != nil {
-		t.Fatalf("update: %v", err)
-	}
-
-	// Run fsck - should not count broken symlink as an error
-	cli := &CLI{
-		PrincipalFile: principalPath,
-		Verbose:       true,
-	}
-
-	if err := run(cli); err != nil {
-		t.Errorf("run failed: %v (broken symlinks should not cause failures)", err)
-	}
-}
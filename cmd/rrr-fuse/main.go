@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfs"
+)
+
+// CLI defines the command-line interface for rrr-fuse.
+type CLI struct {
+	PrincipalFile string `arg:"" help:"Path to principal RECENT file (e.g., RECENT-1h.yaml)." type:"path"`
+	Mountpoint    string `arg:"" help:"Directory to mount the view on." type:"existingdir"`
+
+	Window       time.Duration `short:"w" default:"24h" help:"Show files changed within this long."`
+	Refresh      time.Duration `default:"1m" help:"How often to reread the RECENT files."`
+	CacheTimeout time.Duration `default:"1s" help:"How long the kernel may cache names and attributes."`
+	Verbose      bool          `short:"v" help:"Enable verbose logging."`
+
+	Version kong.VersionFlag `short:"V" help:"Show version."`
+}
+
+func main() {
+	var cli CLI
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-fuse"),
+		kong.Description("Mount a read-only view of recently changed files"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+	)
+
+	if err := run(&cli); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
+
+func run(cli *CLI) error {
+	if cli.Window <= 0 || cli.Refresh <= 0 {
+		return fmt.Errorf("--window and --refresh must be positive")
+	}
+
+	logLevel := slog.LevelInfo
+	if cli.Verbose {
+		logLevel = slog.LevelDebug
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+	principalPath, err := filepath.Abs(cli.PrincipalFile)
+	if err != nil {
+		return fmt.Errorf("resolve principal path: %w", err)
+	}
+
+	rec, err := recent.New(principalPath)
+	if err != nil {
+		return fmt.Errorf("load recent: %w", err)
+	}
+
+	fsys := recentfs.New(rec, cli.Window)
+	if err := fsys.Refresh(); err != nil {
+		return err
+	}
+
+	server, err := fsys.Mount(cli.Mountpoint, cli.CacheTimeout)
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", cli.Mountpoint, err)
+	}
+	log.Info("mounted", "mountpoint", cli.Mountpoint, "local_root", rec.LocalRoot(),
+		"window", cli.Window, "files", fsys.View().Len())
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		ticker := time.NewTicker(cli.Refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("unmounting", "mountpoint", cli.Mountpoint)
+				if err := server.Unmount(); err != nil {
+					log.Error("unmount failed", "error", err)
+				}
+				return
+			case <-ticker.C:
+				if err := fsys.Refresh(); err != nil {
+					log.Error("refresh failed", "error", err)
+					continue
+				}
+				log.Debug("refreshed", "files", fsys.View().Len())
+			}
+		}
+	}()
+
+	server.Wait()
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// CLI defines the command-line interface for rrr-ls.
+type CLI struct {
+	PrincipalFile string `arg:"" help:"Path to principal RECENT file (e.g., RECENT-1h.yaml)." type:"path"`
+
+	Prefix     []string `short:"p" help:"List only paths starting with this prefix (repeatable)."`
+	Since      string   `short:"s" help:"List only paths changed after this epoch, or within this age (e.g., 1712345678.5, 90m, 1d, 1W)."`
+	Until      string   `help:"List only paths not changed after this epoch, or within this age."`
+	Type       string   `short:"t" enum:"new,delete,all" default:"new" help:"List paths whose latest event is of this type: new (the live tree), delete, or all."`
+	ArchiveDir string   `help:"Archive of events rotated out of Z, to include the paths not changed since." type:"path"`
+
+	Long bool `short:"l" xor:"format" help:"Print the epoch, time and event type with each path."`
+	JSON bool `xor:"format" help:"Print events as JSON, one per line."`
+	Null bool `short:"0" xor:"format" help:"End paths with a NUL byte instead of a newline, for rsync --from0."`
+
+	Version kong.VersionFlag `short:"V" help:"Show version."`
+}
+
+func main() {
+	var cli CLI
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-ls"),
+		kong.Description("List the files a RECENT hierarchy says exist"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+	)
+
+	if err := run(&cli, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
+
+func run(cli *CLI, out io.Writer) error {
+	now := time.Now()
+	var since, until recentfile.Epoch
+	if cli.Since != "" {
+		var err error
+		if since, err = recentfile.ParseSince(cli.Since, now); err != nil {
+			return fmt.Errorf("--since: %w", err)
+		}
+	}
+	if cli.Until != "" {
+		var err error
+		if until, err = recentfile.ParseSince(cli.Until, now); err != nil {
+			return fmt.Errorf("--until: %w", err)
+		}
+	}
+
+	principalPath, err := filepath.Abs(cli.PrincipalFile)
+	if err != nil {
+		return fmt.Errorf("resolve principal path: %w", err)
+	}
+
+	rec, err := recent.New(principalPath)
+	if err != nil {
+		return fmt.Errorf("load recent: %w", err)
+	}
+
+	state, err := fsck.IndexState(rec, cli.ArchiveDir)
+	if err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+
+	f := filter{prefixes: cli.Prefix, since: since, until: until, eventType: cli.Type}
+	var events []recentfile.Event
+	for _, event := range state {
+		if f.match(event) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Path < events[j].Path
+	})
+
+	p := &printer{w: bufio.NewWriter(out), long: cli.Long, json: cli.JSON, null: cli.Null}
+	for _, event := range events {
+		p.print(event)
+	}
+	return p.flush()
+}
+
+// filter selects the events to list. Zero fields select everything.
+type filter struct {
+	prefixes  []string
+	since     recentfile.Epoch // latest event after this
+	until     recentfile.Epoch // latest event not after this
+	eventType string           // "new", "delete" or "all"
+}
+
+func (f filter) match(event recentfile.Event) bool {
+	if f.eventType != "" && f.eventType != "all" && event.Type != f.eventType {
+		return false
+	}
+	if !f.since.IsZero() && !recentfile.EpochGt(event.Epoch, f.since) {
+		return false
+	}
+	if !f.until.IsZero() && recentfile.EpochGt(event.Epoch, f.until) {
+		return false
+	}
+	if len(f.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(event.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// printer writes events in the selected format.
+type printer struct {
+	w    *bufio.Writer
+	long bool
+	json bool
+	null bool
+}
+
+func (p *printer) print(event recentfile.Event) {
+	switch {
+	case p.json:
+		data, _ := json.Marshal(event)
+		p.w.Write(data)
+		p.w.WriteByte('\n')
+	case p.long:
+		ts := recentfile.EpochToTime(event.Epoch).UTC()
+		fmt.Fprintf(p.w, "%s  %s  %-6s  %s\n", event.Epoch, ts.Format(time.RFC3339), event.Type, event.Path)
+	case p.null:
+		p.w.WriteString(event.Path)
+		p.w.WriteByte(0)
+	default:
+		fmt.Fprintln(p.w, event.Path)
+	}
+}
+
+func (p *printer) flush() error {
+	if err := p.w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
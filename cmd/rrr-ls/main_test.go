@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func setupRecent(t *testing.T) string {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+		if err := rec.Update(filepath.Join(tmpDir, name), "new"); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	// Moves the events into the 1d file; the listing must look there too
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	if err := rec.Update(filepath.Join(tmpDir, "dir/c.txt"), "delete"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	return filepath.Join(tmpDir, "RECENT-1h.yaml")
+}
+
+func TestRun(t *testing.T) {
+	principal := setupRecent(t)
+
+	tests := []struct {
+		name string
+		cli  CLI
+		want string
+	}{
+		{"live tree", CLI{Type: "new"}, "a.txt\ndir/b.txt\n"},
+		{"deleted", CLI{Type: "delete"}, "dir/c.txt\n"},
+		{"all", CLI{Type: "all"}, "a.txt\ndir/b.txt\ndir/c.txt\n"},
+		{"prefix", CLI{Type: "all", Prefix: []string{"dir/"}}, "dir/b.txt\ndir/c.txt\n"},
+		{"prefixes", CLI{Type: "new", Prefix: []string{"a", "x"}}, "a.txt\n"},
+		{"since", CLI{Type: "all", Since: "1h"}, "a.txt\ndir/b.txt\ndir/c.txt\n"},
+		{"until", CLI{Type: "all", Until: "1h"}, ""},
+		{"null", CLI{Type: "new", Null: true}, "a.txt\x00dir/b.txt\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cli.PrincipalFile = principal
+			var out bytes.Buffer
+			if err := run(&tt.cli, &out); err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFormats(t *testing.T) {
+	principal := setupRecent(t)
+
+	var out bytes.Buffer
+	if err := run(&CLI{PrincipalFile: principal, Type: "delete", JSON: true}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var event recentfile.Event
+	if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if event.Path != "dir/c.txt" || event.Type != "delete" {
+		t.Errorf("event = %+v", event)
+	}
+
+	out.Reset()
+	if err := run(&CLI{PrincipalFile: principal, Type: "new", Long: true}, &out); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if fields := strings.Fields(strings.SplitN(out.String(), "\n", 2)[0]); len(fields) != 4 || fields[2] != "new" || fields[3] != "a.txt" {
+		t.Errorf("long line = %q", fields)
+	}
+
+	if err := run(&CLI{PrincipalFile: principal, Since: "soon"}, &out); err == nil {
+		t.Error("expected error for invalid --since")
+	}
+	if err := run(&CLI{PrincipalFile: principal, Until: "soon"}, &out); err == nil {
+		t.Error("expected error for invalid --until")
+	}
+}
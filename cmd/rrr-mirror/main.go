@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/cmd/internal/mirrorcmd"
+)
+
+func main() {
+	var cli struct {
+		mirrorcmd.CLI
+
+		Version kong.VersionFlag `short:"V" help:"Show version."`
+	}
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-mirror"),
+		kong.Description("Mirror a remote tree by following its RECENT files"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+	)
+
+	if err := mirrorcmd.Run(&cli.CLI); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
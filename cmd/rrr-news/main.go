@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/cmd/internal/newscmd"
+)
+
+func main() {
+	var cli struct {
+		newscmd.CLI
+
+		Version kong.VersionFlag `short:"V" help:"Show version."`
+	}
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-news"),
+		kong.Description("List recent changes across all intervals of a RECENT hierarchy"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+	)
+
+	if err := newscmd.Run(&cli.CLI, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
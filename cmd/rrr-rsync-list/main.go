@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+// CLI defines the command-line interface for rrr-rsync-list.
+type CLI struct {
+	PrincipalFile string `arg:"" help:"Path to principal RECENT file (e.g., RECENT-1h.yaml)." type:"path"`
+
+	Since  string `short:"s" required:"" help:"Include changes after this epoch, or within this age (e.g., 1712345678.5, 90m, 1d, 1W)."`
+	Format string `short:"f" default:"files-from" enum:"files-from,include-from" help:"List format: files-from (existing files only) or include-from (filter rules, including deletions)."`
+	Output string `short:"o" help:"Write the list to this file instead of stdout." type:"path"`
+
+	Version kong.VersionFlag `short:"V" help:"Show version."`
+}
+
+func main() {
+	var cli CLI
+
+	ctx := kong.Parse(&cli,
+		kong.Name("rrr-rsync-list"),
+		kong.Description("Write an rsync --files-from or --include-from list of recent changes"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+	)
+
+	if err := run(&cli); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		ctx.Exit(1)
+	}
+}
+
+func run(cli *CLI) error {
+	since, err := parseSince(cli.Since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	principalPath, err := filepath.Abs(cli.PrincipalFile)
+	if err != nil {
+		return fmt.Errorf("resolve principal path: %w", err)
+	}
+
+	rec, err := recent.New(principalPath)
+	if err != nil {
+		return fmt.Errorf("load recent: %w", err)
+	}
+
+	events, err := rsynclist.Changes(rec, since)
+	if err != nil {
+		return fmt.Errorf("collect changes: %w", err)
+	}
+
+	out := os.Stdout
+	if cli.Output != "" {
+		f, err := os.Create(cli.Output)
+		if err != nil {
+			return fmt.Errorf("create output: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := rsynclist.Write(out, cli.Format, events); err != nil {
+		return fmt.Errorf("write list: %w", err)
+	}
+
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("close output: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseSince parses the --since flag.
+func parseSince(s string, now time.Time) (recentfile.Epoch, error) {
+	since, err := recentfile.ParseSince(s, now)
+	if err != nil {
+		return 0, fmt.Errorf("--since: %w", err)
+	}
+	return since, nil
+}
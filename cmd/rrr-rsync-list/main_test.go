@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		in   string
+		want recentfile.Epoch
+	}{
+		{"1699999000.5", 1699999000.5},
+		{"90m", 1700000000 - 90*60},
+		{"1d", 1700000000 - 86400},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if err != nil {
+			t.Errorf("parseSince(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "Z", "yesterday"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("parseSince(%q): expected error", bad)
+		}
+	}
+}
+
+func TestRsyncList(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.Update(filepath.Join(tmpDir, "dir/file.txt"), "new"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	binPath := filepath.Join(t.TempDir(), "rrr-rsync-list-test")
+	buildCmd := exec.Command("go", "build", "-o", binPath, ".")
+	if output, err := buildCmd.CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, output)
+	}
+
+	outPath := filepath.Join(t.TempDir(), "include.txt")
+	cmd := exec.Command(binPath, filepath.Join(tmpDir, "RECENT-1h.yaml"),
+		"--since", "1h", "--format", "include-from", "--output", outPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("rrr-rsync-list failed: %v\n%s", err, output)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+
+	want := "+ /dir/\n+ /dir/file.txt\n- *\n"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
@@ -1,17 +1,12 @@
 package main
 
 import (
-	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
 	"testing"
 	"time"
-
-	"go.ntppool.org/common/metricsserver"
-	"go.ntppool.org/common/version"
 )
 
 func TestServerIntegration(t *testing.T) {
@@ -176,155 +171,3 @@ func TestServerFileOnly(t *testing.T) {
 		t.Error("expected error message in output")
 	}
 }
-
-func TestCreateOrLoadRecent(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	// Create a test logger
-	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
-
-	// Test creating new collection (default YAML)
-	rec, err := createOrLoadRecent(tmpDir, "1h", "yaml", []string{"6h", "1d"}, log)
-	if err != nil {
-		t.Fatalf("createOrLoadRecent (new): %v", err)
-	}
-
-	if rec == nil {
-		t.Fatal("createOrLoadRecent returned nil")
-	}
-
-	intervals := rec.Intervals()
-	if len(intervals) != 3 {
-		t.Errorf("expected 3 intervals, got %d", len(intervals))
-	}
-
-	// Verify principal file was created
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
-	if _, err := os.Stat(principalPath); err != nil {
-		t.Errorf("principal file not created: %v", err)
-	}
-
-	// Test loading existing collection
-	rec2, err := createOrLoadRecent(tmpDir, "1h", "yaml", []string{"6h", "1d"}, log)
-	if err != nil {
-		t.Fatalf("createOrLoadRecent (load): %v", err)
-	}
-
-	if rec2 == nil {
-		t.Fatal("createOrLoadRecent (load) returned nil")
-	}
-
-	intervals2 := rec2.Intervals()
-	if len(intervals2) != len(intervals) {
-		t.Errorf("loaded collection has %d intervals, expected %d", len(intervals2), len(intervals))
-	}
-}
-
-func TestCreateOrLoadRecentJSON(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	// Create a test logger
-	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
-
-	// Test creating new collection with JSON format
-	rec, err := createOrLoadRecent(tmpDir, "1h", "json", []string{"6h", "1d"}, log)
-	if err != nil {
-		t.Fatalf("createOrLoadRecent (new, JSON): %v", err)
-	}
-
-	if rec == nil {
-		t.Fatal("createOrLoadRecent returned nil")
-	}
-
-	intervals := rec.Intervals()
-	if len(intervals) != 3 {
-		t.Errorf("expected 3 intervals, got %d", len(intervals))
-	}
-
-	// Verify principal file was created with .json extension
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.json")
-	if _, err := os.Stat(principalPath); err != nil {
-		t.Errorf("principal JSON file not created: %v", err)
-	}
-
-	// Verify aggregator files were also created with .json extension
-	aggPath := filepath.Join(tmpDir, "RECENT-6h.json")
-	if _, err := os.Stat(aggPath); err != nil {
-		t.Errorf("aggregator JSON file not created: %v", err)
-	}
-
-	// Test loading existing JSON collection
-	rec2, err := createOrLoadRecent(tmpDir, "1h", "json", []string{"6h", "1d"}, log)
-	if err != nil {
-		t.Fatalf("createOrLoadRecent (load, JSON): %v", err)
-	}
-
-	if rec2 == nil {
-		t.Fatal("createOrLoadRecent (load) returned nil")
-	}
-
-	intervals2 := rec2.Intervals()
-	if len(intervals2) != len(intervals) {
-		t.Errorf("loaded collection has %d intervals, expected %d", len(intervals2), len(intervals))
-	}
-}
-
-func TestCreateOrLoadRecentYAMLDefault(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	// Create a test logger
-	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
-
-	// Test creating new collection with YAML format (default)
-	rec, err := createOrLoadRecent(tmpDir, "1h", "yaml", []string{"6h"}, log)
-	if err != nil {
-		t.Fatalf("createOrLoadRecent (new, YAML): %v", err)
-	}
-
-	if rec == nil {
-		t.Fatal("createOrLoadRecent returned nil")
-	}
-
-	// Verify principal file was created with .yaml extension
-	principalPath := filepath.Join(tmpDir, "RECENT-1h.yaml")
-	if _, err := os.Stat(principalPath); err != nil {
-		t.Errorf("principal YAML file not created: %v", err)
-	}
-}
-
-func TestBuildInfoMetric(t *testing.T) {
-	// Create a metrics server with custom registry
-	metricsSrv := metricsserver.New()
-
-	// Register build_info metric
-	version.RegisterMetric("rrr", metricsSrv.Registry())
-
-	// Gather metrics from registry
-	metricFamilies, err := metricsSrv.Registry().Gather()
-	if err != nil {
-		t.Fatalf("failed to gather metrics: %v", err)
-	}
-
-	// Check if rrr_build_info metric is present
-	found := false
-	var buildInfoMetric string
-	for _, mf := range metricFamilies {
-		if mf.GetName() == "rrr_build_info" {
-			found = true
-			buildInfoMetric = mf.String()
-			break
-		}
-	}
-
-	if !found {
-		t.Error("rrr_build_info metric not found in registry")
-	}
-
-	// Verify the metric has expected labels
-	expectedLabels := []string{"version", "buildtime", "gittime", "git"}
-	for _, label := range expectedLabels {
-		if !strings.Contains(buildInfoMetric, label) {
-			t.Errorf("rrr_build_info metric missing expected label: %s", label)
-		}
-	}
-}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"go.ntppool.org/common/version"
+
+	"github.com/abh/rrrgo/cmd/internal/flags"
+	"github.com/abh/rrrgo/cmd/internal/fsckcmd"
+	"github.com/abh/rrrgo/cmd/internal/mirrorcmd"
+	"github.com/abh/rrrgo/cmd/internal/newscmd"
+	"github.com/abh/rrrgo/cmd/internal/servecmd"
+)
+
+// CLI defines the command-line interface for rrr. The commands are those
+// of rrr-server, rrr-aggregate, rrr-convert, rrr-fsck, rrr-news and
+// rrr-mirror, with the same flags.
+type CLI struct {
+	Serve     servecmd.CLI          `cmd:"" help:"Watch a directory tree and maintain its RECENT files."`
+	Init      servecmd.InitCLI      `cmd:"" help:"Create the RECENT files of a new hierarchy."`
+	Aggregate servecmd.AggregateCLI `cmd:"" help:"Aggregate the RECENT files of a hierarchy once, e.g. from cron."`
+	Convert   servecmd.ConvertCLI   `cmd:"" help:"Convert the RECENT files of a hierarchy to another format."`
+	Reshape   servecmd.ReshapeCLI   `cmd:"" help:"Add and remove aggregator intervals of a hierarchy to match --aggregator."`
+	Compact   servecmd.CompactCLI   `cmd:"" help:"Drop superseded and old delete events from the Z file of a hierarchy."`
+	Fsck      fsckcmd.CLI           `cmd:"" help:"Verify and repair RECENT file integrity."`
+	News      newscmd.CLI           `cmd:"" help:"List recent changes across all intervals of a RECENT hierarchy."`
+	Mirror    mirrorcmd.CLI         `cmd:"" help:"Mirror a remote tree by following its RECENT files."`
+	Dashboard servecmd.DashboardCmd `cmd:"" help:"Monitoring dashboard helpers."`
+
+	Version kong.VersionFlag `short:"V" help:"Show version."`
+}
+
+// newParser returns the command line parser of rrr. Flags not given on
+// the command line are taken from the YAML file named with --config, by
+// the commands that have it.
+func newParser(cli *CLI) *kong.Kong {
+	return kong.Must(cli,
+		kong.Name("rrr"),
+		kong.Description("Maintain, check and mirror trees with RECENT files"),
+		kong.UsageOnError(),
+		kong.Vars{"version": version.Version()},
+		kong.Configuration(flags.YAMLConfig),
+	)
+}
+
+func main() {
+	var cli CLI
+
+	parser := newParser(&cli)
+	kctx, err := parser.Parse(os.Args[1:])
+	parser.FatalIfErrorf(err)
+
+	command, _, _ := strings.Cut(kctx.Command(), " ")
+	switch command {
+	case "serve":
+		// Errors are logged
+		if err := servecmd.Run(&cli.Serve, os.Args[1:]); err != nil {
+			kctx.Exit(1)
+		}
+		return
+	case "fsck":
+		code, err := fsckcmd.Run(&cli.Fsck)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		kctx.Exit(code)
+		return
+	case "init":
+		err = servecmd.Init(&cli.Init)
+	case "aggregate":
+		err = servecmd.Aggregate(&cli.Aggregate)
+	case "convert":
+		err = servecmd.Convert(&cli.Convert)
+	case "reshape":
+		err = servecmd.Reshape(&cli.Reshape)
+	case "compact":
+		err = servecmd.Compact(&cli.Compact)
+	case "news":
+		err = newscmd.Run(&cli.News, os.Stdout)
+	case "mirror":
+		err = mirrorcmd.Run(&cli.Mirror)
+	case "dashboard":
+		err = cli.Dashboard.Export.Run()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		kctx.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+	}{
+		{[]string{"serve", "/srv/cpan"}, "serve <local-root>"},
+		{[]string{"init", "/srv/cpan", "--cpan"}, "init <local-root>"},
+		{[]string{"aggregate", "/srv/cpan", "--force", "--dry-run"}, "aggregate <local-root>"},
+		{[]string{"convert", "/srv/cpan", "--to", "json"}, "convert <local-root>"},
+		{[]string{"reshape", "/srv/cpan", "--aggregator", "1h,6h,1d,1M,Z"}, "reshape <local-root>"},
+		{[]string{"compact", "/srv/cpan", "--z-prune-deletes", "2160h"}, "compact <local-root>"},
+		{[]string{"fsck", "RECENT-1h.yaml", "--repair"}, "fsck <principal-file>"},
+		{[]string{"news", "RECENT-1h.yaml", "--since", "1d"}, "news <principal-file>"},
+		{[]string{"mirror", "host::cpan", "."}, "mirror <remote> <local-root>"},
+		{[]string{"dashboard", "export"}, "dashboard export"},
+	}
+	for _, tt := range tests {
+		var cli CLI
+		kctx, err := newParser(&cli).Parse(tt.args)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.args, err)
+			continue
+		}
+		if kctx.Command() != tt.command {
+			t.Errorf("Parse(%q) = %q, want %q", tt.args, kctx.Command(), tt.command)
+		}
+	}
+}
+
+func TestSharedConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "rrr.yaml")
+	err := os.WriteFile(config, []byte(`local_root: /srv/cpan
+lock_backend: flock
+ignore: [incoming]
+batch_size: 50
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every command takes the settings it has flags for
+	var cli CLI
+	if _, err := newParser(&cli).Parse([]string{"serve", "--config", config}); err != nil {
+		t.Fatalf("parse serve: %v", err)
+	}
+	if cli.Serve.LockBackend != "flock" || cli.Serve.BatchSize != 50 || strings.Join(cli.Serve.Ignore, ",") != "incoming" {
+		t.Errorf("serve = %+v", cli.Serve)
+	}
+
+	cli = CLI{}
+	if _, err := newParser(&cli).Parse([]string{"fsck", "--config", config, "RECENT-1h.yaml"}); err != nil {
+		t.Fatalf("parse fsck: %v", err)
+	}
+	if cli.Fsck.LockBackend != "flock" || strings.Join(cli.Fsck.Ignore, ",") != "incoming" {
+		t.Errorf("fsck = %+v", cli.Fsck)
+	}
+
+	cli = CLI{}
+	if _, err := newParser(&cli).Parse([]string{"init", "--config", config}); err != nil {
+		t.Fatalf("parse init: %v", err)
+	}
+	if cli.Init.LockBackend != "flock" {
+		t.Errorf("init = %+v", cli.Init)
+	}
+}
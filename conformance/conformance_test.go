@@ -0,0 +1,326 @@
+//go:build conformance
+
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/mirror"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+var testFiles = []string{
+	"authors/id/A/AB/ABH/Foo-1.0.tar.gz",
+	"authors/id/A/AB/ABH/Foo-1.0.meta",
+	"modules/02packages.details.txt.gz",
+	"index.html",
+}
+
+// require skips the test if check fails, or fails it when
+// RRR_CONFORMANCE_REQUIRE is set.
+func require(t *testing.T, what string, check func() error) {
+	t.Helper()
+	if err := check(); err != nil {
+		if os.Getenv("RRR_CONFORMANCE_REQUIRE") != "" {
+			t.Fatalf("%s not available: %v", what, err)
+		}
+		t.Skipf("%s not available: %v", what, err)
+	}
+}
+
+func requirePerl(t *testing.T) {
+	t.Helper()
+	require(t, "File::Rsync::Mirror::Recent", func() error {
+		out, err := exec.Command("perl", "-MFile::Rsync::Mirror::Recent", "-MJSON::PP", "-e1").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return nil
+	})
+}
+
+func requireCommand(t *testing.T, name string) string {
+	t.Helper()
+	var path string
+	require(t, name, func() (err error) {
+		path, err = exec.LookPath(name)
+		return err
+	})
+	return path
+}
+
+// perl runs one of the scripts in testdata.
+func perl(t *testing.T, script string, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("perl", append([]string{filepath.Join("testdata", script)}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		var stderr []byte
+		if ee, ok := err.(*exec.ExitError); ok {
+			stderr = ee.Stderr
+		}
+		t.Fatalf("%s failed: %v\n%s", script, err, stderr)
+	}
+	return out
+}
+
+func writeFiles(t *testing.T, root string) {
+	t.Helper()
+	for _, name := range testFiles {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// goHierarchy writes the test files and a hierarchy indexing them with rrrgo,
+// the way rrr-server does.
+func goHierarchy(t *testing.T, suffix string, opts ...recentfile.Option) (*recent.Recent, string) {
+	t.Helper()
+	root := t.TempDir()
+	writeFiles(t, root)
+
+	principal := recentfile.New(append([]recentfile.Option{
+		recentfile.WithLocalRoot(root),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1d", "Z"}),
+		recentfile.WithSerializerSuffix(suffix),
+	}, opts...)...)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatalf("EnsureFilesExist failed: %v", err)
+	}
+
+	var batch []recentfile.BatchItem
+	for _, name := range testFiles {
+		batch = append(batch, recentfile.BatchItem{Path: filepath.Join(root, name), Type: "new"})
+	}
+	if err := rec.BatchUpdate(batch); err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if err := principal.AssertSymlink(); err != nil {
+		t.Fatalf("AssertSymlink failed: %v", err)
+	}
+
+	return rec, root
+}
+
+func sortedPaths(events []recentfile.Event) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, e := range events {
+		if e.Type == "new" && !seen[e.Path] {
+			seen[e.Path] = true
+			paths = append(paths, e.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func wantPaths() []string {
+	paths := append([]string(nil), testFiles...)
+	sort.Strings(paths)
+	return paths
+}
+
+func TestPerlReadsGoHierarchy(t *testing.T) {
+	requirePerl(t)
+
+	for name, tt := range map[string]struct {
+		suffix string
+		opts   []recentfile.Option
+	}{
+		".yaml":      {".yaml", nil},
+		".json":      {".json", nil},
+		"perl .yaml": {".yaml", []recentfile.Option{recentfile.WithPerlYAML(true)}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec, root := goHierarchy(t, tt.suffix, tt.opts...)
+
+			for _, principal := range []string{
+				rec.PrincipalRecentfile().Rfile(),
+				filepath.Join(root, "RECENT.recent"),
+			} {
+				var events []recentfile.Event
+				if err := json.Unmarshal(perl(t, "news.pl", principal), &events); err != nil {
+					t.Fatalf("decode news: %v", err)
+				}
+				if got := sortedPaths(events); !slices.Equal(got, wantPaths()) {
+					t.Errorf("%s: Perl sees %v, want %v", filepath.Base(principal), got, wantPaths())
+				}
+			}
+		})
+	}
+}
+
+func TestPerlFsckGoHierarchy(t *testing.T) {
+	requirePerl(t)
+	rrrFsck := requireCommand(t, "rrr-fsck")
+
+	rec, _ := goHierarchy(t, ".yaml")
+
+	out, err := exec.Command(rrrFsck, rec.PrincipalRecentfile().Rfile()).CombinedOutput()
+	if err != nil {
+		t.Errorf("Perl rrr-fsck failed: %v\n%s", err, out)
+	}
+}
+
+func TestGoReadsPerlHierarchy(t *testing.T) {
+	requirePerl(t)
+
+	for _, suffix := range []string{".yaml", ".json"} {
+		t.Run(suffix, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root)
+			perl(t, "write.pl", append([]string{root, suffix}, testFiles...)...)
+
+			rec, err := recent.New(filepath.Join(root, "RECENT-1h"+suffix))
+			if err != nil {
+				t.Fatalf("load Perl hierarchy: %v", err)
+			}
+
+			events, err := rsynclist.Changes(rec, 0)
+			if err != nil {
+				t.Fatalf("Changes failed: %v", err)
+			}
+			if got := sortedPaths(events); !slices.Equal(got, wantPaths()) {
+				t.Errorf("rrrgo sees %v, want %v", got, wantPaths())
+			}
+
+			if errs := rec.Validate(); len(errs) > 0 {
+				t.Errorf("Validate: %v", errs)
+			}
+
+			result, err := fsck.Run(rec, fsck.Options{
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if err != nil {
+				t.Fatalf("fsck failed: %v", err)
+			}
+			if result.Issues != 0 {
+				t.Errorf("fsck found %d issues in Perl hierarchy: %v", result.Issues, result.IssuesFound)
+			}
+		})
+	}
+}
+
+func TestPerlClientMirrorsGoServer(t *testing.T) {
+	requirePerl(t)
+	rsync := requireCommand(t, "rsync")
+
+	_, root := goHierarchy(t, ".yaml")
+	port := startRsyncd(t, rsync, root)
+
+	target := t.TempDir()
+	perl(t, "rmirror.pl", "127.0.0.1::mirror/RECENT.recent", strconv.Itoa(port), target, t.TempDir())
+
+	for _, name := range testFiles {
+		data, err := os.ReadFile(filepath.Join(target, name))
+		if err != nil {
+			t.Errorf("%s not mirrored: %v", name, err)
+			continue
+		}
+		if string(data) != name {
+			t.Errorf("%s has content %q", name, data)
+		}
+	}
+}
+
+func TestGoMirrorsPerlHierarchy(t *testing.T) {
+	requirePerl(t)
+	rsync := requireCommand(t, "rsync")
+
+	root := t.TempDir()
+	writeFiles(t, root)
+	perl(t, "write.pl", append([]string{root, ".yaml"}, testFiles...)...)
+	port := startRsyncd(t, rsync, root)
+
+	fetcher, err := mirror.NewRsync("127.0.0.1::mirror", "--port="+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := t.TempDir()
+	m := mirror.New(fetcher, target, mirror.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("mirror failed: %v", err)
+	}
+
+	for _, name := range testFiles {
+		if data, err := os.ReadFile(filepath.Join(target, name)); err != nil || string(data) != name {
+			t.Errorf("%s not mirrored: %q, %v", name, data, err)
+		}
+	}
+}
+
+// startRsyncd serves root as the rsync module "mirror" and returns its port.
+func startRsyncd(t *testing.T, rsync, root string) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "rsyncd.conf")
+	err = os.WriteFile(config, []byte(fmt.Sprintf(
+		"use chroot = no\npid file = %s\n\n[mirror]\npath = %s\nread only = yes\n",
+		filepath.Join(dir, "rsyncd.pid"), root,
+	)), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(rsync, "--daemon", "--no-detach", "--address=127.0.0.1",
+		"--port="+strconv.Itoa(port), "--config="+config)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start rsync daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err == nil {
+			conn.Close()
+			return port
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rsync daemon did not start: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,13 @@
+// Package conformance holds integration tests that run the Perl
+// File::Rsync::Mirror::Recent tools against hierarchies written by rrrgo and
+// the other way around, so protocol compatibility is checked rather than
+// assumed.
+//
+// The tests are behind the "conformance" build tag:
+//
+//	go test -tags conformance ./conformance/
+//
+// Tests needing tools that aren't installed are skipped, unless
+// RRR_CONFORMANCE_REQUIRE is set. conformance/Dockerfile builds an image
+// with everything installed.
+package conformance
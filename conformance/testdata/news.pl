@@ -0,0 +1,13 @@
+#!/usr/bin/perl
+# Print the events of a local hierarchy as JSON, as seen by the Perl reader.
+# usage: news.pl <principal-file>
+use strict;
+use warnings;
+use File::Rsync::Mirror::Recent;
+use JSON::PP;
+
+my $principal = shift or die "usage: $0 <principal-file>\n";
+my $rrr = File::Rsync::Mirror::Recent->new(local => $principal);
+
+my @events = map { { path => $_->{path}, type => $_->{type} } } @{ $rrr->news };
+print JSON::PP->new->canonical->encode(\@events);
@@ -0,0 +1,25 @@
+#!/usr/bin/perl
+# Mirror a remote hierarchy once with the Perl client.
+# usage: rmirror.pl <remote> <port> <localroot> <tempdir>
+use strict;
+use warnings;
+use File::Rsync::Mirror::Recent;
+
+my ($remote, $port, $localroot, $tempdir) = @ARGV;
+die "usage: $0 <remote> <port> <localroot> <tempdir>\n" unless $tempdir;
+
+my $rrr = File::Rsync::Mirror::Recent->new(
+    localroot                => $localroot,
+    remote                   => $remote,
+    tempdir                  => $tempdir,
+    ttl                      => 1,
+    max_files_per_connection => 100,
+    rsync_options            => {
+        port     => $port,
+        links    => 1,
+        times    => 1,
+        compress => 0,
+        checksum => 0,
+    },
+);
+$rrr->rmirror;
@@ -0,0 +1,19 @@
+#!/usr/bin/perl
+# Write a hierarchy with the Perl implementation.
+# usage: write.pl <localroot> <suffix> <file>...
+use strict;
+use warnings;
+use File::Rsync::Mirror::Recentfile;
+
+my ($localroot, $suffix, @files) = @ARGV;
+die "usage: $0 <localroot> <suffix> <file>...\n" unless $suffix;
+
+my $rf = File::Rsync::Mirror::Recentfile->new(
+    filenameroot      => "RECENT",
+    interval          => "1h",
+    localroot         => $localroot,
+    aggregator        => [qw(6h 1d Z)],
+    serializer_suffix => $suffix,
+);
+$rf->update("$localroot/$_", "new") for @files;
+$rf->aggregate(force => 1);
@@ -1,9 +1,12 @@
 package fsck
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
@@ -27,6 +30,222 @@ func checkHierarchy(rec *recent.Recent, opts Options) int {
 	return 0
 }
 
+// checkChainCoverage checks that no time window is covered by neither of
+// two adjacent recentfiles with events: the smaller interval must hold
+// every event from at least the newest one of the larger interval on.
+// Otherwise events in between are in no file, and mirrors following the
+// chain miss them. Files that are missing or unreadable are left to
+// checkFileIntegrity.
+func checkChainCoverage(rec *recent.Recent, opts Options) int {
+	type coverage struct {
+		interval string
+		since    recentfile.Epoch // every event from here on is in the file
+		newest   recentfile.Epoch
+	}
+
+	var files []coverage
+	for _, rf := range rec.Recentfiles() {
+		stats, err := recentfile.StreamEvents(rf.Rfile(), 1, func([]recentfile.Event) bool { return false })
+		if err != nil || stats.Meta.Minmax == nil {
+			continue
+		}
+		files = append(files, coverage{
+			interval: rf.Interval(),
+			since:    coveredSince(stats.Meta, rf.IntervalSecs()),
+			newest:   stats.Meta.Minmax.Max,
+		})
+	}
+
+	issues := 0
+	for i := 1; i < len(files); i++ {
+		smaller, larger := files[i-1], files[i]
+		if !recentfile.EpochGt(smaller.since, larger.newest) {
+			if opts.Verbose {
+				opts.Logger.Debug("chain covered", "smaller", smaller.interval, "larger", larger.interval)
+			}
+			continue
+		}
+		gap := recentfile.EpochToFloat(smaller.since) - recentfile.EpochToFloat(larger.newest)
+		opts.Logger.Warn(fmt.Sprintf("hole between %s and %s", smaller.interval, larger.interval),
+			"from", larger.newest,
+			"to", smaller.since,
+			"duration", time.Duration(gap*float64(time.Second)).Round(time.Second),
+		)
+		issues++
+	}
+
+	return issues
+}
+
+// coveredSince returns the epoch from which a recentfile with the given
+// metadata holds every event. That is its oldest event, or earlier: events
+// are only dropped once merged into the next interval (up to the merged
+// epoch), or otherwise once older than the interval when the file was
+// last written (minmax mtime, in whole seconds).
+func coveredSince(meta recentfile.MetaData, intervalSecs int64) recentfile.Epoch {
+	if intervalSecs == recentfile.ZSeconds {
+		return 0
+	}
+
+	since := meta.Minmax.Min
+	var bound recentfile.Epoch
+	switch {
+	case meta.Merged != nil && !meta.Merged.Epoch.IsZero():
+		bound = meta.Merged.Epoch
+	case meta.Minmax.Mtime != 0:
+		bound = recentfile.EpochFromFloat(float64(meta.Minmax.Mtime - intervalSecs - 1))
+	}
+	if !bound.IsZero() && recentfile.EpochLt(bound, since) {
+		since = bound
+	}
+	return since
+}
+
+// checkEventOrder checks that the events of each recentfile are strictly
+// descending by epoch and that its minmax metadata names the epochs of the
+// first and last event. Returns number of issues found (one per file and
+// problem).
+func checkEventOrder(rec *recent.Recent, opts Options) int {
+	issues := 0
+
+	for _, rf := range rec.Recentfiles() {
+		rfile := rf.Rfile()
+		name := filepath.Base(rfile)
+
+		var first, prev recentfile.Event
+		count, unordered := 0, 0
+		stats, err := recentfile.StreamEvents(rfile, 10000, func(events []recentfile.Event) bool {
+			for _, event := range events {
+				if count == 0 {
+					first = event
+				} else if !recentfile.EpochGt(prev.Epoch, event.Epoch) {
+					if opts.Verbose || unordered < 10 {
+						opts.Logger.Warn("event out of order", "file", name, "path", event.Path, "epoch", event.Epoch, "after", prev.Epoch)
+					}
+					unordered++
+				}
+				prev = event
+				count++
+			}
+			return true
+		})
+		if err != nil {
+			continue // Reported by checkFileIntegrity
+		}
+
+		if unordered > 0 {
+			opts.Logger.Warn("events not strictly descending by epoch", "file", name, "count", unordered)
+			issues++
+		}
+
+		minmax := stats.Meta.Minmax
+		switch {
+		case count == 0 && minmax != nil:
+			opts.Logger.Warn("minmax in file without events", "file", name)
+			issues++
+		case count > 0 && minmax == nil:
+			opts.Logger.Warn("no minmax in file with events", "file", name)
+			issues++
+		case count > 0 && (minmax.Max != first.Epoch || minmax.Min != prev.Epoch):
+			opts.Logger.Warn("minmax does not match the events", "file", name,
+				"max", minmax.Max, "first", first.Epoch,
+				"min", minmax.Min, "last", prev.Epoch,
+			)
+			issues++
+		case opts.Verbose:
+			opts.Logger.Debug("events in order", "file", name, "events", count)
+		}
+	}
+
+	return issues
+}
+
+// checkFutureEpochs checks for events dated ahead of the local clock by more
+// than opts.MaxClockSkew, as written by a host with a bad clock. Until the
+// clock catches up, every event added after them is given an epoch just
+// above theirs, and mirrors comparing epochs with their own clock see
+// nothing new. Events are sorted newest first, so each file is only read
+// up to the first event that is not in the future. Returns number of
+// issues found (one per file).
+func checkFutureEpochs(rec *recent.Recent, opts Options) int {
+	issues := 0
+	limit := recentfile.EpochFromTime(time.Now().Add(opts.maxClockSkew()))
+
+	for _, rf := range rec.Recentfiles() {
+		name := filepath.Base(rf.Rfile())
+
+		var newest recentfile.Epoch
+		future := 0
+		_, err := recentfile.StreamEvents(rf.Rfile(), 1000, func(events []recentfile.Event) bool {
+			for _, event := range events {
+				if !recentfile.EpochGt(event.Epoch, limit) {
+					return false
+				}
+				if future == 0 {
+					newest = event.Epoch
+				}
+				future++
+			}
+			return true
+		})
+		if err != nil {
+			continue // Reported by checkFileIntegrity
+		}
+
+		if future > 0 {
+			opts.Logger.Warn("events dated in the future", "file", name, "count", future,
+				"newest", newest,
+				"ahead", recentfile.EpochToTime(newest).Sub(time.Now()).Round(time.Second),
+			)
+			issues++
+		} else if opts.Verbose {
+			opts.Logger.Debug("no events dated in the future", "file", name)
+		}
+	}
+
+	return issues
+}
+
+// checkDirtymarks checks that the dirtymarks are consistent along the
+// chain. Aggregation copies a new dirtymark from each interval to the next
+// larger one, so a larger interval may lag behind with an older dirtymark
+// until then, but never has a newer one unless files were changed by hand.
+func checkDirtymarks(rec *recent.Recent, opts Options) int {
+	issues := 0
+
+	var prev *recentfile.Recentfile
+	var prevMark recentfile.Epoch
+	for _, rf := range rec.Recentfiles() {
+		stats, err := recentfile.StreamEvents(rf.Rfile(), 1, func([]recentfile.Event) bool { return false })
+		if err != nil {
+			continue // Reported by checkFileIntegrity
+		}
+		mark := stats.Meta.Dirtymark
+
+		if prev != nil {
+			switch {
+			case recentfile.EpochGt(mark, prevMark):
+				opts.Logger.Warn("dirtymark newer than that of a smaller interval",
+					"file", filepath.Base(rf.Rfile()),
+					"dirtymark", mark,
+					"smaller", filepath.Base(prev.Rfile()),
+					"smaller_dirtymark", prevMark,
+				)
+				issues++
+			case mark != prevMark && opts.Verbose:
+				opts.Logger.Debug("dirtymark not yet aggregated",
+					"file", filepath.Base(rf.Rfile()),
+					"dirtymark", mark,
+					"smaller_dirtymark", prevMark,
+				)
+			}
+		}
+		prev, prevMark = rf, mark
+	}
+
+	return issues
+}
+
 // checkFileIntegrity verifies that all recentfiles exist and are readable.
 func checkFileIntegrity(rec *recent.Recent, opts Options) int {
 	issues := 0
@@ -99,11 +318,50 @@ func checkFileIntegrity(rec *recent.Recent, opts Options) int {
 	return issues
 }
 
+// checkSymlink verifies that the RECENT.recent symlink next to the
+// principal exists and points at it, by its file name as the symlink is
+// written, and so at a file with the configured serializer suffix.
+func checkSymlink(rec *recent.Recent, opts Options) int {
+	principal := rec.PrincipalRecentfile()
+	meta := principal.Meta()
+	link := filepath.Join(filepath.Dir(principal.Rfile()), meta.Filenameroot+".recent")
+	want := principal.Rfilename()
+
+	fi, err := os.Lstat(link)
+	if err != nil {
+		opts.Logger.Warn("missing symlink", "path", link, "want", want)
+		return 1
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		opts.Logger.Warn("not a symlink", "path", link, "want", want)
+		return 1
+	}
+
+	target, err := os.Readlink(link)
+	if err != nil {
+		opts.Logger.Warn("cannot read symlink", "path", link, "error", err)
+		return 1
+	}
+	if target != want {
+		if !strings.HasSuffix(target, meta.SerializerSuffix) {
+			opts.Logger.Warn("symlink points at a file with another serializer suffix", "path", link, "target", target, "want", want)
+		} else {
+			opts.Logger.Warn("symlink does not point at the principal", "path", link, "target", target, "want", want)
+		}
+		return 1
+	}
+
+	if opts.Verbose {
+		opts.Logger.Debug("symlink ok", "path", link, "target", target)
+	}
+	return 0
+}
+
 // checkOrphanedFiles looks for RECENT-*.yaml files that aren't in the hierarchy.
 func checkOrphanedFiles(rec *recent.Recent, opts Options) int {
 	issues := 0
 
-	localRoot := rec.LocalRoot()
+	indexDir := rec.IndexDir()
 
 	// Get all expected files
 	expectedFiles := make(map[string]bool)
@@ -111,10 +369,14 @@ func checkOrphanedFiles(rec *recent.Recent, opts Options) int {
 		expectedFiles[filepath.Base(rf.Rfile())] = true
 	}
 
-	// Scan directory for RECENT-*.yaml files
-	entries, err := os.ReadDir(localRoot)
+	// Scan directory for RECENT-*.yaml files (or whatever the hierarchy's
+	// files are called)
+	meta := rec.PrincipalRecentfile().Meta()
+	prefix := meta.Filenameroot + "-"
+	suffix := meta.SerializerSuffix
+	entries, err := os.ReadDir(indexDir)
 	if err != nil {
-		opts.Logger.Warn("cannot read directory", "path", localRoot, "error", err)
+		opts.Logger.Warn("cannot read directory", "path", indexDir, "error", err)
 		return 1
 	}
 
@@ -126,12 +388,7 @@ func checkOrphanedFiles(rec *recent.Recent, opts Options) int {
 		name := entry.Name()
 
 		// Check if it's a RECENT file
-		if filepath.Ext(name) == ".yaml" && len(name) > 7 && name[:7] == "RECENT-" {
-			// Skip lock and new files
-			if filepath.Ext(name) == ".lock" || filepath.Ext(name) == ".new" {
-				continue
-			}
-
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
 			// Check if it's expected
 			if !expectedFiles[name] {
 				opts.Logger.Warn("orphaned file", "file", name, "note", "not in hierarchy")
@@ -147,7 +404,9 @@ func checkOrphanedFiles(rec *recent.Recent, opts Options) int {
 
 // verifyEventsMatchFilesystem checks that files mentioned in RECENT events exist on disk.
 // It builds a complete state map first, keeping only the most recent event for each path,
-// then verifies only files where the most recent event is "new" (not "delete").
+// then verifies only files where the most recent event is "new" (not "delete"), and their
+// size and checksum if the event has them (see recentfile.WithProtocolExt). Unless
+// opts.Full is set, only a sample of opts.Sample of them is checked.
 func verifyEventsMatchFilesystem(rec *recent.Recent, opts Options) int {
 	issues := 0
 	localRoot := rec.LocalRoot()
@@ -186,24 +445,32 @@ func verifyEventsMatchFilesystem(rec *recent.Recent, opts Options) int {
 		}
 	}
 
+	if err := addArchivedEvents(stateMap, opts.ArchiveDir); err != nil {
+		opts.Logger.Warn("cannot read archive", "dir", opts.ArchiveDir, "error", err)
+		issues++
+	}
+
 	if opts.Verbose {
 		opts.Logger.Debug("built state map", "total_events", totalEvents, "unique_paths", len(stateMap))
 	}
 
 	// Now check only files where the most recent event is "new"
 	checked := 0
+	skipped := 0
 	missing := 0
+	mismatched := 0
 	showedMissing := 0
-	maxSample := 1000
+	sample := opts.sample()
 
 	for path, event := range stateMap {
-		// Skip files where most recent event is "delete"
-		if event.Type == "delete" {
+		// Skip files where most recent event is "delete", and ignored paths
+		if event.Type == "delete" || opts.Filter.Ignored(path) {
 			continue
 		}
 
-		// In non-verbose mode, only check a sample
-		if !opts.Verbose && checked >= maxSample {
+		// Unless asked for a full check, only check a sample
+		if !opts.Full && checked >= sample {
+			skipped++
 			continue
 		}
 
@@ -231,11 +498,19 @@ func verifyEventsMatchFilesystem(rec *recent.Recent, opts Options) int {
 				opts.Logger.Warn("broken symlink in RECENT", "path", path)
 				showedMissing++
 			}
+			continue
+		}
+
+		// Events with a size and checksum must describe the file
+		if err := event.VerifyFile(fullPath); errors.Is(err, recentfile.ErrChecksumMismatch) {
+			opts.Logger.Warn("file on disk differs from its RECENT event", "path", path)
+			mismatched++
+			issues++
 		}
 	}
 
-	if !opts.Verbose && len(stateMap) > maxSample {
-		opts.Logger.Info("checked sample", "checked", checked, "total_paths", len(stateMap))
+	if skipped > 0 {
+		opts.Logger.Info("checked sample", "checked", checked, "total_paths", checked+skipped)
 	}
 
 	if missing > 0 {
@@ -243,6 +518,9 @@ func verifyEventsMatchFilesystem(rec *recent.Recent, opts Options) int {
 	} else if opts.Verbose {
 		opts.Logger.Debug("all files from events exist on disk", "checked", checked)
 	}
+	if mismatched > 0 {
+		opts.Logger.Info("files differing from their size or checksum in RECENT", "mismatched", mismatched, "checked", checked)
+	}
 
 	return issues
 }
@@ -251,24 +529,18 @@ func verifyEventsMatchFilesystem(rec *recent.Recent, opts Options) int {
 // Returns number of issues found (files on disk but not in index).
 func verifyDiskMatchesIndex(rec *recent.Recent, opts Options) int {
 	issues := 0
-	localRoot := rec.LocalRoot()
 
 	if opts.Verbose {
 		opts.Logger.Debug("scanning files on disk")
 	}
 
 	// Build set of paths that should exist according to index
-	indexPaths, err := buildCurrentIndexState(rec)
+	indexPaths, err := buildCurrentIndexState(rec, opts.ArchiveDir)
 	if err != nil {
 		opts.Logger.Warn("cannot build index state", "error", err)
 		return issues
 	}
 
-	// Get ignore pattern for RECENT files
-	meta := rec.PrincipalRecentfile().Meta()
-	filenameRoot := meta.Filenameroot
-	serializerSuffix := meta.SerializerSuffix
-
 	if opts.Verbose {
 		opts.Logger.Debug("loaded paths from index", "count", len(indexPaths))
 		opts.Logger.Debug("walking directory tree")
@@ -279,60 +551,7 @@ func verifyDiskMatchesIndex(rec *recent.Recent, opts Options) int {
 	missingInIndex := 0
 	showedMissing := 0
 
-	err = filepath.Walk(localRoot, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip paths we can't access
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Get relative path
-		relPath, err := filepath.Rel(localRoot, path)
-		if err != nil {
-			return nil // Skip if we can't get relative path
-		}
-
-		// Normalize path separators for cross-platform compatibility
-		relPath = filepath.ToSlash(relPath)
-
-		// Skip current and parent directory markers
-		if relPath == "." || relPath == ".." {
-			return nil
-		}
-
-		// Skip temporary files
-		baseName := filepath.Base(path)
-		if recentfile.ShouldIgnoreFile(baseName) {
-			return nil
-		}
-
-		// Skip RECENT files managed by rrr-server (only in root, not subdirectories)
-		if len(baseName) >= len(filenameRoot) && baseName[:len(filenameRoot)] == filenameRoot {
-			// Only skip RECENT files if they're in the root directory
-			// Subdirectory RECENT files (modules/RECENT-*, authors/RECENT.recent) are mirrored content
-			inRootDir := filepath.Dir(relPath) == "."
-
-			// Check for .recent symlink
-			if baseName == filenameRoot+".recent" && inRootDir {
-				return nil // Skip root RECENT.recent (managed by rrr-server)
-			}
-
-			// Check if it's a RECENT file pattern (RECENT-*)
-			if len(baseName) > len(filenameRoot)+1 && baseName[len(filenameRoot)] == '-' {
-				// Skip only root RECENT-* files, not subdirectory ones
-				if inRootDir {
-					if filepath.Ext(baseName) == serializerSuffix ||
-						filepath.Ext(baseName) == ".lock" ||
-						filepath.Ext(baseName) == ".new" {
-						return nil // Skip root RECENT-* files
-					}
-				}
-			}
-		}
-
+	walkDisk(rec, opts, func(relPath string) {
 		filesOnDisk++
 
 		// Check if in index
@@ -350,13 +569,7 @@ func verifyDiskMatchesIndex(rec *recent.Recent, opts Options) int {
 		if opts.Verbose && filesOnDisk%10000 == 0 {
 			opts.Logger.Debug("progress", "scanned", filesOnDisk, "not_in_index", missingInIndex)
 		}
-
-		return nil
 	})
-	if err != nil {
-		opts.Logger.Warn("error walking directory", "error", err)
-		return issues
-	}
 
 	if opts.Verbose {
 		opts.Logger.Debug("scanned files on disk", "count", filesOnDisk)
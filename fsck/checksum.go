@@ -0,0 +1,203 @@
+package fsck
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/abh/rrrgo/recent"
+)
+
+// checksum is one entry of a checksum manifest.
+type checksum struct {
+	path   string // relative to the local root, slash-separated
+	digest string // lower case hex
+}
+
+// bsdChecksum matches a line of a BSD style manifest,
+// "SHA256 (path) = digest".
+var bsdChecksum = regexp.MustCompile(`^[A-Z0-9-]+ \((.+)\) = ([0-9a-fA-F]+)$`)
+
+// readManifest reads a checksum manifest as written by sha256sum (or
+// md5sum, sha1sum, sha512sum), "digest  path" per line, or by the BSD
+// tools with --tag. Paths are relative to the local root.
+func readManifest(name string) ([]checksum, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+	defer f.Close()
+
+	var sums []checksum
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var sum checksum
+		if m := bsdChecksum.FindStringSubmatch(line); m != nil {
+			sum = checksum{path: m[1], digest: m[2]}
+		} else {
+			digest, path, _ := strings.Cut(line, " ")
+			// "digest *path" marks binary mode, "digest  path" text mode
+			if strings.HasPrefix(path, " ") || strings.HasPrefix(path, "*") {
+				path = path[1:]
+			}
+			if path == "" {
+				return nil, fmt.Errorf("manifest %s line %d: no path", name, n)
+			}
+			sum = checksum{path: path, digest: digest}
+		}
+		if newHash(sum.digest) == nil {
+			return nil, fmt.Errorf("manifest %s line %d: not a digest: %q", name, n, sum.digest)
+		}
+		sum.digest = strings.ToLower(sum.digest)
+		sum.path = strings.TrimPrefix(filepath.ToSlash(sum.path), "./")
+		sums = append(sums, sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	return sums, nil
+}
+
+// newHash returns the hash producing digests of the length of digest, or
+// nil if there is none or digest is not hex.
+func newHash(digest string) hash.Hash {
+	if _, err := hex.DecodeString(digest); err != nil {
+		return nil
+	}
+	switch len(digest) {
+	case 2 * md5.Size:
+		return md5.New()
+	case 2 * sha1.Size:
+		return sha1.New()
+	case 2 * sha256.Size:
+		return sha256.New()
+	case 2 * sha512.Size:
+		return sha512.New()
+	}
+	return nil
+}
+
+// fileDigest returns the hex digest of the file at path, computed with h.
+func fileDigest(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksums compares the files listed in opts.ChecksumManifest with
+// their digests there, to find files corrupted on disk. With
+// opts.ChecksumSample and opts.ChecksumLimit only a random part of them is
+// read. Files missing from disk are left to the other checks.
+// Returns number of issues found (files that differ or cannot be read).
+func verifyChecksums(rec *recent.Recent, opts Options) int {
+	sums, err := readManifest(opts.ChecksumManifest)
+	if err != nil {
+		opts.Logger.Warn("cannot read checksum manifest", "error", err)
+		return 1
+	}
+
+	// Pick the sample
+	selected := sums[:0]
+	for _, sum := range sums {
+		if opts.Filter.Ignored(sum.path) {
+			continue
+		}
+		if opts.ChecksumSample > 0 && opts.ChecksumSample < 1 && rand.Float64() >= opts.ChecksumSample {
+			continue
+		}
+		selected = append(selected, sum)
+	}
+	if opts.ChecksumLimit > 0 && len(selected) > opts.ChecksumLimit {
+		rand.Shuffle(len(selected), func(i, j int) {
+			selected[i], selected[j] = selected[j], selected[i]
+		})
+		selected = selected[:opts.ChecksumLimit]
+	}
+
+	if opts.Verbose {
+		opts.Logger.Debug("verifying checksums", "manifest", opts.ChecksumManifest, "files", len(selected), "listed", len(sums))
+	}
+
+	workers := opts.Concurrency
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var (
+		mu           sync.Mutex
+		checked      int
+		issues       int
+		showedIssues int
+		wg           sync.WaitGroup
+	)
+	report := func(msg, path string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		issues++
+		if opts.Verbose || showedIssues < 10 {
+			opts.Logger.Warn(msg, append([]any{"path", path}, args...)...)
+			showedIssues++
+		}
+	}
+
+	work := make(chan checksum)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sum := range work {
+				digest, err := fileDigest(filepath.Join(rec.LocalRoot(), filepath.FromSlash(sum.path)), newHash(sum.digest))
+				switch {
+				case os.IsNotExist(err):
+					continue
+				case err != nil:
+					report("cannot read file for checksum", sum.path, "error", err)
+				case digest != sum.digest:
+					report("checksum mismatch", sum.path, "want", sum.digest, "got", digest)
+				}
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, sum := range selected {
+		work <- sum
+	}
+	close(work)
+	wg.Wait()
+
+	if issues > 0 {
+		opts.Logger.Info("files not matching their checksum", "count", issues, "checked", checked)
+	} else if opts.Verbose {
+		opts.Logger.Debug("all checksums match", "checked", checked)
+	}
+
+	return issues
+}
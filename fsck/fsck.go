@@ -3,16 +3,55 @@ package fsck
 import (
 	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/abh/rrrgo/pathfilter"
 	"github.com/abh/rrrgo/recent"
 )
 
 // Options controls fsck behavior.
 type Options struct {
-	Repair     bool         // Auto-repair issues found
-	SkipEvents bool         // Skip event parsing (faster, less thorough)
-	Verbose    bool         // Detailed output
-	Logger     *slog.Logger // Required for all output
+	Repair           bool               // Auto-repair issues found
+	Repairs          []string           // Repairs made with Repair (see AllRepairs); all if nil
+	SkipEvents       bool               // Skip event parsing (faster, less thorough)
+	Sample           int                // Indexed paths checked against the disk; 1000 if 0
+	Full             bool               // Check every indexed path against the disk, not a sample
+	Concurrency      int                // Directories (or files, for checksums) read at once; GOMAXPROCS if 0
+	Verbose          bool               // Detailed output
+	ArchiveDir       string             // Archive of events rotated out of Z, if any
+	Filter           *pathfilter.Filter // Paths left out of disk comparisons, if any
+	StateFile        string             // Disk scan kept between runs, if any; unchanged directories are not read again
+	ChecksumManifest string             // Checksums to verify files against (sha256sum format), if any
+	ChecksumSample   float64            // Share of the manifest's files verified; all if 0
+	ChecksumLimit    int                // Most files verified; no limit if 0
+	MaxClockSkew     time.Duration      // How far event epochs may be ahead of the local clock; 5 minutes if 0
+	Logger           *slog.Logger       // Required for all output
+
+	cache *diskCache // loaded from StateFile
+}
+
+// defaultMaxClockSkew is how far event epochs may be ahead of the local
+// clock when Options.MaxClockSkew is not set.
+const defaultMaxClockSkew = 5 * time.Minute
+
+// maxClockSkew returns opts.MaxClockSkew, or its default.
+func (opts Options) maxClockSkew() time.Duration {
+	if opts.MaxClockSkew > 0 {
+		return opts.MaxClockSkew
+	}
+	return defaultMaxClockSkew
+}
+
+// defaultSample is how many indexed paths are checked against the disk
+// when Options.Sample is not set.
+const defaultSample = 1000
+
+// sample returns opts.Sample, or its default.
+func (opts Options) sample() int {
+	if opts.Sample > 0 {
+		return opts.Sample
+	}
+	return defaultSample
 }
 
 // Result contains fsck findings.
@@ -32,7 +71,9 @@ func Run(rec *recent.Recent, opts Options) (*Result, error) {
 
 	opts.Logger.Info("starting fsck",
 		"repair", opts.Repair,
+		"repairs", opts.Repairs,
 		"skip_events", opts.SkipEvents,
+		"full", opts.Full,
 		"verbose", opts.Verbose,
 	)
 
@@ -40,18 +81,52 @@ func Run(rec *recent.Recent, opts Options) (*Result, error) {
 		IssuesFound: make(map[string]int),
 	}
 
+	if opts.StateFile != "" {
+		cache, err := loadDiskCache(opts.StateFile, rec.LocalRoot())
+		if err != nil {
+			return nil, err
+		}
+		opts.cache = cache
+	}
+
 	// Check hierarchy
 	if opts.Verbose {
 		opts.Logger.Debug("validating hierarchy")
 	}
 	result.IssuesFound["hierarchy"] = checkHierarchy(rec, opts)
 
+	// Check the chain has no holes
+	if opts.Verbose {
+		opts.Logger.Debug("checking aggregator chain coverage")
+	}
+	result.IssuesFound["chain_gaps"] = checkChainCoverage(rec, opts)
+
 	// Check file integrity
 	if opts.Verbose {
 		opts.Logger.Debug("checking file integrity")
 	}
 	result.IssuesFound["file_integrity"] = checkFileIntegrity(rec, opts)
 
+	// Check the RECENT.recent symlink
+	if opts.Verbose {
+		opts.Logger.Debug("checking symlink")
+	}
+	result.IssuesFound["symlink"] = checkSymlink(rec, opts)
+
+	// Check event order and minmax (unless skipped), and dirtymarks
+	if !opts.SkipEvents {
+		if opts.Verbose {
+			opts.Logger.Debug("checking event order")
+		}
+		result.IssuesFound["event_order"] = checkEventOrder(rec, opts)
+
+		if opts.Verbose {
+			opts.Logger.Debug("checking for events dated in the future")
+		}
+		result.IssuesFound["future_epochs"] = checkFutureEpochs(rec, opts)
+	}
+	result.IssuesFound["dirtymark"] = checkDirtymarks(rec, opts)
+
 	// Check for orphaned files
 	if opts.Verbose {
 		opts.Logger.Debug("checking for orphaned files")
@@ -74,6 +149,14 @@ func Run(rec *recent.Recent, opts Options) (*Result, error) {
 		opts.Logger.Debug("skipping event-to-filesystem verification")
 	}
 
+	// Check file contents against the checksum manifest
+	if opts.ChecksumManifest != "" {
+		if opts.Verbose {
+			opts.Logger.Debug("verifying file checksums")
+		}
+		result.IssuesFound["checksums"] = verifyChecksums(rec, opts)
+	}
+
 	// Calculate total issues
 	for _, count := range result.IssuesFound {
 		result.Issues += count
@@ -82,10 +165,16 @@ func Run(rec *recent.Recent, opts Options) (*Result, error) {
 	opts.Logger.Info("fsck checks complete",
 		"issues_found", result.Issues,
 		"hierarchy", result.IssuesFound["hierarchy"],
+		"chain_gaps", result.IssuesFound["chain_gaps"],
 		"file_integrity", result.IssuesFound["file_integrity"],
+		"symlink", result.IssuesFound["symlink"],
+		"event_order", result.IssuesFound["event_order"],
+		"future_epochs", result.IssuesFound["future_epochs"],
+		"dirtymark", result.IssuesFound["dirtymark"],
 		"orphaned_files", result.IssuesFound["orphaned_files"],
 		"disk_index", result.IssuesFound["disk_index"],
 		"index_disk", result.IssuesFound["index_disk"],
+		"checksums", result.IssuesFound["checksums"],
 	)
 
 	// Repair if requested and issues found
@@ -103,5 +192,16 @@ func Run(rec *recent.Recent, opts Options) (*Result, error) {
 		opts.Logger.Info("repair complete")
 	}
 
+	if opts.cache != nil {
+		if err := opts.cache.save(); err != nil {
+			return result, err
+		}
+		opts.Logger.Info("saved disk scan state",
+			"file", opts.StateFile,
+			"dirs_read", opts.cache.read,
+			"dirs_unchanged", opts.cache.unchanged,
+		)
+	}
+
 	return result, nil
 }
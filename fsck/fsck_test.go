@@ -1,11 +1,17 @@
 package fsck
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/abh/rrrgo/archive"
+	"github.com/abh/rrrgo/pathfilter"
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
 )
@@ -75,7 +81,7 @@ func TestBuildCurrentIndexState(t *testing.T) {
 		t.Fatalf("Update failed: %v", err)
 	}
 
-	indexPaths, err := buildCurrentIndexState(rec)
+	indexPaths, err := buildCurrentIndexState(rec, "")
 	if err != nil {
 		t.Fatalf("buildCurrentIndexState failed: %v", err)
 	}
@@ -96,6 +102,53 @@ func TestBuildCurrentIndexState(t *testing.T) {
 	}
 }
 
+// TestBuildCurrentIndexStateArchive verifies events rotated out of Z still
+// count towards the expected state when the archive is given.
+func TestBuildCurrentIndexStateArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"Z"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+
+	z := rec.RecentfileByInterval("Z")
+	z.SetRecentEvents([]recentfile.Event{
+		{Epoch: recentfile.EpochFromTime(time.Now().Add(-48 * time.Hour)), Path: "archived.txt", Type: "new"},
+	})
+	if err := z.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	if _, err := archive.Rotate(rec, archiveDir, 24*time.Hour); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	indexPaths, err := buildCurrentIndexState(rec, "")
+	if err != nil {
+		t.Fatalf("buildCurrentIndexState failed: %v", err)
+	}
+	if indexPaths["archived.txt"] {
+		t.Error("archived.txt should not be in index without the archive")
+	}
+
+	indexPaths, err = buildCurrentIndexState(rec, archiveDir)
+	if err != nil {
+		t.Fatalf("buildCurrentIndexState failed: %v", err)
+	}
+	if !indexPaths["archived.txt"] {
+		t.Error("archived.txt should be in index with the archive")
+	}
+}
+
 // TestNewerDeleteEvent verifies fsck doesn't report false positive when:
 // - Old file has "new" event (epoch 500)
 // - New file has "delete" event (epoch 1000)
@@ -132,3 +185,581 @@ func TestNewerDeleteEvent(t *testing.T) {
 		t.Errorf("FAIL: got %d issues, want 0 (most recent event is delete)", result.Issues)
 	}
 }
+
+// TestFilter verifies that ignored paths are left out of the disk comparisons
+// in both directions.
+func TestFilter(t *testing.T) {
+	rec, rfs := setupTest(t)
+	tmpDir := rec.LocalRoot()
+
+	// On disk but not recorded, and recorded but not on disk
+	os.MkdirAll(filepath.Join(tmpDir, ".git"), 0o755)
+	os.WriteFile(filepath.Join(tmpDir, ".git", "HEAD"), []byte("ref"), 0o644)
+	if err := rfs[0].Update(filepath.Join(tmpDir, "scratch", "gone.txt"), "new", 0); err != nil {
+		t.Fatal(err)
+	}
+	rfs[1].Lock()
+	rfs[1].Write()
+	rfs[1].Unlock()
+
+	result, err := Run(rec, Options{Logger: quietLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Issues != 2 {
+		t.Errorf("unfiltered: got %d issues, want 2", result.Issues)
+	}
+
+	filter, err := pathfilter.New([]string{".git", "/scratch"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = Run(rec, Options{Logger: quietLogger(), Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Issues != 0 {
+		t.Errorf("filtered: got %d issues (%v), want 0", result.Issues, result.IssuesFound)
+	}
+}
+
+func TestSelectRepairs(t *testing.T) {
+	tests := []struct {
+		only, skip []string
+		want       []string
+		wantErr    bool
+	}{
+		{nil, nil, AllRepairs, false},
+		{[]string{"epochs", "files"}, nil, []string{"files", "epochs"}, false},
+		{nil, []string{"missing-events"}, []string{"files", "corrupt", "symlink", "index-orphans", "future-epochs", "order", "epochs"}, false},
+		{[]string{"epochs"}, []string{"epochs"}, nil, true},
+		{[]string{"bogus"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := SelectRepairs(tt.only, tt.skip)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SelectRepairs(%v, %v) error = %v", tt.only, tt.skip, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SelectRepairs(%v, %v) = %v, want %v", tt.only, tt.skip, got, tt.want)
+		}
+	}
+}
+
+func TestRepairSelection(t *testing.T) {
+	rec, rfs := setupTest(t)
+	tmpDir := rec.LocalRoot()
+
+	// Recorded but not on disk, on disk but not recorded, and a missing
+	// aggregated file
+	if err := rfs[0].Update(filepath.Join(tmpDir, "gone.txt"), "new", 0); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("new"), 0o644)
+
+	result, err := Run(rec, Options{
+		Repair:  true,
+		Repairs: []string{RepairFiles, RepairIndexOrphans},
+		Logger:  quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Repaired {
+		t.Error("not repaired")
+	}
+	if _, err := os.Stat(rfs[1].Rfile()); err != nil {
+		t.Errorf("aggregated file not created: %v", err)
+	}
+
+	state, err := IndexState(rec, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["new.txt"].Type != "new" {
+		t.Errorf("new.txt not added: %+v", state["new.txt"])
+	}
+	if state["gone.txt"].Type != "new" {
+		t.Errorf("gone.txt changed without the missing-events repair: %+v", state["gone.txt"])
+	}
+}
+
+func TestDirEvents(t *testing.T) {
+	rec, rfs := setupTest(t)
+	tmpDir := rec.LocalRoot()
+
+	// Directories recorded as such, one still there and one gone
+	os.Mkdir(filepath.Join(tmpDir, "empty"), 0o755)
+	err := rfs[0].BatchUpdate([]recentfile.BatchItem{
+		{Path: filepath.Join(tmpDir, "empty"), Type: "new", Dir: true},
+		{Path: filepath.Join(tmpDir, "gone"), Type: "new", Dir: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Run(rec, Options{Full: true, Logger: quietLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Issues != 1 {
+		t.Errorf("got %d issues (%v), want 1", result.Issues, result.IssuesFound)
+	}
+
+	if _, err := Run(rec, Options{Repair: true, Repairs: []string{RepairMissingEvents}, Logger: quietLogger()}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := IndexState(rec, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["empty"].Type != "new" || state["gone"].Type != "delete" {
+		t.Errorf("after repair: empty %+v, gone %+v", state["empty"], state["gone"])
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	var want []string
+	for i := range 20 {
+		for _, dir := range []string{fmt.Sprintf("d%d", i), fmt.Sprintf("d%d/sub", i), fmt.Sprintf("skip%d", i)} {
+			os.MkdirAll(filepath.Join(tmpDir, dir), 0o755)
+			os.WriteFile(filepath.Join(tmpDir, dir, "f"), nil, 0o644)
+			if !strings.HasPrefix(dir, "skip") {
+				want = append(want, dir+"/f")
+			}
+		}
+	}
+	os.WriteFile(filepath.Join(tmpDir, "top"), nil, 0o644)
+	want = append(want, "top")
+
+	for _, workers := range []int{1, 4} {
+		var got []string
+		walkFiles(tmpDir, workers, nil, func(relPath string) bool {
+			return strings.HasPrefix(relPath, "skip")
+		}, func(relPath string) {
+			got = append(got, relPath)
+		})
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("%d workers: got %v, want %v", workers, got, want)
+		}
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	rec, _ := setupTest(t)
+	tmpDir := rec.LocalRoot()
+	stateFile := filepath.Join(t.TempDir(), "state.json.gz")
+
+	// Directories changed long enough ago to be trusted
+	old := time.Now().Add(-time.Hour)
+	for _, dir := range []string{"a", "b"} {
+		os.Mkdir(filepath.Join(tmpDir, dir), 0o755)
+		os.WriteFile(filepath.Join(tmpDir, dir, "1.txt"), nil, 0o644)
+		os.Chtimes(filepath.Join(tmpDir, dir), old, old)
+	}
+
+	run := func() int {
+		t.Helper()
+		result, err := Run(rec, Options{Logger: quietLogger(), SkipEvents: true, StateFile: stateFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.IssuesFound["disk_index"]
+	}
+
+	if got := run(); got != 2 {
+		t.Fatalf("first run: %d files not in index, want 2", got)
+	}
+
+	// A file slipped into a with its mtime kept is not seen: the
+	// directory is taken from the state
+	os.WriteFile(filepath.Join(tmpDir, "a", "2.txt"), nil, 0o644)
+	os.Chtimes(filepath.Join(tmpDir, "a"), old, old)
+	if got := run(); got != 2 {
+		t.Errorf("unchanged directory read again: %d files not in index, want 2", got)
+	}
+
+	// Once the mtime changes, it is
+	os.Chtimes(filepath.Join(tmpDir, "a"), old.Add(time.Minute), old.Add(time.Minute))
+	if got := run(); got != 3 {
+		t.Errorf("changed directory not read: %d files not in index, want 3", got)
+	}
+
+	// Without the state file every directory is read
+	os.RemoveAll(filepath.Join(tmpDir, "b"))
+	os.Remove(stateFile)
+	if got := run(); got != 2 {
+		t.Errorf("fresh state: %d files not in index, want 2", got)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	sha := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" // "foo"
+	manifest := filepath.Join(t.TempDir(), "SHA256SUMS")
+	os.WriteFile(manifest, []byte("# comment\n\n"+
+		sha+"  ./a/text.txt\n"+
+		sha+" *bin.tar.gz\n"+
+		"SHA256 (name with spaces) = "+strings.ToUpper(sha)+"\n"+
+		"acbd18db4cc2f85cedef654fccc4a4d8  md5.txt\n"), 0o644)
+
+	sums, err := readManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []checksum{
+		{"a/text.txt", sha},
+		{"bin.tar.gz", sha},
+		{"name with spaces", sha},
+		{"md5.txt", "acbd18db4cc2f85cedef654fccc4a4d8"},
+	}
+	if !slices.Equal(sums, want) {
+		t.Errorf("got %v, want %v", sums, want)
+	}
+
+	os.WriteFile(manifest, []byte("xyz  file\n"), 0o644)
+	if _, err := readManifest(manifest); err == nil {
+		t.Error("expected error for a bad digest")
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	rec, _ := setupTest(t)
+	tmpDir := rec.LocalRoot()
+
+	var manifest strings.Builder
+	for i := range 10 {
+		name := fmt.Sprintf("f%d", i)
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("foo"), 0o644)
+		fmt.Fprintf(&manifest, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  %s\n", name)
+	}
+	manifest.WriteString("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  gone\n")
+	os.WriteFile(filepath.Join(tmpDir, "f3"), []byte("bar"), 0o644) // corrupted
+	manifestFile := filepath.Join(t.TempDir(), "SHA256SUMS")
+	os.WriteFile(manifestFile, []byte(manifest.String()), 0o644)
+
+	opts := Options{Logger: quietLogger(), ChecksumManifest: manifestFile}
+	if got := verifyChecksums(rec, opts); got != 1 {
+		t.Errorf("got %d issues, want 1", got)
+	}
+
+	// With every file corrupted, the limit and sample decide how many
+	// are found
+	for i := range 10 {
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%d", i)), []byte("bar"), 0o644)
+	}
+	os.WriteFile(manifestFile, []byte(strings.TrimSuffix(manifest.String(), "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  gone\n")), 0o644)
+	opts.ChecksumLimit = 3
+	if got := verifyChecksums(rec, opts); got != 3 {
+		t.Errorf("limit 3: got %d issues, want 3", got)
+	}
+	opts.ChecksumLimit = 0
+	opts.ChecksumSample = 1e-9
+	if got := verifyChecksums(rec, opts); got != 0 {
+		t.Errorf("tiny sample: got %d issues, want 0", got)
+	}
+}
+
+func TestChainCoverage(t *testing.T) {
+	rec, rfs := setupTest(t)
+	now := recentfile.EpochToFloat(recentfile.EpochNow())
+
+	// The 6h file ends three hours ago and the 1h file starts ten minutes
+	// ago: what happened in between is in neither
+	if err := rfs[1].BatchUpdate([]recentfile.BatchItem{
+		{Path: "old.txt", Type: "new", Epoch: recentfile.EpochFromFloat(now - 3*3600)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfs[0].BatchUpdate([]recentfile.BatchItem{
+		{Path: "new.txt", Type: "new", Epoch: recentfile.EpochFromFloat(now - 600)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkChainCoverage(rec, Options{Logger: quietLogger()}); got != 1 {
+		t.Errorf("got %d holes, want 1", got)
+	}
+
+	// Aggregating merges the 1h file into the 6h one
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkChainCoverage(rec, Options{Logger: quietLogger()}); got != 0 {
+		t.Errorf("after aggregation: got %d holes, want 0", got)
+	}
+}
+
+func TestEventOrder(t *testing.T) {
+	rec, rfs := setupTest(t)
+	now := recentfile.EpochToFloat(recentfile.EpochNow())
+
+	// Written out of order, without minmax
+	rfs[0].Lock()
+	rfs[0].SetRecentEvents([]recentfile.Event{
+		{Path: "b.txt", Type: "delete", Epoch: recentfile.EpochFromFloat(now - 20)},
+		{Path: "c.txt", Type: "delete", Epoch: recentfile.EpochFromFloat(now - 10)},
+		{Path: "a.txt", Type: "delete", Epoch: recentfile.EpochFromFloat(now - 30)},
+	})
+	if err := rfs[0].Write(); err != nil {
+		t.Fatal(err)
+	}
+	rfs[0].Unlock()
+
+	// A dirtymark the principal doesn't have
+	rfs[1].SetDirtymark(recentfile.EpochFromFloat(now))
+	rfs[1].Lock()
+	rfs[1].Write()
+	rfs[1].Unlock()
+
+	opts := Options{Logger: quietLogger()}
+	if got := checkEventOrder(rec, opts); got != 2 {
+		t.Errorf("got %d issues, want 2 (order and minmax)", got)
+	}
+	if got := checkDirtymarks(rec, opts); got != 1 {
+		t.Errorf("got %d dirtymark differences, want 1", got)
+	}
+
+	opts.Repair = true
+	opts.Repairs = []string{RepairOrder}
+	if _, err := Run(rec, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkEventOrder(rec, opts); got != 0 {
+		t.Errorf("after repair: got %d issues, want 0", got)
+	}
+	if err := rfs[0].Read(); err != nil {
+		t.Fatal(err)
+	}
+	if events := rfs[0].RecentEvents(); events[0].Path != "c.txt" || events[2].Path != "a.txt" {
+		t.Errorf("events not sorted: %v", events)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	rec, _ := setupTest(t)
+	link := filepath.Join(rec.LocalRoot(), "RECENT.recent")
+	opts := Options{Logger: quietLogger()}
+
+	if got := checkSymlink(rec, opts); got != 1 {
+		t.Errorf("missing: got %d issues, want 1", got)
+	}
+	for _, target := range []string{"RECENT-6h.yaml", "RECENT-1h.json"} {
+		os.Remove(link)
+		os.Symlink(target, link)
+		if got := checkSymlink(rec, opts); got != 1 {
+			t.Errorf("pointing at %s: got %d issues, want 1", target, got)
+		}
+	}
+	os.Remove(link)
+	os.WriteFile(link, nil, 0o644)
+	if got := checkSymlink(rec, opts); got != 1 {
+		t.Errorf("regular file: got %d issues, want 1", got)
+	}
+
+	opts.Repair = true
+	opts.Repairs = []string{RepairSymlink}
+	if _, err := Run(rec, opts); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != "RECENT-1h.yaml" {
+		t.Errorf("after repair: symlink points at %q (%v)", target, err)
+	}
+	if got := checkSymlink(rec, opts); got != 0 {
+		t.Errorf("after repair: got %d issues, want 0", got)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	rec, rfs := setupTest(t)
+	tmpDir := rec.LocalRoot()
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0o644)
+		if err := rfs[0].Update(filepath.Join(tmpDir, name), "new", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(rfs[0].Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbled := strings.Replace(string(data), "path: b.txt", "path: [b.txt", 1)
+	if err := os.WriteFile(rfs[0].Rfile(), []byte(garbled), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Logger: quietLogger()}
+	if got := checkFileIntegrity(rec, opts); got != 1 {
+		t.Errorf("damaged: got %d issues, want 1", got)
+	}
+
+	opts.Repair = true
+	opts.Repairs = []string{RepairCorrupt}
+	if _, err := Run(rec, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkFileIntegrity(rec, opts); got != 0 {
+		t.Errorf("after repair: got %d issues, want 0", got)
+	}
+	rf, err := recentfile.NewFromFile(rfs[0].Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, event := range rf.RecentEvents() {
+		paths = append(paths, event.Path)
+	}
+	if want := []string{"c.txt", "a.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("after repair: paths = %v, want %v", paths, want)
+	}
+}
+
+func TestFutureEpochs(t *testing.T) {
+	rec, rfs := setupTest(t)
+	now := recentfile.EpochToFloat(recentfile.EpochNow())
+
+	// Two events from a host with its clock a day ahead, and one nudged
+	// above them when it was added
+	for _, rf := range rfs {
+		rf.Lock()
+		rf.SetRecentEvents([]recentfile.Event{
+			{Path: "c.txt", Type: "new", Epoch: recentfile.EpochFromFloat(now + 86400.00002)},
+			{Path: "b.txt", Type: "new", Epoch: recentfile.EpochFromFloat(now + 86400.00001)},
+			{Path: "a.txt", Type: "new", Epoch: recentfile.EpochFromFloat(now + 86400)},
+			{Path: "old.txt", Type: "new", Epoch: recentfile.EpochFromFloat(now - 60)},
+		})
+		rf.SortEvents()
+		if err := rf.Write(); err != nil {
+			t.Fatal(err)
+		}
+		rf.Unlock()
+	}
+
+	opts := Options{Logger: quietLogger()}
+	if got := checkFutureEpochs(rec, opts); got != 2 {
+		t.Errorf("got %d issues, want 2 (one per file)", got)
+	}
+	opts.MaxClockSkew = 48 * time.Hour
+	if got := checkFutureEpochs(rec, opts); got != 0 {
+		t.Errorf("with 48h skew: got %d issues, want 0", got)
+	}
+	opts.MaxClockSkew = 0
+
+	opts.Repair = true
+	opts.Repairs = []string{RepairFutureEpochs}
+	if _, err := Run(rec, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkFutureEpochs(rec, opts); got != 0 {
+		t.Errorf("after repair: got %d issues, want 0", got)
+	}
+	if got := checkEventOrder(rec, opts); got != 0 {
+		t.Errorf("after repair: got %d order issues, want 0", got)
+	}
+
+	var principal []recentfile.Event
+	for i, rf := range rfs {
+		if err := rf.Read(); err != nil {
+			t.Fatal(err)
+		}
+		events := rf.RecentEvents()
+		if events[0].Path != "c.txt" || events[2].Path != "a.txt" || events[3].Path != "old.txt" {
+			t.Errorf("%s: events out of order: %v", rf.Interval(), events)
+		}
+		if i == 0 {
+			principal = events
+		} else if !slices.Equal(events, principal) {
+			t.Errorf("%s: epochs differ from the principal's: %v, %v", rf.Interval(), events, principal)
+		}
+	}
+}
+
+func TestEventSample(t *testing.T) {
+	rec, rfs := setupTest(t)
+	now := recentfile.EpochToFloat(recentfile.EpochNow())
+
+	// Indexed, none of them on disk
+	var events []recentfile.Event
+	for i := range 5 {
+		events = append(events, recentfile.Event{
+			Path:  fmt.Sprintf("dir%d/file.txt", i),
+			Type:  "new",
+			Epoch: recentfile.EpochFromFloat(now - float64(i)),
+		})
+	}
+	rfs[0].Lock()
+	rfs[0].SetRecentEvents(events)
+	if err := rfs[0].Write(); err != nil {
+		t.Fatal(err)
+	}
+	rfs[0].Unlock()
+	rfs[1].Lock()
+	rfs[1].Write()
+	rfs[1].Unlock()
+
+	filter, err := pathfilter.New([]string{"dir0"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want int
+	}{
+		{"sample", Options{Sample: 2}, 2},
+		{"default sample", Options{}, 5},
+		{"full", Options{Sample: 2, Full: true}, 5},
+		{"excluded", Options{Full: true, Filter: filter}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Logger = quietLogger()
+			if got := verifyEventsMatchFilesystem(rec, tt.opts); got != tt.want {
+				t.Errorf("got %d issues, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventChecksums(t *testing.T) {
+	rec, rfs := setupTest(t)
+	rec.SetProtocolExt(true)
+	root := rec.LocalRoot()
+
+	var batch []recentfile.BatchItem
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, recentfile.BatchItem{Path: path, Type: "new"})
+	}
+	if err := rec.BatchUpdate(batch); err != nil {
+		t.Fatal(err)
+	}
+	rfs[1].Lock()
+	rfs[1].Write()
+	rfs[1].Unlock()
+
+	opts := Options{Full: true, Logger: quietLogger()}
+	if got := verifyEventsMatchFilesystem(rec, opts); got != 0 {
+		t.Errorf("got %d issues, want 0", got)
+	}
+
+	// Changed without an event
+	if err := os.WriteFile(filepath.Join(root, "b.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyEventsMatchFilesystem(rec, opts); got != 1 {
+		t.Errorf("got %d issues, want 1", got)
+	}
+}
@@ -2,8 +2,8 @@ package fsck
 
 import (
 	"fmt"
-	"path/filepath"
 
+	"github.com/abh/rrrgo/archive"
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
 )
@@ -11,29 +11,11 @@ import (
 // buildCurrentIndexState returns paths that should exist on disk according to
 // the current state of all RECENT files (where most recent event type is "new").
 // This correctly handles files with multiple events by keeping only the most recent.
-func buildCurrentIndexState(rec *recent.Recent) (map[string]bool, error) {
-	// Build state map of path -> most recent event
-	stateMap := make(map[string]recentfile.Event)
-	recentfiles := rec.Recentfiles()
-
-	for _, rf := range recentfiles {
-		rfilePath := rf.Rfile()
-		_, err := recentfile.StreamEvents(rfilePath, 10000, func(events []recentfile.Event) bool {
-			for _, event := range events {
-				// Keep the event with the highest epoch for each path
-				if existing, ok := stateMap[event.Path]; ok {
-					if recentfile.EpochGt(event.Epoch, existing.Epoch) {
-						stateMap[event.Path] = event
-					}
-				} else {
-					stateMap[event.Path] = event
-				}
-			}
-			return true
-		})
-		if err != nil {
-			return nil, fmt.Errorf("read %s: %w", filepath.Base(rfilePath), err)
-		}
+// Events rotated out of Z into archiveDir are included when archiveDir is set.
+func buildCurrentIndexState(rec *recent.Recent, archiveDir string) (map[string]bool, error) {
+	stateMap, err := IndexState(rec, archiveDir)
+	if err != nil {
+		return nil, err
 	}
 
 	// Build set of paths that should exist (where most recent event is "new")
@@ -46,3 +28,35 @@ func buildCurrentIndexState(rec *recent.Recent) (map[string]bool, error) {
 
 	return indexPaths, nil
 }
+
+// IndexState returns the most recent event for every path in the RECENT
+// files (see recent.Recent.CurrentState), and in archiveDir when it is set.
+func IndexState(rec *recent.Recent, archiveDir string) (map[string]recentfile.Event, error) {
+	stateMap, err := rec.CurrentState()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := addArchivedEvents(stateMap, archiveDir); err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+
+	return stateMap, nil
+}
+
+// addArchivedEvents merges the events archived in dir into stateMap, keeping
+// the newest event per path. It does nothing if dir is empty.
+func addArchivedEvents(stateMap map[string]recentfile.Event, dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	return archive.StreamEvents(dir, 10000, func(events []recentfile.Event) bool {
+		for _, event := range events {
+			if existing, ok := stateMap[event.Path]; !ok || recentfile.EpochGt(event.Epoch, existing.Epoch) {
+				stateMap[event.Path] = event
+			}
+		}
+		return true
+	})
+}
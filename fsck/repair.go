@@ -1,41 +1,125 @@
 package fsck
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
 )
 
-// repairIssues attempts to fix issues found during validation.
+// The repairs fsck makes, selected with Options.Repairs.
+const (
+	RepairFiles         = "files"          // Create missing recentfiles
+	RepairCorrupt       = "corrupt"        // Rebuild damaged recentfiles from the events that can be read
+	RepairSymlink       = "symlink"        // Point RECENT.recent at the principal
+	RepairIndexOrphans  = "index-orphans"  // Add new events for files on disk but not in the index
+	RepairMissingEvents = "missing-events" // Add delete events for indexed files missing from disk
+	RepairFutureEpochs  = "future-epochs"  // Move events dated in the future back to now
+	RepairOrder         = "order"          // Sort events by epoch and fix minmax
+	RepairEpochs        = "epochs"         // Quantize epochs to 10µs and fix collisions
+)
+
+// AllRepairs lists every repair, in the order they are made.
+var AllRepairs = []string{RepairFiles, RepairCorrupt, RepairSymlink, RepairIndexOrphans, RepairMissingEvents, RepairFutureEpochs, RepairOrder, RepairEpochs}
+
+// SelectRepairs returns the repairs named in only (all of them if only is
+// empty) except those named in skip, for Options.Repairs. It fails on
+// unknown names and when no repair is left.
+func SelectRepairs(only, skip []string) ([]string, error) {
+	for _, name := range slices.Concat(only, skip) {
+		if !slices.Contains(AllRepairs, name) {
+			return nil, fmt.Errorf("unknown repair %q (want one of %s)", name, strings.Join(AllRepairs, ", "))
+		}
+	}
+
+	repairs := []string{}
+	for _, name := range AllRepairs {
+		if (len(only) == 0 || slices.Contains(only, name)) && !slices.Contains(skip, name) {
+			repairs = append(repairs, name)
+		}
+	}
+	if len(repairs) == 0 {
+		return nil, fmt.Errorf("no repairs selected")
+	}
+	return repairs, nil
+}
+
+// repairs reports whether the repair called name is to be made.
+func (opts Options) repairs(name string) bool {
+	return opts.Repairs == nil || slices.Contains(opts.Repairs, name)
+}
+
+// repairIssues attempts to fix issues found during validation, making the
+// repairs selected in opts.
 // Returns epoch repair statistics: (epochsQuantized, epochsDeduplicated, error)
 func repairIssues(rec *recent.Recent, opts Options) (int, int, error) {
 	// Ensure all files exist
-	if opts.Verbose {
-		opts.Logger.Debug("ensuring all recentfiles exist")
+	if opts.repairs(RepairFiles) {
+		if opts.Verbose {
+			opts.Logger.Debug("ensuring all recentfiles exist")
+		}
+
+		if err := rec.EnsureFilesExist(); err != nil {
+			return 0, 0, fmt.Errorf("ensure files exist: %w", err)
+		}
+
+		if opts.Verbose {
+			opts.Logger.Debug("all files ensured")
+		}
 	}
 
-	if err := rec.EnsureFilesExist(); err != nil {
-		return 0, 0, fmt.Errorf("ensure files exist: %w", err)
+	// Rebuild damaged files from what can be read of them
+	if opts.repairs(RepairCorrupt) {
+		if err := repairCorrupt(rec, opts); err != nil {
+			return 0, 0, err
+		}
 	}
 
-	if opts.Verbose {
-		opts.Logger.Debug("all files ensured")
+	// Recreate the RECENT.recent symlink
+	if opts.repairs(RepairSymlink) {
+		if err := rec.PrincipalRecentfile().AssertSymlink(); err != nil {
+			return 0, 0, fmt.Errorf("repair symlink: %w", err)
+		}
 	}
 
 	// Repair disk→index mismatches (files on disk but not in index)
-	if err := repairIndexOrphans(rec, opts); err != nil {
-		return 0, 0, err
+	if opts.repairs(RepairIndexOrphans) {
+		if err := repairIndexOrphans(rec, opts); err != nil {
+			return 0, 0, err
+		}
 	}
 
 	// Repair index→disk mismatches (files in index but not on disk)
-	if err := repairIndexMismatches(rec, opts); err != nil {
-		return 0, 0, err
+	if opts.repairs(RepairMissingEvents) {
+		if err := repairIndexMismatches(rec, opts); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Move events dated in the future back to now
+	if opts.repairs(RepairFutureEpochs) {
+		if err := repairFutureEpochs(rec, opts); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Repair event order and minmax
+	if opts.repairs(RepairOrder) {
+		if err := repairOrder(rec, opts); err != nil {
+			return 0, 0, err
+		}
 	}
 
 	// Repair epochs (quantize to 10µs and deduplicate)
+	if !opts.repairs(RepairEpochs) {
+		return 0, 0, nil
+	}
 	quantized, deduplicated, err := repairEpochs(rec, opts)
 	if err != nil {
 		return 0, 0, err
@@ -44,6 +128,169 @@ func repairIssues(rec *recent.Recent, opts Options) (int, int, error) {
 	return quantized, deduplicated, nil
 }
 
+// repairCorrupt rewrites every recentfile that doesn't read, or has
+// invalid events, with the events recentfile.Recentfile.ReadTolerant
+// recovers of it, so the other repairs and the clients can read it again.
+func repairCorrupt(rec *recent.Recent, opts Options) error {
+	for _, rf := range rec.Recentfiles() {
+		// Missing files are left alone without the files repair
+		if _, err := os.Stat(rf.Rfile()); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := repairCorruptFile(rf, opts); err != nil {
+			return fmt.Errorf("repair corrupt %s: %w", filepath.Base(rf.Rfile()), err)
+		}
+	}
+	return nil
+}
+
+// repairCorruptFile rebuilds rf if it is damaged.
+func repairCorruptFile(rf *recentfile.Recentfile, opts Options) error {
+	if err := rf.Lock(); err != nil {
+		return err
+	}
+	defer rf.Unlock()
+
+	err := rf.Read()
+	if err != nil && !errors.Is(err, recentfile.ErrCorrupt) {
+		return err
+	}
+	if err == nil && !slices.ContainsFunc(rf.RecentEvents(), func(e recentfile.Event) bool {
+		return !e.Valid()
+	}) {
+		return nil
+	}
+
+	dropped, err := rf.ReadTolerant()
+	if err != nil {
+		return err
+	}
+	if err := rf.Write(); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	opts.Logger.Info("rebuilt damaged file", "file", filepath.Base(rf.Rfile()), "events", len(rf.RecentEvents()), "dropped", dropped)
+	return nil
+}
+
+// repairOrder sorts the events of every recentfile by epoch, newest first,
+// and sets its minmax to match, rewriting only files that change.
+func repairOrder(rec *recent.Recent, opts Options) error {
+	for _, rf := range rec.Recentfiles() {
+		// Missing files are left alone without the files repair
+		if _, err := os.Stat(rf.Rfile()); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := repairOrderInFile(rf, opts); err != nil {
+			return fmt.Errorf("repair order in %s: %w", filepath.Base(rf.Rfile()), err)
+		}
+	}
+	return nil
+}
+
+// repairOrderInFile sorts the events of rf and fixes its minmax.
+func repairOrderInFile(rf *recentfile.Recentfile, opts Options) error {
+	if err := rf.Lock(); err != nil {
+		return err
+	}
+	defer rf.Unlock()
+
+	if err := rf.Read(); err != nil {
+		return err
+	}
+
+	before := rf.RecentEvents()
+	minmax := rf.Meta().Minmax
+	rf.SortEvents()
+	after := rf.RecentEvents()
+
+	sorted := !slices.Equal(before, after)
+	fixed := !minmaxMatches(minmax, rf.Meta().Minmax)
+	if !sorted && !fixed {
+		return nil
+	}
+	if err := rf.Write(); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	opts.Logger.Info("repaired event order", "file", filepath.Base(rf.Rfile()), "sorted", sorted, "minmax_fixed", fixed)
+	return nil
+}
+
+// repairFutureEpochs gives the events dated ahead of the clock by more than
+// opts.MaxClockSkew epochs just before now, keeping their order. The same
+// now is used for every recentfile, so an event moved in more than one of
+// them gets the same epoch in each.
+func repairFutureEpochs(rec *recent.Recent, opts Options) error {
+	now := time.Now()
+	limit := recentfile.EpochFromTime(now.Add(opts.maxClockSkew()))
+
+	for _, rf := range rec.Recentfiles() {
+		// Missing files are left alone without the files repair
+		if _, err := os.Stat(rf.Rfile()); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := repairFutureEpochsInFile(rf, now, limit, opts); err != nil {
+			return fmt.Errorf("repair future epochs in %s: %w", filepath.Base(rf.Rfile()), err)
+		}
+	}
+	return nil
+}
+
+// repairFutureEpochsInFile moves the events of rf newer than limit to now
+// and the epochs 10µs apart just below it, newest first, and sorts the
+// events again.
+func repairFutureEpochsInFile(rf *recentfile.Recentfile, now time.Time, limit recentfile.Epoch, opts Options) error {
+	if err := rf.Lock(); err != nil {
+		return err
+	}
+	defer rf.Unlock()
+
+	if err := rf.Read(); err != nil {
+		return err
+	}
+
+	events := rf.RecentEvents()
+	var future []int
+	for i, event := range events {
+		if recentfile.EpochGt(event.Epoch, limit) {
+			future = append(future, i)
+		}
+	}
+	if len(future) == 0 {
+		return nil
+	}
+
+	// Keep the order of the moved events among themselves
+	slices.SortStableFunc(future, func(a, b int) int {
+		return recentfile.EpochCompare(events[b].Epoch, events[a].Epoch)
+	})
+	for n, i := range future {
+		events[i].Epoch = recentfile.EpochFromTime(now.Add(-time.Duration(n) * 10 * time.Microsecond))
+	}
+
+	// Events just before now may now share an epoch with a moved one
+	rf.SetRecentEvents(rf.DeduplicateEpochs(events))
+	rf.SortEvents()
+	if err := rf.Write(); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	opts.Logger.Info("moved events dated in the future to now", "file", filepath.Base(rf.Rfile()), "count", len(future))
+	return nil
+}
+
+// minmaxMatches reports whether two minmax values name the same epochs.
+func minmaxMatches(a, b *recentfile.MinmaxInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Min == b.Min && a.Max == b.Max
+}
+
 // repairEpochs quantizes epochs to 10µs precision and deduplicates collisions.
 // Returns statistics about epochs quantized and collisions fixed.
 func repairEpochs(rec *recent.Recent, opts Options) (quantized int, deduplicated int, err error) {
@@ -53,6 +300,11 @@ func repairEpochs(rec *recent.Recent, opts Options) (quantized int, deduplicated
 
 	recentfiles := rec.Recentfiles()
 	for _, rf := range recentfiles {
+		// Missing files are left alone without the files repair
+		if _, err := os.Stat(rf.Rfile()); os.IsNotExist(err) {
+			continue
+		}
+
 		q, d, err := repairEpochsInFile(rf, opts)
 		if err != nil {
 			return quantized, deduplicated, fmt.Errorf("repair epochs in %s: %w", filepath.Base(rf.Rfile()), err)
@@ -83,6 +335,12 @@ func repairEpochs(rec *recent.Recent, opts Options) (quantized int, deduplicated
 
 // repairEpochsInFile quantizes and deduplicates epochs in a single recentfile.
 func repairEpochsInFile(rf *recentfile.Recentfile, opts Options) (quantized int, deduplicated int, err error) {
+	// Hold the lock, rrr-server may be writing the file
+	if err := rf.Lock(); err != nil {
+		return 0, 0, err
+	}
+	defer rf.Unlock()
+
 	// Read the file
 	if err := rf.Read(); err != nil {
 		return 0, 0, err
@@ -139,23 +397,16 @@ func repairEpochsInFile(rf *recentfile.Recentfile, opts Options) (quantized int,
 // repairIndexOrphans adds files on disk but not in index to the principal RECENT file.
 // Disk is considered authoritative.
 func repairIndexOrphans(rec *recent.Recent, opts Options) error {
-	localRoot := rec.LocalRoot()
-
 	if opts.Verbose {
 		opts.Logger.Debug("finding files on disk not in index")
 	}
 
 	// Build set of paths that should exist according to index
-	indexPaths, err := buildCurrentIndexState(rec)
+	indexPaths, err := buildCurrentIndexState(rec, opts.ArchiveDir)
 	if err != nil {
 		return fmt.Errorf("build index state: %w", err)
 	}
 
-	// Get ignore pattern for RECENT files
-	meta := rec.PrincipalRecentfile().Meta()
-	filenameRoot := meta.Filenameroot
-	serializerSuffix := meta.SerializerSuffix
-
 	if opts.Verbose {
 		opts.Logger.Debug("loaded paths from index", "count", len(indexPaths))
 		opts.Logger.Debug("scanning disk for orphaned files")
@@ -164,61 +415,15 @@ func repairIndexOrphans(rec *recent.Recent, opts Options) error {
 	// Collect files to add
 	var batch []recentfile.BatchItem
 
-	err = filepath.Walk(localRoot, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip paths we can't access
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Get relative path
-		relPath, err := filepath.Rel(localRoot, path)
-		if err != nil {
-			return nil // Skip if we can't get relative path
-		}
-
-		// Normalize path separators for cross-platform compatibility
-		relPath = filepath.ToSlash(relPath)
-
-		// Skip current and parent directory markers
-		if relPath == "." || relPath == ".." {
-			return nil
-		}
-
-		// Skip RECENT files managed by rrr-server (only in root, not subdirectories)
-		baseName := filepath.Base(path)
-		if len(baseName) >= len(filenameRoot) && baseName[:len(filenameRoot)] == filenameRoot {
-			// Only skip RECENT files if they're in the root directory
-			// Subdirectory RECENT files (modules/RECENT-*, authors/RECENT.recent) are mirrored content
-			inRootDir := filepath.Dir(relPath) == "."
-
-			// Check for .recent symlink
-			if baseName == filenameRoot+".recent" && inRootDir {
-				return nil // Skip root RECENT.recent (managed by rrr-server)
-			}
-
-			// Check if it's a RECENT file pattern (RECENT-*)
-			if len(baseName) > len(filenameRoot)+1 && baseName[len(filenameRoot)] == '-' {
-				// Skip only root RECENT-* files, not subdirectory ones
-				if inRootDir {
-					if filepath.Ext(baseName) == serializerSuffix ||
-						filepath.Ext(baseName) == ".lock" ||
-						filepath.Ext(baseName) == ".new" {
-						return nil // Skip root RECENT-* files
-					}
-				}
-			}
-		}
-
+	walkDisk(rec, opts, func(relPath string) {
 		// Check if in index
 		if !indexPaths[relPath] {
 			// File not in index - add to batch
 			// Use current time (zero epoch) so old files don't get immediately truncated
 			if opts.Verbose {
-				opts.Logger.Debug("adding file to index", "path", relPath, "mtime", info.ModTime().Unix())
+				if info, err := os.Lstat(filepath.Join(rec.LocalRoot(), relPath)); err == nil {
+					opts.Logger.Debug("adding file to index", "path", relPath, "mtime", info.ModTime().Unix())
+				}
 			}
 
 			batch = append(batch, recentfile.BatchItem{
@@ -227,12 +432,7 @@ func repairIndexOrphans(rec *recent.Recent, opts Options) error {
 				Epoch: recentfile.Epoch(0), // Use current time, not file mtime
 			})
 		}
-
-		return nil
 	})
-	if err != nil {
-		return fmt.Errorf("walk directory: %w", err)
-	}
 
 	if len(batch) == 0 {
 		if opts.Verbose {
@@ -241,6 +441,11 @@ func repairIndexOrphans(rec *recent.Recent, opts Options) error {
 		return nil
 	}
 
+	// The walk finds files in no particular order
+	slices.SortFunc(batch, func(a, b recentfile.BatchItem) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
 	opts.Logger.Info("adding files to index", "count", len(batch))
 
 	// Add to principal RECENT file
@@ -258,101 +463,43 @@ func repairIndexOrphans(rec *recent.Recent, opts Options) error {
 // Disk is considered authoritative - if a file is in the index but not on disk,
 // it means the file was deleted and we need to record that in the index.
 func repairIndexMismatches(rec *recent.Recent, opts Options) error {
-	localRoot := rec.LocalRoot()
-
 	if opts.Verbose {
 		opts.Logger.Debug("finding files in index but not on disk")
 	}
 
-	// Build set of paths that exist on disk
-	diskPaths := make(map[string]bool)
-
-	// Get ignore pattern for RECENT files
-	meta := rec.PrincipalRecentfile().Meta()
-	filenameRoot := meta.Filenameroot
-	serializerSuffix := meta.SerializerSuffix
-
-	// Walk disk to build set of existing files
-	err := filepath.Walk(localRoot, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip paths we can't access
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Get relative path
-		relPath, err := filepath.Rel(localRoot, path)
-		if err != nil {
-			return nil
-		}
-
-		// Normalize path separators
-		relPath = filepath.ToSlash(relPath)
-
-		// Skip current and parent directory markers
-		if relPath == "." || relPath == ".." {
-			return nil
-		}
-
-		// Skip temporary files
-		baseName := filepath.Base(path)
-		if recentfile.ShouldIgnoreFile(baseName) {
-			return nil
-		}
-
-		// Skip RECENT files managed by rrr-server (only in root, not subdirectories)
-		if len(baseName) >= len(filenameRoot) && baseName[:len(filenameRoot)] == filenameRoot {
-			// Only skip RECENT files if they're in the root directory
-			// Subdirectory RECENT files (modules/RECENT-*, authors/RECENT.recent) are mirrored content
-			inRootDir := filepath.Dir(relPath) == "."
-
-			// Check for .recent symlink
-			if baseName == filenameRoot+".recent" && inRootDir {
-				return nil // Skip root RECENT.recent (managed by rrr-server)
-			}
-
-			// Check if it's a RECENT file pattern (RECENT-*)
-			if len(baseName) > len(filenameRoot)+1 && baseName[len(filenameRoot)] == '-' {
-				// Skip only root RECENT-* files, not subdirectory ones
-				if inRootDir {
-					if filepath.Ext(baseName) == serializerSuffix ||
-						filepath.Ext(baseName) == ".lock" ||
-						filepath.Ext(baseName) == ".new" {
-						return nil // Skip root RECENT-* files
-					}
-				}
-			}
-		}
-
-		diskPaths[relPath] = true
-		return nil
-	})
+	// Build set of paths that should exist according to index
+	indexPaths, err := buildCurrentIndexState(rec, opts.ArchiveDir)
 	if err != nil {
-		return fmt.Errorf("walk directory: %w", err)
+		return fmt.Errorf("build index state: %w", err)
 	}
 
+	// Walk disk, removing the files found from the set, so the paths on
+	// disk don't have to be kept as well
+	diskFiles := 0
+	walkDisk(rec, opts, func(relPath string) {
+		diskFiles++
+		delete(indexPaths, relPath)
+	})
+
 	if opts.Verbose {
-		opts.Logger.Debug("found files on disk", "count", len(diskPaths))
+		opts.Logger.Debug("found files on disk", "count", diskFiles)
 		opts.Logger.Debug("checking index for missing files")
 	}
 
-	// Build set of paths that should exist according to index
-	indexPaths, err := buildCurrentIndexState(rec)
-	if err != nil {
-		return fmt.Errorf("build index state: %w", err)
-	}
-
 	var missingPaths []string
 
-	// Find files in index but not on disk
+	// Find files in index but not on disk. Directories recorded with
+	// events of their own (see recentfile.Event.Dir) are not walked.
 	for path := range indexPaths {
-		if !diskPaths[path] {
-			missingPaths = append(missingPaths, path)
+		if opts.Filter.Ignored(path) {
+			continue
+		}
+		if fi, err := os.Lstat(filepath.Join(rec.LocalRoot(), path)); err == nil && fi.IsDir() {
+			continue
 		}
+		missingPaths = append(missingPaths, path)
 	}
+	slices.Sort(missingPaths)
 
 	if len(missingPaths) == 0 {
 		if opts.Verbose {
@@ -0,0 +1,178 @@
+package fsck
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateVersion is bumped when the format of the state file changes; a
+// state file of another version is ignored.
+const stateVersion = 1
+
+// racyWindow is how long after it was read a directory's mtime must lie
+// for its contents to be trusted: a change within the same mtime tick as
+// the read would otherwise go unnoticed.
+const racyWindow = 2 * time.Second
+
+// diskState is the result of a disk scan, kept in Options.StateFile
+// between runs: the entries of every directory read, with the directory's
+// mtime at the time.
+type diskState struct {
+	Version int                  `json:"version"`
+	Root    string               `json:"root"`
+	Time    time.Time            `json:"time"`
+	Dirs    map[string]*dirState `json:"dirs"` // by slash-separated path, "." for the root
+}
+
+// dirState is what a directory held when it was last read.
+type dirState struct {
+	Mtime  int64       `json:"mtime"` // UnixNano
+	Read   int64       `json:"read"`  // UnixNano
+	Files  []fileState `json:"files,omitempty"`
+	Subdir []string    `json:"subdirs,omitempty"`
+}
+
+// fileState is a file as it was when its directory was last read.
+type fileState struct {
+	Name  string `json:"name"`
+	Mtime int64  `json:"mtime"` // UnixNano
+	Size  int64  `json:"size"`
+}
+
+// diskCache answers disk walks from the state of the previous run for the
+// directories that did not change since, and records the state of this
+// run.
+type diskCache struct {
+	path string
+
+	mu        sync.Mutex
+	state     *diskState
+	seen      map[string]bool
+	read      int // directories read from disk
+	unchanged int // directories taken from the state
+}
+
+// loadDiskCache reads the state file at path for the tree at root. A
+// missing file, or one written for another tree or in another format,
+// starts an empty state, so the first run reads every directory.
+func loadDiskCache(path, root string) (*diskCache, error) {
+	c := &diskCache{
+		path:  path,
+		state: &diskState{Version: stateVersion, Root: root, Dirs: make(map[string]*dirState)},
+		seen:  make(map[string]bool),
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open state: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("read state %s: %w", path, err)
+	}
+	var state diskState
+	if err := json.NewDecoder(gz).Decode(&state); err != nil {
+		return nil, fmt.Errorf("read state %s: %w", path, err)
+	}
+	if state.Version == stateVersion && state.Root == root && state.Dirs != nil {
+		c.state = &state
+	}
+	return c, nil
+}
+
+// lookup returns the recorded state of the directory at relPath if it has
+// not changed since it was read: its mtime is the same, and was not too
+// close to the time of the read to tell.
+func (c *diskCache) lookup(relPath string, mtime time.Time) (*dirState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seen[relPath] = true
+	d, ok := c.state.Dirs[relPath]
+	if !ok || d.Mtime != mtime.UnixNano() || time.Unix(0, d.Read).Sub(mtime) < racyWindow {
+		c.read++
+		return nil, false
+	}
+	c.unchanged++
+	return d, true
+}
+
+// store records what the directory at relPath holds.
+func (c *diskCache) store(relPath string, d *dirState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Dirs[relPath] = d
+}
+
+// readDir reads the directory at relPath below root for the cache, with
+// the mtime and size of every file. Directories that cannot be read are
+// recorded as empty.
+func readDir(root, relPath string, mtime time.Time) *dirState {
+	d := &dirState{Mtime: mtime.UnixNano(), Read: time.Now().UnixNano()}
+	entries, _ := os.ReadDir(filepath.Join(root, filepath.FromSlash(relPath)))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			d.Subdir = append(d.Subdir, entry.Name())
+			continue
+		}
+		file := fileState{Name: entry.Name()}
+		if info, err := entry.Info(); err == nil {
+			file.Mtime = info.ModTime().UnixNano()
+			file.Size = info.Size()
+		}
+		d.Files = append(d.Files, file)
+	}
+	return d
+}
+
+// save replaces the state file with the directories seen in this run.
+func (c *diskCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Directories gone since the last run are forgotten
+	for dir := range c.state.Dirs {
+		if !c.seen[dir] {
+			delete(c.state.Dirs, dir)
+		}
+	}
+	c.state.Time = time.Now()
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "."+filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	if err := json.NewEncoder(gz).Encode(c.state); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
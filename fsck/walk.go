@@ -0,0 +1,167 @@
+package fsck
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// walkFiles calls fn with the slash-separated relative path of every file
+// below root, without following symlinks. Up to workers directories are
+// read at once, so the walk is not held up by slow directories on large
+// trees; the directories waiting to be read are kept on a stack, not one
+// goroutine each. fn is called by one goroutine at a time, in no
+// particular order. Directories for which skipDir returns true are not
+// read, and those that cannot be read are skipped. With a cache, only
+// directories changed since the cached state are read.
+func walkFiles(root string, workers int, cache *diskCache, skipDir func(relPath string) bool, fn func(relPath string)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu   sync.Mutex
+		cond = sync.NewCond(&mu)
+		dirs = []string{"."} // waiting to be read
+		busy int             // directories being read
+
+		fnMu sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(dirs) == 0 && busy > 0 {
+					cond.Wait()
+				}
+				if len(dirs) == 0 {
+					mu.Unlock()
+					return
+				}
+				dir := dirs[len(dirs)-1]
+				dirs = dirs[:len(dirs)-1]
+				busy++
+				mu.Unlock()
+
+				files, subdirs := listDir(root, dir, cache)
+				var next []string
+				for _, name := range subdirs {
+					if relPath := path.Join(dir, name); !skipDir(relPath) {
+						next = append(next, relPath)
+					}
+				}
+				fnMu.Lock()
+				for _, name := range files {
+					fn(path.Join(dir, name))
+				}
+				fnMu.Unlock()
+
+				mu.Lock()
+				dirs = append(dirs, next...)
+				busy--
+				mu.Unlock()
+				cond.Broadcast()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// listDir returns the names of the files and of the subdirectories in the
+// directory at relPath below root, from cache if it has not changed.
+func listDir(root, relPath string, cache *diskCache) (files, subdirs []string) {
+	dir := filepath.Join(root, filepath.FromSlash(relPath))
+
+	if cache == nil {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				subdirs = append(subdirs, entry.Name())
+			} else {
+				files = append(files, entry.Name())
+			}
+		}
+		return files, subdirs
+	}
+
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return nil, nil
+	}
+	d, ok := cache.lookup(relPath, fi.ModTime())
+	if !ok {
+		d = readDir(root, relPath, fi.ModTime())
+		cache.store(relPath, d)
+	}
+	for _, file := range d.Files {
+		files = append(files, file.Name)
+	}
+	return files, d.Subdir
+}
+
+// walkDisk calls fn for every file in the local root of rec that fsck
+// compares with the index: those not excluded by opts.Filter, not
+// temporary files and not the hierarchy's own RECENT files.
+func walkDisk(rec *recent.Recent, opts Options, fn func(relPath string)) {
+	meta := rec.PrincipalRecentfile().Meta()
+	filenameRoot := meta.Filenameroot
+	serializerSuffix := meta.SerializerSuffix
+
+	workers := opts.Concurrency
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	walkFiles(rec.LocalRoot(), workers, opts.cache, opts.Filter.IgnoredDir, func(relPath string) {
+		// Skip paths excluded by the ignore and include patterns
+		if opts.Filter.Ignored(relPath) {
+			return
+		}
+
+		// Skip temporary files
+		baseName := path.Base(relPath)
+		if recentfile.ShouldIgnoreFile(baseName) {
+			return
+		}
+
+		// Skip RECENT files managed by rrr-server (only in root, not subdirectories)
+		if strings.HasPrefix(baseName, filenameRoot) {
+			// Only skip RECENT files if they're in the root directory
+			// Subdirectory RECENT files (modules/RECENT-*, authors/RECENT.recent) are mirrored content
+			inRootDir := path.Dir(relPath) == "."
+
+			// Check for .recent symlink
+			if baseName == filenameRoot+".recent" && inRootDir {
+				return // Skip root RECENT.recent (managed by rrr-server)
+			}
+
+			// Check if it's a RECENT file pattern (RECENT-*)
+			if len(baseName) > len(filenameRoot)+1 && baseName[len(filenameRoot)] == '-' {
+				// Skip only root RECENT-* files, not subdirectory ones
+				if inRootDir {
+					if base, ok := recentfile.TrimGeneration(baseName); ok {
+						baseName = strings.TrimSuffix(base, recentfile.SignatureSuffix)
+					}
+					if strings.HasSuffix(baseName, serializerSuffix) ||
+						filepath.Ext(baseName) == ".lock" ||
+						filepath.Ext(baseName) == recentfile.TempSuffix {
+						return // Skip root RECENT-* files
+					}
+				}
+			}
+		}
+
+		fn(relPath)
+	})
+}
@@ -0,0 +1,175 @@
+// Package index maintains an embedded database of the latest event per path,
+// mirroring the state described by a RECENT hierarchy.
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// eventsBucket maps path -> JSON encoded latest Event.
+var eventsBucket = []byte("events")
+
+// DB is a bbolt-backed lookup table of the latest event for each path.
+// It implements sink.Sink so it can be kept current with sink.Run.
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) the index database at path.
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create bucket: %w", err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Apply records events, keeping only the newest event for each path.
+func (d *DB) Apply(events []recentfile.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		for _, event := range events {
+			key := []byte(event.Path)
+
+			// Keep the event with the highest epoch for each path
+			if data := b.Get(key); data != nil {
+				var existing recentfile.Event
+				if err := json.Unmarshal(data, &existing); err == nil &&
+					recentfile.EpochGe(existing.Epoch, event.Epoch) {
+					continue
+				}
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("marshal event %s: %w", event.Path, err)
+			}
+			if err := b.Put(key, data); err != nil {
+				return fmt.Errorf("put %s: %w", event.Path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Publish applies a committed batch; it lets DB be used as a sink.Sink.
+func (d *DB) Publish(ctx context.Context, events []recentfile.Event) error {
+	return d.Apply(events)
+}
+
+// Get returns the latest event recorded for path.
+func (d *DB) Get(path string) (recentfile.Event, bool, error) {
+	var event recentfile.Event
+	found := false
+
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(eventsBucket).Get([]byte(path))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &event)
+	})
+	if err != nil {
+		return recentfile.Event{}, false, fmt.Errorf("get %s: %w", path, err)
+	}
+
+	return event, found, nil
+}
+
+// Prefix calls fn for every path starting with prefix, in path order.
+// Return false from fn to stop early.
+func (d *DB) Prefix(prefix string, fn func(recentfile.Event) bool) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+		p := []byte(prefix)
+
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			var event recentfile.Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return fmt.Errorf("decode %s: %w", k, err)
+			}
+			if !fn(event) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Len returns the number of paths in the index.
+func (d *DB) Len() (int, error) {
+	var n int
+	err := d.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(eventsBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Rebuild replaces the index contents with the state of all recentfiles in rec.
+func (d *DB) Rebuild(rec *recent.Recent) error {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(eventsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(eventsBucket)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reset bucket: %w", err)
+	}
+
+	for _, rf := range rec.Recentfiles() {
+		rfilePath := rf.Rfile()
+
+		// Aggregate files may not have been written yet
+		if _, err := os.Stat(rfilePath); os.IsNotExist(err) {
+			continue
+		}
+
+		var applyErr error
+		_, err := recentfile.StreamEvents(rfilePath, 10000, func(events []recentfile.Event) bool {
+			applyErr = d.Apply(events)
+			return applyErr == nil
+		})
+		if applyErr != nil {
+			return fmt.Errorf("apply %s: %w", filepath.Base(rfilePath), applyErr)
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", filepath.Base(rfilePath), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package index
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func openTestDB(t *testing.T) *DB {
+	db, err := Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestApplyKeepsNewest(t *testing.T) {
+	db := openTestDB(t)
+
+	err := db.Apply([]recentfile.Event{
+		{Epoch: 200, Path: "a.txt", Type: "delete"},
+		{Epoch: 100, Path: "a.txt", Type: "new"}, // older, must not win
+		{Epoch: 150, Path: "b.txt", Type: "new"},
+	})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	event, found, err := db.Get("a.txt")
+	if err != nil || !found {
+		t.Fatalf("Get a.txt: found=%v err=%v", found, err)
+	}
+	if event.Type != "delete" || event.Epoch != 200 {
+		t.Errorf("a.txt = %+v, want delete@200", event)
+	}
+
+	if _, found, _ := db.Get("missing.txt"); found {
+		t.Error("missing.txt should not be found")
+	}
+
+	if n, _ := db.Len(); n != 2 {
+		t.Errorf("Len = %d, want 2", n)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	db := openTestDB(t)
+
+	err := db.Apply([]recentfile.Event{
+		{Epoch: 1, Path: "authors/id/A/AB/Foo-1.0.tar.gz", Type: "new"},
+		{Epoch: 2, Path: "authors/id/A/AB/Foo-1.1.tar.gz", Type: "new"},
+		{Epoch: 3, Path: "authors/id/B/BC/Bar-2.0.tar.gz", Type: "new"},
+		{Epoch: 4, Path: "modules/02packages.details.txt.gz", Type: "new"},
+	})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	var paths []string
+	err = db.Prefix("authors/id/A/", func(e recentfile.Event) bool {
+		paths = append(paths, e.Path)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Prefix failed: %v", err)
+	}
+
+	want := []string{"authors/id/A/AB/Foo-1.0.tar.gz", "authors/id/A/AB/Foo-1.1.tar.gz"}
+	if len(paths) != len(want) {
+		t.Fatalf("Prefix returned %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("paths[%d] = %s, want %s", i, paths[i], want[i])
+		}
+	}
+
+	// Early stop
+	count := 0
+	db.Prefix("", func(e recentfile.Event) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("early stop visited %d paths, want 2", count)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	if err := rec.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := rec.Update(filepath.Join(tmpDir, "b.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	db := openTestDB(t)
+	db.Apply([]recentfile.Event{{Epoch: 1, Path: "stale.txt", Type: "new"}})
+
+	// 6h file was never written; Rebuild must skip it
+	if err := db.Rebuild(rec); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+
+	if n, _ := db.Len(); n != 2 {
+		t.Errorf("Len = %d, want 2", n)
+	}
+	if _, found, _ := db.Get("stale.txt"); found {
+		t.Error("stale.txt should be gone after Rebuild")
+	}
+	if _, found, _ := db.Get("b.txt"); !found {
+		t.Error("b.txt not found after Rebuild")
+	}
+}
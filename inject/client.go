@@ -0,0 +1,55 @@
+package inject
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Client sends events to an injection socket.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+	enc  *json.Encoder
+}
+
+// Dial connects to the injection socket at socketPath.
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	return &Client{conn: conn, r: bufio.NewReader(conn), enc: json.NewEncoder(conn)}, nil
+}
+
+// Send writes events as one batch and waits until they are recorded.
+func (c *Client) Send(events ...recentfile.Event) error {
+	req := struct {
+		Events []recentfile.Event `json:"events"`
+	}{events}
+	if err := c.enc.Encode(req); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
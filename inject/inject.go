@@ -0,0 +1,249 @@
+// Package inject lets producers such as upload pipelines push events
+// straight into RECENT hierarchies over a UNIX socket, without waiting for
+// the filesystem watcher to notice the change.
+//
+// The protocol is newline-delimited JSON. Each request line holds one event
+// or a batch of them:
+//
+//	{"type":"new","path":"authors/id/A/AB/ABC/Foo-1.0.tar.gz"}
+//	{"events":[{"type":"delete","path":"a.txt"},{"type":"new","path":"b.txt","epoch":1704207845.123}]}
+//
+// Paths are relative to the local root (absolute paths must be below it).
+// An epoch, when given, is recorded as is; like any update with an explicit
+// epoch this marks the hierarchy dirty, so mirrors resynchronize. Every
+// request gets one response line, {"ok":true,"events":N} or
+// {"ok":false,"error":"..."}.
+package inject
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Request is one request line.
+type Request struct {
+	recentfile.Event
+	Events []recentfile.Event `json:"events,omitempty"`
+}
+
+// Response is the reply to one request line.
+type Response struct {
+	OK     bool   `json:"ok"`
+	Events int    `json:"events,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Server applies injected events to the hierarchies added to it.
+type Server struct {
+	root string
+	log  *slog.Logger
+
+	hierarchies []hierarchy
+}
+
+type hierarchy struct {
+	dir string // slash-separated, relative to the root; "" for the root itself
+	rec *recent.Recent
+}
+
+// New creates a Server for paths relative to root.
+func New(root string, log *slog.Logger) *Server {
+	return &Server{root: root, log: log}
+}
+
+// Add routes events for paths below dir ("." for the whole root) to rec.
+func (s *Server) Add(dir string, rec *recent.Recent) {
+	dir = path.Clean(filepath.ToSlash(dir))
+	if dir == "." {
+		dir = ""
+	}
+	s.hierarchies = append(s.hierarchies, hierarchy{dir: dir, rec: rec})
+
+	// Most specific directory first
+	sort.SliceStable(s.hierarchies, func(i, j int) bool {
+		return len(s.hierarchies[i].dir) > len(s.hierarchies[j].dir)
+	})
+}
+
+// Serve listens on the UNIX socket at socketPath until ctx is done. A stale
+// socket file from an earlier run is replaced; the socket is created with
+// mode 0660 so producers in the server's group can connect.
+func (s *Server) Serve(ctx context.Context, socketPath string) error {
+	if fi, err := os.Lstat(socketPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		os.Remove(socketPath)
+	}
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer os.Remove(socketPath)
+
+	if err := os.Chmod(socketPath, 0o660); err != nil {
+		ln.Close()
+		return fmt.Errorf("chmod socket: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var mu sync.Mutex
+	conns := make(map[net.Conn]struct{})
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle serves requests on one connection until it is closed.
+func (s *Server) handle(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	enc := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		resp := Response{OK: true}
+		n, err := s.apply([]byte(line))
+		if err != nil {
+			resp = Response{Error: err.Error()}
+			s.log.Warn("injected events rejected", "error", err)
+		} else {
+			resp.Events = n
+			s.log.Debug("injected events", "events", n)
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// apply parses one request and writes its events. Nothing is written
+// unless every event in the request is valid.
+func (s *Server) apply(line []byte) (int, error) {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return 0, fmt.Errorf("invalid request: %w", err)
+	}
+
+	events := req.Events
+	if req.Path != "" || req.Type != "" {
+		events = append([]recentfile.Event{req.Event}, events...)
+	}
+	if len(events) == 0 {
+		return 0, errors.New("no events in request")
+	}
+
+	batches := make(map[*recent.Recent][]recentfile.BatchItem)
+	var order []*recent.Recent
+	for _, e := range events {
+		rec, abs, err := s.route(e)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := batches[rec]; !ok {
+			order = append(order, rec)
+		}
+		batches[rec] = append(batches[rec], recentfile.BatchItem{Path: abs, Type: e.Type, Epoch: e.Epoch})
+	}
+
+	for _, rec := range order {
+		if err := rec.BatchUpdate(batches[rec]); err != nil {
+			return 0, fmt.Errorf("update %s: %w", rec.LocalRoot(), err)
+		}
+	}
+	return len(events), nil
+}
+
+// route validates e and finds the hierarchy it belongs to. It returns the
+// event's absolute path.
+func (s *Server) route(e recentfile.Event) (*recent.Recent, string, error) {
+	if e.Type != "new" && e.Type != "delete" {
+		return nil, "", fmt.Errorf("%s: type must be new or delete, not %q", e.Path, e.Type)
+	}
+
+	p := e.Path
+	if filepath.IsAbs(p) {
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid path %q", e.Path)
+		}
+		p = filepath.ToSlash(rel)
+	}
+	if p == "" || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		return nil, "", fmt.Errorf("invalid path %q", e.Path)
+	}
+
+	for _, h := range s.hierarchies {
+		if h.dir != "" && p != h.dir && !strings.HasPrefix(p, h.dir+"/") {
+			continue
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, h.dir), "/")
+		if isRecentfile(h.rec, rel) {
+			return nil, "", fmt.Errorf("%s: refusing to record a RECENT file", e.Path)
+		}
+		return h.rec, filepath.Join(s.root, filepath.FromSlash(p)), nil
+	}
+
+	return nil, "", fmt.Errorf("%s: not in any hierarchy", e.Path)
+}
+
+// isRecentfile reports whether rel, relative to rec's root, names one of
+// rec's own files.
+func isRecentfile(rec *recent.Recent, rel string) bool {
+	root := rec.PrincipalRecentfile().Meta().Filenameroot
+	return rel == root+".recent" || (!strings.Contains(rel, "/") && strings.HasPrefix(rel, root+"-"))
+}
@@ -0,0 +1,249 @@
+package inject
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/watcher"
+)
+
+func newTestRecent(t *testing.T, root string) *recent.Recent {
+	t.Helper()
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(root),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// startServer serves s on a socket in a temp dir and returns its path.
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	// Socket paths are limited to ~100 bytes; t.TempDir() can be longer
+	dir, err := os.MkdirTemp("", "rrr-inject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "inject.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, socket) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			return socket
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("socket not created")
+	return ""
+}
+
+func quietLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func events(rec *recent.Recent) map[string]recentfile.Event {
+	m := map[string]recentfile.Event{}
+	for _, e := range rec.PrincipalRecentfile().RecentEvents() {
+		m[e.Path] = e
+	}
+	return m
+}
+
+func TestClient(t *testing.T) {
+	root := t.TempDir()
+	rec := newTestRecent(t, root)
+
+	s := New(root, quietLogger())
+	s.Add(".", rec)
+	socket := startServer(t, s)
+
+	c, err := Dial(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.Send(
+		recentfile.Event{Path: "a/b.txt", Type: "new"},
+		recentfile.Event{Path: filepath.Join(root, "c.txt"), Type: "delete"},
+	)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	got := events(rec)
+	if got["a/b.txt"].Type != "new" || got["c.txt"].Type != "delete" {
+		t.Errorf("events = %v", got)
+	}
+
+	// Explicit epochs are dirty updates
+	if err := c.Send(recentfile.Event{Path: "old.txt", Type: "new", Epoch: 1000}); err != nil {
+		t.Fatalf("Send with epoch failed: %v", err)
+	}
+	if rec.PrincipalRecentfile().Meta().Dirtymark == 0 {
+		t.Error("explicit epoch did not set dirtymark")
+	}
+
+	// A bad event rejects the whole request
+	err = c.Send(
+		recentfile.Event{Path: "ok.txt", Type: "new"},
+		recentfile.Event{Path: "../etc/passwd", Type: "new"},
+	)
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Errorf("expected invalid path error, got %v", err)
+	}
+	if _, ok := events(rec)["ok.txt"]; ok {
+		t.Error("ok.txt recorded from a rejected request")
+	}
+}
+
+func TestConcurrentWithWatcher(t *testing.T) {
+	root := t.TempDir()
+	rec := newTestRecent(t, root)
+
+	w, err := watcher.New(rec, watcher.WithBatchDelay(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	s := New(root, quietLogger())
+	s.Add(".", rec)
+	socket := startServer(t, s)
+
+	// Files written for the watcher while clients inject events, so
+	// their writes of the principal overlap
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.WriteFile(filepath.Join(root, fmt.Sprintf("w%d.txt", i)), []byte("w"), 0o644)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	errs := make(chan error, 8)
+	var clients sync.WaitGroup
+	for i := range 8 {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			c, err := Dial(socket)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer c.Close()
+			for j := range 50 {
+				if err := c.Send(recentfile.Event{Path: fmt.Sprintf("c%d/%d.txt", i, j), Type: "new"}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	clients.Wait()
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Send failed: %v", err)
+	}
+	got := events(rec)
+	for i := range 8 {
+		for j := range 50 {
+			if _, ok := got[fmt.Sprintf("c%d/%d.txt", i, j)]; !ok {
+				t.Errorf("c%d/%d.txt not recorded", i, j)
+			}
+		}
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	root := t.TempDir()
+	authors := newTestRecent(t, filepath.Join(root, "authors"))
+	modules := newTestRecent(t, filepath.Join(root, "modules"))
+
+	s := New(root, quietLogger())
+	s.Add("authors", authors)
+	s.Add("modules", modules)
+	socket := startServer(t, s)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct{ req, resp string }{
+		{`{"type":"new","path":"authors/id/A/AB/ABC/Foo-1.0.tar.gz"}`, `{"ok":true,"events":1}`},
+		{`{"events":[{"type":"new","path":"modules/02packages.details.txt.gz"},{"type":"new","path":"authors/01mailrc.txt.gz"}]}`, `{"ok":true,"events":2}`},
+		{`{"type":"changed","path":"authors/x"}`, `{"ok":false,"error":"authors/x: type must be new or delete, not \"changed\""}`},
+		{`{"type":"new","path":"authors/RECENT-1h.yaml"}`, `{"ok":false,"error":"authors/RECENT-1h.yaml: refusing to record a RECENT file"}`},
+		{`{"type":"new","path":"scripts/x.pl"}`, `{"ok":false,"error":"scripts/x.pl: not in any hierarchy"}`},
+		{`{}`, `{"ok":false,"error":"no events in request"}`},
+	} {
+		if _, err := conn.Write([]byte(tc.req + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(line); got != tc.resp {
+			t.Errorf("%s\n  got  %s\n  want %s", tc.req, got, tc.resp)
+		}
+	}
+
+	if _, ok := events(authors)["id/A/AB/ABC/Foo-1.0.tar.gz"]; !ok {
+		t.Errorf("authors events = %v", events(authors))
+	}
+	if _, ok := events(modules)["02packages.details.txt.gz"]; !ok {
+		t.Errorf("modules events = %v", events(modules))
+	}
+}
@@ -0,0 +1,135 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// command creates the rsync processes; replaced in tests.
+var command = exec.CommandContext
+
+// Rsync fetches files with rsync from a daemon module or remote shell
+// path, e.g. "pause.perl.org::authors" or "rsync://host/module".
+type Rsync struct {
+	remote  string
+	options []string
+}
+
+// NewRsync creates a Fetcher for remote. options are passed to rsync in
+// addition to the defaults.
+func NewRsync(remote string, options ...string) (*Rsync, error) {
+	if remote == "" {
+		return nil, fmt.Errorf("rsync remote cannot be empty")
+	}
+	return &Rsync{remote: strings.TrimSuffix(remote, "/") + "/", options: options}, nil
+}
+
+// Fetch runs one rsync for all paths.
+func (r *Rsync) Fetch(ctx context.Context, paths []string, dest string) error {
+	args := []string{"-a", "--files-from=-", "--from0", "--ignore-missing-args"}
+	args = append(args, r.options...)
+	args = append(args, r.remote, strings.TrimSuffix(dest, "/")+"/")
+
+	cmd := command(ctx, "rsync", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00"))
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		// 24: files vanished on the sender side; a later event covers them
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() == 24 {
+			return nil
+		}
+		return fmt.Errorf("rsync: %w: %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// HTTP fetches files from a web server exporting the tree.
+type HTTP struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// NewHTTP creates a Fetcher for the tree at baseURL.
+func NewHTTP(baseURL string) (*HTTP, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	return &HTTP{base: u, client: &http.Client{Timeout: 10 * time.Minute}}, nil
+}
+
+// Fetch downloads each path in turn.
+func (h *HTTP) Fetch(ctx context.Context, paths []string, dest string) error {
+	for _, p := range paths {
+		if err := h.fetch(ctx, p, filepath.Join(dest, filepath.FromSlash(p))); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (h *HTTP) fetch(ctx context.Context, p, target string) error {
+	u := h.base.JoinPath(strings.Split(p, "/")...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: %s", u, resp.Status)
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("download: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if mtime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(tmp.Name(), mtime, mtime)
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
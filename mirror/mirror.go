@@ -0,0 +1,379 @@
+// Package mirror keeps a local copy of a remote tree in sync by following
+// its RECENT files, the Go counterpart of the Perl rrr-client.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+// Fetcher copies files from the remote tree.
+type Fetcher interface {
+	// Fetch copies paths (relative to the remote root) to the same
+	// relative paths below dest. Files missing upstream are skipped.
+	Fetch(ctx context.Context, paths []string, dest string) error
+}
+
+// Mirror syncs one remote hierarchy into a local root.
+type Mirror struct {
+	fetcher      Fetcher
+	localRoot    string
+	filenameRoot string
+	batchSize    int
+	permissions  bool
+	log          *slog.Logger
+}
+
+// Option configures a Mirror.
+type Option func(*Mirror)
+
+// WithFilenameRoot sets the RECENT file name root (default "RECENT").
+func WithFilenameRoot(root string) Option {
+	return func(m *Mirror) {
+		m.filenameRoot = root
+	}
+}
+
+// WithBatchSize sets how many files are fetched per Fetch call.
+func WithBatchSize(n int) Option {
+	return func(m *Mirror) {
+		if n > 0 {
+			m.batchSize = n
+		}
+	}
+}
+
+// WithPermissions gives fetched files the mode and, when running as root,
+// the owner and group recorded in their events (see
+// recentfile.WithProtocolExt). Files whose events have none keep what the
+// fetcher gave them.
+func WithPermissions(on bool) Option {
+	return func(m *Mirror) {
+		m.permissions = on
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(log *slog.Logger) Option {
+	return func(m *Mirror) {
+		m.log = log
+	}
+}
+
+// New creates a Mirror fetching with fetcher into localRoot.
+func New(fetcher Fetcher, localRoot string, opts ...Option) *Mirror {
+	m := &Mirror{
+		fetcher:      fetcher,
+		localRoot:    localRoot,
+		filenameRoot: "RECENT",
+		batchSize:    1000,
+		log:          slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Stats summarizes one mirror run.
+type Stats struct {
+	Full    bool // every recentfile was read from the beginning
+	Fetched int  // files requested from the remote
+	Dirs    int  // directories created for their events
+	Deleted int  // local files removed
+}
+
+// Run brings the local root up to date. The remote recentfiles are
+// fetched into a staging directory and every event newer than the local
+// copies of the recentfiles, from Z down to the principal, is applied.
+// Only once all files have been fetched are the new recentfiles installed
+// in the local root, so an interrupted run is repeated in full.
+//
+// Signatures of the recentfiles are fetched and installed along with
+// them. With verify keys set (see recentfile.SetVerifyKeys) every remote
+// recentfile must verify before any file is fetched or deleted.
+//
+// Files whose events have a size and checksum (see
+// recentfile.WithProtocolExt) must match them, or the run fails with an
+// error wrapping recentfile.ErrChecksumMismatch; a file that changed
+// upstream since is fetched again by the next run. With WithPermissions
+// their modes and owners are applied next.
+//
+// Events of directories (see recentfile.Event.Dir) create the directory
+// rather than fetching it, so empty directories are kept.
+func (m *Mirror) Run(ctx context.Context) (*Stats, error) {
+	staging, err := os.MkdirTemp(m.localRoot, "."+m.filenameRoot+"-mirror-")
+	if err != nil {
+		return nil, fmt.Errorf("create staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	remote, names, err := m.fetchRecentfiles(ctx, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	since := m.since(remote, names[0])
+	stats := &Stats{Full: since == 0}
+
+	events, err := rsynclist.Changes(remote, since)
+	if err != nil {
+		return nil, fmt.Errorf("collect changes: %w", err)
+	}
+
+	var fetch []string
+	described := make(map[string]recentfile.Event) // events with file info
+	for _, event := range events {
+		if !m.safePath(event.Path) {
+			m.log.Warn("skipping unsafe path", "path", event.Path)
+			continue
+		}
+
+		switch event.Type {
+		case "new":
+			if event.Dir {
+				if err := m.mkdir(event.Path); err != nil {
+					return nil, err
+				}
+				stats.Dirs++
+				continue
+			}
+			fetch = append(fetch, event.Path)
+			if event.Size != 0 || event.Sha256 != "" || event.Mode != 0 {
+				described[event.Path] = event
+			}
+		case "delete":
+			if err := os.RemoveAll(filepath.Join(m.localRoot, filepath.FromSlash(event.Path))); err != nil {
+				return nil, fmt.Errorf("delete %s: %w", event.Path, err)
+			}
+			stats.Deleted++
+		}
+	}
+
+	for i := 0; i < len(fetch); i += m.batchSize {
+		batch := fetch[i:min(i+m.batchSize, len(fetch))]
+		if err := m.fetcher.Fetch(ctx, batch, m.localRoot); err != nil {
+			return nil, fmt.Errorf("fetch: %w", err)
+		}
+		if err := m.verify(batch, described); err != nil {
+			return nil, err
+		}
+		if m.permissions {
+			if err := m.applyPermissions(batch, described); err != nil {
+				return nil, err
+			}
+		}
+		stats.Fetched += len(batch)
+		m.log.Debug("fetched batch", "files", len(batch), "total", stats.Fetched, "of", len(fetch))
+	}
+
+	if err := m.install(staging, names); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// mkdir creates the directory at p, replacing a file left there.
+func (m *Mirror) mkdir(p string) error {
+	local := filepath.Join(m.localRoot, filepath.FromSlash(p))
+	if fi, err := os.Lstat(local); err == nil && !fi.IsDir() {
+		if err := os.Remove(local); err != nil {
+			return fmt.Errorf("create directory %s: %w", p, err)
+		}
+	}
+	if err := os.MkdirAll(local, 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", p, err)
+	}
+	return nil
+}
+
+// verify checks the files fetched for paths against the size and checksum
+// of their events, if they have them. Files missing upstream were skipped.
+func (m *Mirror) verify(paths []string, events map[string]recentfile.Event) error {
+	for _, p := range paths {
+		event, ok := events[p]
+		if !ok {
+			continue
+		}
+		err := event.VerifyFile(filepath.Join(m.localRoot, filepath.FromSlash(p)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("verify: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyPermissions gives the files fetched for paths the mode and, when
+// running as root, the owner their events record, if they have them.
+func (m *Mirror) applyPermissions(paths []string, events map[string]recentfile.Event) error {
+	for _, p := range paths {
+		event, ok := events[p]
+		if !ok || event.Mode == 0 {
+			continue
+		}
+		local := filepath.Join(m.localRoot, filepath.FromSlash(p))
+		if os.Geteuid() == 0 {
+			// Before the chmod, which chown may undo for setuid files
+			err := os.Chown(local, event.UID, event.GID)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("chown %s: %w", p, err)
+			}
+		}
+		err := os.Chmod(local, event.FileMode())
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("chmod %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// fetchRecentfiles fetches the remote hierarchy into staging. It returns
+// the hierarchy and the recentfile names, principal first.
+func (m *Mirror) fetchRecentfiles(ctx context.Context, staging string) (*recent.Recent, []string, error) {
+	link := m.filenameRoot + ".recent"
+	if err := m.fetcher.Fetch(ctx, withSignatures([]string{link}), staging); err != nil {
+		return nil, nil, fmt.Errorf("fetch %s: %w", link, err)
+	}
+
+	linkPath := filepath.Join(staging, link)
+	if _, err := os.Lstat(linkPath); err != nil {
+		return nil, nil, fmt.Errorf("remote has no %s: %w", link, err)
+	}
+
+	// rsync copies the symlink; its target is needed to read it
+	if target, err := os.Readlink(linkPath); err == nil {
+		if target != filepath.Base(target) {
+			return nil, nil, fmt.Errorf("%s points outside the root: %s", link, target)
+		}
+		if err := m.fetcher.Fetch(ctx, withSignatures([]string{target}), staging); err != nil {
+			return nil, nil, fmt.Errorf("fetch %s: %w", target, err)
+		}
+	}
+
+	principal, err := recentfile.NewFromFile(linkPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", link, err)
+	}
+	sparse, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	for _, rf := range sparse.Recentfiles() {
+		name := rf.Rfilename()
+		if name != filepath.Base(name) {
+			return nil, nil, fmt.Errorf("invalid recentfile name %q", name)
+		}
+		names = append(names, name)
+	}
+
+	if err := m.fetcher.Fetch(ctx, withSignatures(names), staging); err != nil {
+		return nil, nil, fmt.Errorf("fetch recentfiles: %w", err)
+	}
+
+	rec, err := recent.New(filepath.Join(staging, names[0]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("load remote hierarchy: %w", err)
+	}
+	return rec, names, nil
+}
+
+// since returns the newest epoch the local recentfiles know about, or 0
+// if there are none or the remote dirtymark has changed.
+func (m *Mirror) since(remote *recent.Recent, principalName string) recentfile.Epoch {
+	localPath := filepath.Join(m.localRoot, principalName)
+	if _, err := os.Stat(localPath); err != nil {
+		return 0
+	}
+
+	local, err := recent.New(localPath)
+	if err == nil {
+		err = local.LoadAll()
+	}
+	if err != nil {
+		m.log.Warn("ignoring local recentfiles", "error", err)
+		return 0
+	}
+
+	if local.PrincipalRecentfile().Meta().Dirtymark != remote.PrincipalRecentfile().Meta().Dirtymark {
+		m.log.Info("remote dirtymark changed, doing a full pass")
+		return 0
+	}
+
+	var newest recentfile.Epoch
+	for _, rf := range local.Recentfiles() {
+		events := rf.RecentEvents()
+		if len(events) > 0 && recentfile.EpochGt(events[0].Epoch, newest) {
+			newest = events[0].Epoch
+		}
+	}
+	return newest
+}
+
+// withSignatures returns names followed by the names of their signatures.
+func withSignatures(names []string) []string {
+	all := slices.Clone(names)
+	for _, name := range names {
+		all = append(all, name+recentfile.SignatureSuffix)
+	}
+	return all
+}
+
+// install moves the fetched recentfiles and their signatures into the
+// local root. The local signature of a recentfile the remote has none for
+// is removed.
+func (m *Mirror) install(staging string, names []string) error {
+	for _, name := range names {
+		src := filepath.Join(staging, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue // not written upstream yet
+		}
+		if err := os.Rename(src, filepath.Join(m.localRoot, name)); err != nil {
+			return fmt.Errorf("install %s: %w", name, err)
+		}
+
+		sig := name + recentfile.SignatureSuffix
+		err := os.Rename(filepath.Join(staging, sig), filepath.Join(m.localRoot, sig))
+		if os.IsNotExist(err) {
+			err = os.Remove(filepath.Join(m.localRoot, sig))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("install %s: %w", sig, err)
+		}
+	}
+
+	principal, err := recentfile.NewFromFile(filepath.Join(m.localRoot, names[0]))
+	if err != nil {
+		return fmt.Errorf("read installed principal: %w", err)
+	}
+	return principal.AssertSymlink()
+}
+
+// safePath rejects paths that escape the local root or would overwrite
+// the mirror's own recentfiles.
+func (m *Mirror) safePath(p string) bool {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		return false
+	}
+	return !strings.HasPrefix(p, m.filenameRoot+"-") && !strings.HasPrefix(p, m.filenameRoot+".recent")
+}
@@ -0,0 +1,432 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// dirFetcher copies files from a local directory, like rsync -a would.
+type dirFetcher struct {
+	src string
+}
+
+func (f *dirFetcher) Fetch(ctx context.Context, paths []string, dest string) error {
+	for _, p := range paths {
+		src := filepath.Join(f.src, p)
+		dst := filepath.Join(dest, p)
+		fi, err := os.Lstat(src)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		os.MkdirAll(filepath.Dir(dst), 0o755)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(src)
+			os.Remove(dst)
+			if err := os.Symlink(target, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type upstream struct {
+	root string
+	rec  *recent.Recent
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+	root := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(root),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d", "Z"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+	if err := principal.AssertSymlink(); err != nil {
+		t.Fatal(err)
+	}
+	return &upstream{root: root, rec: rec}
+}
+
+func (u *upstream) write(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(u.root, name)
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.rec.Update(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (u *upstream) remove(t *testing.T, name string) {
+	t.Helper()
+	path := filepath.Join(u.root, name)
+	os.Remove(path)
+	if err := u.rec.Update(path, "delete"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func quietLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("read %s: %v", filepath.Base(path), err)
+	}
+	return string(data)
+}
+
+func TestRun(t *testing.T) {
+	up := newUpstream(t)
+	up.write(t, "a/one.txt", "one")
+	up.write(t, "b/two.txt", "two")
+	if err := up.rec.Aggregate(true); err != nil {
+		t.Fatal(err)
+	}
+	up.write(t, "c/three.txt", "three")
+
+	local := t.TempDir()
+	fetcher := &dirFetcher{src: up.root}
+	m := New(fetcher, local, WithLogger(quietLogger()))
+
+	stats, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !stats.Full || stats.Fetched != 3 {
+		t.Errorf("first run stats = %+v", stats)
+	}
+	for name, want := range map[string]string{"a/one.txt": "one", "b/two.txt": "two", "c/three.txt": "three"} {
+		if got := readFile(t, filepath.Join(local, name)); got != want {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+
+	// The local root is a hierarchy of its own now
+	if _, err := recent.New(filepath.Join(local, "RECENT.recent")); err != nil {
+		t.Errorf("local hierarchy: %v", err)
+	}
+	entries, _ := os.ReadDir(local)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), "-mirror-") {
+			t.Errorf("staging dir left behind: %s", e.Name())
+		}
+	}
+
+	// Incremental run picks up only the new changes
+	up.remove(t, "a/one.txt")
+	up.write(t, "d/four.txt", "four")
+
+	stats, err = m.Run(context.Background())
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if stats.Full || stats.Fetched != 1 || stats.Deleted != 1 {
+		t.Errorf("second run stats = %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(local, "a/one.txt")); !os.IsNotExist(err) {
+		t.Error("a/one.txt should have been deleted")
+	}
+	if got := readFile(t, filepath.Join(local, "d/four.txt")); got != "four" {
+		t.Errorf("d/four.txt = %q", got)
+	}
+}
+
+func TestRunDirs(t *testing.T) {
+	up := newUpstream(t)
+	up.write(t, "empty", "a file that becomes a directory")
+
+	local := t.TempDir()
+	m := New(&dirFetcher{src: up.root}, local, WithLogger(quietLogger()))
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	os.Remove(filepath.Join(up.root, "empty"))
+	for _, dir := range []string{"empty", "a/b"} {
+		if err := os.MkdirAll(filepath.Join(up.root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		err := up.rec.BatchUpdate([]recentfile.BatchItem{{Path: filepath.Join(up.root, dir), Type: "new", Dir: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if stats.Dirs != 2 || stats.Fetched != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	for _, dir := range []string{"empty", "a/b"} {
+		if fi, err := os.Stat(filepath.Join(local, dir)); err != nil || !fi.IsDir() {
+			t.Errorf("%s not created: %v", dir, err)
+		}
+	}
+}
+
+func TestRunDirtymark(t *testing.T) {
+	up := newUpstream(t)
+	up.write(t, "a.txt", "a")
+
+	local := t.TempDir()
+	m := New(&dirFetcher{src: up.root}, local, WithLogger(quietLogger()))
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dirty update rewrites history; the next run starts over
+	principal := up.rec.PrincipalRecentfile()
+	if err := up.rec.Update(filepath.Join(up.root, "a.txt"), "new", recentfile.Epoch(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if principal.Meta().Dirtymark == 0 {
+		t.Fatal("dirty update did not set dirtymark")
+	}
+
+	stats, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Full {
+		t.Errorf("expected a full pass after dirtymark change, got %+v", stats)
+	}
+}
+
+func TestRunSigned(t *testing.T) {
+	key, err := recentfile.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recentfile.SetSigningKey(key)
+	t.Cleanup(func() { recentfile.SetSigningKey(nil) })
+
+	up := newUpstream(t)
+	up.write(t, "a.txt", "a")
+	if err := up.rec.Aggregate(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upstream and mirror share the process: verify only while mirroring
+	local := t.TempDir()
+	m := New(&dirFetcher{src: up.root}, local, WithLogger(quietLogger()))
+	run := func() error {
+		recentfile.SetVerifyKeys(key.Public())
+		defer recentfile.SetVerifyKeys()
+		_, err := m.Run(context.Background())
+		return err
+	}
+	if err := run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// The signatures are installed too, for mirrors of this mirror
+	for _, name := range []string{"RECENT-1h.yaml.minisig", "RECENT-1d.yaml.minisig", "RECENT.recent.minisig"} {
+		if _, err := os.Stat(filepath.Join(local, name)); err != nil {
+			t.Errorf("signature not installed: %v", err)
+		}
+	}
+
+	// Changes signed with another key are not applied
+	other, err := recentfile.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recentfile.SetSigningKey(other)
+	up.remove(t, "a.txt")
+	if err := run(); !errors.Is(err, recentfile.ErrBadSignature) {
+		t.Fatalf("Run = %v, want ErrBadSignature", err)
+	}
+	if got := readFile(t, filepath.Join(local, "a.txt")); got != "a" {
+		t.Errorf("a.txt = %q", got)
+	}
+
+	// Nor unsigned ones
+	recentfile.SetSigningKey(nil)
+	up.write(t, "b.txt", "b")
+	if err := run(); !errors.Is(err, recentfile.ErrUnsigned) {
+		t.Fatalf("Run = %v, want ErrUnsigned", err)
+	}
+}
+
+func TestRunChecksums(t *testing.T) {
+	up := newUpstream(t)
+	up.rec.SetProtocolExt(true)
+	up.write(t, "a.txt", "a")
+
+	local := t.TempDir()
+	m := New(&dirFetcher{src: up.root}, local, WithLogger(quietLogger()))
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// A file that is not the one its event describes fails the run
+	up.write(t, "b.txt", "b")
+	if err := os.WriteFile(filepath.Join(up.root, "b.txt"), []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Run(context.Background()); !errors.Is(err, recentfile.ErrChecksumMismatch) {
+		t.Fatalf("Run = %v, want ErrChecksumMismatch", err)
+	}
+
+	// With a matching event again the next run succeeds
+	up.write(t, "b.txt", "b2")
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(local, "b.txt")); got != "b2" {
+		t.Errorf("b.txt = %q", got)
+	}
+}
+
+func TestRunPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on windows")
+	}
+	up := newUpstream(t)
+	up.rec.SetProtocolExt(true)
+	path := filepath.Join(up.root, "private.txt")
+	if err := os.WriteFile(path, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.rec.Update(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+	up.write(t, "public.txt", "hello")
+
+	// The fetcher writes every file 0644
+	local := t.TempDir()
+	m := New(&dirFetcher{src: up.root}, local, WithLogger(quietLogger()), WithPermissions(true))
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	for name, want := range map[string]os.FileMode{"private.txt": 0o600, "public.txt": 0o644} {
+		fi, err := os.Stat(filepath.Join(local, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s: mode %v, want %v", name, fi.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	m := New(nil, t.TempDir())
+	for p, want := range map[string]bool{
+		"a/b.txt":                true,
+		"authors/RECENT-1h.yaml": true,
+		"../etc/passwd":          false,
+		"/etc/passwd":            false,
+		"a/../../b":              false,
+		"RECENT-1h.yaml":         false,
+		"RECENT.recent":          false,
+		"RECENT.recent.minisig":  false,
+		"":                       false,
+	} {
+		if got := m.safePath(p); got != want {
+			t.Errorf("safePath(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	up := newUpstream(t)
+	up.write(t, "dir with space/file.txt", "hello")
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(up.root)))
+	defer srv.Close()
+
+	fetcher, err := NewHTTP(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := t.TempDir()
+	m := New(fetcher, local, WithLogger(quietLogger()))
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(local, "dir with space/file.txt")); got != "hello" {
+		t.Errorf("file.txt = %q", got)
+	}
+	if target, err := os.Readlink(filepath.Join(local, "RECENT.recent")); err != nil || target != "RECENT-1h.yaml" {
+		t.Errorf("RECENT.recent -> %q, %v", target, err)
+	}
+
+	// Missing files are skipped
+	if err := fetcher.Fetch(context.Background(), []string{"nope.txt"}, local); err != nil {
+		t.Errorf("Fetch of missing file: %v", err)
+	}
+
+	if _, err := NewHTTP("ftp://example.com/"); err == nil {
+		t.Error("Expected error for ftp url")
+	}
+}
+
+func TestRsyncArgs(t *testing.T) {
+	var gotArgs []string
+	stdin := filepath.Join(t.TempDir(), "stdin")
+	orig := command
+	command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		gotArgs = append([]string{name}, args...)
+		return exec.CommandContext(ctx, "sh", "-c", "cat > "+stdin)
+	}
+	defer func() { command = orig }()
+
+	r, err := NewRsync("pause.perl.org::authors", "--port=8730")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Fetch(context.Background(), []string{"a.txt", "b/c.txt"}, "/srv/mirror"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	want := "rsync -a --files-from=- --from0 --ignore-missing-args --port=8730 pause.perl.org::authors/ /srv/mirror/"
+	if got := strings.Join(gotArgs, " "); got != want {
+		t.Errorf("args = %s", got)
+	}
+	if got := readFile(t, stdin); got != "a.txt\x00b/c.txt" {
+		t.Errorf("stdin = %q", got)
+	}
+}
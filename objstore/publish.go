@@ -0,0 +1,248 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Store is where a Publisher copies a hierarchy to. Names are
+// slash-separated paths relative to the root of the hierarchy.
+type Store interface {
+	recentfile.Fetcher
+	Put(ctx context.Context, name string, body io.Reader, size int64) error
+	Delete(ctx context.Context, name string) error
+}
+
+// Publisher keeps a copy of a hierarchy in a Store: the files of the tree
+// and its RECENT files, laid out as on disk, so the store can be mirrored
+// like the tree itself. Object stores have no symlinks, so RECENT.recent is
+// a copy of the principal file.
+type Publisher struct {
+	store Store
+	rec   *recent.Recent
+	log   *slog.Logger
+
+	mu      sync.Mutex // one Publish at a time
+	started bool
+	since   recentfile.Epoch    // newest event published
+	sums    map[string][32]byte // of the RECENT files as last published
+}
+
+// PublishStats summarizes one Publish.
+type PublishStats struct {
+	Full        bool // every file was published, not only the changed ones
+	Uploaded    int  // files of the tree
+	Deleted     int
+	Recentfiles int // RECENT files uploaded
+}
+
+// NewPublisher creates a Publisher copying rec to store.
+func NewPublisher(store Store, rec *recent.Recent, log *slog.Logger) *Publisher {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Publisher{
+		store: store,
+		rec:   rec,
+		log:   log,
+		sums:  make(map[string][32]byte),
+	}
+}
+
+// Publish copies the files changed since the last Publish to the store,
+// deletes those deleted, and then uploads the RECENT files that changed,
+// principal last, so clients never see an event before its file. The
+// first Publish continues from the newest event in the RECENT files
+// already in the store, or publishes every file if there are none or
+// their dirtymark differs.
+func (p *Publisher) Publish(ctx context.Context) (PublishStats, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stats PublishStats
+	if !p.started {
+		since, err := p.publishedSince(ctx)
+		if err != nil {
+			return stats, err
+		}
+		p.since = since
+	}
+	stats.Full = p.since.IsZero()
+
+	newest := p.since
+	root := p.rec.LocalRoot()
+	for event, err := range p.rec.News(p.since) {
+		if err != nil {
+			return stats, fmt.Errorf("collect changes: %w", err)
+		}
+		if recentfile.EpochGt(event.Epoch, newest) {
+			newest = event.Epoch
+		}
+
+		switch event.Type {
+		case "new":
+			uploaded, err := p.putFile(ctx, event.Path, filepath.Join(root, filepath.FromSlash(event.Path)))
+			if err != nil {
+				return stats, err
+			}
+			if uploaded {
+				stats.Uploaded++
+			}
+		case "delete":
+			// Nothing to delete in a store published from scratch
+			if stats.Full {
+				continue
+			}
+			if err := p.store.Delete(ctx, event.Path); err != nil {
+				return stats, fmt.Errorf("delete %s: %w", event.Path, err)
+			}
+			stats.Deleted++
+		}
+	}
+
+	n, err := p.putRecentfiles(ctx)
+	stats.Recentfiles = n
+	if err != nil {
+		return stats, err
+	}
+
+	p.started = true
+	p.since = newest
+	return stats, nil
+}
+
+// publishedSince returns the newest event in the principal file in the
+// store, or 0 if there is none or its dirtymark is not the local one.
+func (p *Publisher) publishedSince(ctx context.Context) (recentfile.Epoch, error) {
+	principal := p.rec.PrincipalRecentfile()
+	name := principal.Rfilename()
+
+	data, err := p.store.Fetch(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		p.log.Info("no hierarchy published yet, publishing every file", "store", p.store)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("fetch published %s: %w", name, err)
+	}
+	sd, err := recentfile.Unmarshal(data, principal.Meta().SerializerSuffix)
+	if err != nil {
+		p.log.Warn("cannot read published principal, publishing every file", "store", p.store, "error", err)
+		return 0, nil
+	}
+	if sd.Meta.Dirtymark != principal.Meta().Dirtymark {
+		p.log.Info("dirtymark changed, publishing every file", "store", p.store)
+		return 0, nil
+	}
+
+	var newest recentfile.Epoch
+	for _, event := range sd.Recent {
+		if recentfile.EpochGt(event.Epoch, newest) {
+			newest = event.Epoch
+		}
+	}
+	return newest, nil
+}
+
+// putFile uploads the file at path as name. A file deleted meanwhile is
+// skipped; the event deleting it follows.
+func (p *Publisher) putFile(ctx context.Context, name, path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", name, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	if err := p.store.Put(ctx, name, f, fi.Size()); err != nil {
+		return false, fmt.Errorf("put %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// putRecentfiles uploads the RECENT files changed since they were last
+// published, the largest interval first, and the principal again as
+// RECENT.recent, each followed by its signature. A signature published
+// before but gone locally is deleted. Returns how many RECENT files were
+// uploaded.
+func (p *Publisher) putRecentfiles(ctx context.Context) (int, error) {
+	rfs := p.rec.Recentfiles()
+	if len(rfs) == 0 {
+		return 0, nil
+	}
+
+	type upload struct {
+		name string
+		data []byte // nil for a missing signature
+	}
+	var uploads []upload
+	for i := len(rfs) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(rfs[i].Rfile())
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // not written yet
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read %s: %w", rfs[i].Rfilename(), err)
+		}
+		sig, err := os.ReadFile(rfs[i].Rfile() + recentfile.SignatureSuffix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("read signature of %s: %w", rfs[i].Rfilename(), err)
+		}
+
+		names := []string{rfs[i].Rfilename()}
+		if i == 0 {
+			names = append(names, rfs[0].Meta().Filenameroot+".recent")
+		}
+		for _, name := range names {
+			uploads = append(uploads, upload{name, data}, upload{name + recentfile.SignatureSuffix, sig})
+		}
+	}
+
+	n := 0
+	for _, u := range uploads {
+		if u.data == nil {
+			if _, published := p.sums[u.name]; published {
+				if err := p.store.Delete(ctx, u.name); err != nil {
+					return n, fmt.Errorf("delete %s: %w", u.name, err)
+				}
+				delete(p.sums, u.name)
+			}
+			continue
+		}
+
+		sum := sha256.Sum256(u.data)
+		if p.sums[u.name] == sum {
+			continue
+		}
+		if err := p.store.Put(ctx, u.name, bytes.NewReader(u.data), int64(len(u.data))); err != nil {
+			return n, fmt.Errorf("put %s: %w", u.name, err)
+		}
+		p.sums[u.name] = sum
+		if !strings.HasSuffix(u.name, recentfile.SignatureSuffix) {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,320 @@
+// Package objstore publishes RECENT hierarchies to S3-compatible object
+// storage and reads them back, so clients can follow a hierarchy without
+// an rsync daemon.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 reads and writes the objects below a prefix of a bucket in an
+// S3-compatible store, addressed path-style (endpoint/bucket/key), which
+// AWS, MinIO, Ceph and R2 all accept. Object names are slash-separated
+// paths relative to the prefix.
+//
+// Fetch keeps the last copy of every object read with its ETag and asks
+// the store to send it only if it changed since.
+type S3 struct {
+	endpoint    *url.URL
+	rawEndpoint string // from WithEndpoint
+	bucket      string
+	prefix      string // "" or ending in "/"
+	region      string
+	creds       Credentials
+	client      *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*s3Copy
+}
+
+// s3Copy is the last copy of an object read by Fetch.
+type s3Copy struct {
+	etag string
+	data []byte
+}
+
+// Option configures an S3 store.
+type Option func(*S3)
+
+// WithEndpoint sets the URL of the store, e.g. "http://localhost:9000" for
+// MinIO. The default is AWS S3 in the region.
+func WithEndpoint(endpoint string) Option {
+	return func(s *S3) {
+		s.rawEndpoint = endpoint
+	}
+}
+
+// WithRegion sets the region requests are signed for (default us-east-1).
+func WithRegion(region string) Option {
+	return func(s *S3) {
+		if region != "" {
+			s.region = region
+		}
+	}
+}
+
+// WithCredentials sets the credentials requests are signed with.
+func WithCredentials(creds Credentials) Option {
+	return func(s *S3) {
+		s.creds = creds
+	}
+}
+
+// WithHTTPClient sets the HTTP client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *S3) {
+		s.client = client
+	}
+}
+
+// NewS3 creates a store for location, "s3://bucket/prefix".
+func NewS3(location string, opts ...Option) (*S3, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse location: %w", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("location %q is not s3://bucket/prefix", location)
+	}
+
+	s := &S3{
+		bucket: u.Host,
+		region: "us-east-1",
+		client: &http.Client{Timeout: 10 * time.Minute},
+		cache:  make(map[string]*s3Copy),
+	}
+	if prefix := strings.Trim(u.Path, "/"); prefix != "" {
+		s.prefix = prefix + "/"
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.rawEndpoint == "" {
+		s.endpoint = &url.URL{Scheme: "https", Host: "s3." + s.region + ".amazonaws.com"}
+		return s, nil
+	}
+	endpoint, err := url.Parse(s.rawEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported endpoint scheme %q", endpoint.Scheme)
+	}
+	s.endpoint = endpoint
+	return s, nil
+}
+
+// Sub returns a store for the objects below dir, with the same settings.
+func (s *S3) Sub(dir string) *S3 {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return s
+	}
+	return &S3{
+		endpoint: s.endpoint,
+		bucket:   s.bucket,
+		prefix:   s.prefix + dir + "/",
+		region:   s.region,
+		creds:    s.creds,
+		client:   s.client,
+		cache:    make(map[string]*s3Copy),
+	}
+}
+
+// String returns the location of the store.
+func (s *S3) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+// newRequest creates a request for the object name, signed when there are
+// credentials. Headers must be set with header, before signing.
+func (s *S3) newRequest(ctx context.Context, method, name string, body io.Reader, size int64, header http.Header) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + s.prefix + name
+	u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + "/" + uriEncode(s.bucket, false) + "/" + uriEncode(s.prefix+name, true)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if s.creds.AccessKey != "" {
+		payloadHash := emptyPayload
+		if body != nil {
+			payloadHash = unsignedPayload
+		}
+		sign(req, s.creds, s.region, payloadHash, time.Now())
+	}
+	return req, nil
+}
+
+// Fetch returns the contents of the object name. It implements
+// recentfile.Fetcher, so RECENT files can be read from the store with
+// recentfile.NewFromFetcher.
+func (s *S3) Fetch(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	cached := s.cache[name]
+	s.mu.Unlock()
+
+	header := http.Header{}
+	if cached != nil {
+		header.Set("If-None-Match", cached.etag)
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.data, nil
+	}
+	if err := checkResponse(resp, "get", s.prefix+name); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", s.prefix+name, err)
+	}
+
+	s.mu.Lock()
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		s.cache[name] = &s3Copy{etag: etag, data: data}
+	} else {
+		delete(s.cache, name)
+	}
+	s.mu.Unlock()
+
+	return data, nil
+}
+
+// Put stores size bytes from body as the object name.
+func (s *S3) Put(ctx context.Context, name string, body io.Reader, size int64) error {
+	req, err := s.newRequest(ctx, http.MethodPut, name, body, size, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "put", s.prefix+name)
+}
+
+// Delete removes the object name. A missing object is not an error.
+func (s *S3) Delete(ctx context.Context, name string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return checkResponse(resp, "delete", s.prefix+name)
+}
+
+// download stores the object name in target, replacing it atomically.
+func (s *S3) download(ctx context.Context, name, target string) error {
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "get", s.prefix+name); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("download: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if mtime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(tmp.Name(), mtime, mtime)
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Files returns a fetcher for the files of the tree published in the
+// store, for mirror.New.
+func (s *S3) Files() *Files {
+	return &Files{s: s}
+}
+
+// Files fetches the files of a tree published in an S3 store; it
+// implements mirror.Fetcher.
+type Files struct {
+	s *S3
+}
+
+// Fetch downloads each path in turn. Paths missing from the store are
+// skipped.
+func (f *Files) Fetch(ctx context.Context, paths []string, dest string) error {
+	for _, p := range paths {
+		err := f.s.download(ctx, p, filepath.Join(dest, filepath.FromSlash(p)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// checkResponse returns an error for a response that is not a success,
+// wrapping fs.ErrNotExist for a missing object.
+func checkResponse(resp *http.Response, op, key string) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", op, key, fs.ErrNotExist)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", op, key, resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,384 @@
+package objstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abh/rrrgo/mirror"
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// fakeS3 is an in-memory S3 bucket served path-style, enough for the
+// requests S3 makes.
+type fakeS3 struct {
+	bucket string
+	signed bool // require signed requests
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	puts        []string
+	notModified int
+}
+
+func newFakeS3(t *testing.T, bucket string, signed bool) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{bucket: bucket, signed: signed, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.signed && !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+		if r.Header.Get("If-None-Match") == etag {
+			f.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			http.Error(w, "MissingContentLength", http.StatusLengthRequired)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		f.puts = append(f.puts, key)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// takePuts returns the keys stored since the last call, in order.
+func (f *fakeS3) takePuts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	puts := f.puts
+	f.puts = nil
+	return puts
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestS3(t *testing.T) {
+	fake, srv := newFakeS3(t, "bucket", true)
+	ctx := context.Background()
+
+	s, err := NewS3("s3://bucket/pub/",
+		WithEndpoint(srv.URL),
+		WithRegion("eu-west-1"),
+		WithCredentials(Credentials{AccessKey: "AKID", SecretKey: "secret"}),
+	)
+	if err != nil {
+		t.Fatalf("NewS3 failed: %v", err)
+	}
+	if got, want := s.String(), "s3://bucket/pub/"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+
+	name := "authors/id/A/Foo Bar+1.0.tar.gz"
+	if err := s.Put(ctx, name, strings.NewReader("data"), 4); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if keys := fake.keys(); len(keys) != 1 || keys[0] != "pub/"+name {
+		t.Errorf("keys = %q", keys)
+	}
+
+	for range 2 {
+		data, err := s.Fetch(ctx, name)
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if string(data) != "data" {
+			t.Errorf("Fetch = %q", data)
+		}
+	}
+	if fake.notModified != 1 {
+		t.Errorf("not modified = %d, want 1", fake.notModified)
+	}
+
+	if err := s.Sub("authors").Delete(ctx, "id/A/Foo Bar+1.0.tar.gz"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Fetch(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch after Delete: err = %v, want fs.ErrNotExist", err)
+	}
+
+	// Unsigned requests are refused by this bucket
+	anon, err := NewS3("s3://bucket", WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := anon.Put(ctx, "x", strings.NewReader(""), 0); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("unsigned Put: err = %v", err)
+	}
+
+	for _, location := range []string{"https://bucket/pub", "s3:///pub"} {
+		if _, err := NewS3(location); err == nil {
+			t.Errorf("NewS3(%q): expected error", location)
+		}
+	}
+	if _, err := NewS3("s3://bucket", WithEndpoint("ftp://example.org")); err == nil {
+		t.Error("expected error for ftp endpoint")
+	}
+}
+
+var discard = slog.New(slog.DiscardHandler)
+
+func setupHierarchy(t *testing.T) *recent.Recent {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	return rec
+}
+
+func addFile(t *testing.T, rec *recent.Recent, name, content string) {
+	t.Helper()
+	path := filepath.Join(rec.LocalRoot(), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Update(path, "new"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	fake, srv := newFakeS3(t, "bucket", false)
+	ctx := context.Background()
+
+	s, err := NewS3("s3://bucket/cpan", WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := setupHierarchy(t)
+	addFile(t, rec, "a.txt", "a")
+	addFile(t, rec, "dir/b.txt", "b")
+	addFile(t, rec, "dir/c.txt", "c")
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+
+	p := NewPublisher(s, rec, discard)
+	stats, err := p.Publish(ctx)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if !stats.Full || stats.Uploaded != 3 || stats.Recentfiles != 3 {
+		t.Errorf("first publish = %+v", stats)
+	}
+	// Files first, then the RECENT files with the principal last
+	want := []string{"cpan/RECENT-1d.yaml", "cpan/RECENT-1h.yaml", "cpan/RECENT.recent"}
+	if puts := fake.takePuts(); len(puts) != 6 || strings.Join(puts[3:], " ") != strings.Join(want, " ") {
+		t.Errorf("puts = %q", puts)
+	}
+
+	// Nothing changed
+	stats, err = p.Publish(ctx)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if stats != (PublishStats{}) {
+		t.Errorf("unchanged publish = %+v", stats)
+	}
+
+	addFile(t, rec, "d.txt", "d")
+	os.Remove(filepath.Join(rec.LocalRoot(), "dir", "c.txt"))
+	if err := rec.Update(filepath.Join(rec.LocalRoot(), "dir", "c.txt"), "delete"); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = p.Publish(ctx)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if stats.Full || stats.Uploaded != 1 || stats.Deleted != 1 || stats.Recentfiles != 2 {
+		t.Errorf("incremental publish = %+v", stats)
+	}
+	wantKeys := []string{"cpan/RECENT-1d.yaml", "cpan/RECENT-1h.yaml", "cpan/RECENT.recent", "cpan/a.txt", "cpan/d.txt", "cpan/dir/b.txt"}
+	if keys := fake.keys(); strings.Join(keys, " ") != strings.Join(wantKeys, " ") {
+		t.Errorf("keys = %q, want %q", keys, wantKeys)
+	}
+	fake.takePuts()
+
+	// A new publisher continues where the store is
+	stats, err = NewPublisher(s, rec, discard).Publish(ctx)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if stats.Full || stats.Uploaded != 0 {
+		t.Errorf("publish after restart = %+v", stats)
+	}
+
+	// Clients read the RECENT files from the store
+	remote, err := recentfile.NewFromFetcher(ctx, s, "RECENT.recent")
+	if err != nil {
+		t.Fatalf("NewFromFetcher failed: %v", err)
+	}
+	if events := remote.RecentEvents(); len(events) != 4 || events[0].Path != "dir/c.txt" || events[0].Type != "delete" {
+		t.Errorf("published events = %+v", events)
+	}
+
+	// and mirror the tree from it
+	local := t.TempDir()
+	if _, err := mirror.New(s.Files(), local).Run(ctx); err != nil {
+		t.Fatalf("mirror failed: %v", err)
+	}
+	for name, content := range map[string]string{"a.txt": "a", "d.txt": "d", "dir/b.txt": "b"} {
+		data, err := os.ReadFile(filepath.Join(local, filepath.FromSlash(name)))
+		if err != nil || string(data) != content {
+			t.Errorf("mirrored %s = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(local, "dir", "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted file mirrored: %v", err)
+	}
+}
+
+func TestPublishDirtymark(t *testing.T) {
+	_, srv := newFakeS3(t, "bucket", false)
+	ctx := context.Background()
+
+	s, err := NewS3("s3://bucket", WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := setupHierarchy(t)
+	addFile(t, rec, "a.txt", "a")
+
+	if _, err := NewPublisher(s, rec, discard).Publish(ctx); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	// A new dirtymark forces clients, and the publisher, into a full pass
+	if err := rec.SetDirtymark(recentfile.EpochNow()); err != nil {
+		t.Fatalf("SetDirtymark failed: %v", err)
+	}
+	stats, err := NewPublisher(s, rec, discard).Publish(ctx)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if !stats.Full || stats.Uploaded != 1 {
+		t.Errorf("publish after dirtymark change = %+v", stats)
+	}
+}
+
+func TestPublishSigned(t *testing.T) {
+	fake, srv := newFakeS3(t, "bucket", false)
+	ctx := context.Background()
+
+	key, err := recentfile.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recentfile.SetSigningKey(key)
+	t.Cleanup(func() { recentfile.SetSigningKey(nil) })
+
+	s, err := NewS3("s3://bucket", WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := setupHierarchy(t)
+	addFile(t, rec, "a.txt", "a")
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+
+	p := NewPublisher(s, rec, discard)
+	stats, err := p.Publish(ctx)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if stats.Recentfiles != 3 {
+		t.Errorf("publish = %+v", stats)
+	}
+	want := []string{
+		"RECENT-1d.yaml", "RECENT-1d.yaml.minisig",
+		"RECENT-1h.yaml", "RECENT-1h.yaml.minisig",
+		"RECENT.recent", "RECENT.recent.minisig",
+	}
+	if puts := fake.takePuts(); strings.Join(puts[1:], " ") != strings.Join(want, " ") {
+		t.Errorf("puts = %q", puts)
+	}
+
+	// Clients verify what they read from the store
+	recentfile.SetVerifyKeys(key.Public())
+	_, err = recentfile.NewFromFetcher(ctx, s, "RECENT.recent")
+	recentfile.SetVerifyKeys()
+	if err != nil {
+		t.Fatalf("NewFromFetcher failed: %v", err)
+	}
+
+	// Signing stopped: the principal's signatures go away
+	recentfile.SetSigningKey(nil)
+	addFile(t, rec, "b.txt", "b")
+	if _, err := p.Publish(ctx); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	wantKeys := []string{"RECENT-1d.yaml", "RECENT-1d.yaml.minisig", "RECENT-1h.yaml", "RECENT.recent", "a.txt", "b.txt"}
+	if keys := fake.keys(); strings.Join(keys, " ") != strings.Join(wantKeys, " ") {
+		t.Errorf("keys = %q, want %q", keys, wantKeys)
+	}
+}
@@ -0,0 +1,132 @@
+// Package pathfilter decides which paths below a local root are content to
+// be recorded, based on operator-supplied ignore and include patterns.
+//
+// A pattern is a glob (path.Match syntax) unless it starts with "re:", in
+// which case the rest is a regular expression matched against the
+// slash-separated path relative to the root. A glob without a slash matches
+// any single path component, so ".git" matches every .git directory and
+// everything below it and "*.o" matches object files anywhere. A glob with
+// a slash is anchored at the root (a leading slash is optional) and matches
+// the path or any of its parent directories, so "build/tmp" covers the
+// whole build/tmp subtree.
+package pathfilter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter holds compiled ignore and include patterns. A nil *Filter
+// ignores nothing.
+type Filter struct {
+	ignore  []matcher
+	include []matcher
+}
+
+type matcher struct {
+	glob string         // glob pattern, "" for a regexp
+	rx   *regexp.Regexp // regexp pattern, nil for a glob
+}
+
+// New compiles the ignore and include patterns. Files matching an ignore
+// pattern are never recorded; when include patterns are given, only files
+// matching one of them are. It returns nil if there are no patterns.
+func New(ignore, include []string) (*Filter, error) {
+	if len(ignore) == 0 && len(include) == 0 {
+		return nil, nil
+	}
+
+	f := &Filter{}
+	var err error
+	if f.ignore, err = compile(ignore); err != nil {
+		return nil, fmt.Errorf("ignore pattern: %w", err)
+	}
+	if f.include, err = compile(include); err != nil {
+		return nil, fmt.Errorf("include pattern: %w", err)
+	}
+	return f, nil
+}
+
+func compile(patterns []string) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(patterns))
+	for _, p := range patterns {
+		if expr, ok := strings.CutPrefix(p, "re:"); ok {
+			rx, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", p, err)
+			}
+			matchers = append(matchers, matcher{rx: rx})
+			continue
+		}
+
+		glob := strings.TrimPrefix(p, "/")
+		if glob == "" {
+			return nil, fmt.Errorf("%q: empty pattern", p)
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		matchers = append(matchers, matcher{glob: glob})
+	}
+	return matchers, nil
+}
+
+// match reports whether relPath matches m.
+func (m matcher) match(relPath string) bool {
+	if m.rx != nil {
+		return m.rx.MatchString(relPath)
+	}
+
+	if !strings.Contains(m.glob, "/") {
+		for component := range strings.SplitSeq(relPath, "/") {
+			if ok, _ := path.Match(m.glob, component); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The path itself or one of its parent directories
+	for p := relPath; p != "." && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(m.glob, p); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			break
+		}
+	}
+	return false
+}
+
+func matchAny(matchers []matcher, relPath string) bool {
+	for _, m := range matchers {
+		if m.match(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ignored reports whether the file at relPath, slash-separated and
+// relative to the root, must not be recorded.
+func (f *Filter) Ignored(relPath string) bool {
+	if f == nil {
+		return false
+	}
+	if matchAny(f.ignore, relPath) {
+		return true
+	}
+	return len(f.include) > 0 && !matchAny(f.include, relPath)
+}
+
+// IgnoredDir reports whether everything below the directory at relPath is
+// ignored, so it need not be watched or walked. Include patterns never
+// exclude a directory, since files below it may still match.
+func (f *Filter) IgnoredDir(relPath string) bool {
+	if f == nil || relPath == "." || relPath == "" {
+		return false
+	}
+	return matchAny(f.ignore, relPath)
+}
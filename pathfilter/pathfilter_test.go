@@ -0,0 +1,82 @@
+package pathfilter
+
+import "testing"
+
+func TestIgnored(t *testing.T) {
+	f, err := New(
+		[]string{".git", "*.o", "/build/tmp", `re:\.bak$`},
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"README", false},
+		{".git", true},
+		{".git/config", true},
+		{"sub/.git/HEAD", true},
+		{"sub/.github/workflow.yml", false},
+		{"foo.o", true},
+		{"src/foo.o", true},
+		{"src/foo.go", false},
+		{"build/tmp/x", true},
+		{"build/tmp", true},
+		{"build/out/x", false},
+		{"sub/build/tmp/x", false},
+		{"notes.bak", true},
+		{"notes.bak/x", false},
+	}
+	for _, tt := range tests {
+		if got := f.Ignored(tt.path); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	f, err := New([]string{"*.tmp.tar.gz"}, []string{"*.tar.gz", "authors/01mailrc.txt.gz"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"authors/id/A/AB/Foo-1.0.tar.gz", false},
+		{"authors/id/A/AB/Foo-1.0.tmp.tar.gz", true},
+		{"authors/id/A/AB/CHECKSUMS", true},
+		{"authors/01mailrc.txt.gz", false},
+	}
+	for _, tt := range tests {
+		if got := f.Ignored(tt.path); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if f.IgnoredDir("authors/id") {
+		t.Error("include patterns must not exclude directories")
+	}
+}
+
+func TestNil(t *testing.T) {
+	f, err := New(nil, nil)
+	if err != nil || f != nil {
+		t.Fatalf("New(nil, nil) = %v, %v; want nil, nil", f, err)
+	}
+	if f.Ignored("a") || f.IgnoredDir("a") {
+		t.Error("nil filter ignores paths")
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	for _, p := range []string{"[", "re:(", "/"} {
+		if _, err := New([]string{p}, nil); err == nil {
+			t.Errorf("New(%q) succeeded, want error", p)
+		}
+	}
+}
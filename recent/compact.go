@@ -0,0 +1,27 @@
+package recent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// CompactZ compacts the Z recentfile of the collection (see
+// recentfile.Recentfile.Compact), dropping superseded events and delete
+// events older than pruneDeletes; with 0 it keeps every delete. Mirrors
+// that last synced before a pruned delete no longer learn of it from the
+// Z file and need a full sync to remove the file. Returns the number of
+// events removed.
+func (r *Recent) CompactZ(pruneDeletes time.Duration) (int, error) {
+	z := r.RecentfileByInterval("Z")
+	if z == nil {
+		return 0, fmt.Errorf("hierarchy has no Z recentfile")
+	}
+
+	var cutoff recentfile.Epoch
+	if pruneDeletes > 0 {
+		cutoff = recentfile.EpochFromTime(time.Now().Add(-pruneDeletes))
+	}
+	return z.Compact(cutoff)
+}
@@ -0,0 +1,93 @@
+package recent
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestCompactZ(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d", "Z"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	now := time.Now()
+	epoch := func(age time.Duration) recentfile.Epoch {
+		return recentfile.EpochFromTime(now.Add(-age))
+	}
+	z := rec.RecentfileByInterval("Z")
+	z.SetRecentEvents([]recentfile.Event{
+		{Epoch: epoch(time.Hour), Path: "kept.txt", Type: "new"},
+		{Epoch: epoch(2 * time.Hour), Path: "recent-delete.txt", Type: "delete"},
+		{Epoch: epoch(3 * time.Hour), Path: "kept.txt", Type: "new"},
+		{Epoch: epoch(48 * time.Hour), Path: "old-delete.txt", Type: "delete"},
+		{Epoch: epoch(49 * time.Hour), Path: "old-delete.txt", Type: "new"},
+		{Epoch: epoch(50 * time.Hour), Path: "old.txt", Type: "new"},
+	})
+	if err := z.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := func() []string {
+		t.Helper()
+		rf, err := recentfile.NewFromFile(z.Rfile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, event := range rf.RecentEvents() {
+			paths = append(paths, event.Path)
+		}
+		return paths
+	}
+
+	// Without pruning only superseded events go
+	removed, err := rec.CompactZ(0)
+	if err != nil {
+		t.Fatalf("CompactZ failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("CompactZ(0) removed %d events, want 2", removed)
+	}
+	if got, want := paths(), []string{"kept.txt", "recent-delete.txt", "old-delete.txt", "old.txt"}; !slices.Equal(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+
+	removed, err = rec.CompactZ(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("CompactZ failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("CompactZ(24h) removed %d events, want 1", removed)
+	}
+	if got, want := paths(), []string{"kept.txt", "recent-delete.txt", "old.txt"}; !slices.Equal(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+
+	// Nothing left to do
+	if removed, err := rec.CompactZ(24 * time.Hour); err != nil || removed != 0 {
+		t.Errorf("second CompactZ = %d, %v", removed, err)
+	}
+
+	noZ, err := NewWithPrincipal(recentfile.New(
+		recentfile.WithLocalRoot(t.TempDir()),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noZ.CompactZ(time.Hour); err == nil {
+		t.Error("CompactZ without a Z file succeeded")
+	}
+}
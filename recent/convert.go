@@ -0,0 +1,98 @@
+package recent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// BackupSuffix is added to the names of the RECENT files Convert replaces.
+const BackupSuffix = ".bak"
+
+// Convert rewrites every recentfile of the collection in the format of
+// suffix, e.g. ".json" or ".yaml.gz", and points the RECENT.recent
+// symlink at the new principal. The old files, and their signatures, are
+// kept with BackupSuffix added to their names.
+//
+// All recentfiles are locked first (see LockAll) and stay locked until
+// the end. Every new file is written before the symlink is switched, so
+// if one can't be written the ones already written are removed and the
+// hierarchy is left as it was. Clients following the symlink see either
+// the old hierarchy or the new.
+func (r *Recent) Convert(suffix string) error {
+	if _, err := recentfile.GetSerializer(suffix); err != nil {
+		return fmt.Errorf("convert to %s: %w", suffix, err)
+	}
+	principal := r.PrincipalRecentfile()
+	from := principal.Meta().SerializerSuffix
+	if suffix == from {
+		return fmt.Errorf("convert to %s: already in that format", suffix)
+	}
+	recentfiles := r.Recentfiles()
+
+	unlock, err := lockAll(context.Background(), recentfiles)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Recentfiles without a file yet get none in the new format either
+	var converted []*recentfile.Recentfile
+	for _, rf := range recentfiles {
+		err := rf.Read()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", rf.Interval(), err)
+		}
+		converted = append(converted, rf)
+	}
+
+	var old, written []string
+	undo := func() {
+		for _, rfile := range written {
+			os.Remove(rfile)
+			os.Remove(rfile + recentfile.SignatureSuffix)
+		}
+		for _, rf := range recentfiles {
+			rf.SetSerializerSuffix(from)
+		}
+	}
+	for _, rf := range converted {
+		old = append(old, rf.Rfile())
+		rf.SetSerializerSuffix(suffix)
+		if err := rf.Write(); err != nil {
+			undo()
+			return fmt.Errorf("write %s: %w", rf.Interval(), err)
+		}
+		written = append(written, rf.Rfile())
+	}
+	for _, rf := range recentfiles {
+		rf.SetSerializerSuffix(suffix)
+	}
+
+	if err := principal.AssertSymlink(); err != nil {
+		undo()
+		principal.AssertSymlink()
+		return fmt.Errorf("switch symlink: %w", err)
+	}
+
+	var errs []error
+	for _, rfile := range old {
+		if err := os.Rename(rfile, rfile+BackupSuffix); err != nil {
+			errs = append(errs, err)
+		}
+		sig := rfile + recentfile.SignatureSuffix
+		if err := os.Rename(sig, sig+BackupSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("keep old files: %w", err)
+	}
+	return nil
+}
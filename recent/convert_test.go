@@ -0,0 +1,82 @@
+package recent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestConvert(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1d"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.Update("file1.txt", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if err := principal.AssertSymlink(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Convert(".yaml"); err == nil {
+		t.Error("Convert to the same format succeeded")
+	}
+	if err := rec.Convert(".xml"); err == nil {
+		t.Error("Convert to an unknown format succeeded")
+	}
+
+	if err := rec.Convert(".json"); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	for _, interval := range []string{"1h", "6h", "1d"} {
+		path := filepath.Join(tmpDir, "RECENT-"+interval+".json")
+		rf, err := recentfile.NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+		}
+		if got := rf.Meta().SerializerSuffix; got != ".json" {
+			t.Errorf("%s serializer_suffix = %q", interval, got)
+		}
+		if got := rf.RecentEvents(); len(got) != 1 || got[0].Path != "file1.txt" {
+			t.Errorf("%s events = %v, want file1.txt", interval, got)
+		}
+
+		old := filepath.Join(tmpDir, "RECENT-"+interval+".yaml")
+		if _, err := os.Stat(old); !os.IsNotExist(err) {
+			t.Errorf("%s still there: %v", old, err)
+		}
+		if _, err := os.Stat(old + BackupSuffix); err != nil {
+			t.Errorf("no backup of %s: %v", old, err)
+		}
+		if _, err := os.Stat(old + ".lock"); !os.IsNotExist(err) {
+			t.Errorf("%s is still locked", interval)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(tmpDir, "RECENT.recent")); err != nil || target != "RECENT-1h.json" {
+		t.Errorf("RECENT.recent -> %q (%v), want RECENT-1h.json", target, err)
+	}
+
+	// The collection goes on in the new format
+	if err := rec.Update("file2.txt", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	rf, err := recentfile.NewFromFile(filepath.Join(tmpDir, "RECENT-1h.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rf.RecentEvents(); len(got) != 2 {
+		t.Errorf("events after Update = %v", got)
+	}
+}
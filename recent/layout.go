@@ -0,0 +1,81 @@
+package recent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Layout describes a RECENT hierarchy kept in a subdirectory of a larger
+// tree, so several hierarchies can be maintained side by side.
+type Layout struct {
+	Dir        string   // Directory relative to the tree root
+	Interval   string   // Principal interval
+	Aggregator []string // Aggregator intervals
+	Format     string   // Serialization format ("yaml" or "json")
+}
+
+// cpanAggregator is the aggregator chain PAUSE uses for both CPAN hierarchies.
+var cpanAggregator = []string{"6h", "1d", "1W", "1M", "1Q", "1Y", "Z"}
+
+// CPANLayout returns the standard CPAN layout: separate authors/ and
+// modules/ hierarchies, each in YAML with a 1h principal aggregated up to Z.
+func CPANLayout() []Layout {
+	return []Layout{
+		{Dir: "authors", Interval: "1h", Aggregator: append([]string(nil), cpanAggregator...), Format: "yaml"},
+		{Dir: "modules", Interval: "1h", Aggregator: append([]string(nil), cpanAggregator...), Format: "yaml"},
+	}
+}
+
+// ParseLayout parses a hierarchy given as DIR[:INTERVAL[:AGGREGATOR,...]],
+// e.g. "authors:1h:6h,1d,Z". The parts left out stay empty for the caller
+// to fill in, as does the format.
+func ParseLayout(spec string) (Layout, error) {
+	dir, rest, _ := strings.Cut(spec, ":")
+	interval, aggregator, _ := strings.Cut(rest, ":")
+
+	if dir == "" {
+		return Layout{}, fmt.Errorf("hierarchy %q: no directory", spec)
+	}
+	dir = filepath.Clean(filepath.FromSlash(dir))
+	if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+		return Layout{}, fmt.Errorf("hierarchy %q: directory must be below the tree root", spec)
+	}
+
+	layout := Layout{Dir: dir, Interval: interval}
+	if aggregator != "" {
+		layout.Aggregator = strings.Split(aggregator, ",")
+	}
+	for _, interval := range append([]string{layout.Interval}, layout.Aggregator...) {
+		if interval == "" {
+			continue
+		}
+		if _, err := recentfile.ParseInterval(interval); err != nil {
+			return Layout{}, fmt.Errorf("hierarchy %q: %w", spec, err)
+		}
+	}
+	return layout, nil
+}
+
+// CheckLayouts reports an error if two of layouts share a directory or one
+// is nested in another: the watcher of the outer one would record the
+// RECENT files of the inner one as changes.
+func CheckLayouts(layouts []Layout) error {
+	for i, a := range layouts {
+		for _, b := range layouts[i+1:] {
+			if within(a.Dir, b.Dir) || within(b.Dir, a.Dir) {
+				return fmt.Errorf("hierarchies %s and %s overlap", a.Dir, b.Dir)
+			}
+		}
+	}
+	return nil
+}
+
+// within reports whether dir is parent or below it; both are relative to
+// the tree root.
+func within(parent, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
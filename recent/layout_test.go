@@ -0,0 +1,51 @@
+package recent
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Layout
+	}{
+		{"authors", Layout{Dir: "authors"}},
+		{"authors/", Layout{Dir: "authors"}},
+		{"modules:1h", Layout{Dir: "modules", Interval: "1h"}},
+		{"modules:1h:6h,1d,Z", Layout{Dir: "modules", Interval: "1h", Aggregator: []string{"6h", "1d", "Z"}}},
+		{"modules::6h", Layout{Dir: "modules", Aggregator: []string{"6h"}}},
+		{".:30m", Layout{Dir: ".", Interval: "30m"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLayout(tt.spec)
+		if err != nil {
+			t.Errorf("ParseLayout(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got.Dir != tt.want.Dir || got.Interval != tt.want.Interval || !slices.Equal(got.Aggregator, tt.want.Aggregator) {
+			t.Errorf("ParseLayout(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "/srv/cpan", "../other", "a/../../b", "authors:1x", "authors:1h:6h,nope"} {
+		if _, err := ParseLayout(spec); err == nil {
+			t.Errorf("ParseLayout(%q) accepted", spec)
+		}
+	}
+}
+
+func TestCheckLayouts(t *testing.T) {
+	if err := CheckLayouts(CPANLayout()); err != nil {
+		t.Errorf("CPAN layout: %v", err)
+	}
+	for _, dirs := range [][]string{{"a", "a"}, {".", "a"}, {"a", "a/b"}} {
+		layouts := []Layout{{Dir: dirs[0]}, {Dir: dirs[1]}}
+		if err := CheckLayouts(layouts); err == nil {
+			t.Errorf("CheckLayouts accepted %v", dirs)
+		}
+	}
+	if err := CheckLayouts([]Layout{{Dir: "a"}, {Dir: "ab"}}); err != nil {
+		t.Errorf("CheckLayouts(a, ab): %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package recent
+
+import (
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// newsOptions holds the settings for News.
+type newsOptions struct {
+	before     recentfile.Epoch
+	max        int
+	duplicates bool
+}
+
+// NewsOption configures News.
+type NewsOption func(*newsOptions)
+
+// NewsBefore only returns events older than epoch.
+func NewsBefore(epoch recentfile.Epoch) NewsOption {
+	return func(o *newsOptions) {
+		o.before = epoch
+	}
+}
+
+// NewsMax stops after n events.
+func NewsMax(n int) NewsOption {
+	return func(o *newsOptions) {
+		o.max = n
+	}
+}
+
+// NewsDuplicates returns every recorded event instead of only the latest
+// one per path.
+func NewsDuplicates() NewsOption {
+	return func(o *newsOptions) {
+		o.duplicates = true
+	}
+}
+
+// News iterates over the events newer than after, like Perl's
+// File::Rsync::Mirror::Recent::news. The recentfiles are read from disk
+// from the principal upward, each newest first, so events come out newest
+// first except where backdated (dirty) events were inserted. Events kept in
+// more than one recentfile after a merge are returned once, and unless
+// NewsDuplicates is given only the latest event for each path is returned.
+//
+// A read error is yielded once, with a zero event, and ends the iteration.
+func (r *Recent) News(after recentfile.Epoch, opts ...NewsOption) iter.Seq2[recentfile.Event, error] {
+	var o newsOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(yield func(recentfile.Event, error) bool) {
+		seen := make(map[recentfile.Event]bool)
+		count := 0
+		stopped := false
+
+		for _, rf := range r.Recentfiles() {
+			rfilePath := rf.Rfile()
+
+			// Aggregate files may not have been written yet
+			if _, err := os.Stat(rfilePath); os.IsNotExist(err) {
+				continue
+			}
+
+			_, err := recentfile.StreamEvents(rfilePath, 10000, func(events []recentfile.Event) bool {
+				for _, event := range events {
+					// Events are stored newest first
+					if recentfile.EpochLe(event.Epoch, after) {
+						return false
+					}
+					if !o.before.IsZero() && recentfile.EpochGe(event.Epoch, o.before) {
+						continue
+					}
+
+					key := recentfile.Event{Path: event.Path}
+					if o.duplicates {
+						key = event
+					}
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+
+					if !yield(event, nil) {
+						stopped = true
+						return false
+					}
+					count++
+					if o.max > 0 && count >= o.max {
+						stopped = true
+						return false
+					}
+				}
+				return true
+			})
+			if stopped {
+				return
+			}
+			if err != nil {
+				yield(recentfile.Event{}, fmt.Errorf("read %s: %w", filepath.Base(rfilePath), err))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package recent
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestNews(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	update := func(name, typ string) {
+		t.Helper()
+		if err := rec.Update(filepath.Join(tmpDir, name), typ); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+
+	update("a.txt", "new")
+	update("b.txt", "new")
+	// Merged into 1d and, with retention, still in 1h as well
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	update("a.txt", "delete")
+	update("c.txt", "new")
+
+	collect := func(after recentfile.Epoch, opts ...NewsOption) []recentfile.Event {
+		t.Helper()
+		var events []recentfile.Event
+		for event, err := range rec.News(after, opts...) {
+			if err != nil {
+				t.Fatalf("News failed: %v", err)
+			}
+			events = append(events, event)
+		}
+		return events
+	}
+	paths := func(events []recentfile.Event) []string {
+		var p []string
+		for _, e := range events {
+			p = append(p, e.Type+" "+e.Path)
+		}
+		return p
+	}
+
+	all := collect(0)
+	want := []string{"new c.txt", "delete a.txt", "new b.txt"}
+	if got := paths(all); !slices.Equal(got, want) {
+		t.Errorf("News(0) = %v, want %v", got, want)
+	}
+
+	// The events before and after a boundary
+	if got := paths(collect(all[1].Epoch)); !slices.Equal(got, want[:1]) {
+		t.Errorf("News(after) = %v, want %v", got, want[:1])
+	}
+	// Before the delete, a.txt's latest event was its creation
+	if got, want := paths(collect(0, NewsBefore(all[1].Epoch))), []string{"new b.txt", "new a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("News(before) = %v, want %v", got, want)
+	}
+
+	if got := collect(0, NewsMax(2)); len(got) != 2 {
+		t.Errorf("NewsMax(2) returned %d events", len(got))
+	}
+
+	// Every event, but those kept in both recentfiles only once
+	dups := paths(collect(0, NewsDuplicates()))
+	want = []string{"new c.txt", "delete a.txt", "new b.txt", "new a.txt"}
+	if !slices.Equal(dups, want) {
+		t.Errorf("NewsDuplicates = %v, want %v", dups, want)
+	}
+
+	// Breaking out of the loop early is fine
+	for range rec.News(0) {
+		break
+	}
+}
@@ -1,11 +1,15 @@
 package recent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/abh/rrrgo/recentfile"
 )
@@ -25,20 +29,60 @@ type Recent struct {
 	// Verbose logging
 	verbose bool
 
+	// Consumers registered with Subscribe
+	subscribers map[*subscriber]struct{}
+	subMu       sync.Mutex
+
+	// writeMu serializes the updates, aggregations and flushes of this
+	// process: a recentfile locked by one of them fails to lock for the
+	// others instead of waiting, as other processes do
+	writeMu sync.Mutex
+
 	mu sync.RWMutex
 }
 
 // New creates a Recent collection from a principal recentfile path.
 // The principal file must exist and contain aggregator configuration.
 func New(principalPath string) (*Recent, error) {
+	// Get local root from principal's directory
+	return NewWithLocalRoot(principalPath, filepath.Dir(principalPath))
+}
+
+// NewWithLocalRoot is like New for recentfiles kept outside the tree they
+// index (see recentfile.WithIndexDir): event paths are relative to
+// localRoot rather than to the principal's directory.
+func NewWithLocalRoot(principalPath, localRoot string) (*Recent, error) {
 	// Load the principal recentfile
 	principal, err := recentfile.NewFromFile(principalPath)
 	if err != nil {
 		return nil, fmt.Errorf("load principal: %w", err)
 	}
+	return newFromPrincipal(principal, principalPath, localRoot)
+}
 
-	// Get local root from principal's directory
-	localRoot := filepath.Dir(principalPath)
+// NewTolerant is like NewWithLocalRoot, but reads the principal with
+// recentfile.Recentfile.ReadTolerant, so a hierarchy whose principal is
+// damaged can be opened to repair it. It returns how many events of the
+// principal were dropped.
+func NewTolerant(principalPath, localRoot string) (*Recent, int, error) {
+	principal, dropped, err := recentfile.NewFromFileTolerant(principalPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("load principal: %w", err)
+	}
+	r, err := newFromPrincipal(principal, principalPath, localRoot)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, dropped, nil
+}
+
+// newFromPrincipal creates the collection of the principal read from
+// principalPath, with event paths relative to localRoot.
+func newFromPrincipal(principal *recentfile.Recentfile, principalPath, localRoot string) (*Recent, error) {
+	if dir := filepath.Dir(principalPath); dir != localRoot {
+		principal.SetIndexDir(dir)
+		principal.SetLocalRoot(localRoot)
+	}
 
 	// Create Recent collection
 	r := &Recent{
@@ -85,6 +129,9 @@ func (r *Recent) initializeHierarchy() error {
 	// Get aggregator intervals
 	meta := r.principal.Meta()
 	aggregator := meta.Aggregator
+	if err := recentfile.CheckAggregator(r.principal.Interval(), aggregator); err != nil {
+		return err
+	}
 
 	if len(aggregator) == 0 {
 		// No aggregation configured, only principal
@@ -150,6 +197,12 @@ func (r *Recent) LocalRoot() string {
 	return r.localRoot
 }
 
+// IndexDir returns the directory holding the recentfiles, which is the
+// local root unless they are kept outside the tree.
+func (r *Recent) IndexDir() string {
+	return r.PrincipalRecentfile().IndexDir()
+}
+
 // Intervals returns the list of all intervals in the hierarchy.
 func (r *Recent) Intervals() []string {
 	r.mu.RLock()
@@ -164,25 +217,83 @@ func (r *Recent) Intervals() []string {
 
 // Update adds or updates a single file event in the principal recentfile.
 func (r *Recent) Update(path, eventType string, dirtyEpoch ...recentfile.Epoch) error {
-	principal := r.PrincipalRecentfile()
-	return principal.Update(path, eventType, dirtyEpoch...)
+	item := recentfile.BatchItem{
+		Path: path,
+		Type: eventType,
+	}
+	if len(dirtyEpoch) > 0 {
+		item.Epoch = dirtyEpoch[0]
+	}
+	return r.BatchUpdate([]recentfile.BatchItem{item})
 }
 
 // BatchUpdate processes multiple events in the principal recentfile.
+// The committed events are delivered to any subscribers.
 func (r *Recent) BatchUpdate(batch []recentfile.BatchItem) error {
+	return r.BatchUpdateContext(context.Background(), batch)
+}
+
+// BatchUpdateContext is like BatchUpdate, but stops waiting for the lock
+// when ctx is done (see recentfile.Recentfile.LockContext).
+func (r *Recent) BatchUpdateContext(ctx context.Context, batch []recentfile.BatchItem) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
 	principal := r.PrincipalRecentfile()
-	return principal.BatchUpdate(batch)
+	events, err := principal.BatchUpdateEventsContext(ctx, batch)
+	if err != nil {
+		return err
+	}
+	r.publish(events)
+	return nil
 }
 
 // Aggregate runs aggregation on the principal recentfile.
 // This will merge events into larger intervals as configured.
 func (r *Recent) Aggregate(force bool) error {
+	return r.AggregateContext(context.Background(), force)
+}
+
+// AggregateContext is like Aggregate, but gives up when ctx is done (see
+// recentfile.Recentfile.AggregateContext).
+func (r *Recent) AggregateContext(ctx context.Context, force bool) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
 	principal := r.PrincipalRecentfile()
-	return principal.Aggregate(force)
+	return principal.AggregateContext(ctx, force)
+}
+
+// AggregatePlan returns the intervals Aggregate would merge into now (see
+// recentfile.Recentfile.AggregatePlan).
+func (r *Recent) AggregatePlan(force bool) []string {
+	return r.PrincipalRecentfile().AggregatePlan(force)
+}
+
+// Flush writes the events the principal keeps in memory with deferred
+// writes (see SetDeferredWrites).
+func (r *Recent) Flush() error {
+	return r.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, but stops waiting for the lock when ctx is
+// done.
+func (r *Recent) FlushContext(ctx context.Context) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	return r.PrincipalRecentfile().FlushContext(ctx)
+}
+
+// FlushDue reports whether the principal has deferred events that are due
+// to be written (see recentfile.Recentfile.FlushDue).
+func (r *Recent) FlushDue() bool {
+	return r.PrincipalRecentfile().FlushDue()
 }
 
 // EnsureFilesExist ensures all recentfiles in the hierarchy exist on disk.
-// If they don't exist, creates empty files with appropriate metadata.
+// If they don't exist, creates empty files with appropriate metadata. The
+// RECENT.recent symlink is pointed at the principal too.
 func (r *Recent) EnsureFilesExist() error {
 	for _, rf := range r.Recentfiles() {
 		rfile := rf.Rfile()
@@ -208,6 +319,11 @@ func (r *Recent) EnsureFilesExist() error {
 		}
 	}
 
+	// Non-fatal, like for batch updates
+	if err := r.PrincipalRecentfile().AssertSymlink(); err != nil && r.verbose {
+		fmt.Fprintf(os.Stderr, "warn: assert symlink: %v\n", err)
+	}
+
 	return nil
 }
 
@@ -231,6 +347,225 @@ func (r *Recent) LoadAll() error {
 	return nil
 }
 
+// LockAll locks every recentfile of the collection, for maintenance that
+// rewrites several of them while a server may be updating the hierarchy.
+// They are locked largest interval first, as aggregation locks them, so
+// the two can't deadlock, and waiting gives up when ctx is done. If one
+// can't be locked, those already locked are unlocked again. The returned
+// function unlocks them all. The methods of Recent that rewrite the
+// hierarchy, such as Convert and AddInterval, lock it themselves.
+func (r *Recent) LockAll(ctx context.Context) (unlock func(), err error) {
+	return lockAll(ctx, r.Recentfiles())
+}
+
+// LocksHeld returns the intervals of the recentfiles whose locks are held,
+// e.g. by an aggregation run from cron or by another goroutine of this
+// process, which Aggregate would wait for. Each lock is tried without
+// waiting and released again, through a clone so the recentfiles of the
+// collection are left alone; a lock taken right after it was tried is
+// missed.
+func (r *Recent) LocksHeld() ([]string, error) {
+	var held []string
+	for _, rf := range slices.Backward(r.Recentfiles()) {
+		probe := rf.SparseClone()
+		probe.SetInterval(rf.Interval())
+		locked, err := probe.TryLock()
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", rf.Interval(), err)
+		}
+		if !locked {
+			held = append(held, rf.Interval())
+			continue
+		}
+		if err := probe.Unlock(); err != nil {
+			return nil, fmt.Errorf("unlock %s: %w", rf.Interval(), err)
+		}
+	}
+	slices.Reverse(held)
+	return held, nil
+}
+
+// lockAll locks recentfiles like LockAll.
+func lockAll(ctx context.Context, recentfiles []*recentfile.Recentfile) (func(), error) {
+	ordered := slices.Clone(recentfiles)
+	slices.SortFunc(ordered, compareIntervals)
+
+	var locked []*recentfile.Recentfile
+	unlock := func() {
+		for _, rf := range locked {
+			rf.Unlock()
+		}
+	}
+	for _, rf := range slices.Backward(ordered) {
+		if err := rf.LockContext(ctx); err != nil {
+			unlock()
+			return nil, fmt.Errorf("lock %s: %w", rf.Interval(), err)
+		}
+		locked = append(locked, rf)
+	}
+	return unlock, nil
+}
+
+// SetDirtymark sets the dirtymark of every recentfile in the collection
+// and writes them, so mirrors forget what they have synced and do a full
+// re-sync, as after history was rewritten. All recentfiles are locked
+// first (see LockAll), so no merge can carry the old dirtymark into a
+// file in between. The principal is written last: a mirror that sees the
+// new dirtymark there finds it in every file.
+func (r *Recent) SetDirtymark(dirtymark recentfile.Epoch) error {
+	recentfiles := r.Recentfiles()
+
+	unlock, err := lockAll(context.Background(), recentfiles)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, rf := range slices.Backward(recentfiles) {
+		if err := rf.Read(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("read %s: %w", rf.Interval(), err)
+		}
+		rf.SetDirtymark(dirtymark)
+		if err := rf.Write(); err != nil {
+			return fmt.Errorf("write %s: %w", rf.Interval(), err)
+		}
+	}
+
+	return nil
+}
+
+// SetLockBackend selects how every recentfile in the collection is locked
+// (see recentfile.WithLockBackend).
+func (r *Recent) SetLockBackend(name string) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetLockBackend(name)
+	}
+}
+
+// SetLockObserver sets the function called after every attempt to lock a
+// recentfile of the collection (see recentfile.WithLockObserver).
+func (r *Recent) SetLockObserver(observe func(recentfile.LockWait)) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetLockObserver(observe)
+	}
+}
+
+// SetDeferredWrites configures deferred writes of the principal, the only
+// recentfile updated for every batch (see recentfile.WithDeferredWrites).
+func (r *Recent) SetDeferredWrites(every time.Duration, maxEvents int) {
+	r.PrincipalRecentfile().SetDeferredWrites(every, maxEvents)
+}
+
+// SetBreakLocks turns breaking locks held on other hosts on or off for
+// every recentfile in the collection (see recentfile.WithBreakLocks).
+func (r *Recent) SetBreakLocks(on bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetBreakLocks(on)
+	}
+}
+
+// SetRetention turns retention mode on or off for every recentfile in the
+// collection (see recentfile.WithRetention).
+func (r *Recent) SetRetention(on bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetRetention(on)
+	}
+}
+
+// SetDropDeletes sets the aggregator intervals that delete events are not
+// merged into for every recentfile in the collection (see
+// recentfile.WithDropDeletes).
+func (r *Recent) SetDropDeletes(intervals []string) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetDropDeletes(intervals)
+	}
+}
+
+// SetMaxEvents sets the caps on the number of events of the recentfiles
+// of these intervals for every recentfile in the collection (see
+// recentfile.WithMaxEvents).
+func (r *Recent) SetMaxEvents(limits map[string]int) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetMaxEvents(limits)
+	}
+}
+
+// SetGenerations sets the number of previous versions of each file kept
+// on every write for every recentfile in the collection (see
+// recentfile.WithGenerations).
+func (r *Recent) SetGenerations(n int) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetGenerations(n)
+	}
+}
+
+// SetDurableWrites turns syncing every write to disk on or off for every
+// recentfile in the collection (see recentfile.WithDurableWrites).
+func (r *Recent) SetDurableWrites(durable bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetDurableWrites(durable)
+	}
+}
+
+// SetPermissions sets the mode and owner of the files written for every
+// recentfile in the collection (see recentfile.WithPermissions).
+func (r *Recent) SetPermissions(perm recentfile.Permissions) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetPermissions(perm)
+	}
+}
+
+// SetEventMtime turns setting each recentfile's mtime to its newest event
+// on or off for every recentfile in the collection (see
+// recentfile.WithEventMtime).
+func (r *Recent) SetEventMtime(on bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetEventMtime(on)
+	}
+}
+
+// SetPreserveEpochs turns keeping the original text of epochs read from
+// files on or off for every recentfile in the collection (see
+// recentfile.WithPreserveEpochs).
+func (r *Recent) SetPreserveEpochs(on bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetPreserveEpochs(on)
+	}
+}
+
+// SetProtocolExt turns recording file sizes and checksums in events on or
+// off for every recentfile in the collection (see
+// recentfile.WithProtocolExt).
+func (r *Recent) SetProtocolExt(on bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetProtocolExt(on)
+	}
+}
+
+// SetPerlYAML turns writing YAML like the Perl implementation on or off for
+// every recentfile in the collection (see recentfile.WithPerlYAML).
+func (r *Recent) SetPerlYAML(on bool) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetPerlYAML(on)
+	}
+}
+
+// SetProducer sets the name and version recorded in the Producers metadata
+// of every recentfile in the collection (see recentfile.WithProducer).
+func (r *Recent) SetProducer(name, version string) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetProducer(name, version)
+	}
+}
+
+// SetComment sets the metadata comment of every recentfile in the
+// collection (see recentfile.WithComment).
+func (r *Recent) SetComment(comment string) {
+	for _, rf := range r.Recentfiles() {
+		rf.SetComment(comment)
+	}
+}
+
 // Verbose sets verbose logging.
 func (r *Recent) Verbose(v bool) {
 	r.mu.Lock()
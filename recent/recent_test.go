@@ -1,9 +1,12 @@
 package recent
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/abh/rrrgo/recentfile"
 )
@@ -297,6 +300,132 @@ func TestLoadAll(t *testing.T) {
 	}
 }
 
+func TestSetDirtymark(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1d"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.Update("file1.txt", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+
+	dirtymark := recentfile.EpochNow()
+	if err := rec.SetDirtymark(dirtymark); err != nil {
+		t.Fatalf("SetDirtymark failed: %v", err)
+	}
+
+	for _, interval := range []string{"1h", "6h", "1d"} {
+		path := filepath.Join(tmpDir, "RECENT-"+interval+".yaml")
+		rf, err := recentfile.NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+		}
+		if got := rf.Meta().Dirtymark; got != dirtymark {
+			t.Errorf("%s dirtymark = %v, want %v", interval, got, dirtymark)
+		}
+		if got := rf.RecentEvents(); len(got) != 1 || got[0].Path != "file1.txt" {
+			t.Errorf("%s events = %v, want file1.txt", interval, got)
+		}
+		if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+			t.Errorf("%s is still locked", interval)
+		}
+	}
+
+	// Aggregation carries the new dirtymark along
+	if err := rec.Update("file2.txt", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	rf, err := recentfile.NewFromFile(filepath.Join(tmpDir, "RECENT-1d.yaml"))
+	if err != nil {
+		t.Fatalf("NewFromFile failed: %v", err)
+	}
+	if got := rf.Meta().Dirtymark; got != dirtymark {
+		t.Errorf("dirtymark after aggregation = %v, want %v", got, dirtymark)
+	}
+	if got := rf.RecentEvents(); len(got) != 2 {
+		t.Errorf("%d events after aggregation, want 2", len(got))
+	}
+}
+
+func TestLockAll(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1d"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	// Held by another process: the larger interval, locked first, is
+	// released again
+	other := recentfile.New(recentfile.WithLocalRoot(tmpDir), recentfile.WithInterval("6h"))
+	if err := other.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := rec.LocksHeld(); err != nil || !slices.Equal(held, []string{"6h"}) {
+		t.Errorf("LocksHeld() = %v, %v, want 6h", held, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := rec.LockAll(ctx); err == nil {
+		t.Fatal("LockAll succeeded with 6h locked")
+	}
+	for _, rf := range rec.Recentfiles() {
+		if rf.Locked() {
+			t.Errorf("%s still locked after LockAll failed", rf.Interval())
+		}
+	}
+	other.Unlock()
+
+	// Held in this process, e.g. by an archiver: the recentfile keeps its lock
+	daily := rec.RecentfileByInterval("1d")
+	if err := daily.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := rec.LocksHeld(); err != nil || !slices.Equal(held, []string{"1d"}) {
+		t.Errorf("LocksHeld() = %v, %v, want 1d", held, err)
+	}
+	if !daily.Locked() {
+		t.Error("LocksHeld released the lock of 1d")
+	}
+	if err := daily.Unlock(); err != nil {
+		t.Errorf("Unlock after LocksHeld: %v", err)
+	}
+
+	unlock, err := rec.LockAll(context.Background())
+	if err != nil {
+		t.Fatalf("LockAll failed: %v", err)
+	}
+	for _, rf := range rec.Recentfiles() {
+		if !rf.Locked() {
+			t.Errorf("%s not locked", rf.Interval())
+		}
+	}
+	unlock()
+	for _, rf := range rec.Recentfiles() {
+		if _, err := os.Stat(rf.Rfile() + ".lock"); !os.IsNotExist(err) {
+			t.Errorf("%s is still locked", rf.Interval())
+		}
+	}
+}
+
 func TestStats(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -480,6 +609,34 @@ func TestPrincipalInAggregatorList(t *testing.T) {
 	}
 }
 
+func TestInvalidAggregator(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, aggregator := range [][]string{{"1d", "6h"}, {"6h", "bogus"}, {"6h", "1.5d"}} {
+		principal := recentfile.New(
+			recentfile.WithLocalRoot(tmpDir),
+			recentfile.WithInterval("1h"),
+			recentfile.WithAggregator(aggregator),
+		)
+		if _, err := NewWithPrincipal(principal); err == nil {
+			t.Errorf("NewWithPrincipal with aggregator %v succeeded", aggregator)
+		}
+	}
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"90m", "1d12h"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if got := rec.Intervals(); len(got) != 3 || got[1] != "90m" || got[2] != "1d12h" {
+		t.Errorf("Intervals() = %v", got)
+	}
+}
+
 func TestLocalRoot(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -658,3 +815,71 @@ func TestNoAggregator(t *testing.T) {
 		t.Errorf("Aggregate failed: %v", err)
 	}
 }
+
+func TestSubscribe(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+	)
+
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, unsubscribe := rec.Subscribe(ctx)
+	defer unsubscribe()
+
+	batch := []recentfile.BatchItem{
+		{Path: filepath.Join(tmpDir, "a.txt"), Type: "new"},
+		{Path: filepath.Join(tmpDir, "b.txt"), Type: "delete"},
+	}
+	if err := rec.BatchUpdate(batch); err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+
+	select {
+	case got := <-events:
+		if len(got) != 2 {
+			t.Fatalf("got %d events, want 2", len(got))
+		}
+		if got[0].Path != "a.txt" || got[0].Type != "new" {
+			t.Errorf("event[0] = %+v, want a.txt/new", got[0])
+		}
+		if got[1].Path != "b.txt" || got[1].Type != "delete" {
+			t.Errorf("event[1] = %+v, want b.txt/delete", got[1])
+		}
+		if got[0].Epoch.IsZero() {
+			t.Error("event epoch not assigned")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no batch delivered to subscriber")
+	}
+
+	// Cancelling the context closes the channel
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+
+	// So does the cancel func, for a context that never ends
+	events, unsubscribe = rec.Subscribe(context.Background())
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("expected channel to be closed")
+	}
+	if err := rec.BatchUpdate(batch); err != nil {
+		t.Fatalf("BatchUpdate after unsubscribe failed: %v", err)
+	}
+	unsubscribe()
+}
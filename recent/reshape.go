@@ -0,0 +1,188 @@
+package recent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// AddInterval adds interval to the aggregator chain of the collection. Its
+// recentfile is created from the events of the smaller intervals and those
+// of the next larger one within interval, as if it had been aggregated
+// all along, and the new aggregator list is written to every recentfile.
+//
+// Like SetDirtymark, it locks every recentfile first (see LockAll), and
+// writes the principal last, so clients find the new interval in its
+// aggregator list only once it has its file.
+func (r *Recent) AddInterval(interval string) error {
+	secs, err := recentfile.ParseInterval(interval)
+	if err != nil {
+		return fmt.Errorf("add interval: %w", err)
+	}
+	principal := r.PrincipalRecentfile()
+	if secs <= principal.IntervalSecs() {
+		return fmt.Errorf("add interval %s: not larger than the principal's %s", interval, principal.Interval())
+	}
+	for _, rf := range r.Recentfiles() {
+		if rf.IntervalSecs() == secs {
+			return fmt.Errorf("add interval %s: hierarchy has %s already", interval, rf.Interval())
+		}
+	}
+
+	added := principal.SparseClone()
+	added.SetInterval(interval)
+	recentfiles := append(r.Recentfiles(), added)
+	slices.SortFunc(recentfiles, compareIntervals)
+
+	return r.reshape(recentfiles, func() error {
+		var events [][]recentfile.Event
+		for _, rf := range recentfiles {
+			if rf == added {
+				continue
+			}
+			events = append(events, rf.RecentEvents())
+			if rf.IntervalSecs() > secs {
+				break // the next larger one has the older events
+			}
+		}
+		added.SetDirtymark(principal.Meta().Dirtymark)
+		added.SetRecentEvents(newestEvents(interval, events...))
+		added.SortEvents()
+		return nil
+	})
+}
+
+// RemoveInterval retires interval from the aggregator chain of the
+// collection. Its events are merged into the next larger interval, if
+// there is one, the new aggregator list is written to every recentfile,
+// and its file is removed. The principal can't be removed. It locks and
+// writes the recentfiles like AddInterval, and removes the file once the
+// principal no longer lists it.
+func (r *Recent) RemoveInterval(interval string) error {
+	principal := r.PrincipalRecentfile()
+	if interval == principal.Interval() {
+		return fmt.Errorf("remove interval %s: it is the principal", interval)
+	}
+	removed := r.RecentfileByInterval(interval)
+	if removed == nil {
+		return fmt.Errorf("remove interval %s: %w: not in the hierarchy", interval, recentfile.ErrIntervalUnknown)
+	}
+
+	recentfiles := slices.DeleteFunc(r.Recentfiles(), func(rf *recentfile.Recentfile) bool {
+		return rf == removed
+	})
+
+	return r.reshape(recentfiles, func() error {
+		for _, rf := range recentfiles {
+			if rf.IntervalSecs() > removed.IntervalSecs() {
+				rf.SetRecentEvents(newestEvents(rf.Interval(), rf.RecentEvents(), removed.RecentEvents()))
+				rf.SortEvents()
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// reshape makes recentfiles, sorted by interval, the hierarchy of the
+// collection. It locks and reads them and those of the current hierarchy,
+// calls change to fill in their events, writes them all with their
+// intervals as the aggregator list, and removes the files of those that
+// are no longer in it.
+func (r *Recent) reshape(recentfiles []*recentfile.Recentfile, change func() error) error {
+	current := r.Recentfiles()
+	all := slices.Clone(recentfiles)
+	var retired []*recentfile.Recentfile
+	for _, rf := range current {
+		if !slices.Contains(recentfiles, rf) {
+			all = append(all, rf)
+			retired = append(retired, rf)
+		}
+	}
+	slices.SortFunc(all, compareIntervals)
+
+	unlock, err := lockAll(context.Background(), all)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, rf := range all {
+		if err := rf.Read(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("read %s: %w", rf.Interval(), err)
+		}
+	}
+	if err := change(); err != nil {
+		return err
+	}
+
+	var aggregator []string
+	for _, rf := range recentfiles[1:] {
+		aggregator = append(aggregator, rf.Interval())
+	}
+	for _, rf := range slices.Backward(recentfiles) {
+		rf.SetAggregator(slices.Clone(aggregator))
+		if err := rf.Write(); err != nil {
+			return fmt.Errorf("write %s: %w", rf.Interval(), err)
+		}
+	}
+
+	r.mu.Lock()
+	r.recentfiles = recentfiles
+	r.mu.Unlock()
+
+	for _, rf := range retired {
+		for _, name := range []string{rf.Rfile(), rf.Rfile() + recentfile.SignatureSuffix} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compareIntervals orders recentfiles by interval, smallest first.
+func compareIntervals(a, b *recentfile.Recentfile) int {
+	switch {
+	case a.IntervalSecs() < b.IntervalSecs():
+		return -1
+	case a.IntervalSecs() > b.IntervalSecs():
+		return 1
+	}
+	return 0
+}
+
+// newestEvents returns the newest event of every path in sets that is
+// within interval of now, by path; SortEvents puts them in file order.
+func newestEvents(interval string, sets ...[]recentfile.Event) []recentfile.Event {
+	var cutoff recentfile.Epoch
+	if secs := recentfile.IntervalSecsFor(interval); secs != recentfile.ZSeconds {
+		cutoff = recentfile.EpochFromFloat(recentfile.EpochToFloat(recentfile.EpochNow()) - float64(secs))
+	}
+
+	newest := map[string]recentfile.Event{}
+	for _, events := range sets {
+		for _, event := range events {
+			if !cutoff.IsZero() && recentfile.EpochLt(event.Epoch, cutoff) {
+				continue
+			}
+			if seen, ok := newest[event.Path]; !ok || recentfile.EpochGt(event.Epoch, seen.Epoch) {
+				newest[event.Path] = event
+			}
+		}
+	}
+
+	events := make([]recentfile.Event, 0, len(newest))
+	for _, event := range newest {
+		events = append(events, event)
+	}
+	slices.SortFunc(events, func(a, b recentfile.Event) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return events
+}
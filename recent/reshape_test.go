@@ -0,0 +1,120 @@
+package recent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestReshape(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h", "1W"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.Update("file1.txt", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	// Not aggregated yet
+	if err := rec.Update("file2.txt", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	check := func(aggregator []string, events map[string][]string) {
+		t.Helper()
+		if got := rec.Intervals(); !slices.Equal(got, append([]string{"1h"}, aggregator...)) {
+			t.Errorf("Intervals() = %v, want 1h %v", got, aggregator)
+		}
+		for interval, want := range events {
+			rf, err := recentfile.NewFromFile(filepath.Join(tmpDir, "RECENT-"+interval+".yaml"))
+			if err != nil {
+				t.Fatalf("NewFromFile(%s) failed: %v", interval, err)
+			}
+			if got := rf.Meta().Aggregator; !slices.Equal(got, aggregator) {
+				t.Errorf("%s aggregator = %v, want %v", interval, got, aggregator)
+			}
+			if got := rf.Meta().Dirtymark; got != principal.Meta().Dirtymark {
+				t.Errorf("%s dirtymark = %v, want %v", interval, got, principal.Meta().Dirtymark)
+			}
+			var paths []string
+			for _, event := range rf.RecentEvents() {
+				paths = append(paths, event.Path)
+			}
+			slices.Sort(paths)
+			if !slices.Equal(paths, want) {
+				t.Errorf("%s paths = %v, want %v", interval, paths, want)
+			}
+		}
+	}
+
+	for _, interval := range []string{"bogus", "30m", "1h", "1W", "7d"} {
+		if err := rec.AddInterval(interval); err == nil {
+			t.Errorf("AddInterval(%s) succeeded", interval)
+		}
+	}
+
+	if err := rec.AddInterval("bogus"); !errors.Is(err, recentfile.ErrIntervalUnknown) {
+		t.Errorf("AddInterval(bogus) = %v, want ErrIntervalUnknown", err)
+	}
+	if err := rec.RemoveInterval("1M"); !errors.Is(err, recentfile.ErrIntervalUnknown) {
+		t.Errorf("RemoveInterval(1M) = %v, want ErrIntervalUnknown", err)
+	}
+
+	// The new interval gets the events not aggregated yet too
+	if err := rec.AddInterval("1d"); err != nil {
+		t.Fatalf("AddInterval failed: %v", err)
+	}
+	check([]string{"6h", "1d", "1W"}, map[string][]string{
+		"1h": {"file1.txt", "file2.txt"},
+		"6h": {"file1.txt"},
+		"1d": {"file1.txt", "file2.txt"},
+		"1W": {"file1.txt"},
+	})
+
+	for _, interval := range []string{"1h", "1M"} {
+		if err := rec.RemoveInterval(interval); err == nil {
+			t.Errorf("RemoveInterval(%s) succeeded", interval)
+		}
+	}
+
+	if err := rec.RemoveInterval("6h"); err != nil {
+		t.Fatalf("RemoveInterval failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-6h.yaml")); !os.IsNotExist(err) {
+		t.Errorf("RECENT-6h.yaml still there: %v", err)
+	}
+
+	// The events of a retired interval go to the next one
+	if err := rec.RemoveInterval("1d"); err != nil {
+		t.Fatalf("RemoveInterval failed: %v", err)
+	}
+	check([]string{"1W"}, map[string][]string{
+		"1h": {"file1.txt", "file2.txt"},
+		"1W": {"file1.txt", "file2.txt"},
+	})
+
+	// A reopened collection has the new shape
+	reopened, err := New(principal.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Intervals(); !slices.Equal(got, []string{"1h", "1W"}) {
+		t.Errorf("reopened Intervals() = %v", got)
+	}
+	if err := reopened.Aggregate(true); err != nil {
+		t.Errorf("Aggregate after reshaping failed: %v", err)
+	}
+}
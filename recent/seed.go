@@ -0,0 +1,110 @@
+package recent
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/abh/rrrgo/pathfilter"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// WalkFiles calls fn for every file below the local root that is content
+// rather than part of the hierarchy's own bookkeeping, with its path
+// relative to the root. Temporary files are skipped, as are the
+// hierarchy's recentfiles (with their signatures, generations and
+// backups), symlink and lock files in the root directory;
+// RECENT files of hierarchies nested deeper are mirrored content.
+// Unreadable directories are skipped, as is everything filter ignores
+// (filter may be nil).
+func (r *Recent) WalkFiles(filter *pathfilter.Filter, fn func(relPath string, info fs.FileInfo) error) error {
+	localRoot := r.LocalRoot()
+	meta := r.PrincipalRecentfile().Meta()
+	own := meta.Filenameroot + "-"
+
+	return filepath.WalkDir(localRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && path != localRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, err := filepath.Rel(localRoot, path)
+		if err != nil {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if d.IsDir() {
+			if filter.IgnoredDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filter.Ignored(relPath) {
+			return nil
+		}
+
+		baseName := d.Name()
+		if recentfile.ShouldIgnoreFile(baseName) {
+			return nil
+		}
+		if !strings.Contains(relPath, "/") {
+			baseName, _ = recentfile.TrimGeneration(baseName)
+			baseName = strings.TrimSuffix(baseName, recentfile.SignatureSuffix)
+			if baseName == meta.Filenameroot+".recent" {
+				return nil
+			}
+			if strings.HasPrefix(baseName, own) &&
+				(strings.HasSuffix(baseName, meta.SerializerSuffix) ||
+					strings.HasSuffix(baseName, ".lock") ||
+					strings.HasSuffix(baseName, recentfile.TempSuffix) ||
+					strings.HasSuffix(baseName, BackupSuffix)) {
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // vanished
+		}
+		return fn(relPath, info)
+	})
+}
+
+// Seed walks the local root and records a "new" event for every file,
+// using its modification time as a dirty epoch. Each event is written to
+// every recentfile whose interval covers it, as if the hierarchy had been
+// maintained all along; with a Z interval every file is listed. It is meant
+// for populating a new hierarchy over an existing tree. Files filter
+// ignores are left out (filter may be nil). Seed returns the number of
+// files found.
+func (r *Recent) Seed(filter *pathfilter.Filter) (int, error) {
+	now := recentfile.EpochNow()
+
+	var events []recentfile.Event
+	err := r.WalkFiles(filter, func(relPath string, info fs.FileInfo) error {
+		epoch := recentfile.EpochFromTime(info.ModTime())
+		if recentfile.EpochGt(epoch, now) {
+			epoch = now
+		}
+		events = append(events, recentfile.Event{Epoch: epoch, Path: relPath, Type: "new"})
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("scan %s: %w", r.LocalRoot(), err)
+	}
+
+	for _, rf := range r.Recentfiles() {
+		if err := rf.Seed(events, now); err != nil {
+			return 0, fmt.Errorf("seed %s: %w", rf.Interval(), err)
+		}
+	}
+
+	if err := r.PrincipalRecentfile().AssertSymlink(); err != nil {
+		return 0, fmt.Errorf("assert symlink: %w", err)
+	}
+
+	return len(events), nil
+}
@@ -0,0 +1,116 @@
+package recent
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/pathfilter"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestSeed(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d", "Z"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatalf("EnsureFilesExist failed: %v", err)
+	}
+
+	now := time.Now()
+	for name, age := range map[string]time.Duration{
+		"fresh.txt":                time.Minute,
+		"dir/today.txt":            5 * time.Hour,
+		"dir/sub/old.txt":          30 * 24 * time.Hour,
+		"nested/RECENT-1h.yaml":    time.Minute, // another hierarchy's file is content
+		"upload.tmp":               time.Minute,
+		"RECENT-1h.yaml.minisig":   time.Minute, // own signatures are not
+		"RECENT.recent.minisig":    time.Minute,
+		"RECENT-1h.json.bak":       time.Minute, // nor those Convert kept
+		"RECENT-1h.yaml.2":         time.Minute, // nor generations
+		"RECENT-1h.yaml.minisig.2": time.Minute,
+		"RECENT-1h.yaml.1.2a.new":  time.Minute, // nor temp files
+	} {
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-age)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	var walked []string
+	rec.WalkFiles(nil, func(relPath string, info fs.FileInfo) error {
+		walked = append(walked, relPath)
+		return nil
+	})
+	sort.Strings(walked)
+	want := []string{"dir/sub/old.txt", "dir/today.txt", "fresh.txt", "nested/RECENT-1h.yaml"}
+	if len(walked) != len(want) {
+		t.Fatalf("WalkFiles = %v, want %v", walked, want)
+	}
+
+	filter, _ := pathfilter.New([]string{"sub"}, nil)
+	walked = nil
+	rec.WalkFiles(filter, func(relPath string, info fs.FileInfo) error {
+		walked = append(walked, relPath)
+		return nil
+	})
+	if len(walked) != len(want)-1 {
+		t.Errorf("filtered WalkFiles = %v, want dir/sub skipped", walked)
+	}
+
+	n, err := rec.Seed(nil)
+	if err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Seed found %d files, want 4", n)
+	}
+
+	// Each recentfile gets the files within its interval
+	for interval, count := range map[string]int{"1h": 2, "1d": 3, "Z": 4} {
+		rf, err := recentfile.NewFromFile(rec.RecentfileByInterval(interval).Rfile())
+		if err != nil {
+			t.Fatalf("read %s: %v", interval, err)
+		}
+		if got := len(rf.RecentEvents()); got != count {
+			t.Errorf("%s has %d events, want %d", interval, got, count)
+		}
+		if rf.Meta().Dirtymark.IsZero() {
+			t.Errorf("%s has no dirtymark", interval)
+		}
+	}
+
+	// Epochs are the modification times
+	z, _ := recentfile.NewFromFile(rec.RecentfileByInterval("Z").Rfile())
+	oldest := z.RecentEvents()[3]
+	if oldest.Path != "dir/sub/old.txt" {
+		t.Errorf("oldest event = %+v", oldest)
+	}
+	wantEpoch := recentfile.EpochFromTime(now.Add(-30 * 24 * time.Hour))
+	if d := recentfile.EpochToFloat(oldest.Epoch) - recentfile.EpochToFloat(wantEpoch); d < -0.001 || d > 0.001 {
+		t.Errorf("oldest epoch = %v, want %v", oldest.Epoch, wantEpoch)
+	}
+
+	// Later aggregation keeps the seeded history
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	z, _ = recentfile.NewFromFile(rec.RecentfileByInterval("Z").Rfile())
+	if got := len(z.RecentEvents()); got != 4 {
+		t.Errorf("Z has %d events after aggregation, want 4", got)
+	}
+}
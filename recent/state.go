@@ -0,0 +1,54 @@
+package recent
+
+import (
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// CurrentState returns the latest event for every path in the
+// recentfiles: the paths whose latest event is "new" are those that should
+// exist on disk now, the others have been deleted. Recentfiles not written
+// yet are skipped. Events rotated out of Z into an archive are not
+// included.
+func (r *Recent) CurrentState() (map[string]recentfile.Event, error) {
+	state := make(map[string]recentfile.Event)
+
+	for _, rf := range r.Recentfiles() {
+		rfilePath := rf.Rfile()
+
+		// Aggregate files may not have been written yet
+		if _, err := os.Stat(rfilePath); os.IsNotExist(err) {
+			continue
+		}
+
+		_, err := recentfile.StreamEvents(rfilePath, 10000, func(events []recentfile.Event) bool {
+			for _, event := range events {
+				// Keep the event with the highest epoch for each path
+				if existing, ok := state[event.Path]; !ok || recentfile.EpochGt(event.Epoch, existing.Epoch) {
+					state[event.Path] = event
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", filepath.Base(rfilePath), err)
+		}
+	}
+
+	return state, nil
+}
+
+// CurrentStateSeq is like CurrentState, but yields the events one at a
+// time instead of returning them all at once, for hierarchies whose state
+// is too large to hold: only the paths already yielded are kept. Events
+// come out newest first, as from News(0), so for a path whose latest event
+// was backdated (a dirty event) the one read first is yielded.
+//
+// A read error is yielded once, with a zero event, and ends the iteration.
+func (r *Recent) CurrentStateSeq() iter.Seq2[recentfile.Event, error] {
+	return r.News(0)
+}
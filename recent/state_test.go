@@ -0,0 +1,74 @@
+package recent
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestCurrentState(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d"}),
+	)
+	rec, err := NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	update := func(name, typ string) {
+		t.Helper()
+		if err := rec.Update(filepath.Join(tmpDir, name), typ); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+
+	// 1d is not written yet
+	update("a.txt", "new")
+	state, err := rec.CurrentState()
+	if err != nil {
+		t.Fatalf("CurrentState failed: %v", err)
+	}
+	if len(state) != 1 || state["a.txt"].Type != "new" {
+		t.Errorf("state before aggregation = %v", state)
+	}
+
+	update("b.txt", "new")
+	if err := rec.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	update("a.txt", "delete")
+	update("c.txt", "new")
+
+	state, err = rec.CurrentState()
+	if err != nil {
+		t.Fatalf("CurrentState failed: %v", err)
+	}
+	want := map[string]string{"a.txt": "delete", "b.txt": "new", "c.txt": "new"}
+	if len(state) != len(want) {
+		t.Errorf("state = %v, want %v", state, want)
+	}
+	for path, typ := range want {
+		if state[path].Type != typ || state[path].Path != path {
+			t.Errorf("state[%s] = %+v, want %s", path, state[path], typ)
+		}
+	}
+
+	streamed := make(map[string]recentfile.Event)
+	for event, err := range rec.CurrentStateSeq() {
+		if err != nil {
+			t.Fatalf("CurrentStateSeq failed: %v", err)
+		}
+		if _, ok := streamed[event.Path]; ok {
+			t.Errorf("%s yielded twice", event.Path)
+		}
+		streamed[event.Path] = event
+	}
+	if !maps.Equal(streamed, state) {
+		t.Errorf("CurrentStateSeq = %v, CurrentState = %v", streamed, state)
+	}
+}
@@ -0,0 +1,78 @@
+package recent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// subscriberBuffer is the number of batches buffered per subscriber before
+// further batches are dropped for that subscriber.
+const subscriberBuffer = 64
+
+// subscriber is a single consumer registered with Subscribe.
+type subscriber struct {
+	ch   chan []recentfile.Event
+	once sync.Once
+}
+
+// Subscribe registers a consumer for batches committed to the principal
+// recentfile. Each successful Update or BatchUpdate, such as a watcher
+// flush, delivers the written events (canonical paths, assigned epochs)
+// as one slice.
+//
+// Delivery never blocks updates: if the consumer falls more than a few dozen
+// batches behind, further batches are dropped for that consumer.
+// The channel is closed when ctx is done or the returned cancel func is called.
+func (r *Recent) Subscribe(ctx context.Context) (<-chan []recentfile.Event, func()) {
+	sub := &subscriber{
+		ch: make(chan []recentfile.Event, subscriberBuffer),
+	}
+
+	r.subMu.Lock()
+	if r.subscribers == nil {
+		r.subscribers = make(map[*subscriber]struct{})
+	}
+	r.subscribers[sub] = struct{}{}
+	r.subMu.Unlock()
+
+	unsubscribe := func() {
+		r.subMu.Lock()
+		delete(r.subscribers, sub)
+		r.subMu.Unlock()
+		sub.once.Do(func() { close(sub.ch) })
+	}
+
+	// Without a goroutine waiting for ctx, which would outlive a
+	// subscription cancelled while ctx never ends
+	stop := context.AfterFunc(ctx, unsubscribe)
+	cancel := func() {
+		stop()
+		unsubscribe()
+	}
+
+	return sub.ch, cancel
+}
+
+// publish delivers a committed batch to all subscribers.
+func (r *Recent) publish(events []recentfile.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	for sub := range r.subscribers {
+		// Each subscriber gets its own copy so consumers can't interfere
+		batch := make([]recentfile.Event, len(events))
+		copy(batch, events)
+
+		select {
+		case sub.ch <- batch:
+		default:
+			// Subscriber is too slow, drop this batch for it
+		}
+	}
+}
@@ -1,19 +1,119 @@
 package recentfile
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"sort"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Aggregate merges this recentfile into larger interval files.
 // This should be called on the principal (smallest interval) file.
 // It will merge into each aggregator interval in sequence.
 func (rf *Recentfile) Aggregate(force bool) error {
+	return rf.AggregateContext(context.Background(), force)
+}
+
+// AggregateContext is like Aggregate, but gives up when ctx is done while
+// waiting for a lock or between intervals.
+func (rf *Recentfile) AggregateContext(ctx context.Context, force bool) error {
+	ctx, span := tracer.Start(ctx, "Aggregate", trace.WithAttributes(
+		attribute.String("interval", rf.Interval()),
+		attribute.Bool("force", force),
+	))
+	err := rf.aggregate(ctx, force)
+	endSpan(span, err)
+	return err
+}
+
+// aggregate is AggregateContext without the span.
+func (rf *Recentfile) aggregate(ctx context.Context, force bool) error {
+	// Create aggregation chain (Bug #3 fix)
+	// Each level merges from the previous level, not all from principal
+	source := rf
+
+	// Aggregate into each target interval
+	plan, all := rf.AggregatePlan(force), rf.AggregatePlan(true)
+	for i := 0; i < len(plan); i++ {
+		targetInterval := plan[i]
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("aggregate into %s: %w", targetInterval, err)
+		}
+
+		// Create sparse clone for target interval from PREVIOUS level
+		target := source.SparseClone()
+		target.SetInterval(targetInterval)
+
+		// Perform the merge from previous level (not always from principal)
+		if err := target.MergeFromContext(ctx, source); err != nil {
+			return fmt.Errorf("merge into %s: %w", targetInterval, err)
+		}
+
+		// Update source's merged metadata, and write source file to persist
+		// it (needed for next aggregation cycle); events beyond its cap go
+		// now that they are merged
+		changed := source.setMerged(target, targetInterval)
+		if changed || source.Overflowing() {
+			if err := source.LockContext(ctx); err != nil {
+				return fmt.Errorf("lock source %s: %w", source.interval, err)
+			}
+			source.mu.Lock()
+			if capped := source.capEvents(source.recent); len(capped) < len(source.recent) {
+				source.recent = capped
+				source.updateMinmax()
+				changed = true
+			}
+			source.mu.Unlock()
+			if changed {
+				if err := source.Write(); err != nil {
+					source.Unlock()
+					return fmt.Errorf("write source %s: %w", source.interval, err)
+				}
+			}
+			source.Unlock()
+		}
+
+		// A target left over its cap overflows into the next interval
+		if i == len(plan)-1 && i+1 < len(all) && target.overCap() {
+			plan = append(plan, all[i+1])
+		}
+
+		// Use target as source for next iteration (creates the chain)
+		source = target
+	}
+
+	return nil
+}
+
+// overCap reports whether the file of the recentfile holds more events
+// than its cap (see WithMaxEvents).
+func (rf *Recentfile) overCap() bool {
+	rf.mu.RLock()
+	limit := rf.maxEvents[rf.interval]
+	rf.mu.RUnlock()
+	if limit <= 0 {
+		return false
+	}
+	stats, err := StreamEvents(rf.Rfile(), 1000, func([]Event) bool { return true })
+	return err == nil && stats.EventCount > limit
+}
+
+// AggregatePlan returns the aggregator intervals Aggregate would merge
+// into now, smallest first: the one above this recentfile's interval, and
+// each larger one whose file is older than the interval two levels below
+// it, up to the first that isn't. With force they are all of them.
+func (rf *Recentfile) AggregatePlan(force bool) []string {
 	// Get aggregator intervals
+	rf.mu.RLock()
 	aggregator := rf.meta.Aggregator
+	rf.mu.RUnlock()
 	if len(aggregator) == 0 {
 		return nil // No aggregation configured
 	}
@@ -34,28 +134,20 @@ func (rf *Recentfile) Aggregate(force bool) error {
 		}
 	}
 
-	if len(targetIntervals) == 0 {
-		return nil // No larger intervals to aggregate into
-	}
-
 	// Track interval of the level BEFORE current source (for age checking)
 	// Perl: uses $aggs[$i-1]{object} to check against previous level's interval
 	prevSourceInterval := rf.interval
+	sourceInterval := rf.interval
 
-	// Create aggregation chain (Bug #3 fix)
-	// Each level merges from the previous level, not all from principal
-	source := rf
-
-	// Aggregate into each target interval
+	var plan []string
 	for _, targetInterval := range targetIntervals {
-		// Create sparse clone for target interval from PREVIOUS level
-		target := source.SparseClone()
+		target := rf.SparseClone()
 		target.SetInterval(targetInterval)
 
 		// Decide if we should merge
 		// First iteration (source is principal): always merge
 		// Later iterations: check if target file is old enough
-		shouldMerge := force || source.interval == rf.interval
+		shouldMerge := force || sourceInterval == rf.interval
 		if !shouldMerge {
 			// Check target file age vs PREVIOUS source's interval duration
 			// Perl: $next_age > $prev->interval_secs (prev = level before current source)
@@ -66,44 +158,71 @@ func (rf *Recentfile) Aggregate(force bool) error {
 			// Skip remaining intervals
 			break
 		}
-
-		// Perform the merge from previous level (not always from principal)
-		if err := target.MergeFrom(source); err != nil {
-			return fmt.Errorf("merge into %s: %w", targetInterval, err)
-		}
-
-		// Update source's merged metadata
-		source.mu.Lock()
-		if len(target.recent) > 0 {
-			source.meta.Merged = &MergedInfo{
-				Epoch:        target.recent[0].Epoch,
-				IntoInterval: targetInterval,
-			}
-		}
-		source.mu.Unlock()
-
-		// Write source file to persist merged metadata (needed for next aggregation cycle)
-		if err := source.Lock(); err != nil {
-			return fmt.Errorf("lock source %s: %w", source.interval, err)
-		}
-		if err := source.Write(); err != nil {
-			source.Unlock()
-			return fmt.Errorf("write source %s: %w", source.interval, err)
-		}
-		source.Unlock()
+		plan = append(plan, targetInterval)
 
 		// Save current source's interval before moving to next level
-		prevSourceInterval = source.interval
-		// Use target as source for next iteration (creates the chain)
-		source = target
+		prevSourceInterval = sourceInterval
+		sourceInterval = targetInterval
 	}
+	return plan
+}
 
-	return nil
+// setMerged records in the metadata that rf has been merged into target,
+// the recentfile for interval, up to target's newest event. It reports
+// whether the metadata changed and so needs to be written.
+func (rf *Recentfile) setMerged(target *Recentfile, interval string) bool {
+	minmax := target.Meta().Minmax
+	if minmax == nil {
+		return false
+	}
+	merged := MergedInfo{
+		Epoch:        minmax.Max,
+		IntoInterval: interval,
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.meta.Merged != nil && *rf.meta.Merged == merged {
+		return false
+	}
+	rf.meta.Merged = &merged
+	return true
 }
 
 // MergeFrom merges events from the source recentfile into this (larger interval) recentfile.
 // This recentfile (rf) should have a larger interval than the source.
+//
+// Both files are sorted by epoch, so the target file is streamed through
+// and merged with the source rather than read whole. If the serializer
+// implements StreamMarshaler, the merged file is written as it is merged
+// too, and the merged events are not kept: rf has the new metadata but no
+// events until it is read again. Only the source events are held in
+// memory, so aggregating into a large Z file stays cheap.
+//
+// An existing target whose events and dirtymark the merge doesn't change
+// is not written at all. If rf's interval is one of WithDropDeletes, the
+// source's delete events are not merged (see mergeEvents).
 func (rf *Recentfile) MergeFrom(source *Recentfile) error {
+	return rf.MergeFromContext(context.Background(), source)
+}
+
+// MergeFromContext is like MergeFrom, but stops waiting for the locks when
+// ctx is done (see LockContext).
+func (rf *Recentfile) MergeFromContext(ctx context.Context, source *Recentfile) error {
+	ctx, span := tracer.Start(ctx, "MergeFrom", trace.WithAttributes(
+		attribute.String("interval", rf.Interval()),
+		attribute.String("source_interval", source.Interval()),
+	))
+	err := rf.mergeFrom(ctx, source)
+	source.mu.RLock()
+	span.SetAttributes(attribute.Int("events", len(source.recent)))
+	source.mu.RUnlock()
+	endSpan(span, err)
+	return err
+}
+
+// mergeFrom is MergeFromContext without the span.
+func (rf *Recentfile) mergeFrom(ctx context.Context, source *Recentfile) error {
 	// Sanity check: target interval should be larger than source
 	if rf.IntervalSecs() <= source.IntervalSecs() {
 		return fmt.Errorf("cannot merge %s into %s (target must be larger)",
@@ -111,35 +230,44 @@ func (rf *Recentfile) MergeFrom(source *Recentfile) error {
 	}
 
 	// Lock both files
-	if err := rf.Lock(); err != nil {
+	if err := rf.LockContext(ctx); err != nil {
 		return fmt.Errorf("lock target: %w", err)
 	}
 	defer rf.Unlock()
 
-	if err := source.Lock(); err != nil {
+	if err := source.LockContext(ctx); err != nil {
 		return fmt.Errorf("lock source: %w", err)
 	}
 	defer source.Unlock()
 
-	// Read both files (ignore error if target doesn't exist yet)
-	if err := rf.Read(); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("read target: %w", err)
-	}
-
 	if err := source.Read(); err != nil {
 		return fmt.Errorf("read source: %w", err)
 	}
 
-	rf.mu.Lock()
+	// Read the target's metadata; its events are streamed below (ignore
+	// error if target doesn't exist yet)
+	rfile := rf.Rfile()
+	rf.mu.RLock()
+	meta := rf.meta
+	rf.mu.RUnlock()
+	m, err := fileMeta(rfile)
+	exists := err == nil
+	if exists {
+		meta = m
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read target: %w", err)
+	}
+
 	source.mu.RLock()
+	defer source.mu.RUnlock()
 
 	// Calculate oldest allowed epoch
 	// IMPORTANT: Check dirtymark BEFORE copying (Perl does comparison before assignment)
 	var oldestAllowed Epoch
-	if rf.meta.Dirtymark != source.meta.Dirtymark {
+	if meta.Dirtymark != source.meta.Dirtymark {
 		// Dirtymarks differ, keep everything
 		oldestAllowed = 0
-	} else if rf.meta.Merged != nil && !rf.meta.Merged.Epoch.IsZero() {
+	} else if meta.Merged != nil && !meta.Merged.Epoch.IsZero() {
 		// Target has merged metadata - calculate cutoff
 		// Perl: } elsif (my $merged = $self->merged) {
 		now := EpochNow()
@@ -153,7 +281,7 @@ func (rf *Recentfile) MergeFrom(source *Recentfile) error {
 
 		// Use minimum of interval cutoff and merged epoch
 		// Perl: $oldest_allowed = min($epoch - $secs, $merged->{epoch}||0)
-		mergedEpoch := rf.meta.Merged.Epoch
+		mergedEpoch := meta.Merged.Epoch
 		if !intervalCutoff.IsZero() && EpochLt(intervalCutoff, mergedEpoch) {
 			oldestAllowed = intervalCutoff
 		} else {
@@ -175,75 +303,150 @@ func (rf *Recentfile) MergeFrom(source *Recentfile) error {
 		oldestAllowed = 0
 	}
 
-	// Merge events from both
-	mergedEvents := make(map[string]Event) // path -> event
+	// Merge events from both, dropping old events from the target too
+	// (Bug #2 fix). Don't truncate - filtering already happened via
+	// oldestAllowed; Perl writes merged events directly without additional
+	// truncation.
+	targetEvents := fileEvents(rfile)
+	rf.mu.RLock()
+	if !rf.preserveEpochs {
+		targetEvents = withoutEpochText(targetEvents)
+	}
+	if !rf.protocolExt {
+		targetEvents = withoutProtocolExt(targetEvents)
+	}
+	dropDeletes := slices.Contains(rf.dropDeletes, rf.interval)
+	limit := rf.maxEvents[rf.interval]
+	rf.mu.RUnlock()
+	merged := mergeEvents(targetEvents, source.recent, oldestAllowed, dropDeletes)
+	merged = cappedEvents(merged, limit, meta.Merged)
 
-	// Add events from target (rf) - filter old events like Perl does
-	for _, event := range rf.recent {
-		// Skip old events from target (Bug #2 fix)
-		if !oldestAllowed.IsZero() && EpochLt(event.Epoch, oldestAllowed) {
-			continue
-		}
-		mergedEvents[event.Path] = event
+	// Copy source dirtymark (Perl does this after filtering, before write)
+	// Perl: if (!$self->dirtymark || $other->dirtymark ne $self->dirtymark)
+	changed := !exists
+	if meta.Dirtymark.IsZero() || meta.Dirtymark != source.meta.Dirtymark {
+		changed = changed || meta.Dirtymark != source.meta.Dirtymark
+		meta.Dirtymark = source.meta.Dirtymark
 	}
 
-	// Add/update events from source
-	for _, event := range source.recent {
-		// Check if event is old enough to skip
-		if !oldestAllowed.IsZero() && EpochLt(event.Epoch, oldestAllowed) {
-			continue
-		}
+	// An existing target is only rewritten if the merge changes its
+	// events, so aggregating without new events leaves its mtime alone
+	// and doesn't make downstream mirrors fetch it again
+	var unchanged *eventMatcher
+	if !changed {
+		unchanged = newEventMatcher(fileEvents(rfile))
+		defer unchanged.stop()
+	}
 
-		// Check if we should keep this event
-		if existing, ok := mergedEvents[event.Path]; ok {
-			// Path exists, keep the newer one
-			if EpochGt(event.Epoch, existing.Epoch) {
-				mergedEvents[event.Path] = event
-			}
+	// Like Read, take the state derived from the metadata from an
+	// existing target file
+	update := func() {
+		if exists {
+			rf.setMeta(meta)
 		} else {
-			// New path
-			mergedEvents[event.Path] = event
+			rf.meta = meta
 		}
 	}
 
-	// Convert map to slice
-	newRecent := make([]Event, 0, len(mergedEvents))
-	for _, event := range mergedEvents {
-		// Handle delete events for Z interval
-		if rf.interval == "Z" && event.Type == "delete" {
-			// Optionally skip delete events in Z file
-			// For now, keep them (configurable in future)
-		}
-		newRecent = append(newRecent, event)
+	serializer, err := GetSerializer(rf.serializerSuffix)
+	if err != nil {
+		return fmt.Errorf("write target: marshal: %w", err)
 	}
+	sm, ok := serializer.(StreamMarshaler)
+	if !ok {
+		// The serializer needs all events at once
+		events, err := collectEvents(merged)
+		if err != nil {
+			return fmt.Errorf("read target: %w", err)
+		}
+		if unchanged != nil {
+			for _, event := range events {
+				if err := unchanged.match(event); err != nil {
+					return fmt.Errorf("read target: %w", err)
+				}
+			}
+			same, err := unchanged.same()
+			if err != nil {
+				return fmt.Errorf("read target: %w", err)
+			}
+			changed = !same
+		}
 
-	// Sort by epoch descending
-	rf.sortEventsByEpoch(newRecent)
-
-	// Handle epoch conflicts (very rare)
-	newRecent = rf.DeduplicateEpochs(newRecent)
-
-	// Don't truncate - filtering already happened via oldestAllowed
-	// Perl writes merged events directly without additional truncation
-	rf.recent = newRecent
-
-	// Update minmax
-	rf.updateMinmax()
+		rf.mu.Lock()
+		update()
+		rf.recent = events
+		if changed {
+			rf.updateMinmax()
+		}
+		rf.mu.Unlock()
 
-	// Copy source dirtymark (Perl does this after filtering, before write)
-	// Perl: if (!$self->dirtymark || $other->dirtymark ne $self->dirtymark)
-	if rf.meta.Dirtymark.IsZero() || rf.meta.Dirtymark != source.meta.Dirtymark {
-		rf.meta.Dirtymark = source.meta.Dirtymark
+		if !changed {
+			return nil
+		}
+		if err := rf.Write(); err != nil {
+			return fmt.Errorf("write target: %w", err)
+		}
+		return nil
 	}
 
-	source.mu.RUnlock()
-	rf.mu.Unlock()
+	// The metadata comes before the events, so a first pass over the
+	// merge finds the minmax before the second one writes it
+	var count int
+	var first, last Epoch
+	for event, err := range merged {
+		if err != nil {
+			return fmt.Errorf("read target: %w", err)
+		}
+		if count == 0 {
+			first = event.Epoch
+		}
+		last = event.Epoch
+		count++
+		if unchanged != nil {
+			if err := unchanged.match(event); err != nil {
+				return fmt.Errorf("read target: %w", err)
+			}
+		}
+	}
+	if unchanged != nil {
+		same, err := unchanged.same()
+		if err != nil {
+			return fmt.Errorf("read target: %w", err)
+		}
+		if same {
+			rf.mu.Lock()
+			update()
+			rf.recent = nil
+			rf.mu.Unlock()
+			return nil
+		}
+	}
+	meta.Minmax = nil
+	if count > 0 {
+		meta.Minmax = &MinmaxInfo{
+			Max:   first,
+			Min:   last,
+			Mtime: time.Now().Unix(),
+		}
+	}
 
-	// Write target file
-	if err := rf.Write(); err != nil {
+	rf.mu.RLock()
+	opts := rf.writeOptions(meta.Minmax)
+	rf.mu.RUnlock()
+	if err := writeSigned(rfile, opts, func(w io.Writer) error {
+		if err := sm.MarshalTo(w, &meta, merged); err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		return nil
+	}); err != nil {
 		return fmt.Errorf("write target: %w", err)
 	}
 
+	rf.mu.Lock()
+	update()
+	rf.recent = nil
+	rf.mu.Unlock()
+
 	return nil
 }
 
@@ -277,6 +480,9 @@ func (rf *Recentfile) DeduplicateEpochs(events []Event) []Event {
 }
 
 // shouldMergeByAge checks if target file is old enough to warrant merging.
+// The age comes from the time of the last write recorded in the metadata
+// (minmax.mtime), not the file's mtime, which WithEventMtime sets to the
+// newest event instead. Files without it fall back to the file's mtime.
 func shouldMergeByAge(target *Recentfile, prevInterval string) bool {
 	targetFile := target.Rfile()
 	stat, err := os.Stat(targetFile)
@@ -287,8 +493,17 @@ func shouldMergeByAge(target *Recentfile, prevInterval string) bool {
 		return false // Can't stat, skip
 	}
 
+	written := stat.ModTime()
+	meta, err := fileMeta(targetFile)
+	if err != nil {
+		return false // Can't read, skip
+	}
+	if meta.Minmax != nil && meta.Minmax.Mtime != 0 {
+		written = time.Unix(meta.Minmax.Mtime, 0)
+	}
+
 	// Check if target file is older than previous interval duration
-	targetAge := time.Since(stat.ModTime())
+	targetAge := time.Since(written)
 	prevDuration := time.Duration(IntervalSecsFor(prevInterval)) * time.Second
 
 	return targetAge > prevDuration
@@ -344,18 +559,103 @@ func (rf *Recentfile) AggregateInterval(sourceInterval, targetInterval string) e
 	}
 	defer source.Unlock()
 
-	source.mu.Lock()
-	if len(target.recent) > 0 {
-		source.meta.Merged = &MergedInfo{
-			Epoch:        target.recent[0].Epoch,
-			IntoInterval: targetInterval,
-		}
+	if !source.setMerged(target, targetInterval) {
+		return nil
 	}
-	source.mu.Unlock()
-
 	if err := source.Write(); err != nil {
 		return fmt.Errorf("write source metadata: %w", err)
 	}
 
 	return nil
 }
+
+// Expire removes events older than cutoff from this recentfile. The removed
+// events are handed to archive before the file is rewritten, so they are
+// never lost; if archive fails the file is left unchanged.
+// Returns the number of events removed.
+func (rf *Recentfile) Expire(cutoff Epoch, archive func(events []Event) error) (int, error) {
+	if err := rf.Lock(); err != nil {
+		return 0, fmt.Errorf("lock: %w", err)
+	}
+	defer rf.Unlock()
+
+	if err := rf.Read(); err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+
+	rf.mu.Lock()
+	var keep, expired []Event
+	for _, event := range rf.recent {
+		if EpochLt(event.Epoch, cutoff) {
+			expired = append(expired, event)
+		} else {
+			keep = append(keep, event)
+		}
+	}
+	rf.mu.Unlock()
+
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	if err := archive(expired); err != nil {
+		return 0, fmt.Errorf("archive: %w", err)
+	}
+
+	rf.mu.Lock()
+	rf.recent = keep
+	rf.updateMinmax()
+	rf.mu.Unlock()
+
+	if err := rf.Write(); err != nil {
+		return 0, fmt.Errorf("write: %w", err)
+	}
+
+	return len(expired), nil
+}
+
+// Compact rewrites this recentfile without superseded events, keeping
+// only the newest event of each path, and without delete events older
+// than cutoff; a zero cutoff keeps every delete. It is meant for the Z
+// file, which otherwise keeps a delete for every file ever removed.
+// Returns the number of events removed.
+func (rf *Recentfile) Compact(cutoff Epoch) (int, error) {
+	if err := rf.Lock(); err != nil {
+		return 0, fmt.Errorf("lock: %w", err)
+	}
+	defer rf.Unlock()
+
+	if err := rf.Read(); err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+
+	rf.mu.Lock()
+	seen := make(map[string]bool, len(rf.recent))
+	keep := make([]Event, 0, len(rf.recent))
+	for _, event := range rf.recent {
+		if seen[event.Path] {
+			continue
+		}
+		seen[event.Path] = true
+		if event.Type == "delete" && !cutoff.IsZero() && EpochLt(event.Epoch, cutoff) {
+			continue
+		}
+		keep = append(keep, event)
+	}
+	removed := len(rf.recent) - len(keep)
+	if removed > 0 {
+		rf.recent = keep
+		rf.updateMinmax()
+	}
+	rf.mu.Unlock()
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err := rf.Write(); err != nil {
+		return 0, fmt.Errorf("write: %w", err)
+	}
+
+	return removed, nil
+}
@@ -3,8 +3,13 @@ package recentfile
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestMergeFrom(t *testing.T) {
@@ -405,6 +410,22 @@ func TestShouldMergeByAge(t *testing.T) {
 	if !shouldMerge {
 		t.Error("should merge when file doesn't exist")
 	}
+
+	// The age comes from the metadata, not the file's mtime
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(target.Rfile(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if shouldMergeByAge(target, "1h") {
+		t.Error("should not merge file written recently with an old mtime")
+	}
+	backdate(t, target.Rfile(), old)
+	if err := os.Chtimes(target.Rfile(), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !shouldMergeByAge(target, "1h") {
+		t.Error("should merge file written 2h ago with a new mtime")
+	}
 }
 
 func TestMergeMultipleLevels(t *testing.T) {
@@ -520,6 +541,44 @@ func TestAggregateSkipsWhenNotNeeded(t *testing.T) {
 	}
 }
 
+func TestAggregatePlan(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithAggregator([]string{"1W", "6h", "1d"}),
+	)
+	if err := principal.BatchUpdate([]BatchItem{{Path: "file1.txt", Type: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(force bool, want ...string) {
+		t.Helper()
+		if got := principal.AggregatePlan(force); !slices.Equal(got, want) {
+			t.Errorf("AggregatePlan(%v) = %v, want %v", force, got, want)
+		}
+	}
+
+	// Missing files are always merged into
+	check(false, "6h", "1d", "1W")
+
+	// The plan doesn't write anything
+	if _, err := os.Stat(filepath.Join(tmpDir, "RECENT-6h.yaml")); !os.IsNotExist(err) {
+		t.Errorf("6h file written by the plan: %v", err)
+	}
+
+	if err := principal.Aggregate(false); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	check(false, "6h")
+	check(true, "6h", "1d", "1W")
+
+	// 1d is due once its file is older than 1h, 1W once its file is older than 6h
+	backdate(t, filepath.Join(tmpDir, "RECENT-1d.yaml"), time.Now().Add(-2*time.Hour))
+	check(false, "6h", "1d")
+}
+
 func TestMergeFromWithMergedEpochMinLogic(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -632,17 +691,11 @@ func TestAggregateChainProgressionWithCorrectInterval(t *testing.T) {
 	rf1d := filepath.Join(tmpDir, "RECENT-1d.yaml")
 	rf1W := filepath.Join(tmpDir, "RECENT-1W.yaml")
 
-	// Set realistic mtimes
-	if err := os.Chtimes(rf6h, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
-		t.Fatalf("Failed to set 6h mtime: %v", err)
-	}
-	if err := os.Chtimes(rf1d, now.Add(-8*time.Hour), now.Add(-8*time.Hour)); err != nil {
-		t.Fatalf("Failed to set 1d mtime: %v", err)
-	}
-	initial1WMtime := now.Add(-8 * time.Hour)
-	if err := os.Chtimes(rf1W, initial1WMtime, initial1WMtime); err != nil {
-		t.Fatalf("Failed to set 1W mtime: %v", err)
-	}
+	// Set realistic ages
+	backdate(t, rf6h, now.Add(-2*time.Hour))
+	backdate(t, rf1d, now.Add(-8*time.Hour))
+	initial1WMtime := now.Add(-8 * time.Hour).Truncate(time.Second)
+	backdate(t, rf1W, initial1WMtime)
 
 	// Add a new event to trigger aggregation
 	principal.BatchUpdate([]BatchItem{
@@ -687,6 +740,26 @@ func TestAggregateChainProgressionWithCorrectInterval(t *testing.T) {
 	}
 }
 
+// backdate makes the recentfile at path look as if it was last written at
+// written, in both its metadata and its mtime.
+func backdate(t *testing.T, path string, written time.Time) {
+	t.Helper()
+
+	rf, err := NewFromFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if rf.meta.Minmax != nil {
+		rf.meta.Minmax.Mtime = written.Unix()
+	}
+	if err := rf.Write(); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatalf("set mtime of %s: %v", path, err)
+	}
+}
+
 // TestMergeFromFirstMergePreservesAllEvents tests Bug #6 fix:
 // First merge (no merged metadata) should preserve all events,
 // not truncate based on interval.
@@ -758,3 +831,356 @@ func TestMergeFromFirstMergePreservesAllEvents(t *testing.T) {
 		t.Error("old_file.txt from 10 days ago should be kept when no merged metadata exists")
 	}
 }
+
+func TestMergeFromStreaming(t *testing.T) {
+	targetEvents := []Event{
+		{Epoch: 1704207900, Path: "tie.txt", Type: "new"},
+		{Epoch: 1704207800, Path: "shared.txt", Type: "new"},
+		{Epoch: 1704207700, Path: "old-only.txt", Type: "new"},
+		{Epoch: 1704207600, Path: "replaced.txt", Type: "new"},
+	}
+	sourceEvents := []Event{
+		{Epoch: 1704208000, Path: "replaced.txt", Type: "delete"},
+		{Epoch: 1704207900, Path: "tie.txt", Type: "delete"},
+		{Epoch: 1704207800, Path: "new.txt", Type: "new"},
+		{Epoch: 1704207500, Path: "shared.txt", Type: "delete"},
+	}
+
+	for _, suffix := range []string{".yaml", ".json", ".json.gz"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			source := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix))
+			source.SetRecentEvents(sourceEvents)
+			if err := source.Write(); err != nil {
+				t.Fatal(err)
+			}
+			target := New(WithLocalRoot(tmpDir), WithInterval("6h"), WithSerializerSuffix(suffix))
+			target.SetRecentEvents(targetEvents)
+			if err := target.Write(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := target.MergeFrom(source); err != nil {
+				t.Fatalf("MergeFrom failed: %v", err)
+			}
+
+			targetRead, err := NewFromFile(target.Rfile())
+			if err != nil {
+				t.Fatalf("Read target failed: %v", err)
+			}
+			got := targetRead.RecentEvents()
+
+			// Newest event per path, the target's on a tie; the two
+			// events at 1704207800 get unique epochs, in merge order
+			want := []struct {
+				path, typ string
+			}{
+				{"replaced.txt", "delete"},
+				{"tie.txt", "new"},
+				{"new.txt", "new"},
+				{"shared.txt", "new"},
+				{"old-only.txt", "new"},
+			}
+			if len(got) != len(want) {
+				t.Fatalf("target has %d events, want %d: %+v", len(got), len(want), got)
+			}
+			for i, w := range want {
+				if got[i].Path != w.path || got[i].Type != w.typ {
+					t.Errorf("event %d = %s %s, want %s %s", i, got[i].Path, got[i].Type, w.path, w.typ)
+				}
+				if i > 0 && !EpochGt(got[i-1].Epoch, got[i].Epoch) {
+					t.Errorf("epochs not strictly descending at %d: %v, %v", i, got[i-1].Epoch, got[i].Epoch)
+				}
+			}
+
+			minmax := targetRead.Meta().Minmax
+			if minmax == nil || minmax.Max != got[0].Epoch || minmax.Min != got[len(got)-1].Epoch {
+				t.Errorf("minmax = %+v", minmax)
+			}
+			if m := target.Meta().Minmax; m == nil || m.Max != 1704208000 {
+				t.Errorf("target minmax after merge = %+v", m)
+			}
+		})
+	}
+}
+
+func TestMergeFromSkipsUnchangedTarget(t *testing.T) {
+	for _, suffix := range []string{".yaml", ".json"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			principal := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithAggregator([]string{"6h"}),
+				WithSerializerSuffix(suffix),
+			)
+			if err := principal.BatchUpdate([]BatchItem{{Path: "a.txt", Type: "new"}}); err != nil {
+				t.Fatalf("BatchUpdate failed: %v", err)
+			}
+			if err := principal.AggregateInterval("1h", "6h"); err != nil {
+				t.Fatalf("AggregateInterval failed: %v", err)
+			}
+
+			// Backdate both files, so a rewrite would show in their mtime
+			old := time.Now().Add(-time.Hour).Truncate(time.Second)
+			target := principal.SparseClone()
+			target.SetInterval("6h")
+			for _, path := range []string{principal.Rfile(), target.Rfile()} {
+				if err := os.Chtimes(path, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := principal.AggregateInterval("1h", "6h"); err != nil {
+				t.Fatalf("AggregateInterval failed: %v", err)
+			}
+			for _, path := range []string{principal.Rfile(), target.Rfile()} {
+				fi, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !fi.ModTime().Equal(old) {
+					t.Errorf("%s rewritten without changes", filepath.Base(path))
+				}
+			}
+
+			// A new event is merged and written
+			if err := principal.BatchUpdate([]BatchItem{{Path: "b.txt", Type: "new"}}); err != nil {
+				t.Fatalf("BatchUpdate failed: %v", err)
+			}
+			if err := principal.AggregateInterval("1h", "6h"); err != nil {
+				t.Fatalf("AggregateInterval failed: %v", err)
+			}
+			fi, err := os.Stat(target.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.ModTime().Equal(old) {
+				t.Error("target not written after a new event")
+			}
+			targetRead, err := NewFromFile(target.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(targetRead.RecentEvents()); n != 2 {
+				t.Errorf("target has %d events, want 2", n)
+			}
+		})
+	}
+}
+
+func TestWriteEventMtime(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithAggregator([]string{"6h"}),
+		WithEventMtime(true),
+	)
+	epoch := EpochFromTime(time.Now().Add(-10 * time.Minute))
+	if err := rf.BatchUpdate([]BatchItem{{Path: "a.txt", Type: "new", Epoch: epoch}}); err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+	for _, suffix := range []string{".yaml", ".json"} {
+		target := rf.SparseClone()
+		target.SetInterval("6h")
+		target.serializerSuffix = suffix
+		if err := target.MergeFrom(rf); err != nil {
+			t.Fatalf("MergeFrom failed: %v", err)
+		}
+
+		for _, path := range []string{rf.Rfile(), target.Rfile()} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.ModTime().Equal(EpochToTime(epoch)) {
+				t.Errorf("%s: mtime = %v, want %v", filepath.Base(path), fi.ModTime(), EpochToTime(epoch))
+			}
+		}
+
+		// The old mtime doesn't make the fresh file look old
+		if shouldMergeByAge(target, "1h") {
+			t.Errorf("%s: should not merge file written just now", suffix)
+		}
+	}
+}
+
+func TestAggregateDropDeletes(t *testing.T) {
+	for _, suffix := range []string{".yaml", ".json"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			rf := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithAggregator([]string{"6h", "Z"}),
+				WithSerializerSuffix(suffix),
+				WithDropDeletes([]string{"Z"}),
+			)
+			now := time.Now()
+			if err := rf.BatchUpdate([]BatchItem{
+				{Path: "a.txt", Type: "new", Epoch: EpochFromTime(now.Add(-3 * time.Minute))},
+				{Path: "b.txt", Type: "new", Epoch: EpochFromTime(now.Add(-2 * time.Minute))},
+			}); err != nil {
+				t.Fatalf("BatchUpdate failed: %v", err)
+			}
+			if err := rf.Aggregate(true); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			if err := rf.BatchUpdate([]BatchItem{
+				{Path: "a.txt", Type: "delete", Epoch: EpochFromTime(now.Add(-time.Minute))},
+			}); err != nil {
+				t.Fatalf("BatchUpdate failed: %v", err)
+			}
+			if err := rf.Aggregate(true); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+
+			events := func(interval string) map[string]string {
+				t.Helper()
+				read, err := NewFromFile(filepath.Join(tmpDir, "RECENT-"+interval+suffix))
+				if err != nil {
+					t.Fatal(err)
+				}
+				types := map[string]string{}
+				for _, event := range read.RecentEvents() {
+					types[event.Path] = event.Type
+				}
+				return types
+			}
+
+			// 6h gets the delete; Z forgets the path
+			if got := events("6h"); got["a.txt"] != "delete" || got["b.txt"] != "new" {
+				t.Errorf("6h events = %v", got)
+			}
+			if got := events("Z"); len(got) != 1 || got["b.txt"] != "new" {
+				t.Errorf("Z events = %v", got)
+			}
+		})
+	}
+}
+
+func TestMaxEvents(t *testing.T) {
+	for _, suffix := range []string{".yaml", ".json"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			rf := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithAggregator([]string{"6h", "1d"}),
+				WithSerializerSuffix(suffix),
+				WithMaxEvents(map[string]int{"1h": 3, "6h": 4}),
+			)
+			update := func(paths ...string) {
+				t.Helper()
+				var batch []BatchItem
+				for _, path := range paths {
+					batch = append(batch, BatchItem{Path: path, Type: "new"})
+				}
+				if err := rf.BatchUpdate(batch); err != nil {
+					t.Fatalf("BatchUpdate failed: %v", err)
+				}
+			}
+			count := func(interval string) int {
+				t.Helper()
+				read, err := NewFromFile(filepath.Join(tmpDir, "RECENT-"+interval+suffix))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return len(read.RecentEvents())
+			}
+
+			update("a")
+			if err := rf.Aggregate(false); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			if plan := rf.AggregatePlan(false); !slices.Equal(plan, []string{"6h"}) {
+				t.Fatalf("AggregatePlan = %v, want [6h]", plan)
+			}
+
+			// Events not merged yet are kept, those merged are not
+			update("b", "c", "d", "e", "f")
+			if !rf.Overflowing() {
+				t.Error("not overflowing with 5 events")
+			}
+			if got := count("1h"); got != 5 {
+				t.Errorf("1h has %d events before aggregating, want 5", got)
+			}
+
+			// 6h goes over its cap too, so it overflows into 1d, which
+			// isn't due yet
+			if err := rf.Aggregate(false); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			if rf.Overflowing() {
+				t.Error("overflowing after aggregating")
+			}
+			if got := count("1h"); got != 3 {
+				t.Errorf("1h has %d events after aggregating, want 3", got)
+			}
+			if got := count("6h"); got != 4 {
+				t.Errorf("6h has %d events, want 4", got)
+			}
+			if got := count("1d"); got != 6 {
+				t.Errorf("1d has %d events, want 6", got)
+			}
+
+			// Merged events make room for new ones
+			update("f", "g")
+			if got := count("1h"); got != 3 {
+				t.Errorf("1h has %d events after an update, want 3", got)
+			}
+			// but those not merged yet stay
+			update("h", "i", "j", "k")
+			if got := count("1h"); got != 6 {
+				t.Errorf("1h has %d events after a larger update, want 6", got)
+			}
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	tmpDir := t.TempDir()
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithAggregator([]string{"6h"}))
+	if err := rf.BatchUpdate([]BatchItem{{Path: "a", Type: "new"}, {Path: "b", Type: "new"}}); err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+	if err := rf.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	attrs := func(name string) map[string]any {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span in %v", name, spans)
+		}
+		m := make(map[string]any)
+		for _, kv := range span.Attributes() {
+			m[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		return m
+	}
+
+	if a := attrs("BatchUpdate"); a["events"] != int64(2) || a["interval"] != "1h" {
+		t.Errorf("BatchUpdate attributes = %v", a)
+	}
+	if a := attrs("MergeFrom"); a["events"] != int64(2) || a["interval"] != "6h" || a["source_interval"] != "1h" {
+		t.Errorf("MergeFrom attributes = %v", a)
+	}
+	attrs("Aggregate")
+	if spans["MergeFrom"].Parent().SpanID() != spans["Aggregate"].SpanContext().SpanID() {
+		t.Error("MergeFrom span is not a child of the Aggregate span")
+	}
+}
@@ -0,0 +1,149 @@
+package recentfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+)
+
+// ChecksumMaxSize is the size of the largest file whose SHA-256 is
+// computed for its event with WithProtocolExt. Larger files only get
+// their size recorded.
+const ChecksumMaxSize = 64 << 20
+
+// ErrChecksumMismatch is returned by Event.VerifyFile for a file that is
+// not the one the event describes.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// addFileInfo returns batch with what is known about the file of every
+// "new" item filled in where it is missing: its size and SHA-256, where
+// they are cheap to compute, and its mode and owner. Directories, and
+// files that can't be read, are left alone; their event is written
+// without.
+func (rf *Recentfile) addFileInfo(batch []BatchItem) []BatchItem {
+	root := rf.LocalRoot()
+	var out []BatchItem
+	for i, item := range batch {
+		if item.Type != "new" || item.Dir || (item.Sha256 != "" && item.Mode != 0) {
+			continue
+		}
+		path := item.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, filepath.FromSlash(path))
+		}
+		if out == nil {
+			out = make([]BatchItem, len(batch))
+			copy(out, batch)
+		}
+		if item.Sha256 == "" {
+			if size, sum, err := fileChecksum(path, ChecksumMaxSize); err == nil {
+				out[i].Size, out[i].Sha256 = size, sum
+			}
+		}
+		if item.Mode == 0 {
+			if mode, uid, gid, err := fileOwner(path); err == nil {
+				out[i].Mode, out[i].UID, out[i].GID = mode, uid, gid
+			}
+		}
+	}
+	if out == nil {
+		return batch
+	}
+	return out
+}
+
+// fileChecksum returns the size of the regular file at path and, if it is
+// no larger than maxSize, its SHA-256 in hex.
+func fileChecksum(path string, maxSize int64) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, "", fmt.Errorf("%s is not a regular file", path)
+	}
+	if fi.Size() > maxSize {
+		return fi.Size(), "", nil
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyFile checks that the file at path has the size and SHA-256 the
+// event records (see WithProtocolExt), returning an error wrapping
+// ErrChecksumMismatch if not. Events without them verify any file.
+func (e Event) VerifyFile(path string) error {
+	if e.Size == 0 && e.Sha256 == "" {
+		return nil
+	}
+	size, sum, err := fileChecksum(path, ChecksumMaxSize)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", e.Path, err)
+	}
+	if e.Sha256 == "" {
+		sum = ""
+	}
+	if size != e.Size || sum != e.Sha256 {
+		return fmt.Errorf("%s: %w", e.Path, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// FileMode returns the permission bits of the event's Mode, with the
+// setuid, setgid and sticky bits, as an os.FileMode for os.Chmod.
+func (e Event) FileMode() os.FileMode {
+	mode := os.FileMode(e.Mode & 0o777)
+	if e.Mode&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if e.Mode&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if e.Mode&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// dropProtocolExt forgets what events record about their files (see
+// WithProtocolExt).
+func dropProtocolExt(events []Event) {
+	for i := range events {
+		events[i].dropFileInfo()
+	}
+}
+
+// withoutProtocolExt forgets what events record about their files (see
+// WithProtocolExt).
+func withoutProtocolExt(events iter.Seq2[Event, error]) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for event, err := range events {
+			event.dropFileInfo()
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// dropFileInfo forgets what the event records about its file.
+func (e *Event) dropFileInfo() {
+	e.Size, e.Sha256 = 0, ""
+	e.Mode, e.UID, e.GID = 0, 0, 0
+}
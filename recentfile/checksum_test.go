@@ -0,0 +1,302 @@
+package recentfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// sha256 of "hello\n"
+const helloSha256 = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+func TestProtocolExt(t *testing.T) {
+	for _, suffix := range []string{".yaml", ".json"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(tmpDir, "hello.txt"), []byte("hello\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			rf := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithAggregator([]string{"1d"}),
+				WithSerializerSuffix(suffix),
+				WithProtocolExt(true),
+			)
+			err := rf.BatchUpdate([]BatchItem{
+				{Path: filepath.Join(tmpDir, "hello.txt"), Type: "new"},
+				{Path: "given.txt", Type: "new", Size: 3, Sha256: "abc"},
+				{Path: "missing.txt", Type: "new"},
+				{Path: "gone.txt", Type: "delete", Size: 3, Sha256: "abc"},
+			})
+			if err != nil {
+				t.Fatalf("BatchUpdate failed: %v", err)
+			}
+			if err := rf.Aggregate(true); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+
+			want := map[string]Event{
+				"hello.txt":   {Path: "hello.txt", Type: "new", Size: 6, Sha256: helloSha256},
+				"given.txt":   {Path: "given.txt", Type: "new", Size: 3, Sha256: "abc"},
+				"missing.txt": {Path: "missing.txt", Type: "new"},
+				"gone.txt":    {Path: "gone.txt", Type: "delete"},
+			}
+			check := func(name string, withExt bool) {
+				t.Helper()
+				rf2, err := NewFromFile(filepath.Join(tmpDir, name))
+				if err != nil {
+					t.Fatalf("NewFromFile(%s) failed: %v", name, err)
+				}
+				rf2.SetProtocolExt(true)
+				if err := rf2.Read(); err != nil {
+					t.Fatal(err)
+				}
+				if len(rf2.recent) != len(want) {
+					t.Fatalf("%s: %d events, want %d", name, len(rf2.recent), len(want))
+				}
+				for _, got := range rf2.recent {
+					w := want[got.Path]
+					if !withExt {
+						w.Size, w.Sha256 = 0, ""
+					}
+					if got.Type != w.Type || got.Size != w.Size || got.Sha256 != w.Sha256 {
+						t.Errorf("%s: event %+v, want %+v", name, got, w)
+					}
+				}
+			}
+			check("RECENT-1h"+suffix, true)
+			check("RECENT-1d"+suffix, true)
+
+			// Without the extension rewriting a file drops the fields
+			plain := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithAggregator([]string{"1d"}),
+				WithSerializerSuffix(suffix),
+			)
+			if err := plain.Read(); err != nil {
+				t.Fatal(err)
+			}
+			if err := plain.Write(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(plain.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), "sha256") || strings.Contains(string(data), "size") || strings.Contains(string(data), "mode") {
+				t.Errorf("fields written without the extension:\n%s", data)
+			}
+			check("RECENT-1h"+suffix, false)
+
+			if err := plain.Aggregate(true); err != nil {
+				t.Fatal(err)
+			}
+			check("RECENT-1d"+suffix, false)
+		})
+	}
+}
+
+func TestProtocolExtPerlYAML(t *testing.T) {
+	tmpDir := t.TempDir()
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithPerlYAML(true),
+		WithPreserveEpochs(true),
+		WithProtocolExt(true),
+	)
+	if err := rf.BatchUpdate([]BatchItem{{Path: "a.txt", Type: "new", Size: 6, Sha256: helloSha256}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "    sha256: "+helloSha256+"\n    size: 6\n") {
+		t.Errorf("unexpected YAML:\n%s", data)
+	}
+
+	// Epochs kept in their original text keep the fields too
+	if err := rf.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	data2, err := os.ReadFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data2) != string(data) {
+		t.Errorf("rewrite changed the file:\n%s\nwant:\n%s", data2, data)
+	}
+}
+
+func TestEventVerifyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(path, []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		event Event
+		want  error
+	}{
+		{Event{Path: "hello.txt"}, nil},
+		{Event{Path: "hello.txt", Size: 6, Sha256: helloSha256}, nil},
+		{Event{Path: "hello.txt", Size: 6}, nil},
+		{Event{Path: "hello.txt", Size: 7}, ErrChecksumMismatch},
+		{Event{Path: "hello.txt", Size: 6, Sha256: strings.Repeat("0", 64)}, ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		if err := tt.event.VerifyFile(path); !errors.Is(err, tt.want) {
+			t.Errorf("VerifyFile(%+v) = %v, want %v", tt.event, err, tt.want)
+		}
+	}
+
+	missing := Event{Path: "missing.txt", Size: 6, Sha256: helloSha256}
+	if err := missing.VerifyFile(path + ".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("VerifyFile of missing file = %v", err)
+	}
+}
+
+func TestProtocolExtOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file owners on windows")
+	}
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "script.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithProtocolExt(true))
+	if err := rf.BatchUpdate([]BatchItem{{Path: path, Type: "new"}}); err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+	rf2, err := NewFromFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf2.SetProtocolExt(true)
+	if err := rf2.Read(); err != nil {
+		t.Fatal(err)
+	}
+	event := rf2.recent[0]
+	if event.Mode != 0o100750 {
+		t.Errorf("Mode = %o, want 100750", event.Mode)
+	}
+	if event.UID != os.Getuid() || event.GID != os.Getgid() {
+		t.Errorf("owner = %d:%d, want %d:%d", event.UID, event.GID, os.Getuid(), os.Getgid())
+	}
+	if event.FileMode() != 0o750 {
+		t.Errorf("FileMode = %v", event.FileMode())
+	}
+}
+
+func TestEventFileMode(t *testing.T) {
+	tests := []struct {
+		mode uint32
+		want os.FileMode
+	}{
+		{0o100644, 0o644},
+		{0o104755, os.ModeSetuid | 0o755},
+		{0o102755, os.ModeSetgid | 0o755},
+		{0o041777, os.ModeSticky | 0o777},
+	}
+	for _, tt := range tests {
+		if got := (Event{Mode: tt.mode}).FileMode(); got != tt.want {
+			t.Errorf("FileMode(%o) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDirEvents(t *testing.T) {
+	for _, suffix := range []string{".yaml", ".json", ".sereal"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(tmpDir, "empty"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			rf := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithAggregator([]string{"1d"}),
+				WithSerializerSuffix(suffix),
+				WithProtocolExt(true),
+				WithPreserveEpochs(true),
+			)
+			err := rf.BatchUpdate([]BatchItem{
+				{Path: filepath.Join(tmpDir, "empty"), Type: "new", Dir: true},
+				{Path: "gone", Type: "delete", Dir: true},
+				{Path: "file.txt", Type: "new"},
+			})
+			if err != nil {
+				t.Fatalf("BatchUpdate failed: %v", err)
+			}
+			if err := rf.Aggregate(true); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			// Directories get no file info
+			for _, event := range rf.RecentEvents() {
+				if event.Path == "empty" && event.Mode != 0 {
+					t.Errorf("empty = %+v", event)
+				}
+			}
+
+			// The marker survives rewrites without the protocol extension
+			for _, name := range []string{"RECENT-1h" + suffix, "RECENT-1d" + suffix} {
+				plain, err := NewFromFile(filepath.Join(tmpDir, name))
+				if err != nil {
+					t.Fatalf("NewFromFile(%s) failed: %v", name, err)
+				}
+				plain.SetPreserveEpochs(true)
+				if err := plain.Read(); err != nil {
+					t.Fatal(err)
+				}
+				if err := plain.Write(); err != nil {
+					t.Fatal(err)
+				}
+				if err := plain.Read(); err != nil {
+					t.Fatal(err)
+				}
+				got := make(map[string]Event)
+				for _, event := range plain.RecentEvents() {
+					got[event.Path] = event
+				}
+				if e := got["empty"]; !e.Dir || e.Type != "new" {
+					t.Errorf("%s: empty = %+v", name, e)
+				}
+				if e := got["gone"]; !e.Dir || e.Type != "delete" {
+					t.Errorf("%s: gone = %+v", name, e)
+				}
+				if e := got["file.txt"]; e.Dir {
+					t.Errorf("%s: file.txt = %+v", name, e)
+				}
+			}
+		})
+	}
+}
+
+func TestPerlBool(t *testing.T) {
+	event := map[string]interface{}{"a": "1", "b": "0", "c": "", "d": int64(1), "e": float64(0), "f": true}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		perlBool(event, key)
+	}
+	want := map[string]interface{}{"a": true, "b": false, "c": false, "d": true, "e": false, "f": true}
+	for key, w := range want {
+		if event[key] != w {
+			t.Errorf("%s = %v, want %v", key, event[key], w)
+		}
+	}
+}
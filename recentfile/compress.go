@@ -0,0 +1,182 @@
+package recentfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression suffixes. They follow the serializer suffix and come before
+// the encryption marker, e.g. "RECENT-Z.json.gz" or "RECENT-Z.yaml.zst.enc",
+// so files are compressed before they are encrypted.
+const (
+	GzipSuffix = ".gz"
+	ZstdSuffix = ".zst"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressedSerializer wraps another serializer, compressing its output
+// with gzip or zstd. Unmarshal recognizes the compression from the data,
+// and passes data that is not compressed to the inner serializer as is.
+type CompressedSerializer struct {
+	Inner       Serializer
+	Compression string // GzipSuffix or ZstdSuffix
+}
+
+// Marshal serializes with the inner serializer, then compresses.
+func (s *CompressedSerializer) Marshal(rf *Recentfile) ([]byte, error) {
+	data, err := s.Inner.Marshal(rf)
+	if err != nil {
+		return nil, err
+	}
+	return compress(data, s.Compression)
+}
+
+// Unmarshal decompresses, then deserializes with the inner serializer.
+func (s *CompressedSerializer) Unmarshal(data []byte) (*SerializedData, error) {
+	plain, err := decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.Inner.Unmarshal(plain)
+}
+
+// MarshalTo compresses to w while the inner serializer writes, if it is a
+// StreamMarshaler, or after it has marshaled the collected events.
+func (s *CompressedSerializer) MarshalTo(w io.Writer, meta *MetaData, events iter.Seq2[Event, error]) error {
+	zw, err := compressWriter(w, s.Compression)
+	if err != nil {
+		return err
+	}
+
+	if sm, ok := s.Inner.(StreamMarshaler); ok {
+		err = sm.MarshalTo(zw, meta, events)
+	} else {
+		rf := &Recentfile{meta: *meta}
+		if rf.recent, err = collectEvents(events); err == nil {
+			var data []byte
+			if data, err = s.Inner.Marshal(rf); err == nil {
+				_, err = zw.Write(data)
+			}
+		}
+	}
+	if closeErr := zw.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("compress: %w", closeErr)
+	}
+	return err
+}
+
+// splitCompression splits the compression suffix off suffix, e.g.
+// ".json.gz" -> ".json", ".gz". The compression is empty for uncompressed
+// suffixes.
+func splitCompression(suffix string) (plain, compression string) {
+	for _, c := range []string{GzipSuffix, ZstdSuffix} {
+		if strings.HasSuffix(suffix, c) && len(suffix) > len(c) {
+			return strings.TrimSuffix(suffix, c), c
+		}
+	}
+	return suffix, ""
+}
+
+// isCompressionSuffix reports whether suffix is a compression suffix on
+// its own.
+func isCompressionSuffix(suffix string) bool {
+	return suffix == GzipSuffix || suffix == ZstdSuffix
+}
+
+// compressionOf returns the compression suffix matching the header of
+// data, or "" if data is not compressed.
+func compressionOf(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return GzipSuffix
+	case bytes.HasPrefix(data, zstdMagic):
+		return ZstdSuffix
+	}
+	return ""
+}
+
+// compress compresses data with the given compression.
+func compress(data []byte, compression string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := compressWriter(&buf, compression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// compressWriter returns a writer that compresses to w. It must be closed
+// to flush the compressed stream.
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case GzipSuffix:
+		return gzip.NewWriter(w), nil
+	case ZstdSuffix:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// decompress returns the decompressed content of data, or data itself if
+// it is not compressed.
+func decompress(data []byte) ([]byte, error) {
+	if compressionOf(data) == "" {
+		return data, nil
+	}
+	zr, err := decompressReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	return plain, nil
+}
+
+// decompressReader returns a reader for the decompressed content of r,
+// recognizing the compression from its header. Content that is not
+// compressed is read as is.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(zstdMagic))
+
+	switch compressionOf(header) {
+	case GzipSuffix:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return zr, nil
+	case ZstdSuffix:
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
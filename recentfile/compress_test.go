@@ -0,0 +1,117 @@
+package recentfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedWriteAndRead(t *testing.T) {
+	setTestKey(t)
+
+	tests := []struct {
+		suffix string
+		magic  []byte
+	}{
+		{".json.gz", gzipMagic},
+		{".yaml.zst", zstdMagic},
+		{".sereal.gz", gzipMagic},
+		{".json.zst.enc", encryptedMagic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			rf := New(
+				WithLocalRoot(tmpDir),
+				WithInterval("1h"),
+				WithSerializerSuffix(tt.suffix),
+			)
+			var events []Event
+			for i := 0; i < 1000; i++ {
+				events = append(events, Event{Epoch: Epoch(1704207845 - i), Path: "authors/id/A/AB/ABC/Foo-1.0.tar.gz", Type: "new"})
+			}
+			rf.SetRecentEvents(events)
+			if err := rf.Write(); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := rf.AssertSymlink(); err != nil {
+				t.Fatalf("AssertSymlink failed: %v", err)
+			}
+
+			if filepath.Base(rf.Rfile()) != "RECENT-1h"+tt.suffix {
+				t.Errorf("Rfile = %s", rf.Rfile())
+			}
+			data, err := os.ReadFile(rf.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, tt.magic) {
+				t.Errorf("file starts with %x", data[:4])
+			}
+			if len(data) > 10000 {
+				t.Errorf("file is %d bytes, not compressed", len(data))
+			}
+
+			// A RECENT.recent that is not a symlink is recognized by content
+			plain := filepath.Join(tmpDir, "plain.recent")
+			if err := os.WriteFile(plain, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if suffix, err := detectFormat(plain); err != nil || suffix != tt.suffix {
+				t.Errorf("detectFormat = %q, %v", suffix, err)
+			}
+
+			for _, path := range []string{rf.Rfile(), filepath.Join(tmpDir, "RECENT.recent"), plain} {
+				rf2, err := NewFromFile(path)
+				if err != nil {
+					t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+				}
+				if got := rf2.RecentEvents(); len(got) != len(events) || got[999] != events[999] {
+					t.Errorf("%s: %d events", path, len(got))
+				}
+
+				var streamed []Event
+				stats, err := StreamEvents(path, 100, func(events []Event) bool {
+					streamed = append(streamed, events...)
+					return true
+				})
+				if err != nil {
+					t.Fatalf("StreamEvents(%s) failed: %v", path, err)
+				}
+				if stats.Meta.SerializerSuffix != tt.suffix || len(streamed) != len(events) {
+					t.Errorf("%s: suffix %q, streamed %d", path, stats.Meta.SerializerSuffix, len(streamed))
+				}
+			}
+		})
+	}
+}
+
+func TestSplitCompression(t *testing.T) {
+	tests := []struct {
+		suffix, plain, compression string
+	}{
+		{".json.gz", ".json", GzipSuffix},
+		{".yaml.zst", ".yaml", ZstdSuffix},
+		{".json", ".json", ""},
+		{".gz", ".gz", ""},
+	}
+
+	for _, tt := range tests {
+		plain, compression := splitCompression(tt.suffix)
+		if plain != tt.plain || compression != tt.compression {
+			t.Errorf("splitCompression(%q) = %q, %q", tt.suffix, plain, compression)
+		}
+	}
+
+	for _, name := range []string{"RECENT-Z.json.gz", "RECENT-Z.yaml.zst.enc"} {
+		if _, interval, suffix, err := SplitRfilename(name); err != nil || interval != "Z" || name != "RECENT-Z"+suffix {
+			t.Errorf("SplitRfilename(%s) = %q, %q, %v", name, interval, suffix, err)
+		}
+	}
+	if _, _, _, err := SplitRfilename("RECENT-Z.json.bz2"); err == nil {
+		t.Error("SplitRfilename accepted an unknown compression")
+	}
+}
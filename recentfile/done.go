@@ -1,6 +1,7 @@
 package recentfile
 
 import (
+	"sort"
 	"sync"
 )
 
@@ -74,6 +75,41 @@ func (d *Done) Covered(epoch1 Epoch, epoch2 ...Epoch) bool {
 	return false
 }
 
+// Uncovered returns the gaps between the covered intervals within min and
+// max, as [high, low] epoch pairs sorted descending like Intervals. A gap
+// next to a covered interval ends at that interval's edge, so it shares the
+// edge epoch with it. With nothing covered the whole range is one gap.
+func (d *Done) Uncovered(min, max Epoch) [][2]Epoch {
+	if EpochLt(max, min) {
+		min, max = max, min
+	}
+
+	d.mu.RLock()
+	intervals := make([][2]Epoch, len(d.intervals))
+	copy(intervals, d.intervals)
+	d.mu.RUnlock()
+	sort.Slice(intervals, func(i, j int) bool {
+		return EpochGt(intervals[i][0], intervals[j][0])
+	})
+
+	var gaps [][2]Epoch
+	upper := max // the top of the range not yet accounted for
+	for _, iv := range intervals {
+		hi, lo := iv[0], iv[1]
+		if EpochLt(hi, min) {
+			break
+		}
+		if EpochGt(upper, hi) {
+			gaps = append(gaps, [2]Epoch{upper, hi})
+		}
+		upper = EpochMin(upper, lo)
+	}
+	if EpochGt(upper, min) {
+		gaps = append(gaps, [2]Epoch{upper, min})
+	}
+	return gaps
+}
+
 // Register marks epochs as processed.
 // events: the full list of events
 // indices: which event indices to register (nil means all)
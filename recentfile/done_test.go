@@ -0,0 +1,35 @@
+package recentfile
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUncovered(t *testing.T) {
+	d := &Done{intervals: [][2]Epoch{{90, 80}, {60, 50}, {20, 10}}}
+
+	tests := []struct {
+		name     string
+		min, max Epoch
+		want     [][2]Epoch
+	}{
+		{"whole range", 0, 100, [][2]Epoch{{100, 90}, {80, 60}, {50, 20}, {10, 0}}},
+		{"inside an interval", 52, 58, nil},
+		{"edges covered", 10, 90, [][2]Epoch{{80, 60}, {50, 20}}},
+		{"starts in a gap", 30, 85, [][2]Epoch{{80, 60}, {50, 30}}},
+		{"swapped bounds", 85, 30, [][2]Epoch{{80, 60}, {50, 30}}},
+		{"above everything", 95, 100, [][2]Epoch{{100, 95}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Uncovered(tt.min, tt.max); !slices.Equal(got, tt.want) {
+				t.Errorf("Uncovered(%v, %v) = %v, want %v", tt.min, tt.max, got, tt.want)
+			}
+		})
+	}
+
+	if got := (&Done{}).Uncovered(10, 20); !slices.Equal(got, [][2]Epoch{{20, 10}}) {
+		t.Errorf("Uncovered with nothing covered = %v", got)
+	}
+}
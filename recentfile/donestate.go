@@ -0,0 +1,100 @@
+package recentfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DoneState is what a mirroring client has processed of one remote
+// hierarchy: the covered intervals of the Done tracker of each remote
+// recentfile, and the dirtymark they were recorded under. Saved to a state
+// file, it lets the client resume where it left off after a restart.
+type DoneState struct {
+	// Principal identifies the remote hierarchy, e.g. the rsync URL of
+	// its principal recentfile
+	Principal string `json:"principal"`
+
+	Dirtymark Epoch `json:"dirtymark,omitempty"`
+
+	// Intervals holds the covered [high, low] epoch pairs by recentfile
+	// interval, as returned by Done.Intervals
+	Intervals map[string][][2]Epoch `json:"intervals"`
+}
+
+// NewDoneState returns an empty state for the remote hierarchy principal.
+func NewDoneState(principal string) *DoneState {
+	return &DoneState{
+		Principal: principal,
+		Intervals: make(map[string][][2]Epoch),
+	}
+}
+
+// DoneStatePath returns the name of the state file for the remote
+// hierarchy principal in dir. Each principal gets its own file.
+func DoneStatePath(dir, principal string) string {
+	sum := sha256.Sum256([]byte(principal))
+	return filepath.Join(dir, "done-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// LoadDoneState reads the state for principal from path. A missing file
+// gives an empty state; a file saved for another principal is an error.
+func LoadDoneState(path, principal string) (*DoneState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewDoneState(principal), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read done state: %w", err)
+	}
+
+	state := NewDoneState("")
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parse done state %s: %w", path, err)
+	}
+	if state.Principal != principal {
+		return nil, fmt.Errorf("done state %s is for %s, not %s", path, state.Principal, principal)
+	}
+	if state.Intervals == nil {
+		state.Intervals = make(map[string][][2]Epoch)
+	}
+	return state, nil
+}
+
+// Save writes the state to path, replacing it atomically.
+func (s *DoneState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal done state: %w", err)
+	}
+	return writeAtomic(path, writeOptions{}, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// Record stores the covered intervals of rf's Done tracker, along with
+// rf's dirtymark.
+func (s *DoneState) Record(rf *Recentfile) {
+	s.Dirtymark = rf.Meta().Dirtymark
+	s.Intervals[rf.Interval()] = rf.Done().Intervals()
+}
+
+// Restore replaces the covered intervals of rf's Done tracker with the
+// recorded ones. If rf's dirtymark is not the one they were recorded
+// under, the remote history has been rewritten: the tracker is left empty
+// and Restore returns false, so everything is synced again.
+func (s *DoneState) Restore(rf *Recentfile) bool {
+	done := rf.Done()
+	done.Reset()
+	if rf.Meta().Dirtymark != s.Dirtymark {
+		return false
+	}
+	done.Merge(&Done{intervals: s.Intervals[rf.Interval()]})
+	return true
+}
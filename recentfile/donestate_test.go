@@ -0,0 +1,85 @@
+package recentfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDoneState(t *testing.T) {
+	dir := t.TempDir()
+	principal := "rsync://cpan.example.org/CPAN/RECENT-1h.yaml"
+	path := DoneStatePath(dir, principal)
+
+	if other := DoneStatePath(dir, "rsync://other.example.org/CPAN/RECENT-1h.yaml"); other == path {
+		t.Errorf("two principals share the state file %s", path)
+	}
+
+	state, err := LoadDoneState(path, principal)
+	if err != nil {
+		t.Fatalf("LoadDoneState (missing) failed: %v", err)
+	}
+	if len(state.Intervals) != 0 {
+		t.Errorf("missing state file gave intervals %v", state.Intervals)
+	}
+
+	rf := New(WithLocalRoot(dir), WithInterval("1h"))
+	rf.SetDirtymark(1704200000)
+	events := []Event{
+		{Epoch: 1704207845, Path: "a", Type: "new"},
+		{Epoch: 1704207840, Path: "b", Type: "new"},
+		{Epoch: 1704207835, Path: "c", Type: "new"},
+		{Epoch: 1704207830, Path: "d", Type: "new"},
+	}
+	rf.Done().Register(events, []int{0, 1, 3})
+
+	state.Record(rf)
+	if err := state.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadDoneState(path, principal)
+	if err != nil {
+		t.Fatalf("LoadDoneState failed: %v", err)
+	}
+
+	// A fresh tracker for the same remote recentfile picks up the intervals
+	rf2 := New(WithLocalRoot(t.TempDir()), WithInterval("1h"))
+	rf2.SetDirtymark(1704200000)
+	if !loaded.Restore(rf2) {
+		t.Fatal("Restore failed with the same dirtymark")
+	}
+	for i, event := range events {
+		if got, want := rf2.Done().Covered(event.Epoch), i != 2; got != want {
+			t.Errorf("Covered(%v) = %v, want %v", event.Epoch, got, want)
+		}
+	}
+
+	// Other intervals have nothing covered yet
+	rf6h := rf2.SparseClone()
+	rf6h.SetInterval("6h")
+	rf6h.SetDirtymark(1704200000)
+	if !loaded.Restore(rf6h) || len(rf6h.Done().Intervals()) != 0 {
+		t.Errorf("6h intervals = %v", rf6h.Done().Intervals())
+	}
+
+	// A new dirtymark invalidates what was done
+	rf2.SetDirtymark(1704300000)
+	if loaded.Restore(rf2) {
+		t.Error("Restore succeeded with a new dirtymark")
+	}
+	if got := rf2.Done().Intervals(); len(got) != 0 {
+		t.Errorf("intervals after dirtymark change = %v", got)
+	}
+
+	if _, err := LoadDoneState(path, "rsync://other.example.org/CPAN/RECENT-1h.yaml"); err == nil {
+		t.Error("LoadDoneState accepted a state file of another principal")
+	}
+	garbage := filepath.Join(dir, "garbage.json")
+	if err := os.WriteFile(garbage, []byte("---\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDoneState(garbage, principal); err == nil {
+		t.Error("LoadDoneState accepted a file that is not JSON")
+	}
+}
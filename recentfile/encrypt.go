@@ -0,0 +1,177 @@
+package recentfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// EncryptedSuffix marks an encrypted RECENT file. It is appended to the
+// serializer suffix, e.g. "RECENT-1h.json.enc".
+const EncryptedSuffix = ".enc"
+
+// KeyFileEnv names the environment variable read for the key file when no
+// key has been set explicitly.
+const KeyFileEnv = "RRR_KEYFILE"
+
+// encryptedMagic starts every encrypted file so it can be recognized
+// without relying on the file name.
+var encryptedMagic = []byte("RRRENC1\n")
+
+// ErrNoKey is returned when an encrypted file is read or written and no
+// key is configured.
+var ErrNoKey = errors.New("no encryption key configured (set " + KeyFileEnv + ")")
+
+var (
+	keyMu     sync.Mutex
+	keyAEAD   cipher.AEAD
+	keyLoaded bool
+)
+
+// SetKey sets the process-wide key used for encrypted RECENT files. The key
+// must be 32 bytes (AES-256). A nil key clears it.
+func SetKey(key []byte) error {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	if key == nil {
+		keyAEAD = nil
+		keyLoaded = true
+		return nil
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	keyAEAD = aead
+	keyLoaded = true
+	return nil
+}
+
+// LoadKeyFile reads a key from path and sets it with SetKey. The file holds
+// either 32 raw bytes or 64 hex characters.
+func LoadKeyFile(path string) error {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	return SetKey(key)
+}
+
+// readKeyFile reads and decodes a key file.
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("key file %s: need 32 raw bytes or 64 hex characters", path)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// currentKey returns the configured key, loading it from $RRR_KEYFILE on
+// first use.
+func currentKey() (cipher.AEAD, error) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	if !keyLoaded {
+		keyLoaded = true
+		if path := os.Getenv(KeyFileEnv); path != "" {
+			key, err := readKeyFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if keyAEAD, err = newAEAD(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if keyAEAD == nil {
+		return nil, ErrNoKey
+	}
+	return keyAEAD, nil
+}
+
+// IsEncryptedSuffix reports whether suffix denotes an encrypted file.
+func IsEncryptedSuffix(suffix string) bool {
+	return strings.HasSuffix(suffix, EncryptedSuffix)
+}
+
+// plainSuffix strips the encryption marker, e.g. ".json.enc" -> ".json".
+func plainSuffix(suffix string) string {
+	return strings.TrimSuffix(suffix, EncryptedSuffix)
+}
+
+// encrypt seals data as magic || nonce || ciphertext.
+func encrypt(data []byte) ([]byte, error) {
+	aead, err := currentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(encryptedMagic)+aead.NonceSize(), len(encryptedMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, encryptedMagic)
+	nonce := out[len(encryptedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	return aead.Seal(out, nonce, data, encryptedMagic), nil
+}
+
+// decrypt opens data produced by encrypt.
+func decrypt(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return nil, fmt.Errorf("not an encrypted recentfile")
+	}
+
+	aead, err := currentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted recentfile truncated")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, ciphertext, encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plain, nil
+}
+
+// isEncrypted reports whether data starts with the encrypted file header.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
@@ -0,0 +1,106 @@
+package recentfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setTestKey(t *testing.T) {
+	t.Helper()
+	if err := SetKey(bytes.Repeat([]byte{0x42}, 32)); err != nil {
+		t.Fatalf("SetKey failed: %v", err)
+	}
+	t.Cleanup(func() { SetKey(nil) })
+}
+
+func TestEncryptedWriteAndRead(t *testing.T) {
+	setTestKey(t)
+	tmpDir := t.TempDir()
+
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithSerializerSuffix(".json.enc"),
+	)
+	rf.SetRecentEvents([]Event{
+		{Epoch: 1234567890.123456, Path: "secret/file.txt", Type: "new"},
+	})
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := rf.AssertSymlink(); err != nil {
+		t.Fatalf("AssertSymlink failed: %v", err)
+	}
+
+	if filepath.Base(rf.Rfile()) != "RECENT-1h.json.enc" {
+		t.Errorf("Rfile = %s", rf.Rfile())
+	}
+	data, err := os.ReadFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret/file.txt")) {
+		t.Error("path stored in plaintext")
+	}
+
+	for _, path := range []string{rf.Rfile(), filepath.Join(tmpDir, "RECENT.recent")} {
+		rf2, err := NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+		}
+		events := rf2.RecentEvents()
+		if len(events) != 1 || events[0].Path != "secret/file.txt" {
+			t.Errorf("%s: events = %+v", path, events)
+		}
+
+		var streamed []Event
+		stats, err := StreamEvents(path, 10, func(events []Event) bool {
+			streamed = append(streamed, events...)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("StreamEvents(%s) failed: %v", path, err)
+		}
+		if stats.Meta.SerializerSuffix != ".json.enc" || len(streamed) != 1 {
+			t.Errorf("%s: stats = %+v, streamed %d", path, stats, len(streamed))
+		}
+	}
+
+	// A different key cannot read the file
+	if err := SetKey(bytes.Repeat([]byte{0x43}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFromFile(rf.Rfile()); err == nil {
+		t.Error("Expected error reading with the wrong key")
+	}
+
+	SetKey(nil)
+	if _, err := NewFromFile(rf.Rfile()); err == nil {
+		t.Error("Expected error reading without a key")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	t.Cleanup(func() { SetKey(nil) })
+	tmpDir := t.TempDir()
+	key := bytes.Repeat([]byte{0x01}, 32)
+
+	raw := filepath.Join(tmpDir, "raw.key")
+	os.WriteFile(raw, key, 0o600)
+	hexKey := filepath.Join(tmpDir, "hex.key")
+	os.WriteFile(hexKey, []byte(hex.EncodeToString(key)+"\n"), 0o600)
+	short := filepath.Join(tmpDir, "short.key")
+	os.WriteFile(short, []byte("abcd"), 0o600)
+
+	for _, path := range []string{raw, hexKey} {
+		if err := LoadKeyFile(path); err != nil {
+			t.Errorf("LoadKeyFile(%s) failed: %v", filepath.Base(path), err)
+		}
+	}
+	if err := LoadKeyFile(short); err == nil {
+		t.Error("Expected error for short key")
+	}
+}
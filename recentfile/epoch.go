@@ -3,8 +3,11 @@ package recentfile
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Epoch represents a timestamp as a float64.
@@ -32,6 +35,11 @@ func EpochFromTime(t time.Time) Epoch {
 	return Epoch(float64(tenMicroUnits) / 1e5)
 }
 
+// EpochToTime converts an Epoch to a time.Time with microsecond precision.
+func EpochToTime(e Epoch) time.Time {
+	return time.UnixMicro(int64(math.Round(float64(e) * 1e6)))
+}
+
 // EpochFromFloat converts a float64 to an Epoch.
 func EpochFromFloat(f float64) Epoch {
 	return Epoch(f)
@@ -118,6 +126,25 @@ func EpochBetween(l, r Epoch) Epoch {
 	return EpochIncreaseABit(r)
 }
 
+// ParseSince accepts an absolute epoch, a Go duration or a RECENT interval
+// (e.g. "1712345678.5", "90m", "1d") and returns the epoch that changes
+// must be newer than.
+func ParseSince(s string, now time.Time) (Epoch, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return EpochFromFloat(f), nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return EpochFromTime(now.Add(-d)), nil
+	}
+
+	if secs := IntervalSecsFor(s); secs > 0 && s != "Z" {
+		return EpochFromTime(now.Add(-time.Duration(secs) * time.Second)), nil
+	}
+
+	return 0, fmt.Errorf("invalid time %q: want an epoch, duration or interval", s)
+}
+
 // IsZero returns true if the epoch is zero or empty.
 func (e Epoch) IsZero() bool {
 	return e == 0.0
@@ -158,3 +185,47 @@ func (e *Epoch) UnmarshalJSON(data []byte) error {
 
 	return fmt.Errorf("epoch must be a number or string, got: %s", string(data))
 }
+
+// UnmarshalYAML implements yaml.Unmarshaler for Epoch.
+// Like UnmarshalJSON, it handles both numbers and strings (from Perl).
+func (e *Epoch) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: epoch is not a number", node.Line)
+	}
+	if node.ShortTag() == "!!null" {
+		*e = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(node.Value, 64)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid epoch string %q: %w", node.Line, node.Value, err)
+	}
+	*e = Epoch(f)
+	return nil
+}
+
+// epochText returns the decimal text s, which was read from a file and
+// parses to e, if writing e would not reproduce it: more digits than a
+// float64 holds, as some Perl mirrors write, or unusual formatting such as
+// trailing zeros. It returns "" for text that is written back the same
+// way, and for text that is not a plain decimal number.
+func epochText(s string, e Epoch) string {
+	f := float64(e)
+	if s == strconv.FormatFloat(f, 'f', -1, 64) || s == strconv.FormatFloat(f, 'g', -1, 64) {
+		return ""
+	}
+	if !json.Valid([]byte(s)) || s[0] == '"' {
+		return ""
+	}
+	return s
+}
+
+// parseEpochText parses the text of an epoch, returning the text to keep
+// as for epochText.
+func parseEpochText(s string) (Epoch, string, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid epoch string %q: %w", s, err)
+	}
+	return Epoch(f), epochText(s, Epoch(f)), nil
+}
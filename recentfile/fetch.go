@@ -0,0 +1,199 @@
+package recentfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFetched is returned when writing or locking a recentfile read with a
+// Fetcher: it is only a copy of the published file.
+var ErrFetched = errors.New("recentfile was fetched and is read-only")
+
+// Fetcher reads RECENT files from where a hierarchy is published, for
+// recentfiles read from somewhere else than the local disk (see
+// NewFromFetcher).
+type Fetcher interface {
+	// Fetch returns the contents of the RECENT file name, a slash-separated
+	// path relative to the root of the hierarchy. A missing file returns
+	// an error wrapping fs.ErrNotExist.
+	Fetch(ctx context.Context, name string) ([]byte, error)
+}
+
+// DirFetcher is a Fetcher reading RECENT files from a local directory.
+type DirFetcher string
+
+// Fetch implements Fetcher.
+func (d DirFetcher) Fetch(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// HTTPFetcher is a Fetcher reading RECENT files from a web server. It
+// keeps the last copy of every file fetched with its ETag and
+// Last-Modified time, and asks the server to send the file only if it
+// changed since; the copy is returned again if it did not.
+type HTTPFetcher struct {
+	base   *url.URL
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*httpCopy
+}
+
+// httpCopy is the last copy of a file fetched by an HTTPFetcher.
+type httpCopy struct {
+	etag         string
+	lastModified string
+	data         []byte
+}
+
+// NewHTTPFetcher creates a Fetcher for the hierarchy published at baseURL.
+// A nil client uses one with a one minute timeout.
+func NewHTTPFetcher(baseURL string, client *http.Client) (*HTTPFetcher, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	return &HTTPFetcher{base: u, client: client, cache: make(map[string]*httpCopy)}, nil
+}
+
+// Fetch implements Fetcher.
+func (h *HTTPFetcher) Fetch(ctx context.Context, name string) ([]byte, error) {
+	u := h.base.JoinPath(strings.Split(name, "/")...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	cached := h.cache[name]
+	h.mu.Unlock()
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached.data, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("get %s: %w", u, fs.ErrNotExist)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("get %s: %s", u, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", u, err)
+	}
+
+	fetched := &httpCopy{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		data:         data,
+	}
+	h.mu.Lock()
+	if fetched.etag != "" || fetched.lastModified != "" {
+		h.cache[name] = fetched
+	} else {
+		delete(h.cache, name)
+	}
+	h.mu.Unlock()
+
+	return data, nil
+}
+
+// NewFromFetcher reads the recentfile name with f, like NewFromFile reads
+// one from disk. name is either a RECENT file name like "RECENT-1h.json"
+// or the principal's symlink, "RECENT.recent", whose format is detected
+// from its contents. Read fetches the file again.
+//
+// The recentfile has no local root, and cannot be written or locked.
+func NewFromFetcher(ctx context.Context, f Fetcher, name string) (*Recentfile, error) {
+	data, err := f.Fetch(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", name, err)
+	}
+	if err := verifySignature(ctx, f, name, data); err != nil {
+		return nil, err
+	}
+
+	var suffix string
+	if path.Ext(name) == ".recent" {
+		if suffix, err = detectDataFormat(data); err != nil {
+			return nil, fmt.Errorf("detect format for %s: %w", name, err)
+		}
+	} else if _, _, suffix, err = SplitRfilename(path.Base(name)); err != nil {
+		return nil, fmt.Errorf("parse filename %s: %w", name, err)
+	}
+
+	sd, err := Unmarshal(data, suffix)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", name, err)
+	}
+
+	rf := &Recentfile{
+		rfile:   name,
+		fetcher: f,
+	}
+	rf.setMeta(sd.Meta)
+	rf.recent = sd.Recent
+	dropEpochText(rf.recent)
+
+	// Initialize done tracker
+	rf.done = &Done{
+		rfInterval: rf.interval,
+	}
+
+	return rf, nil
+}
+
+// NewFromURL reads the recentfile at rawURL over HTTP, e.g.
+// "https://mirror.example.org/pub/RECENT-1h.json", with an HTTPFetcher
+// for the directory it is in (see NewFromFetcher).
+func NewFromURL(ctx context.Context, rawURL string) (*Recentfile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	dir, name := path.Split(u.Path)
+	if name == "" {
+		return nil, fmt.Errorf("url %s does not name a RECENT file", rawURL)
+	}
+	u.Path = dir
+	u.RawPath = ""
+
+	f, err := NewHTTPFetcher(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromFetcher(ctx, f, name)
+}
@@ -0,0 +1,198 @@
+package recentfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingHandler counts the requests and Not Modified responses of h.
+type countingHandler struct {
+	h http.Handler
+
+	mu          sync.Mutex
+	requests    int
+	notModified int
+}
+
+func (c *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := httptest.NewRecorder()
+	c.h.ServeHTTP(rec, r)
+
+	c.mu.Lock()
+	c.requests++
+	if rec.Code == http.StatusNotModified {
+		c.notModified++
+	}
+	c.mu.Unlock()
+
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+func (c *countingHandler) counts() (requests, notModified int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests, c.notModified
+}
+
+func writeTestRecentfile(t *testing.T, dir, suffix string, events []Event) *Recentfile {
+	t.Helper()
+	rf := New(
+		WithLocalRoot(dir),
+		WithInterval("1h"),
+		WithSerializerSuffix(suffix),
+	)
+	rf.SetRecentEvents(events)
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return rf
+}
+
+func TestNewFromURL(t *testing.T) {
+	tmpDir := t.TempDir()
+	rf := writeTestRecentfile(t, tmpDir, ".json", []Event{
+		{Epoch: 1704207845, Path: "a.txt", Type: "new"},
+	})
+
+	// http.FileServer answers If-Modified-Since
+	handler := &countingHandler{h: http.StripPrefix("/pub/", http.FileServer(http.Dir(tmpDir)))}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	ctx := context.Background()
+	remote, err := NewFromURL(ctx, srv.URL+"/pub/RECENT-1h.json")
+	if err != nil {
+		t.Fatalf("NewFromURL failed: %v", err)
+	}
+	if remote.Interval() != "1h" || remote.Meta().SerializerSuffix != ".json" {
+		t.Errorf("interval %q, suffix %q", remote.Interval(), remote.Meta().SerializerSuffix)
+	}
+	if events := remote.RecentEvents(); len(events) != 1 || events[0].Path != "a.txt" {
+		t.Fatalf("events = %+v", events)
+	}
+
+	// Unchanged: the server only answers Not Modified
+	if err := remote.Read(); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if requests, notModified := handler.counts(); requests != 2 || notModified != 1 {
+		t.Errorf("requests = %d, not modified = %d, want 2 and 1", requests, notModified)
+	}
+	if events := remote.RecentEvents(); len(events) != 1 {
+		t.Fatalf("events after Read = %+v", events)
+	}
+
+	// Changed, a second later than Last-Modified can tell
+	rf.SetRecentEvents([]Event{
+		{Epoch: 1704207850, Path: "b.txt", Type: "new"},
+		{Epoch: 1704207845, Path: "a.txt", Type: "new"},
+	})
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	later := time.Now().Add(2 * time.Second)
+	os.Chtimes(rf.Rfile(), later, later)
+
+	if err := remote.ReadContext(ctx); err != nil {
+		t.Fatalf("ReadContext failed: %v", err)
+	}
+	if events := remote.RecentEvents(); len(events) != 2 || events[0].Path != "b.txt" {
+		t.Errorf("events after change = %+v", events)
+	}
+
+	if err := remote.Write(); !errors.Is(err, ErrFetched) {
+		t.Errorf("Write = %v, want ErrFetched", err)
+	}
+	if err := remote.Lock(); !errors.Is(err, ErrFetched) {
+		t.Errorf("Lock = %v, want ErrFetched", err)
+	}
+
+	if _, err := NewFromURL(ctx, srv.URL+"/pub/RECENT-6h.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: err = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := NewFromURL(ctx, "ftp://example.org/RECENT-1h.json"); err == nil {
+		t.Error("expected error for ftp url")
+	}
+}
+
+func TestHTTPFetcherETag(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTestRecentfile(t, tmpDir, ".json.gz", []Event{
+		{Epoch: 1704207845, Path: "a.txt", Type: "new"},
+	})
+	if err := os.Symlink("RECENT-1h.json.gz", filepath.Join(tmpDir, "RECENT.recent")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve with an ETag only, no Last-Modified
+	handler := &countingHandler{h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile(filepath.Join(tmpDir, strings.TrimPrefix(r.URL.Path, "/")))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		sum := sha256.Sum256(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(data)))
+	})}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	f, err := NewHTTPFetcher(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPFetcher failed: %v", err)
+	}
+
+	// The format of RECENT.recent is detected from its contents
+	ctx := context.Background()
+	remote, err := NewFromFetcher(ctx, f, "RECENT.recent")
+	if err != nil {
+		t.Fatalf("NewFromFetcher failed: %v", err)
+	}
+	if remote.Meta().SerializerSuffix != ".json.gz" {
+		t.Errorf("suffix = %q", remote.Meta().SerializerSuffix)
+	}
+	if err := remote.Read(); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if requests, notModified := handler.counts(); requests != 2 || notModified != 1 {
+		t.Errorf("requests = %d, not modified = %d, want 2 and 1", requests, notModified)
+	}
+	if events := remote.RecentEvents(); len(events) != 1 || events[0].Path != "a.txt" {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestDirFetcher(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTestRecentfile(t, tmpDir, ".yaml", []Event{
+		{Epoch: 1704207845, Path: "a.txt", Type: "new"},
+	})
+
+	rf, err := NewFromFetcher(context.Background(), DirFetcher(tmpDir), "RECENT-1h.yaml")
+	if err != nil {
+		t.Fatalf("NewFromFetcher failed: %v", err)
+	}
+	if events := rf.RecentEvents(); len(events) != 1 || events[0].Path != "a.txt" {
+		t.Errorf("events = %+v", events)
+	}
+
+	if _, err := NewFromFetcher(context.Background(), DirFetcher(tmpDir), "RECENT-6h.yaml"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: err = %v, want fs.ErrNotExist", err)
+	}
+}
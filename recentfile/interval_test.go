@@ -0,0 +1,79 @@
+package recentfile
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     int64
+		err      string
+	}{
+		{"1h", HourSeconds, ""},
+		{"h", HourSeconds, ""},
+		{"90m", 90 * MinuteSeconds, ""},
+		{"36h", 36 * HourSeconds, ""},
+		{"2W", 2 * WeekSeconds, ""},
+		{"1h30m", 90 * MinuteSeconds, ""},
+		{"1d12h", 36 * HourSeconds, ""},
+		{"1Y", YearSeconds, ""},
+		{"Z", ZSeconds, ""},
+		{"", 0, "want a count and a unit"},
+		{"1x", 0, "want a count and a unit"},
+		{"Z1h", 0, "want a count and a unit"},
+		{"1.5h", 0, "fractions are not supported"},
+		{"0h", 0, "longer than 0s"},
+		{"99999999999999999999s", 0, "out of range"},
+		{"9999999999999Y", 0, "too long"},
+	}
+	for _, tt := range tests {
+		got, err := ParseInterval(tt.interval)
+		if tt.err != "" {
+			if !errors.Is(err, ErrIntervalUnknown) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseInterval(%q) error = %v, want %q", tt.interval, err, tt.err)
+			}
+			if IntervalSecsFor(tt.interval) != 0 {
+				t.Errorf("IntervalSecsFor(%q) = %d, want 0", tt.interval, IntervalSecsFor(tt.interval))
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseInterval(%q) = %d, %v, want %d", tt.interval, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckAggregator(t *testing.T) {
+	tests := []struct {
+		interval   string
+		aggregator []string
+		err        string
+	}{
+		{"1h", nil, ""},
+		{"1h", []string{"6h", "1d", "1W", "Z"}, ""},
+		{"1h", []string{"1h", "6h", "1d"}, ""},
+		{"30m", []string{"90m", "1d12h", "Z"}, ""},
+		{"1h", []string{"1d", "6h"}, "6h is not longer than 1d"},
+		{"1h", []string{"6h", "360m"}, "360m is not longer than 6h"},
+		{"1h", []string{"30m"}, "30m is not longer than 1h"},
+		{"1h", []string{"6h", "1h"}, "1h is not longer than 6h"},
+		{"1h", []string{"Z", "1Y"}, "1Y is not longer than Z"},
+		{"1h", []string{"6h", "1.5d"}, "fractions"},
+		{"1x", []string{"6h"}, "want a count"},
+	}
+	for _, tt := range tests {
+		err := CheckAggregator(tt.interval, tt.aggregator)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("CheckAggregator(%s, %v) = %v", tt.interval, tt.aggregator, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("CheckAggregator(%s, %v) = %v, want %q", tt.interval, tt.aggregator, err, tt.err)
+		}
+	}
+}
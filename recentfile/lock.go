@@ -1,24 +1,77 @@
 package recentfile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Lock backends (see WithLockBackend).
+const (
+	LockBackendMkdir = "mkdir"
+	LockBackendFlock = "flock"
+)
+
+// lockHostFile is the file in a lock directory recording the host and the
+// start time of the process holding the lock, next to the "process" file
+// with its PID. Locks without it, such as those of the Perl
+// implementation, are taken to be held on this host.
+const lockHostFile = "host"
+
+var (
+	// processStart is recorded in the locks this process holds, so on
+	// Linux a process that reuses its PID after it died isn't taken for
+	// the holder (see checkStaleLock)
+	processStart = time.Now()
+
+	hostname = sync.OnceValue(func() string {
+		name, _ := os.Hostname()
+		return name
+	})
+)
+
+// ErrLockTimeout is returned by Lock when the lock is still held by
+// another process after ten minutes.
+var ErrLockTimeout = errors.New("lock timeout")
+
+// ErrNotLocked is returned by Unlock for a recentfile that isn't locked.
+var ErrNotLocked = errors.New("not locked")
+
+// LockWait describes an attempt to take the lock of a recentfile (see
+// WithLockObserver).
+type LockWait struct {
+	Interval string        // of the recentfile
+	Retries  int           // attempts that found the lock held
+	Wait     time.Duration // from the first attempt until the lock was taken or given up
+	Err      error         // why the lock was not taken, nil if it was
+}
+
 // Lock acquires an exclusive lock on the recentfile.
 // Uses directory-based locking (mkdir is atomic on POSIX systems).
 func (rf *Recentfile) Lock() error {
-	rf.mu.Lock()
-	if rf.locked {
-		rf.mu.Unlock()
-		return fmt.Errorf("already locked")
+	return rf.LockContext(context.Background())
+}
+
+// LockContext is like Lock, but stops waiting for the lock when ctx is
+// done. The lock is always tried once, so a free lock is taken even with
+// a cancelled ctx.
+func (rf *Recentfile) LockContext(ctx context.Context) (err error) {
+	tryLock, err := rf.lockFunc()
+	if err != nil {
+		return err
 	}
+	rf.mu.Lock()
+	observe := rf.lockObserver
+	interval := rf.interval
 	rf.mu.Unlock()
 
-	lockDir := rf.Rfile() + ".lock"
+	lockPath := rf.Rfile() + ".lock"
 	timeout := rf.lockTimeout
 	if timeout == 0 {
 		timeout = 600 * time.Second // Default 10 minutes
@@ -26,15 +79,100 @@ func (rf *Recentfile) Lock() error {
 
 	start := time.Now()
 	sleepDuration := 10 * time.Millisecond
+	retries := 0
+	if observe != nil {
+		defer func() {
+			observe(LockWait{Interval: interval, Retries: retries, Wait: time.Since(start), Err: err})
+		}()
+	}
+
+	for {
+		if locked, err := tryLock(lockPath); err != nil {
+			return err
+		} else if locked {
+			return nil
+		}
+		retries++
+
+		// Check timeout
+		if time.Since(start) > timeout {
+			return fmt.Errorf("%w after %v (held by %s)", ErrLockTimeout, timeout, lockHolder(lockPath))
+		}
+
+		// Wait and retry
+		timer := time.NewTimer(sleepDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("wait for lock: %w", ctx.Err())
+		case <-timer.C:
+		}
+
+		// Exponential backoff up to 1 second
+		sleepDuration *= 2
+		if sleepDuration > time.Second {
+			sleepDuration = time.Second
+		}
+	}
+}
+
+// TryLock takes the lock on the recentfile like Lock if it is free,
+// without waiting, and reports whether it did. A stale lock is broken as
+// by Lock.
+func (rf *Recentfile) TryLock() (bool, error) {
+	tryLock, err := rf.lockFunc()
+	if err != nil {
+		return false, err
+	}
+	return tryLock(rf.Rfile() + ".lock")
+}
+
+// lockFunc returns the function that tries once to take the lock of the
+// recentfile with its lock backend.
+func (rf *Recentfile) lockFunc() (func(string) (bool, error), error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.fetcher != nil {
+		return nil, fmt.Errorf("lock %s: %w", rf.rfile, ErrFetched)
+	}
+	if rf.locked {
+		return nil, fmt.Errorf("already locked")
+	}
+
+	switch rf.lockBackend {
+	case "", LockBackendMkdir:
+		return rf.tryLockDir, nil
+	case LockBackendFlock:
+		return rf.tryFlock, nil
+	default:
+		return nil, fmt.Errorf("unknown lock backend %q", rf.lockBackend)
+	}
+}
+
+// tryLockDir tries to take the lock by creating the lock directory,
+// breaking it if it is stale. It reports whether the lock was taken.
+func (rf *Recentfile) tryLockDir(lockDir string) (bool, error) {
+	rf.mu.RLock()
+	perm := rf.perm
+	rf.mu.RUnlock()
 
 	for {
 		// Try to create lock directory
 		err := os.Mkdir(lockDir, 0o755)
 		if err == nil {
-			// Success! We got the lock
-			if err := rf.writeLockPID(lockDir); err != nil {
-				os.Remove(lockDir)
-				return fmt.Errorf("write lock PID: %w", err)
+			// Success! We got the lock. The host goes first, so no other
+			// host sees the PID without it.
+			if err := perm.path(lockDir, perm.DirMode); err != nil {
+				os.RemoveAll(lockDir)
+				return false, fmt.Errorf("set lock permissions: %w", err)
+			}
+			if err := writeLockHost(lockDir, perm); err != nil {
+				os.RemoveAll(lockDir)
+				return false, fmt.Errorf("write lock host: %w", err)
+			}
+			if err := rf.writeLockPID(lockDir, perm); err != nil {
+				os.RemoveAll(lockDir)
+				return false, fmt.Errorf("write lock PID: %w", err)
 			}
 
 			rf.mu.Lock()
@@ -42,38 +180,81 @@ func (rf *Recentfile) Lock() error {
 			rf.lockDir = lockDir
 			rf.mu.Unlock()
 
-			return nil
+			return true, nil
 		}
 
 		// Lock directory already exists
 		if !os.IsExist(err) {
-			return fmt.Errorf("mkdir %s: %w", lockDir, err)
+			return false, fmt.Errorf("mkdir %s: %w", lockDir, err)
 		}
 
 		// Check if lock is stale
 		if stale, err := rf.checkStaleLock(lockDir); err != nil {
-			return fmt.Errorf("check stale lock: %w", err)
-		} else if stale {
-			// Remove stale lock and try again
-			if err := os.RemoveAll(lockDir); err != nil {
-				return fmt.Errorf("remove stale lock: %w", err)
+			return false, fmt.Errorf("check stale lock: %w", err)
+		} else if !stale {
+			return false, nil
+		}
+
+		// Remove stale lock and try again
+		if err := os.RemoveAll(lockDir); err != nil {
+			return false, fmt.Errorf("remove stale lock: %w", err)
+		}
+	}
+}
+
+// tryFlock tries to take the lock with flock(2) on the lock file, which
+// the kernel releases when the process dies. The file is removed on
+// Unlock; a lock taken on a file removed in the meantime is given up and
+// tried again. A lock directory left by the mkdir backend is respected,
+// and broken if it is stale. It reports whether the lock was taken.
+func (rf *Recentfile) tryFlock(lockFile string) (bool, error) {
+	for {
+		if fi, err := os.Stat(lockFile); err == nil && fi.IsDir() {
+			if stale, err := rf.checkStaleLock(lockFile); err != nil {
+				return false, fmt.Errorf("check stale lock: %w", err)
+			} else if !stale {
+				return false, nil
+			}
+			if err := os.RemoveAll(lockFile); err != nil {
+				return false, fmt.Errorf("remove stale lock: %w", err)
 			}
-			continue
 		}
 
-		// Check timeout
-		if time.Since(start) > timeout {
-			return fmt.Errorf("lock timeout after %v", timeout)
+		f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return false, fmt.Errorf("open %s: %w", lockFile, err)
+		}
+		locked, err := flockFile(f)
+		if err != nil || !locked {
+			f.Close()
+			if err != nil {
+				return false, fmt.Errorf("flock %s: %w", lockFile, err)
+			}
+			return false, nil
 		}
 
-		// Wait and retry
-		time.Sleep(sleepDuration)
+		held, err1 := f.Stat()
+		current, err2 := os.Stat(lockFile)
+		if err1 != nil || err2 != nil || !os.SameFile(held, current) {
+			// Removed by the previous holder's Unlock
+			funlockFile(f)
+			f.Close()
+			continue
+		}
 
-		// Exponential backoff up to 1 second
-		sleepDuration *= 2
-		if sleepDuration > time.Second {
-			sleepDuration = time.Second
+		rf.mu.Lock()
+		if err := rf.perm.file(f); err != nil {
+			rf.mu.Unlock()
+			funlockFile(f)
+			f.Close()
+			return false, fmt.Errorf("set lock permissions: %w", err)
 		}
+		rf.locked = true
+		rf.lockFile = f
+		rf.lockDir = lockFile
+		rf.mu.Unlock()
+
+		return true, nil
 	}
 }
 
@@ -83,7 +264,25 @@ func (rf *Recentfile) Unlock() error {
 	defer rf.mu.Unlock()
 
 	if !rf.locked {
-		return fmt.Errorf("not locked")
+		return ErrNotLocked
+	}
+
+	if f := rf.lockFile; f != nil {
+		// Remove the file while still holding it, so whoever waits on it
+		// notices and locks a new one. Where open files can't be removed
+		// the file stays, which is harmless.
+		os.Remove(rf.lockDir)
+		err := funlockFile(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		rf.locked = false
+		rf.lockFile = nil
+		rf.lockDir = ""
+		if err != nil {
+			return fmt.Errorf("release lock file: %w", err)
+		}
+		return nil
 	}
 
 	// Remove lock directory
@@ -97,8 +296,9 @@ func (rf *Recentfile) Unlock() error {
 	return nil
 }
 
-// writeLockPID writes the current process PID to the lock directory.
-func (rf *Recentfile) writeLockPID(lockDir string) error {
+// writeLockPID writes the current process PID to the lock directory,
+// with the permissions of perm.
+func (rf *Recentfile) writeLockPID(lockDir string, perm Permissions) error {
 	pidFile := filepath.Join(lockDir, "process")
 	pid := os.Getpid()
 
@@ -107,11 +307,73 @@ func (rf *Recentfile) writeLockPID(lockDir string) error {
 		return fmt.Errorf("write PID file: %w", err)
 	}
 
-	return nil
+	return perm.path(pidFile, perm.FileMode)
+}
+
+// writeLockHost records this host and the start time of this process in
+// the lock directory, with the permissions of perm. Nothing is recorded
+// if the hostname is unknown.
+func writeLockHost(lockDir string, perm Permissions) error {
+	host := hostname()
+	if host == "" {
+		return nil
+	}
+	data := fmt.Sprintf("%s\n%d\n", host, processStart.Unix())
+	hostFile := filepath.Join(lockDir, lockHostFile)
+	if err := os.WriteFile(hostFile, []byte(data), 0o644); err != nil {
+		return err
+	}
+	return perm.path(hostFile, perm.FileMode)
+}
+
+// readLockHost returns the host and process start time recorded in the
+// lock directory; the host is empty if none is recorded.
+func readLockHost(lockDir string) (string, time.Time) {
+	data, err := os.ReadFile(filepath.Join(lockDir, lockHostFile))
+	if err != nil {
+		return "", time.Time{}
+	}
+	host, started, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	var start time.Time
+	if secs, err := strconv.ParseInt(strings.TrimSpace(started), 10, 64); err == nil {
+		start = time.Unix(secs, 0)
+	}
+	return strings.TrimSpace(host), start
 }
 
+// lockHolder describes the holder of the lock for error messages.
+func lockHolder(lockDir string) string {
+	if fi, err := os.Stat(lockDir); err == nil && !fi.IsDir() {
+		return "another process (flock)"
+	}
+	data, _ := os.ReadFile(filepath.Join(lockDir, "process"))
+	holder := "pid " + strings.TrimSpace(string(data))
+	if host, start := readLockHost(lockDir); host != "" {
+		holder += " on " + host
+		if !start.IsZero() {
+			holder += ", started " + start.Format(time.RFC3339)
+		}
+	}
+	return holder
+}
+
+// lockStartSlack is how much later than the start recorded in a lock its
+// holder's PID may have started and still be the holder, allowing for
+// the rounding of both times and for clock adjustments.
+const lockStartSlack = time.Minute
+
 // checkStaleLock checks if the lock is stale (process no longer running).
+// A process that started after the start recorded in the lock has reused
+// the holder's PID. Whether a process on another host is running can't be
+// told from here, so such locks are only stale with WithBreakLocks.
 func (rf *Recentfile) checkStaleLock(lockDir string) (bool, error) {
+	host, start := readLockHost(lockDir)
+	if host != "" && host != hostname() {
+		rf.mu.RLock()
+		defer rf.mu.RUnlock()
+		return rf.breakLocks, nil
+	}
+
 	pidFile := filepath.Join(lockDir, "process")
 
 	// Read PID from lock directory
@@ -141,7 +403,13 @@ func (rf *Recentfile) checkStaleLock(lockDir string) (bool, error) {
 	}
 
 	// Check if process is running
-	return !isProcessRunning(pid), nil
+	if !isProcessRunning(pid) {
+		return true, nil
+	}
+	if started, ok := processStartTime(pid); ok && !start.IsZero() && started.After(start.Add(lockStartSlack)) {
+		return true, nil
+	}
+	return false, nil
 }
 
 // Locked returns true if this recentfile is currently locked.
@@ -1,9 +1,13 @@
 package recentfile
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -98,8 +102,8 @@ func TestUnlockWithoutLock(t *testing.T) {
 	)
 
 	// Unlock without lock should fail
-	if err := rf.Unlock(); err == nil {
-		t.Error("Unlock without lock should fail")
+	if err := rf.Unlock(); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Unlock without lock = %v, want ErrNotLocked", err)
 	}
 }
 
@@ -151,6 +155,68 @@ func TestConcurrentLocking(t *testing.T) {
 	rf2.Unlock()
 }
 
+func TestFlockLocking(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf1 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithLockBackend(LockBackendFlock))
+	rf2 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithLockBackend(LockBackendFlock))
+	rf2.lockTimeout = 50 * time.Millisecond
+	lockFile := rf1.Rfile() + ".lock"
+
+	if err := rf1.Lock(); err != nil {
+		t.Fatalf("Lock rf1 failed: %v", err)
+	}
+	if err := rf2.Lock(); err == nil {
+		t.Fatal("rf2 locked a file rf1 holds")
+	}
+
+	// Waiters get the lock once it is released, and the file goes away
+	done := make(chan error, 1)
+	go func() {
+		rf3 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithLockBackend(LockBackendFlock))
+		err := rf3.Lock()
+		if err == nil {
+			err = rf3.Unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := rf1.Unlock(); err != nil {
+		t.Fatalf("Unlock rf1 failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("waiting Lock failed: %v", err)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+
+	// A holder that dies without unlocking leaves the file, but not the lock
+	if err := rf1.Lock(); err != nil {
+		t.Fatalf("Lock rf1 failed: %v", err)
+	}
+	rf1.lockFile.Close()
+	if err := rf2.Lock(); err != nil {
+		t.Fatalf("Lock after the holder died failed: %v", err)
+	}
+	rf2.Unlock()
+
+	// A stale lock directory of the mkdir backend is broken
+	if err := os.Mkdir(lockFile, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(lockFile, "process"), []byte("999999999\n"), 0o644)
+	if err := rf2.Lock(); err != nil {
+		t.Fatalf("Lock over a stale lock directory failed: %v", err)
+	}
+	rf2.Unlock()
+
+	rf2.SetLockBackend("fcntl")
+	if err := rf2.Lock(); err == nil {
+		t.Error("Lock accepted an unknown backend")
+	}
+}
+
 func TestStaleLockDetection(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -194,6 +260,81 @@ func TestStaleLockDetection(t *testing.T) {
 	rf.Unlock()
 }
 
+func TestLockOnOtherHost(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"))
+	rf.lockTimeout = 50 * time.Millisecond
+
+	// Our own locks record this host
+	if err := rf.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	lockDir := rf.Rfile() + ".lock"
+	if host, start := readLockHost(lockDir); host != hostname() || !start.Equal(processStart.Truncate(time.Second)) {
+		t.Errorf("lock host = %q, %v", host, start)
+	}
+	rf.Unlock()
+
+	// A PID that doesn't exist here may well exist on the other host
+	if err := os.Mkdir(lockDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(lockDir, "process"), []byte("999999999\n"), 0o644)
+	os.WriteFile(filepath.Join(lockDir, lockHostFile), []byte("elsewhere.example.org\n1704207845\n"), 0o644)
+
+	err := rf.Lock()
+	if err == nil {
+		t.Fatal("Lock broke a lock held on another host")
+	}
+	if !strings.Contains(err.Error(), "pid 999999999 on elsewhere.example.org") {
+		t.Errorf("Lock error %q doesn't name the holder", err)
+	}
+
+	rf.SetBreakLocks(true)
+	if err := rf.Lock(); err != nil {
+		t.Fatalf("Lock with break locks failed: %v", err)
+	}
+	rf.Unlock()
+}
+
+func TestLockWithReusedPID(t *testing.T) {
+	if _, ok := processStartTime(os.Getpid()); !ok {
+		t.Skip("process start times can't be read here")
+	}
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"))
+	rf.lockTimeout = 50 * time.Millisecond
+	lockDir := rf.Rfile() + ".lock"
+
+	lock := func(start time.Time) error {
+		t.Helper()
+		if err := os.Mkdir(lockDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(lockDir, "process"), []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+		os.WriteFile(filepath.Join(lockDir, lockHostFile), []byte(fmt.Sprintf("%s\n%d\n", hostname(), start.Unix())), 0o644)
+		err := rf.Lock()
+		if err == nil {
+			rf.Unlock()
+		} else {
+			os.RemoveAll(lockDir)
+		}
+		return err
+	}
+
+	// Held by this process
+	if err := lock(processStart); err == nil {
+		t.Error("Lock broke a lock of a running process")
+	}
+
+	// Held by a process that died before this one got its PID
+	if err := lock(processStart.Add(-time.Hour)); err != nil {
+		t.Errorf("Lock with reused PID failed: %v", err)
+	}
+}
+
 func TestLockWithMissingPIDFile(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -280,6 +421,8 @@ func TestLockTimeout(t *testing.T) {
 	if err == nil {
 		t.Error("rf2.Lock() should timeout")
 		rf2.Unlock()
+	} else if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("rf2.Lock() = %v, want ErrLockTimeout", err)
 	}
 
 	// Verify timeout happened roughly at the expected time
@@ -288,6 +431,126 @@ func TestLockTimeout(t *testing.T) {
 	}
 }
 
+func TestTryLock(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, backend := range []string{LockBackendMkdir, LockBackendFlock} {
+		rf1 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithLockBackend(backend))
+		rf2 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithLockBackend(backend))
+
+		if err := rf1.Lock(); err != nil {
+			t.Fatalf("%s: Lock rf1 failed: %v", backend, err)
+		}
+		if locked, err := rf2.TryLock(); locked || err != nil {
+			t.Errorf("%s: TryLock of a held lock = %v, %v", backend, locked, err)
+		}
+		if err := rf1.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+
+		if locked, err := rf2.TryLock(); !locked || err != nil || !rf2.Locked() {
+			t.Errorf("%s: TryLock of a free lock = %v, %v", backend, locked, err)
+		}
+		if err := rf2.Unlock(); err != nil {
+			t.Errorf("%s: Unlock failed: %v", backend, err)
+		}
+	}
+}
+
+func TestLockContext(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf1 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithAggregator([]string{"6h"}))
+	rf2 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithAggregator([]string{"6h"}))
+
+	// A free lock is taken even with a cancelled context
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rf1.LockContext(cancelled); err != nil {
+		t.Fatalf("LockContext with a free lock failed: %v", err)
+	}
+	defer rf1.Unlock()
+
+	// Waiting for a held lock ends with the context, long before the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := rf2.LockContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LockContext = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("LockContext returned after %v", elapsed)
+	}
+
+	// So do updates and aggregation
+	if err := rf2.BatchUpdateContext(cancelled, []BatchItem{{Path: "a", Type: "new"}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("BatchUpdateContext = %v, want %v", err, context.Canceled)
+	}
+	if err := rf2.AggregateContext(cancelled, true); !errors.Is(err, context.Canceled) {
+		t.Errorf("AggregateContext = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLockObserver(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var waits []LockWait
+	rf1 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithAggregator([]string{"6h"}))
+	rf2 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithAggregator([]string{"6h"}),
+		WithLockObserver(func(lw LockWait) { waits = append(waits, lw) }))
+
+	// Uncontended
+	if err := rf2.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	rf2.Unlock()
+
+	// Held by rf1 until after a few retries
+	if err := rf1.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		rf1.Unlock()
+	}()
+	if err := rf2.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	rf2.Unlock()
+
+	// Given up
+	rf1.Lock()
+	defer rf1.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rf2.LockContext(ctx)
+
+	// Clones report to the same observer
+	clone := rf2.SparseClone()
+	clone.SetInterval("6h")
+	if err := clone.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	clone.Unlock()
+
+	if len(waits) != 4 {
+		t.Fatalf("observed %d lock attempts, want 4: %+v", len(waits), waits)
+	}
+	if w := waits[0]; w.Retries != 0 || w.Err != nil || w.Interval != "1h" {
+		t.Errorf("uncontended lock = %+v", w)
+	}
+	if w := waits[1]; w.Retries == 0 || w.Wait < 100*time.Millisecond || w.Err != nil {
+		t.Errorf("contended lock = %+v", w)
+	}
+	if w := waits[2]; w.Retries == 0 || !errors.Is(w.Err, context.DeadlineExceeded) {
+		t.Errorf("abandoned lock = %+v", w)
+	}
+	if w := waits[3]; w.Interval != "6h" || w.Err != nil {
+		t.Errorf("clone's lock = %+v", w)
+	}
+}
+
 func TestLockBackoff(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -3,6 +3,7 @@
 package recentfile
 
 import (
+	"os"
 	"syscall"
 )
 
@@ -24,3 +25,18 @@ func isProcessRunning(pid int) bool {
 	// In this case, consider it running
 	return true
 }
+
+// flockFile tries to take an exclusive flock(2) lock on f without
+// blocking. It reports whether the lock was taken.
+func flockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// funlockFile releases the flock(2) lock on f.
+func funlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -3,8 +3,11 @@
 package recentfile
 
 import (
+	"errors"
+	"os"
 	"syscall"
-	"unsafe"
+
+	"golang.org/x/sys/windows"
 )
 
 var (
@@ -35,3 +38,21 @@ func isProcessRunning(pid int) bool {
 	procCloseHandle.Call(handle)
 	return true
 }
+
+// flockFile tries to take an exclusive lock on f with LockFileEx, the
+// Windows counterpart of flock(2), without blocking. It reports whether
+// the lock was taken.
+func flockFile(f *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, new(windows.Overlapped))
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// funlockFile releases the lock on f.
+func funlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
@@ -0,0 +1,256 @@
+package recentfile
+
+import (
+	"errors"
+	"iter"
+	"os"
+	"slices"
+)
+
+// fileEvents iterates over the events of the recentfile at path, newest
+// first, without loading the whole file for formats that can be streamed.
+// A missing file has no events.
+func fileEvents(path string) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		stopped := false
+		_, err := StreamEvents(path, 1000, func(events []Event) bool {
+			for _, event := range events {
+				if !yield(event, nil) {
+					stopped = true
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil && !stopped && !errors.Is(err, os.ErrNotExist) {
+			yield(Event{}, err)
+		}
+	}
+}
+
+// withoutEpochText forgets the original text of the epochs of events
+// (see WithPreserveEpochs).
+func withoutEpochText(events iter.Seq2[Event, error]) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for event, err := range events {
+			event.epochText = ""
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// fileMeta reads the metadata of the recentfile at path. For JSON files it
+// stops at the first event.
+func fileMeta(path string) (MetaData, error) {
+	stats, err := StreamEvents(path, 1, func([]Event) bool { return false })
+	if err != nil {
+		return MetaData{}, err
+	}
+	return stats.Meta, nil
+}
+
+// sliceEvents iterates over events. A nil slice gives a nil sequence, which
+// serializers write like a nil slice.
+func sliceEvents(events []Event) iter.Seq2[Event, error] {
+	if events == nil {
+		return nil
+	}
+	return func(yield func(Event, error) bool) {
+		for _, event := range events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
+// collectEvents gathers events into a slice. A nil sequence gives a nil
+// slice.
+func collectEvents(events iter.Seq2[Event, error]) ([]Event, error) {
+	if events == nil {
+		return nil, nil
+	}
+	collected := []Event{}
+	for event, err := range events {
+		if err != nil {
+			return nil, err
+		}
+		collected = append(collected, event)
+	}
+	return collected, nil
+}
+
+// mergeEvents merges the events of a target recentfile with those of a
+// smaller source recentfile, both newest first, as MergeFrom does: events
+// older than oldestAllowed are dropped, each path keeps only its newest
+// event (the target's on a tie), and epochs are made unique. With
+// dropDeletes, a path of the source whose newest event is a delete is
+// left out altogether.
+//
+// The target is only streamed through. Memory use grows with the source,
+// whose paths are remembered to find the target events they replace;
+// paths within the target are expected to be unique already.
+func mergeEvents(target iter.Seq2[Event, error], source []Event, oldestAllowed Epoch, dropDeletes bool) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		tooOld := func(event Event) bool {
+			return !oldestAllowed.IsZero() && EpochLt(event.Epoch, oldestAllowed)
+		}
+
+		// Paths in the source, and whether their newest event has been
+		// written (from either file)
+		written := make(map[string]bool, len(source))
+		for _, event := range source {
+			written[event.Path] = false
+		}
+
+		next, stop := iter.Pull2(target)
+		defer stop()
+
+		out := epochDeduper{yield: yield}
+		t, err, ok := next()
+		s := 0
+		for ok || s < len(source) {
+			if err != nil {
+				yield(Event{}, err)
+				return
+			}
+
+			var event Event
+			if ok && (s == len(source) || !EpochLt(t.Epoch, source[s].Epoch)) {
+				event = t
+				t, err, ok = next()
+			} else {
+				event = source[s]
+				s++
+			}
+
+			if tooOld(event) {
+				continue
+			}
+			if done, inSource := written[event.Path]; inSource {
+				if done {
+					continue
+				}
+				written[event.Path] = true
+				if dropDeletes && event.Type == "delete" {
+					continue
+				}
+			}
+			if !out.add(event) {
+				return
+			}
+		}
+		out.flush()
+	}
+}
+
+// cappedEvents passes on the first limit events of a newest-first stream
+// and those after them newer than merged, the events of a recentfile with
+// a cap (see WithMaxEvents) that haven't been merged into the next
+// interval. Without a cap or a merge it passes on every event.
+func cappedEvents(events iter.Seq2[Event, error], limit int, merged *MergedInfo) iter.Seq2[Event, error] {
+	if events == nil || limit <= 0 || merged == nil || merged.Epoch.IsZero() {
+		return events
+	}
+	return func(yield func(Event, error) bool) {
+		n := 0
+		for event, err := range events {
+			if err == nil && n >= limit && !EpochGt(event.Epoch, merged.Epoch) {
+				return // so are all older ones
+			}
+			n++
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// epochDeduper passes a newest-first event stream on to yield with unique
+// epochs. Like DeduplicateEpochs, events sharing an epoch are moved up by
+// EpochIncreaseABit; they stay below the previous epoch, so the order is
+// kept without buffering more than one epoch's events.
+type epochDeduper struct {
+	yield func(Event, error) bool
+
+	run     []Event // events sharing the current epoch
+	upper   Epoch   // the smallest epoch written so far
+	written bool
+	stopped bool
+}
+
+// add queues event. It returns false once yield has asked to stop.
+func (d *epochDeduper) add(event Event) bool {
+	if len(d.run) > 0 && event.Epoch != d.run[0].Epoch {
+		d.flush()
+	}
+	d.run = append(d.run, event)
+	return !d.stopped
+}
+
+// flush writes the queued events.
+func (d *epochDeduper) flush() {
+	if len(d.run) == 0 || d.stopped {
+		return
+	}
+
+	for i := 1; i < len(d.run); i++ {
+		epoch := EpochIncreaseABit(d.run[i-1].Epoch)
+		if d.written && !EpochLt(epoch, d.upper) {
+			epoch = EpochBetween(d.upper, d.run[i-1].Epoch)
+		}
+		d.run[i].Epoch = epoch
+	}
+
+	for _, event := range slices.Backward(d.run) {
+		if !d.yield(event, nil) {
+			d.stopped = true
+			return
+		}
+	}
+	d.upper = d.run[0].Epoch
+	d.written = true
+	d.run = d.run[:0]
+}
+
+// eventMatcher compares events, one at a time, with those of another
+// sequence, such as the recentfile a merge would replace.
+type eventMatcher struct {
+	next    func() (Event, error, bool)
+	stop    func()
+	differs bool
+}
+
+func newEventMatcher(want iter.Seq2[Event, error]) *eventMatcher {
+	next, stop := iter.Pull2(want)
+	return &eventMatcher{next: next, stop: stop}
+}
+
+// match compares event with the next wanted event.
+func (m *eventMatcher) match(event Event) error {
+	if m.differs {
+		return nil
+	}
+	want, err, ok := m.next()
+	if err != nil {
+		return err
+	}
+	if !ok || want != event {
+		m.differs = true
+		m.stop()
+	}
+	return nil
+}
+
+// same reports whether the matched events were exactly the wanted ones.
+// It releases the wanted sequence.
+func (m *eventMatcher) same() (bool, error) {
+	defer m.stop()
+	if m.differs {
+		return false, nil
+	}
+	_, err, ok := m.next()
+	return !ok, err
+}
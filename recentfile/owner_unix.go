@@ -0,0 +1,22 @@
+//go:build unix
+
+package recentfile
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the st_mode, owner and group of the file at path.
+func fileOwner(path string) (mode uint32, uid, gid int, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("%s: no owner", path)
+	}
+	return uint32(st.Mode), int(st.Uid), int(st.Gid), nil
+}
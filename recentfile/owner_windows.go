@@ -0,0 +1,11 @@
+//go:build windows
+
+package recentfile
+
+import "errors"
+
+// fileOwner returns the st_mode, owner and group of the file at path.
+// Windows files have neither, so events are written without.
+func fileOwner(path string) (mode uint32, uid, gid int, err error) {
+	return 0, 0, 0, errors.New("file owners are not supported on windows")
+}
@@ -0,0 +1,120 @@
+package recentfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// perlYAMLEpochs are the fields holding epochs, by their path in the
+// document. The Perl implementation writes them as strings, so they keep
+// their precision through Perl's numeric conversions.
+var perlYAMLEpochs = map[string]bool{
+	"meta.dirtymark":    true,
+	"meta.merged.epoch": true,
+	"meta.merged.time":  true,
+	"meta.minmax.max":   true,
+	"meta.minmax.min":   true,
+	"recent.epoch":      true,
+}
+
+// marshalPerlYAML writes data as the Perl implementation does: a "---"
+// document start, mapping keys sorted bytewise at every level, two space
+// indentation, and epochs as quoted strings. Numbers are written in their
+// shortest exact decimal form (or their original text, see
+// WithPreserveEpochs), never in exponent notation.
+func marshalPerlYAML(data *SerializedData) ([]byte, error) {
+	// Go through the JSON form so the field names and omitempty rules
+	// are the same as for the other serializers
+	doc, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal yaml: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("marshal yaml: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(perlYAMLNode(v, "")); err != nil {
+		return nil, fmt.Errorf("marshal yaml: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("marshal yaml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// perlYAMLNode converts a value decoded from JSON with UseNumber to a YAML
+// node. path is the dotted path of mapping keys leading to it.
+func perlYAMLNode(v interface{}, path string) *yaml.Node {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range keys {
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+				perlYAMLNode(v[key], strings.TrimPrefix(path+"."+key, ".")))
+		}
+		return node
+
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			node.Content = append(node.Content, perlYAMLNode(item, path))
+		}
+		return node
+
+	case json.Number:
+		if perlYAMLEpochs[path] {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.SingleQuotedStyle, Value: v.String()}
+		}
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}
+
+	case string:
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if perlYAMLQuote(v) {
+			node.Style = yaml.SingleQuotedStyle
+		}
+		return node
+
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(v)}
+	}
+
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "~"}
+}
+
+// perlYAMLQuote reports whether the string s would be read as another type
+// unquoted, so Perl quotes it, with single quotes. Strings YAML can't have
+// unquoted for other reasons are quoted by the encoder.
+func perlYAMLQuote(s string) bool {
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "", "~", "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return true
+	}
+	return false
+}
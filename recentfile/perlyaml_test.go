@@ -0,0 +1,81 @@
+package recentfile
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPerlYAML(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithAggregator([]string{"6h", "1d"}),
+		WithPerlYAML(true),
+	)
+	rf.meta.Dirtymark = 1704200000.25
+	rf.meta.Merged = &MergedInfo{Epoch: 1704207840.5, IntoInterval: "6h"}
+	rf.meta.Minmax = &MinmaxInfo{Max: 1704207845.12345, Min: 1704207840.5, Mtime: 1704207846}
+	rf.meta.Producers = map[string]interface{}{
+		"time":                 1704207846.00001,
+		"github.com/abh/rrrgo": "1.0",
+		"$0":                   "/usr/bin/rrr-server",
+	}
+	rf.SetRecentEvents([]Event{
+		{Epoch: 1704207845.12345, Path: "authors/id/A/AB/ABH/Foo-1.0.tar.gz", Type: "new"},
+		{Epoch: 1704207840.5, Path: "123", Type: "delete"},
+	})
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	data, err := os.ReadFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `---
+meta:
+  Producers:
+    $0: /usr/bin/rrr-server
+    github.com/abh/rrrgo: '1.0'
+    time: 1704207846.00001
+  aggregator:
+    - 6h
+    - 1d
+  dirtymark: '1704200000.25'
+  filenameroot: RECENT
+  interval: 1h
+  merged:
+    epoch: '1704207840.5'
+    into_interval: 6h
+  minmax:
+    max: '1704207845.12345'
+    min: '1704207840.5'
+    mtime: 1704207846
+  protocol: 1
+  serializer_suffix: .yaml
+recent:
+  - epoch: '1704207845.12345'
+    path: authors/id/A/AB/ABH/Foo-1.0.tar.gz
+    type: new
+  - epoch: '1704207840.5'
+    path: '123'
+    type: delete
+`
+	if string(data) != want {
+		t.Errorf("Write =\n%s\nwant\n%s", data, want)
+	}
+
+	rf2, err := NewFromFile(rf.Rfile())
+	if err != nil {
+		t.Fatalf("NewFromFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(rf2.RecentEvents(), rf.RecentEvents()) {
+		t.Errorf("events = %+v, want %+v", rf2.RecentEvents(), rf.RecentEvents())
+	}
+	if meta := rf2.Meta(); meta.Dirtymark != rf.meta.Dirtymark || *meta.Merged != *rf.meta.Merged || *meta.Minmax != *rf.meta.Minmax {
+		t.Errorf("meta = %+v", meta)
+	}
+}
@@ -0,0 +1,50 @@
+package recentfile
+
+import "os"
+
+// Permissions are the mode and owner of the files, lock directories and
+// symlink a recentfile writes, e.g. to make them world-readable for
+// rsyncd. The zero value leaves them as created: files 0644 and lock
+// directories 0755, less the umask, owned by the writer.
+type Permissions struct {
+	FileMode os.FileMode // of RECENT files, signatures and lock files, unless 0
+	DirMode  os.FileMode // of lock directories, unless 0
+	Chown    bool        // give everything written UID and GID
+	UID, GID int         // -1 leaves the owner or group alone, as for os.Chown
+}
+
+// file applies p to the file f just created.
+func (p Permissions) file(f *os.File) error {
+	if p.FileMode != 0 {
+		if err := f.Chmod(p.FileMode); err != nil {
+			return err
+		}
+	}
+	if p.Chown {
+		return f.Chown(p.UID, p.GID)
+	}
+	return nil
+}
+
+// path applies p to the file or directory name just created, with mode
+// unless it is 0.
+func (p Permissions) path(name string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(name, mode); err != nil {
+			return err
+		}
+	}
+	if p.Chown {
+		return os.Chown(name, p.UID, p.GID)
+	}
+	return nil
+}
+
+// symlink gives the symlink name just created the owner of p; symlinks
+// have no mode of their own.
+func (p Permissions) symlink(name string) error {
+	if p.Chown {
+		return os.Lchown(name, p.UID, p.GID)
+	}
+	return nil
+}
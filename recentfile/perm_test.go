@@ -0,0 +1,55 @@
+package recentfile
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on windows")
+	}
+	tmpDir := t.TempDir()
+
+	// Our own user and group, which needs no root
+	perm := Permissions{FileMode: 0o640, DirMode: 0o750, Chown: true, UID: os.Getuid(), GID: os.Getgid()}
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithPermissions(perm))
+	if err := rf.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	fi, err := os.Stat(rf.Rfile() + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0o750 {
+		t.Errorf("lock directory mode = %o, want 750", got)
+	}
+	if fi, err := os.Stat(filepath.Join(rf.Rfile()+".lock", "process")); err != nil || fi.Mode().Perm() != 0o640 {
+		t.Errorf("lock PID file: %v, %v", fi, err)
+	}
+	rf.Unlock()
+
+	if err := rf.Update("a", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if fi, err := os.Stat(rf.Rfile()); err != nil || fi.Mode().Perm() != 0o640 {
+		t.Errorf("RECENT file: %v, %v", fi, err)
+	}
+	if err := rf.AssertSymlink(); err != nil {
+		t.Errorf("AssertSymlink failed: %v", err)
+	}
+
+	// The mode is set regardless of the umask
+	rf.SetPermissions(Permissions{FileMode: 0o666})
+	if err := rf.Update("b", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if fi, err := os.Stat(rf.Rfile()); err != nil || fi.Mode().Perm() != 0o666 {
+		t.Errorf("RECENT file: %v, %v", fi, err)
+	}
+	if rf.SparseClone().perm != rf.perm {
+		t.Error("SparseClone lost the permissions")
+	}
+}
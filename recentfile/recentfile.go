@@ -1,17 +1,22 @@
 package recentfile
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"go.ntppool.org/common/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Recentfile represents a single RECENT file covering a specific time interval.
@@ -24,19 +29,78 @@ type Recentfile struct {
 
 	// Internal state
 	localRoot        string
+	indexDir         string // where the files are, localRoot if empty
 	rfile            string // cached full path
 	interval         string // e.g., "1h", "6h"
 	filenameRoot     string // e.g., "RECENT"
 	serializerSuffix string // e.g., ".yaml"
 
 	// Locking
-	locked      bool
-	lockDir     string
-	lockTimeout time.Duration
+	locked       bool
+	lockDir      string   // the lock directory, or file with flock
+	lockFile     *os.File // open while holding a flock lock
+	lockTimeout  time.Duration
+	lockBackend  string // LockBackendMkdir if empty
+	breakLocks   bool   // break locks held on other hosts
+	lockObserver func(LockWait)
+
+	// Deferred writes (see WithDeferredWrites)
+	deferEvery time.Duration
+	deferMax   int
+	inMemory   bool      // the events in memory are authoritative
+	pending    int       // events not written yet
+	lastWrite  time.Time // of the last Write
 
 	// Done tracking
 	done *Done
 
+	// truncateAtMerge drops events as soon as they are merged into the next
+	// larger file instead of keeping them for the full interval.
+	truncateAtMerge bool
+
+	// eventMtime sets the file's mtime to the newest event's epoch on
+	// every write.
+	eventMtime bool
+
+	// preserveEpochs keeps the original text of epochs read from files.
+	preserveEpochs bool
+
+	// protocolExt records file sizes and checksums in events.
+	protocolExt bool
+
+	// dropDeletes are the intervals that delete events are not merged into.
+	dropDeletes []string
+
+	// maxEvents caps the number of events of the recentfiles of these
+	// intervals (see WithMaxEvents).
+	maxEvents map[string]int
+
+	// generations is the number of previous versions of the file kept on
+	// every write.
+	generations int
+
+	// durable syncs the file and its directory to disk on every write.
+	durable bool
+
+	// perm are the mode and owner of the files and lock directories
+	// written.
+	perm Permissions
+
+	// perlYAML writes YAML the way the Perl implementation does.
+	perlYAML bool
+
+	// producer and producerVersion identify this implementation in the
+	// Producers metadata; DefaultProducer if empty.
+	producer        string
+	producerVersion string
+
+	// comment replaces the comment of files read from disk if set.
+	comment string
+
+	// fetcher reads the file instead of the local disk, with rfile as the
+	// name to fetch (see NewFromFetcher).
+	fetcher Fetcher
+
 	// Flags
 	verbose    bool
 	verboseLog string
@@ -78,6 +142,28 @@ type Event struct {
 	Epoch Epoch  `yaml:"epoch" json:"epoch"`
 	Path  string `yaml:"path" json:"path"`
 	Type  string `yaml:"type" json:"type"` // "new" or "delete"
+
+	// Size, Sha256, Mode, UID and GID describe the file of a "new" event,
+	// if known. They are a protocol extension, only written with
+	// WithProtocolExt. Mode is the Unix st_mode, file type and permission
+	// bits; UID and GID are only meaningful if it is set, and omitted
+	// when 0.
+	Size   int64  `yaml:"size,omitempty" json:"size,omitempty"`
+	Sha256 string `yaml:"sha256,omitempty" json:"sha256,omitempty"` // lower case hex
+	Mode   uint32 `yaml:"mode,omitempty" json:"mode,omitempty"`
+	UID    int    `yaml:"uid,omitempty" json:"uid,omitempty"`
+	GID    int    `yaml:"gid,omitempty" json:"gid,omitempty"`
+
+	// Dir marks the event of a directory rather than a file, so that
+	// clients can recreate empty directories. It is a protocol extension,
+	// only written by watchers tracking directories (see
+	// watcher.WithDirEvents).
+	Dir bool `yaml:"dir,omitempty" json:"dir,omitempty"`
+
+	// epochText is Epoch as it was written in the file the event was
+	// read from, if writing Epoch would change it (see WithPreserveEpochs).
+	// It is only written back while it still parses to Epoch.
+	epochText string
 }
 
 // BatchItem is used for batch updates.
@@ -85,8 +171,23 @@ type BatchItem struct {
 	Path  string
 	Type  string // "new" or "delete"
 	Epoch Epoch  // optional dirty epoch
+
+	// What is known about the file, optional (see Event). With
+	// WithProtocolExt the size and SHA-256 are found for "new" items
+	// without a Sha256, the mode and owner for those without a Mode.
+	Size   int64
+	Sha256 string
+	Mode   uint32
+	UID    int
+	GID    int
+
+	Dir bool // the item is a directory (see Event.Dir)
 }
 
+// DefaultProducer is the name this implementation is recorded under in
+// the Producers metadata unless WithProducer sets another.
+const DefaultProducer = "github.com/abh/rrrgo"
+
 // Option is a functional option for configuring a Recentfile.
 type Option func(*Recentfile)
 
@@ -113,6 +214,15 @@ func WithLocalRoot(root string) Option {
 	}
 }
 
+// WithIndexDir keeps the recentfile in dir instead of the local root, for
+// read-only trees or to keep index writes off the mirrored volume. Event
+// paths stay relative to the local root.
+func WithIndexDir(dir string) Option {
+	return func(rf *Recentfile) {
+		rf.indexDir = dir
+	}
+}
+
 // WithVerbose sets verbose logging.
 func WithVerbose(v bool) Option {
 	return func(rf *Recentfile) {
@@ -135,6 +245,181 @@ func WithSerializerSuffix(suffix string) Option {
 	}
 }
 
+// WithRetention keeps events for the full interval even after they have
+// been merged into the next larger file, like the Perl implementation. It
+// is on by default; turning it off truncates at the last merge.
+func WithRetention(on bool) Option {
+	return func(rf *Recentfile) {
+		rf.truncateAtMerge = !on
+	}
+}
+
+// WithDropDeletes keeps delete events out of the recentfiles of these
+// aggregator intervals: aggregating a delete into one of them removes the
+// path's older events without recording the delete, like the Perl
+// implementation does for Z unless keep_delete_objects_forever is set.
+// By default deletes propagate into every interval.
+func WithDropDeletes(intervals []string) Option {
+	return func(rf *Recentfile) {
+		rf.dropDeletes = intervals
+	}
+}
+
+// WithMaxEvents caps the number of events kept in the recentfiles of
+// these intervals, so a storm of changes doesn't make files clients have
+// to fetch and parse arbitrarily large. Beyond the cap, events are dropped
+// as soon as they are merged into the next larger interval, oldest first,
+// rather than kept for the full interval; events not merged yet are
+// never dropped. A recentfile over its cap is Overflowing, and a target
+// over it at the end of an aggregation is merged into the next interval
+// too. There is no cap by default.
+func WithMaxEvents(limits map[string]int) Option {
+	return func(rf *Recentfile) {
+		rf.maxEvents = limits
+	}
+}
+
+// WithGenerations keeps the previous n versions of the file on every
+// write, as RECENT-1h.yaml.1 (the one just replaced) to RECENT-1h.yaml.n
+// (see GenerationName), so a botched aggregation or repair can be rolled
+// back by copying one over the file. Signatures keep theirs alongside. It
+// is 0, keeping none, by default.
+func WithGenerations(n int) Option {
+	return func(rf *Recentfile) {
+		rf.generations = n
+	}
+}
+
+// WithDurableWrites syncs every write to disk: the new file before it
+// replaces the old one and the directory after, so a power loss leaves
+// either version of the file, not an empty one. It is off by default,
+// leaving it to the kernel to write the file out.
+func WithDurableWrites(durable bool) Option {
+	return func(rf *Recentfile) {
+		rf.durable = durable
+	}
+}
+
+// WithPermissions gives the RECENT files, signatures, lock directories
+// and RECENT.recent symlink written the mode and owner of perm, instead of
+// those they are created with. Changing the owner needs root.
+func WithPermissions(perm Permissions) Option {
+	return func(rf *Recentfile) {
+		rf.perm = perm
+	}
+}
+
+// WithPreserveEpochs keeps epochs read from files in their original
+// decimal form, so events written back unmodified are byte-identical even
+// if their epochs have more digits than a float64 holds, as with some
+// Perl mirrors. Comparisons still use the float64 value. It is off by
+// default, reformatting every epoch.
+func WithPreserveEpochs(on bool) Option {
+	return func(rf *Recentfile) {
+		rf.preserveEpochs = on
+	}
+}
+
+// WithProtocolExt records the size and SHA-256 of the file in "new"
+// events, so clients can verify their downloads, computing them for files
+// of up to ChecksumMaxSize bytes, and its mode and owner, for clients that
+// keep permissions. The fields are an extension of the protocol the Perl
+// implementation doesn't know; with it off (the default) they are never
+// written, and dropped from files read.
+func WithProtocolExt(on bool) Option {
+	return func(rf *Recentfile) {
+		rf.protocolExt = on
+	}
+}
+
+// WithPerlYAML writes YAML recentfiles the way the Perl implementation
+// does: keys sorted, epochs as quoted decimal strings with no more digits
+// than they have, and two space indentation, so Perl clients and servers
+// taking over the hierarchy see no difference. It has no effect on other
+// formats.
+func WithPerlYAML(on bool) Option {
+	return func(rf *Recentfile) {
+		rf.perlYAML = on
+	}
+}
+
+// WithProducer sets the name and version recorded in the Producers
+// metadata on every update, instead of DefaultProducer and the rrrgo
+// version, e.g. for a program embedding this package.
+func WithProducer(name, version string) Option {
+	return func(rf *Recentfile) {
+		rf.producer = name
+		rf.producerVersion = version
+	}
+}
+
+// WithComment sets the comment in the metadata, replacing the comment of
+// a file read from disk.
+func WithComment(comment string) Option {
+	return func(rf *Recentfile) {
+		rf.comment = comment
+		rf.meta.Comment = comment
+	}
+}
+
+// WithEventMtime makes Write set the file's mtime to the epoch of its
+// newest event (minmax.max), which Perl clients use as a freshness hint.
+// It is off by default, leaving the mtime at the time of the write.
+func WithEventMtime(on bool) Option {
+	return func(rf *Recentfile) {
+		rf.eventMtime = on
+	}
+}
+
+// WithBreakLocks breaks locks held by processes on other hosts, as found
+// on shared filesystems like NFS. Whether such a process is still running
+// can't be checked, so by default their locks are waited for until the
+// lock timeout; use this when the other host is known to be gone. Locks
+// held on this host are broken only once their process has exited.
+func WithBreakLocks(on bool) Option {
+	return func(rf *Recentfile) {
+		rf.breakLocks = on
+	}
+}
+
+// WithLockBackend selects how the recentfile is locked: LockBackendMkdir
+// (the default) creates a lock directory holding the PID, like the Perl
+// implementation; LockBackendFlock takes a flock(2) lock on a lock file,
+// which the kernel releases when the process dies, so crashes leave no
+// stale locks behind. Both use the same path, and a flock lock waits for a
+// lock directory, but every process writing a hierarchy should use the
+// same backend: a mkdir lock doesn't see a flock lock.
+func WithLockBackend(name string) Option {
+	return func(rf *Recentfile) {
+		rf.lockBackend = name
+	}
+}
+
+// WithLockObserver sets a function called after every attempt to take the
+// lock with how long it took, e.g. to find contention with other processes
+// writing the hierarchy. Recentfiles cloned from rf, such as those
+// aggregation works on, report to it too.
+func WithLockObserver(observe func(LockWait)) Option {
+	return func(rf *Recentfile) {
+		rf.lockObserver = observe
+	}
+}
+
+// WithDeferredWrites keeps the events in memory authoritative after the
+// first write, instead of reading and rewriting the file on every
+// BatchUpdate. Updates are written when every has passed since the last
+// write or, if maxEvents is positive, when maxEvents events are pending,
+// and on Flush. A zero every turns it off.
+//
+// This process must be the only one updating the recentfile while it is
+// on: changes written by others are overwritten.
+func WithDeferredWrites(every time.Duration, maxEvents int) Option {
+	return func(rf *Recentfile) {
+		rf.deferEvery = every
+		rf.deferMax = maxEvents
+	}
+}
+
 // New creates a new Recentfile with the given options.
 func New(opts ...Option) *Recentfile {
 	rf := &Recentfile{
@@ -176,7 +461,11 @@ func (rf *Recentfile) Rfile() string {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 
-	rf.rfile = filepath.Join(rf.localRoot, rf.Rfilename())
+	dir := rf.indexDir
+	if dir == "" {
+		dir = rf.localRoot
+	}
+	rf.rfile = filepath.Join(dir, rf.Rfilename())
 	return rf.rfile
 }
 
@@ -186,10 +475,12 @@ func (rf *Recentfile) Rfilename() string {
 }
 
 // SplitRfilename parses a filename into its components.
-// Expected format: "RECENT-1h.yaml" -> root="RECENT", interval="1h", suffix=".yaml"
+// Expected format: "RECENT-1h.yaml" -> root="RECENT", interval="1h", suffix=".yaml".
+// Compressed and encrypted files keep their markers in the suffix:
+// "RECENT-1h.json.gz.enc" -> ".json.gz.enc".
 func SplitRfilename(name string) (root, interval, suffix string, err error) {
-	// Pattern: root-interval.suffix
-	re := regexp.MustCompile(`^(.+)-([^-\.]+)(\.[^\.]+)$`)
+	// Pattern: root-interval.suffix[.gz|.zst][.enc]
+	re := regexp.MustCompile(`^(.+)-([^-\.]+)(\.[^\.]+(?:\.gz|\.zst)?(?:\.enc)?)$`)
 	matches := re.FindStringSubmatch(name)
 	if len(matches) != 4 {
 		return "", "", "", fmt.Errorf("invalid recentfile name: %s", name)
@@ -216,6 +507,26 @@ func (rf *Recentfile) SetLocalRoot(root string) {
 	rf.rfile = "" // clear cached path
 }
 
+// IndexDir returns the directory holding the recentfile: the local root
+// unless set with WithIndexDir.
+func (rf *Recentfile) IndexDir() string {
+	rf.mu.RLock()
+	defer rf.mu.RUnlock()
+	if rf.indexDir == "" {
+		return rf.localRoot
+	}
+	return rf.indexDir
+}
+
+// SetIndexDir sets the directory holding the recentfile (see
+// WithIndexDir); empty means the local root.
+func (rf *Recentfile) SetIndexDir(dir string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.indexDir = dir
+	rf.rfile = "" // clear cached path
+}
+
 // SetInterval sets the interval.
 func (rf *Recentfile) SetInterval(interval string) {
 	rf.mu.Lock()
@@ -225,6 +536,176 @@ func (rf *Recentfile) SetInterval(interval string) {
 	rf.rfile = "" // clear cached path
 }
 
+// SetAggregator sets the aggregator intervals recorded in the metadata
+// (see WithAggregator).
+func (rf *Recentfile) SetAggregator(agg []string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.meta.Aggregator = agg
+}
+
+// SetSerializerSuffix sets the format the recentfile is written in, and
+// so its file name (see WithSerializerSuffix).
+func (rf *Recentfile) SetSerializerSuffix(suffix string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.serializerSuffix = suffix
+	rf.meta.SerializerSuffix = suffix
+	rf.rfile = "" // clear cached path
+}
+
+// SetEventMtime turns setting the file mtime to the newest event on or off
+// (see WithEventMtime).
+func (rf *Recentfile) SetEventMtime(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.eventMtime = on
+}
+
+// SetPreserveEpochs turns keeping the original text of epochs on or off
+// (see WithPreserveEpochs).
+func (rf *Recentfile) SetPreserveEpochs(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.preserveEpochs = on
+}
+
+// SetProtocolExt turns recording file sizes, checksums, modes and owners in
+// events on or off (see WithProtocolExt).
+func (rf *Recentfile) SetProtocolExt(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.protocolExt = on
+}
+
+// ProtocolExt reports whether file sizes, checksums, modes and owners are
+// recorded in events (see WithProtocolExt).
+func (rf *Recentfile) ProtocolExt() bool {
+	rf.mu.RLock()
+	defer rf.mu.RUnlock()
+	return rf.protocolExt
+}
+
+// SetPerlYAML turns writing YAML like the Perl implementation on or off
+// (see WithPerlYAML).
+func (rf *Recentfile) SetPerlYAML(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.perlYAML = on
+}
+
+// SetProducer sets the name and version recorded in the Producers metadata
+// (see WithProducer).
+func (rf *Recentfile) SetProducer(name, version string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.producer = name
+	rf.producerVersion = version
+}
+
+// SetComment sets the comment in the metadata (see WithComment). It is
+// written with the next Write; empty leaves the comment of the file alone.
+func (rf *Recentfile) SetComment(comment string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.comment = comment
+	if comment != "" {
+		rf.meta.Comment = comment
+	}
+}
+
+// SetBreakLocks turns breaking locks held on other hosts on or off (see
+// WithBreakLocks).
+func (rf *Recentfile) SetBreakLocks(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.breakLocks = on
+}
+
+// SetLockBackend selects how the recentfile is locked (see
+// WithLockBackend). It takes effect with the next Lock.
+func (rf *Recentfile) SetLockBackend(name string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.lockBackend = name
+}
+
+// SetLockObserver sets the function called after every attempt to take
+// the lock (see WithLockObserver).
+func (rf *Recentfile) SetLockObserver(observe func(LockWait)) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.lockObserver = observe
+}
+
+// SetDeferredWrites configures deferred writes (see WithDeferredWrites).
+// Turning them off doesn't write pending events; call Flush first.
+func (rf *Recentfile) SetDeferredWrites(every time.Duration, maxEvents int) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.deferEvery = every
+	rf.deferMax = maxEvents
+	if every <= 0 && rf.pending == 0 {
+		rf.inMemory = false
+	}
+}
+
+// SetRetention turns retention mode on or off (see WithRetention).
+func (rf *Recentfile) SetRetention(on bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.truncateAtMerge = !on
+}
+
+// SetDropDeletes sets the intervals that delete events are not merged
+// into (see WithDropDeletes).
+func (rf *Recentfile) SetDropDeletes(intervals []string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.dropDeletes = intervals
+}
+
+// SetMaxEvents sets the caps on the number of events of the recentfiles
+// of these intervals (see WithMaxEvents).
+func (rf *Recentfile) SetMaxEvents(limits map[string]int) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.maxEvents = limits
+}
+
+// SetGenerations sets the number of previous versions of the file kept on
+// every write (see WithGenerations).
+func (rf *Recentfile) SetGenerations(n int) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.generations = n
+}
+
+// SetDurableWrites turns syncing every write to disk on or off (see
+// WithDurableWrites).
+func (rf *Recentfile) SetDurableWrites(durable bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.durable = durable
+}
+
+// SetPermissions sets the mode and owner of the files written (see
+// WithPermissions).
+func (rf *Recentfile) SetPermissions(perm Permissions) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.perm = perm
+}
+
+// Overflowing reports whether the recentfile holds more events than its
+// cap (see WithMaxEvents), so it should be aggregated now.
+func (rf *Recentfile) Overflowing() bool {
+	rf.mu.RLock()
+	defer rf.mu.RUnlock()
+	limit := rf.maxEvents[rf.interval]
+	return limit > 0 && len(rf.recent) > limit
+}
+
 // Meta returns the metadata.
 func (rf *Recentfile) Meta() MetaData {
 	rf.mu.RLock()
@@ -232,6 +713,15 @@ func (rf *Recentfile) Meta() MetaData {
 	return rf.meta
 }
 
+// SetDirtymark sets the dirtymark in the metadata. Mirrors that see the
+// dirtymark change forget what they have synced and start over, so set it
+// when history was rewritten; it takes effect with the next Write.
+func (rf *Recentfile) SetDirtymark(dirtymark Epoch) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.meta.Dirtymark = dirtymark
+}
+
 // RecentEvents returns the events slice.
 func (rf *Recentfile) RecentEvents() []Event {
 	rf.mu.RLock()
@@ -251,6 +741,24 @@ func (rf *Recentfile) SetRecentEvents(events []Event) {
 	copy(rf.recent, events)
 }
 
+// SortEvents sorts the events by epoch, newest first, keeping the order
+// of events with the same epoch, and updates the minmax metadata to match.
+// Used by repair operations on files written out of order.
+func (rf *Recentfile) SortEvents() {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	slices.SortStableFunc(rf.recent, func(a, b Event) int {
+		switch {
+		case EpochGt(a.Epoch, b.Epoch):
+			return -1
+		case EpochLt(a.Epoch, b.Epoch):
+			return 1
+		}
+		return 0
+	})
+	rf.updateMinmax()
+}
+
 // Interval parsing constants
 const (
 	SecondSeconds  int64 = 1
@@ -269,55 +777,105 @@ func (rf *Recentfile) IntervalSecs() int64 {
 	return IntervalSecsFor(rf.interval)
 }
 
-// IntervalSecsFor returns duration for arbitrary interval string.
-// Examples: "1h" -> 3600, "6h" -> 21600, "Z" -> MaxInt64
+// IntervalPattern matches an interval in a RECENT file name, e.g. "1h",
+// "1h30m" or "Z".
+const IntervalPattern = `(?:[0-9]*[smhdWMQY])+|Z`
+
+// intervalPartRx matches the parts of a compound interval.
+var intervalPartRx = regexp.MustCompile(`([0-9]*)([smhdWMQY])`)
+
+// intervalRx matches a whole interval.
+var intervalRx = regexp.MustCompile(`^(?:` + IntervalPattern + `)$`)
+
+// intervalUnits are the seconds of the interval units.
+var intervalUnits = map[string]int64{
+	"s": SecondSeconds,
+	"m": MinuteSeconds,
+	"h": HourSeconds,
+	"d": DaySeconds,
+	"W": WeekSeconds,
+	"M": MonthSeconds,
+	"Q": QuarterSeconds,
+	"Y": YearSeconds,
+}
+
+// IntervalSecsFor returns duration for arbitrary interval string, or 0 if
+// it is not one (see ParseInterval).
+// Examples: "1h" -> 3600, "6h" -> 21600, "1h30m" -> 5400, "Z" -> MaxInt64
 func IntervalSecsFor(interval string) int64 {
-	if interval == "" {
+	secs, err := ParseInterval(interval)
+	if err != nil {
 		return 0
 	}
+	return secs
+}
 
+// ErrIntervalUnknown is returned for a string that is not an interval
+// (see ParseInterval), and for an interval a hierarchy doesn't have.
+var ErrIntervalUnknown = errors.New("unknown interval")
+
+// ParseInterval returns the duration in seconds of an interval: a count
+// and a unit (s, m, h, d, W, M, Q or Y), e.g. "90m" or "2W", several of
+// them added up, e.g. "1d12h", or Z for the interval that never ends.
+// Fractions such as "1.5h" can't be part of a file name, so they are
+// rejected; "90m" or "1h30m" say the same. The errors wrap
+// ErrIntervalUnknown.
+func ParseInterval(interval string) (int64, error) {
 	if interval == "Z" {
-		return ZSeconds
+		return ZSeconds, nil
 	}
-
-	// Parse count and unit
-	re := regexp.MustCompile(`^(\d*)([smhdWMQY])$`)
-	matches := re.FindStringSubmatch(interval)
-	if len(matches) != 3 {
-		return 0
+	if !intervalRx.MatchString(interval) {
+		if strings.ContainsAny(interval, ".,") {
+			return 0, fmt.Errorf("%w %q: fractions are not supported, use a smaller unit (e.g. 90m or 1h30m for 1.5h)", ErrIntervalUnknown, interval)
+		}
+		return 0, fmt.Errorf("%w %q: want a count and a unit (s, m, h, d, W, M, Q or Y), e.g. 6h or 1d12h, or Z", ErrIntervalUnknown, interval)
 	}
 
-	countStr := matches[1]
-	unit := matches[2]
-
-	count := int64(1)
-	if countStr != "" {
-		fmt.Sscanf(countStr, "%d", &count)
-	}
-
-	var unitSecs int64
-	switch unit {
-	case "s":
-		unitSecs = SecondSeconds
-	case "m":
-		unitSecs = MinuteSeconds
-	case "h":
-		unitSecs = HourSeconds
-	case "d":
-		unitSecs = DaySeconds
-	case "W":
-		unitSecs = WeekSeconds
-	case "M":
-		unitSecs = MonthSeconds
-	case "Q":
-		unitSecs = QuarterSeconds
-	case "Y":
-		unitSecs = YearSeconds
-	default:
-		return 0
+	var secs int64
+	for _, part := range intervalPartRx.FindAllStringSubmatch(interval, -1) {
+		count := int64(1)
+		if part[1] != "" {
+			n, err := strconv.ParseInt(part[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("%w %q: %w", ErrIntervalUnknown, interval, err)
+			}
+			count = n
+		}
+		unit := intervalUnits[part[2]]
+		if count > (ZSeconds-1-secs)/unit {
+			return 0, fmt.Errorf("%w %q: too long", ErrIntervalUnknown, interval)
+		}
+		secs += count * unit
+	}
+	if secs == 0 {
+		return 0, fmt.Errorf("%w %q: must be longer than 0s", ErrIntervalUnknown, interval)
 	}
+	return secs, nil
+}
 
-	return count * unitSecs
+// CheckAggregator reports an error unless interval and the intervals of
+// aggregator are valid, and aggregator is in strictly increasing order,
+// every interval longer than interval (which it may list too, first).
+func CheckAggregator(interval string, aggregator []string) error {
+	prev, err := ParseInterval(interval)
+	if err != nil {
+		return err
+	}
+	prevInterval := interval
+	for i, next := range aggregator {
+		if i == 0 && next == interval {
+			continue
+		}
+		secs, err := ParseInterval(next)
+		if err != nil {
+			return fmt.Errorf("aggregator: %w", err)
+		}
+		if secs <= prev {
+			return fmt.Errorf("aggregator %s: %s is not longer than %s; intervals must increase", strings.Join(aggregator, ","), next, prevInterval)
+		}
+		prev, prevInterval = secs, next
+	}
+	return nil
 }
 
 // LocalPath combines localroot with a relative path from an event.
@@ -362,11 +920,28 @@ func (rf *Recentfile) SparseClone() *Recentfile {
 
 	clone := &Recentfile{
 		localRoot:        rf.localRoot,
+		indexDir:         rf.indexDir,
 		filenameRoot:     rf.filenameRoot,
 		serializerSuffix: rf.serializerSuffix,
 		lockTimeout:      rf.lockTimeout,
+		lockBackend:      rf.lockBackend,
+		breakLocks:       rf.breakLocks,
+		lockObserver:     rf.lockObserver,
 		verbose:          rf.verbose,
 		verboseLog:       rf.verboseLog,
+		truncateAtMerge:  rf.truncateAtMerge,
+		eventMtime:       rf.eventMtime,
+		preserveEpochs:   rf.preserveEpochs,
+		protocolExt:      rf.protocolExt,
+		dropDeletes:      rf.dropDeletes,
+		maxEvents:        rf.maxEvents,
+		generations:      rf.generations,
+		durable:          rf.durable,
+		perm:             rf.perm,
+		perlYAML:         rf.perlYAML,
+		producer:         rf.producer,
+		producerVersion:  rf.producerVersion,
+		comment:          rf.comment,
 		meta: MetaData{
 			Aggregator:       rf.meta.Aggregator,
 			Protocol:         rf.meta.Protocol,
@@ -419,24 +994,160 @@ func (rf *Recentfile) Update(path, eventType string, dirtyEpoch ...Epoch) error
 
 // BatchUpdate processes multiple events efficiently.
 func (rf *Recentfile) BatchUpdate(batch []BatchItem) error {
+	return rf.BatchUpdateContext(context.Background(), batch)
+}
+
+// BatchUpdateContext is like BatchUpdate, but stops waiting for the lock
+// when ctx is done (see LockContext).
+func (rf *Recentfile) BatchUpdateContext(ctx context.Context, batch []BatchItem) error {
+	_, err := rf.BatchUpdateEventsContext(ctx, batch)
+	return err
+}
+
+// BatchUpdateEvents is like BatchUpdate but also returns the events that were
+// written, with canonical paths and the epochs assigned to them.
+func (rf *Recentfile) BatchUpdateEvents(batch []BatchItem) ([]Event, error) {
+	return rf.BatchUpdateEventsContext(context.Background(), batch)
+}
+
+// BatchUpdateEventsContext is like BatchUpdateEvents, but stops waiting
+// for the lock when ctx is done (see LockContext).
+func (rf *Recentfile) BatchUpdateEventsContext(ctx context.Context, batch []BatchItem) ([]Event, error) {
 	if len(batch) == 0 {
-		return nil
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "BatchUpdate", trace.WithAttributes(
+		attribute.String("interval", rf.Interval()),
+		attribute.Int("events", len(batch)),
+	))
+	rf.mu.RLock()
+	protocolExt := rf.protocolExt
+	rf.mu.RUnlock()
+	if protocolExt {
+		// Before locking, files may be large
+		batch = rf.addFileInfo(batch)
+	}
+	events, err := rf.batchUpdate(ctx, batch)
+	endSpan(span, err)
+	return events, err
+}
+
+// batchUpdate is BatchUpdateEventsContext without the span.
+func (rf *Recentfile) batchUpdate(ctx context.Context, batch []BatchItem) ([]Event, error) {
+	rf.mu.RLock()
+	inMemory := rf.inMemory
+	rf.mu.RUnlock()
+	if inMemory {
+		// Deferred writes: the events in memory are authoritative
+		rf.mu.Lock()
+		processedBatch, err := rf.applyBatch(batch)
+		if err == nil {
+			rf.pending += len(processedBatch)
+		}
+		rf.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if rf.FlushDue() {
+			if err := rf.FlushContext(ctx); err != nil {
+				return nil, fmt.Errorf("write: %w", err)
+			}
+		}
+		return processedBatch, nil
 	}
 
 	// Lock the recentfile
-	if err := rf.Lock(); err != nil {
-		return fmt.Errorf("lock: %w", err)
+	if err := rf.LockContext(ctx); err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
 	}
 	defer rf.Unlock()
 
 	// Read current events (if file exists)
 	if err := rf.Read(); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("read: %w", err)
+		return nil, fmt.Errorf("read: %w", err)
 	}
 
 	rf.mu.Lock()
-	defer rf.mu.Unlock()
+	processedBatch, err := rf.applyBatch(batch)
+	rf.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to disk
+	if err := rf.Write(); err != nil {
+		return nil, fmt.Errorf("write: %w", err)
+	}
+
+	// Update symlink (if this is the principal file)
+	if err := rf.AssertSymlink(); err != nil {
+		// Non-fatal, just log
+		if rf.verbose {
+			fmt.Fprintf(os.Stderr, "warn: assert symlink: %v\n", err)
+		}
+	}
+
+	return processedBatch, nil
+}
+
+// Flush writes events kept in memory by deferred writes (see
+// WithDeferredWrites). It does nothing if none are pending.
+func (rf *Recentfile) Flush() error {
+	return rf.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, but stops waiting for the lock when ctx is
+// done (see LockContext).
+func (rf *Recentfile) FlushContext(ctx context.Context) error {
+	rf.mu.RLock()
+	pending := rf.pending
+	rf.mu.RUnlock()
+	if pending == 0 {
+		return nil
+	}
+
+	if err := rf.LockContext(ctx); err != nil {
+		return fmt.Errorf("lock: %w", err)
+	}
+	err := rf.Write()
+	rf.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := rf.AssertSymlink(); err != nil && rf.verbose {
+		fmt.Fprintf(os.Stderr, "warn: assert symlink: %v\n", err)
+	}
+	return nil
+}
+
+// Pending returns the number of events kept in memory by deferred writes
+// that are not written yet.
+func (rf *Recentfile) Pending() int {
+	rf.mu.RLock()
+	defer rf.mu.RUnlock()
+	return rf.pending
+}
+
+// FlushDue reports whether deferred events are due to be written: the
+// write interval has passed since the last write, or the maximum number
+// of pending events is reached.
+func (rf *Recentfile) FlushDue() bool {
+	rf.mu.RLock()
+	defer rf.mu.RUnlock()
+	if rf.pending == 0 {
+		return false
+	}
+	if rf.deferMax > 0 && rf.pending >= rf.deferMax {
+		return true
+	}
+	return time.Since(rf.lastWrite) >= rf.deferEvery
+}
 
+// applyBatch adds the events of batch to the events in memory, assigning
+// epochs, and returns them. The caller must hold rf.mu.
+func (rf *Recentfile) applyBatch(batch []BatchItem) ([]Event, error) {
 	// Canonicalize paths and assign epochs
 	now := EpochNow()
 	processedBatch := make([]Event, 0, len(batch))
@@ -449,7 +1160,7 @@ func (rf *Recentfile) BatchUpdate(batch []BatchItem) error {
 		// Canonicalize path
 		canonPath, err := rf.canonizePath(item.Path)
 		if err != nil {
-			return fmt.Errorf("canonize path %s: %w", item.Path, err)
+			return nil, fmt.Errorf("canonize path %s: %w", item.Path, err)
 		}
 
 		// Assign epoch
@@ -470,6 +1181,11 @@ func (rf *Recentfile) BatchUpdate(batch []BatchItem) error {
 			Epoch: epoch,
 			Path:  canonPath,
 			Type:  item.Type,
+			Dir:   item.Dir,
+		}
+		if rf.protocolExt && item.Type == "new" {
+			newEvent.Size, newEvent.Sha256 = item.Size, item.Sha256
+			newEvent.Mode, newEvent.UID, newEvent.GID = item.Mode, item.UID, item.GID
 		}
 		processedBatch = append(processedBatch, newEvent)
 
@@ -505,33 +1221,22 @@ func (rf *Recentfile) BatchUpdate(batch []BatchItem) error {
 	// Update producers to reflect current Go implementation
 	rf.updateProducers()
 
-	// Write to disk
-	rf.mu.Unlock()
-	if err := rf.Write(); err != nil {
-		rf.mu.Lock()
-		return fmt.Errorf("write: %w", err)
-	}
-	rf.mu.Lock()
-
-	// Update symlink (if this is the principal file)
-	rf.mu.Unlock()
-	if err := rf.AssertSymlink(); err != nil {
-		rf.mu.Lock()
-		// Non-fatal, just log
-		if rf.verbose {
-			fmt.Fprintf(os.Stderr, "warn: assert symlink: %v\n", err)
-		}
-	} else {
-		rf.mu.Lock()
-	}
-
-	return nil
+	return processedBatch, nil
 }
 
 // canonizePath removes the localroot prefix and normalizes the path.
+// Paths are stored with forward slashes whatever the OS separator, and an
+// absolute path is made relative with filepath.Rel, which also copes with
+// a local root given with a trailing separator or, on Windows, a drive
+// letter in another case.
 func (rf *Recentfile) canonizePath(path string) (string, error) {
 	// Remove localroot prefix
-	path = strings.TrimPrefix(path, rf.localRoot)
+	if rel, ok := rf.relToRoot(path); ok {
+		path = rel
+	} else {
+		path = strings.TrimPrefix(path, rf.localRoot)
+	}
+	path = filepath.ToSlash(path)
 	path = strings.TrimPrefix(path, "/")
 
 	// Apply canonize method (default: naive_path_normalize)
@@ -542,6 +1247,22 @@ func (rf *Recentfile) canonizePath(path string) (string, error) {
 	return path, nil
 }
 
+// relToRoot returns path relative to the local root when both are
+// absolute and path lies within the root.
+func (rf *Recentfile) relToRoot(path string) (string, bool) {
+	if rf.localRoot == "" || !filepath.IsAbs(path) || !filepath.IsAbs(rf.localRoot) {
+		return "", false
+	}
+	rel, err := filepath.Rel(rf.localRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return rel, true
+}
+
 // ensureMonotonic ensures the epoch is greater than the most recent epoch.
 func (rf *Recentfile) ensureMonotonic(epoch Epoch, events []Event) Epoch {
 	if len(events) == 0 {
@@ -579,6 +1300,19 @@ func (rf *Recentfile) truncate(events []Event) []Event {
 	if rf.meta.Merged != nil && !rf.meta.Merged.Epoch.IsZero() {
 		// Use merged epoch as cutoff
 		cutoff = rf.meta.Merged.Epoch
+
+		// Retention: only drop merged events once they are older than the interval
+		// Perl: $oldest_allowed = min($virtualnow - $secs, $merged->{epoch})
+		if !rf.truncateAtMerge {
+			intervalSecs := rf.IntervalSecs()
+			if intervalSecs == ZSeconds {
+				return events
+			}
+			intervalCutoff := EpochFromFloat(EpochToFloat(EpochNow()) - float64(intervalSecs))
+			if EpochLt(intervalCutoff, cutoff) {
+				cutoff = intervalCutoff
+			}
+		}
 	} else {
 		// Calculate cutoff based on interval
 		intervalSecs := rf.IntervalSecs()
@@ -601,7 +1335,23 @@ func (rf *Recentfile) truncate(events []Event) []Event {
 		}
 	}
 
-	return result
+	return rf.capEvents(result)
+}
+
+// capEvents drops the events beyond the cap of the recentfile (see
+// WithMaxEvents) that have been merged into the next interval. The caller
+// must hold rf.mu.
+func (rf *Recentfile) capEvents(events []Event) []Event {
+	limit := rf.maxEvents[rf.interval]
+	merged := rf.meta.Merged
+	if limit <= 0 || len(events) <= limit || merged == nil || merged.Epoch.IsZero() {
+		return events
+	}
+	n := limit
+	for n < len(events) && EpochGt(events[n].Epoch, merged.Epoch) {
+		n++
+	}
+	return events[:n]
 }
 
 // updateMinmax updates the min/max metadata based on current events.
@@ -619,7 +1369,10 @@ func (rf *Recentfile) updateMinmax() {
 	}
 }
 
-// updateProducers updates the Producers field to reflect the current Go implementation.
+// updateProducers records this implementation in the Producers field,
+// along with the executable ("$0") and the time of the update. Entries of
+// earlier producers, such as the Perl implementation when taking over its
+// tree, are kept.
 func (rf *Recentfile) updateProducers() {
 	now := EpochNow()
 
@@ -630,10 +1383,18 @@ func (rf *Recentfile) updateProducers() {
 		exePath = os.Args[0]
 	}
 
-	// Create/update producers map
-	rf.meta.Producers = map[string]interface{}{
-		"$0":                   exePath,
-		"github.com/abh/rrrgo": version.Version(),
-		"time":                 EpochToFloat(now),
+	name, ver := rf.producer, rf.producerVersion
+	if name == "" {
+		name, ver = DefaultProducer, version.Version()
+	}
+
+	// Copy the map, which may be shared with a MetaData returned by Meta
+	producers := make(map[string]interface{}, len(rf.meta.Producers)+3)
+	for key, value := range rf.meta.Producers {
+		producers[key] = value
 	}
+	producers["$0"] = exePath
+	producers[name] = ver
+	producers["time"] = EpochToFloat(now)
+	rf.meta.Producers = producers
 }
@@ -0,0 +1,101 @@
+package recentfile
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EventStreamer is implemented by serializers that can read the events of
+// a recentfile without decoding it whole. StreamEvents decodes files in
+// other formats with Unmarshal.
+type EventStreamer interface {
+	StreamEvents(r io.Reader, stats *StreamStats, batchSize int, callback StreamEventCallback) (*StreamStats, error)
+}
+
+// StreamMarshaler is implemented by serializers that can write a
+// recentfile without building the whole document in memory. The events
+// are consumed as they are written, newest first; a nil sequence is
+// written like a nil slice. Write and MergeFrom use it when available.
+type StreamMarshaler interface {
+	MarshalTo(w io.Writer, meta *MetaData, events iter.Seq2[Event, error]) error
+}
+
+// FormatDetector is implemented by serializers that recognize their data,
+// so a RECENT.recent file that is not a symlink can be read. Data no
+// registered serializer recognizes is taken to be YAML.
+type FormatDetector interface {
+	Detect(data []byte) bool
+}
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = make(map[string]Serializer)
+)
+
+func init() {
+	RegisterSerializer(".yaml", &YAMLSerializer{})
+	RegisterSerializer(".yml", &YAMLSerializer{})
+	RegisterSerializer(".json", &JSONSerializer{})
+	RegisterSerializer(".sereal", &SerealSerializer{})
+	RegisterSerializer(".storable", &StorableSerializer{})
+}
+
+// RegisterSerializer makes a serializer available for recentfiles with the
+// given suffix, such as ".cbor". The compressed and encrypted variants of
+// the suffix (".cbor.gz", ".cbor.zst.enc") are available too.
+//
+// RegisterSerializer is meant to be called from init functions. It panics
+// if s is nil, if suffix is already registered, or if suffix is not a dot
+// followed by a name without dots that could appear in a recentfile name.
+func RegisterSerializer(suffix string, s Serializer) {
+	if s == nil {
+		panic("recentfile: RegisterSerializer serializer is nil")
+	}
+	if len(suffix) < 2 || suffix[0] != '.' || strings.ContainsAny(suffix[1:], "./") || IsEncryptedSuffix(suffix) || isCompressionSuffix(suffix) {
+		panic(fmt.Sprintf("recentfile: RegisterSerializer invalid suffix %q", suffix))
+	}
+
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+	if _, dup := serializers[suffix]; dup {
+		panic(fmt.Sprintf("recentfile: RegisterSerializer called twice for %s", suffix))
+	}
+	serializers[suffix] = s
+}
+
+// SerializerSuffixes returns the registered suffixes, sorted.
+func SerializerSuffixes() []string {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+
+	suffixes := make([]string, 0, len(serializers))
+	for suffix := range serializers {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	return suffixes
+}
+
+// lookupSerializer returns the serializer registered for suffix.
+func lookupSerializer(suffix string) (Serializer, bool) {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+	s, ok := serializers[suffix]
+	return s, ok
+}
+
+// sniffFormat guesses the suffix of serialized data by asking the
+// registered serializers, in suffix order. It defaults to YAML.
+func sniffFormat(data []byte) string {
+	for _, suffix := range SerializerSuffixes() {
+		s, _ := lookupSerializer(suffix)
+		if d, ok := s.(FormatDetector); ok && d.Detect(data) {
+			return suffix
+		}
+	}
+	return ".yaml"
+}
@@ -0,0 +1,120 @@
+package recentfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// prefixedSerializer is JSON behind a magic line, standing in for a
+// format registered by another package.
+type prefixedSerializer struct{}
+
+const prefixedMagic = "TESTFMT\n"
+
+func (s *prefixedSerializer) Marshal(rf *Recentfile) ([]byte, error) {
+	data, err := (&JSONSerializer{}).Marshal(rf)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(prefixedMagic), data...), nil
+}
+
+func (s *prefixedSerializer) Unmarshal(data []byte) (*SerializedData, error) {
+	return (&JSONSerializer{}).Unmarshal(bytes.TrimPrefix(data, []byte(prefixedMagic)))
+}
+
+func (s *prefixedSerializer) Detect(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(prefixedMagic))
+}
+
+func TestRegisterSerializer(t *testing.T) {
+	RegisterSerializer(".testfmt", &prefixedSerializer{})
+
+	if !slices.Contains(SerializerSuffixes(), ".testfmt") {
+		t.Errorf("SerializerSuffixes() = %v", SerializerSuffixes())
+	}
+	if s, err := GetSerializer(".testfmt.enc"); err != nil {
+		t.Errorf("GetSerializer(.testfmt.enc) failed: %v", err)
+	} else if _, ok := s.(*EncryptedSerializer); !ok {
+		t.Errorf("GetSerializer(.testfmt.enc) = %T", s)
+	}
+
+	tmpDir := t.TempDir()
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithSerializerSuffix(".testfmt"),
+	)
+	rf.SetRecentEvents([]Event{
+		{Epoch: 1704207845.5, Path: "b.txt", Type: "new"},
+		{Epoch: 1704207840.5, Path: "a.txt", Type: "delete"},
+	})
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	data, err := os.ReadFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(prefixedMagic)) {
+		t.Errorf("file not written by the registered serializer: %q", data[:min(len(data), 20)])
+	}
+
+	// A RECENT.recent that is not a symlink is recognized by content
+	plain := filepath.Join(tmpDir, "RECENT.recent")
+	if err := os.WriteFile(plain, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{rf.Rfile(), plain} {
+		rf2, err := NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+		}
+		if events := rf2.RecentEvents(); len(events) != 2 || events[1].Path != "a.txt" {
+			t.Errorf("%s: events = %+v", path, events)
+		}
+
+		var streamed []Event
+		stats, err := StreamEvents(path, 1, func(events []Event) bool {
+			streamed = append(streamed, events...)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("StreamEvents(%s) failed: %v", path, err)
+		}
+		if stats.EventCount != 2 || len(streamed) != 2 {
+			t.Errorf("%s: stats = %+v, streamed %d", path, stats, len(streamed))
+		}
+	}
+}
+
+func TestRegisterSerializerInvalid(t *testing.T) {
+	tests := map[string]struct {
+		suffix string
+		s      Serializer
+	}{
+		"duplicate": {".json", &JSONSerializer{}},
+		"nil":       {".nil", nil},
+		"no dot":    {"cbor", &JSONSerializer{}},
+		"two dots":  {".cbor.gz", &JSONSerializer{}},
+		"encrypted": {".enc", &JSONSerializer{}},
+		"slash":     {".a/b", &JSONSerializer{}},
+		"empty":     {"", &JSONSerializer{}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterSerializer(%q) did not panic", tt.suffix)
+				}
+			}()
+			RegisterSerializer(tt.suffix, tt.s)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package recentfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Seed records events that happened before the recentfile was maintained,
+// each with its own (dirty) epoch, e.g. file modification times from a scan
+// of an existing tree. Events older than the interval are left out, and a
+// path already present keeps whichever event is newer. The dirtymark is set
+// to dirtymark so mirrors know history was rewritten; pass the same value to
+// every recentfile of a hierarchy.
+func (rf *Recentfile) Seed(events []Event, dirtymark Epoch) error {
+	if err := rf.Lock(); err != nil {
+		return fmt.Errorf("lock: %w", err)
+	}
+	defer rf.Unlock()
+
+	if err := rf.Read(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	rf.mu.Lock()
+
+	var cutoff Epoch
+	if secs := rf.IntervalSecs(); secs != ZSeconds {
+		cutoff = EpochFromFloat(EpochToFloat(EpochNow()) - float64(secs))
+	}
+
+	latest := make(map[string]Event, len(rf.recent)+len(events))
+	for _, event := range rf.recent {
+		latest[event.Path] = event
+	}
+	for _, event := range events {
+		if EpochLt(event.Epoch, cutoff) {
+			continue
+		}
+		if existing, ok := latest[event.Path]; !ok || EpochGt(event.Epoch, existing.Epoch) {
+			latest[event.Path] = event
+		}
+	}
+
+	merged := make([]Event, 0, len(latest))
+	for _, event := range latest {
+		merged = append(merged, event)
+	}
+	// Scanned events come in any order; avoid the insertion sort
+	sort.Slice(merged, func(i, j int) bool {
+		return EpochGt(merged[i].Epoch, merged[j].Epoch)
+	})
+
+	rf.recent = rf.DeduplicateEpochs(merged)
+	rf.updateMinmax()
+	rf.updateProducers()
+	rf.meta.Dirtymark = dirtymark
+
+	rf.mu.Unlock()
+
+	if err := rf.Write(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,594 @@
+package recentfile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// SerealSerializer handles Sereal serialization, the binary format
+// File::Rsync::Mirror::Recent writes for ".sereal" recentfiles.
+//
+// Documents of protocol versions 1 to 4 are read, uncompressed or
+// compressed with Snappy, zlib or zstd. Written documents use protocol
+// version 3 without compression, which every Sereal::Decoder since 3.0
+// reads. Epochs are written as strings, like the Perl implementation does,
+// so no precision is lost.
+type SerealSerializer struct{}
+
+// Marshal serializes a recentfile to Sereal bytes.
+func (s *SerealSerializer) Marshal(rf *Recentfile) ([]byte, error) {
+	rf.mu.RLock()
+	data := SerializedData{
+		Meta:   rf.meta,
+		Recent: rf.recent,
+	}
+	doc, err := json.Marshal(&data)
+	rf.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("marshal sereal: %w", err)
+	}
+
+	// Go through the JSON form so the field names and omitempty rules
+	// are the same as for the other serializers
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("marshal sereal: %w", err)
+	}
+
+	e := serealEncoder{keys: make(map[string]int)}
+	e.buf.WriteString(serealMagicV3)
+	e.buf.WriteByte(3) // protocol version 3, raw body
+	e.buf.WriteByte(0) // no header suffix
+	e.bodyStart = e.buf.Len() - 1
+	e.encode(v)
+	return e.buf.Bytes(), nil
+}
+
+// Unmarshal deserializes Sereal bytes to SerializedData.
+func (s *SerealSerializer) Unmarshal(data []byte) (*SerializedData, error) {
+	v, size, err := decodeSereal(data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal sereal: %w", err)
+	}
+	sd, err := serializedFromValue(v, size)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal sereal: %w", err)
+	}
+	return sd, nil
+}
+
+const (
+	serealMagicV1   = "=srl"        // protocol versions 1 and 2
+	serealMagicV3   = "=\xf3rl"     // protocol version 3 and later
+	serealMagicUTF8 = "=\xc3\xb3rl" // a version 3 document that was UTF-8 encoded
+)
+
+// Detect reports whether data starts with a Sereal header.
+func (s *SerealSerializer) Detect(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(serealMagicV1)) || bytes.HasPrefix(data, []byte(serealMagicV3))
+}
+
+// Sereal tags. The high bit of a tag marks an item that is referenced
+// later in the document.
+const (
+	serealPosLow      = 0x00 // small positive integers 0 to 15
+	serealNegLow      = 0x10 // small negative integers -16 to -1
+	serealVarint      = 0x20
+	serealZigzag      = 0x21
+	serealFloat       = 0x22
+	serealDouble      = 0x23
+	serealUndef       = 0x25
+	serealBinary      = 0x26
+	serealStrUTF8     = 0x27
+	serealRefN        = 0x28
+	serealRefP        = 0x29
+	serealHash        = 0x2a
+	serealArray       = 0x2b
+	serealAlias       = 0x2e
+	serealCopy        = 0x2f
+	serealWeaken      = 0x30
+	serealCanonUndef  = 0x39
+	serealFalse       = 0x3a
+	serealTrue        = 0x3b
+	serealPad         = 0x3f
+	serealArrayRefLow = 0x40 // references to arrays of 0 to 15 elements
+	serealHashRefLow  = 0x50 // references to hashes of 0 to 15 pairs
+	serealShortBinLow = 0x60 // strings of 0 to 31 bytes
+	serealTrackFlag   = 0x80
+)
+
+// serealMaxDepth limits the nesting of decoded items.
+const serealMaxDepth = 10000
+
+// Sereal body encodings, from the high nibble of the version-type byte.
+const (
+	serealEncodingMask = 0xf0
+
+	serealRaw               = 0
+	serealSnappy            = 1
+	serealSnappyIncremental = 2
+	serealZlib              = 3
+	serealZstd              = 4
+)
+
+// decodeSereal decodes a Sereal document into nil, bool, int64, float64,
+// string, []interface{} and map[string]interface{} values. References are
+// followed transparently and blessed objects are not supported. It also
+// returns the length of the uncompressed body.
+func decodeSereal(data []byte) (interface{}, int, error) {
+	var magicLen int
+	switch {
+	case bytes.HasPrefix(data, []byte(serealMagicV1)), bytes.HasPrefix(data, []byte(serealMagicV3)):
+		magicLen = 4
+	case bytes.HasPrefix(data, []byte(serealMagicUTF8)):
+		return nil, 0, errors.New("document was UTF-8 encoded after serialization")
+	default:
+		return nil, 0, errors.New("not a Sereal document")
+	}
+	if len(data) < magicLen+1 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	versionType := data[magicLen]
+	version := versionType &^ serealEncodingMask
+	encoding := versionType >> 4
+	if version < 1 || version > 4 {
+		return nil, 0, fmt.Errorf("unsupported protocol version %d", version)
+	}
+	if (version >= 3) != (data[1] == serealMagicV3[1]) {
+		return nil, 0, fmt.Errorf("protocol version %d with wrong magic", version)
+	}
+
+	pos := magicLen + 1
+	suffixLen, n := binary.Uvarint(data[pos:])
+	if n <= 0 || suffixLen > uint64(len(data)-pos-n) {
+		return nil, 0, errors.New("invalid header suffix")
+	}
+	pos += n + int(suffixLen)
+	body := data[pos:]
+
+	switch encoding {
+	case serealRaw:
+	case serealSnappy, serealSnappyIncremental:
+		if encoding == serealSnappyIncremental {
+			size, n := binary.Uvarint(body)
+			if n <= 0 || size > uint64(len(body)-n) {
+				return nil, 0, errors.New("invalid snappy body length")
+			}
+			body = body[n : n+int(size)]
+		}
+		var err error
+		if body, err = snappy.Decode(nil, body); err != nil {
+			return nil, 0, fmt.Errorf("snappy: %w", err)
+		}
+	case serealZlib, serealZstd:
+		size, n := binary.Uvarint(body)
+		if n <= 0 {
+			return nil, 0, errors.New("invalid compressed body length")
+		}
+		body = body[n:]
+		clen, n := binary.Uvarint(body)
+		if n <= 0 || clen > uint64(len(body)-n) {
+			return nil, 0, errors.New("invalid compressed body length")
+		}
+		compressed := body[n : n+int(clen)]
+		var err error
+		if encoding == serealZlib {
+			body, err = inflateZlib(compressed, size)
+		} else {
+			body, err = inflateZstd(compressed, size)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("unsupported body encoding %d", encoding)
+	}
+
+	// Offsets count from the start of the document in version 1 and from
+	// the start of the body, starting at 1, in later versions
+	// Every item takes at least a byte, except COPYs of strings and
+	// references to items still being decoded, which are decoded again
+	d := serealDecoder{data: body, base: 1, tracked: make(map[int]interface{}), budget: len(body)}
+	if version == 1 {
+		d.base = pos
+	}
+	v, err := d.decode(0)
+	return v, len(body), err
+}
+
+func inflateZlib(compressed []byte, size uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("zlib: %w", err)
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return nil, fmt.Errorf("zlib: %w", err)
+	}
+	if uint64(len(body)) != size {
+		return nil, errors.New("zlib: body length mismatch")
+	}
+	return body, nil
+}
+
+func inflateZstd(compressed []byte, size uint64) ([]byte, error) {
+	zr, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	defer zr.Close()
+	body, err := zr.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	if uint64(len(body)) != size {
+		return nil, errors.New("zstd: body length mismatch")
+	}
+	return body, nil
+}
+
+// serealDecoder reads the items of a Sereal body.
+type serealDecoder struct {
+	data []byte
+	pos  int
+	// base is the offset of data[0] in the document's offset space
+	base int
+	// tracked holds the decoded items with the track flag, by offset
+	tracked map[int]interface{}
+	// budget is how many more items may be decoded
+	budget int
+}
+
+func (d *serealDecoder) decode(depth int) (interface{}, error) {
+	if depth > serealMaxDepth {
+		return nil, errors.New("nesting too deep")
+	}
+
+	if d.budget--; d.budget < 0 {
+		return nil, errors.New("too many items")
+	}
+
+	start := d.pos
+	if start >= len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	tag := d.data[start]
+	d.pos++
+
+	v, err := d.decodeTag(tag&^serealTrackFlag, depth)
+	if err != nil {
+		return nil, err
+	}
+	if tag&serealTrackFlag != 0 {
+		d.tracked[start+d.base] = v
+	}
+	return v, nil
+}
+
+func (d *serealDecoder) decodeTag(tag byte, depth int) (interface{}, error) {
+	switch {
+	case tag < serealNegLow:
+		return int64(tag), nil
+	case tag < serealVarint:
+		return int64(tag) - 32, nil
+	case tag >= serealShortBinLow:
+		return d.readString(int(tag - serealShortBinLow))
+	case tag >= serealHashRefLow:
+		return d.readHash(int(tag-serealHashRefLow), depth)
+	case tag >= serealArrayRefLow:
+		return d.readArray(int(tag-serealArrayRefLow), depth)
+	}
+
+	switch tag {
+	case serealVarint:
+		u, err := d.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if u > math.MaxInt64 {
+			return float64(u), nil
+		}
+		return int64(u), nil
+	case serealZigzag:
+		u, err := d.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		return int64(u>>1) ^ -int64(u&1), nil
+	case serealFloat:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case serealDouble:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case serealUndef, serealCanonUndef:
+		return nil, nil
+	case serealFalse:
+		return false, nil
+	case serealTrue:
+		return true, nil
+	case serealBinary, serealStrUTF8:
+		n, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case serealRefN, serealWeaken:
+		return d.decode(depth + 1)
+	case serealHash, serealArray:
+		n, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if tag == serealHash {
+			return d.readHash(n, depth)
+		}
+		return d.readArray(n, depth)
+	case serealRefP, serealAlias:
+		offset, err := d.readOffset()
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := d.tracked[offset+d.base]; ok {
+			return v, nil
+		}
+		return d.decodeAt(offset, depth)
+	case serealCopy:
+		offset, err := d.readOffset()
+		if err != nil {
+			return nil, err
+		}
+		// Sereal::Encoder copies only strings, hash keys among them
+		if !isSerealString(d.data[offset] &^ serealTrackFlag) {
+			return nil, fmt.Errorf("COPY of a tag 0x%02x item at offset %d", d.data[offset], offset+d.base)
+		}
+		return d.decodeAt(offset, depth)
+	case serealPad:
+		return d.decode(depth)
+	default:
+		return nil, fmt.Errorf("unsupported tag 0x%02x at offset %d", tag, d.pos-1+d.base)
+	}
+}
+
+// isSerealString reports whether tag starts a string.
+func isSerealString(tag byte) bool {
+	return tag >= serealShortBinLow || tag == serealBinary || tag == serealStrUTF8
+}
+
+// decodeAt decodes the item at an earlier offset, for COPY and for
+// references to items that are still being decoded.
+func (d *serealDecoder) decodeAt(offset, depth int) (interface{}, error) {
+	saved := d.pos
+	d.pos = offset
+	v, err := d.decode(depth + 1)
+	d.pos = saved
+	return v, err
+}
+
+func (d *serealDecoder) readHash(n, depth int) (interface{}, error) {
+	m := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("hash key is %T, not a string", k)
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (d *serealDecoder) readArray(n, depth int) (interface{}, error) {
+	a := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (d *serealDecoder) read(n int) ([]byte, error) {
+	if n > len(d.data)-d.pos {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *serealDecoder) readString(n int) (interface{}, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *serealDecoder) readVarint() (uint64, error) {
+	u, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errors.New("invalid varint")
+	}
+	d.pos += n
+	return u, nil
+}
+
+// readLength reads a string length or element count. Counts larger than
+// the rest of the body are rejected before anything is allocated.
+func (d *serealDecoder) readLength() (int, error) {
+	u, err := d.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if u > uint64(len(d.data)-d.pos) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(u), nil
+}
+
+// readOffset reads an offset and checks that it points before the current
+// item, which also keeps cyclic references from recursing forever.
+func (d *serealDecoder) readOffset() (int, error) {
+	start := d.pos - 1
+	u, err := d.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if u < uint64(d.base) || u-uint64(d.base) >= uint64(start) {
+		return 0, fmt.Errorf("invalid offset %d", u)
+	}
+	return int(u) - d.base, nil
+}
+
+// serealEncoder writes the body of a Sereal document.
+type serealEncoder struct {
+	buf bytes.Buffer
+	// bodyStart is the position of offset 0 in buf; body offsets start at 1
+	bodyStart int
+	// keys maps hash keys already written to their offset, so repeated
+	// keys are written as COPY tags like Sereal::Encoder does
+	keys map[string]int
+}
+
+func (e *serealEncoder) encode(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.buf.WriteByte(serealUndef)
+	case bool:
+		if v {
+			e.buf.WriteByte(serealTrue)
+		} else {
+			e.buf.WriteByte(serealFalse)
+		}
+	case json.Number:
+		// Integers stay integers; epochs are written as the
+		// decimal strings they came from
+		if i, err := v.Int64(); err == nil {
+			e.encodeInt(i)
+		} else {
+			e.encodeString(v.String())
+		}
+	case string:
+		e.encodeString(v)
+	case []interface{}:
+		if len(v) < 16 {
+			e.buf.WriteByte(serealArrayRefLow + byte(len(v)))
+		} else {
+			e.buf.WriteByte(serealRefN)
+			e.buf.WriteByte(serealArray)
+			e.writeVarint(uint64(len(v)))
+		}
+		for _, item := range v {
+			e.encode(item)
+		}
+	case map[string]interface{}:
+		if len(v) < 16 {
+			e.buf.WriteByte(serealHashRefLow + byte(len(v)))
+		} else {
+			e.buf.WriteByte(serealRefN)
+			e.buf.WriteByte(serealHash)
+			e.writeVarint(uint64(len(v)))
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.encodeKey(k)
+			e.encode(v[k])
+		}
+	default:
+		// JSON values decoded with UseNumber have no other types
+		panic(fmt.Sprintf("sereal: unexpected %T", v))
+	}
+}
+
+func (e *serealEncoder) encodeInt(i int64) {
+	switch {
+	case i >= 0 && i < 16:
+		e.buf.WriteByte(serealPosLow + byte(i))
+	case i >= -16 && i < 0:
+		e.buf.WriteByte(byte(i + 32))
+	case i >= 0:
+		e.buf.WriteByte(serealVarint)
+		e.writeVarint(uint64(i))
+	default:
+		e.buf.WriteByte(serealZigzag)
+		e.writeVarint(uint64(i<<1) ^ uint64(i>>63))
+	}
+}
+
+func (e *serealEncoder) encodeString(s string) {
+	switch {
+	case !isASCII(s) && utf8.ValidString(s):
+		e.buf.WriteByte(serealStrUTF8)
+		e.writeVarint(uint64(len(s)))
+	case len(s) < 32:
+		e.buf.WriteByte(serealShortBinLow + byte(len(s)))
+	default:
+		e.buf.WriteByte(serealBinary)
+		e.writeVarint(uint64(len(s)))
+	}
+	e.buf.WriteString(s)
+}
+
+// encodeKey writes a hash key, or a COPY of the same key written earlier
+// when that is shorter.
+func (e *serealEncoder) encodeKey(k string) {
+	if offset, ok := e.keys[k]; ok {
+		var tmp [binary.MaxVarintLen64]byte
+		if 1+binary.PutUvarint(tmp[:], uint64(offset)) < 1+len(k) {
+			e.buf.WriteByte(serealCopy)
+			e.writeVarint(uint64(offset))
+			return
+		}
+	}
+	e.keys[k] = e.buf.Len() - e.bodyStart
+	e.encodeString(k)
+}
+
+func (e *serealEncoder) writeVarint(u uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf.Write(tmp[:binary.PutUvarint(tmp[:], u)])
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
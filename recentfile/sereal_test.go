@@ -0,0 +1,276 @@
+package recentfile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSerealWriteAndRead(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithSerializerSuffix(".sereal"),
+	)
+	var events []Event
+	for i := 0; i < 20; i++ {
+		events = append(events, Event{Epoch: Epoch(1704207845.123456 - float64(i)), Path: "dír/file.txt", Type: "new"})
+	}
+	events[3] = Event{Epoch: 1704207842, Path: "gone.txt", Type: "delete"}
+	rf.SetRecentEvents(events)
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := rf.AssertSymlink(); err != nil {
+		t.Fatalf("AssertSymlink failed: %v", err)
+	}
+
+	data, err := os.ReadFile(rf.Rfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("=\xf3rl\x03")) {
+		t.Errorf("header = %q", data[:5])
+	}
+	plain := filepath.Join(tmpDir, "plain.recent")
+	if err := os.WriteFile(plain, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{rf.Rfile(), filepath.Join(tmpDir, "RECENT.recent"), plain} {
+		rf2, err := NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+		}
+		got := rf2.RecentEvents()
+		if len(got) != len(events) {
+			t.Fatalf("%s: %d events, want %d", path, len(got), len(events))
+		}
+		for i := range events {
+			if got[i] != events[i] {
+				t.Errorf("%s: event %d = %+v, want %+v", path, i, got[i], events[i])
+			}
+		}
+		if rf2.Meta().Interval != "1h" || rf2.Meta().SerializerSuffix != ".sereal" {
+			t.Errorf("%s: meta = %+v", path, rf2.Meta())
+		}
+
+		var streamed []Event
+		stats, err := StreamEvents(path, 7, func(events []Event) bool {
+			streamed = append(streamed, events...)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("StreamEvents(%s) failed: %v", path, err)
+		}
+		if stats.EventCount != len(events) || len(streamed) != len(events) {
+			t.Errorf("%s: stats = %+v, streamed %d", path, stats, len(streamed))
+		}
+	}
+}
+
+// serealBody builds a document body the way Sereal::Encoder writes
+// recentfiles: a hash reference with repeated keys as COPY tags, string
+// epochs, and a tracked value referenced again with ALIAS. base is the
+// offset of the first body byte.
+func serealBody(base int) []byte {
+	var b []byte
+	offsets := make(map[string]int)
+	str := func(s string) {
+		offsets[s] = base + len(b)
+		b = append(b, byte(serealShortBinLow+len(s)))
+		b = append(b, s...)
+	}
+	ref := func(tag byte, s string) {
+		b = append(b, tag)
+		b = binary.AppendUvarint(b, uint64(offsets[s]))
+	}
+
+	b = append(b, serealRefN, serealHash, 2)
+	str("meta")
+	b = append(b, serealHashRefLow+3)
+	str("interval")
+	str("1h")
+	str("protocol")
+	b = append(b, serealPosLow+1)
+	str("serializer_suffix")
+	str(".sereal")
+	str("recent")
+	b = append(b, serealArrayRefLow+2)
+
+	b = append(b, serealHashRefLow+3)
+	str("epoch")
+	str("1704207845.123456")
+	str("path")
+	str("a.txt")
+	str("type")
+	offsets["new"] = base + len(b)
+	b = append(b, serealTrackFlag|serealShortBinLow+3)
+	b = append(b, "new"...)
+
+	b = append(b, serealHashRefLow+3)
+	ref(serealCopy, "epoch")
+	b = append(b, serealDouble)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(1704207800.5))
+	ref(serealCopy, "path")
+	b = append(b, serealStrUTF8, 10)
+	b = append(b, "dír/b.txt"...)
+	b = append(b, serealPad)
+	ref(serealCopy, "type")
+	ref(serealAlias, "new")
+	return b
+}
+
+func TestSerealUnmarshalPerl(t *testing.T) {
+	body := serealBody(1)
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(body)
+	zw.Close()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zs := enc.EncodeAll(body, nil)
+	enc.Close()
+
+	sn := snappy.Encode(nil, body)
+
+	compressed := func(header string, size int, data []byte) []byte {
+		doc := []byte(header)
+		doc = binary.AppendUvarint(doc, uint64(size))
+		doc = binary.AppendUvarint(doc, uint64(len(data)))
+		return append(doc, data...)
+	}
+
+	docs := map[string][]byte{
+		"v1":                 append([]byte("=srl\x01\x00"), serealBody(6)...),
+		"v2":                 append([]byte("=srl\x02\x00"), body...),
+		"v3":                 append([]byte("=\xf3rl\x03\x00"), body...),
+		"v3 header suffix":   append([]byte("=\xf3rl\x03\x02\x01\x00"), body...),
+		"v2 snappy":          append(binary.AppendUvarint([]byte("=srl\x22\x00"), uint64(len(sn))), sn...),
+		"v3 zlib":            compressed("=\xf3rl\x33\x00", len(body), z.Bytes()),
+		"v4 zstd":            compressed("=\xf3rl\x44\x00", len(body), zs),
+		"v1 legacy snappy":   append([]byte("=srl\x11\x00"), snappy.Encode(nil, serealBody(6))...),
+		"v3 zlib, wrong len": compressed("=\xf3rl\x33\x00", len(body)+1, z.Bytes()),
+	}
+
+	want := []Event{
+		{Epoch: 1704207845.123456, Path: "a.txt", Type: "new"},
+		{Epoch: 1704207800.5, Path: "dír/b.txt", Type: "new"},
+	}
+
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			if got := sniffFormat(doc); got != ".sereal" {
+				t.Errorf("sniffFormat = %q", got)
+			}
+
+			sd, err := (&SerealSerializer{}).Unmarshal(doc)
+			if name == "v3 zlib, wrong len" {
+				if err == nil {
+					t.Error("expected error for wrong body length")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if sd.Meta.Interval != "1h" || sd.Meta.Protocol != 1 || sd.Meta.SerializerSuffix != ".sereal" {
+				t.Errorf("meta = %+v", sd.Meta)
+			}
+			if len(sd.Recent) != len(want) {
+				t.Fatalf("events = %+v", sd.Recent)
+			}
+			for i := range want {
+				if sd.Recent[i] != want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, sd.Recent[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+// serealShared builds a document whose "recent" array holds arrays of two
+// references to the one before, so it doubles with each of n levels.
+func serealShared(n int) []byte {
+	b := []byte("=\xf3rl\x03\x00")
+	b = append(b, serealRefN, serealHash, 1, serealShortBinLow+6)
+	b = append(b, "recent"...)
+	b = append(b, serealArray)
+	b = binary.AppendUvarint(b, uint64(n+1))
+	prev := len(b) - 5 // body offsets start at 1
+	b = append(b, serealTrackFlag|serealArrayRefLow)
+	for i := 0; i < n; i++ {
+		offset := len(b) - 5
+		b = append(b, serealTrackFlag|serealArrayRefLow+2)
+		for range 2 {
+			b = append(b, serealRefP)
+			b = binary.AppendUvarint(b, uint64(prev))
+		}
+		prev = offset
+	}
+	return b
+}
+
+func TestSerealUnmarshalShared(t *testing.T) {
+	doc := serealShared(40)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := (&SerealSerializer{}).Unmarshal(doc)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Unmarshal of shared items did not finish")
+	}
+
+	// A few levels are fine
+	if _, _, err := decodeSereal(serealShared(3)); err != nil {
+		t.Errorf("decodeSereal: %v", err)
+	}
+}
+
+func TestSerealUnmarshalInvalid(t *testing.T) {
+	body := serealBody(1)
+
+	tests := map[string][]byte{
+		"empty":          nil,
+		"json":           []byte(`{"meta":{}}`),
+		"utf8 encoded":   append([]byte("=\xc3\xb3rl\x03\x00"), body...),
+		"wrong magic":    append([]byte("=srl\x03\x00"), body...),
+		"version 5":      append([]byte("=\xf3rl\x05\x00"), body...),
+		"truncated":      append([]byte("=\xf3rl\x03\x00"), body[:len(body)-2]...),
+		"forward offset": []byte("=\xf3rl\x03\x00\x2f\x05\x00\x00\x00\x00"),
+		"self alias":     []byte("=\xf3rl\x03\x00\x2e\x01"),
+		"not a hash":     []byte("=\xf3rl\x03\x00\x42\x01\x02"),
+		"huge count":     []byte("=\xf3rl\x03\x00\x2b\xff\xff\xff\xff\x0f"),
+		"unsupported":    []byte("=\xf3rl\x03\x00\x2c\x61a\x50"),
+		"copy of hash":   []byte("=\xf3rl\x03\x00\x42\x50\x2f\x02"),
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := (&SerealSerializer{}).Unmarshal(doc); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
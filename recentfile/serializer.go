@@ -1,16 +1,37 @@
 package recentfile
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"iter"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// ErrUnsupportedFormat is returned for a RECENT file in a format no
+// serializer is registered for (see RegisterSerializer).
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// ErrCorrupt is returned for a RECENT file whose contents don't parse.
+// ReadTolerant recovers what it can of such a file.
+var ErrCorrupt = errors.New("corrupt recentfile")
+
 // Serializer is the interface for marshaling and unmarshaling recentfiles.
+// Serializers are registered by suffix with RegisterSerializer and may
+// also implement EventStreamer and FormatDetector.
 type Serializer interface {
 	Marshal(rf *Recentfile) ([]byte, error)
 	Unmarshal(data []byte) (*SerializedData, error)
@@ -22,8 +43,277 @@ type SerializedData struct {
 	Recent []Event  `yaml:"recent" json:"recent"`
 }
 
+// fileEvent is how an Event is (un)marshaled in a recentfile. It keeps
+// the original text of the epoch (see WithPreserveEpochs); Event itself
+// has no marshaling methods, so types embedding it are unaffected.
+type fileEvent Event
+
+// eventFields is Event without the marshaling methods of fileEvent.
+type eventFields Event
+
+// keptEpochText returns the original text of the event's epoch, if it was
+// kept and still parses to Epoch.
+func (e *fileEvent) keptEpochText() string {
+	if e.epochText == "" {
+		return ""
+	}
+	if f, err := strconv.ParseFloat(e.epochText, 64); err != nil || Epoch(f) != e.Epoch {
+		return ""
+	}
+	return e.epochText
+}
+
+// MarshalJSON implements json.Marshaler, writing the epoch in its original
+// form if it was kept.
+func (e *fileEvent) MarshalJSON() ([]byte, error) {
+	text := e.keptEpochText()
+	if text == "" {
+		return json.Marshal((*eventFields)(e))
+	}
+	return json.Marshal(struct {
+		Epoch  json.Number `json:"epoch"`
+		Path   string      `json:"path"`
+		Type   string      `json:"type"`
+		Size   int64       `json:"size,omitempty"`
+		Sha256 string      `json:"sha256,omitempty"`
+		Mode   uint32      `json:"mode,omitempty"`
+		UID    int         `json:"uid,omitempty"`
+		GID    int         `json:"gid,omitempty"`
+		Dir    bool        `json:"dir,omitempty"`
+	}{json.Number(text), e.Path, e.Type, e.Size, e.Sha256, e.Mode, e.UID, e.GID, e.Dir})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Epochs may be numbers or
+// strings (from Perl); their text is kept if writing the parsed epoch
+// would change it.
+func (e *fileEvent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var aux struct {
+		Epoch  json.RawMessage `json:"epoch"`
+		Path   string          `json:"path"`
+		Type   string          `json:"type"`
+		Size   int64           `json:"size"`
+		Sha256 string          `json:"sha256"`
+		Mode   uint32          `json:"mode"`
+		UID    int             `json:"uid"`
+		GID    int             `json:"gid"`
+		Dir    bool            `json:"dir"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*e = fileEvent{
+		Path: aux.Path, Type: aux.Type,
+		Size: aux.Size, Sha256: aux.Sha256,
+		Mode: aux.Mode, UID: aux.UID, GID: aux.GID,
+		Dir: aux.Dir,
+	}
+
+	if len(aux.Epoch) == 0 || string(aux.Epoch) == "null" {
+		return nil
+	}
+	text := string(aux.Epoch)
+	if aux.Epoch[0] == '"' {
+		if err := json.Unmarshal(aux.Epoch, &text); err != nil {
+			return err
+		}
+	}
+	epoch, kept, err := parseEpochText(text)
+	if err != nil {
+		return err
+	}
+	e.Epoch, e.epochText = epoch, kept
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, writing the epoch in its original
+// form if it was kept.
+func (e *fileEvent) MarshalYAML() (interface{}, error) {
+	text := e.keptEpochText()
+	if text == "" {
+		return (*eventFields)(e), nil
+	}
+	return struct {
+		Epoch  yaml.Node `yaml:"epoch"`
+		Path   string    `yaml:"path"`
+		Type   string    `yaml:"type"`
+		Size   int64     `yaml:"size,omitempty"`
+		Sha256 string    `yaml:"sha256,omitempty"`
+		Mode   uint32    `yaml:"mode,omitempty"`
+		UID    int       `yaml:"uid,omitempty"`
+		GID    int       `yaml:"gid,omitempty"`
+		Dir    bool      `yaml:"dir,omitempty"`
+	}{yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: text}, e.Path, e.Type, e.Size, e.Sha256, e.Mode, e.UID, e.GID, e.Dir}, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, keeping the text of the
+// epoch like UnmarshalJSON.
+func (e *fileEvent) UnmarshalYAML(node *yaml.Node) error {
+	var aux struct {
+		Epoch  yaml.Node `yaml:"epoch"`
+		Path   string    `yaml:"path"`
+		Type   string    `yaml:"type"`
+		Size   int64     `yaml:"size"`
+		Sha256 string    `yaml:"sha256"`
+		Mode   uint32    `yaml:"mode"`
+		UID    int       `yaml:"uid"`
+		GID    int       `yaml:"gid"`
+		Dir    bool      `yaml:"dir"`
+	}
+	if err := node.Decode(&aux); err != nil {
+		return err
+	}
+	*e = fileEvent{
+		Path: aux.Path, Type: aux.Type,
+		Size: aux.Size, Sha256: aux.Sha256,
+		Mode: aux.Mode, UID: aux.UID, GID: aux.GID,
+		Dir: aux.Dir,
+	}
+
+	switch {
+	case aux.Epoch.Kind == 0 || aux.Epoch.ShortTag() == "!!null":
+		return nil
+	case aux.Epoch.Kind != yaml.ScalarNode:
+		return fmt.Errorf("line %d: epoch is not a number", aux.Epoch.Line)
+	}
+	epoch, kept, err := parseEpochText(aux.Epoch.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", aux.Epoch.Line, err)
+	}
+	e.Epoch, e.epochText = epoch, kept
+	return nil
+}
+
+// eventList is the list of events of a recentfile, with each event
+// (un)marshaled as a fileEvent.
+type eventList []Event
+
+// MarshalJSON implements json.Marshaler.
+func (l eventList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i := range l {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data, err := (*fileEvent)(&l[i]).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *eventList) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*l = nil
+		return nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	events := make([]Event, len(raw))
+	for i := range raw {
+		if err := (*fileEvent)(&events[i]).UnmarshalJSON(raw[i]); err != nil {
+			return err
+		}
+	}
+	*l = events
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (l eventList) MarshalYAML() (interface{}, error) {
+	if l == nil {
+		return nil, nil
+	}
+	events := make([]*fileEvent, len(l))
+	for i := range l {
+		events[i] = (*fileEvent)(&l[i])
+	}
+	return events, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *eventList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var events []Event
+		if err := node.Decode(&events); err != nil {
+			return err
+		}
+		*l = events
+		return nil
+	}
+	events := make([]Event, len(node.Content))
+	for i, item := range node.Content {
+		if err := (*fileEvent)(&events[i]).UnmarshalYAML(item); err != nil {
+			return err
+		}
+	}
+	*l = events
+	return nil
+}
+
+// serializedFields is SerializedData with the events (un)marshaled as an
+// eventList.
+type serializedFields struct {
+	Meta   MetaData  `yaml:"meta" json:"meta"`
+	Recent eventList `yaml:"recent" json:"recent"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (sd *SerializedData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&serializedFields{Meta: sd.Meta, Recent: sd.Recent})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (sd *SerializedData) UnmarshalJSON(data []byte) error {
+	aux := serializedFields{Meta: sd.Meta, Recent: sd.Recent}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	sd.Meta, sd.Recent = aux.Meta, aux.Recent
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (sd *SerializedData) MarshalYAML() (interface{}, error) {
+	return &serializedFields{Meta: sd.Meta, Recent: sd.Recent}, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (sd *SerializedData) UnmarshalYAML(node *yaml.Node) error {
+	aux := serializedFields{Meta: sd.Meta, Recent: sd.Recent}
+	if err := node.Decode(&aux); err != nil {
+		return err
+	}
+	sd.Meta, sd.Recent = aux.Meta, aux.Recent
+	return nil
+}
+
+// dropEpochText forgets the original text of the events' epochs.
+func dropEpochText(events []Event) {
+	for i := range events {
+		events[i].epochText = ""
+	}
+}
+
 // YAMLSerializer handles YAML serialization.
-type YAMLSerializer struct{}
+type YAMLSerializer struct {
+	// PerlCompat writes YAML the way the Perl implementation does (see
+	// WithPerlYAML). Recentfiles with WithPerlYAML are written this way
+	// regardless.
+	PerlCompat bool
+}
 
 // Marshal serializes a recentfile to YAML bytes.
 func (s *YAMLSerializer) Marshal(rf *Recentfile) ([]byte, error) {
@@ -35,6 +325,9 @@ func (s *YAMLSerializer) Marshal(rf *Recentfile) ([]byte, error) {
 		Recent: rf.recent,
 	}
 
+	if s.PerlCompat || rf.perlYAML {
+		return marshalPerlYAML(&data)
+	}
 	return yaml.Marshal(&data)
 }
 
@@ -55,12 +348,55 @@ func (s *JSONSerializer) Marshal(rf *Recentfile) ([]byte, error) {
 	rf.mu.RLock()
 	defer rf.mu.RUnlock()
 
-	data := SerializedData{
-		Meta:   rf.meta,
-		Recent: rf.recent,
+	var buf bytes.Buffer
+	if err := s.MarshalTo(&buf, &rf.meta, sliceEvents(rf.recent)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalTo writes a recentfile to w as indented JSON, one event at a
+// time, so memory use does not grow with the number of events. The output
+// is the same as json.MarshalIndent's.
+func (s *JSONSerializer) MarshalTo(w io.Writer, meta *MetaData, events iter.Seq2[Event, error]) error {
+	metaJSON, err := json.MarshalIndent(meta, "  ", "  ")
+	if err != nil {
+		return err
 	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{\n  \"meta\": ")
+	bw.Write(metaJSON)
+	bw.WriteString(",\n  \"recent\": ")
 
-	return json.MarshalIndent(&data, "", "  ")
+	if events == nil {
+		bw.WriteString("null")
+	} else {
+		n := 0
+		for event, err := range events {
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				bw.WriteString("[\n    ")
+			} else {
+				bw.WriteString(",\n    ")
+			}
+			data, err := json.MarshalIndent((*fileEvent)(&event), "    ", "  ")
+			if err != nil {
+				return err
+			}
+			bw.Write(data)
+			n++
+		}
+		if n == 0 {
+			bw.WriteString("[]")
+		} else {
+			bw.WriteString("\n  ]")
+		}
+	}
+
+	bw.WriteString("\n}")
+	return bw.Flush()
 }
 
 // Unmarshal deserializes JSON bytes to SerializedData.
@@ -72,16 +408,67 @@ func (s *JSONSerializer) Unmarshal(data []byte) (*SerializedData, error) {
 	return &sd, nil
 }
 
-// GetSerializer returns the appropriate serializer for the given suffix.
+// Detect reports whether data looks like JSON: its first non-blank
+// character is an opening brace.
+func (s *JSONSerializer) Detect(data []byte) bool {
+	// Read first 512 bytes max for detection
+	sample := data
+	if len(sample) > 512 {
+		sample = sample[:512]
+	}
+
+	trimmed := bytes.TrimLeft(sample, " \t\n\r")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// EncryptedSerializer encrypts the output of another serializer with the
+// process-wide key (see SetKey).
+type EncryptedSerializer struct {
+	Inner Serializer
+}
+
+// Marshal serializes and encrypts a recentfile.
+func (s *EncryptedSerializer) Marshal(rf *Recentfile) ([]byte, error) {
+	data, err := s.Inner.Marshal(rf)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(data)
+}
+
+// Unmarshal decrypts and deserializes data.
+func (s *EncryptedSerializer) Unmarshal(data []byte) (*SerializedData, error) {
+	plain, err := decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.Inner.Unmarshal(plain)
+}
+
+// GetSerializer returns the serializer registered for the given suffix.
+// Suffixes ending in ".enc" (e.g. ".json.enc") return an EncryptedSerializer,
+// and compressed suffixes (e.g. ".json.gz") a CompressedSerializer.
 func GetSerializer(suffix string) (Serializer, error) {
-	switch suffix {
-	case ".yaml", ".yml":
-		return &YAMLSerializer{}, nil
-	case ".json":
-		return &JSONSerializer{}, nil
-	default:
-		return nil, fmt.Errorf("unsupported serializer suffix: %s", suffix)
+	if IsEncryptedSuffix(suffix) {
+		inner, err := GetSerializer(plainSuffix(suffix))
+		if err != nil {
+			return nil, err
+		}
+		return &EncryptedSerializer{Inner: inner}, nil
+	}
+	if plain, compression := splitCompression(suffix); compression != "" {
+		inner, err := GetSerializer(plain)
+		if err != nil {
+			return nil, err
+		}
+		return &CompressedSerializer{Inner: inner, Compression: compression}, nil
+	}
+
+	s, ok := lookupSerializer(suffix)
+	if !ok {
+		return nil, fmt.Errorf("%w: serializer suffix %s", ErrUnsupportedFormat, suffix)
 	}
+	return s, nil
 }
 
 // Marshal serializes a recentfile using its configured serializer.
@@ -94,17 +481,31 @@ func (rf *Recentfile) Marshal() ([]byte, error) {
 }
 
 // Unmarshal deserializes data into a recentfile using the given suffix.
+// Data that doesn't parse gives an error wrapping ErrCorrupt.
 func Unmarshal(data []byte, suffix string) (*SerializedData, error) {
 	serializer, err := GetSerializer(suffix)
 	if err != nil {
 		return nil, err
 	}
-	return serializer.Unmarshal(data)
+	sd, err := serializer.Unmarshal(data)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	return sd, nil
+}
+
+// corrupt returns err, of data that failed to parse, wrapping ErrCorrupt,
+// unless the data couldn't be decrypted for want of a key.
+func corrupt(err error) error {
+	if errors.Is(err, ErrNoKey) || errors.Is(err, ErrCorrupt) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrCorrupt, err)
 }
 
 // detectFormat attempts to detect the serialization format of a RECENT file.
 // It first tries to resolve symlinks, then falls back to content sniffing.
-// Returns the detected suffix (e.g., ".yaml", ".json") and any error.
+// Returns the detected suffix (e.g., ".yaml", ".json", ".sereal") and any error.
 func detectFormat(path string) (string, error) {
 	// Check if file exists
 	if _, err := os.Stat(path); err != nil {
@@ -128,37 +529,52 @@ func detectFormat(path string) (string, error) {
 		return "", fmt.Errorf("read %s: %w", path, err)
 	}
 
+	suffix, err := detectDataFormat(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return suffix, nil
+}
+
+// detectDataFormat returns the suffix of the format the contents of a
+// RECENT file are serialized in.
+func detectDataFormat(data []byte) (string, error) {
 	// Empty file - default to YAML
 	if len(data) == 0 {
 		return ".yaml", nil
 	}
 
-	// Read first 512 bytes max for detection
-	sample := data
-	if len(sample) > 512 {
-		sample = sample[:512]
+	// Encrypted or compressed file - sniff the plain content
+	var suffix string
+	var err error
+	if isEncrypted(data) {
+		if data, err = decrypt(data); err != nil {
+			return "", err
+		}
+		suffix = EncryptedSuffix
 	}
-
-	// Trim whitespace and check first character
-	trimmed := string(sample)
-	for i, c := range trimmed {
-		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
-			if trimmed[i] == '{' {
-				return ".json", nil
-			}
-			break
+	if compression := compressionOf(data); compression != "" {
+		if data, err = decompress(data); err != nil {
+			return "", err
 		}
+		suffix = compression + suffix
 	}
 
-	// Default to YAML
-	return ".yaml", nil
+	return sniffFormat(data) + suffix, nil
 }
 
 // Write writes the recentfile atomically to disk.
-// Writes to a temporary file (.new), then renames to the target.
+// Writes to a temporary file (see TempName), then renames to the target.
+// Serializers that implement StreamMarshaler write the temporary file
+// directly instead of marshaling the whole file in memory first.
+// With a signing key set (see SetSigningKey) its signature is written
+// next to it.
 func (rf *Recentfile) Write() error {
-	// Marshal the data
-	data, err := rf.Marshal()
+	if rf.fetcher != nil {
+		return fmt.Errorf("write %s: %w", rf.Rfile(), ErrFetched)
+	}
+
+	serializer, err := GetSerializer(rf.serializerSuffix)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
@@ -166,16 +582,122 @@ func (rf *Recentfile) Write() error {
 	// Get the target file path
 	rfile := rf.Rfile()
 
+	rf.mu.RLock()
+	opts := rf.writeOptions(rf.meta.Minmax)
+	pending := rf.pending
+	rf.mu.RUnlock()
+
+	if sm, ok := serializer.(StreamMarshaler); ok {
+		err = writeSigned(rfile, opts, func(w io.Writer) error {
+			rf.mu.RLock()
+			defer rf.mu.RUnlock()
+			if err := sm.MarshalTo(w, &rf.meta, sliceEvents(rf.recent)); err != nil {
+				return fmt.Errorf("marshal: %w", err)
+			}
+			return nil
+		})
+	} else {
+		var data []byte
+		if data, err = serializer.Marshal(rf); err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		err = writeSigned(rfile, opts, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.pending -= pending
+	// With deferred writes, the file now has what is in memory
+	rf.inMemory = rf.deferEvery > 0
+	rf.lastWrite = time.Now()
+	return nil
+}
+
+// writeOptions say how writeAtomic writes a file.
+type writeOptions struct {
+	mtime       time.Time // unless zero, the file's mtime
+	generations int       // previous versions to keep (see WithGenerations)
+	durable     bool      // sync the file and directory (see WithDurableWrites)
+	perm        Permissions
+}
+
+// writeOptions returns the options for writing the file of rf with the
+// given minmax. The caller must hold rf.mu.
+func (rf *Recentfile) writeOptions(minmax *MinmaxInfo) writeOptions {
+	return writeOptions{
+		mtime:       rf.fileMtime(minmax),
+		generations: rf.generations,
+		durable:     rf.durable,
+		perm:        rf.perm,
+	}
+}
+
+// fileMtime returns the mtime a file with the given minmax should get, or
+// the zero time to leave it at the time of the write. The caller must hold
+// rf.mu.
+func (rf *Recentfile) fileMtime(minmax *MinmaxInfo) time.Time {
+	if !rf.eventMtime || minmax == nil || minmax.Max.IsZero() {
+		return time.Time{}
+	}
+	return EpochToTime(minmax.Max)
+}
+
+// writeAtomic writes rfile by calling write on a temporary file of its
+// own (see TempName), then renaming it to rfile, so writers of the same
+// file never write to each other's. The temporary file is removed if
+// writing fails, and those left by crashed writers are removed once they
+// are an hour old (see removeOrphanTemps). The file gets the permissions
+// of opts.perm. Unless opts.mtime is zero, the file's mtime is set to it,
+// and with opts.generations the file replaced is kept (see
+// rotateGenerations).
+func writeAtomic(rfile string, opts writeOptions, write func(w io.Writer) error) error {
 	// Ensure parent directory exists
 	dir := filepath.Dir(rfile)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("mkdir %s: %w", dir, err)
 	}
 
+	removeOrphanTemps(rfile)
+
 	// Write to temporary file
-	tmpfile := rfile + ".new"
-	if err := os.WriteFile(tmpfile, data, 0o644); err != nil {
-		return fmt.Errorf("write %s: %w", tmpfile, err)
+	f, err := createTemp(rfile)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", rfile, err)
+	}
+	tmpfile := f.Name()
+
+	bw := bufio.NewWriterSize(f, 64*1024)
+	if err = opts.perm.file(f); err != nil {
+		err = fmt.Errorf("set permissions: %w", err)
+	} else if err = write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && opts.durable {
+		err = f.Sync()
+	}
+	if err != nil {
+		err = fmt.Errorf("write %s: %w", tmpfile, err)
+	}
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("write %s: %w", tmpfile, closeErr)
+	}
+	if err == nil && !opts.mtime.IsZero() {
+		if err = os.Chtimes(tmpfile, time.Time{}, opts.mtime); err != nil {
+			err = fmt.Errorf("set mtime: %w", err)
+		}
+	}
+	if err == nil && opts.generations > 0 {
+		err = rotateGenerations(rfile, opts.generations)
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+		return err
 	}
 
 	// Atomic rename
@@ -183,19 +705,183 @@ func (rf *Recentfile) Write() error {
 		os.Remove(tmpfile) // Clean up on failure
 		return fmt.Errorf("rename %s to %s: %w", tmpfile, rfile, err)
 	}
+	if opts.durable {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("sync %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// TempSuffix ends the names of the temporary files RECENT files are
+// written to (see TempName).
+const TempSuffix = ".new"
+
+// orphanTempAge is the age of a temporary file after which it is taken to
+// be left by a crashed writer. No write takes that long.
+const orphanTempAge = time.Hour
+
+// tempsCleaned are the files whose orphaned temporary files this process
+// has removed.
+var tempsCleaned sync.Map
+
+// TempName returns the name of a temporary file for writing rfile: rfile
+// with the process ID, a random number and TempSuffix appended, e.g.
+// "RECENT-1h.yaml.4711.8d6c0f3e.new".
+func TempName(rfile string) string {
+	return fmt.Sprintf("%s.%d.%08x%s", rfile, os.Getpid(), rand.Uint32(), TempSuffix)
+}
+
+// IsTempName reports whether name is a temporary file for writing rfile,
+// as named by TempName or, by older versions, rfile with TempSuffix
+// appended. Both may be base names or paths.
+func IsTempName(name, rfile string) bool {
+	rest, ok := strings.CutPrefix(name, rfile)
+	return ok && strings.HasSuffix(rest, TempSuffix) && !strings.Contains(rest, "/")
+}
+
+// createTemp creates a new temporary file for writing rfile.
+func createTemp(rfile string) (*os.File, error) {
+	for range 100 {
+		f, err := os.OpenFile(TempName(rfile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+	return nil, errors.New("no unused temporary file name")
+}
+
+// removeOrphanTemps removes the temporary files of rfile older than
+// orphanTempAge, the first time this process writes it.
+func removeOrphanTemps(rfile string) {
+	if _, done := tempsCleaned.LoadOrStore(rfile, true); done {
+		return
+	}
+	entries, err := os.ReadDir(filepath.Dir(rfile))
+	if err != nil {
+		return
+	}
+	base := filepath.Base(rfile)
+	for _, entry := range entries {
+		if !IsTempName(entry.Name(), base) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > orphanTempAge {
+			os.Remove(filepath.Join(filepath.Dir(rfile), entry.Name()))
+		}
+	}
+}
+
+// GenerationName returns the name of generation n of the file rfile, an
+// earlier version kept by WithGenerations: rfile with ".n" appended, 1
+// being the newest.
+func GenerationName(rfile string, n int) string {
+	return rfile + "." + strconv.Itoa(n)
+}
+
+// TrimGeneration returns name without the number GenerationName appends,
+// and whether it had one.
+func TrimGeneration(name string) (string, bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 || i == len(name)-1 {
+		return name, false
+	}
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return name, false
+		}
+	}
+	return name[:i], true
+}
 
+// rotateGenerations makes rfile, which is about to be replaced, generation
+// 1 of n, moving the older generations up and dropping generation n. The
+// new generation 1 is a hard link to rfile (or a copy where links are not
+// supported), so rfile itself stays in place until the rename.
+func rotateGenerations(rfile string, n int) error {
+	if _, err := os.Lstat(rfile); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := os.Remove(GenerationName(rfile, n)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove generation %d: %w", n, err)
+	}
+	for i := n - 1; i >= 1; i-- {
+		err := os.Rename(GenerationName(rfile, i), GenerationName(rfile, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("keep generation %d: %w", i+1, err)
+		}
+	}
+	first := GenerationName(rfile, 1)
+	if err := os.Link(rfile, first); err != nil {
+		if err := copyFile(rfile, first); err != nil {
+			return fmt.Errorf("keep generation 1: %w", err)
+		}
+	}
 	return nil
 }
 
-// Read reads the recentfile from disk.
+// copyFile copies the file src to dst, keeping its mtime.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, time.Time{}, fi.ModTime())
+}
+
+// Read reads the recentfile from disk, or with its Fetcher for one
+// created by NewFromFetcher. With verify keys set (see SetVerifyKeys) its
+// signature is checked before it is parsed.
 func (rf *Recentfile) Read() error {
+	return rf.ReadContext(context.Background())
+}
+
+// ReadContext is like Read, but gives up fetching the file when ctx is
+// done.
+func (rf *Recentfile) ReadContext(ctx context.Context) error {
 	rfile := rf.Rfile()
 
+	// The events in memory are newer than the file until they are written
+	// (see WithDeferredWrites)
+	rf.mu.RLock()
+	pending := rf.pending
+	rf.mu.RUnlock()
+	if pending > 0 {
+		return nil
+	}
+
 	// Read file
-	data, err := os.ReadFile(rfile)
+	var f Fetcher = DirFetcher("") // rfile is a path
+	if rf.fetcher != nil {
+		f = rf.fetcher
+	}
+	data, err := f.Fetch(ctx, rfile)
 	if err != nil {
 		return fmt.Errorf("read %s: %w", rfile, err)
 	}
+	if err := verifySignature(ctx, f, rfile, data); err != nil {
+		return err
+	}
 
 	// Unmarshal
 	sd, err := Unmarshal(data, rf.serializerSuffix)
@@ -206,16 +892,35 @@ func (rf *Recentfile) Read() error {
 	// Update recentfile
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
+	rf.setData(sd)
 
-	rf.meta = sd.Meta
-	rf.recent = sd.Recent
+	return nil
+}
 
-	// Update internal state from metadata
-	rf.interval = sd.Meta.Interval
-	rf.filenameRoot = sd.Meta.Filenameroot
-	rf.serializerSuffix = sd.Meta.SerializerSuffix
+// setData replaces the metadata and events with those read. The caller
+// must hold rf.mu.
+func (rf *Recentfile) setData(sd *SerializedData) {
+	rf.setMeta(sd.Meta)
+	rf.recent = sd.Recent
+	if !rf.preserveEpochs {
+		dropEpochText(rf.recent)
+	}
+	if !rf.protocolExt {
+		dropProtocolExt(rf.recent)
+	}
+}
 
-	return nil
+// setMeta replaces the metadata and updates the internal state derived
+// from it, keeping a comment set with WithComment. The caller must hold
+// rf.mu.
+func (rf *Recentfile) setMeta(meta MetaData) {
+	if rf.comment != "" {
+		meta.Comment = rf.comment
+	}
+	rf.meta = meta
+	rf.interval = meta.Interval
+	rf.filenameRoot = meta.Filenameroot
+	rf.serializerSuffix = meta.SerializerSuffix
 }
 
 // NewFromFile reads a recentfile from disk.
@@ -238,6 +943,9 @@ func NewFromFile(path string) (*Recentfile, error) {
 		if err != nil {
 			return nil, fmt.Errorf("read %s: %w", path, err)
 		}
+		if err := verifySignature(context.Background(), DirFetcher(""), path, data); err != nil {
+			return nil, err
+		}
 
 		// Unmarshal to get metadata
 		sd, err := Unmarshal(data, suffix)
@@ -305,17 +1013,42 @@ func NewFromFile(path string) (*Recentfile, error) {
 
 // AssertSymlink creates or updates the RECENT.recent symlink to point to this recentfile.
 // This is used for the principal recentfile so clients can find it easily.
+// A signature of the recentfile gets a RECENT.recent.minisig symlink too.
 func (rf *Recentfile) AssertSymlink() error {
 	dir := filepath.Dir(rf.Rfile())
 	symlinkPath := filepath.Join(dir, rf.filenameRoot+".recent")
 
 	// Get the target (just the filename, not full path)
 	target := rf.Rfilename()
+	rf.mu.RLock()
+	perm := rf.perm
+	rf.mu.RUnlock()
+
+	if err := assertSymlink(symlinkPath, target, perm); err != nil {
+		return err
+	}
+
+	sigLink := symlinkPath + SignatureSuffix
+	if _, err := os.Stat(rf.Rfile() + SignatureSuffix); err != nil {
+		if err := os.Remove(sigLink); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", sigLink, err)
+		}
+		return nil
+	}
+	return assertSymlink(sigLink, target+SignatureSuffix, perm)
+}
 
+// assertSymlink points the symlink at symlinkPath to target, replacing it
+// atomically, and gives it the owner of perm.
+func assertSymlink(symlinkPath, target string, perm Permissions) error {
 	// Check if symlink exists and points to correct target
 	if existing, err := os.Readlink(symlinkPath); err == nil {
 		if existing == target {
-			return nil // Already correct
+			// Already correct
+			if err := perm.symlink(symlinkPath); err != nil {
+				return fmt.Errorf("chown symlink %s: %w", symlinkPath, err)
+			}
+			return nil
 		}
 	}
 
@@ -326,6 +1059,10 @@ func (rf *Recentfile) AssertSymlink() error {
 	if err := os.Symlink(target, tmpSymlink); err != nil {
 		return fmt.Errorf("create symlink %s -> %s: %w", tmpSymlink, target, err)
 	}
+	if err := perm.symlink(tmpSymlink); err != nil {
+		os.Remove(tmpSymlink)
+		return fmt.Errorf("chown symlink %s: %w", tmpSymlink, err)
+	}
 
 	// Atomic rename
 	if err := os.Rename(tmpSymlink, symlinkPath); err != nil {
@@ -391,19 +1128,50 @@ func StreamEvents(path string, batchSize int, callback StreamEventCallback) (sta
 		FileSize: fi.Size(),
 	}
 
+	// Encrypted files are decrypted in memory before streaming
+	var r io.Reader = f
+	if IsEncryptedSuffix(suffix) {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		plain, err := decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, corrupt(err))
+		}
+		r = bytes.NewReader(plain)
+		suffix = plainSuffix(suffix)
+	}
+
+	// Compressed files are decompressed while streaming
+	if plain, compression := splitCompression(suffix); compression != "" {
+		zr, err := decompressReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, corrupt(err))
+		}
+		defer zr.Close()
+		r = zr
+		suffix = plain
+	}
+
 	// Stream based on format
-	switch suffix {
-	case ".json":
-		return streamEventsJSON(f, stats, batchSize, callback)
-	case ".yaml", ".yml":
-		return streamEventsYAML(f, stats, batchSize, callback)
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", suffix)
+	serializer, ok := lookupSerializer(suffix)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, suffix)
 	}
+	if streamer, ok := serializer.(EventStreamer); ok {
+		stats, err = streamer.StreamEvents(r, stats, batchSize, callback)
+	} else {
+		stats, err = streamEventsDecoded(r, serializer, stats, batchSize, callback)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, corrupt(err))
+	}
+	return stats, nil
 }
 
-// streamEventsJSON streams events from a JSON file.
-func streamEventsJSON(r io.Reader, stats *StreamStats, batchSize int, callback StreamEventCallback) (*StreamStats, error) {
+// StreamEvents streams events from a JSON file.
+func (s *JSONSerializer) StreamEvents(r io.Reader, stats *StreamStats, batchSize int, callback StreamEventCallback) (*StreamStats, error) {
 	dec := json.NewDecoder(r)
 
 	// Read opening brace
@@ -449,7 +1217,7 @@ func streamEventsJSON(r io.Reader, stats *StreamStats, batchSize int, callback S
 			// Stream through events
 			for dec.More() {
 				var event Event
-				if err := dec.Decode(&event); err != nil {
+				if err := dec.Decode((*fileEvent)(&event)); err != nil {
 					return nil, fmt.Errorf("decode event %d: %w", eventCount, err)
 				}
 
@@ -504,19 +1272,17 @@ func streamEventsJSON(r io.Reader, stats *StreamStats, batchSize int, callback S
 	return stats, nil
 }
 
-// streamEventsYAML streams events from a YAML file.
-// Note: YAML doesn't have native streaming support, so we load the whole file.
-// For YAML files, use the standard Read() method for small files.
-func streamEventsYAML(r io.Reader, stats *StreamStats, batchSize int, callback StreamEventCallback) (*StreamStats, error) {
-	// YAML doesn't support streaming, so read entire file
+// streamEventsDecoded streams events from a format without an
+// EventStreamer, by decoding the file whole.
+func streamEventsDecoded(r io.Reader, s Serializer, stats *StreamStats, batchSize int, callback StreamEventCallback) (*StreamStats, error) {
 	data, err := io.ReadAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("read yaml: %w", err)
+		return nil, fmt.Errorf("read: %w", err)
 	}
 
-	var sd SerializedData
-	if err := yaml.Unmarshal(data, &sd); err != nil {
-		return nil, fmt.Errorf("unmarshal yaml: %w", err)
+	sd, err := s.Unmarshal(data)
+	if err != nil {
+		return nil, err
 	}
 
 	stats.Meta = sd.Meta
@@ -538,6 +1304,96 @@ func streamEventsYAML(r io.Reader, stats *StreamStats, batchSize int, callback S
 	return stats, nil
 }
 
+// serializedFromValue converts a decoded Perl data structure of maps,
+// slices and scalars to SerializedData, using the JSON field names.
+// Items shared through back-references are converted wherever they
+// occur, so a document is rejected when that expands it to more than
+// maxValues values; the size of the document bounds those of a real one.
+func serializedFromValue(v interface{}, maxValues int) (*SerializedData, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("document is not a hash")
+	}
+	if !withinValues(v, &maxValues) {
+		return nil, errors.New("document expands to too many values")
+	}
+
+	// Perl keeps numbers that were read from text as strings, but the
+	// integer fields need numbers
+	if meta, ok := m["meta"].(map[string]interface{}); ok {
+		perlInt(meta, "protocol")
+		if minmax, ok := meta["minmax"].(map[string]interface{}); ok {
+			perlInt(minmax, "mtime")
+		}
+	}
+	if recent, ok := m["recent"].([]interface{}); ok {
+		for _, event := range recent {
+			if event, ok := event.(map[string]interface{}); ok {
+				for _, key := range []string{"size", "mode", "uid", "gid"} {
+					perlInt(event, key)
+				}
+				perlBool(event, "dir")
+			}
+		}
+	}
+
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var sd SerializedData
+	if err := json.Unmarshal(doc, &sd); err != nil {
+		return nil, err
+	}
+	return &sd, nil
+}
+
+// withinValues reports whether v holds at most *n values, counting it and
+// every array element and hash value below it, and takes them off *n. It
+// stops at the first value over.
+func withinValues(v interface{}, n *int) bool {
+	if *n--; *n < 0 {
+		return false
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if !withinValues(e, n) {
+				return false
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			if !withinValues(e, n) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// perlInt replaces the string value of m[key] by the integer it holds.
+func perlInt(m map[string]interface{}, key string) {
+	if s, ok := m[key].(string); ok {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			m[key] = i
+		}
+	}
+}
+
+// perlBool replaces the Perl truth value of m[key], a number or string,
+// by a boolean.
+func perlBool(m map[string]interface{}, key string) {
+	switch v := m[key].(type) {
+	case string:
+		m[key] = v != "" && v != "0"
+	case int64:
+		m[key] = v != 0
+	case float64:
+		m[key] = v != 0
+	}
+}
+
 // ValidateFile validates a RECENT file's structure without loading all events into memory.
 // Returns metadata, event count, and any errors.
 func ValidateFile(path string) (*StreamStats, error) {
@@ -1,10 +1,14 @@
 package recentfile
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestYAMLSerializer(t *testing.T) {
@@ -117,6 +121,7 @@ func TestGetSerializer(t *testing.T) {
 		{".yaml", false},
 		{".yml", false},
 		{".json", false},
+		{".sereal", false},
 		{".xml", true},
 		{".txt", true},
 		{"", true},
@@ -126,8 +131,8 @@ func TestGetSerializer(t *testing.T) {
 		t.Run(tt.suffix, func(t *testing.T) {
 			s, err := GetSerializer(tt.suffix)
 			if tt.wantErr {
-				if err == nil {
-					t.Error("GetSerializer() expected error, got nil")
+				if !errors.Is(err, ErrUnsupportedFormat) {
+					t.Errorf("GetSerializer() error = %v, want ErrUnsupportedFormat", err)
 				}
 			} else {
 				if err != nil {
@@ -225,10 +230,9 @@ func TestAtomicWrite(t *testing.T) {
 		t.Fatalf("Write failed: %v", err)
 	}
 
-	// Verify .new file is gone (atomic rename completed)
-	newFile := rf.Rfile() + ".new"
-	if _, err := os.Stat(newFile); !os.IsNotExist(err) {
-		t.Error(".new file still exists after write")
+	// Verify the temp file is gone (atomic rename completed)
+	if temps, _ := filepath.Glob(rf.Rfile() + ".*" + TempSuffix); len(temps) > 0 {
+		t.Errorf("temp files still exist after write: %v", temps)
 	}
 
 	// Verify target file exists
@@ -254,6 +258,14 @@ func TestSplitRfilename(t *testing.T) {
 			wantSuf:  ".yaml",
 			wantErr:  false,
 		},
+		{
+			name:     "encrypted json",
+			filename: "RECENT-1h.json.enc",
+			wantRoot: "RECENT",
+			wantInt:  "1h",
+			wantSuf:  ".json.enc",
+			wantErr:  false,
+		},
 		{
 			name:     "6h interval",
 			filename: "RECENT-6h.yaml",
@@ -792,3 +804,256 @@ func TestStreamEventsWithRecentFile(t *testing.T) {
 		t.Errorf("EventCount = %d, want 1", stats.EventCount)
 	}
 }
+
+func TestJSONMarshalToMatchesMarshalIndent(t *testing.T) {
+	full := New(WithInterval("1h"), WithAggregator([]string{"6h", "1d"}), WithSerializerSuffix(".json"))
+	full.meta.Comment = "<mirror & friends>"
+	full.meta.Minmax = &MinmaxInfo{Max: 1704207845.5, Min: 1704200000, Mtime: 1704207846}
+	full.meta.Producers = map[string]interface{}{"rrrgo": "1.0", "time": 1704207845.5}
+	full.SetRecentEvents([]Event{
+		{Epoch: 1704207845.5, Path: "a/ü.txt", Type: "new"},
+		{Epoch: 1704200000, Path: "b.txt", Type: "delete"},
+	})
+
+	empty := New(WithInterval("1h"), WithSerializerSuffix(".json"))
+	empty.SetRecentEvents([]Event{})
+
+	for name, rf := range map[string]*Recentfile{
+		"full":  full,
+		"empty": empty,
+		"nil":   New(WithInterval("1h"), WithSerializerSuffix(".json")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			want, err := json.MarshalIndent(&SerializedData{Meta: rf.meta, Recent: rf.recent}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := (&JSONSerializer{}).MarshalTo(&buf, &rf.meta, sliceEvents(rf.recent)); err != nil {
+				t.Fatalf("MarshalTo failed: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("MarshalTo =\n%s\nwant\n%s", buf.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestWriteStreamingRemovesTempFileOnError(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(".json"))
+	rf.meta.Producers = map[string]interface{}{"bad": func() {}}
+	if err := rf.Write(); err == nil {
+		t.Fatal("expected error")
+	}
+	if temps, _ := filepath.Glob(rf.Rfile() + ".*" + TempSuffix); len(temps) > 0 {
+		t.Errorf("temporary file left behind: %v", temps)
+	}
+}
+
+func TestWriteGenerations(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithGenerations(2))
+	for _, path := range []string{"a", "b", "c", "d"} {
+		if err := rf.Update(path, "new"); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+
+	// Generation n has the events of n writes ago
+	for n, want := range map[int]int{1: 3, 2: 2} {
+		data, err := os.ReadFile(GenerationName(rf.Rfile(), n))
+		if err != nil {
+			t.Fatalf("generation %d: %v", n, err)
+		}
+		old, err := (&YAMLSerializer{}).Unmarshal(data)
+		if err != nil {
+			t.Fatalf("generation %d: %v", n, err)
+		}
+		if got := len(old.Recent); got != want {
+			t.Errorf("generation %d has %d events, want %d", n, got, want)
+		}
+	}
+	if _, err := os.Stat(GenerationName(rf.Rfile(), 3)); !os.IsNotExist(err) {
+		t.Errorf("generation 3 kept: %v", err)
+	}
+
+	// Replacing the file leaves its generations alone
+	data, _ := os.ReadFile(GenerationName(rf.Rfile(), 1))
+	if err := rf.Update("e", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, _ := os.ReadFile(GenerationName(rf.Rfile(), 2)); !bytes.Equal(got, data) {
+		t.Error("generation 1 changed when it was moved to 2")
+	}
+
+	if name, ok := TrimGeneration("RECENT-1h.yaml.12"); !ok || name != "RECENT-1h.yaml" {
+		t.Errorf("TrimGeneration = %q, %v", name, ok)
+	}
+	if _, ok := TrimGeneration("RECENT-1h.yaml"); ok {
+		t.Error("TrimGeneration found a generation in RECENT-1h.yaml")
+	}
+}
+
+func TestWriteDurable(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithDurableWrites(true))
+	if err := rf.Update("a", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if temps, _ := filepath.Glob(rf.Rfile() + ".*" + TempSuffix); len(temps) > 0 {
+		t.Errorf("temp files left: %v", temps)
+	}
+	got, err := NewFromFile(rf.Rfile())
+	if err != nil {
+		t.Fatalf("NewFromFile failed: %v", err)
+	}
+	if n := len(got.RecentEvents()); n != 1 {
+		t.Errorf("got %d events, want 1", n)
+	}
+	if !rf.SparseClone().durable {
+		t.Error("SparseClone lost durable writes")
+	}
+}
+
+func TestPreserveEpochs(t *testing.T) {
+	for _, suffix := range []string{".json", ".yaml", ".sereal"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			// A file from a Perl mirror with arbitrary precision epochs
+			writer := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix))
+			writer.SetRecentEvents([]Event{
+				{Epoch: 1704207845.5, Path: "b.txt", Type: "new", epochText: "1704207845.500000000001"},
+				{Epoch: 1704207840.25, Path: "a.txt", Type: "delete", epochText: "1704207840.2500"},
+			})
+			if err := writer.Write(); err != nil {
+				t.Fatal(err)
+			}
+			original, err := os.ReadFile(writer.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if suffix != ".sereal" && !bytes.Contains(original, []byte("1704207845.500000000001")) {
+				t.Fatalf("epoch text not written:\n%s", original)
+			}
+
+			for _, preserve := range []bool{true, false} {
+				rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix), WithPreserveEpochs(preserve))
+				if err := rf.Read(); err != nil {
+					t.Fatal(err)
+				}
+				if events := rf.RecentEvents(); len(events) != 2 || events[0].Epoch != 1704207845.5 {
+					t.Fatalf("events = %+v", events)
+				}
+				if err := rf.Write(); err != nil {
+					t.Fatal(err)
+				}
+				rewritten, err := os.ReadFile(rf.Rfile())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if preserve != bytes.Equal(rewritten, original) {
+					t.Errorf("preserve %v: rewritten file identical = %v\n%s", preserve, !preserve, rewritten)
+				}
+				if err := os.WriteFile(rf.Rfile(), original, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A modified epoch is written as usual
+			rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix), WithPreserveEpochs(true))
+			if err := rf.Read(); err != nil {
+				t.Fatal(err)
+			}
+			events := rf.RecentEvents()
+			events[0].Epoch = 1704207846
+			rf.SetRecentEvents(events)
+			if err := rf.Write(); err != nil {
+				t.Fatal(err)
+			}
+			rewritten, err := os.ReadFile(rf.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(rewritten, []byte("1704207845.500000000001")) {
+				t.Errorf("text of modified epoch written:\n%s", rewritten)
+			}
+			if suffix != ".sereal" && !bytes.Contains(rewritten, []byte("1704207840.2500")) {
+				t.Errorf("text of unmodified epoch not written:\n%s", rewritten)
+			}
+		})
+	}
+}
+
+func TestEpochText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"1704207845.5", ""},
+		{"1.7042078455e+09", ""},
+		{"1704207845", ""},
+		{"1704207845.50", "1704207845.50"},
+		{"1704207845.123456789012", "1704207845.123456789012"},
+		{"0x1p-2", ""},
+	}
+
+	for _, tt := range tests {
+		_, got, err := parseEpochText(tt.text)
+		if err != nil {
+			t.Errorf("parseEpochText(%q) failed: %v", tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("parseEpochText(%q) kept %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if _, _, err := parseEpochText("soon"); err == nil {
+		t.Error("parseEpochText accepted a word")
+	}
+}
+
+func TestWriteTempNames(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"))
+	if a, b := TempName(rf.Rfile()), TempName(rf.Rfile()); a == b {
+		t.Errorf("TempName returned %s twice", a)
+	}
+	for name, want := range map[string]bool{
+		"RECENT-1h.yaml.4711.8d6c0f3e.new":         true,
+		"RECENT-1h.yaml.minisig.4711.8d6c0f3e.new": true,
+		"RECENT-1h.yaml.new":                       true,
+		"RECENT-1h.yaml":                           false,
+		"RECENT-6h.yaml.4711.8d6c0f3e.new":         false,
+		"RECENT-1h.yaml.lock/x.new":                false,
+	} {
+		if got := IsTempName(name, "RECENT-1h.yaml"); got != want {
+			t.Errorf("IsTempName(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	// Orphans of crashed writers are removed on the first write, once
+	// they are old enough that no writer can still be using them
+	orphan := filepath.Join(tmpDir, "RECENT-1h.yaml.1.2.new")
+	recent := filepath.Join(tmpDir, "RECENT-1h.yaml.3.4.new")
+	for _, name := range []string{orphan, recent} {
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * orphanTempAge)
+	os.Chtimes(orphan, old, old)
+	if err := rf.Update("a", "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan kept: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent temp file removed: %v", err)
+	}
+}
@@ -0,0 +1,422 @@
+package recentfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SignatureSuffix is appended to the name of a RECENT file for its
+// detached signature, e.g. "RECENT-1h.yaml.minisig". Signatures are in the
+// format of minisign (https://jedisct1.github.io/minisign/), so they can
+// also be checked with "minisign -V".
+const SignatureSuffix = ".minisig"
+
+// ErrUnsigned is returned when a recentfile without a signature is read
+// while verify keys are set.
+var ErrUnsigned = errors.New("recentfile has no signature")
+
+// ErrBadSignature is returned when the signature of a recentfile does not
+// verify.
+var ErrBadSignature = errors.New("signature verification failed")
+
+// Algorithm identifiers of the minisign formats.
+const (
+	sigAlgLegacy = "Ed" // signature of the file itself, and the key algorithm
+	sigAlgHashed = "ED" // signature of the BLAKE2b-512 hash of the file
+	kdfScrypt    = "Sc"
+	kdfNone      = "\x00\x00"
+	chkBlake2b   = "B2"
+)
+
+// Limits on the scrypt parameters of an encrypted secret key, those of
+// libsodium's "sensitive" preset that minisign uses.
+const (
+	maxScryptOps = 1 << 25
+	maxScryptMem = 1 << 30
+)
+
+// SigningKey is an Ed25519 secret key that signs RECENT files.
+type SigningKey struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+// PublicKey verifies signatures made with a SigningKey.
+type PublicKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+var (
+	signMu     sync.Mutex
+	signingKey *SigningKey
+	verifyKeys []*PublicKey
+)
+
+// SetSigningKey sets the process-wide key every RECENT file is signed with
+// when it is written. A nil key stops signing.
+func SetSigningKey(k *SigningKey) {
+	signMu.Lock()
+	defer signMu.Unlock()
+	signingKey = k
+}
+
+// LoadSigningKeyFile reads a minisign secret key from path, decrypting it
+// with password if it is encrypted, and sets it with SetSigningKey.
+func LoadSigningKeyFile(path, password string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read signing key: %w", err)
+	}
+	k, err := ParseSigningKey(data, password)
+	if err != nil {
+		return fmt.Errorf("signing key %s: %w", path, err)
+	}
+	SetSigningKey(k)
+	return nil
+}
+
+// SetVerifyKeys sets the process-wide keys RECENT files must be signed
+// with. While any are set, Read, NewFromFile and NewFromFetcher reject a
+// file whose signature is missing or not made by one of them. Several keys
+// allow for rotating the signing key; no keys turn verification off.
+func SetVerifyKeys(keys ...*PublicKey) {
+	signMu.Lock()
+	defer signMu.Unlock()
+	verifyKeys = keys
+}
+
+func currentSigningKey() *SigningKey {
+	signMu.Lock()
+	defer signMu.Unlock()
+	return signingKey
+}
+
+func currentVerifyKeys() []*PublicKey {
+	signMu.Lock()
+	defer signMu.Unlock()
+	return verifyKeys
+}
+
+// GenerateSigningKey creates a new random key.
+func GenerateSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k := &SigningKey{key: key}
+	if _, err := rand.Read(k.id[:]); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseSigningKey parses a minisign secret key file. An encrypted key is
+// decrypted with password.
+func ParseSigningKey(data []byte, password string) (*SigningKey, error) {
+	raw, err := decodeKeyFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 158 || string(raw[:2]) != sigAlgLegacy || string(raw[4:6]) != chkBlake2b {
+		return nil, errors.New("not a minisign secret key")
+	}
+
+	keynum := bytes.Clone(raw[54:]) // key id, secret key and checksum
+	switch string(raw[2:4]) {
+	case kdfNone:
+	case kdfScrypt:
+		if password == "" {
+			return nil, errors.New("secret key is encrypted and no password was given")
+		}
+		ops := binary.LittleEndian.Uint64(raw[38:46])
+		mem := binary.LittleEndian.Uint64(raw[46:54])
+		if ops > maxScryptOps || mem > maxScryptMem {
+			return nil, errors.New("secret key scrypt parameters are too large")
+		}
+		stream, err := scryptKeystream(password, raw[6:38], ops, mem, len(keynum))
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(keynum, keynum, stream)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", raw[2:4])
+	}
+
+	k := &SigningKey{key: ed25519.PrivateKey(bytes.Clone(keynum[8:72]))}
+	copy(k.id[:], keynum[:8])
+	if sum := k.checksum(); subtle.ConstantTimeCompare(sum[:], keynum[72:]) != 1 {
+		if string(raw[2:4]) == kdfScrypt {
+			return nil, errors.New("wrong password for secret key")
+		}
+		return nil, errors.New("secret key checksum mismatch")
+	}
+	return k, nil
+}
+
+// checksum returns the checksum stored with the key in a secret key file.
+func (k *SigningKey) checksum() [32]byte {
+	return blake2b.Sum256(append(append([]byte(sigAlgLegacy), k.id[:]...), k.key...))
+}
+
+// Marshal returns the key as an unencrypted minisign secret key file, as
+// written by "minisign -G -W".
+func (k *SigningKey) Marshal() []byte {
+	data, _ := k.marshal("", 0, 0)
+	return data
+}
+
+// marshal returns the key as a minisign secret key file, encrypted with
+// password and the scrypt parameters ops and mem unless password is empty.
+func (k *SigningKey) marshal(password string, ops, mem uint64) ([]byte, error) {
+	raw := make([]byte, 54, 158)
+	copy(raw, sigAlgLegacy+kdfNone+chkBlake2b)
+	sum := k.checksum()
+	keynum := append(append(append([]byte{}, k.id[:]...), k.key...), sum[:]...)
+
+	comment := "minisign secret key"
+	if password != "" {
+		copy(raw[2:4], kdfScrypt)
+		if _, err := rand.Read(raw[6:38]); err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint64(raw[38:46], ops)
+		binary.LittleEndian.PutUint64(raw[46:54], mem)
+		stream, err := scryptKeystream(password, raw[6:38], ops, mem, len(keynum))
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(keynum, keynum, stream)
+		comment = "minisign encrypted secret key"
+	}
+	raw = append(raw, keynum...)
+	return []byte("untrusted comment: " + comment + "\n" + base64.StdEncoding.EncodeToString(raw) + "\n"), nil
+}
+
+// scryptKeystream derives the n bytes a secret key is encrypted with, from
+// the libsodium opslimit and memlimit the way
+// crypto_pwhash_scryptsalsa208sha256 picks the scrypt parameters.
+func scryptKeystream(password string, salt []byte, ops, mem uint64, n int) ([]byte, error) {
+	ops = max(ops, 1<<15)
+	const r = 8
+	maxN, p := ops/(r*4), uint64(1)
+	if ops >= mem/32 {
+		maxN = mem / (r * 128)
+	}
+	logN := uint64(1)
+	for logN < 63 && 1<<logN <= maxN/2 {
+		logN++
+	}
+	if ops >= mem/32 {
+		p = min((ops/4)>>logN, 0x3fffffff) / r
+	}
+	stream, err := scrypt.Key([]byte(password), salt, 1<<logN, r, int(max(p, 1)), n)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+	return stream, nil
+}
+
+// Public returns the public key of k.
+func (k *SigningKey) Public() *PublicKey {
+	return &PublicKey{id: k.id, key: k.key.Public().(ed25519.PublicKey)}
+}
+
+// ParsePublicKey parses a minisign public key, either a public key file or
+// just the base64 line of one (as given to "minisign -P").
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	raw, err := decodeKeyFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 42 || string(raw[:2]) != sigAlgLegacy {
+		return nil, errors.New("not a minisign public key")
+	}
+	k := &PublicKey{key: ed25519.PublicKey(bytes.Clone(raw[10:]))}
+	copy(k.id[:], raw[2:10])
+	return k, nil
+}
+
+// ID returns the key id, in hex as minisign prints it.
+func (k *PublicKey) ID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.id[:]))
+}
+
+// String returns the key in base64, as given to "minisign -P".
+func (k *PublicKey) String() string {
+	return base64.StdEncoding.EncodeToString(append(append([]byte(sigAlgLegacy), k.id[:]...), k.key...))
+}
+
+// Marshal returns the key as a minisign public key file.
+func (k *PublicKey) Marshal() []byte {
+	return []byte("untrusted comment: minisign public key " + k.ID() + "\n" + k.String() + "\n")
+}
+
+// decodeKeyFile returns the decoded key of a minisign key file, skipping
+// the untrusted comment if there is one.
+func decodeKeyFile(data []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	line := strings.TrimSpace(lines[0])
+	if strings.HasPrefix(line, "untrusted comment:") {
+		if len(lines) < 2 {
+			return nil, errors.New("key file has no key")
+		}
+		line = strings.TrimSpace(lines[1])
+	}
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	return raw, nil
+}
+
+// Sign returns a minisign signature of data, a RECENT file called name.
+func (k *SigningKey) Sign(data []byte, name string) []byte {
+	hash := blake2b.Sum512(data)
+	return k.signHash(hash[:], name, time.Now())
+}
+
+// signHash returns a minisign signature of the file called name with the
+// BLAKE2b-512 hash, made at t.
+func (k *SigningKey) signHash(hash []byte, name string, t time.Time) []byte {
+	sig := append(append([]byte(sigAlgHashed), k.id[:]...), ed25519.Sign(k.key, hash)...)
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", t.Unix(), name)
+	global := ed25519.Sign(k.key, append(bytes.Clone(sig[10:]), trusted...))
+
+	return []byte("untrusted comment: signature from rrrgo secret key\n" +
+		base64.StdEncoding.EncodeToString(sig) + "\n" +
+		"trusted comment: " + trusted + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+// Verify checks the minisign signature sig of data with whichever of keys
+// made it, and returns its trusted comment.
+func Verify(data, sig []byte, keys ...*PublicKey) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) != 4 {
+		return "", errors.New("malformed signature")
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	trusted, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !strings.HasPrefix(lines[0], "untrusted comment:") || !ok {
+		return "", errors.New("malformed signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 74 {
+		return "", errors.New("malformed signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("malformed signature")
+	}
+
+	var key *PublicKey
+	for _, k := range keys {
+		if bytes.Equal(k.id[:], raw[2:10]) {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("signed with unknown key %016X: %w", binary.LittleEndian.Uint64(raw[2:10]), ErrBadSignature)
+	}
+
+	msg := data
+	switch string(raw[:2]) {
+	case sigAlgHashed:
+		hash := blake2b.Sum512(data)
+		msg = hash[:]
+	case sigAlgLegacy:
+	default:
+		return "", fmt.Errorf("unsupported signature algorithm %q", raw[:2])
+	}
+	if !ed25519.Verify(key.key, msg, raw[10:]) {
+		return "", ErrBadSignature
+	}
+	if !ed25519.Verify(key.key, append(bytes.Clone(raw[10:]), trusted...), global) {
+		return "", fmt.Errorf("trusted comment: %w", ErrBadSignature)
+	}
+	return trusted, nil
+}
+
+// writeSigned writes rfile like writeAtomic and, with a signing key set,
+// then its signature. Without one a signature left from an earlier write
+// is removed, as it no longer matches.
+func writeSigned(rfile string, opts writeOptions, write func(w io.Writer) error) error {
+	key := currentSigningKey()
+	if key == nil {
+		if err := writeAtomic(rfile, opts, write); err != nil {
+			return err
+		}
+		if err := os.Remove(rfile + SignatureSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove stale signature: %w", err)
+		}
+		return nil
+	}
+
+	h, _ := blake2b.New512(nil)
+	if err := writeAtomic(rfile, opts, func(w io.Writer) error {
+		return write(io.MultiWriter(w, h))
+	}); err != nil {
+		return err
+	}
+	sig := key.signHash(h.Sum(nil), filepath.Base(rfile), time.Now())
+	return writeAtomic(rfile+SignatureSuffix, writeOptions{generations: opts.generations, durable: opts.durable, perm: opts.perm}, func(w io.Writer) error {
+		_, err := w.Write(sig)
+		return err
+	})
+}
+
+// verifySignature checks data, the contents of the RECENT file name,
+// against its signature read with f, when verify keys are set. The file
+// named in the signature must be name, unless name is the principal's
+// symlink.
+func verifySignature(ctx context.Context, f Fetcher, name string, data []byte) error {
+	keys := currentVerifyKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sig, err := f.Fetch(ctx, name+SignatureSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", name, ErrUnsigned)
+	}
+	if err != nil {
+		return fmt.Errorf("read signature of %s: %w", name, err)
+	}
+
+	trusted, err := Verify(data, sig, keys...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	base := filepath.Base(filepath.FromSlash(name))
+	if filepath.Ext(base) == ".recent" {
+		return nil
+	}
+	for _, field := range strings.Split(trusted, "\t") {
+		if file, ok := strings.CutPrefix(field, "file:"); ok && file != base {
+			return fmt.Errorf("%s: signature is for %s: %w", name, strconv.Quote(file), ErrBadSignature)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package recentfile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setTestSigningKey(t *testing.T) *SigningKey {
+	t.Helper()
+	k, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey failed: %v", err)
+	}
+	SetSigningKey(k)
+	t.Cleanup(func() {
+		SetSigningKey(nil)
+		SetVerifyKeys()
+	})
+	return k
+}
+
+func TestSigningKeyMarshal(t *testing.T) {
+	k, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseSigningKey(k.Marshal(), "")
+	if err != nil {
+		t.Fatalf("ParseSigningKey failed: %v", err)
+	}
+	if parsed.Public().String() != k.Public().String() {
+		t.Error("unencrypted key changed in round trip")
+	}
+
+	// Cheap scrypt parameters, minisign uses 1 GiB
+	encrypted, err := k.marshal("secret", 1<<15, 1<<24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseSigningKey(encrypted, ""); err == nil {
+		t.Error("expected error without password")
+	}
+	if _, err := ParseSigningKey(encrypted, "wrong"); err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Errorf("wrong password: err = %v", err)
+	}
+	if parsed, err = ParseSigningKey(encrypted, "secret"); err != nil {
+		t.Fatalf("ParseSigningKey failed: %v", err)
+	}
+	if parsed.Public().String() != k.Public().String() {
+		t.Error("encrypted key changed in round trip")
+	}
+
+	// Public keys parse from a file or the bare key
+	pub := k.Public()
+	for _, data := range []string{string(pub.Marshal()), pub.String()} {
+		parsed, err := ParsePublicKey([]byte(data))
+		if err != nil {
+			t.Fatalf("ParsePublicKey(%q) failed: %v", data, err)
+		}
+		if parsed.ID() != pub.ID() || parsed.String() != pub.String() {
+			t.Errorf("ParsePublicKey(%q) = %s", data, parsed)
+		}
+	}
+	if _, err := ParsePublicKey(k.Marshal()); err == nil {
+		t.Error("expected error parsing a secret key as public key")
+	}
+}
+
+// TestVerifyMinisign checks a signature made by minisign itself.
+func TestVerifyMinisign(t *testing.T) {
+	pub, err := ParsePublicKey([]byte("untrusted comment: minisign public key C373193807678450\n" +
+		"RWRQhGcHOBlzw4CoKyugkk4ioDfoxlXxC9LBx+VNhJ3w9w+cAxgvPsuo\n"))
+	if err != nil {
+		t.Fatalf("ParsePublicKey failed: %v", err)
+	}
+	if pub.ID() != "C373193807678450" {
+		t.Errorf("ID = %s", pub.ID())
+	}
+
+	sig := "untrusted comment: signature from minisign secret key\n" +
+		"RWRQhGcHOBlzwxrJCyuC+rJfHSfyRKRxkuwa3JJ0bWEs7RHjL1OUmqnTr+V1B9JzFuJIH/ybR2Eus9oEZKt9RbitpF/L4D3+5wg=\n" +
+		"trusted comment: timestamp:1614549543\tfile:message.txt\n" +
+		"P/722+ynQ+tIy0qadFHwLx5MsyNz/jDKJkDWQj4dDD2OKnVte8m/M14mwPE/1NMwzShPMSBhMXqZGdbe+UZjDg==\n"
+
+	trusted, err := Verify([]byte("Hello World!\n"), []byte(sig), pub)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if trusted != "timestamp:1614549543\tfile:message.txt" {
+		t.Errorf("trusted comment = %q", trusted)
+	}
+
+	if _, err := Verify([]byte("Hello World?\n"), []byte(sig), pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("changed message: err = %v", err)
+	}
+	forged := strings.Replace(sig, "file:message.txt", "file:other.txt", 1)
+	if _, err := Verify([]byte("Hello World!\n"), []byte(forged), pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("changed trusted comment: err = %v", err)
+	}
+}
+
+func TestSignedWriteAndRead(t *testing.T) {
+	k := setTestSigningKey(t)
+	tmpDir := t.TempDir()
+
+	principal := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithAggregator([]string{"1d"}),
+	)
+	if err := principal.BatchUpdate([]BatchItem{{Path: "a.txt", Type: "new"}}); err != nil {
+		t.Fatalf("BatchUpdate failed: %v", err)
+	}
+	if err := principal.Aggregate(true); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+
+	// Principal, aggregate and the principal's symlink are all signed
+	for _, name := range []string{"RECENT-1h.yaml", "RECENT-1d.yaml", "RECENT.recent"} {
+		data, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := os.ReadFile(filepath.Join(tmpDir, name+SignatureSuffix))
+		if err != nil {
+			t.Fatalf("no signature for %s: %v", name, err)
+		}
+		if _, err := Verify(data, sig, k.Public()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	SetVerifyKeys(k.Public())
+	for _, name := range []string{"RECENT-1h.yaml", "RECENT.recent"} {
+		if _, err := NewFromFile(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("NewFromFile(%s) failed: %v", name, err)
+		}
+		if _, err := NewFromFetcher(context.Background(), DirFetcher(tmpDir), name); err != nil {
+			t.Errorf("NewFromFetcher(%s) failed: %v", name, err)
+		}
+	}
+
+	// Another key's signatures are rejected
+	other, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetVerifyKeys(other.Public())
+	if err := principal.Read(); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Read with other key: err = %v, want ErrBadSignature", err)
+	}
+	SetVerifyKeys(other.Public(), k.Public())
+	if err := principal.Read(); err != nil {
+		t.Errorf("Read with rotated keys failed: %v", err)
+	}
+
+	// A signature of another RECENT file does not do
+	rfile := principal.Rfile()
+	sig1d, err := os.ReadFile(filepath.Join(tmpDir, "RECENT-1d.yaml"+SignatureSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data1d, err := os.ReadFile(filepath.Join(tmpDir, "RECENT-1d.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(rfile, data1d, 0o644)
+	os.WriteFile(rfile+SignatureSuffix, sig1d, 0o644)
+	if _, err := NewFromFile(rfile); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("swapped file: err = %v, want ErrBadSignature", err)
+	}
+
+	// Written without a key the file loses its signature
+	SetSigningKey(nil)
+	if err := principal.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := os.Stat(rfile + SignatureSuffix); !os.IsNotExist(err) {
+		t.Errorf("stale signature kept: %v", err)
+	}
+	if err := principal.Read(); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Read unsigned: err = %v, want ErrUnsigned", err)
+	}
+	if err := principal.AssertSymlink(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, "RECENT.recent"+SignatureSuffix)); !os.IsNotExist(err) {
+		t.Errorf("signature symlink kept: %v", err)
+	}
+
+	SetVerifyKeys()
+	if err := principal.Read(); err != nil {
+		t.Errorf("Read without verify keys failed: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+package recentfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// StorableSerializer reads recentfiles in Perl's Storable format, which
+// File::Rsync::Mirror::Recent writes for ".storable" recentfiles with
+// Storable::nfreeze. Documents in network order and in the native order of
+// the writing machine are read, with or without the "pst0" file header of
+// Storable::store.
+//
+// Writing Storable is not supported; Marshal always fails.
+type StorableSerializer struct{}
+
+// Marshal returns an error: Storable recentfiles are read-only.
+func (s *StorableSerializer) Marshal(rf *Recentfile) ([]byte, error) {
+	return nil, errors.New("marshal storable: writing Storable recentfiles is not supported")
+}
+
+// Unmarshal deserializes Storable bytes to SerializedData.
+func (s *StorableSerializer) Unmarshal(data []byte) (*SerializedData, error) {
+	v, err := decodeStorable(data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal storable: %w", err)
+	}
+	sd, err := serializedFromValue(v, len(data))
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal storable: %w", err)
+	}
+	return sd, nil
+}
+
+// storableFileMagic starts files written by Storable::store.
+const storableFileMagic = "pst0"
+
+// storableMajor is the only Storable major version in use since Storable
+// 0.6 (1998). It is stored shifted left by one, with the network order
+// flag in the low bit.
+const storableMajor = 2
+
+// Detect reports whether data starts with a Storable header.
+func (s *StorableSerializer) Detect(data []byte) bool {
+	return isStorable(data)
+}
+
+// isStorable reports whether data starts with a Storable header.
+func isStorable(data []byte) bool {
+	data = bytes.TrimPrefix(data, []byte(storableFileMagic))
+	return len(data) >= 2 && data[0]>>1 == storableMajor && data[1] <= 20
+}
+
+// Storable item types.
+const (
+	sxObject       = 0  // an item seen before, by tag
+	sxLScalar      = 1  // scalar with a 4-byte length
+	sxArray        = 2  // array with a 4-byte size
+	sxHash         = 3  // hash with a 4-byte size
+	sxRef          = 4  // reference to an item
+	sxUndef        = 5  // undefined scalar
+	sxInteger      = 6  // native integer
+	sxDouble       = 7  // native double
+	sxByte         = 8  // integer from -128 to 127, stored plus 128
+	sxNetint       = 9  // 4-byte integer in network order
+	sxScalar       = 10 // scalar with a 1-byte length
+	sxSvUndef      = 14 // the immortal undef
+	sxSvYes        = 15 // the immortal true
+	sxSvNo         = 16 // the immortal false
+	sxBless        = 17 // blessed item, with its class name
+	sxIxBless      = 18 // blessed item, with the index of a class name seen before
+	sxOverload     = 20 // reference to an overloaded item
+	sxUTF8Str      = 23 // UTF-8 string with a 1-byte length
+	sxLUTF8Str     = 24 // UTF-8 string with a 4-byte length
+	sxFlagHash     = 25 // hash with flags
+	sxWeakRef      = 27 // weak reference
+	sxWeakOverload = 28 // weak reference to an overloaded item
+	sxSvUndefElem  = 31 // undefined array element
+	sxBooleanTrue  = 34 // boolean true (Perl 5.36)
+	sxBooleanFalse = 35 // boolean false (Perl 5.36)
+)
+
+// storableKeyIsSV marks a hash key stored as an item rather than a string.
+const storableKeyIsSV = 0x08
+
+// storableMaxDepth limits the nesting of decoded items.
+const storableMaxDepth = 10000
+
+// decodeStorable decodes a Storable document into nil, bool, int64,
+// float64, string, []interface{} and map[string]interface{} values.
+// References are followed transparently and blessed items lose their class.
+func decodeStorable(data []byte) (interface{}, error) {
+	data = bytes.TrimPrefix(data, []byte(storableFileMagic))
+	if !isStorable(data) {
+		return nil, errors.New("not a Storable document")
+	}
+
+	d := storableDecoder{data: data, pos: 2, order: binary.BigEndian}
+	if data[0]&1 == 0 {
+		if err := d.readNativeHeader(data[1]); err != nil {
+			return nil, err
+		}
+	}
+	return d.decode(0)
+}
+
+// storableDecoder reads the items of a Storable document.
+type storableDecoder struct {
+	data []byte
+	pos  int
+
+	// order, ivSize and nvSize describe native documents; network order
+	// documents have no native integers or doubles
+	order  binary.ByteOrder
+	ivSize int
+	nvSize int
+
+	// seen holds every decoded item by tag, for sxObject
+	seen []interface{}
+	// classes holds the class names of blessed items, for sxIxBless
+	classes []string
+}
+
+// readNativeHeader reads the byte order and type sizes that follow the
+// version of a native order document.
+func (d *storableDecoder) readNativeHeader(minor byte) error {
+	n, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if n != 4 && n != 8 {
+		return fmt.Errorf("unsupported integer size %d", n)
+	}
+	order, err := d.read(int(n))
+	if err != nil {
+		return err
+	}
+	switch {
+	case string(order) == "12345678"[:n]:
+		d.order = binary.LittleEndian
+	case string(order) == "87654321"[8-n:]:
+		d.order = binary.BigEndian
+	default:
+		return fmt.Errorf("unsupported byte order %q", order)
+	}
+	d.ivSize = int(n)
+
+	// sizeof(int), sizeof(long), sizeof(char *), then sizeof(NV)
+	sizes, err := d.read(3)
+	if err != nil {
+		return err
+	}
+	if sizes[0] != 4 {
+		return fmt.Errorf("unsupported int size %d", sizes[0])
+	}
+	d.nvSize = 8
+	if minor >= 2 {
+		nv, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		d.nvSize = int(nv)
+	}
+	return nil
+}
+
+func (d *storableDecoder) decode(depth int) (interface{}, error) {
+	if depth > storableMaxDepth {
+		return nil, errors.New("nesting too deep")
+	}
+
+	tag, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag == sxObject {
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		i := d.order.Uint32(b)
+		if uint64(i) >= uint64(len(d.seen)) {
+			return nil, fmt.Errorf("invalid object tag %d", i)
+		}
+		return d.seen[i], nil
+	}
+	if tag == sxBless || tag == sxIxBless {
+		if err := d.readClass(tag); err != nil {
+			return nil, err
+		}
+		return d.decode(depth + 1)
+	}
+
+	// Every other item gets the next tag, containers before their
+	// elements
+	i := len(d.seen)
+	d.seen = append(d.seen, nil)
+	v, err := d.decodeItem(tag, depth)
+	if err != nil {
+		return nil, err
+	}
+	d.seen[i] = v
+	return v, nil
+}
+
+func (d *storableDecoder) decodeItem(tag byte, depth int) (interface{}, error) {
+	switch tag {
+	case sxScalar, sxUTF8Str:
+		n, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(int(n))
+	case sxLScalar, sxLUTF8Str:
+		n, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case sxByte:
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return int64(b) - 128, nil
+	case sxNetint:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case sxInteger:
+		if d.ivSize == 0 {
+			return nil, errors.New("native integer in network order document")
+		}
+		b, err := d.read(d.ivSize)
+		if err != nil {
+			return nil, err
+		}
+		if d.ivSize == 4 {
+			return int64(int32(d.order.Uint32(b))), nil
+		}
+		return int64(d.order.Uint64(b)), nil
+	case sxDouble:
+		if d.nvSize != 8 {
+			return nil, fmt.Errorf("unsupported double size %d", d.nvSize)
+		}
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(d.order.Uint64(b)), nil
+	case sxUndef, sxSvUndef, sxSvUndefElem:
+		return nil, nil
+	case sxSvYes, sxBooleanTrue:
+		return true, nil
+	case sxSvNo, sxBooleanFalse:
+		return false, nil
+	case sxRef, sxOverload, sxWeakRef, sxWeakOverload:
+		return d.decode(depth + 1)
+	case sxArray:
+		n, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		a := make([]interface{}, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case sxHash, sxFlagHash:
+		return d.readHash(tag == sxFlagHash, depth)
+	default:
+		return nil, fmt.Errorf("unsupported item type %d at offset %d", tag, d.pos-1)
+	}
+}
+
+// readHash reads the pairs of a hash. Each value comes before its key.
+func (d *storableDecoder) readHash(flagged bool, depth int) (interface{}, error) {
+	if flagged {
+		if _, err := d.readByte(); err != nil { // hash flags
+			return nil, err
+		}
+	}
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if flagged {
+			flags, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if flags&storableKeyIsSV != 0 {
+				return nil, errors.New("unsupported hash key type")
+			}
+		}
+		klen, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		key, err := d.read(klen)
+		if err != nil {
+			return nil, err
+		}
+		m[string(key)] = v
+	}
+	return m, nil
+}
+
+// readClass reads the class name of a blessed item.
+func (d *storableDecoder) readClass(tag byte) error {
+	b, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	n := int(b)
+	if b&0x80 != 0 {
+		if n, err = d.readLength(); err != nil {
+			return err
+		}
+	}
+
+	if tag == sxIxBless {
+		if n >= len(d.classes) {
+			return fmt.Errorf("invalid class index %d", n)
+		}
+		return nil
+	}
+	name, err := d.read(n)
+	if err != nil {
+		return err
+	}
+	d.classes = append(d.classes, string(name))
+	return nil
+}
+
+func (d *storableDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *storableDecoder) read(n int) ([]byte, error) {
+	if n > len(d.data)-d.pos {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *storableDecoder) readString(n int) (interface{}, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// readLength reads a 4-byte length, size or tag. Values larger than the
+// rest of the document are rejected before anything is allocated.
+func (d *storableDecoder) readLength() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	n := d.order.Uint32(b)
+	if n > uint32(len(d.data)-d.pos) || n > math.MaxInt32 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
@@ -0,0 +1,252 @@
+package recentfile
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storableDoc builds Storable documents item by item.
+type storableDoc struct {
+	b     []byte
+	order binary.AppendByteOrder
+}
+
+func (d *storableDoc) u32(n int) {
+	d.b = d.order.AppendUint32(d.b, uint32(n))
+}
+
+func (d *storableDoc) scalar(s string) {
+	d.b = append(d.b, sxScalar, byte(len(s)))
+	d.b = append(d.b, s...)
+}
+
+func (d *storableDoc) key(k string) {
+	d.u32(len(k))
+	d.b = append(d.b, k...)
+}
+
+// storableRecentfile builds a recentfile the way Storable::nfreeze (or,
+// with native set, Storable::freeze on a little-endian 64-bit machine)
+// writes it. Values come before their keys in hashes.
+func storableRecentfile(native bool) []byte {
+	d := &storableDoc{b: []byte{storableMajor<<1 | 1, 11}, order: binary.BigEndian}
+	if native {
+		d.b = append([]byte{storableMajor << 1, 11, 8}, "12345678\x04\x08\x08\x08"...)
+		d.order = binary.LittleEndian
+	}
+
+	d.b = append(d.b, sxHash) // tag 0
+	d.u32(2)
+
+	d.b = append(d.b, sxRef, sxHash) // tags 1, 2
+	d.u32(4)
+	d.scalar("1h") // tag 3
+	d.key("interval")
+	d.b = append(d.b, sxByte, 128+1) // tag 4
+	d.key("protocol")
+	d.scalar(".storable") // tag 5
+	d.key("serializer_suffix")
+	d.b = append(d.b, sxRef, sxHash) // tags 6, 7
+	d.u32(2)
+	if native {
+		d.b = append(d.b, sxInteger) // tag 8
+		d.b = binary.LittleEndian.AppendUint64(d.b, 1704207900)
+	} else {
+		d.scalar("1704207900") // tag 8, a number Perl read from text
+	}
+	d.key("mtime")
+	d.scalar("1704207845.123456") // tag 9
+	d.key("max")
+	d.key("minmax")
+	d.key("meta")
+
+	d.b = append(d.b, sxRef, sxArray) // tags 10, 11
+	d.u32(2)
+	d.b = append(d.b, sxRef, sxHash) // tags 12, 13
+	d.u32(3)
+	d.scalar("1704207845.123456") // tag 14
+	d.key("epoch")
+	d.scalar("a.txt") // tag 15
+	d.key("path")
+	d.scalar("new") // tag 16
+	d.key("type")
+
+	d.b = append(d.b, sxRef, sxFlagHash, 0) // tags 17, 18
+	d.u32(3)
+	if native {
+		d.b = append(d.b, sxDouble) // tag 19
+		d.b = binary.LittleEndian.AppendUint64(d.b, math.Float64bits(1704207800.5))
+	} else {
+		d.scalar("1704207800.5") // tag 19
+	}
+	d.b = append(d.b, 0)
+	d.key("epoch")
+	d.b = append(d.b, sxUTF8Str, 10) // tag 20
+	d.b = append(d.b, "dír/b.txt"...)
+	d.b = append(d.b, 1) // UTF-8 key
+	d.key("path")
+	d.b = append(d.b, sxObject) // the "new" of the first event
+	d.u32(16)
+	d.b = append(d.b, 0)
+	d.key("type")
+
+	d.key("recent")
+	return d.b
+}
+
+func TestStorableUnmarshal(t *testing.T) {
+	want := []Event{
+		{Epoch: 1704207845.123456, Path: "a.txt", Type: "new"},
+		{Epoch: 1704207800.5, Path: "dír/b.txt", Type: "new"},
+	}
+
+	docs := map[string][]byte{
+		"nfreeze":        storableRecentfile(false),
+		"freeze":         storableRecentfile(true),
+		"nstore":         append([]byte(storableFileMagic), storableRecentfile(false)...),
+		"store, blessed": append([]byte(storableFileMagic), storableBlessed(storableRecentfile(true))...),
+	}
+
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			if got := sniffFormat(doc); got != ".storable" {
+				t.Errorf("sniffFormat = %q", got)
+			}
+
+			sd, err := (&StorableSerializer{}).Unmarshal(doc)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if sd.Meta.Interval != "1h" || sd.Meta.Protocol != 1 || sd.Meta.SerializerSuffix != ".storable" {
+				t.Errorf("meta = %+v", sd.Meta)
+			}
+			if sd.Meta.Minmax == nil || sd.Meta.Minmax.Mtime != 1704207900 || sd.Meta.Minmax.Max != 1704207845.123456 {
+				t.Errorf("minmax = %+v", sd.Meta.Minmax)
+			}
+			if len(sd.Recent) != len(want) {
+				t.Fatalf("events = %+v", sd.Recent)
+			}
+			for i := range want {
+				if sd.Recent[i] != want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, sd.Recent[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+// storableBlessed blesses the top-level hash of a native document.
+func storableBlessed(doc []byte) []byte {
+	header := 2 + 1 + 8 + 4
+	out := append([]byte{}, doc[:header]...)
+	out = append(out, sxBless, 4)
+	out = append(out, "Meta"...)
+	return append(out, doc[header:]...)
+}
+
+func TestStorableReadFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rfile := filepath.Join(tmpDir, "RECENT-1h.storable")
+	if err := os.WriteFile(rfile, storableRecentfile(false), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("RECENT-1h.storable", filepath.Join(tmpDir, "RECENT.recent")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{rfile, filepath.Join(tmpDir, "RECENT.recent")} {
+		rf, err := NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%s) failed: %v", path, err)
+		}
+		if events := rf.RecentEvents(); len(events) != 2 || events[0].Path != "a.txt" {
+			t.Errorf("%s: events = %+v", path, events)
+		}
+
+		var streamed []Event
+		stats, err := StreamEvents(path, 1, func(events []Event) bool {
+			streamed = append(streamed, events...)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("StreamEvents(%s) failed: %v", path, err)
+		}
+		if stats.EventCount != 2 || len(streamed) != 2 {
+			t.Errorf("%s: stats = %+v, streamed %d", path, stats, len(streamed))
+		}
+	}
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(".storable"))
+	if err := rf.Write(); err == nil {
+		t.Error("expected error writing a Storable recentfile")
+	}
+}
+
+// storableShared builds a document whose "recent" array holds arrays of
+// two references to the one before, so it doubles with each of n levels.
+func storableShared(n int) []byte {
+	d := &storableDoc{b: []byte{storableMajor<<1 | 1, 11}, order: binary.BigEndian}
+	d.b = append(d.b, sxHash) // tag 0
+	d.u32(1)
+	d.b = append(d.b, sxArray) // tag 1
+	d.u32(n + 1)
+	d.b = append(d.b, sxArray) // tag 2
+	d.u32(0)
+	for i := 0; i < n; i++ {
+		d.b = append(d.b, sxArray) // tag 3+i
+		d.u32(2)
+		for range 2 {
+			d.b = append(d.b, sxObject)
+			d.u32(2 + i)
+		}
+	}
+	d.key("recent")
+	return d.b
+}
+
+func TestStorableUnmarshalShared(t *testing.T) {
+	doc := storableShared(40)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := (&StorableSerializer{}).Unmarshal(doc)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Unmarshal of shared items did not finish")
+	}
+}
+
+func TestStorableUnmarshalInvalid(t *testing.T) {
+	doc := storableRecentfile(false)
+
+	tests := map[string][]byte{
+		"empty":         nil,
+		"yaml":          []byte("---\nmeta: {}\n"),
+		"truncated":     doc[:len(doc)-3],
+		"forward tag":   {storableMajor<<1 | 1, 11, sxObject, 0, 0, 0, 0},
+		"huge array":    {storableMajor<<1 | 1, 11, sxArray, 0x7f, 0xff, 0xff, 0xff},
+		"not a hash":    {storableMajor<<1 | 1, 11, sxScalar, 1, 'a'},
+		"byte order":    {storableMajor << 1, 11, 4, '3', '4', '1', '2', 4, 4, 4, 8, sxHash, 0, 0, 0, 0},
+		"code ref":      {storableMajor<<1 | 1, 11, 26},
+		"native in net": {storableMajor<<1 | 1, 11, sxInteger, 1, 2, 3, 4, 5, 6, 7, 8},
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := (&StorableSerializer{}).Unmarshal(doc); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package recentfile
+
+import "os"
+
+// syncDir syncs the directory dir to disk, making a rename in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
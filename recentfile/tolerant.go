@@ -0,0 +1,458 @@
+package recentfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReadTolerant reads the recentfile like Read, but recovers what it can
+// of a damaged file instead of failing on it: events that don't parse,
+// or that lack a path, a known type or an epoch, are dropped, as are the
+// events of a file cut off after the last whole one. It returns how many
+// were dropped, so the file can be written back valid (see fsck). The
+// metadata must be readable, and the file decryptable; a compressed file
+// is read as far as it decompresses. Formats other than YAML and JSON are
+// read as by Read, apart from the invalid events being dropped.
+func (rf *Recentfile) ReadTolerant() (dropped int, err error) {
+	return rf.ReadTolerantContext(context.Background())
+}
+
+// ReadTolerantContext is like ReadTolerant, but gives up fetching the
+// file when ctx is done.
+func (rf *Recentfile) ReadTolerantContext(ctx context.Context) (dropped int, err error) {
+	rfile := rf.Rfile()
+
+	var f Fetcher = DirFetcher("") // rfile is a path
+	if rf.fetcher != nil {
+		f = rf.fetcher
+	}
+	data, err := f.Fetch(ctx, rfile)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", rfile, err)
+	}
+	if err := verifySignature(ctx, f, rfile, data); err != nil {
+		return 0, err
+	}
+
+	sd, dropped, err := unmarshalTolerant(data, rf.serializerSuffix)
+	if err != nil {
+		return 0, fmt.Errorf("unmarshal %s: %w", rfile, err)
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.setData(sd)
+	return dropped, nil
+}
+
+// NewFromFileTolerant is like NewFromFile, reading the file with
+// ReadTolerant, and returns how many events were dropped. path must be
+// named as the recentfile, e.g. RECENT-1h.yaml, not RECENT.recent.
+func NewFromFileTolerant(path string) (*Recentfile, int, error) {
+	root, interval, suffix, err := SplitRfilename(filepath.Base(path))
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse filename %s: %w", filepath.Base(path), err)
+	}
+	rf := &Recentfile{
+		localRoot:        filepath.Dir(path),
+		rfile:            path,
+		interval:         interval,
+		filenameRoot:     root,
+		serializerSuffix: suffix,
+		meta: MetaData{
+			Protocol:         1,
+			Filenameroot:     root,
+			Interval:         interval,
+			SerializerSuffix: suffix,
+		},
+		done: &Done{rfInterval: interval},
+	}
+	dropped, err := rf.ReadTolerant()
+	if err != nil {
+		return nil, 0, err
+	}
+	return rf, dropped, nil
+}
+
+// TolerantUnmarshaler is implemented by serializers that can recover the
+// metadata and the events that are intact from damaged data (see
+// ReadTolerant). UnmarshalTolerant returns how many events it dropped.
+type TolerantUnmarshaler interface {
+	UnmarshalTolerant(data []byte) (*SerializedData, int, error)
+}
+
+// unmarshalTolerant deserializes data with the serializer for suffix,
+// recovering what it can if the data is damaged, and drops invalid
+// events. It returns how many events were dropped.
+func unmarshalTolerant(data []byte, suffix string) (*SerializedData, int, error) {
+	serializer, err := GetSerializer(suffix)
+	if err != nil {
+		return nil, 0, err
+	}
+	sd, err := serializer.Unmarshal(data)
+	dropped := 0
+	if err != nil {
+		t, ok := serializer.(TolerantUnmarshaler)
+		if !ok {
+			return nil, 0, corrupt(err)
+		}
+		if sd, dropped, err = t.UnmarshalTolerant(data); err != nil {
+			return nil, 0, corrupt(err)
+		}
+	}
+
+	valid := sd.Recent[:0]
+	for _, event := range sd.Recent {
+		if event.Valid() {
+			valid = append(valid, event)
+		}
+	}
+	dropped += len(sd.Recent) - len(valid)
+	sd.Recent = valid
+	return sd, dropped, nil
+}
+
+// Valid reports whether e has what every event needs: a path, a known
+// type and an epoch, and a whole checksum if it has one. ReadTolerant
+// drops the events that aren't.
+func (e Event) Valid() bool {
+	if e.Path == "" || (e.Type != "new" && e.Type != "delete") || e.Epoch <= 0 {
+		return false
+	}
+	if e.Sha256 != "" {
+		if _, err := hex.DecodeString(e.Sha256); err != nil || len(e.Sha256) != 64 {
+			return false
+		}
+	}
+	return true
+}
+
+// UnmarshalTolerant decrypts data and recovers it with the inner
+// serializer, if it can. Data that doesn't decrypt is lost.
+func (s *EncryptedSerializer) UnmarshalTolerant(data []byte) (*SerializedData, int, error) {
+	plain, err := decrypt(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return unmarshalInner(s.Inner, plain)
+}
+
+// UnmarshalTolerant decompresses as much of data as it can and recovers
+// that with the inner serializer, if it can.
+func (s *CompressedSerializer) UnmarshalTolerant(data []byte) (*SerializedData, int, error) {
+	zr, err := decompressReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer zr.Close()
+	plain, _ := io.ReadAll(zr) // up to where the data is damaged
+	return unmarshalInner(s.Inner, plain)
+}
+
+// unmarshalInner deserializes data with s, recovering it if s is a
+// TolerantUnmarshaler.
+func unmarshalInner(s Serializer, data []byte) (*SerializedData, int, error) {
+	sd, err := s.Unmarshal(data)
+	if err == nil {
+		return sd, 0, nil
+	}
+	t, ok := s.(TolerantUnmarshaler)
+	if !ok {
+		return nil, 0, err
+	}
+	return t.UnmarshalTolerant(data)
+}
+
+// errNoMeta is returned when the metadata of damaged data can't be
+// recovered.
+var errNoMeta = fmt.Errorf("%w: no readable metadata", ErrCorrupt)
+
+// UnmarshalTolerant recovers damaged YAML by parsing each top-level key
+// and each event on its own: the events are the items of the "recent"
+// sequence, found by their indentation.
+func (s *YAMLSerializer) UnmarshalTolerant(data []byte) (*SerializedData, int, error) {
+	var sd SerializedData
+	haveMeta := false
+	dropped := 0
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		key, ok := yamlTopLevelKey(line)
+		if !ok {
+			i++ // a document marker, comment or garbage
+			continue
+		}
+		end := i + 1
+		for end < len(lines) {
+			if _, ok := yamlTopLevelKey(lines[end]); ok {
+				break
+			}
+			end++
+		}
+		section := lines[i:end]
+		i = end
+
+		switch key {
+		case "meta":
+			var aux struct {
+				Meta MetaData `yaml:"meta"`
+			}
+			if err := yaml.Unmarshal([]byte(strings.Join(section, "")), &aux); err == nil {
+				sd.Meta, haveMeta = aux.Meta, true
+			}
+		case "recent":
+			events, n := yamlItems(section)
+			sd.Recent = append(sd.Recent, events...)
+			dropped += n
+		}
+	}
+
+	if !haveMeta {
+		return nil, 0, errNoMeta
+	}
+	return &sd, dropped, nil
+}
+
+// yamlTopLevelKey returns the key of a line starting a top-level entry
+// of a mapping, such as "meta:".
+func yamlTopLevelKey(line string) (string, bool) {
+	key, _, ok := strings.Cut(line, ":")
+	if !ok || key == "" {
+		return "", false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_') {
+			return "", false
+		}
+	}
+	return key, true
+}
+
+// yamlItems parses the items of the sequence under the key line that
+// starts section one by one, returning the events and how many items
+// didn't parse. Items start with a "-" at the indentation of the first.
+func yamlItems(section []string) ([]Event, int) {
+	if _, value, _ := strings.Cut(section[0], ":"); strings.TrimSpace(value) != "" {
+		// A flow sequence, e.g. "recent: []", parses whole or not at all
+		var aux struct {
+			Recent eventList `yaml:"recent"`
+		}
+		if err := yaml.Unmarshal([]byte(strings.Join(section, "")), &aux); err != nil {
+			return nil, 1
+		}
+		return aux.Recent, 0
+	}
+
+	indent := ""
+	var items [][]string
+	for _, line := range section[1:] {
+		trimmed := strings.TrimLeft(line, " ")
+		if indent == "" && strings.HasPrefix(trimmed, "-") {
+			indent = line[:len(line)-len(trimmed)]
+		}
+		switch {
+		case indent != "" && strings.HasPrefix(line, indent+"-"):
+			items = append(items, []string{line})
+		case len(items) > 0:
+			items[len(items)-1] = append(items[len(items)-1], line)
+		}
+	}
+
+	var events []Event
+	dropped := 0
+	for _, item := range items {
+		var buf strings.Builder
+		for _, line := range item {
+			buf.WriteString(strings.TrimPrefix(line, indent))
+		}
+		var parsed eventList
+		if err := yaml.Unmarshal([]byte(buf.String()), &parsed); err != nil || len(parsed) != 1 {
+			dropped++
+			continue
+		}
+		events = append(events, parsed[0])
+	}
+	return events, dropped
+}
+
+// UnmarshalTolerant recovers damaged JSON by matching brackets: the
+// metadata and each event of the "recent" array are parsed on their own,
+// and a file cut off loses only the events after the last whole one.
+func (s *JSONSerializer) UnmarshalTolerant(data []byte) (*SerializedData, int, error) {
+	var sd SerializedData
+	haveMeta := false
+	dropped := 0
+
+	i := skipJSONSpace(data, 0)
+	if i >= len(data) || data[i] != '{' {
+		return nil, 0, errNoMeta
+	}
+	i++
+	for {
+		i = skipJSONSpace(data, i)
+		if i >= len(data) || data[i] == '}' {
+			break
+		}
+		end := jsonValueEnd(data, i)
+		var key string
+		if end < 0 || json.Unmarshal(data[i:end], &key) != nil {
+			break
+		}
+		i = skipJSONSpace(data, end)
+		if i >= len(data) || data[i] != ':' {
+			break
+		}
+		i = skipJSONSpace(data, i+1)
+
+		if key == "recent" && i < len(data) && data[i] == '[' {
+			var events []Event
+			var n int
+			events, n, i = jsonItems(data, i+1)
+			sd.Recent = append(sd.Recent, events...)
+			dropped += n
+		} else {
+			end := jsonValueEnd(data, i)
+			if end < 0 {
+				break
+			}
+			if key == "meta" && json.Unmarshal(data[i:end], &sd.Meta) == nil {
+				haveMeta = true
+			}
+			i = end
+		}
+
+		i = skipJSONSpace(data, i)
+		if i < len(data) && data[i] == ',' {
+			i++
+		}
+	}
+
+	if !haveMeta {
+		return nil, 0, errNoMeta
+	}
+	return &sd, dropped, nil
+}
+
+// jsonItems parses the elements of the array starting at data[i], just
+// after its "[", one by one. It returns the events, how many elements
+// didn't parse and the index just past the array, or past the data if
+// it is cut off.
+func jsonItems(data []byte, i int) ([]Event, int, int) {
+	var events []Event
+	dropped := 0
+	for {
+		i = skipJSONSpace(data, i)
+		if i >= len(data) {
+			return events, dropped, i
+		}
+		if data[i] == ']' {
+			return events, dropped, i + 1
+		}
+		end := jsonValueEnd(data, i)
+		var event Event
+		if end >= 0 && data[i] == '{' && (*fileEvent)(&event).UnmarshalJSON(data[i:end]) == nil {
+			events = append(events, event)
+		} else {
+			// A broken string throws the brackets off, so go on at the
+			// next event rather than where this one seems to end
+			dropped++
+			if next := nextJSONEvent(data, i+1); next >= 0 {
+				i = next
+				continue
+			}
+			if end < 0 {
+				return events, dropped, len(data) // cut off
+			}
+			end = max(end, i+1)
+		}
+		i = skipJSONSpace(data, end)
+		if i < len(data) && data[i] == ',' {
+			i++
+		}
+	}
+}
+
+// nextJSONEvent returns the index of the next event from data[i] on, or
+// -1. Events start with their epoch, both as written here and by the
+// Perl tools, which sort their keys.
+func nextJSONEvent(data []byte, i int) int {
+	for {
+		j := bytes.IndexByte(data[i:], '{')
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if rest := data[skipJSONSpace(data, i+1):]; bytes.HasPrefix(rest, []byte(`"epoch"`)) {
+			return i
+		}
+		i++
+	}
+}
+
+// skipJSONSpace returns the index of the first byte from data[i] on that
+// is not JSON whitespace.
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) && strings.IndexByte(" \t\r\n", data[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// jsonValueEnd returns the index just past the JSON value starting at
+// data[i], matching brackets outside strings without checking what is
+// between them, or -1 if the data ends first. A scalar ends at the next
+// delimiter.
+func jsonValueEnd(data []byte, i int) int {
+	if i >= len(data) {
+		return -1
+	}
+	switch data[i] {
+	case '{', '[', '"':
+	default:
+		for j := i; j < len(data); j++ {
+			if strings.IndexByte(",]} \t\r\n", data[j]) >= 0 {
+				return j
+			}
+		}
+		return len(data)
+	}
+
+	depth := 0
+	inString := false
+	for j := i; j < len(data); j++ {
+		c := data[j]
+		if inString {
+			switch c {
+			case '\\':
+				j++
+			case '"':
+				inString = false
+				if depth == 0 {
+					return j + 1
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,112 @@
+package recentfile
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadTolerant(t *testing.T) {
+	for _, suffix := range []string{".yaml", ".json", ".json.gz"} {
+		t.Run(suffix, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			rf := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix), WithAggregator([]string{"6h"}))
+			for _, path := range []string{"a", "b", "c", "d"} {
+				if err := rf.Update(path, "new"); err != nil {
+					t.Fatalf("Update failed: %v", err)
+				}
+			}
+			good, err := os.ReadFile(rf.Rfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(name string, data []byte, want []string, wantDropped int) {
+				t.Helper()
+				if err := os.WriteFile(rf.Rfile(), data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				damaged := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix))
+				dropped, err := damaged.ReadTolerant()
+				if err != nil {
+					t.Fatalf("%s: ReadTolerant failed: %v", name, err)
+				}
+				var paths []string
+				for _, event := range damaged.RecentEvents() {
+					paths = append(paths, event.Path)
+				}
+				if !slices.Equal(paths, want) || dropped != wantDropped {
+					t.Errorf("%s: got %v with %d dropped, want %v with %d", name, paths, dropped, want, wantDropped)
+				}
+				if got := damaged.Meta().Aggregator; !slices.Equal(got, []string{"6h"}) {
+					t.Errorf("%s: aggregator = %v", name, got)
+				}
+			}
+
+			// What decompresses of a cut off file is read
+			if suffix == ".json.gz" {
+				check("truncated", good[:len(good)-10], []string{"d", "c", "b", "a"}, 0)
+				return
+			}
+
+			// Cut off in the middle of the third event, newest first
+			text := string(good)
+			i := strings.Index(text, `"b"`)
+			if suffix == ".yaml" {
+				i = strings.Index(text, "path: b")
+			}
+			check("truncated", []byte(text[:i+5]), []string{"d", "c"}, 1)
+
+			// Cut off right after the key of the events
+			key := `"recent":`
+			if suffix == ".yaml" {
+				key = "recent:"
+			}
+			check("truncated after key", []byte(text[:strings.Index(text, key)+len(key)]), nil, 0)
+
+			// One event garbled
+			garbled := strings.Replace(text, `"c"`, `"c`, 1)
+			if suffix == ".yaml" {
+				garbled = strings.Replace(text, "path: c", "path: [c", 1)
+			}
+			if err := os.WriteFile(rf.Rfile(), []byte(garbled), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := rf.Read(); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Read of garbled file = %v, want ErrCorrupt", err)
+			}
+			check("garbled", []byte(garbled), []string{"d", "b", "a"}, 1)
+
+			// Without metadata nothing can be rebuilt
+			if err := os.WriteFile(rf.Rfile(), []byte(text[strings.Index(text, "recent"):]), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithSerializerSuffix(suffix)).ReadTolerant(); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("ReadTolerant without metadata = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
+
+func TestReadTolerantDropsInvalidEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"))
+	rf.SetRecentEvents([]Event{
+		{Epoch: 3, Path: "ok", Type: "new"},
+		{Epoch: 2, Path: "", Type: "new"},
+		{Epoch: 1, Path: "bad-type", Type: "changed"},
+	})
+	if err := rf.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	dropped, err := rf.ReadTolerant()
+	if err != nil {
+		t.Fatalf("ReadTolerant failed: %v", err)
+	}
+	if events := rf.RecentEvents(); dropped != 2 || len(events) != 1 || events[0].Path != "ok" {
+		t.Errorf("got %+v with %d dropped", events, dropped)
+	}
+}
@@ -0,0 +1,20 @@
+package recentfile
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces batch updates, aggregation and merges. Spans are only
+// recorded once the program installs an OpenTelemetry tracer provider.
+var tracer = otel.Tracer("github.com/abh/rrrgo/recentfile")
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
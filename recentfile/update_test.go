@@ -234,6 +234,32 @@ func TestPathCanonicalization(t *testing.T) {
 	}
 }
 
+func TestCanonizePathRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A root given with a trailing separator or an unclean path
+	for _, root := range []string{tmpDir, tmpDir + string(filepath.Separator), filepath.Join(tmpDir, "x") + string(filepath.Separator) + ".."} {
+		rf := New(WithLocalRoot(root), WithInterval("1h"))
+		tests := []struct {
+			input string
+			want  string
+		}{
+			{filepath.Join(tmpDir, "foo", "bar.txt"), "foo/bar.txt"},
+			{filepath.Join("foo", "bar.txt"), "foo/bar.txt"},
+			{"foo/bar.txt", "foo/bar.txt"},
+		}
+		for _, tt := range tests {
+			got, err := rf.canonizePath(tt.input)
+			if err != nil {
+				t.Fatalf("canonizePath(%q) failed: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("root %q: canonizePath(%q) = %q, want %q", root, tt.input, got, tt.want)
+			}
+		}
+	}
+}
+
 func TestTruncateByInterval(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -271,6 +297,44 @@ func TestTruncateByInterval(t *testing.T) {
 	}
 }
 
+func TestTruncateRetention(t *testing.T) {
+	nowFloat := EpochToFloat(EpochNow())
+	events := []Event{
+		{Epoch: EpochFromFloat(nowFloat), Path: "current.txt", Type: "new"},
+		{Epoch: EpochFromFloat(nowFloat - 1800), Path: "30min.txt", Type: "new"},
+		{Epoch: EpochFromFloat(nowFloat - 7200), Path: "2hours.txt", Type: "new"},
+	}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		merged float64 // seconds ago
+		want   int
+	}{
+		{"retention keeps merged events for the interval", nil, 600, 2},
+		{"retention keeps events not merged yet", nil, 3 * 3600, 3},
+		{"truncate at merge", []Option{WithRetention(false)}, 600, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := New(append([]Option{WithLocalRoot(t.TempDir()), WithInterval("1h")}, tt.opts...)...)
+			rf.meta.Merged = &MergedInfo{Epoch: EpochFromFloat(nowFloat - tt.merged)}
+
+			got := rf.truncate(append([]Event(nil), events...))
+			if len(got) != tt.want {
+				t.Errorf("kept %d events, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+
+	// Aggregate files inherit the setting
+	rf := New(WithInterval("1h"), WithRetention(false))
+	if clone := rf.SparseClone(); !clone.truncateAtMerge {
+		t.Error("SparseClone lost the retention setting")
+	}
+}
+
 func TestMinmaxUpdate(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -423,3 +487,151 @@ func TestEnsureMonotonic(t *testing.T) {
 		t.Errorf("ensureMonotonic(50.0, empty) = %v, want 50.0", result)
 	}
 }
+
+func TestUpdateKeepsProducers(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A file written by the Perl implementation
+	perl := New(WithLocalRoot(tmpDir), WithInterval("1h"))
+	perl.meta.Producers = map[string]interface{}{
+		"File::Rsync::Mirror::Recentfile": "0.0.8",
+		"$0":                              "/usr/bin/rrr-server",
+		"time":                            1704207845.5,
+	}
+	if err := perl.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		opts    []Option
+		name    string
+		version string
+	}{
+		{nil, DefaultProducer, ""},
+		{[]Option{WithProducer("example.org/mirror", "2.1")}, "example.org/mirror", "2.1"},
+	} {
+		rf := New(append([]Option{WithLocalRoot(tmpDir), WithInterval("1h")}, tt.opts...)...)
+		if err := rf.BatchUpdate([]BatchItem{{Path: "a.txt", Type: "new"}}); err != nil {
+			t.Fatalf("BatchUpdate failed: %v", err)
+		}
+
+		rf2, err := NewFromFile(rf.Rfile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		producers := rf2.Meta().Producers
+		if producers["File::Rsync::Mirror::Recentfile"] != "0.0.8" {
+			t.Errorf("%s: Perl producer lost: %v", tt.name, producers)
+		}
+		if v, ok := producers[tt.name]; !ok || (tt.version != "" && v != tt.version) {
+			t.Errorf("%s: producer not recorded: %v", tt.name, producers)
+		}
+		if producers["$0"] == "/usr/bin/rrr-server" || producers["time"] == 1704207845.5 {
+			t.Errorf("%s: $0 and time not updated: %v", tt.name, producers)
+		}
+	}
+}
+
+func TestDeferredWrites(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(
+		WithLocalRoot(tmpDir),
+		WithInterval("1h"),
+		WithDeferredWrites(time.Hour, 3),
+	)
+
+	onDisk := func() int {
+		t.Helper()
+		rf2, err := NewFromFile(rf.Rfile())
+		if err != nil {
+			t.Fatalf("NewFromFile failed: %v", err)
+		}
+		return len(rf2.recent)
+	}
+
+	// The first update writes the file
+	if err := rf.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if n := onDisk(); n != 1 {
+		t.Fatalf("%d events on disk after the first update, want 1", n)
+	}
+
+	// Later ones stay in memory until the interval passes
+	for _, name := range []string{"b.txt", "c.txt"} {
+		if err := rf.Update(filepath.Join(tmpDir, name), "new"); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+	if n := onDisk(); n != 1 {
+		t.Errorf("%d events on disk with deferred writes, want 1", n)
+	}
+	if rf.Pending() != 2 || rf.FlushDue() {
+		t.Errorf("Pending() = %d, FlushDue() = %v", rf.Pending(), rf.FlushDue())
+	}
+
+	// Reading doesn't lose what isn't written yet
+	if err := rf.Read(); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(rf.recent) != 3 {
+		t.Errorf("%d events in memory after Read, want 3", len(rf.recent))
+	}
+
+	if err := rf.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if n := onDisk(); n != 3 || rf.Pending() != 0 {
+		t.Errorf("%d events on disk, %d pending after Flush", n, rf.Pending())
+	}
+
+	// Reaching maxEvents writes right away
+	for _, name := range []string{"d.txt", "e.txt", "f.txt"} {
+		if err := rf.Update(filepath.Join(tmpDir, name), "new"); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+	if n := onDisk(); n != 6 || rf.Pending() != 0 {
+		t.Errorf("%d events on disk, %d pending after maxEvents updates", n, rf.Pending())
+	}
+
+	// Once the interval has passed, a flush is due
+	rf.SetDeferredWrites(time.Nanosecond, 0)
+	if err := rf.Update(filepath.Join(tmpDir, "g.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if n := onDisk(); n != 7 {
+		t.Errorf("%d events on disk after the interval, want 7", n)
+	}
+}
+
+func TestComment(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	rf := New(WithLocalRoot(tmpDir), WithInterval("1h"))
+	if err := rf.Update(filepath.Join(tmpDir, "a.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// The comment replaces the one read from the file
+	rf2 := New(WithLocalRoot(tmpDir), WithInterval("1h"), WithComment("mirror of example.org"))
+	if err := rf2.Update(filepath.Join(tmpDir, "b.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	rf3, err := NewFromFile(rf.Rfile())
+	if err != nil {
+		t.Fatalf("NewFromFile failed: %v", err)
+	}
+	if got := rf3.Meta().Comment; got != "mirror of example.org" {
+		t.Errorf("comment = %q", got)
+	}
+
+	// Without one, the comment of the file is kept
+	if err := rf.Update(filepath.Join(tmpDir, "c.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got := rf.Meta().Comment; got != "mirror of example.org" {
+		t.Errorf("comment after update = %q", got)
+	}
+}
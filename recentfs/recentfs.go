@@ -0,0 +1,279 @@
+// Package recentfs exposes the files a RECENT hierarchy reports as changed
+// within a time window as a read-only FUSE filesystem, so tools that know
+// nothing about RECENT files (backup, indexing) can work on just what
+// changed.
+package recentfs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/rsynclist"
+)
+
+// View is the set of recently changed files, arranged as a directory tree.
+type View struct {
+	// dirs maps a directory ("" for the root) to its sorted entries.
+	dirs  map[string][]string
+	files map[string]bool
+}
+
+// NewView builds a view of the paths whose latest event is "new".
+func NewView(events []recentfile.Event) *View {
+	v := &View{
+		dirs:  map[string][]string{"": nil},
+		files: make(map[string]bool),
+	}
+
+	for _, event := range events {
+		if event.Type != "new" || !validPath(event.Path) || v.files[event.Path] {
+			continue
+		}
+		v.files[event.Path] = true
+
+		// Add the path to its parent, and new parents to theirs
+		child := event.Path
+		for child != "" {
+			dir := parentDir(child)
+			_, known := v.dirs[dir]
+			v.dirs[dir] = append(v.dirs[dir], path.Base(child))
+			if known {
+				break
+			}
+			child = dir
+		}
+	}
+
+	// A path may be both a file and a directory in the history
+	for dir, names := range v.dirs {
+		sort.Strings(names)
+		v.dirs[dir] = slices.Compact(names)
+	}
+
+	return v
+}
+
+// IsDir reports whether rel is a directory in the view.
+func (v *View) IsDir(rel string) bool {
+	_, ok := v.dirs[rel]
+	return ok
+}
+
+// IsFile reports whether rel is a changed file in the view.
+func (v *View) IsFile(rel string) bool {
+	return v.files[rel]
+}
+
+// Entries returns the names in directory rel.
+func (v *View) Entries(rel string) []string {
+	return v.dirs[rel]
+}
+
+// Len returns the number of files in the view.
+func (v *View) Len() int {
+	return len(v.files)
+}
+
+// validPath rejects paths that would escape the local root.
+func validPath(p string) bool {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p {
+		return false
+	}
+	return p != ".." && !strings.HasPrefix(p, "../")
+}
+
+func parentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// FS serves a View of a hierarchy. File contents and attributes come from
+// the files below the local root.
+type FS struct {
+	rec    *recent.Recent
+	root   string
+	window time.Duration
+
+	view atomic.Pointer[View]
+}
+
+// New creates an FS showing files changed within window. Call Refresh to
+// load the view.
+func New(rec *recent.Recent, window time.Duration) *FS {
+	f := &FS{
+		rec:    rec,
+		root:   rec.LocalRoot(),
+		window: window,
+	}
+	f.view.Store(NewView(nil))
+	return f
+}
+
+// Refresh rebuilds the view from the recentfiles on disk.
+func (f *FS) Refresh() error {
+	since := recentfile.EpochFromTime(time.Now().Add(-f.window))
+	events, err := rsynclist.Changes(f.rec, since)
+	if err != nil {
+		return fmt.Errorf("collect changes: %w", err)
+	}
+	f.view.Store(NewView(events))
+	return nil
+}
+
+// View returns the current view.
+func (f *FS) View() *View {
+	return f.view.Load()
+}
+
+// Root returns the root directory node to mount.
+func (f *FS) Root() fs.InodeEmbedder {
+	return &dirNode{fsys: f}
+}
+
+// Mount mounts the filesystem read-only at mountpoint.
+func (f *FS) Mount(mountpoint string, timeout time.Duration) (*fuse.Server, error) {
+	return fs.Mount(mountpoint, f.Root(), &fs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: fuse.MountOptions{
+			FsName:        "rrr:" + f.root,
+			Name:          "rrr",
+			Options:       []string{"ro"},
+			DirectMount:   true,
+			DisableXAttrs: true,
+		},
+	})
+}
+
+func (f *FS) realPath(rel string) string {
+	return filepath.Join(f.root, filepath.FromSlash(rel))
+}
+
+// lookup creates the node for rel, or fails with ENOENT.
+func (f *FS) lookup(ctx context.Context, parent *fs.Inode, rel string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	view := f.View()
+	if !view.IsDir(rel) && !view.IsFile(rel) {
+		return nil, syscall.ENOENT
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(f.realPath(rel), &st); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+
+	// The file on disk decides, in case the path changed type
+	var node fs.InodeEmbedder
+	mode := uint32(st.Mode) & syscall.S_IFMT
+	switch {
+	case mode == syscall.S_IFDIR && view.IsDir(rel):
+		node = &dirNode{fsys: f, rel: rel}
+	case mode == syscall.S_IFREG && view.IsFile(rel):
+		node = &fileNode{fsys: f, rel: rel}
+	default:
+		return nil, syscall.ENOENT
+	}
+
+	out.Attr.FromStat(&st)
+	readOnly(&out.Attr)
+
+	return parent.NewInode(ctx, node, fs.StableAttr{Mode: mode}), 0
+}
+
+// getattr fills out with the attributes of the file behind rel.
+func (f *FS) getattr(rel string, out *fuse.AttrOut) syscall.Errno {
+	var st syscall.Stat_t
+	if err := syscall.Stat(f.realPath(rel), &st); err != nil {
+		return fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	readOnly(&out.Attr)
+	return 0
+}
+
+func readOnly(attr *fuse.Attr) {
+	attr.Mode &^= 0o222
+}
+
+// dirNode is a directory of the view.
+type dirNode struct {
+	fs.Inode
+	fsys *FS
+	rel  string
+}
+
+var (
+	_ fs.NodeLookuper  = (*dirNode)(nil)
+	_ fs.NodeReaddirer = (*dirNode)(nil)
+	_ fs.NodeGetattrer = (*dirNode)(nil)
+)
+
+// Lookup finds a child in the current view.
+func (d *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	return d.fsys.lookup(ctx, d.EmbeddedInode(), path.Join(d.rel, name), out)
+}
+
+// Readdir lists the directory's entries in the current view.
+func (d *dirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	view := d.fsys.View()
+
+	var entries []fuse.DirEntry
+	for _, name := range view.Entries(d.rel) {
+		mode := uint32(fuse.S_IFREG)
+		if view.IsDir(path.Join(d.rel, name)) {
+			mode = fuse.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+// Getattr returns the attributes of the underlying directory.
+func (d *dirNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	return d.fsys.getattr(d.rel, out)
+}
+
+// fileNode is a changed file; reads go to the file below the local root.
+type fileNode struct {
+	fs.Inode
+	fsys *FS
+	rel  string
+}
+
+var (
+	_ fs.NodeOpener    = (*fileNode)(nil)
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+)
+
+// Open opens the underlying file read-only.
+func (n *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	fd, err := syscall.Open(n.fsys.realPath(n.rel), syscall.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	return fs.NewLoopbackFile(fd), 0, 0
+}
+
+// Getattr returns the attributes of the underlying file.
+func (n *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	return n.fsys.getattr(n.rel, out)
+}
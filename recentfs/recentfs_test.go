@@ -0,0 +1,143 @@
+package recentfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestNewView(t *testing.T) {
+	v := NewView([]recentfile.Event{
+		{Path: "authors/id/A/AB/ABH/Foo-1.0.tar.gz", Type: "new"},
+		{Path: "authors/id/A/AB/ABH/Foo-0.9.tar.gz", Type: "delete"},
+		{Path: "authors/id/B/BA/BAR/Bar-2.0.tar.gz", Type: "new"},
+		{Path: "index.html", Type: "new"},
+		{Path: "../escape", Type: "new"},
+		{Path: "/abs", Type: "new"},
+	})
+
+	if v.Len() != 3 {
+		t.Errorf("Len = %d, want 3", v.Len())
+	}
+	if got := v.Entries(""); !reflect.DeepEqual(got, []string{"authors", "index.html"}) {
+		t.Errorf("root entries = %v", got)
+	}
+	if got := v.Entries("authors/id"); !reflect.DeepEqual(got, []string{"A", "B"}) {
+		t.Errorf("authors/id entries = %v", got)
+	}
+	if got := v.Entries("authors/id/A/AB/ABH"); !reflect.DeepEqual(got, []string{"Foo-1.0.tar.gz"}) {
+		t.Errorf("ABH entries = %v", got)
+	}
+	if !v.IsDir("authors/id/A") || v.IsFile("authors/id/A") {
+		t.Error("authors/id/A should be a directory")
+	}
+	if v.IsFile("authors/id/A/AB/ABH/Foo-0.9.tar.gz") {
+		t.Error("deleted file should not be in the view")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"1d", "Z"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatalf("EnsureFilesExist failed: %v", err)
+	}
+
+	now := time.Now()
+	principal.SetRecentEvents([]recentfile.Event{
+		{Epoch: recentfile.EpochFromTime(now.Add(-time.Minute)), Path: "a/new.txt", Type: "new"},
+	})
+	if err := principal.Write(); err != nil {
+		t.Fatal(err)
+	}
+	z := rec.RecentfileByInterval("Z")
+	z.SetRecentEvents([]recentfile.Event{
+		{Epoch: recentfile.EpochFromTime(now.Add(-48 * time.Hour)), Path: "a/old.txt", Type: "new"},
+	})
+	if err := z.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := New(rec, 24*time.Hour)
+	if fsys.View().Len() != 0 {
+		t.Error("view should be empty before Refresh")
+	}
+	if err := fsys.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	if got := fsys.View().Entries("a"); !reflect.DeepEqual(got, []string{"new.txt"}) {
+		t.Errorf("a/ entries = %v", got)
+	}
+}
+
+func TestMount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a"), 0o755)
+	os.WriteFile(filepath.Join(root, "a", "new.txt"), []byte("hello"), 0o644)
+	os.WriteFile(filepath.Join(root, "a", "old.txt"), []byte("old"), 0o644)
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(root),
+		recentfile.WithInterval("1h"),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal.SetRecentEvents([]recentfile.Event{
+		{Epoch: recentfile.EpochFromTime(time.Now()), Path: "a/new.txt", Type: "new"},
+	})
+	if err := principal.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := New(rec, time.Hour)
+	if err := fsys.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	mnt := t.TempDir()
+	server, err := fsys.Mount(mnt, time.Second)
+	if err != nil {
+		t.Skipf("mount not available: %v", err)
+	}
+	defer server.Unmount()
+
+	data, err := os.ReadFile(filepath.Join(mnt, "a", "new.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("read new.txt = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(mnt, "a", "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("old.txt should not be visible: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(mnt, "a", "new.txt"), []byte("x"), 0o644); err == nil {
+		t.Error("write should fail")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(mnt, "a"))
+	if err != nil || len(entries) != 1 || entries[0].Name() != "new.txt" {
+		t.Errorf("ReadDir = %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package rsynclist turns RECENT events into rsync file lists, so downstreams
+// without a RECENT client can run targeted rsyncs instead of full-tree scans.
+package rsynclist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Changes returns the latest event for every path changed after since,
+// sorted by path. Events are read from the recentfiles on disk.
+func Changes(rec *recent.Recent, since recentfile.Epoch) ([]recentfile.Event, error) {
+	latest := make(map[string]recentfile.Event)
+
+	for _, rf := range rec.Recentfiles() {
+		rfilePath := rf.Rfile()
+
+		// Aggregate files may not have been written yet
+		if _, err := os.Stat(rfilePath); os.IsNotExist(err) {
+			continue
+		}
+
+		_, err := recentfile.StreamEvents(rfilePath, 10000, func(events []recentfile.Event) bool {
+			for _, event := range events {
+				// Events are stored newest first
+				if recentfile.EpochLe(event.Epoch, since) {
+					return false
+				}
+				if existing, ok := latest[event.Path]; !ok || recentfile.EpochGt(event.Epoch, existing.Epoch) {
+					latest[event.Path] = event
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", filepath.Base(rfilePath), err)
+		}
+	}
+
+	result := make([]recentfile.Event, 0, len(latest))
+	for _, event := range latest {
+		result = append(result, event)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	return result, nil
+}
+
+// List formats accepted by Write.
+const (
+	FilesFrom   = "files-from"
+	IncludeFrom = "include-from"
+)
+
+// Write writes events as a list in format, FilesFrom or IncludeFrom.
+func Write(w io.Writer, format string, events []recentfile.Event) error {
+	switch format {
+	case FilesFrom:
+		return WriteFilesFrom(w, events)
+	case IncludeFrom:
+		return WriteIncludeFrom(w, events)
+	}
+	return fmt.Errorf("unknown list format %q", format)
+}
+
+// WriteFilesFrom writes the paths of changed files that still exist, one per
+// line, for use with rsync --files-from. Deletions are left out since a
+// files-from list cannot express them; use WriteIncludeFrom with --delete
+// to propagate those.
+func WriteFilesFrom(w io.Writer, events []recentfile.Event) error {
+	bw := bufio.NewWriter(w)
+
+	for _, event := range events {
+		if event.Type != "new" {
+			continue
+		}
+		if err := checkPath(event.Path); err != nil {
+			return err
+		}
+		fmt.Fprintln(bw, event.Path)
+	}
+
+	return bw.Flush()
+}
+
+// WriteIncludeFrom writes filter rules for use with rsync --include-from.
+// Every changed path, including deleted ones, is included together with its
+// parent directories, and a final rule excludes everything else.
+func WriteIncludeFrom(w io.Writer, events []recentfile.Event) error {
+	bw := bufio.NewWriter(w)
+	dirs := make(map[string]bool)
+
+	for _, event := range events {
+		if err := checkPath(event.Path); err != nil {
+			return err
+		}
+
+		// rsync only descends into directories that are included
+		parts := strings.Split(event.Path, "/")
+		for i := 1; i < len(parts); i++ {
+			dir := strings.Join(parts[:i], "/")
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+			fmt.Fprintf(bw, "+ /%s/\n", escapePattern(dir))
+		}
+
+		fmt.Fprintf(bw, "+ /%s\n", escapePattern(event.Path))
+	}
+
+	fmt.Fprintln(bw, "- *")
+
+	return bw.Flush()
+}
+
+// checkPath rejects paths that can't be represented in a line-based list.
+func checkPath(path string) error {
+	if strings.ContainsAny(path, "\n\r") {
+		return fmt.Errorf("path contains a newline: %q", path)
+	}
+	return nil
+}
+
+// escapePattern escapes rsync wildcard characters. rsync only treats
+// backslashes as escapes in patterns that contain a wildcard, so paths
+// without one are returned unchanged.
+func escapePattern(path string) string {
+	if !strings.ContainsAny(path, "*?[") {
+		return path
+	}
+
+	var b strings.Builder
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package rsynclist
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h"}),
+	)
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	if err := rec.Update(filepath.Join(tmpDir, "old.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	since := recentfile.EpochNow()
+	time.Sleep(10 * time.Millisecond)
+
+	for _, item := range []struct{ path, typ string }{
+		{"b/two.txt", "new"},
+		{"a/one.txt", "new"},
+		{"b/gone.txt", "delete"},
+		{"a/one.txt", "new"},
+	} {
+		if err := rec.Update(filepath.Join(tmpDir, item.path), item.typ); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+
+	events, err := Changes(rec, since)
+	if err != nil {
+		t.Fatalf("Changes failed: %v", err)
+	}
+
+	want := []string{"a/one.txt", "b/gone.txt", "b/two.txt"}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), events)
+	}
+	for i, path := range want {
+		if events[i].Path != path {
+			t.Errorf("events[%d] = %s, want %s", i, events[i].Path, path)
+		}
+	}
+}
+
+func TestWriteFilesFrom(t *testing.T) {
+	events := []recentfile.Event{
+		{Path: "a/one.txt", Type: "new"},
+		{Path: "b/gone.txt", Type: "delete"},
+		{Path: "top.txt", Type: "new"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFilesFrom(&buf, events); err != nil {
+		t.Fatalf("WriteFilesFrom failed: %v", err)
+	}
+
+	want := "a/one.txt\ntop.txt\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteIncludeFrom(t *testing.T) {
+	events := []recentfile.Event{
+		{Path: "a/b/one.txt", Type: "new"},
+		{Path: "a/b/two[1].txt", Type: "delete"},
+		{Path: "top.txt", Type: "new"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIncludeFrom(&buf, events); err != nil {
+		t.Fatalf("WriteIncludeFrom failed: %v", err)
+	}
+
+	want := "+ /a/\n" +
+		"+ /a/b/\n" +
+		"+ /a/b/one.txt\n" +
+		"+ /a/b/two\\[1].txt\n" +
+		"+ /top.txt\n" +
+		"- *\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteRejectsNewline(t *testing.T) {
+	events := []recentfile.Event{{Path: "bad\nname", Type: "new"}}
+
+	var buf bytes.Buffer
+	if err := WriteFilesFrom(&buf, events); err == nil {
+		t.Error("Expected error for path with newline")
+	}
+	if err := WriteIncludeFrom(&buf, events); err == nil {
+		t.Error("Expected error for path with newline")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	events := []recentfile.Event{
+		{Path: "a/one.txt", Type: "new"},
+		{Path: "gone.txt", Type: "delete"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FilesFrom, events); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if want := "a/one.txt\n"; buf.String() != want {
+		t.Errorf("files-from: got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, IncludeFrom, events); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if want := "+ /a/\n+ /a/one.txt\n+ /gone.txt\n- *\n"; buf.String() != want {
+		t.Errorf("include-from: got %q, want %q", buf.String(), want)
+	}
+
+	if err := Write(&buf, "exclude-from", events); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Func adapts an ordinary function to a Sink, for processors embedded in a
+// Go program (virus scanning, signing, replication triggers, ...).
+type Func func(ctx context.Context, events []recentfile.Event) error
+
+// Publish calls f.
+func (f Func) Publish(ctx context.Context, events []recentfile.Event) error {
+	return f(ctx, events)
+}
+
+// Close is a no-op.
+func (f Func) Close() error {
+	return nil
+}
+
+// Exec runs an external command for each batch. The events are written to
+// the command's stdin as NDJSON, one event per line. The command sees the
+// hierarchy root in RRR_LOCAL_ROOT and the batch id in RRR_BATCH_ID; a
+// non-zero exit status is reported as an error.
+type Exec struct {
+	command string
+	root    string
+	timeout time.Duration
+}
+
+// NewExec runs command with /bin/sh for each batch. If timeout is positive
+// the command is killed when it runs longer.
+func NewExec(command, root string, timeout time.Duration) (*Exec, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+	return &Exec{command: command, root: root, timeout: timeout}, nil
+}
+
+// Publish runs the command with the batch on stdin.
+func (e *Exec) Publish(ctx context.Context, events []recentfile.Event) error {
+	batchID, err := newBatchID()
+	if err != nil {
+		return err
+	}
+
+	var stdin bytes.Buffer
+	enc := json.NewEncoder(&stdin)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return fmt.Errorf("encode event: %w", err)
+		}
+	}
+
+	env := []string{"RRR_LOCAL_ROOT=" + e.root, "RRR_BATCH_ID=" + batchID}
+	if err := runCommand(ctx, e.command, env, &stdin, e.timeout); err != nil {
+		return fmt.Errorf("batch %s: %w", batchID, err)
+	}
+	return nil
+}
+
+// Close is a no-op; a process is only running during Publish.
+func (e *Exec) Close() error {
+	return nil
+}
+
+// Hook runs an external command for every event of one type in a batch,
+// e.g. to purge a cache for each changed file. The command sees the event
+// in RRR_PATH (relative to the hierarchy root), RRR_FILE (the local path),
+// RRR_TYPE and RRR_EPOCH, and the hierarchy root in RRR_LOCAL_ROOT. The
+// events are run one after another; the errors of those failing are
+// reported together.
+type Hook struct {
+	command   string
+	eventType string
+	root      string
+	timeout   time.Duration
+}
+
+// NewHook runs command with /bin/sh for each event of eventType, "new" or
+// "delete". If timeout is positive the command is killed when it runs
+// longer.
+func NewHook(command, eventType, root string, timeout time.Duration) (*Hook, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+	if eventType != "new" && eventType != "delete" {
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	return &Hook{command: command, eventType: eventType, root: root, timeout: timeout}, nil
+}
+
+// Publish runs the command for each event of the hook's type.
+func (h *Hook) Publish(ctx context.Context, events []recentfile.Event) error {
+	var errs []error
+	for _, event := range events {
+		if event.Type != h.eventType {
+			continue
+		}
+		env := []string{
+			"RRR_LOCAL_ROOT=" + h.root,
+			"RRR_PATH=" + event.Path,
+			"RRR_FILE=" + filepath.Join(h.root, filepath.FromSlash(event.Path)),
+			"RRR_TYPE=" + event.Type,
+			"RRR_EPOCH=" + event.Epoch.String(),
+		}
+		if err := runCommand(ctx, h.command, env, nil, h.timeout); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", event.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close is a no-op; a process is only running during Publish.
+func (h *Hook) Close() error {
+	return nil
+}
+
+// runCommand runs command with /bin/sh, adding env to the environment. A
+// non-zero exit status is returned as an error with the command's output.
+func runCommand(ctx context.Context, command string, env []string, stdin io.Reader, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
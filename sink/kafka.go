@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// KafkaREST publishes batches to a Kafka topic through a Kafka REST Proxy
+// (Confluent REST Proxy v2 API).
+type KafkaREST struct {
+	endpoint string
+	root     string
+	client   *http.Client
+}
+
+// NewKafkaREST publishes to topic via the REST proxy at proxyURL.
+// root is included in each message (and used as the record key) to identify the hierarchy.
+func NewKafkaREST(proxyURL, topic, root string) (*KafkaREST, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("kafka topic cannot be empty")
+	}
+	if _, err := url.Parse(proxyURL); err != nil {
+		return nil, fmt.Errorf("parse kafka proxy url: %w", err)
+	}
+
+	return &KafkaREST{
+		endpoint: strings.TrimSuffix(proxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		root:     root,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// kafkaRecords is the REST proxy request body.
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string  `json:"key,omitempty"`
+	Value Message `json:"value"`
+}
+
+// Publish sends the batch as one record.
+func (k *KafkaREST) Publish(ctx context.Context, events []recentfile.Event) error {
+	body, err := json.Marshal(kafkaRecords{
+		Records: []kafkaRecord{{
+			Key:   k.root,
+			Value: Message{Root: k.root, Events: events},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal records: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post %s: %w", k.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("post %s: %s: %s", k.endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// Close is a no-op; the HTTP client holds no long-lived resources.
+func (k *KafkaREST) Close() error {
+	return nil
+}
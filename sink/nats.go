@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// natsFlushTimeout bounds how long Publish waits for the server to acknowledge a flush.
+const natsFlushTimeout = 10 * time.Second
+
+// NATS publishes batches to a NATS subject.
+type NATS struct {
+	conn    *nats.Conn
+	subject string
+	root    string
+}
+
+// NewNATS connects to the NATS server at url and publishes to subject.
+// root is included in each message to identify the hierarchy.
+func NewNATS(url, subject, root string) (*NATS, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("nats subject cannot be empty")
+	}
+
+	conn, err := nats.Connect(url, nats.Name("rrr-server"))
+	if err != nil {
+		return nil, fmt.Errorf("connect %s: %w", url, err)
+	}
+
+	return &NATS{
+		conn:    conn,
+		subject: subject,
+		root:    root,
+	}, nil
+}
+
+// Publish sends the batch as one JSON message.
+func (n *NATS) Publish(ctx context.Context, events []recentfile.Event) error {
+	data, err := encodeMessage(n.root, events)
+	if err != nil {
+		return err
+	}
+
+	if err := n.conn.Publish(n.subject, data); err != nil {
+		return fmt.Errorf("publish to %s: %w", n.subject, err)
+	}
+
+	// Flush so the batch is on the wire before we report success
+	ctx, cancel := context.WithTimeout(ctx, natsFlushTimeout)
+	defer cancel()
+	if err := n.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	return nil
+}
+
+// Close drains and closes the connection.
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
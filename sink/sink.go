@@ -0,0 +1,55 @@
+// Package sink publishes committed RECENT batches to external systems.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Sink receives batches of events committed to the principal recentfile.
+// It is the same interface as watcher.Sink, so the sinks can also be
+// passed to watcher.WithSinks.
+type Sink interface {
+	// Publish sends one committed batch.
+	Publish(ctx context.Context, events []recentfile.Event) error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// Message is the JSON payload published for each batch.
+type Message struct {
+	BatchID string             `json:"batch_id,omitempty"`
+	Root    string             `json:"root"`
+	Events  []recentfile.Event `json:"events"`
+}
+
+// encodeMessage builds the JSON payload for a batch.
+func encodeMessage(root string, events []recentfile.Event) ([]byte, error) {
+	data, err := json.Marshal(Message{Root: root, Events: events})
+	if err != nil {
+		return nil, fmt.Errorf("marshal message: %w", err)
+	}
+	return data, nil
+}
+
+// Run subscribes to rec and publishes every committed batch to s until ctx is done.
+// Batches already queued when ctx is cancelled are still published.
+// Publish errors are passed to errorHandler (if set) and don't stop the loop.
+func Run(ctx context.Context, rec *recent.Recent, s Sink, errorHandler func(error)) {
+	events, cancel := rec.Subscribe(ctx)
+	defer cancel()
+
+	// Don't abort in-flight publishes on shutdown
+	pubCtx := context.WithoutCancel(ctx)
+
+	for batch := range events {
+		if err := s.Publish(pubCtx, batch); err != nil && errorHandler != nil {
+			errorHandler(fmt.Errorf("publish %d events: %w", len(batch), err))
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+	"github.com/abh/rrrgo/watcher"
+)
+
+// The sinks plug into a watcher as well
+var (
+	_ watcher.Sink = Func(nil)
+	_ watcher.Sink = (*Exec)(nil)
+	_ watcher.Sink = (*Hook)(nil)
+	_ watcher.Sink = (*KafkaREST)(nil)
+	_ watcher.Sink = (*NATS)(nil)
+	_ watcher.Sink = (*Webhook)(nil)
+)
+
+// memSink records published batches.
+type memSink struct {
+	mu      sync.Mutex
+	batches [][]recentfile.Event
+}
+
+func (m *memSink) Publish(ctx context.Context, events []recentfile.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, events)
+	return nil
+}
+
+func (m *memSink) Close() error { return nil }
+
+func (m *memSink) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.batches)
+}
+
+func setupTestRecent(t *testing.T) (*recent.Recent, string) {
+	tmpDir := t.TempDir()
+
+	principal := recentfile.New(
+		recentfile.WithLocalRoot(tmpDir),
+		recentfile.WithInterval("1h"),
+	)
+
+	rec, err := recent.NewWithPrincipal(principal)
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	return rec, tmpDir
+}
+
+func TestRun(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &memSink{}
+
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, rec, s, func(err error) { t.Errorf("sink error: %v", err) })
+		close(done)
+	}()
+
+	// Give Run a moment to subscribe
+	time.Sleep(50 * time.Millisecond)
+
+	if err := rec.Update(filepath.Join(tmpDir, "file.txt"), "new"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if s.count() != 1 {
+		t.Fatalf("published %d batches, want 1", s.count())
+	}
+	if got := s.batches[0][0].Path; got != "file.txt" {
+		t.Errorf("published path = %s, want file.txt", got)
+	}
+}
+
+func TestKafkaREST(t *testing.T) {
+	var gotPath, gotType string
+	var body kafkaRecords
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/vnd.kafka.v2+json")
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer srv.Close()
+
+	k, err := NewKafkaREST(srv.URL+"/", "mirror-events", "/srv/mirror")
+	if err != nil {
+		t.Fatalf("NewKafkaREST failed: %v", err)
+	}
+
+	events := []recentfile.Event{
+		{Epoch: 1700000000.5, Path: "a.txt", Type: "new"},
+	}
+	if err := k.Publish(context.Background(), events); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if gotPath != "/topics/mirror-events" {
+		t.Errorf("path = %s, want /topics/mirror-events", gotPath)
+	}
+	if gotType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("content type = %s", gotType)
+	}
+	if len(body.Records) != 1 {
+		t.Fatalf("got %d records, want 1", len(body.Records))
+	}
+	msg := body.Records[0].Value
+	if msg.Root != "/srv/mirror" || len(msg.Events) != 1 || msg.Events[0].Path != "a.txt" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func TestKafkaRESTError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error_code":40401,"message":"Topic not found"}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	k, err := NewKafkaREST(srv.URL, "missing", "/srv/mirror")
+	if err != nil {
+		t.Fatalf("NewKafkaREST failed: %v", err)
+	}
+
+	if err := k.Publish(context.Background(), []recentfile.Event{{Path: "a.txt", Type: "new"}}); err == nil {
+		t.Error("expected error for 404 response")
+	}
+}
+
+func TestNewNATSEmptySubject(t *testing.T) {
+	if _, err := NewNATS("nats://127.0.0.1:4222", "", "/srv/mirror"); err == nil {
+		t.Error("expected error for empty subject")
+	}
+}
+
+func TestWebhookSigned(t *testing.T) {
+	secret := "s3cret"
+	var msg Message
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		if got, want := r.Header.Get(HeaderSignature), Sign([]byte(secret), body); got != want {
+			t.Errorf("signature = %s, want %s", got, want)
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Errorf("unmarshal body: %v", err)
+		}
+		if r.Header.Get(HeaderBatchID) != msg.BatchID {
+			t.Errorf("batch id header %q doesn't match body %q", r.Header.Get(HeaderBatchID), msg.BatchID)
+		}
+	}))
+	defer srv.Close()
+
+	wh, err := NewWebhook(srv.URL, secret, "/srv/mirror", 0)
+	if err != nil {
+		t.Fatalf("NewWebhook failed: %v", err)
+	}
+
+	events := []recentfile.Event{
+		{Epoch: 1700000000.5, Path: "a.txt", Type: "new"},
+		{Epoch: 1700000000.4, Path: "b.txt", Type: "delete"},
+	}
+	if err := wh.Publish(context.Background(), events); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if msg.BatchID == "" {
+		t.Error("batch id not set")
+	}
+	if len(msg.Events) != 2 || msg.Events[1].Type != "delete" {
+		t.Errorf("unexpected events: %+v", msg.Events)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var attempts int
+	var batchIDs []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		batchIDs = append(batchIDs, r.Header.Get(HeaderBatchID))
+		if attempts < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	defer srv.Close()
+
+	wh, err := NewWebhook(srv.URL, "", "/srv/mirror", 3)
+	if err != nil {
+		t.Fatalf("NewWebhook failed: %v", err)
+	}
+	wh.retryDelay = time.Millisecond
+
+	if err := wh.Publish(context.Background(), []recentfile.Event{{Path: "a.txt", Type: "new"}}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	for _, id := range batchIDs {
+		if id != batchIDs[0] {
+			t.Errorf("batch id changed between retries: %v", batchIDs)
+		}
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	var attempts int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	wh, err := NewWebhook(srv.URL, "", "/srv/mirror", 3)
+	if err != nil {
+		t.Fatalf("NewWebhook failed: %v", err)
+	}
+	wh.retryDelay = time.Millisecond
+
+	if err := wh.Publish(context.Background(), []recentfile.Event{{Path: "a.txt", Type: "new"}}); err == nil {
+		t.Error("expected error for 400 response")
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestExec(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	e, err := NewExec(`{ echo "$RRR_LOCAL_ROOT"; cat; } > `+out, "/srv/mirror", time.Minute)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	events := []recentfile.Event{
+		{Epoch: 1700000000.5, Path: "a.txt", Type: "new"},
+		{Epoch: 1700000001.25, Path: "b.txt", Type: "delete"},
+	}
+	if err := e.Publish(context.Background(), events); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "/srv/mirror" {
+		t.Fatalf("unexpected output:\n%s", data)
+	}
+	for i, line := range lines[1:] {
+		var got recentfile.Event
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		if got != events[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got, events[i])
+		}
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	e, _ := NewExec("echo scanner unavailable >&2; exit 1", "/srv/mirror", 0)
+
+	err := e.Publish(context.Background(), []recentfile.Event{{Path: "a.txt", Type: "new"}})
+	if err == nil || !strings.Contains(err.Error(), "scanner unavailable") {
+		t.Errorf("Expected error with command output, got %v", err)
+	}
+}
+
+func TestHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	h, err := NewHook(`echo "$RRR_TYPE $RRR_PATH $RRR_FILE $RRR_EPOCH" >> `+out, "new", "/srv/mirror", time.Minute)
+	if err != nil {
+		t.Fatalf("NewHook failed: %v", err)
+	}
+
+	events := []recentfile.Event{
+		{Epoch: 1700000000.5, Path: "a.txt", Type: "new"},
+		{Epoch: 1700000001.25, Path: "b.txt", Type: "delete"},
+		{Epoch: 1700000002, Path: "dir/c.txt", Type: "new"},
+	}
+	if err := h.Publish(context.Background(), events); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	want := "new a.txt /srv/mirror/a.txt 1700000000.5\n" +
+		"new dir/c.txt /srv/mirror/dir/c.txt 1700000002.0\n"
+	if string(data) != want {
+		t.Errorf("output = %q, want %q", data, want)
+	}
+
+	if _, err := NewHook("true", "modify", "/srv/mirror", 0); err == nil {
+		t.Error("expected error for unknown event type")
+	}
+}
+
+func TestHookFailure(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	h, _ := NewHook(`echo "$RRR_PATH" >> `+out+`; test "$RRR_PATH" != a.txt || { echo purge failed >&2; exit 1; }`, "delete", "/srv/mirror", 0)
+
+	err := h.Publish(context.Background(), []recentfile.Event{
+		{Path: "a.txt", Type: "delete"},
+		{Path: "b.txt", Type: "delete"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "a.txt") || !strings.Contains(err.Error(), "purge failed") {
+		t.Errorf("Expected error for a.txt with command output, got %v", err)
+	}
+
+	// A failing event doesn't keep the others from their hook
+	data, _ := os.ReadFile(out)
+	if string(data) != "a.txt\nb.txt\n" {
+		t.Errorf("output = %q", data)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	var got []recentfile.Event
+	var s Sink = Func(func(ctx context.Context, events []recentfile.Event) error {
+		got = append(got, events...)
+		return nil
+	})
+
+	s.Publish(context.Background(), []recentfile.Event{{Path: "a.txt"}})
+	if len(got) != 1 {
+		t.Errorf("Func not called")
+	}
+}
@@ -0,0 +1,147 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Webhook header names.
+const (
+	HeaderSignature = "X-RRR-Signature" // "sha256=" + hex HMAC of the body
+	HeaderBatchID   = "X-RRR-Batch-Id"
+)
+
+// webhookRetryDelay is the initial delay between delivery attempts (doubled each retry).
+const webhookRetryDelay = time.Second
+
+// Webhook POSTs a JSON summary of each batch to an HTTP endpoint.
+type Webhook struct {
+	url     string
+	secret  []byte
+	root    string
+	retries int
+	client  *http.Client
+
+	retryDelay time.Duration
+}
+
+// NewWebhook posts batches to url. If secret is set, each request carries an
+// HMAC-SHA256 signature of the body in the X-RRR-Signature header.
+// Failed deliveries (network errors, 429 and 5xx responses) are retried up
+// to retries times with exponential backoff.
+func NewWebhook(url, secret, root string, retries int) (*Webhook, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook url cannot be empty")
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	return &Webhook{
+		url:        url,
+		secret:     []byte(secret),
+		root:       root,
+		retries:    retries,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		retryDelay: webhookRetryDelay,
+	}, nil
+}
+
+// Publish delivers the batch, retrying transient failures.
+// All attempts share the same batch id so receivers can deduplicate.
+func (wh *Webhook) Publish(ctx context.Context, events []recentfile.Event) error {
+	batchID, err := newBatchID()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(Message{
+		BatchID: batchID,
+		Root:    wh.root,
+		Events:  events,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	delay := wh.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := wh.post(ctx, batchID, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= wh.retries {
+			return fmt.Errorf("batch %s: %w", batchID, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("batch %s: %w", batchID, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt.
+// Returns whether a failure is worth retrying.
+func (wh *Webhook) post(ctx context.Context, batchID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderBatchID, batchID)
+	if len(wh.secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(wh.secret, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("post %s: %w", wh.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("post %s: %s: %s", wh.url, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// Close is a no-op; the HTTP client holds no long-lived resources.
+func (wh *Webhook) Close() error {
+	return nil
+}
+
+// Sign returns the X-RRR-Signature header value for body.
+// Receivers verify a request by computing the same value with the shared
+// secret and comparing with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newBatchID returns a random identifier for a published batch.
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate batch id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
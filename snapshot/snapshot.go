@@ -0,0 +1,119 @@
+// Package snapshot takes filesystem snapshots after aggregation, so each
+// consistent state of a RECENT hierarchy can be rolled back to.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Snapshotter takes a snapshot of the filesystem holding a hierarchy.
+type Snapshotter interface {
+	// Snapshot records the current state of the tree at root. name is
+	// unique per aggregation run and safe to use in snapshot names.
+	Snapshot(ctx context.Context, root, name string) error
+}
+
+// Prefix is prepended to generated snapshot names.
+const Prefix = "rrr"
+
+// Name returns the snapshot name for a run at t, e.g.
+// "rrr-20240102T150405.123Z". Milliseconds keep names from hierarchies
+// aggregating in the same second apart.
+func Name(t time.Time) string {
+	return Prefix + "-" + t.UTC().Format("20060102T150405.000Z")
+}
+
+// command creates the processes snapshots run; replaced in tests.
+var command = exec.CommandContext
+
+// run executes a command and includes its output in any error.
+func run(cmd *exec.Cmd) error {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(out.String())
+		if msg == "" {
+			return fmt.Errorf("%s: %w", cmd.Args[0], err)
+		}
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+	}
+	return nil
+}
+
+// Exec runs a shell command for each snapshot. The command sees the
+// hierarchy root in RRR_LOCAL_ROOT and the snapshot name in
+// RRR_SNAPSHOT_NAME.
+type Exec struct {
+	command string
+}
+
+// NewExec creates a Snapshotter running command with /bin/sh.
+func NewExec(command string) (*Exec, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	return &Exec{command: command}, nil
+}
+
+// Snapshot runs the command.
+func (e *Exec) Snapshot(ctx context.Context, root, name string) error {
+	cmd := command(ctx, "/bin/sh", "-c", e.command)
+	cmd.Env = append(os.Environ(),
+		"RRR_LOCAL_ROOT="+root,
+		"RRR_SNAPSHOT_NAME="+name,
+	)
+	return run(cmd)
+}
+
+// ZFS snapshots a ZFS dataset.
+type ZFS struct {
+	dataset string
+}
+
+// NewZFS creates a Snapshotter for dataset (e.g. "tank/mirror").
+func NewZFS(dataset string) (*ZFS, error) {
+	if dataset == "" || strings.Contains(dataset, "@") {
+		return nil, fmt.Errorf("invalid zfs dataset %q", dataset)
+	}
+	return &ZFS{dataset: dataset}, nil
+}
+
+// Snapshot runs "zfs snapshot <dataset>@<name>".
+func (z *ZFS) Snapshot(ctx context.Context, root, name string) error {
+	return run(command(ctx, "zfs", "snapshot", z.dataset+"@"+name))
+}
+
+// Btrfs creates read-only snapshots of a btrfs subvolume.
+type Btrfs struct {
+	subvolume string
+	dir       string
+}
+
+// NewBtrfs creates a Snapshotter for subvolume that stores snapshots in dir.
+// dir must not be inside the subvolume.
+func NewBtrfs(subvolume, dir string) (*Btrfs, error) {
+	if subvolume == "" || dir == "" {
+		return nil, fmt.Errorf("btrfs subvolume and snapshot directory are required")
+	}
+
+	rel, err := filepath.Rel(subvolume, dir)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("snapshot directory %s is inside subvolume %s", dir, subvolume)
+	}
+
+	return &Btrfs{subvolume: subvolume, dir: dir}, nil
+}
+
+// Snapshot runs "btrfs subvolume snapshot -r <subvolume> <dir>/<name>".
+func (b *Btrfs) Snapshot(ctx context.Context, root, name string) error {
+	return run(command(ctx, "btrfs", "subvolume", "snapshot", "-r", b.subvolume, filepath.Join(b.dir, name)))
+}
@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordCommands replaces command for the duration of a test and returns
+// the argument lists it was called with.
+func recordCommands(t *testing.T) *[][]string {
+	var calls [][]string
+	orig := command
+	command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		calls = append(calls, append([]string{name}, args...))
+		return exec.CommandContext(ctx, "true")
+	}
+	t.Cleanup(func() { command = orig })
+	return &calls
+}
+
+func TestName(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 15, 4, 5, 7e6, time.FixedZone("x", 3600))
+	if got := Name(ts); got != "rrr-20240102T140405.007Z" {
+		t.Errorf("Name = %s", got)
+	}
+}
+
+func TestExec(t *testing.T) {
+	tmpDir := t.TempDir()
+	out := filepath.Join(tmpDir, "out")
+
+	e, err := NewExec(`echo "$RRR_LOCAL_ROOT $RRR_SNAPSHOT_NAME" > ` + out)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	if err := e.Snapshot(context.Background(), "/srv/mirror", "rrr-1"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "/srv/mirror rrr-1" {
+		t.Errorf("command saw %q", got)
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	e, _ := NewExec("echo no space left >&2; exit 3")
+
+	err := e.Snapshot(context.Background(), "/srv/mirror", "rrr-1")
+	if err == nil || !strings.Contains(err.Error(), "no space left") {
+		t.Errorf("Expected error with command output, got %v", err)
+	}
+}
+
+func TestZFS(t *testing.T) {
+	calls := recordCommands(t)
+
+	z, err := NewZFS("tank/mirror")
+	if err != nil {
+		t.Fatalf("NewZFS failed: %v", err)
+	}
+	if err := z.Snapshot(context.Background(), "/srv/mirror", "rrr-1"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	want := [][]string{{"zfs", "snapshot", "tank/mirror@rrr-1"}}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %v, want %v", *calls, want)
+	}
+
+	if _, err := NewZFS("tank/mirror@snap"); err == nil {
+		t.Error("Expected error for dataset with @")
+	}
+}
+
+func TestBtrfs(t *testing.T) {
+	calls := recordCommands(t)
+
+	b, err := NewBtrfs("/srv/mirror", "/srv/snapshots")
+	if err != nil {
+		t.Fatalf("NewBtrfs failed: %v", err)
+	}
+	if err := b.Snapshot(context.Background(), "/srv/mirror", "rrr-1"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	want := [][]string{{"btrfs", "subvolume", "snapshot", "-r", "/srv/mirror", "/srv/snapshots/rrr-1"}}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %v, want %v", *calls, want)
+	}
+
+	if _, err := NewBtrfs("/srv/mirror", "/srv/mirror/.snapshots"); err == nil {
+		t.Error("Expected error for snapshot directory inside the subvolume")
+	}
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// addDir remembers that path is a watched directory.
+func (w *Watcher) addDir(path string) {
+	w.dirsMu.Lock()
+	defer w.dirsMu.Unlock()
+	w.dirs[path] = true
+}
+
+// forgetDir reports whether path was a watched directory, and forgets it
+// and every directory below it.
+func (w *Watcher) forgetDir(path string) bool {
+	w.dirsMu.Lock()
+	defer w.dirsMu.Unlock()
+
+	if !w.dirs[path] {
+		return false
+	}
+	prefix := path + string(filepath.Separator)
+	for dir := range w.dirs {
+		if dir == path || strings.HasPrefix(dir, prefix) {
+			delete(w.dirs, dir)
+		}
+	}
+	return true
+}
+
+// expandDirDeletes adds a delete event for every file known to be below a
+// removed directory, right after the directory's own event. A directory
+// removed in one piece (or renamed out of the tree) is reported as a single
+// event, and mirrors would otherwise keep its files forever. Known files
+// are those recorded in the RECENT files (and the archive) plus those
+// created earlier in batch.
+func (w *Watcher) expandDirDeletes(batch []recentfile.BatchItem) []recentfile.BatchItem {
+	var removed []int
+	for i, item := range batch {
+		if item.Type == "delete" && w.forgetDir(item.Path) {
+			batch[i].Dir = w.dirEvents
+			removed = append(removed, i)
+		}
+	}
+	if len(removed) == 0 {
+		return batch
+	}
+
+	state, err := fsck.IndexState(w.recent, w.archiveDir)
+	if err != nil && w.errorHandler != nil {
+		w.errorHandler(fmt.Errorf("expand directory delete: %w", err))
+	}
+
+	expanded := make([]recentfile.BatchItem, 0, len(batch))
+	next := 0
+	for i, item := range batch {
+		expanded = append(expanded, item)
+		if next >= len(removed) || removed[next] != i {
+			continue
+		}
+		next++
+
+		rel, err := filepath.Rel(w.rootDir, item.Path)
+		if err != nil {
+			continue
+		}
+		prefix := filepath.ToSlash(rel) + "/"
+
+		children := make(map[string]bool) // whether each is a directory
+		for path, event := range state {
+			if event.Type == "new" && strings.HasPrefix(path, prefix) {
+				children[filepath.Join(w.rootDir, filepath.FromSlash(path))] = event.Dir
+			}
+		}
+		for _, earlier := range batch[:i] {
+			if earlier.Type == "new" && strings.HasPrefix(earlier.Path, item.Path+string(filepath.Separator)) {
+				children[earlier.Path] = earlier.Dir
+			}
+		}
+
+		for path, dir := range children {
+			expanded = append(expanded, recentfile.BatchItem{Path: path, Type: "delete", Dir: dir})
+		}
+		if w.verbose && len(children) > 0 {
+			fmt.Printf("Directory removed: %s (%d files)\n", item.Path, len(children))
+		}
+	}
+
+	return expanded
+}
@@ -0,0 +1,290 @@
+package watcher
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyMask is the set of directory entry and content changes watched.
+const fanotifyMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MOVED_FROM |
+	unix.FAN_MOVED_TO | unix.FAN_MODIFY | unix.FAN_ATTRIB | unix.FAN_ONDIR
+
+// fanotifyMetadataSize is the size of struct fanotify_event_metadata.
+const fanotifyMetadataSize = 24
+
+// fanotifyDirCacheSize bounds the cache of directory handles.
+const fanotifyDirCacheSize = 100000
+
+// FanotifySource is an EventSource using a single fanotify mark on the
+// filesystem holding the root, so trees with millions of directories don't
+// run out of inotify watches. Events outside the root are discarded. It
+// needs Linux 5.9 or later and CAP_SYS_ADMIN (and CAP_DAC_READ_SEARCH to
+// resolve paths).
+type FanotifySource struct {
+	root     string   // as given
+	realRoot string   // with symlinks resolved, as event paths are
+	file     *os.File // fanotify group
+	mountFD  int      // any descriptor on the filesystem, for open_by_handle_at
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+
+	// Directory paths by file handle, for events in directories that are
+	// gone by the time the event is read (rm -r). Only touched by the read
+	// loop.
+	dirs map[string]string
+}
+
+// NewFanotifySource marks the filesystem holding root.
+func NewFanotifySource(root string) (EventSource, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", root, err)
+	}
+
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+		unix.O_RDONLY|unix.O_CLOEXEC|unix.O_LARGEFILE)
+	if err != nil {
+		return nil, fmt.Errorf("fanotify_init: %w", err)
+	}
+
+	if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanotifyMask, unix.AT_FDCWD, root); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("fanotify_mark %s: %w", root, err)
+	}
+
+	mountFD, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("open %s: %w", root, err)
+	}
+
+	s := &FanotifySource{
+		root:     filepath.Clean(root),
+		realRoot: realRoot,
+		file:     os.NewFile(uintptr(fd), "fanotify"),
+		mountFD:  mountFD,
+		events:   make(chan fsnotify.Event, 1000),
+		errors:   make(chan error, 10),
+		done:     make(chan struct{}),
+		dirs:     make(map[string]string),
+	}
+
+	go s.readLoop()
+
+	return s, nil
+}
+
+// readLoop reads and decodes events until Close.
+func (s *FanotifySource) readLoop() {
+	defer close(s.events)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.file.Read(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				if !errors.Is(err, io.EOF) {
+					s.sendError(fmt.Errorf("read fanotify: %w", err))
+				}
+			}
+			return
+		}
+
+		for _, event := range s.parse(buf[:n]) {
+			select {
+			case s.events <- event:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// parse decodes the events in one read.
+func (s *FanotifySource) parse(buf []byte) []fsnotify.Event {
+	var events []fsnotify.Event
+
+	for len(buf) >= fanotifyMetadataSize {
+		eventLen := int(binary.NativeEndian.Uint32(buf[0:]))
+		version := buf[4]
+		metaLen := int(binary.NativeEndian.Uint16(buf[6:]))
+		mask := binary.NativeEndian.Uint64(buf[8:])
+		fd := int32(binary.NativeEndian.Uint32(buf[16:]))
+
+		if eventLen < metaLen || eventLen > len(buf) {
+			break
+		}
+		if version != unix.FANOTIFY_METADATA_VERSION {
+			s.sendError(fmt.Errorf("fanotify metadata version %d, want %d", version, unix.FANOTIFY_METADATA_VERSION))
+			break
+		}
+		if fd >= 0 {
+			unix.Close(int(fd))
+		}
+
+		info := buf[metaLen:eventLen]
+		buf = buf[eventLen:]
+
+		if mask&unix.FAN_Q_OVERFLOW != 0 {
+			s.sendError(errors.New("fanotify queue overflow, events were lost"))
+			continue
+		}
+
+		path, ok := s.eventPath(info)
+		if !ok {
+			continue
+		}
+
+		var op fsnotify.Op
+		if mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+			op |= fsnotify.Create
+		}
+		if mask&unix.FAN_MODIFY != 0 {
+			op |= fsnotify.Write
+		}
+		if mask&unix.FAN_ATTRIB != 0 {
+			op |= fsnotify.Chmod
+		}
+		if mask&unix.FAN_DELETE != 0 {
+			op |= fsnotify.Remove
+		}
+		if mask&unix.FAN_MOVED_FROM != 0 {
+			op |= fsnotify.Rename
+		}
+
+		// Merged events may report both sides; the filesystem decides
+		if op.Has(fsnotify.Create) && (op.Has(fsnotify.Remove) || op.Has(fsnotify.Rename)) {
+			if _, err := os.Lstat(path); err == nil {
+				op = fsnotify.Create
+			} else {
+				op = fsnotify.Remove
+			}
+		}
+
+		if op != 0 {
+			events = append(events, fsnotify.Event{Name: path, Op: op})
+		}
+	}
+
+	return events
+}
+
+// eventPath returns the path from an event's directory handle and name
+// record, and whether it is below the root. Directory handles resolve
+// to paths without symlinks, which are mapped back to the root as given.
+func (s *FanotifySource) eventPath(info []byte) (string, bool) {
+	for len(info) >= 4 {
+		infoType := info[0]
+		infoLen := int(binary.NativeEndian.Uint16(info[2:]))
+		if infoLen < 4 || infoLen > len(info) {
+			return "", false
+		}
+		record := info[4:infoLen]
+		info = info[infoLen:]
+
+		if infoType != unix.FAN_EVENT_INFO_TYPE_DFID_NAME || len(record) < 16 {
+			continue
+		}
+
+		// fsid, then struct file_handle, then the entry name
+		record = record[8:]
+		handleBytes := int(binary.NativeEndian.Uint32(record[0:]))
+		handleType := int32(binary.NativeEndian.Uint32(record[4:]))
+		if len(record) < 8+handleBytes {
+			return "", false
+		}
+		handle := record[8 : 8+handleBytes]
+		name := record[8+handleBytes:]
+		if i := strings.IndexByte(string(name), 0); i >= 0 {
+			name = name[:i]
+		}
+
+		dir, ok := s.resolveDir(handleType, handle)
+		if !ok {
+			return "", false
+		}
+
+		path := dir
+		if n := string(name); n != "" && n != "." {
+			path = filepath.Join(dir, n)
+		}
+		rel, err := filepath.Rel(s.realRoot, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+		return filepath.Join(s.root, rel), true
+	}
+	return "", false
+}
+
+// resolveDir turns a directory file handle into its current path, falling
+// back to the last known path for directories that no longer exist.
+func (s *FanotifySource) resolveDir(handleType int32, handle []byte) (string, bool) {
+	key := string(handle)
+
+	fd, err := unix.OpenByHandleAt(s.mountFD, unix.NewFileHandle(handleType, handle), unix.O_PATH|unix.O_CLOEXEC)
+	if err != nil {
+		if !errors.Is(err, unix.ESTALE) && !errors.Is(err, unix.ENOENT) {
+			s.sendError(fmt.Errorf("open_by_handle_at: %w", err))
+		}
+		dir, ok := s.dirs[key]
+		return dir, ok
+	}
+	defer unix.Close(fd)
+
+	dir, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err != nil || strings.HasSuffix(dir, " (deleted)") {
+		dir, ok := s.dirs[key]
+		return dir, ok
+	}
+
+	if len(s.dirs) >= fanotifyDirCacheSize {
+		clear(s.dirs)
+	}
+	s.dirs[key] = dir
+	return dir, true
+}
+
+// sendError reports an error without blocking the read loop.
+func (s *FanotifySource) sendError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+// Events returns the channel of change notifications.
+func (s *FanotifySource) Events() <-chan fsnotify.Event { return s.events }
+
+// Errors returns the channel of source errors.
+func (s *FanotifySource) Errors() <-chan error { return s.errors }
+
+// Add is a no-op; the filesystem mark covers the whole tree.
+func (s *FanotifySource) Add(path string) error { return nil }
+
+// WatchesTree reports that no per-directory watches are needed.
+func (s *FanotifySource) WatchesTree() bool { return true }
+
+// Close stops watching.
+func (s *FanotifySource) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.file.Close()
+		unix.Close(s.mountFD)
+	})
+	return err
+}
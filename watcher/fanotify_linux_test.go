@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recent"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func TestFanotifyBackend(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	os.MkdirAll(filepath.Join(tmpDir, "sub", "deep"), 0o755)
+	os.WriteFile(filepath.Join(tmpDir, "gone.txt"), []byte("gone"), 0o644)
+
+	w, err := New(rec, WithBackend("fanotify"))
+	if err != nil {
+		t.Skipf("fanotify unavailable: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// Outside the root, on the same filesystem
+	os.WriteFile(filepath.Join(filepath.Dir(tmpDir), "outside.txt"), []byte("x"), 0o644)
+	defer os.Remove(filepath.Join(filepath.Dir(tmpDir), "outside.txt"))
+
+	os.WriteFile(filepath.Join(tmpDir, "sub", "deep", "a.txt"), []byte("a"), 0o644)
+	os.Remove(filepath.Join(tmpDir, "gone.txt"))
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	types := eventTypes(w)
+	if types["sub/deep/a.txt"] != "new" {
+		t.Errorf("sub/deep/a.txt type = %q, want new", types["sub/deep/a.txt"])
+	}
+	if types["gone.txt"] != "delete" {
+		t.Errorf("gone.txt type = %q, want delete", types["gone.txt"])
+	}
+	if len(types) != 2 {
+		t.Errorf("recorded %v, want only events below the root", types)
+	}
+}
+
+func TestFanotifyDirectoryMove(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	dir := filepath.Join(tmpDir, "d")
+	os.MkdirAll(filepath.Join(dir, "nested"), 0o755)
+	for _, name := range []string{"a.txt", "nested/b.txt"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0o644)
+		if err := rec.Update(path, "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := New(rec, WithBackend("fanotify"), WithIncludePatterns("*.txt"))
+	if err != nil {
+		t.Skipf("fanotify unavailable: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// A directory created while running is known as well
+	os.MkdirAll(filepath.Join(dir, "new"), 0o755)
+	time.Sleep(100 * time.Millisecond)
+	os.WriteFile(filepath.Join(dir, "new", "c.txt"), []byte("c"), 0o644)
+	time.Sleep(100 * time.Millisecond)
+
+	// Moving the directory out of the tree reports only the directory,
+	// whose delete the include patterns don't filter
+	if err := os.Rename(dir, filepath.Join(filepath.Dir(tmpDir), filepath.Base(tmpDir)+"-moved")); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	defer os.RemoveAll(filepath.Join(filepath.Dir(tmpDir), filepath.Base(tmpDir)+"-moved"))
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	types := eventTypes(w)
+	for _, path := range []string{"d/a.txt", "d/nested/b.txt", "d/new/c.txt"} {
+		if types[path] != "delete" {
+			t.Errorf("%s type = %q, want delete", path, types[path])
+		}
+	}
+}
+
+func TestFanotifySymlinkedRoot(t *testing.T) {
+	// The local root is reached through a symlink
+	link := filepath.Join(t.TempDir(), "root")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := recent.NewWithPrincipal(recentfile.New(
+		recentfile.WithLocalRoot(link),
+		recentfile.WithInterval("1h"),
+		recentfile.WithAggregator([]string{"6h"}),
+	))
+	if err != nil {
+		t.Fatalf("NewWithPrincipal failed: %v", err)
+	}
+
+	w, err := New(rec, WithBackend("fanotify"))
+	if err != nil {
+		t.Skipf("fanotify unavailable: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	os.WriteFile(filepath.Join(link, "a.txt"), []byte("a"), 0o644)
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	if types := eventTypes(w); types["a.txt"] != "new" {
+		t.Errorf("recorded %v, want a.txt new", types)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// NewFanotifySource is only available on Linux.
+func NewFanotifySource(root string) (EventSource, error) {
+	return nil, errors.New("fanotify is only available on Linux")
+}
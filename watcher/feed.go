@@ -0,0 +1,179 @@
+package watcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// feedOps maps feed operation names to fsnotify operations.
+var feedOps = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"delete": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// feedLine is one change notification in a feed.
+type feedLine struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// FeedSource is an EventSource fed by an external producer instead of inotify,
+// e.g. a container runtime or overlayfs change-log tailer, or an eBPF-based
+// file-change tracer. The producer writes newline-delimited JSON, one change per line:
+//
+//	{"op":"create","path":"authors/id/A/AB/ABC/Foo-1.0.tar.gz"}
+//
+// op is one of create, write, remove (or delete), rename and chmod; rename
+// refers to the old path. Relative paths are resolved against the root.
+// The whole tree is covered by the feed, so no directory watches are set up.
+type FeedSource struct {
+	root   string
+	r      io.ReadCloser
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewFeedSource reads change notifications from r until EOF or Close.
+func NewFeedSource(root string, r io.ReadCloser) *FeedSource {
+	feed := &FeedSource{
+		root:   root,
+		r:      r,
+		events: make(chan fsnotify.Event, 1000),
+		errors: make(chan error, 10),
+		done:   make(chan struct{}),
+	}
+
+	go feed.readLoop()
+
+	return feed
+}
+
+// OpenFeedSource opens a feed at path; "-" reads standard input.
+// Named pipes are opened read-write so the feed survives producers
+// closing and reopening their end.
+func OpenFeedSource(root, path string) (*FeedSource, error) {
+	if path == "-" {
+		return NewFeedSource(root, io.NopCloser(os.Stdin)), nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat feed: %w", err)
+	}
+
+	flag := os.O_RDONLY
+	if fi.Mode()&os.ModeNamedPipe != 0 {
+		// Holding a write end open means we never see EOF between producers
+		flag = os.O_RDWR
+	}
+
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open feed: %w", err)
+	}
+
+	return NewFeedSource(root, f), nil
+}
+
+// readLoop parses feed lines into events.
+func (feed *FeedSource) readLoop() {
+	defer close(feed.events)
+
+	scanner := bufio.NewScanner(feed.r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		event, err := feed.parseLine(line)
+		if err != nil {
+			feed.sendError(err)
+			continue
+		}
+
+		select {
+		case feed.events <- event:
+		case <-feed.done:
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-feed.done:
+			// Read error caused by Close
+		default:
+			feed.sendError(fmt.Errorf("read feed: %w", err))
+		}
+	}
+}
+
+// parseLine converts one feed line into an fsnotify event.
+func (feed *FeedSource) parseLine(line string) (fsnotify.Event, error) {
+	var fl feedLine
+	if err := json.Unmarshal([]byte(line), &fl); err != nil {
+		return fsnotify.Event{}, fmt.Errorf("invalid feed line %q: %w", line, err)
+	}
+
+	op, ok := feedOps[strings.ToLower(fl.Op)]
+	if !ok {
+		return fsnotify.Event{}, fmt.Errorf("unknown feed op %q", fl.Op)
+	}
+	if fl.Path == "" {
+		return fsnotify.Event{}, fmt.Errorf("feed line without path: %q", line)
+	}
+
+	name := filepath.FromSlash(fl.Path)
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(feed.root, name)
+	}
+
+	return fsnotify.Event{Name: name, Op: op}, nil
+}
+
+// sendError reports an error without blocking the read loop.
+func (feed *FeedSource) sendError(err error) {
+	select {
+	case feed.errors <- err:
+	default:
+	}
+}
+
+// Events returns the channel of change notifications.
+func (feed *FeedSource) Events() <-chan fsnotify.Event { return feed.events }
+
+// Errors returns the channel of feed errors.
+func (feed *FeedSource) Errors() <-chan error { return feed.errors }
+
+// Add is a no-op; the feed covers the whole tree.
+func (feed *FeedSource) Add(path string) error { return nil }
+
+// WatchesTree reports that no per-directory watches are needed.
+func (feed *FeedSource) WatchesTree() bool { return true }
+
+// Close stops reading the feed.
+func (feed *FeedSource) Close() error {
+	var err error
+	feed.once.Do(func() {
+		close(feed.done)
+		err = feed.r.Close()
+	})
+	return err
+}
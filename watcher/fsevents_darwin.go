@@ -0,0 +1,145 @@
+//go:build darwin && cgo
+
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsevents"
+	"github.com/fsnotify/fsnotify"
+)
+
+// FSEventsSource is an EventSource using a single macOS FSEvents stream
+// for the whole tree, instead of one kqueue descriptor per file.
+type FSEventsSource struct {
+	root     string // as given
+	realRoot string // with symlinks resolved, as FSEvents reports paths
+	stream   *fsevents.EventStream
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewFSEventsSource starts an FSEvents stream for root.
+func NewFSEventsSource(root string) (EventSource, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", root, err)
+	}
+
+	s := &FSEventsSource{
+		root:     filepath.Clean(root),
+		realRoot: realRoot,
+		stream: &fsevents.EventStream{
+			Paths:   []string{realRoot},
+			Latency: 100 * time.Millisecond,
+			Flags:   fsevents.FileEvents | fsevents.NoDefer,
+		},
+		events: make(chan fsnotify.Event, 1000),
+		errors: make(chan error, 10),
+		done:   make(chan struct{}),
+	}
+
+	if err := s.stream.Start(); err != nil {
+		return nil, fmt.Errorf("start FSEvents stream: %w", err)
+	}
+
+	go s.readLoop()
+
+	return s, nil
+}
+
+// readLoop translates FSEvents batches until Close.
+func (s *FSEventsSource) readLoop() {
+	defer close(s.events)
+
+	for {
+		select {
+		case batch := <-s.stream.Events:
+			for _, e := range batch {
+				if e.Flags&fsevents.MustScanSubDirs != 0 {
+					s.sendError(fmt.Errorf("FSEvents dropped events below %s", e.Path))
+				}
+				event, ok := s.translate(e)
+				if !ok {
+					continue
+				}
+				select {
+				case s.events <- event:
+				case <-s.done:
+					return
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// translate converts one FSEvents event. FSEvents coalesces flags per
+// path, so the current state of the path decides between creation and
+// removal.
+func (s *FSEventsSource) translate(e fsevents.Event) (fsnotify.Event, bool) {
+	path := e.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	rel, err := filepath.Rel(s.realRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fsnotify.Event{}, false
+	}
+	name := filepath.Join(s.root, rel)
+
+	_, statErr := os.Lstat(name)
+	exists := statErr == nil
+
+	var op fsnotify.Op
+	switch {
+	case e.Flags&(fsevents.ItemRemoved|fsevents.ItemRenamed) != 0 && !exists:
+		op = fsnotify.Remove
+	case e.Flags&(fsevents.ItemCreated|fsevents.ItemRenamed) != 0:
+		op = fsnotify.Create
+	case e.Flags&fsevents.ItemModified != 0:
+		op = fsnotify.Write
+	case e.Flags&(fsevents.ItemInodeMetaMod|fsevents.ItemChangeOwner) != 0:
+		op = fsnotify.Chmod
+	default:
+		return fsnotify.Event{}, false
+	}
+	return fsnotify.Event{Name: name, Op: op}, true
+}
+
+// sendError reports an error without blocking the read loop.
+func (s *FSEventsSource) sendError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+// Events returns the channel of change notifications.
+func (s *FSEventsSource) Events() <-chan fsnotify.Event { return s.events }
+
+// Errors returns the channel of source errors.
+func (s *FSEventsSource) Errors() <-chan error { return s.errors }
+
+// Add is a no-op; the stream covers the whole tree.
+func (s *FSEventsSource) Add(path string) error { return nil }
+
+// WatchesTree reports that no per-directory watches are needed.
+func (s *FSEventsSource) WatchesTree() bool { return true }
+
+// Close stops the stream.
+func (s *FSEventsSource) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.stream.Stop()
+	})
+	return nil
+}
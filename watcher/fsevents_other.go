@@ -0,0 +1,10 @@
+//go:build !darwin || !cgo
+
+package watcher
+
+import "errors"
+
+// NewFSEventsSource is only available on macOS, built with cgo.
+func NewFSEventsSource(root string) (EventSource, error) {
+	return nil, errors.New("FSEvents is only available on macOS (with cgo)")
+}
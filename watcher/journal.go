@@ -0,0 +1,215 @@
+package watcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Journal is an append-only, fsynced log of the events the watcher has
+// accepted but not yet written to the principal recentfile. It is replayed
+// on startup, so events queued when the process died are not lost.
+type Journal struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	// dirty is set when an event in the journal was dropped from memory
+	// (queue overflow, failed batch update); the journal must then be
+	// replayed rather than truncated.
+	dirty bool
+}
+
+// journalEntry is one line of the journal.
+type journalEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Dir  bool   `json:"dir,omitempty"`
+}
+
+// OpenJournal opens (or creates) the journal at path. Entries left by a
+// previous run stay in place until replayed.
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	return &Journal{path: path, file: f}, nil
+}
+
+// Path returns the journal file name.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// append records items and syncs them to disk. The caller holds j.mu.
+func (j *Journal) append(items []batchItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	w := bufio.NewWriter(j.file)
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err := enc.Encode(journalEntry{Path: item.path, Type: item.typ, Dir: item.dir}); err != nil {
+			return fmt.Errorf("write journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return nil
+}
+
+// entries reads the journal, latest entry per path only, in the order the
+// paths were last seen. A torn last line from a crash is ignored. The
+// caller holds j.mu.
+func (j *Journal) entries() ([]recentfile.BatchItem, error) {
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	latest := make(map[string]int)
+	var items []recentfile.BatchItem
+
+	scanner := bufio.NewScanner(j.file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Path == "" {
+			continue
+		}
+		if i, ok := latest[e.Path]; ok {
+			items[i].Path = "" // superseded
+		}
+		latest[e.Path] = len(items)
+		items = append(items, recentfile.BatchItem{Path: e.Path, Type: e.Type, Dir: e.Dir})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	n := 0
+	for _, item := range items {
+		if item.Path != "" {
+			items[n] = item
+			n++
+		}
+	}
+	return items[:n], nil
+}
+
+// truncate empties the journal. The caller holds j.mu.
+func (j *Journal) truncate() error {
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	j.dirty = false
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// replayJournal writes the journaled events to the recent collection and
+// empties the journal. Each path's event is corrected against the
+// filesystem, since the journal may be older than the current state.
+// The caller holds w.journal.mu.
+func (w *Watcher) replayJournal() error {
+	j := w.journal
+
+	items, err := j.entries()
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, item := range items {
+		fi, err := os.Lstat(item.Path)
+		switch {
+		case err == nil && fi.IsDir() != item.Dir:
+			continue // a directory recreated at a removed file's path, or the reverse
+		case err == nil:
+			item.Type = "new"
+		case errors.Is(err, os.ErrNotExist):
+			item.Type = "delete"
+		}
+		items[n] = item
+		n++
+	}
+	items = items[:n]
+
+	if len(items) > 0 {
+		if w.verbose {
+			fmt.Printf("Replaying journal: %d events\n", len(items))
+		}
+		if err := w.recent.BatchUpdateContext(w.ctx, items); err != nil {
+			return fmt.Errorf("replay journal: %w", err)
+		}
+		w.countEvents(items)
+	}
+
+	return j.truncate()
+}
+
+// checkpointJournal empties the journal once everything in it has been
+// written, replaying it first if events were dropped on the way. Events
+// held for their path to settle (see WithQuietPeriod) are journaled
+// again. It runs on the batch processor goroutine after a flush.
+func (w *Watcher) checkpointJournal() {
+	j := w.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Events still queued are in the journal too; wait for a quiet moment
+	if len(w.batchChan) > 0 {
+		return
+	}
+	w.batchMu.Lock()
+	pending := len(w.batch)
+	held := make([]batchItem, 0, len(w.held))
+	for _, h := range w.held {
+		held = append(held, batchItem{path: h.item.Path, typ: h.item.Type, dir: h.item.Dir})
+	}
+	w.batchMu.Unlock()
+	if pending > 0 {
+		return
+	}
+	// So are events kept in memory by deferred writes
+	if w.recent.PrincipalRecentfile().Pending() > 0 {
+		return
+	}
+
+	var err error
+	if j.dirty {
+		err = w.replayJournal()
+	} else {
+		err = j.truncate()
+	}
+	if err == nil {
+		err = j.append(held)
+	}
+	if err != nil && w.errorHandler != nil {
+		w.errorHandler(err)
+	}
+}
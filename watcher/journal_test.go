@@ -0,0 +1,178 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+func journalSize(t *testing.T, path string) int64 {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func eventTypes(w *Watcher) map[string]string {
+	types := map[string]string{}
+	for _, e := range w.recent.PrincipalRecentfile().RecentEvents() {
+		types[e.Path] = e.Type
+	}
+	return types
+}
+
+func TestJournalReplayOnStart(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(tmpDir, "c.txt"), []byte("c"), 0o644)
+	os.Mkdir(filepath.Join(tmpDir, "dir"), 0o755)
+
+	// Left behind by a crashed run; the last line is torn
+	lines := `{"path":"` + filepath.Join(tmpDir, "a.txt") + `","type":"new"}
+{"path":"` + filepath.Join(tmpDir, "b.txt") + `","type":"new"}
+{"path":"` + filepath.Join(tmpDir, "c.txt") + `","type":"delete"}
+{"path":"` + filepath.Join(tmpDir, "dir") + `","type":"delete"}
+{"path":"` + filepath.Join(tmpDir, "c.txt") + `","type":"new"}
+{"path":"` + tmpDir + `/d.t`
+	if err := os.WriteFile(journal, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(rec, WithJournal(journal))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// Events are corrected against the filesystem
+	types := eventTypes(w)
+	want := map[string]string{"a.txt": "new", "b.txt": "delete", "c.txt": "new"}
+	if len(types) != len(want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	for path, typ := range want {
+		if types[path] != typ {
+			t.Errorf("%s type = %q, want %q", path, types[path], typ)
+		}
+	}
+
+	if size := journalSize(t, journal); size != 0 {
+		t.Errorf("journal has %d bytes after replay", size)
+	}
+}
+
+func TestJournalSurvivesCrash(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+
+	w, err := New(rec, WithJournal(journal))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// Queued but never flushed, as if the process died here
+	testFile := filepath.Join(tmpDir, "test.txt")
+	os.WriteFile(testFile, []byte("test"), 0o644)
+	w.handleEvents([]fsnotify.Event{{Name: testFile, Op: fsnotify.Create}})
+	w.source.Close()
+	w.journal.Close()
+
+	if size := journalSize(t, journal); size == 0 {
+		t.Fatal("event was not journaled")
+	}
+
+	w2, err := New(rec, WithJournal(journal))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := w2.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w2.Stop()
+
+	if types := eventTypes(w2); types["test.txt"] != "new" {
+		t.Errorf("events after restart = %v", types)
+	}
+}
+
+func TestJournalCheckpoint(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+
+	w, err := New(rec, WithJournal(journal))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.source.Close()
+
+	// Room for one event only; the others are dropped from memory
+	w.batchChan = make(chan batchItem, 1)
+
+	var events []fsnotify.Event
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte(name), 0o644)
+		events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+	}
+	w.handleEvents(events)
+
+	item := <-w.batchChan
+	w.batch = append(w.batch, recentfile.BatchItem{Path: item.path, Type: item.typ})
+	w.flushBatch()
+
+	// The flush replayed the journal, recovering the dropped events
+	if types := eventTypes(w); len(types) != 3 {
+		t.Errorf("events = %v, want all 3", types)
+	}
+	if size := journalSize(t, journal); size != 0 {
+		t.Errorf("journal has %d bytes after checkpoint", size)
+	}
+}
+
+func TestJournalCheckpointKeepsHeld(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+
+	w, err := New(rec, WithJournal(journal), WithQuietPeriod(time.Hour))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.source.Close()
+
+	a, b := filepath.Join(tmpDir, "a.txt"), filepath.Join(tmpDir, "held.log")
+	w.handleEvents([]fsnotify.Event{{Name: a, Op: fsnotify.Remove}, {Name: b, Op: fsnotify.Write}})
+	for range 2 {
+		item := <-w.batchChan
+		w.batchMu.Lock()
+		w.add(recentfile.BatchItem{Path: item.path, Type: item.typ}, time.Hour)
+		w.batchMu.Unlock()
+	}
+	// The delete is written, the new of held.log held
+	w.flushBatch()
+
+	// Only the held event is left in the journal
+	j, err := OpenJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	entries, err := j.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != b {
+		t.Errorf("journal entries = %+v, want %s only", entries, b)
+	}
+}
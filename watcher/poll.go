@@ -0,0 +1,200 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollEntry is the cached state of one path in a PollSource.
+type pollEntry struct {
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+}
+
+// PollSource is an EventSource that walks the tree on a schedule and diffs
+// it against the previous walk, for filesystems where inotify is absent or
+// unreliable, such as NFS. Changes are reported once per interval; a file
+// changed and changed back within one interval without its size or
+// modification time moving is missed. Symlinks are reported but not
+// followed.
+type PollSource struct {
+	root     string
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+
+	// Only touched by the poll loop after construction
+	tree map[string]pollEntry
+}
+
+// NewPollSource walks root once to build the initial tree and then polls
+// it every interval until Close.
+func NewPollSource(root string, interval time.Duration) (*PollSource, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("poll interval must be positive, not %s", interval)
+	}
+
+	p := &PollSource{
+		root:     root,
+		interval: interval,
+		events:   make(chan fsnotify.Event, 1000),
+		errors:   make(chan error, 10),
+		done:     make(chan struct{}),
+	}
+
+	tree, _, err := p.scan()
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", root, err)
+	}
+	p.tree = tree
+
+	go p.pollLoop()
+
+	return p, nil
+}
+
+// pollLoop rescans the tree every interval and sends the differences.
+func (p *PollSource) pollLoop() {
+	defer close(p.events)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+
+		tree, skipped, err := p.scan()
+		if err != nil {
+			p.sendError(fmt.Errorf("scan %s: %w", p.root, err))
+			continue
+		}
+
+		for _, event := range p.diff(tree, skipped) {
+			select {
+			case p.events <- event:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// scan walks the tree. Directories that can't be read are returned in
+// skipped, so their previous contents are not reported as removed.
+func (p *PollSource) scan() (map[string]pollEntry, []string, error) {
+	tree := make(map[string]pollEntry)
+	var skipped []string
+
+	err := filepath.WalkDir(p.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == p.root {
+				return err
+			}
+			if d != nil && d.IsDir() {
+				skipped = append(skipped, path)
+				return filepath.SkipDir
+			}
+			return nil // vanished
+		}
+		if path == p.root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // vanished
+		}
+		tree[path] = pollEntry{mode: info.Mode(), size: info.Size(), mtime: info.ModTime()}
+		return nil
+	})
+	return tree, skipped, err
+}
+
+// diff replaces the cached tree and returns the changes, sorted by path.
+func (p *PollSource) diff(tree map[string]pollEntry, skipped []string) []fsnotify.Event {
+	var events []fsnotify.Event
+
+	for path, cur := range tree {
+		prev, ok := p.tree[path]
+		switch {
+		case !ok:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case prev.mode.Type() != cur.mode.Type():
+			events = append(events,
+				fsnotify.Event{Name: path, Op: fsnotify.Remove},
+				fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case cur.mode.IsDir():
+			// Directory mtimes change with their entries, which are reported
+		case prev.size != cur.size || !prev.mtime.Equal(cur.mtime):
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		case prev.mode != cur.mode:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+		}
+	}
+
+	for path, prev := range p.tree {
+		if _, ok := tree[path]; ok {
+			continue
+		}
+		if below(path, skipped) {
+			tree[path] = prev // unknown, keep it
+			continue
+		}
+		events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+	}
+
+	p.tree = tree
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	return events
+}
+
+// below reports whether path is inside one of dirs.
+func below(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendError reports an error without blocking the poll loop.
+func (p *PollSource) sendError(err error) {
+	select {
+	case p.errors <- err:
+	default:
+	}
+}
+
+// Events returns the channel of change notifications.
+func (p *PollSource) Events() <-chan fsnotify.Event { return p.events }
+
+// Errors returns the channel of scan errors.
+func (p *PollSource) Errors() <-chan error { return p.errors }
+
+// Add is a no-op; every scan covers the whole tree.
+func (p *PollSource) Add(path string) error { return nil }
+
+// WatchesTree reports that no per-directory watches are needed.
+func (p *PollSource) WatchesTree() bool { return true }
+
+// Close stops polling.
+func (p *PollSource) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// nextEvents collects the events of one poll.
+func nextEvents(t *testing.T, p *PollSource) map[string]fsnotify.Op {
+	t.Helper()
+	ops := map[string]fsnotify.Op{}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-p.Events():
+			ops[filepath.Base(e.Name)] |= e.Op
+		case <-time.After(100 * time.Millisecond):
+			if len(ops) > 0 {
+				return ops
+			}
+		case <-timeout:
+			return ops
+		}
+	}
+}
+
+func TestPollSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "keep.txt"), []byte("keep"), 0o644)
+	os.WriteFile(filepath.Join(tmpDir, "change.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(tmpDir, "gone.txt"), []byte("gone"), 0o644)
+
+	p, err := NewPollSource(tmpDir, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewPollSource failed: %v", err)
+	}
+	defer p.Close()
+
+	os.WriteFile(filepath.Join(tmpDir, "change.txt"), []byte("changed"), 0o644)
+	os.Remove(filepath.Join(tmpDir, "gone.txt"))
+	os.MkdirAll(filepath.Join(tmpDir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(tmpDir, "sub", "new.txt"), []byte("new"), 0o644)
+
+	ops := nextEvents(t, p)
+	want := map[string]fsnotify.Op{
+		"change.txt": fsnotify.Write,
+		"gone.txt":   fsnotify.Remove,
+		"sub":        fsnotify.Create,
+		"new.txt":    fsnotify.Create,
+	}
+	if len(ops) != len(want) {
+		t.Errorf("events = %v, want %v", ops, want)
+	}
+	for name, op := range want {
+		if ops[name] != op {
+			t.Errorf("%s op = %v, want %v", name, ops[name], op)
+		}
+	}
+
+	// A removed directory reports its files too
+	os.RemoveAll(filepath.Join(tmpDir, "sub"))
+	ops = nextEvents(t, p)
+	if ops["sub"] != fsnotify.Remove || ops["new.txt"] != fsnotify.Remove {
+		t.Errorf("events after RemoveAll = %v", ops)
+	}
+}
+
+func TestPollBackend(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	w, err := New(rec, WithBackend("poll"), WithPollInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0o644)
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	if types := eventTypes(w); types["a.txt"] != "new" {
+		t.Errorf("a.txt type = %q, want new", types["a.txt"])
+	}
+
+	if _, err := New(rec, WithBackend("carrier-pigeon")); err == nil {
+		t.Error("New accepted an unknown backend")
+	}
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// WithQuietPeriod holds the "new" event of a path until the path has seen
+// no further event for d, so a file written over and over, such as a log,
+// is recorded once when it settles rather than with every batch. A delete
+// is recorded at once, replacing a held event. Held events are written
+// when the watcher stops. If set to 0 (the default), events are written
+// with the next batch.
+func WithQuietPeriod(d time.Duration) Option {
+	return func(w *Watcher) {
+		w.quietPeriod = d
+	}
+}
+
+// heldItem is a "new" event waiting for its path to settle.
+type heldItem struct {
+	item recentfile.BatchItem
+	last time.Time // when the path last had an event
+}
+
+// add appends item to the batch or, with a quiet period, holds it until
+// its path settles. The caller holds w.batchMu.
+func (w *Watcher) add(item recentfile.BatchItem, quietPeriod time.Duration) {
+	if quietPeriod > 0 && item.Type == "new" {
+		if w.held == nil {
+			w.held = make(map[string]heldItem)
+		}
+		w.held[item.Path] = heldItem{item: item, last: time.Now()}
+		return
+	}
+	delete(w.held, item.Path)
+	w.batch = append(w.batch, item)
+}
+
+// release moves the held events of the paths that had no event since
+// cutoff to the batch, returning how many.
+func (w *Watcher) release(cutoff time.Time) int {
+	w.batchMu.Lock()
+	defer w.batchMu.Unlock()
+
+	n := 0
+	for path, h := range w.held {
+		if h.last.After(cutoff) {
+			continue
+		}
+		w.batch = append(w.batch, h.item)
+		delete(w.held, path)
+		n++
+	}
+	return n
+}
+
+// isHeld reports whether the event of path is held.
+func (w *Watcher) isHeld(path string) bool {
+	w.batchMu.Lock()
+	defer w.batchMu.Unlock()
+	_, ok := w.held[path]
+	return ok
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package watcher
+
+import "errors"
+
+// NewReadDirChangesSource is only available on Windows.
+func NewReadDirChangesSource(root string) (EventSource, error) {
+	return nil, errors.New("ReadDirectoryChangesW is only available on Windows")
+}
@@ -0,0 +1,174 @@
+//go:build windows
+
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/windows"
+)
+
+// readDirChangesMask is the set of changes watched: file and directory
+// names, and file sizes, contents and attributes.
+const readDirChangesMask = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_ATTRIBUTES | windows.FILE_NOTIFY_CHANGE_SIZE | windows.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// readDirChangesBufferSize is the size of the buffer the changes are read
+// into; 64 KiB is the largest that works on network shares.
+const readDirChangesBufferSize = 64 * 1024
+
+// ReadDirChangesSource is an EventSource using a single
+// ReadDirectoryChangesW call watching the whole tree below the root,
+// instead of one directory handle per directory as fsnotify uses on
+// Windows. Changes that don't fit the buffer are lost and reported as
+// fsnotify.ErrEventOverflow.
+type ReadDirChangesSource struct {
+	root    string
+	handle  windows.Handle
+	ov      windows.Overlapped
+	events  chan fsnotify.Event
+	errors  chan error
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewReadDirChangesSource opens root to watch its tree.
+func NewReadDirChangesSource(root string) (EventSource, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", root, err)
+	}
+	name, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", root, err)
+	}
+
+	handle, err := windows.CreateFile(name, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", root, err)
+	}
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(handle)
+		return nil, fmt.Errorf("create event: %w", err)
+	}
+
+	s := &ReadDirChangesSource{
+		root:    root,
+		handle:  handle,
+		events:  make(chan fsnotify.Event, 1000),
+		errors:  make(chan error, 10),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	s.ov.HEvent = event
+
+	go s.readLoop()
+
+	return s, nil
+}
+
+// readLoop reads and translates changes until Close.
+func (s *ReadDirChangesSource) readLoop() {
+	defer close(s.stopped)
+	defer close(s.events)
+
+	buf := make([]byte, readDirChangesBufferSize)
+	for {
+		if err := windows.ReadDirectoryChanges(s.handle, &buf[0], uint32(len(buf)), true, readDirChangesMask, nil, &s.ov, 0); err != nil {
+			s.sendError(fmt.Errorf("ReadDirectoryChangesW %s: %w", s.root, err))
+			return
+		}
+		var n uint32
+		if err := windows.GetOverlappedResult(s.handle, &s.ov, &n, true); err != nil {
+			if !errors.Is(err, windows.ERROR_OPERATION_ABORTED) {
+				s.sendError(fmt.Errorf("ReadDirectoryChangesW %s: %w", s.root, err))
+			}
+			return
+		}
+		if n == 0 {
+			s.sendError(fsnotify.ErrEventOverflow)
+			continue
+		}
+
+		for _, event := range s.translate(buf[:n]) {
+			select {
+			case s.events <- event:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// translate converts the FILE_NOTIFY_INFORMATION records in buf. Their
+// names are relative to the root, with backslashes.
+func (s *ReadDirChangesSource) translate(buf []byte) []fsnotify.Event {
+	var events []fsnotify.Event
+	for offset := uint32(0); int(offset) < len(buf); {
+		info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+		name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+
+		var op fsnotify.Op
+		switch info.Action {
+		case windows.FILE_ACTION_ADDED, windows.FILE_ACTION_RENAMED_NEW_NAME:
+			op = fsnotify.Create
+		case windows.FILE_ACTION_REMOVED:
+			op = fsnotify.Remove
+		case windows.FILE_ACTION_RENAMED_OLD_NAME:
+			op = fsnotify.Rename
+		case windows.FILE_ACTION_MODIFIED:
+			op = fsnotify.Write
+		}
+		if op != 0 {
+			events = append(events, fsnotify.Event{Name: filepath.Join(s.root, name), Op: op})
+		}
+
+		if info.NextEntryOffset == 0 {
+			break
+		}
+		offset += info.NextEntryOffset
+	}
+	return events
+}
+
+// sendError reports an error without blocking the read loop.
+func (s *ReadDirChangesSource) sendError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+// Events returns the channel of change notifications.
+func (s *ReadDirChangesSource) Events() <-chan fsnotify.Event { return s.events }
+
+// Errors returns the channel of source errors.
+func (s *ReadDirChangesSource) Errors() <-chan error { return s.errors }
+
+// Add is a no-op; the root's handle covers the whole tree.
+func (s *ReadDirChangesSource) Add(path string) error { return nil }
+
+// WatchesTree reports that no per-directory watches are needed.
+func (s *ReadDirChangesSource) WatchesTree() bool { return true }
+
+// Close cancels the pending read and closes the handles once the read
+// loop is done with them.
+func (s *ReadDirChangesSource) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		windows.CancelIoEx(s.handle, &s.ov)
+		<-s.stopped
+		windows.CloseHandle(s.handle)
+		windows.CloseHandle(s.ov.HEvent)
+	})
+	return nil
+}
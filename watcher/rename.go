@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// renameWindow is how soon after a rename the create of its target must
+// arrive to be paired with it. Event sources report both halves of a move
+// back to back; fsnotify doesn't expose inotify's rename cookie.
+const renameWindow = 100 * time.Millisecond
+
+// pendingRename is the source of a rename waiting for its target.
+type pendingRename struct {
+	path string
+	at   time.Time
+}
+
+// movedDir returns "new" events for the files below dir when dir was just
+// created by renaming from.path, i.e. a directory moved within the tree.
+// The old path is marked as a directory so that, when the batch is
+// flushed, its delete expands to deletes of the files recorded below it.
+// Watches under the old path are removed; fsnotify would otherwise keep
+// reporting changes below the moved directory under the old path. The
+// caller watches dir afterwards.
+func (w *Watcher) movedDir(dir string, from pendingRename) []batchItem {
+	if from.path == "" || time.Since(from.at) > renameWindow {
+		return nil
+	}
+	w.addDir(from.path)
+
+	if rs, ok := w.source.(removableSource); ok {
+		prefix := from.path + string(filepath.Separator)
+		w.dirsMu.Lock()
+		for d := range w.dirs {
+			if d == from.path || strings.HasPrefix(d, prefix) {
+				rs.Remove(d) // may be gone already
+			}
+		}
+		w.dirsMu.Unlock()
+	}
+
+	var items []batchItem
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && w.filter.Load().IgnoredDir(w.relPath(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if recentfile.ShouldIgnoreFile(d.Name()) || w.isRecentFile(path) || w.isFiltered(path) {
+			return nil
+		}
+		items = append(items, batchItem{path: path, typ: "new"})
+		return nil
+	})
+
+	if w.verbose && len(items) > 0 {
+		fmt.Printf("Directory moved: %s -> %s (%d files)\n", from.path, dir, len(items))
+	}
+
+	return items
+}
+
+// dirItems returns "new" events for dir and the directories below it when
+// directories are recorded (see WithDirEvents).
+func (w *Watcher) dirItems(dir string) []batchItem {
+	if !w.dirEvents {
+		return nil
+	}
+
+	var items []batchItem
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if recentfile.ShouldIgnoreFile(d.Name()) || w.isRecentFile(path) || w.filter.Load().IgnoredDir(w.relPath(path)) {
+			return filepath.SkipDir
+		}
+		items = append(items, batchItem{path: path, typ: "new", dir: true})
+		return nil
+	})
+	return items
+}
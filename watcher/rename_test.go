@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// latestEvents returns the newest event per path in the principal recentfile.
+func latestEvents(w *Watcher) map[string]recentfile.Event {
+	events := map[string]recentfile.Event{}
+	for _, e := range w.recent.PrincipalRecentfile().RecentEvents() {
+		if prev, ok := events[e.Path]; !ok || recentfile.EpochGt(e.Epoch, prev.Epoch) {
+			events[e.Path] = e
+		}
+	}
+	return events
+}
+
+func TestRenameFile(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	os.WriteFile(filepath.Join(tmpDir, "old.txt"), []byte("x"), 0o644)
+	rec.Update(filepath.Join(tmpDir, "old.txt"), "new")
+
+	w, _ := New(rec)
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.Rename(filepath.Join(tmpDir, "old.txt"), filepath.Join(tmpDir, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	events := latestEvents(w)
+	from, to := events["old.txt"], events["new.txt"]
+	if from.Type != "delete" || to.Type != "new" {
+		t.Fatalf("old.txt %q, new.txt %q; want delete, new", from.Type, to.Type)
+	}
+	if !recentfile.EpochLt(from.Epoch, to.Epoch) {
+		t.Errorf("delete of source (%v) not before new of target (%v)", from.Epoch, to.Epoch)
+	}
+}
+
+func TestRenameDirectory(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	oldDir := filepath.Join(tmpDir, "old")
+	os.MkdirAll(filepath.Join(oldDir, "sub"), 0o755)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		path := filepath.Join(oldDir, name)
+		os.WriteFile(path, []byte(name), 0o644)
+		rec.Update(path, "new")
+	}
+
+	w, _ := New(rec)
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.Rename(oldDir, filepath.Join(tmpDir, "new")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	events := latestEvents(w)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		from, to := events["old/"+name], events["new/"+name]
+		if from.Type != "delete" || to.Type != "new" {
+			t.Errorf("old/%s %q, new/%s %q; want delete, new", name, from.Type, name, to.Type)
+			continue
+		}
+		if !recentfile.EpochLt(from.Epoch, to.Epoch) {
+			t.Errorf("%s: delete of source not before new of target", name)
+		}
+	}
+
+	// Changes below the new location are seen
+	os.WriteFile(filepath.Join(tmpDir, "new", "sub", "c.txt"), []byte("c"), 0o644)
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+	if events := latestEvents(w); events["new/sub/c.txt"].Type != "new" {
+		t.Errorf("new/sub/c.txt not recorded after the move: %v", events)
+	}
+}
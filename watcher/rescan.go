@@ -0,0 +1,130 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/abh/rrrgo/fsck"
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// WithRescanInterval periodically compares the tree on disk with the
+// recorded state and writes corrective events for changes the event
+// source missed: files that are not recorded (or recorded as deleted),
+// files modified after their last event, and recorded files (and
+// directories, see WithDirEvents) that are gone. Paths excluded by the
+// ignore and include patterns are left alone.
+// If set to 0, rescanning is disabled.
+func WithRescanInterval(interval time.Duration) Option {
+	return func(w *Watcher) {
+		w.rescanInterval = interval
+	}
+}
+
+// WithArchiveDir sets the archive of events rotated out of the Z
+// recentfile, so rescans and directory removals know about files that are
+// only recorded there.
+func WithArchiveDir(dir string) Option {
+	return func(w *Watcher) {
+		w.archiveDir = dir
+	}
+}
+
+// WithRescanCallback sets a callback for tracking rescans.
+// The callback is called after each successful rescan with the number of
+// corrective events written and the duration.
+func WithRescanCallback(callback func(corrected int, duration time.Duration)) Option {
+	return func(w *Watcher) {
+		w.rescanCallback = callback
+	}
+}
+
+// Rescan compares the tree on disk with the recorded state and writes
+// corrective events. It returns the number of events written. Events
+// still queued are flushed first.
+func (w *Watcher) Rescan() (int, error) {
+	w.flushBatch()
+	// The index state is read from the files
+	if err := w.recent.FlushContext(w.ctx); err != nil {
+		return 0, fmt.Errorf("write deferred events: %w", err)
+	}
+
+	start := time.Now()
+	scanStart := recentfile.EpochFromTime(start)
+
+	state, err := fsck.IndexState(w.recent, w.archiveDir)
+	if err != nil {
+		return 0, fmt.Errorf("build index state: %w", err)
+	}
+
+	var batch []recentfile.BatchItem
+	onDisk := make(map[string]bool)
+
+	filter := w.filter.Load()
+	err = w.recent.WalkFiles(filter, func(relPath string, info fs.FileInfo) error {
+		onDisk[relPath] = true
+
+		event, ok := state[relPath]
+		switch {
+		case !ok || event.Type != "new":
+		case w.modifiedAfter(info, event.Epoch, scanStart):
+		default:
+			return nil
+		}
+
+		path := filepath.Join(w.rootDir, filepath.FromSlash(relPath))
+		if w.isHeld(path) {
+			return nil // recorded once it settles
+		}
+		batch = append(batch, recentfile.BatchItem{Path: path, Type: "new"})
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("walk %s: %w", w.rootDir, err)
+	}
+
+	for path, event := range state {
+		if event.Type != "new" || onDisk[path] {
+			continue
+		}
+		full := filepath.Join(w.rootDir, filepath.FromSlash(path))
+		if event.Dir {
+			// Directories (see WithDirEvents) are not walked as files
+			if isDir(full) || filter.IgnoredDir(path) {
+				continue
+			}
+		} else if filter.Ignored(path) {
+			continue
+		}
+		batch = append(batch, recentfile.BatchItem{Path: full, Type: "delete", Dir: event.Dir})
+	}
+
+	if len(batch) > 0 {
+		if w.verbose {
+			fmt.Printf("Rescan: writing %d corrective events\n", len(batch))
+		}
+		if err := w.recent.BatchUpdateContext(w.ctx, batch); err != nil {
+			return 0, fmt.Errorf("batch update: %w", err)
+		}
+		w.countEvents(batch)
+	}
+
+	if w.rescanCallback != nil {
+		w.rescanCallback(len(batch), time.Since(start))
+	}
+
+	return len(batch), nil
+}
+
+// modifiedAfter reports whether info's modification time is after epoch.
+// Files with modification times in the future are left alone, since they
+// would otherwise be recorded again on every rescan.
+func (w *Watcher) modifiedAfter(info fs.FileInfo, epoch, now recentfile.Epoch) bool {
+	if info.IsDir() {
+		return false
+	}
+	mtime := recentfile.EpochFromTime(info.ModTime())
+	return recentfile.EpochGt(mtime, epoch) && recentfile.EpochLe(mtime, now)
+}
@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRescan(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	write := func(name string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	// Recorded and unchanged, recorded but deleted, recorded but modified later
+	past := time.Now().Add(-time.Minute)
+	for _, name := range []string{"same.txt", "gone.txt", "changed.txt"} {
+		write(name, past)
+		if err := rec.Update(filepath.Join(tmpDir, name), "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Remove(filepath.Join(tmpDir, "gone.txt"))
+	write("changed.txt", time.Now())
+	// Never recorded, and one with a future mtime
+	write("dir/missed.txt", past)
+	write("future.txt", time.Now().Add(time.Hour))
+
+	var corrected int
+	w, err := New(rec, WithRescanCallback(func(n int, d time.Duration) { corrected = n }))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.source.Close()
+
+	n, err := w.Rescan()
+	if err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	if n != 4 || corrected != 4 {
+		t.Errorf("Rescan wrote %d events (callback %d), want 4", n, corrected)
+	}
+
+	types := eventTypes(w)
+	for path, want := range map[string]string{
+		"same.txt":       "new",
+		"gone.txt":       "delete",
+		"changed.txt":    "new",
+		"dir/missed.txt": "new",
+		"future.txt":     "new",
+	} {
+		if types[path] != want {
+			t.Errorf("%s type = %q, want %q", path, types[path], want)
+		}
+	}
+
+	// Nothing left to correct
+	if n, err := w.Rescan(); err != nil || n != 0 {
+		t.Errorf("second Rescan = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// Sink receives the batches of events written to the RECENT files, after
+// deduplication, e.g. to feed a message bus. The sinks of package sink
+// (webhook, NATS, Kafka, external commands) implement it.
+type Sink interface {
+	// Publish sends one committed batch.
+	Publish(ctx context.Context, events []recentfile.Event) error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// WithSinks publishes every batch committed to the Recent collection to
+// sinks while the watcher runs, so one process both maintains the RECENT
+// files and feeds other systems. Each sink gets the batches in order, in
+// the background: one falling far behind loses batches rather than
+// holding up the RECENT files (see recent.Recent.Subscribe). Publish
+// errors go to the error handler. Stop closes the sinks once they have
+// published the last batch.
+func WithSinks(sinks ...Sink) Option {
+	return func(w *Watcher) {
+		w.sinks = append(w.sinks, sinks...)
+	}
+}
+
+// startSinks subscribes every sink to the Recent collection.
+func (w *Watcher) startSinks() {
+	for _, s := range w.sinks {
+		batches, cancel := w.recent.Subscribe(context.Background())
+		w.sinkCancels = append(w.sinkCancels, cancel)
+
+		w.sinkWg.Add(1)
+		go func() {
+			defer w.sinkWg.Done()
+			for batch := range batches {
+				err := s.Publish(context.Background(), batch)
+				if err != nil && w.errorHandler != nil {
+					w.errorHandler(fmt.Errorf("sink: publish %d events: %w", len(batch), err))
+				}
+			}
+		}()
+	}
+}
+
+// unsubscribeSinks ends the subscriptions of the sinks and waits for them
+// to publish the batches already queued.
+func (w *Watcher) unsubscribeSinks() {
+	for _, cancel := range w.sinkCancels {
+		cancel()
+	}
+	w.sinkCancels = nil
+	w.sinkWg.Wait()
+}
+
+// stopSinks publishes the batches queued for the sinks and closes them.
+func (w *Watcher) stopSinks() error {
+	w.unsubscribeSinks()
+	var errs []error
+	for _, s := range w.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close sink: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abh/rrrgo/recentfile"
+)
+
+// fakeSink records the batches it is given.
+type fakeSink struct {
+	err error
+
+	mu      sync.Mutex
+	batches [][]recentfile.Event
+	closed  bool
+}
+
+func (s *fakeSink) Publish(ctx context.Context, events []recentfile.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, events)
+	return s.err
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	sink := &fakeSink{}
+	failing := &fakeSink{err: errors.New("unavailable")}
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	w, _ := New(rec,
+		WithBatchSize(1000),
+		WithBatchDelay(10*time.Second),
+		WithSinks(sink, failing),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}))
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	testFile := filepath.Join(tmpDir, "test.txt")
+	for range 5 {
+		os.WriteFile(testFile, []byte("test"), 0o644)
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Stop flushes the batch and waits for the sinks to publish it
+	if err := w.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	for _, s := range []*fakeSink{sink, failing} {
+		if len(s.batches) != 1 || len(s.batches[0]) != 1 {
+			t.Fatalf("batches = %+v, want one deduplicated event", s.batches)
+		}
+		if event := s.batches[0][0]; event.Path != "test.txt" || event.Type != "new" {
+			t.Errorf("event = %+v", event)
+		}
+		if !s.closed {
+			t.Error("sink not closed on Stop")
+		}
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], failing.err) {
+		t.Errorf("errors = %v, want the failed publish", errs)
+	}
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// EventSource delivers filesystem change notifications to the watcher.
+// The default source is fsnotify (inotify, kqueue, ReadDirectoryChangesW).
+type EventSource interface {
+	// Events returns the channel of change notifications.
+	// Event names are absolute paths below the watched root.
+	Events() <-chan fsnotify.Event
+
+	// Errors returns the channel of source errors.
+	Errors() <-chan error
+
+	// Add starts watching a single directory.
+	Add(path string) error
+
+	// Close stops the source and closes both channels.
+	Close() error
+}
+
+// treeSource is implemented by sources that observe the whole tree without
+// per-directory registration. The watcher then skips walking the tree.
+type treeSource interface {
+	WatchesTree() bool
+}
+
+// removableSource is implemented by sources whose per-directory watches
+// must be removed when a directory moves, so they can be set up again
+// under the new path.
+type removableSource interface {
+	Remove(path string) error
+}
+
+// fsnotifySource adapts an fsnotify.Watcher to EventSource.
+type fsnotifySource struct {
+	fsw *fsnotify.Watcher
+}
+
+// newFsnotifySource creates the default fsnotify-based event source.
+func newFsnotifySource() (*fsnotifySource, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifySource{fsw: fsw}, nil
+}
+
+func (s *fsnotifySource) Events() <-chan fsnotify.Event { return s.fsw.Events }
+func (s *fsnotifySource) Errors() <-chan error          { return s.fsw.Errors }
+func (s *fsnotifySource) Add(path string) error         { return s.fsw.Add(path) }
+func (s *fsnotifySource) Remove(path string) error      { return s.fsw.Remove(path) }
+func (s *fsnotifySource) Close() error                  { return s.fsw.Close() }
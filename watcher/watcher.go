@@ -7,19 +7,30 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/abh/rrrgo/pathfilter"
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
 )
 
 // Watcher monitors a directory tree for changes and updates a Recent collection.
 type Watcher struct {
-	// fsnotify watcher
-	fsw *fsnotify.Watcher
+	// Source of filesystem events (fsnotify unless configured otherwise)
+	source EventSource
+
+	// Backend used when no source is given ("fsnotify" or "poll")
+	backend      string
+	pollInterval time.Duration
 
 	// Recent collection to update
 	recent *recent.Recent
@@ -30,6 +41,24 @@ type Watcher struct {
 	// Pattern to ignore (RECENT files)
 	ignoredRx *regexp.Regexp
 
+	// Operator-supplied ignore and include patterns (nil = record everything)
+	ignorePatterns  []string
+	includePatterns []string
+	filter          atomic.Pointer[pathfilter.Filter]
+
+	// Record directories created and removed as events (see WithDirEvents)
+	dirEvents bool
+
+	// Skip chmod-only events (see WithIgnoreChmod)
+	ignoreChmod atomic.Bool
+
+	// configMu guards the settings Reconfigure changes while running:
+	// the patterns, batchSize, batchDelay, quietPeriod, aggregateInterval
+	// and rescanInterval. reconfigured wakes the batch processor to apply
+	// them.
+	configMu     sync.Mutex
+	reconfigured chan struct{}
+
 	// Batch processing
 	batchChan   chan batchItem
 	batchSize   int           // Max batch size before flush
@@ -39,9 +68,35 @@ type Watcher struct {
 	lastFlush   time.Time
 	lastFlushMu sync.Mutex
 
+	// Events held until their path settles (see WithQuietPeriod), by
+	// path; guarded by batchMu
+	quietPeriod time.Duration
+	held        map[string]heldItem
+
+	// Totals for Stats
+	dropped atomic.Int64 // events dropped because the queue was full
+	batched atomic.Int64 // events flushed, before deduplication
+	written atomic.Int64 // events written, after deduplication
+
 	// Aggregation
 	aggregateInterval time.Duration // How often to run aggregation (0 = disabled)
 
+	// Last rename seen, for pairing it with the following create. Only
+	// touched by the event loop.
+	lastRename pendingRename
+
+	// Watched directories, for expanding directory removals
+	dirs   map[string]bool
+	dirsMu sync.Mutex
+
+	// Rescans of the tree (0 = disabled)
+	rescanInterval time.Duration
+	archiveDir     string
+
+	// Write-ahead journal of accepted events (nil = disabled)
+	journalPath string
+	journal     *Journal
+
 	// Context for shutdown
 	ctx     context.Context
 	cancel  context.CancelFunc
@@ -62,12 +117,33 @@ type Watcher struct {
 	// Aggregation callback - called after successful aggregation
 	// Argument: duration of aggregation
 	aggregationCallback func(duration time.Duration)
+
+	// Aggregation skip callback - called when a periodic aggregation is
+	// skipped because RECENT files are locked elsewhere
+	// Argument: intervals of the locked recentfiles
+	aggregationSkipCallback func(held []string)
+
+	// Rescan callback - called after each successful rescan
+	rescanCallback func(corrected int, duration time.Duration)
+
+	// Sinks fed with committed batches (see WithSinks)
+	sinks       []Sink
+	sinkCancels []func()
+	sinkWg      sync.WaitGroup
+
+	// Flush callback - called after each successful batch flush
+	flushCallback func(events int, duration time.Duration)
 }
 
+// tracer traces batch flushes. Spans are only recorded once the program
+// installs an OpenTelemetry tracer provider.
+var tracer = otel.Tracer("github.com/abh/rrrgo/watcher")
+
 // batchItem is an internal item in the batch channel.
 type batchItem struct {
 	path string
 	typ  string
+	dir  bool
 }
 
 // Option is a functional option for configuring the Watcher.
@@ -117,6 +193,89 @@ func WithAggregateInterval(interval time.Duration) Option {
 	}
 }
 
+// WithIgnorePatterns excludes paths matching any of patterns from event
+// generation, e.g. scratch directories, .git or build artifacts. See
+// package pathfilter for the pattern syntax.
+func WithIgnorePatterns(patterns ...string) Option {
+	return func(w *Watcher) {
+		w.ignorePatterns = append(w.ignorePatterns, patterns...)
+	}
+}
+
+// WithIncludePatterns limits event generation to files matching at least
+// one of patterns. Ignore patterns still apply.
+func WithIncludePatterns(patterns ...string) Option {
+	return func(w *Watcher) {
+		w.includePatterns = append(w.includePatterns, patterns...)
+	}
+}
+
+// WithBackend selects the built-in event source: "fsnotify" (the default);
+// "fanotify" (Linux), "fsevents" (macOS) or "readdirchanges" (Windows),
+// which cover the whole tree with a single watch instead of one per
+// directory; or "poll", which scans the tree every poll interval instead
+// of relying on kernel notifications, for trees on NFS and similar
+// filesystems. It is ignored when WithEventSource is given.
+func WithBackend(name string) Option {
+	return func(w *Watcher) {
+		w.backend = name
+	}
+}
+
+// WithDirEvents records the creation and removal of directories as events
+// of their own, marked with recentfile.Event.Dir, so clients can recreate
+// empty directories. Without it only files are recorded. The directories
+// below a new directory get events too, since they may have been created
+// before it was watched.
+func WithDirEvents(on bool) Option {
+	return func(w *Watcher) {
+		w.dirEvents = on
+	}
+}
+
+// WithIgnoreChmod skips events that only change the permissions of a
+// file, which would otherwise be recorded as "new" and make mirrors fetch
+// the unchanged content again. They are still recorded while events carry
+// file modes and owners (see recentfile.WithProtocolExt), as those change.
+func WithIgnoreChmod(on bool) Option {
+	return func(w *Watcher) {
+		w.ignoreChmod.Store(on)
+	}
+}
+
+// WithPollInterval sets how often the poll backend scans the tree.
+func WithPollInterval(interval time.Duration) Option {
+	return func(w *Watcher) {
+		w.pollInterval = interval
+	}
+}
+
+// WithEventSource replaces the default fsnotify event source, e.g. with a
+// FeedSource for environments where inotify is impractical.
+func WithEventSource(source EventSource) Option {
+	return func(w *Watcher) {
+		w.source = source
+	}
+}
+
+// WithJournal records every accepted event in a journal at path before it
+// is queued, and replays the journal on Start. The journal must not be
+// inside the watched tree.
+func WithJournal(path string) Option {
+	return func(w *Watcher) {
+		w.journalPath = path
+	}
+}
+
+// WithFlushCallback sets a callback for tracking batch flushes.
+// The callback is called after each successful flush with the number of
+// events written (after deduplication) and the duration of the flush.
+func WithFlushCallback(callback func(events int, duration time.Duration)) Option {
+	return func(w *Watcher) {
+		w.flushCallback = callback
+	}
+}
+
 // WithAggregationCallback sets a callback for tracking aggregation runs.
 // The callback is called after each successful aggregation with the duration.
 func WithAggregationCallback(callback func(duration time.Duration)) Option {
@@ -125,39 +284,55 @@ func WithAggregationCallback(callback func(duration time.Duration)) Option {
 	}
 }
 
+// WithAggregationSkipCallback sets a callback for aggregations skipped
+// because another process, such as an aggregation run from cron, holds the
+// locks of RECENT files. It is called with the intervals of those files.
+func WithAggregationSkipCallback(callback func(held []string)) Option {
+	return func(w *Watcher) {
+		w.aggregationSkipCallback = callback
+	}
+}
+
 // New creates a new file system watcher for the given Recent collection.
 func New(rec *recent.Recent, opts ...Option) (*Watcher, error) {
 	if rec == nil {
 		return nil, fmt.Errorf("recent collection cannot be nil")
 	}
 
-	// Create fsnotify watcher
-	fsw, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("create fsnotify watcher: %w", err)
-	}
-
 	// Create context
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Build ignore regex for RECENT files
+	// Build ignore regex for RECENT files. It matches the path relative to
+	// the root: our own recentfiles live in the root directory, while lock
+	// and temp files (see recentfile.TempName) of any hierarchy (including
+	// ones nested below us, as in the CPAN layout) are never content.
+	// Signatures and generations (see recentfile.WithGenerations) go with
+	// the files, and so do the files left behind by Recent.Convert in any
+	// format.
 	meta := rec.PrincipalRecentfile().Meta()
-	pattern := fmt.Sprintf(`^%s(-[0-9]*[smhdWMQYZ]%s(\.lock(/.*)?|\.new)?|\.recent)$`,
-		regexp.QuoteMeta(meta.Filenameroot),
-		regexp.QuoteMeta(meta.SerializerSuffix))
+	root := regexp.QuoteMeta(meta.Filenameroot)
+	suffix := regexp.QuoteMeta(meta.SerializerSuffix)
+	sig := regexp.QuoteMeta(recentfile.SignatureSuffix)
+	bak := regexp.QuoteMeta(recent.BackupSuffix)
+	tmp := regexp.QuoteMeta(recentfile.TempSuffix)
+	interval := "(?:" + recentfile.IntervalPattern + ")"
+	pattern := fmt.Sprintf(`^%s(-%s%s|\.recent)(%s)?$|^%s-%s%s(%s)?\.[0-9]+$|^%s-%s\.[^/]*%s$|(^|/)%s-%s%s(\.lock(/.*)?|[^/]*%s)$`,
+		root, interval, suffix, sig, root, interval, suffix, sig, root, interval, bak, root, interval, suffix, tmp)
 	ignoredRx := regexp.MustCompile(pattern)
 
 	w := &Watcher{
-		fsw:          fsw,
 		recent:       rec,
-		rootDir:      rec.LocalRoot(),
+		rootDir:      filepath.Clean(rec.LocalRoot()),
 		ignoredRx:    ignoredRx,
+		dirs:         make(map[string]bool),
 		batchChan:    make(chan batchItem, 100000),
 		batchSize:    1000,
 		batchDelay:   1 * time.Second,
+		pollInterval: 10 * time.Second,
 		ctx:          ctx,
 		cancel:       cancel,
 		lastFlush:    time.Now(),
+		reconfigured: make(chan struct{}, 1),
 		errorHandler: func(err error) { fmt.Fprintf(os.Stderr, "watcher error: %v\n", err) },
 	}
 
@@ -166,6 +341,66 @@ func New(rec *recent.Recent, opts ...Option) (*Watcher, error) {
 		opt(w)
 	}
 
+	filter, err := pathfilter.New(w.ignorePatterns, w.includePatterns)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w.filter.Store(filter)
+
+	if w.source == nil {
+		switch w.backend {
+		case "", "fsnotify":
+			source, err := newFsnotifySource()
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("create fsnotify watcher: %w", err)
+			}
+			w.source = source
+		case "fanotify":
+			source, err := NewFanotifySource(w.rootDir)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("create fanotify watcher: %w", err)
+			}
+			w.source = source
+		case "fsevents":
+			source, err := NewFSEventsSource(w.rootDir)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("create FSEvents watcher: %w", err)
+			}
+			w.source = source
+		case "readdirchanges":
+			source, err := NewReadDirChangesSource(w.rootDir)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("create ReadDirectoryChangesW watcher: %w", err)
+			}
+			w.source = source
+		case "poll":
+			source, err := NewPollSource(w.rootDir, w.pollInterval)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("create poll watcher: %w", err)
+			}
+			w.source = source
+		default:
+			cancel()
+			return nil, fmt.Errorf("unknown watcher backend %q", w.backend)
+		}
+	}
+
+	if w.journalPath != "" {
+		journal, err := OpenJournal(w.journalPath)
+		if err != nil {
+			cancel()
+			w.source.Close()
+			return nil, err
+		}
+		w.journal = journal
+	}
+
 	return w, nil
 }
 
@@ -179,8 +414,26 @@ func (w *Watcher) Start() error {
 	w.running = true
 	w.runMu.Unlock()
 
+	// Before the replay, so the sinks get its events too
+	w.startSinks()
+
+	// Write what the previous run accepted but never flushed
+	if w.journal != nil {
+		w.journal.mu.Lock()
+		err := w.replayJournal()
+		w.journal.mu.Unlock()
+		if err != nil {
+			w.unsubscribeSinks()
+			w.runMu.Lock()
+			w.running = false
+			w.runMu.Unlock()
+			return err
+		}
+	}
+
 	// Watch the entire directory tree
 	if err := w.watchTree(w.rootDir); err != nil {
+		w.unsubscribeSinks()
 		w.runMu.Lock()
 		w.running = false
 		w.runMu.Unlock()
@@ -200,6 +453,12 @@ func (w *Watcher) Start() error {
 
 // Stop stops the watcher gracefully.
 func (w *Watcher) Stop() error {
+	return w.StopContext(context.Background())
+}
+
+// StopContext is like Stop, but stops waiting for the lock to write the
+// remaining events when ctx is done.
+func (w *Watcher) StopContext(ctx context.Context) error {
 	w.runMu.Lock()
 	if !w.running {
 		w.runMu.Unlock()
@@ -210,28 +469,49 @@ func (w *Watcher) Stop() error {
 	// Signal shutdown
 	w.cancel()
 
-	// Close fsnotify watcher (will cause eventLoop to exit)
-	if err := w.fsw.Close(); err != nil {
-		return fmt.Errorf("close fsnotify: %w", err)
+	// Close event source (will cause eventLoop to exit)
+	if err := w.source.Close(); err != nil {
+		return fmt.Errorf("close event source: %w", err)
 	}
 
 	// Wait for goroutines to finish
 	w.wg.Wait()
 
-	// Flush any remaining events
-	w.flushBatch()
+	// Flush any remaining events, including those held for their path to
+	// settle and those kept in memory by deferred writes
+	w.release(time.Now())
+	w.flush(ctx)
+	flushErr := w.recent.FlushContext(ctx)
+	sinkErr := w.stopSinks()
+
+	if w.journal != nil {
+		if err := w.journal.Close(); err != nil {
+			return fmt.Errorf("close journal: %w", err)
+		}
+	}
 
 	w.runMu.Lock()
 	w.running = false
 	w.runMu.Unlock()
 
-	return nil
+	if flushErr != nil {
+		return fmt.Errorf("write deferred events: %w", flushErr)
+	}
+	return sinkErr
 }
 
-// watchTree recursively watches all directories.
+// watchTree recursively watches all directories. Sources covering the
+// whole tree need no per-directory watches, but the directories are still
+// remembered, for expanding their deletes (see expandDirDeletes).
 func (w *Watcher) watchTree(root string) error {
+	ts, ok := w.source.(treeSource)
+	tree := ok && ts.WatchesTree()
+
 	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if tree {
+				return nil // nothing to watch, only to remember
+			}
 			return err
 		}
 
@@ -249,14 +529,26 @@ func (w *Watcher) watchTree(root string) error {
 			return filepath.SkipDir // Don't follow symlinks
 		}
 
+		// Nothing below an ignored directory is recorded
+		if w.filter.Load().IgnoredDir(w.relPath(path)) {
+			return filepath.SkipDir
+		}
+
+		if tree {
+			w.addDir(path)
+			return nil
+		}
+
 		// Add watch
-		if err := w.fsw.Add(path); err != nil {
+		if err := w.source.Add(path); err != nil {
 			if w.verbose {
 				fmt.Fprintf(os.Stderr, "warn: failed to watch %s: %v\n", path, err)
 			}
 			return nil // Continue anyway
 		}
 
+		w.addDir(path)
+
 		if w.verbose {
 			fmt.Printf("Watching: %s\n", path)
 		}
@@ -273,7 +565,7 @@ func (w *Watcher) eventLoop() {
 
 	for {
 		select {
-		case event, ok := <-w.fsw.Events:
+		case event, ok := <-w.source.Events():
 			if !ok {
 				return // Channel closed, watcher stopped
 			}
@@ -283,7 +575,7 @@ func (w *Watcher) eventLoop() {
 			draining := true
 			for draining && len(events) < 100000 { // Safety limit
 				select {
-				case e, ok := <-w.fsw.Events:
+				case e, ok := <-w.source.Events():
 					if !ok {
 						// Process what we have and exit
 						w.handleEvents(events)
@@ -298,12 +590,12 @@ func (w *Watcher) eventLoop() {
 			// Process all drained events together
 			w.handleEvents(events)
 
-		case err, ok := <-w.fsw.Errors:
+		case err, ok := <-w.source.Errors():
 			if !ok {
 				return // Channel closed
 			}
 			if w.errorHandler != nil {
-				w.errorHandler(fmt.Errorf("fsnotify error: %w", err))
+				w.errorHandler(fmt.Errorf("event source error: %w", err))
 			}
 
 		case <-w.ctx.Done():
@@ -312,6 +604,50 @@ func (w *Watcher) eventLoop() {
 	}
 }
 
+// relPath returns path relative to the root, slash-separated.
+func (w *Watcher) relPath(path string) string {
+	rel, err := filepath.Rel(w.rootDir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isRecentFile reports whether path belongs to a RECENT hierarchy's own
+// bookkeeping rather than to the mirrored content.
+func (w *Watcher) isRecentFile(path string) bool {
+	return w.ignoredRx.MatchString(w.relPath(path))
+}
+
+// isFiltered reports whether the ignore and include patterns exclude path.
+// Watched directories are only excluded by ignore patterns, so removing
+// one still deletes the included files below it.
+func (w *Watcher) isFiltered(path string) bool {
+	filter := w.filter.Load()
+	if filter == nil {
+		return false
+	}
+
+	w.dirsMu.Lock()
+	watched := w.dirs[path]
+	w.dirsMu.Unlock()
+
+	if watched {
+		return filter.IgnoredDir(w.relPath(path))
+	}
+	return filter.Ignored(w.relPath(path))
+}
+
+// ignoresChmod reports whether chmod-only events are skipped: with
+// WithIgnoreChmod, unless events record file modes and owners.
+func (w *Watcher) ignoresChmod() bool {
+	if !w.ignoreChmod.Load() {
+		return false
+	}
+	rf := w.recent.PrincipalRecentfile()
+	return rf == nil || !rf.ProtocolExt()
+}
+
 // handleEvents processes multiple fsnotify events efficiently.
 // This reduces overhead by processing bursts of events together.
 func (w *Watcher) handleEvents(events []fsnotify.Event) {
@@ -324,13 +660,17 @@ func (w *Watcher) handleEvents(events []fsnotify.Event) {
 	for _, event := range events {
 		basename := filepath.Base(event.Name)
 
+		// A rename pairs only with the event right after it
+		rename := w.lastRename
+		w.lastRename = pendingRename{}
+
 		// Filter 1: Skip temporary files
 		if recentfile.ShouldIgnoreFile(basename) {
 			continue
 		}
 
 		// Filter 2: Ignore RECENT files
-		if w.ignoredRx.MatchString(basename) {
+		if w.isRecentFile(event.Name) {
 			continue
 		}
 
@@ -338,41 +678,52 @@ func (w *Watcher) handleEvents(events []fsnotify.Event) {
 		var typ string
 		switch {
 		case event.Op&fsnotify.Create != 0:
-			// If it's a directory, add watch but don't create an entry
-			if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+			// If it's a directory, add watch but don't create an entry,
+			// except for the files of a directory moved here
+			if isDir(event.Name) {
+				moved := w.movedDir(event.Name, rename)
 				if err := w.watchTree(event.Name); err != nil && w.errorHandler != nil {
 					w.errorHandler(fmt.Errorf("watch tree %s: %w", event.Name, err))
 				}
+				items = append(items, w.dirItems(event.Name)...)
+				items = append(items, moved...)
 				continue
 			}
 			typ = "new"
 
 		case event.Op&fsnotify.Write != 0:
 			// Skip directory modifications - we don't track those
-			if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+			if isDir(event.Name) {
 				continue
 			}
 			typ = "new"
 
 		case event.Op&fsnotify.Chmod != 0:
 			// Skip directory permission changes - we don't track those
-			if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+			if isDir(event.Name) || w.ignoresChmod() {
 				continue
 			}
 			typ = "new"
 
 		case event.Op&fsnotify.Remove != 0:
 			// For removes, we can't stat since the path is gone
-			// Could be a file or directory - add entry either way
+			// Could be a file or directory - add entry either way;
+			// directories are expanded to their files when flushed
 			typ = "delete"
 
 		case event.Op&fsnotify.Rename != 0:
 			typ = "delete" // Source of rename
+			w.lastRename = pendingRename{path: event.Name, at: time.Now()}
 
 		default:
 			continue // Ignore unknown events
 		}
 
+		// Filter 3: Operator-supplied ignore and include patterns
+		if w.isFiltered(event.Name) {
+			continue
+		}
+
 		if w.verbose {
 			fmt.Printf("Event: %s %s\n", typ, event.Name)
 		}
@@ -380,12 +731,54 @@ func (w *Watcher) handleEvents(events []fsnotify.Event) {
 		items = append(items, batchItem{path: event.Name, typ: typ})
 	}
 
-	// Send all items to batch channel
+	w.enqueue(coalesce(items))
+}
+
+// coalesce keeps the last item for each path of a burst of events, in the
+// order of their last occurrence as deduplicateBatch does for the batch,
+// so a file copied in, a create, writes and a chmod, is queued once.
+func coalesce(items []batchItem) []batchItem {
+	if len(items) <= 1 {
+		return items
+	}
+
+	last := make(map[string]int, len(items))
+	for i, item := range items {
+		last[item.path] = i
+	}
+	if len(last) == len(items) {
+		return items
+	}
+
+	n := 0
+	for i, item := range items {
+		if last[item.path] == i {
+			items[n] = item
+			n++
+		}
+	}
+	return items[:n]
+}
+
+// enqueue journals items and sends them to the batch channel.
+func (w *Watcher) enqueue(items []batchItem) {
+	if w.journal != nil {
+		w.journal.mu.Lock()
+		defer w.journal.mu.Unlock()
+		if err := w.journal.append(items); err != nil && w.errorHandler != nil {
+			w.errorHandler(err)
+		}
+	}
+
 	for _, item := range items {
 		select {
 		case w.batchChan <- item:
 		default:
-			// Channel full, drop event
+			// Channel full, drop event; the journal (if any) still has it
+			w.dropped.Add(1)
+			if w.journal != nil {
+				w.journal.dirty = true
+			}
 			if w.errorHandler != nil {
 				w.errorHandler(fmt.Errorf("batch channel full, dropping event: %s", item.path))
 			}
@@ -393,10 +786,22 @@ func (w *Watcher) handleEvents(events []fsnotify.Event) {
 	}
 }
 
+// isDir reports whether path is a directory. Symlinks are not followed:
+// a symlink, even one to a directory, is recorded like a file, so creating,
+// retargeting or removing it is an event of its own.
+func isDir(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.IsDir()
+}
+
 // handleEvent processes a single fsnotify event.
 func (w *Watcher) handleEvent(event fsnotify.Event) {
 	basename := filepath.Base(event.Name)
 
+	// A rename pairs only with the event right after it
+	rename := w.lastRename
+	w.lastRename = pendingRename{}
+
 	// Filter 1: Skip temporary files
 	// These are created during atomic writes and symlink operations
 	if recentfile.ShouldIgnoreFile(basename) {
@@ -404,7 +809,7 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	}
 
 	// Filter 2: Ignore RECENT files
-	if w.ignoredRx.MatchString(basename) {
+	if w.isRecentFile(event.Name) {
 		return
 	}
 
@@ -412,72 +817,96 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	var typ string
 	switch {
 	case event.Op&fsnotify.Create != 0:
-		// If it's a directory, add watch but don't create an entry
-		if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+		// If it's a directory, add watch but don't create an entry,
+		// except for the files of a directory moved here
+		if isDir(event.Name) {
+			moved := w.movedDir(event.Name, rename)
 			if err := w.watchTree(event.Name); err != nil && w.errorHandler != nil {
 				w.errorHandler(fmt.Errorf("watch tree %s: %w", event.Name, err))
 			}
+			w.enqueue(append(w.dirItems(event.Name), moved...))
 			return
 		}
 		typ = "new"
 
 	case event.Op&fsnotify.Write != 0:
 		// Skip directory modifications - we don't track those
-		if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+		if isDir(event.Name) {
 			return
 		}
 		typ = "new"
 
 	case event.Op&fsnotify.Chmod != 0:
 		// Skip directory permission changes - we don't track those
-		if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+		if isDir(event.Name) || w.ignoresChmod() {
 			return
 		}
 		typ = "new"
 
 	case event.Op&fsnotify.Remove != 0:
 		// For removes, we can't stat since the path is gone
-		// Could be a file or directory - add entry either way
+		// Could be a file or directory - add entry either way;
+		// directories are expanded to their files when flushed
 		typ = "delete"
 
 	case event.Op&fsnotify.Rename != 0:
 		typ = "delete" // Source of rename
+		w.lastRename = pendingRename{path: event.Name, at: time.Now()}
 
 	default:
 		return // Ignore unknown events
 	}
 
+	// Filter 3: Operator-supplied ignore and include patterns
+	if w.isFiltered(event.Name) {
+		return
+	}
+
 	if w.verbose {
 		fmt.Printf("Event: %s %s\n", typ, event.Name)
 	}
 
-	// Send to batch channel
-	select {
-	case w.batchChan <- batchItem{path: event.Name, typ: typ}:
-	default:
-		// Channel full, drop event (or could flush immediately)
-		if w.errorHandler != nil {
-			w.errorHandler(fmt.Errorf("batch channel full, dropping event: %s", event.Name))
-		}
-	}
+	w.enqueue([]batchItem{{path: event.Name, typ: typ}})
 }
 
 // batchProcessor accumulates events and flushes periodically.
 func (w *Watcher) batchProcessor() {
 	defer w.wg.Done()
 
+	w.configMu.Lock()
+	batchSize, batchDelay, quietPeriod := w.batchSize, w.batchDelay, w.quietPeriod
+	aggregateInterval, rescanInterval := w.aggregateInterval, w.rescanInterval
+	w.configMu.Unlock()
+
 	// Create timer for batch flushing
-	flushTimer := time.NewTimer(w.batchDelay)
+	flushTimer := time.NewTimer(batchDelay)
 	defer flushTimer.Stop()
 
 	// Create timer for aggregation (if enabled)
 	var aggregateTimer *time.Timer
 	var aggregateChan <-chan time.Time
-	if w.aggregateInterval > 0 {
-		aggregateTimer = time.NewTimer(w.aggregateInterval)
+	if aggregateInterval > 0 {
+		aggregateTimer = time.NewTimer(aggregateInterval)
 		aggregateChan = aggregateTimer.C
-		defer aggregateTimer.Stop()
 	}
+	defer func() {
+		if aggregateTimer != nil {
+			aggregateTimer.Stop()
+		}
+	}()
+
+	// Create ticker for rescans (if enabled)
+	var rescanTicker *time.Ticker
+	var rescanChan <-chan time.Time
+	if rescanInterval > 0 {
+		rescanTicker = time.NewTicker(rescanInterval)
+		rescanChan = rescanTicker.C
+	}
+	defer func() {
+		if rescanTicker != nil {
+			rescanTicker.Stop()
+		}
+	}()
 
 	for {
 		select {
@@ -487,17 +916,19 @@ func (w *Watcher) batchProcessor() {
 			}
 
 			w.batchMu.Lock()
-			w.batch = append(w.batch, recentfile.BatchItem{
+			w.add(recentfile.BatchItem{
 				Path: item.path,
 				Type: item.typ,
-			})
+				Dir:  item.dir,
+			}, quietPeriod)
 
 			// Check if batch is full
-			needFlush := len(w.batch) >= w.batchSize
+			needFlush := len(w.batch) >= batchSize
 			w.batchMu.Unlock()
 
 			if needFlush {
 				w.flushBatch()
+				w.aggregateOverflow()
 				// Reset flush timer after flushing
 				if !flushTimer.Stop() {
 					select {
@@ -505,39 +936,128 @@ func (w *Watcher) batchProcessor() {
 					default:
 					}
 				}
-				flushTimer.Reset(w.batchDelay)
+				flushTimer.Reset(batchDelay)
 			}
 
 		case <-flushTimer.C:
+			w.release(time.Now().Add(-quietPeriod))
 			w.flushBatch()
-			flushTimer.Reset(w.batchDelay)
+			w.flushDeferred()
+			w.aggregateOverflow()
+			flushTimer.Reset(batchDelay)
 
 		case <-aggregateChan:
 			if w.verbose {
 				fmt.Println("Running periodic aggregation")
 			}
-			start := time.Now()
-			if err := w.recent.Aggregate(false); err != nil {
-				if w.errorHandler != nil {
-					w.errorHandler(fmt.Errorf("aggregation error: %w", err))
+			w.aggregate()
+			aggregateTimer.Reset(aggregateInterval)
+
+		case <-rescanChan:
+			if w.verbose {
+				fmt.Println("Running periodic rescan")
+			}
+			if _, err := w.Rescan(); err != nil && w.errorHandler != nil {
+				w.errorHandler(fmt.Errorf("rescan error: %w", err))
+			}
+
+		case <-w.reconfigured:
+			w.configMu.Lock()
+			batchSize, batchDelay, quietPeriod = w.batchSize, w.batchDelay, w.quietPeriod
+			newAggregate, newRescan := w.aggregateInterval, w.rescanInterval
+			w.configMu.Unlock()
+
+			// Queued events stay in the batch; a smaller size or delay
+			// applies from the next event or tick
+			if !flushTimer.Stop() {
+				select {
+				case <-flushTimer.C:
+				default:
+				}
+			}
+			flushTimer.Reset(batchDelay)
+
+			if newAggregate != aggregateInterval {
+				aggregateInterval = newAggregate
+				if aggregateTimer != nil {
+					aggregateTimer.Stop()
+					aggregateTimer, aggregateChan = nil, nil
+				}
+				if aggregateInterval > 0 {
+					aggregateTimer = time.NewTimer(aggregateInterval)
+					aggregateChan = aggregateTimer.C
+				}
+			}
+			if newRescan != rescanInterval {
+				rescanInterval = newRescan
+				if rescanTicker != nil {
+					rescanTicker.Stop()
+					rescanTicker, rescanChan = nil, nil
 				}
-			} else {
-				duration := time.Since(start)
-				if w.aggregationCallback != nil {
-					w.aggregationCallback(duration)
+				if rescanInterval > 0 {
+					rescanTicker = time.NewTicker(rescanInterval)
+					rescanChan = rescanTicker.C
 				}
 			}
-			aggregateTimer.Reset(w.aggregateInterval)
 
 		case <-w.ctx.Done():
-			w.flushBatch()
+			// Stop writes what is left
 			return
 		}
 	}
 }
 
+// Reconfigure changes the settings of a running watcher that can change
+// without restarting it: the ignore and include patterns (replaced by
+// those given, so pass all of them), batch size and delay, quiet period,
+// skipping chmod-only events, and the aggregation and rescan intervals.
+// Other options are ignored. Queued events are kept.
+func (w *Watcher) Reconfigure(opts ...Option) error {
+	w.configMu.Lock()
+	next := &Watcher{
+		batchSize:         w.batchSize,
+		batchDelay:        w.batchDelay,
+		quietPeriod:       w.quietPeriod,
+		aggregateInterval: w.aggregateInterval,
+		rescanInterval:    w.rescanInterval,
+	}
+	next.ignoreChmod.Store(w.ignoreChmod.Load())
+	w.configMu.Unlock()
+
+	for _, opt := range opts {
+		opt(next)
+	}
+	filter, err := pathfilter.New(next.ignorePatterns, next.includePatterns)
+	if err != nil {
+		return err
+	}
+
+	w.configMu.Lock()
+	w.ignorePatterns = next.ignorePatterns
+	w.includePatterns = next.includePatterns
+	w.batchSize = next.batchSize
+	w.batchDelay = next.batchDelay
+	w.quietPeriod = next.quietPeriod
+	w.aggregateInterval = next.aggregateInterval
+	w.rescanInterval = next.rescanInterval
+	w.configMu.Unlock()
+	w.filter.Store(filter)
+	w.ignoreChmod.Store(next.ignoreChmod.Load())
+
+	select {
+	case w.reconfigured <- struct{}{}:
+	default: // already pending
+	}
+	return nil
+}
+
 // flushBatch writes accumulated events to the Recent collection.
 func (w *Watcher) flushBatch() {
+	w.flush(w.ctx)
+}
+
+// flush is flushBatch, waiting for the lock until ctx is done.
+func (w *Watcher) flush(ctx context.Context) {
 	w.batchMu.Lock()
 	if len(w.batch) == 0 {
 		w.batchMu.Unlock()
@@ -548,57 +1068,159 @@ func (w *Watcher) flushBatch() {
 	w.batch = nil
 	w.batchMu.Unlock()
 
+	start := time.Now()
 	if w.verbose {
 		fmt.Printf("Flushing batch: %d events\n", len(batch))
 	}
 
+	spanCtx, span := tracer.Start(ctx, "flushBatch", trace.WithAttributes(attribute.Int("events", len(batch))))
+	defer span.End()
+
+	// Removed directories stand for all the files below them
+	batch = w.expandDirDeletes(batch)
+
 	// Deduplicate events (keep last event for each path)
 	deduped := w.deduplicateBatch(batch)
+	span.SetAttributes(attribute.Int("deduplicated_events", len(deduped)))
 
 	// Update the recent collection
-	if err := w.recent.BatchUpdate(deduped); err != nil {
+	if err := w.recent.BatchUpdateContext(spanCtx, deduped); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if ctx == w.ctx && ctx.Err() != nil {
+			// Stopping; put the events back for Stop to write
+			w.batchMu.Lock()
+			w.batch = append(deduped, w.batch...)
+			w.batchMu.Unlock()
+			return
+		}
 		if w.errorHandler != nil {
 			w.errorHandler(fmt.Errorf("batch update failed: %w", err))
 		}
+		if w.journal != nil {
+			// Retried from the journal
+			w.journal.mu.Lock()
+			w.journal.dirty = true
+			w.journal.mu.Unlock()
+			w.checkpointJournal()
+		}
 		return // Don't call event callback on error
 	}
 
-	// Call event callback if registered
-	if w.eventCallback != nil {
-		// Count events by type
-		counts := make(map[string]int)
-		for _, item := range deduped {
-			counts[item.Type]++
-		}
-
-		for eventType, count := range counts {
-			w.eventCallback(eventType, count)
-		}
+	w.countEvents(deduped)
+	w.batched.Add(int64(len(batch)))
+	w.written.Add(int64(len(deduped)))
+	if w.flushCallback != nil {
+		w.flushCallback(len(deduped), time.Since(start))
 	}
 
 	// Update last flush time
 	w.lastFlushMu.Lock()
 	w.lastFlush = time.Now()
 	w.lastFlushMu.Unlock()
+
+	if w.journal != nil {
+		w.checkpointJournal()
+	}
+}
+
+// aggregate runs an aggregation, reporting it to the callbacks. While
+// another process holds locks of the hierarchy the aggregation is skipped
+// until the next one is due, rather than waiting for them here, where no
+// batches are written meanwhile.
+func (w *Watcher) aggregate() {
+	held, err := w.recent.LocksHeld()
+	if err != nil && w.errorHandler != nil {
+		w.errorHandler(fmt.Errorf("check locks: %w", err))
+	}
+	if len(held) > 0 {
+		if w.verbose {
+			fmt.Printf("Skipping aggregation, locked elsewhere: %s\n", strings.Join(held, ", "))
+		}
+		if w.aggregationSkipCallback != nil {
+			w.aggregationSkipCallback(held)
+		}
+		return
+	}
+
+	start := time.Now()
+	if err := w.recent.AggregateContext(w.ctx, false); err != nil {
+		if w.errorHandler != nil {
+			w.errorHandler(fmt.Errorf("aggregation error: %w", err))
+		}
+		return
+	}
+	if w.aggregationCallback != nil {
+		w.aggregationCallback(time.Since(start))
+	}
+}
+
+// aggregateOverflow aggregates right away when the principal holds more
+// events than its cap (see recentfile.WithMaxEvents), rather than waiting
+// for the next periodic aggregation.
+func (w *Watcher) aggregateOverflow() {
+	if !w.recent.PrincipalRecentfile().Overflowing() {
+		return
+	}
+	if w.verbose {
+		fmt.Println("Running aggregation, principal over its event cap")
+	}
+	w.aggregate()
+}
+
+// flushDeferred writes the events the principal keeps in memory with
+// deferred writes once they are due (see recent.Recent.SetDeferredWrites).
+func (w *Watcher) flushDeferred() {
+	if !w.recent.FlushDue() {
+		return
+	}
+	if err := w.recent.FlushContext(w.ctx); err != nil {
+		if w.ctx.Err() == nil && w.errorHandler != nil {
+			w.errorHandler(fmt.Errorf("write deferred events: %w", err))
+		}
+		return
+	}
+	if w.journal != nil {
+		w.checkpointJournal()
+	}
+}
+
+// countEvents calls the event callback, if registered, once per event type.
+func (w *Watcher) countEvents(items []recentfile.BatchItem) {
+	if w.eventCallback == nil {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[item.Type]++
+	}
+
+	for eventType, count := range counts {
+		w.eventCallback(eventType, count)
+	}
 }
 
-// deduplicateBatch removes duplicate paths, keeping the last event for each path.
+// deduplicateBatch removes duplicate paths, keeping the last event for each
+// path. Events stay in the order of their last occurrence, so the epochs
+// assigned to them follow the order of the changes (the delete of a
+// rename's source before the new of its target).
 func (w *Watcher) deduplicateBatch(batch []recentfile.BatchItem) []recentfile.BatchItem {
 	if len(batch) <= 1 {
 		return batch
 	}
 
-	// Use map to track last event for each path
-	eventMap := make(map[string]recentfile.BatchItem)
-
-	for _, item := range batch {
-		eventMap[item.Path] = item // Overwrites previous event for same path
+	// Index of the last event for each path
+	last := make(map[string]int, len(batch))
+	for i, item := range batch {
+		last[item.Path] = i
 	}
 
-	// Convert back to slice
-	result := make([]recentfile.BatchItem, 0, len(eventMap))
-	for _, item := range eventMap {
-		result = append(result, item)
+	result := make([]recentfile.BatchItem, 0, len(last))
+	for i, item := range batch {
+		if last[item.Path] == i {
+			result = append(result, item)
+		}
 	}
 
 	if w.verbose && len(result) < len(batch) {
@@ -611,25 +1233,41 @@ func (w *Watcher) deduplicateBatch(batch []recentfile.BatchItem) []recentfile.Ba
 // Stats returns statistics about the watcher.
 func (w *Watcher) Stats() Stats {
 	w.batchMu.Lock()
-	currentBatchSize := len(w.batch)
+	currentBatchSize, held := len(w.batch), len(w.held)
 	w.batchMu.Unlock()
 
 	w.lastFlushMu.Lock()
 	timeSinceFlush := time.Since(w.lastFlush)
 	w.lastFlushMu.Unlock()
 
+	w.dirsMu.Lock()
+	watchedDirs := len(w.dirs)
+	w.dirsMu.Unlock()
+
 	return Stats{
 		QueuedEvents:   len(w.batchChan),
 		BatchSize:      currentBatchSize,
+		HeldEvents:     held,
 		TimeSinceFlush: timeSinceFlush,
+		DroppedEvents:  w.dropped.Load(),
+		WatchedDirs:    watchedDirs,
+		BatchedEvents:  w.batched.Load(),
+		WrittenEvents:  w.written.Load(),
 	}
 }
 
-// Stats represents watcher statistics.
+// Stats represents watcher statistics. The event totals count from the
+// start of the watcher; BatchedEvents - WrittenEvents were folded into a
+// later event for the same path by deduplication.
 type Stats struct {
 	QueuedEvents   int           // Events in channel
 	BatchSize      int           // Events in current batch
+	HeldEvents     int           // Events waiting for their path to settle (see WithQuietPeriod)
 	TimeSinceFlush time.Duration // Time since last flush
+	DroppedEvents  int64         // Events dropped because the channel was full
+	WatchedDirs    int           // Directories being watched
+	BatchedEvents  int64         // Events flushed, before deduplication
+	WrittenEvents  int64         // Events written, after deduplication
 }
 
 // IsRunning returns true if the watcher is running.
@@ -1,11 +1,22 @@
 package watcher
 
 import (
+	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
 	"github.com/abh/rrrgo/recent"
 	"github.com/abh/rrrgo/recentfile"
 )
@@ -239,9 +250,19 @@ func TestIgnoreRECENTFiles(t *testing.T) {
 	recentFiles := []string{
 		"RECENT-1h.yaml",
 		"RECENT-6h.yaml",
+		"RECENT-1d12h.yaml",
 		"RECENT-1h.yaml.lock",
 		"RECENT-1h.yaml.new",
+		"RECENT-1h.yaml.minisig",
+		"RECENT-1h.yaml.minisig.new",
+		"RECENT-1h.yaml.4711.8d6c0f3e.new",
+		"RECENT-1h.yaml.minisig.4711.8d6c0f3e.new",
 		"RECENT.recent",
+		"RECENT.recent.minisig",
+		"RECENT-1h.json.bak",
+		"RECENT-1h.json.minisig.bak",
+		"RECENT-1h.yaml.1",
+		"RECENT-6h.yaml.minisig.12",
 	}
 
 	for _, name := range recentFiles {
@@ -263,6 +284,37 @@ func TestIgnoreRECENTFiles(t *testing.T) {
 	}
 }
 
+func TestNestedHierarchyRECENTFiles(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	w, _ := New(rec)
+	w.Start()
+	defer w.Stop()
+
+	subDir := filepath.Join(tmpDir, "modules")
+	os.Mkdir(subDir, 0o755)
+	time.Sleep(100 * time.Millisecond)
+
+	// A nested hierarchy's recentfiles are content; its temp files are not
+	files := []string{
+		"RECENT-1h.yaml",
+		"RECENT-1h.yaml.new",
+		"RECENT-1h.yaml.minisig.new",
+		"RECENT-1h.yaml.4711.8d6c0f3e.new",
+	}
+	for _, name := range files {
+		os.WriteFile(filepath.Join(subDir, name), []byte("test"), 0o644)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	events := rec.PrincipalRecentfile().RecentEvents()
+	if len(events) != 1 || events[0].Path != "modules/RECENT-1h.yaml" {
+		t.Errorf("Expected only modules/RECENT-1h.yaml, got %+v", events)
+	}
+}
+
 func TestBatchDeduplication(t *testing.T) {
 	rec, tmpDir := setupTestRecent(t)
 
@@ -341,6 +393,74 @@ func TestBatchDelayFlush(t *testing.T) {
 	}
 }
 
+func TestAggregateOverflow(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	rec.SetMaxEvents(map[string]int{"1h": 2})
+
+	var aggregations atomic.Int32
+	w, _ := New(rec,
+		WithBatchSize(1000),
+		WithBatchDelay(100*time.Millisecond),
+		WithAggregateInterval(time.Hour),
+		WithAggregationCallback(func(time.Duration) { aggregations.Add(1) }))
+	w.Start()
+	defer w.Stop()
+
+	// Two events fit, the third one overflows
+	for i, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0o644)
+		time.Sleep(300 * time.Millisecond)
+		if want := int32(max(0, i-1)); aggregations.Load() != want {
+			t.Errorf("%d aggregations after %s, want %d", aggregations.Load(), name, want)
+		}
+	}
+
+	sixHours, err := recentfile.NewFromFile(filepath.Join(tmpDir, "RECENT-6h.yaml"))
+	if err != nil {
+		t.Fatalf("6h not aggregated: %v", err)
+	}
+	if got := len(sixHours.RecentEvents()); got != 3 {
+		t.Errorf("6h has %d events, want 3", got)
+	}
+	if rec.PrincipalRecentfile().Overflowing() {
+		t.Error("principal still overflowing")
+	}
+}
+
+func TestAggregateSkipsLocked(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	if err := rec.EnsureFilesExist(); err != nil {
+		t.Fatal(err)
+	}
+
+	var aggregations atomic.Int32
+	var skipped []string
+	w, _ := New(rec,
+		WithAggregationCallback(func(time.Duration) { aggregations.Add(1) }),
+		WithAggregationSkipCallback(func(held []string) { skipped = held }))
+
+	// An aggregation run from cron holds the 6h file
+	other := recentfile.New(recentfile.WithLocalRoot(tmpDir), recentfile.WithInterval("6h"))
+	if err := other.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	w.aggregate()
+	if aggregations.Load() != 0 || !slices.Equal(skipped, []string{"6h"}) {
+		t.Errorf("locked: %d aggregations, skipped for %v", aggregations.Load(), skipped)
+	}
+	for _, rf := range rec.Recentfiles() {
+		if rf.Locked() {
+			t.Errorf("%s left locked", rf.Interval())
+		}
+	}
+
+	other.Unlock()
+	w.aggregate()
+	if aggregations.Load() != 1 {
+		t.Errorf("unlocked: %d aggregations, want 1", aggregations.Load())
+	}
+}
+
 func TestStats(t *testing.T) {
 	rec, _ := setupTestRecent(t)
 
@@ -365,6 +485,50 @@ func TestStats(t *testing.T) {
 	}
 }
 
+func TestStatsTotals(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	os.Mkdir(filepath.Join(tmpDir, "sub"), 0o755)
+
+	var flushed []int
+	w, _ := New(rec, WithFlushCallback(func(events int, duration time.Duration) {
+		flushed = append(flushed, events)
+	}))
+	defer w.source.Close()
+	w.batchChan = make(chan batchItem, 3)
+
+	if err := w.watchTree(tmpDir); err != nil {
+		t.Fatalf("watchTree: %v", err)
+	}
+
+	path := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(path, []byte("a"), 0o644)
+	w.enqueue([]batchItem{
+		{path: path, typ: "new"},
+		{path: path, typ: "new"},
+		{path: path, typ: "new"},
+		{path: path, typ: "new"}, // dropped
+	})
+	for range 3 {
+		item := <-w.batchChan
+		w.batch = append(w.batch, recentfile.BatchItem{Path: item.path, Type: item.typ})
+	}
+	w.flushBatch()
+
+	stats := w.Stats()
+	if stats.DroppedEvents != 1 {
+		t.Errorf("DroppedEvents = %d, want 1", stats.DroppedEvents)
+	}
+	if stats.WatchedDirs != 2 {
+		t.Errorf("WatchedDirs = %d, want 2", stats.WatchedDirs)
+	}
+	if stats.BatchedEvents != 3 || stats.WrittenEvents != 1 {
+		t.Errorf("BatchedEvents = %d, WrittenEvents = %d, want 3 and 1", stats.BatchedEvents, stats.WrittenEvents)
+	}
+	if len(flushed) != 1 || flushed[0] != 1 {
+		t.Errorf("flush callback got %v", flushed)
+	}
+}
+
 func TestWithOptions(t *testing.T) {
 	rec, _ := setupTestRecent(t)
 
@@ -438,6 +602,82 @@ func TestDeduplicateBatch(t *testing.T) {
 	}
 }
 
+func TestCoalesceBurst(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	w, err := New(rec)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.source.Close()
+
+	// A copy of a.txt, b.txt created and removed again, and c.txt
+	a, b, c := filepath.Join(tmpDir, "a.txt"), filepath.Join(tmpDir, "b.txt"), filepath.Join(tmpDir, "c.txt")
+	w.handleEvents([]fsnotify.Event{
+		{Name: a, Op: fsnotify.Create},
+		{Name: b, Op: fsnotify.Create},
+		{Name: a, Op: fsnotify.Write},
+		{Name: a, Op: fsnotify.Write},
+		{Name: c, Op: fsnotify.Create},
+		{Name: b, Op: fsnotify.Remove},
+		{Name: a, Op: fsnotify.Chmod},
+	})
+
+	var got []batchItem
+	for len(w.batchChan) > 0 {
+		got = append(got, <-w.batchChan)
+	}
+	want := []batchItem{{path: c, typ: "new"}, {path: b, typ: "delete"}, {path: a, typ: "new"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("queued %+v, want %+v", got, want)
+	}
+}
+
+func TestIgnoreChmod(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	path := filepath.Join(tmpDir, "a.txt")
+	if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(rec, WithIgnoreChmod(true))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.source.Close()
+
+	chmod := fsnotify.Event{Name: path, Op: fsnotify.Chmod}
+	w.handleEvent(chmod)
+	w.handleEvents([]fsnotify.Event{chmod})
+	if n := len(w.batchChan); n != 0 {
+		t.Errorf("queued %d events for chmod, want 0", n)
+	}
+
+	// A write is still recorded
+	w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Write | fsnotify.Chmod})
+	if n := len(w.batchChan); n != 1 {
+		t.Errorf("queued %d events for write, want 1", n)
+	}
+	<-w.batchChan
+
+	// Events carrying the mode record chmod
+	rec.SetProtocolExt(true)
+	w.handleEvent(chmod)
+	if n := len(w.batchChan); n != 1 {
+		t.Errorf("queued %d events for chmod with protocol extension, want 1", n)
+	}
+	<-w.batchChan
+	rec.SetProtocolExt(false)
+
+	if err := w.Reconfigure(WithIgnoreChmod(false)); err != nil {
+		t.Fatalf("Reconfigure failed: %v", err)
+	}
+	w.handleEvent(chmod)
+	if n := len(w.batchChan); n != 1 {
+		t.Errorf("queued %d events for chmod after reconfigure, want 1", n)
+	}
+}
+
 func TestIsRunning(t *testing.T) {
 	rec, _ := setupTestRecent(t)
 
@@ -511,6 +751,39 @@ func TestStopFlushesRemainingEvents(t *testing.T) {
 	}
 }
 
+func TestStopContext(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	var failed atomic.Bool
+	w, _ := New(rec,
+		WithBatchSize(1000),
+		WithBatchDelay(10*time.Second),
+		WithErrorHandler(func(error) { failed.Store(true) }))
+	w.Start()
+
+	// Another process holds the principal's lock
+	holder := rec.PrincipalRecentfile().SparseClone()
+	holder.SetInterval("1h")
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	defer holder.Unlock()
+
+	os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test"), 0o644)
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	w.StopContext(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("StopContext took %v", elapsed)
+	}
+	if !failed.Load() {
+		t.Error("the unwritten events were not reported")
+	}
+}
+
 func TestSymlinksNotFollowed(t *testing.T) {
 	rec, tmpDir := setupTestRecent(t)
 
@@ -550,6 +823,156 @@ func TestSymlinksNotFollowed(t *testing.T) {
 	}
 }
 
+func TestSymlinkEvents(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	// authors/id/A/AN/ANDK holds the files, with CPAN's shortcut
+	// authors/id/ANDK pointing at it
+	idDir := filepath.Join(tmpDir, "authors", "id")
+	if err := os.MkdirAll(filepath.Join(idDir, "A", "AN", "ANDK"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(idDir, "A", "AN", "ANDREAS"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(idDir, "A", "AN", "ANDK", "CPAN-2.36.tar.gz"), []byte("tarball"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, _ := New(rec)
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	latest := filepath.Join(idDir, "A", "AN", "ANDK", "CPAN-latest.tar.gz")
+	steps := []struct {
+		name string
+		do   func() error
+		path string
+		typ  string
+	}{
+		{"symlink to directory", func() error {
+			return os.Symlink(filepath.Join("A", "AN", "ANDK"), filepath.Join(idDir, "ANDK"))
+		}, "authors/id/ANDK", "new"},
+		{"symlink to file", func() error {
+			return os.Symlink("CPAN-2.36.tar.gz", latest)
+		}, "authors/id/A/AN/ANDK/CPAN-latest.tar.gz", "new"},
+		{"dangling symlink", func() error {
+			return os.Symlink("CPAN-2.37.tar.gz", filepath.Join(idDir, "A", "AN", "ANDK", "CPAN-next.tar.gz"))
+		}, "authors/id/A/AN/ANDK/CPAN-next.tar.gz", "new"},
+		{"retarget directory symlink", func() error {
+			tmp := filepath.Join(idDir, "ANDK.tmp")
+			if err := os.Symlink(filepath.Join("A", "AN", "ANDREAS"), tmp); err != nil {
+				return err
+			}
+			return os.Rename(tmp, filepath.Join(idDir, "ANDK"))
+		}, "authors/id/ANDK", "new"},
+		{"retarget file symlink", func() error {
+			if err := os.Remove(latest); err != nil {
+				return err
+			}
+			return os.Symlink("CPAN-2.37.tar.gz", latest)
+		}, "authors/id/A/AN/ANDK/CPAN-latest.tar.gz", "new"},
+		{"remove directory symlink", func() error {
+			return os.Remove(filepath.Join(idDir, "ANDK"))
+		}, "authors/id/ANDK", "delete"},
+	}
+
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Skipf("%s: %v", step.name, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		w.flushBatch()
+
+		events := rec.PrincipalRecentfile().RecentEvents()
+		if len(events) == 0 || events[0].Path != step.path || events[0].Type != step.typ {
+			t.Fatalf("%s: latest event %+v, want %s %s", step.name, events, step.typ, step.path)
+		}
+	}
+
+	// The link target's directory is not watched twice, nor the link
+	// followed when the target changes
+	if err := os.WriteFile(filepath.Join(idDir, "A", "AN", "ANDREAS", "README"), []byte("readme"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+	for _, e := range rec.PrincipalRecentfile().RecentEvents() {
+		if e.Path == "authors/id/ANDK/README" {
+			t.Errorf("event recorded through the symlink: %+v", e)
+		}
+	}
+}
+
+func TestDirEvents(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	w, _ := New(rec, WithDirEvents(true))
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	events := func() map[string]recentfile.Event {
+		time.Sleep(200 * time.Millisecond)
+		w.flushBatch()
+		got := make(map[string]recentfile.Event)
+		for _, e := range rec.PrincipalRecentfile().RecentEvents() {
+			got[e.Path] = e
+		}
+		return got
+	}
+
+	// The directories below a new one may exist before it is watched
+	if err := os.MkdirAll(filepath.Join(tmpDir, "a", "b", "c"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(tmpDir, "a", "b", "file.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := events()
+	for _, path := range []string{"a", "a/b", "a/b/c"} {
+		if e := got[path]; e.Type != "new" || !e.Dir {
+			t.Errorf("%s = %+v, want a new directory event", path, e)
+		}
+	}
+	if e := got["a/b/file.txt"]; e.Type != "new" || e.Dir {
+		t.Errorf("a/b/file.txt = %+v, want a new file event", e)
+	}
+
+	// Removing a tree deletes its files and directories
+	if err := os.RemoveAll(filepath.Join(tmpDir, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	got = events()
+	for _, path := range []string{"a/b", "a/b/c"} {
+		if e := got[path]; e.Type != "delete" || !e.Dir {
+			t.Errorf("%s = %+v, want a deleted directory event", path, e)
+		}
+	}
+	if e := got["a/b/file.txt"]; e.Type != "delete" || e.Dir {
+		t.Errorf("a/b/file.txt = %+v, want a deleted file event", e)
+	}
+	if e := got["a"]; e.Type != "new" {
+		t.Errorf("a = %+v, want it kept", e)
+	}
+
+	// A rescan leaves recorded directories that exist alone and deletes
+	// those that are gone
+	if err := rec.BatchUpdate([]recentfile.BatchItem{{Path: filepath.Join(tmpDir, "missed"), Type: "new", Dir: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Rescan(); err != nil || n != 1 {
+		t.Errorf("Rescan = %d, %v; want 1", n, err)
+	}
+	if e := events()["missed"]; e.Type != "delete" || !e.Dir {
+		t.Errorf("missed = %+v, want a deleted directory event", e)
+	}
+}
+
 func TestIgnoreTemporaryFiles(t *testing.T) {
 	rec, tmpDir := setupTestRecent(t)
 
@@ -603,3 +1026,314 @@ func TestIgnoreTemporaryFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestFeedSource(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	pr, pw := io.Pipe()
+	feed := NewFeedSource(tmpDir, pr)
+
+	w, err := New(rec, WithEventSource(feed))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// Files must exist for create events to be recorded as "new"
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0o644)
+
+	lines := `{"op":"create","path":"a.txt"}
+not json
+{"op":"remove","path":"` + filepath.ToSlash(filepath.Join(tmpDir, "gone.txt")) + `"}
+`
+	go pw.Write([]byte(lines))
+
+	// The malformed line is reported but doesn't stop the feed
+	select {
+	case err := <-feed.Errors():
+		if err == nil {
+			t.Error("expected parse error")
+		}
+	case <-time.After(time.Second):
+		t.Error("no error reported for malformed line")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	types := map[string]string{}
+	for _, e := range rec.PrincipalRecentfile().RecentEvents() {
+		types[e.Path] = e.Type
+	}
+	if types["a.txt"] != "new" {
+		t.Errorf("a.txt type = %q, want new", types["a.txt"])
+	}
+	if types["gone.txt"] != "delete" {
+		t.Errorf("gone.txt type = %q, want delete", types["gone.txt"])
+	}
+}
+
+func TestFeedSourceWatchesTree(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	pr, _ := io.Pipe()
+	feed := NewFeedSource(tmpDir, pr)
+
+	w, _ := New(rec, WithEventSource(feed))
+
+	// watchTree must not fail on the tree for feed sources
+	if err := w.watchTree(filepath.Join(tmpDir, "does-not-exist")); err != nil {
+		t.Errorf("watchTree with feed source: %v", err)
+	}
+
+	if err := feed.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestDirectoryRemovalDeletesChildren(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	subDir := filepath.Join(tmpDir, "subdir")
+	os.MkdirAll(filepath.Join(subDir, "nested"), 0o755)
+	for _, name := range []string{"a.txt", "nested/b.txt"} {
+		path := filepath.Join(subDir, name)
+		os.WriteFile(path, []byte(name), 0o644)
+		if err := rec.Update(path, "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(tmpDir, "subdirectory.txt"), []byte("x"), 0o644)
+	rec.Update(filepath.Join(tmpDir, "subdirectory.txt"), "new")
+
+	w, _ := New(rec)
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Created but not flushed yet when the directory goes away
+	os.WriteFile(filepath.Join(subDir, "c.txt"), []byte("c"), 0o644)
+	time.Sleep(100 * time.Millisecond)
+
+	// Moving the directory out of the tree reports only the directory
+	if err := os.Rename(subDir, filepath.Join(t.TempDir(), "moved")); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	types := eventTypes(w)
+	for path, want := range map[string]string{
+		"subdir/a.txt":        "delete",
+		"subdir/nested/b.txt": "delete",
+		"subdir/c.txt":        "delete",
+		"subdirectory.txt":    "new",
+	} {
+		if types[path] != want {
+			t.Errorf("%s type = %q, want %q", path, types[path], want)
+		}
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	os.MkdirAll(filepath.Join(tmpDir, ".git"), 0o755)
+
+	w, err := New(rec, WithIgnorePatterns(".git", "*.o"), WithIncludePatterns("*.txt", "*.o"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, name := range []string{".git/HEAD.txt", "main.o", "notes.md", "keep.txt"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0o644)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	w.flushBatch()
+
+	types := eventTypes(w)
+	if len(types) != 1 || types["keep.txt"] != "new" {
+		t.Errorf("recorded %v, want only keep.txt", types)
+	}
+
+	if _, err := New(rec, WithIgnorePatterns("[")); err == nil {
+		t.Error("New accepted an invalid pattern")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	w, err := New(rec, WithIgnorePatterns("*.o"), WithBatchDelay(time.Hour))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+	os.WriteFile(filepath.Join(tmpDir, "a.o"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(tmpDir, "a.md"), []byte("a"), 0o644)
+	time.Sleep(200 * time.Millisecond)
+
+	// The queued events are written with the new batch delay
+	if err := w.Reconfigure(WithIgnorePatterns("*.md"), WithBatchDelay(50*time.Millisecond)); err != nil {
+		t.Fatalf("Reconfigure failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "b.o"), []byte("b"), 0o644)
+	os.WriteFile(filepath.Join(tmpDir, "b.md"), []byte("b"), 0o644)
+	time.Sleep(300 * time.Millisecond)
+
+	types := eventTypes(w)
+	if len(types) != 2 || types["a.md"] != "new" || types["b.o"] != "new" {
+		t.Errorf("recorded %v, want a.md and b.o", types)
+	}
+
+	if err := w.Reconfigure(WithIgnorePatterns("[")); err == nil {
+		t.Error("Reconfigure accepted an invalid pattern")
+	}
+}
+
+func TestStopWritesDeferredEvents(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+	rec.SetDeferredWrites(time.Hour, 0)
+
+	w, _ := New(rec, WithBatchDelay(50*time.Millisecond))
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	onDisk := func() int {
+		t.Helper()
+		rf, err := recentfile.NewFromFile(rec.PrincipalRecentfile().Rfile())
+		if err != nil {
+			t.Fatalf("NewFromFile failed: %v", err)
+		}
+		return len(rf.RecentEvents())
+	}
+
+	// The first batch writes the file, the next is kept in memory
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0o644)
+	time.Sleep(300 * time.Millisecond)
+	os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("b"), 0o644)
+	time.Sleep(300 * time.Millisecond)
+	if n := onDisk(); n != 1 {
+		t.Errorf("%d events on disk before Stop, want 1", n)
+	}
+
+	if err := w.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if n := onDisk(); n != 2 {
+		t.Errorf("%d events on disk after Stop, want 2", n)
+	}
+}
+
+func TestFlushTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	rec, tmpDir := setupTestRecent(t)
+	w, _ := New(rec)
+	defer w.source.Close()
+
+	path := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(path, []byte("a"), 0o644)
+	w.batch = []recentfile.BatchItem{{Path: path, Type: "new"}, {Path: path, Type: "new"}}
+	w.flushBatch()
+
+	var flush, update sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "flushBatch":
+			flush = span
+		case "BatchUpdate":
+			update = span
+		}
+	}
+	if flush == nil || update == nil {
+		t.Fatalf("spans = %v", recorder.Ended())
+	}
+	if update.Parent().SpanID() != flush.SpanContext().SpanID() {
+		t.Error("BatchUpdate span is not a child of the flushBatch span")
+	}
+	want := []attribute.KeyValue{attribute.Int("events", 2), attribute.Int("deduplicated_events", 1)}
+	if got := flush.Attributes(); !slices.Equal(got, want) {
+		t.Errorf("flushBatch attributes = %v, want %v", got, want)
+	}
+}
+
+func TestQuietPeriod(t *testing.T) {
+	rec, tmpDir := setupTestRecent(t)
+
+	w, _ := New(rec, WithQuietPeriod(400*time.Millisecond), WithBatchDelay(50*time.Millisecond))
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	stopped := false
+	defer func() {
+		if !stopped {
+			w.Stop()
+		}
+	}()
+
+	types := func() map[string]string {
+		got := make(map[string]string)
+		for _, e := range rec.PrincipalRecentfile().RecentEvents() {
+			got[e.Path] = e.Type
+		}
+		return got
+	}
+
+	// Held while the file keeps changing
+	log := filepath.Join(tmpDir, "app.log")
+	for i := range 6 {
+		if err := os.WriteFile(log, []byte(strings.Repeat("x", i+1)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := types(); got["app.log"] != "" {
+		t.Fatalf("app.log recorded while still changing: %v", got)
+	}
+	if held := w.Stats().HeldEvents; held != 1 {
+		t.Errorf("HeldEvents = %d, want 1", held)
+	}
+
+	// Recorded once it settles
+	time.Sleep(600 * time.Millisecond)
+	if got := types(); got["app.log"] != "new" {
+		t.Fatalf("app.log not recorded after settling: %v", got)
+	}
+
+	// A delete is recorded at once, replacing the held event
+	gone := filepath.Join(tmpDir, "gone.txt")
+	os.WriteFile(gone, []byte("x"), 0o644)
+	time.Sleep(100 * time.Millisecond)
+	os.Remove(gone)
+	time.Sleep(200 * time.Millisecond)
+	if got := types(); got["gone.txt"] != "delete" {
+		t.Errorf("gone.txt = %q, want delete", got["gone.txt"])
+	}
+
+	// Held events are written on Stop
+	os.WriteFile(filepath.Join(tmpDir, "last.txt"), []byte("x"), 0o644)
+	time.Sleep(100 * time.Millisecond)
+	stopped = true
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if got := types(); got["last.txt"] != "new" {
+		t.Errorf("last.txt = %q after Stop, want new", got["last.txt"])
+	}
+}